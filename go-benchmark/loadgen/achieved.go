@@ -0,0 +1,233 @@
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"text/tabwriter"
+	"time"
+)
+
+// The traffic a run sends drifts from the one asked for: errors thin the
+// commands that fail, -retries sends some twice, and scheduling stretches
+// the pace of a replay. The achieved mix sets the commands attempted and
+// succeeded beside the requested shares, and the replay timing sets the
+// gaps between the commands sent beside those of the trace.
+
+// defaultMixTolerance is the -mix-tolerance default, in share of the
+// operations.
+const defaultMixTolerance = 0.02
+
+// mixSigmas is how many standard errors of the random draw of commands a
+// share must stray by, besides -mix-tolerance, to be flagged: a short run
+// drifts by chance alone.
+const mixSigmas = 3
+
+// validateMixTolerance checks -mix-tolerance.
+func (c *config) validateMixTolerance() error {
+	if c.mixTolerance < 0 || c.mixTolerance >= 1 || math.IsNaN(c.mixTolerance) {
+		return fmt.Errorf("-mix-tolerance must be in [0, 1), got %v", c.mixTolerance)
+	}
+	return nil
+}
+
+// requestedMix returns the commands the run was asked to send and their
+// shares: those of the trace under -replay, of the mix of a mixed
+// workload otherwise, nil for a single command.
+func (c *config) requestedMix() ([]opType, []float64) {
+	if t := c.trace; t != nil {
+		var counts [numOpTypes]int
+		for _, recs := range t.conns {
+			for _, r := range recs {
+				counts[r.op]++
+			}
+		}
+		var ops []opType
+		var shares []float64
+		for op := opType(0); op < numOpTypes; op++ {
+			if counts[op] > 0 {
+				ops = append(ops, op)
+				shares = append(shares, float64(counts[op])/float64(t.len()))
+			}
+		}
+		if len(ops) < 2 {
+			return nil, nil
+		}
+		return ops, shares
+	}
+	if !c.mixedCommands() || c.mix == nil || len(c.mix.ops) < 2 {
+		return nil, nil
+	}
+	shares := make([]float64, len(c.mix.ops))
+	for i, op := range c.mix.ops {
+		shares[i] = c.mix.share(op)
+	}
+	return c.mix.ops, shares
+}
+
+// achievedMix is the command mix a run sent. Attempted counts the
+// operations issued, failed or not, Succeeded those that did not fail and
+// Retries the commands -retries sent again; commands outside the requested
+// mix, such as the SCANs of -scan-clients, are left out.
+type achievedMix struct {
+	TolerancePercent float64        `json:"tolerance_percent"`
+	Attempted        int64          `json:"attempted"`
+	Succeeded        int64          `json:"succeeded"`
+	Retries          int64          `json:"retries,omitempty"`
+	Commands         []commandShare `json:"commands"`
+}
+
+// commandShare is one command of the achieved mix. Deviates is set when
+// its attempted or succeeded share strays from the requested one by more
+// than -mix-tolerance and more than chance explains.
+type commandShare struct {
+	Command          string  `json:"command"`
+	RequestedPercent float64 `json:"requested_percent"`
+	Attempted        int64   `json:"attempted"`
+	AttemptedPercent float64 `json:"attempted_percent"`
+	Succeeded        int64   `json:"succeeded"`
+	SucceededPercent float64 `json:"succeeded_percent"`
+	Retries          int64   `json:"retries,omitempty"`
+	Deviates         bool    `json:"deviates,omitempty"`
+}
+
+// buildAchievedMix compares the commands of total with the requested mix,
+// nil for a single command or a run that sent none.
+func buildAchievedMix(cfg *config, total *workerResult) *achievedMix {
+	ops, shares := cfg.requestedMix()
+	if ops == nil {
+		return nil
+	}
+	m := &achievedMix{TolerancePercent: 100 * cfg.mixTolerance}
+	for _, op := range ops {
+		s := &total.ops[op]
+		m.Attempted += s.attempts()
+		m.Succeeded += s.attempts() - s.errors
+		m.Retries += s.retries
+	}
+	if m.Attempted == 0 {
+		return nil
+	}
+	for i, op := range ops {
+		s := &total.ops[op]
+		c := commandShare{
+			Command:          op.String(),
+			RequestedPercent: 100 * shares[i],
+			Attempted:        s.attempts(),
+			Succeeded:        s.attempts() - s.errors,
+			Retries:          s.retries,
+		}
+		c.AttemptedPercent = 100 * float64(c.Attempted) / float64(m.Attempted)
+		c.Deviates = mixDeviates(shares[i], c.Attempted, m.Attempted, cfg.mixTolerance)
+		if m.Succeeded > 0 {
+			c.SucceededPercent = 100 * float64(c.Succeeded) / float64(m.Succeeded)
+			c.Deviates = c.Deviates || mixDeviates(shares[i], c.Succeeded, m.Succeeded, cfg.mixTolerance)
+		}
+		m.Commands = append(m.Commands, c)
+	}
+	return m
+}
+
+// mixDeviates reports whether n of total strays from the share want by
+// more than tolerance and more than mixSigmas standard errors of drawing
+// total commands at random.
+func mixDeviates(want float64, n, total int64, tolerance float64) bool {
+	got := float64(n) / float64(total)
+	chance := mixSigmas * math.Sqrt(want*(1-want)/float64(total))
+	return math.Abs(got-want) > max(tolerance, chance)
+}
+
+// printAchievedMix writes the achieved mix of the summary and a warning for
+// every command that strayed.
+func printAchievedMix(w io.Writer, m *achievedMix) {
+	fmt.Fprintf(w, "Achieved mix: %d operations attempted, %d succeeded", m.Attempted, m.Succeeded)
+	if m.Retries > 0 {
+		fmt.Fprintf(w, ", %d retries", m.Retries)
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "command\trequested\tattempted\tshare\tsucceeded\tshare\tretries\t")
+	for _, c := range m.Commands {
+		fmt.Fprintf(tw, "%s\t%.1f%%\t%d\t%.1f%%\t%d\t%.1f%%\t%d\t\n", c.Command, c.RequestedPercent,
+			c.Attempted, c.AttemptedPercent, c.Succeeded, c.SucceededPercent, c.Retries)
+	}
+	tw.Flush()
+	for _, c := range m.Commands {
+		if c.Deviates {
+			fmt.Fprintf(w, "WARNING: %s was %.1f%% of the operations attempted and %.1f%% of those succeeded, %.1f%% requested (-mix-tolerance %.1f%%)\n",
+				c.Command, c.AttemptedPercent, c.SucceededPercent, c.RequestedPercent, m.TolerancePercent)
+		}
+	}
+}
+
+// recordReplayTiming records the gap since the previous command of the
+// replay, sent at start, beside the gap the trace recorded between them,
+// and under -replay-timing original how late start was on the recorded
+// offset at. The commands of a pipeline count as sent together.
+func (w *worker) recordReplayTiming(at time.Duration, start time.Time) {
+	cfg := w.run.cfg
+	r := w.result
+	if r.replayGaps == nil {
+		r.replayGaps, r.replayRecorded = cfg.layout.New(), cfg.layout.New()
+	}
+	recorded := at - w.replayLastAt
+	if cfg.replayTiming == replayOriginal {
+		recorded = time.Duration(float64(recorded) / cfg.replaySpeed)
+		if r.replayLag == nil {
+			r.replayLag = cfg.layout.New()
+		}
+		due := w.run.replayStart.Add(time.Duration(float64(at) / cfg.replaySpeed))
+		r.replayLag.Record(start.Sub(due))
+	}
+	if !w.replayLast.IsZero() {
+		r.replayGaps.Record(start.Sub(w.replayLast))
+		r.replayRecorded.Record(recorded)
+	}
+	w.replayLast, w.replayLastAt = start, at
+}
+
+// replayTiming is how faithfully a replay kept the pace of its trace.
+// Recorded are the gaps between consecutive commands of each client in the
+// trace, scaled by -replay-speed under original timing, and Achieved those
+// it sent them with; Lag is how late each command went out on its recorded
+// offset, under original timing only.
+type replayTiming struct {
+	Timing   string          `json:"timing"`
+	Speed    float64         `json:"speed,omitempty"`
+	Recorded *latencySummary `json:"recorded_gaps,omitempty"`
+	Achieved *latencySummary `json:"achieved_gaps,omitempty"`
+	Lag      *latencySummary `json:"lag,omitempty"`
+}
+
+// buildReplayTiming summarizes the replay timing of total, nil outside a
+// replay.
+func buildReplayTiming(cfg *config, total *workerResult) *replayTiming {
+	if cfg.trace == nil || (total.replayGaps == nil && total.replayLag == nil) {
+		return nil
+	}
+	t := &replayTiming{Timing: cfg.replayTiming, Recorded: summarizeLatency(total.replayRecorded),
+		Achieved: summarizeLatency(total.replayGaps), Lag: summarizeLatency(total.replayLag)}
+	if cfg.replayTiming == replayOriginal {
+		t.Speed = cfg.replaySpeed
+	}
+	return t
+}
+
+// printReplayTiming writes the replay timing line of the summary.
+func printReplayTiming(w io.Writer, u latencyUnit, t *replayTiming) {
+	fmt.Fprint(w, "Replay timing:")
+	if a, r := t.Achieved, t.Recorded; a != nil && r != nil {
+		fmt.Fprintf(w, " gaps between commands p50 %s (recorded %s), p99 %s (recorded %s), mean %s (recorded %s)",
+			u.format(time.Duration(a.P50Ns)), u.format(time.Duration(r.P50Ns)),
+			u.format(time.Duration(a.P99Ns)), u.format(time.Duration(r.P99Ns)),
+			u.format(time.Duration(a.MeanNs)), u.format(time.Duration(r.MeanNs)))
+	}
+	if l := t.Lag; l != nil {
+		if t.Achieved != nil {
+			fmt.Fprint(w, ";")
+		}
+		fmt.Fprintf(w, " lag behind the recorded offsets p50 %s, p99 %s, max %s",
+			u.format(time.Duration(l.P50Ns)), u.format(time.Duration(l.P99Ns)), u.format(time.Duration(l.MaxNs)))
+	}
+	fmt.Fprintln(w)
+}
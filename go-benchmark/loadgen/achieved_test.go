@@ -0,0 +1,128 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"go-benchmark/stats"
+)
+
+// mixShare returns the command named name of m.
+func mixShare(t *testing.T, m *achievedMix, name string) commandShare {
+	t.Helper()
+	for _, c := range m.Commands {
+		if c.Command == name {
+			return c
+		}
+	}
+	t.Fatalf("achieved mix lacks %s: %+v", name, m.Commands)
+	return commandShare{}
+}
+
+// opsOf returns the stats of n operations of which failed failed.
+func opsOf(n, failed int64) opStats {
+	s := opStats{latency: stats.NewHistogram(), errors: failed}
+	for i := int64(0); i < n-failed; i++ {
+		s.latency.Record(time.Millisecond)
+	}
+	return s
+}
+
+func TestAchievedMixFlagsDrift(t *testing.T) {
+	cfg := testConfig(t, "-clients", "1", "-keyspace", "100", "-ratio", "get=0.5,set=0.5")
+	total := newWorkerResult(stats.DefaultLayout)
+	// Attempted as requested, but the SETs failed far more often.
+	total.ops[opGet] = opsOf(500, 0)
+	total.ops[opSet] = opsOf(500, 200)
+	total.ops[opSet].retries = 150
+
+	m := buildAchievedMix(cfg, total)
+	if m == nil || m.Attempted != 1000 || m.Succeeded != 800 || m.Retries != 150 || len(m.Commands) != 2 {
+		t.Fatalf("achieved mix %+v", m)
+	}
+	set := mixShare(t, m, "SET")
+	if set.AttemptedPercent != 50 || set.SucceededPercent != 37.5 || !set.Deviates {
+		t.Errorf("SET %+v", set)
+	}
+	var buf bytes.Buffer
+	printAchievedMix(&buf, m)
+	if !strings.Contains(buf.String(), "WARNING: SET was 50.0% of the operations attempted and 37.5% of those succeeded, 50.0% requested") {
+		t.Errorf("summary lacks the drift:\n%s", buf.String())
+	}
+
+	// Looser, or over few operations, the same drift is not flagged.
+	cfg = testConfig(t, "-clients", "1", "-keyspace", "100", "-ratio", "get=0.5,set=0.5", "-mix-tolerance", "0.2")
+	if m := buildAchievedMix(cfg, total); mixShare(t, m, "SET").Deviates {
+		t.Errorf("SET flagged under -mix-tolerance 0.2: %+v", m.Commands)
+	}
+	total.ops[opGet], total.ops[opSet] = opsOf(12, 0), opsOf(8, 0)
+	if m := buildAchievedMix(testConfig(t, "-clients", "1", "-keyspace", "100", "-ratio", "get=0.5,set=0.5"), total); mixShare(t, m, "GET").Deviates {
+		t.Errorf("12 GETs of 20 flagged: %+v", m.Commands)
+	}
+
+	if _, err := parseFlags([]string{"-mix-tolerance", "1"}); err == nil {
+		t.Error("-mix-tolerance 1 accepted")
+	}
+}
+
+func TestRunReportsAchievedMix(t *testing.T) {
+	_, rdb := newTestServer(t)
+	cfg := testConfig(t, "-clients", "2", "-ops", "100", "-keyspace", "100", "-ratio", "get=0.8,set=0.2")
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	m := buildAchievedMix(cfg, res.total)
+	if m == nil || m.Attempted != 200 || m.Commands[0].Attempted+m.Commands[1].Attempted != 200 {
+		t.Fatalf("achieved mix %+v", m)
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "Achieved mix: 200 operations attempted") {
+		t.Errorf("summary lacks the achieved mix:\n%s", buf.String())
+	}
+	if rep := buildReport(cfg, res); rep.AchievedMix == nil || mixShare(t, rep.AchievedMix, "GET").RequestedPercent != 80 {
+		t.Errorf("report achieved mix %+v", rep.AchievedMix)
+	}
+	// A single command has no mix to drift from.
+	if m := buildAchievedMix(testConfig(t, "-clients", "1"), res.total); m != nil {
+		t.Errorf("SET workload achieved mix %+v", m)
+	}
+}
+
+func TestReplayReportsItsTiming(t *testing.T) {
+	mr := miniredis.RunT(t)
+	path, _ := recordTrace(t, "-addr", mr.Addr(), "-clients", "2", "-ops", "10", "-rate", "100")
+
+	for _, timing := range []string{replayOriginal, replayFast} {
+		cfg := testConfig(t, "-addr", mr.Addr(), "-replay", path, "-replay-timing", timing)
+		rdb, _ := newClient(cfg)
+		res := runBenchmark(context.Background(), rdb, cfg)
+		rdb.Close()
+
+		rt := buildReplayTiming(cfg, res.total)
+		// Each client sent 10 commands, 20ms apart in the recording.
+		if rt == nil || rt.Achieved == nil || rt.Achieved.Count != 18 || rt.Recorded.Count != 18 {
+			t.Fatalf("%s: replay timing %+v", timing, rt)
+		}
+		if p50 := time.Duration(rt.Recorded.P50Ns); p50 < 10*time.Millisecond {
+			t.Errorf("%s: recorded gaps p50 %v", timing, p50)
+		}
+		achieved := time.Duration(rt.Achieved.P50Ns)
+		if timing == replayOriginal && (rt.Lag == nil || achieved < 10*time.Millisecond) {
+			t.Errorf("original: gaps p50 %v, lag %+v", achieved, rt.Lag)
+		}
+		if timing == replayFast && (rt.Lag != nil || achieved > 10*time.Millisecond) {
+			t.Errorf("fast: gaps p50 %v, lag %+v", achieved, rt.Lag)
+		}
+		var buf bytes.Buffer
+		printSummary(&buf, cfg, res)
+		if !strings.Contains(buf.String(), "Replay timing: gaps between commands") {
+			t.Errorf("%s: summary lacks the replay timing:\n%s", timing, buf.String())
+		}
+	}
+}
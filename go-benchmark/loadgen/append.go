@@ -0,0 +1,254 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+// workloadAppend grows log-style values with APPEND and reads slices of
+// them with GETRANGE, running -ratio or defaultAppendRatio.
+const workloadAppend = "append"
+
+// defaultAppendRatio is the command mix of -workload append without -ratio.
+const defaultAppendRatio = "append=0.5,getrange=0.5"
+
+// appendOps are the commands of the append workload, the only ones it runs.
+var appendOps = map[opType]bool{
+	opAppend:   true,
+	opGetRange: true,
+}
+
+// appendAlphabet is what chunks are made of.
+const appendAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// validateAppend checks the flags of the append workload.
+func (c *config) validateAppend() error {
+	for _, op := range c.mix.ops {
+		if !appendOps[op] {
+			return fmt.Errorf("-workload %s runs APPEND and GETRANGE, not %s", workloadAppend, op)
+		}
+	}
+	switch {
+	case c.appendChunkSize <= 0:
+		return fmt.Errorf("-append-chunk must be positive, got %d", c.appendChunkSize)
+	case c.appendMax < c.appendChunkSize:
+		return fmt.Errorf("-append-max must be at least -append-chunk %d, got %d", c.appendChunkSize, c.appendMax)
+	case c.appendVerify < 0 || c.appendVerify > 1:
+		return fmt.Errorf("-append-verify must be between 0 and 1, got %v", c.appendVerify)
+	case c.hotKeys > 0:
+		return errors.New("-hot-keys does not apply to the append workload")
+	}
+	return nil
+}
+
+// appendByte returns the byte at offset i of every chunk appended to the
+// key hashed to h. A value is the chunk of its key repeated, whoever
+// appended it, so any slice of it can be checked.
+func appendByte(h uint64, i int) byte {
+	return appendAlphabet[mix64(h+uint64(i))%uint64(len(appendAlphabet))]
+}
+
+// appendChunk returns the chunk every APPEND to key adds.
+func (c *config) appendChunk(key string) []byte {
+	h := hashKey(key)
+	chunk := make([]byte, c.appendChunkSize)
+	for i := range chunk {
+		chunk[i] = appendByte(h, i)
+	}
+	return chunk
+}
+
+// hashKey is the FNV-1a hash of key.
+func hashKey(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// issueAppend appends the chunk of key, or reads a chunk's length of it
+// from a random offset below -append-max.
+func (w *worker) issueAppend(ctx context.Context, c redis.Cmdable, op opType, key string) pendingOp {
+	cfg := w.run.cfg
+	if op == opAppend {
+		chunk := cfg.appendChunk(key)
+		return pendingOp{op: op, cmd: c.Append(ctx, key, string(chunk)), bytes: len(chunk), key: key}
+	}
+	start := w.rng.Intn(cfg.appendMax)
+	end := start + cfg.appendChunkSize - 1
+	return pendingOp{op: op, cmd: c.GetRange(ctx, key, int64(start), int64(end)), key: key,
+		check: w.rng.Float64() < cfg.appendVerify, offset: start}
+}
+
+// afterAppend buckets the latency of an APPEND by the length it left the
+// value at and, once that reaches -append-max, has the worker delete the
+// key with its next operation so the value restarts from empty. Other
+// clients may append to the key before that DEL lands, each of them at most
+// a chunk per APPEND in flight, as each then deletes the key in turn: a
+// value overshoots the limit by that much, and those APPENDs are bucketed
+// apart.
+func (w *worker) afterAppend(p pendingOp, length int64, latency time.Duration) {
+	cfg := w.run.cfg
+	w.result.appendStats(cfg).record(cfg, length, latency)
+	if length >= int64(cfg.appendMax) {
+		w.appendFull = p.key
+	}
+}
+
+// checkGetRange verifies a sampled GETRANGE reply against the chunk of its
+// key: every byte must match, and a reply cut short by the end of the value
+// must end on a chunk boundary, as values only grow by whole chunks.
+func (w *worker) checkGetRange(p pendingOp, reply string) {
+	cfg := w.run.cfg
+	s := w.result.appendStats(cfg)
+	s.checked++
+	h := hashKey(p.key)
+	for i := 0; i < len(reply); i++ {
+		if reply[i] != appendByte(h, (p.offset+i)%cfg.appendChunkSize) {
+			s.mismatched++
+			return
+		}
+	}
+	if len(reply) > 0 && len(reply) < cfg.appendChunkSize && (p.offset+len(reply))%cfg.appendChunkSize != 0 {
+		s.torn++
+	}
+}
+
+// appendStats are the APPEND latencies by value length, bucket b holding
+// the APPENDs that left the value at up to -append-chunk << b bytes and
+// overMax those that left it past -append-max, and the outcome of the
+// sampled GETRANGE checks.
+type appendStats struct {
+	byLength []*stats.Histogram
+	overMax  *stats.Histogram
+	// checked counts the GETRANGE replies sampled, of which mismatched
+	// held bytes of no chunk and torn ended inside a chunk.
+	checked    int64
+	mismatched int64
+	torn       int64
+}
+
+// appendBucket returns the bucket of a value of length bytes.
+func appendBucket(cfg *config, length int64) int {
+	if length <= 0 {
+		return 0
+	}
+	return bits.Len64(uint64((length - 1) / int64(cfg.appendChunkSize)))
+}
+
+func (s *appendStats) record(cfg *config, length int64, latency time.Duration) {
+	h := &s.overMax
+	if length <= int64(cfg.appendMax) {
+		h = &s.byLength[appendBucket(cfg, length)]
+	}
+	if *h == nil {
+		*h = cfg.layout.New()
+	}
+	(*h).Record(latency)
+}
+
+func (s *appendStats) merge(o *appendStats) {
+	for i, h := range o.byLength {
+		s.byLength[i] = mergeHistogram(s.byLength[i], h)
+	}
+	s.overMax = mergeHistogram(s.overMax, o.overMax)
+	s.checked += o.checked
+	s.mismatched += o.mismatched
+	s.torn += o.torn
+}
+
+// appendStats returns the append statistics of r, allocating them on first
+// use with a bucket for every length up to -append-max.
+func (r *workerResult) appendStats(cfg *config) *appendStats {
+	if r.appends == nil {
+		r.appends = &appendStats{byLength: make([]*stats.Histogram, appendBucket(cfg, int64(cfg.appendMax))+1)}
+	}
+	return r.appends
+}
+
+// jsonAppend describes the append workload: APPEND latency by the length
+// of the value appended to, OverMax that of the APPENDs other clients made
+// to a full value before its DEL, the resets of full values and the
+// GETRANGE checks.
+type jsonAppend struct {
+	ChunkSize  int                `json:"chunk_size"`
+	MaxLength  int                `json:"max_length"`
+	Resets     int64              `json:"resets"`
+	ByLength   []jsonAppendBucket `json:"by_length"`
+	OverMax    *latencySummary    `json:"over_max,omitempty"`
+	Checked    int64              `json:"getrange_checked"`
+	Mismatched int64              `json:"getrange_mismatched"`
+	Torn       int64              `json:"getrange_torn"`
+}
+
+type jsonAppendBucket struct {
+	UpToBytes int64           `json:"up_to_bytes"`
+	Latency   *latencySummary `json:"latency"`
+}
+
+func buildAppend(cfg *config, total *workerResult) *jsonAppend {
+	s := total.appendStats(cfg)
+	j := &jsonAppend{
+		ChunkSize:  cfg.appendChunkSize,
+		MaxLength:  cfg.appendMax,
+		Resets:     total.ops[opDel].attempts(),
+		Checked:    s.checked,
+		Mismatched: s.mismatched,
+		Torn:       s.torn,
+	}
+	for b, h := range s.byLength {
+		if h != nil {
+			j.ByLength = append(j.ByLength, jsonAppendBucket{UpToBytes: int64(cfg.appendChunkSize) << b, Latency: summarizeLatency(h)})
+		}
+	}
+	if s.overMax != nil {
+		j.OverMax = summarizeLatency(s.overMax)
+	}
+	return j
+}
+
+// failed reports whether a sampled GETRANGE reply was corrupt.
+func (s *appendStats) failed() bool {
+	return s != nil && s.mismatched+s.torn > 0
+}
+
+// printAppend writes the append section of the summary.
+func printAppend(w io.Writer, cfg *config, total *workerResult) {
+	j := buildAppend(cfg, total)
+	fmt.Fprintf(w, "APPEND: %d-byte chunks up to %d bytes, %d values reset with DEL\n", j.ChunkSize, j.MaxLength, j.Resets)
+	if len(j.ByLength) > 0 || j.OverMax != nil {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "value length\tops\tmean\tp50\tp99\tmax\t")
+		row := func(length string, l *latencySummary) {
+			fmt.Fprintf(tw, "%s\t%d\t%v\t%v\t%v\t%v\t\n", length, l.Count,
+				time.Duration(l.MeanNs), time.Duration(l.P50Ns), time.Duration(l.P99Ns), time.Duration(l.MaxNs))
+		}
+		for _, b := range j.ByLength {
+			row(fmt.Sprintf("<= %d", b.UpToBytes), b.Latency)
+		}
+		if j.OverMax != nil {
+			row(fmt.Sprintf("> %d", j.MaxLength), j.OverMax)
+		}
+		tw.Flush()
+	}
+	switch {
+	case j.Checked == 0:
+		fmt.Fprintln(w, "GETRANGE replies: none sampled")
+	case j.Mismatched+j.Torn > 0:
+		fmt.Fprintf(w, "CORRUPTION: of %d sampled GETRANGE replies, %d did not match the chunks appended and %d ended inside a chunk\n",
+			j.Checked, j.Mismatched, j.Torn)
+	default:
+		fmt.Fprintf(w, "GETRANGE replies: %d sampled, all matched the chunks appended\n", j.Checked)
+	}
+}
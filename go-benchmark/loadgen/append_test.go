@@ -0,0 +1,117 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+func TestAppendWorkload(t *testing.T) {
+	mr, _ := newTestServer(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-workload", "append", "-clients", "3", "-ops", "200", "-preload", "4",
+		"-append-chunk", "16", "-append-max", "128", "-append-verify", "1")
+	// runTarget preloads a chunk into every key.
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := buildReport(cfg, res)
+	a := rep.Append
+	if a == nil || a.Resets == 0 || a.Checked == 0 || a.Mismatched != 0 || a.Torn != 0 {
+		t.Fatalf("append %+v", a)
+	}
+	if n := len(a.ByLength); n == 0 || a.ByLength[n-1].UpToBytes != 128 {
+		t.Errorf("buckets %+v", a.ByLength)
+	}
+	for _, name := range []string{"APPEND", "GETRANGE", "DEL"} {
+		if rep.Commands[name].Ops == 0 {
+			t.Errorf("no %s in %+v", name, rep.Commands)
+		}
+	}
+	// Every value is whole chunks of its key. The other two clients may
+	// each append a chunk to a full value before it is deleted.
+	for i := 0; i < 4; i++ {
+		key := cfg.keyName(i)
+		v, err := mr.Get(key)
+		if err != nil {
+			continue
+		}
+		chunk := string(cfg.appendChunk(key))
+		if len(v) > 128+2*16 || v != strings.Repeat(chunk, len(v)/16) {
+			t.Errorf("%s holds %q", key, v)
+		}
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "APPEND: 16-byte chunks up to 128 bytes") || !strings.Contains(buf.String(), "all matched the chunks appended") {
+		t.Errorf("summary lacks the append section:\n%s", buf.String())
+	}
+	if res.verifyFailed() {
+		t.Error("a clean run failed verification")
+	}
+}
+
+func TestCheckGetRange(t *testing.T) {
+	cfg := testConfig(t, "-workload", "append", "-append-chunk", "8", "-preload", "1")
+	w := &worker{run: &runState{cfg: cfg}, result: newWorkerResult(stats.DefaultLayout)}
+	chunk := string(cfg.appendChunk("k"))
+	for _, tc := range []struct {
+		offset int
+		reply  string
+	}{
+		{0, chunk},
+		{5, (chunk + chunk)[5:13]},
+		// The value ends at a chunk boundary.
+		{13, chunk[5:]},
+		{3, ""},
+	} {
+		w.checkGetRange(pendingOp{key: "k", offset: tc.offset}, tc.reply)
+	}
+	s := w.result.appends
+	if s.checked != 4 || s.failed() {
+		t.Fatalf("clean replies: %+v", s)
+	}
+	w.checkGetRange(pendingOp{key: "k", offset: 0}, chunk[:3])
+	w.checkGetRange(pendingOp{key: "k", offset: 0}, strings.Repeat("x", 8))
+	if s.torn != 1 || s.mismatched != 1 || !s.failed() {
+		t.Errorf("corrupt replies: %+v", s)
+	}
+
+	for _, args := range [][]string{
+		{"-workload", "append", "-ratio", "append=0.5,get=0.5"},
+		{"-workload", "append", "-append-chunk", "0"},
+		{"-workload", "append", "-append-max", "10", "-append-chunk", "64"},
+		{"-ratio", "append=0.5,set=0.5"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
+
+func TestAppendOverMax(t *testing.T) {
+	cfg := testConfig(t, "-workload", "append", "-append-chunk", "16", "-append-max", "128", "-preload", "1")
+	w := &worker{run: &runState{cfg: cfg}, result: newWorkerResult(stats.DefaultLayout)}
+	w.afterAppend(pendingOp{key: "k"}, 128, time.Millisecond)
+	// Another client appended before the DEL of the first landed.
+	if w.appendFull != "k" {
+		t.Fatal("a full value was not deleted")
+	}
+	w.afterAppend(pendingOp{key: "k"}, 144, 2*time.Millisecond)
+	j := buildAppend(cfg, w.result)
+	if n := len(j.ByLength); n != 1 || j.ByLength[n-1].UpToBytes != 128 || j.ByLength[0].Latency.Count != 1 {
+		t.Errorf("buckets %+v", j.ByLength)
+	}
+	if j.OverMax == nil || j.OverMax.Count != 1 {
+		t.Errorf("over max %+v", j.OverMax)
+	}
+	var buf bytes.Buffer
+	printAppend(&buf, cfg, w.result)
+	if !strings.Contains(buf.String(), "> 128") {
+		t.Errorf("summary:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,194 @@
+package loadgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// exitRegression is the exit status of a run that regressed against
+// -compare-baseline by more than -fail-threshold.
+const exitRegression = 3
+
+// saveBaseline writes rep to path for later use with -compare-baseline.
+func saveBaseline(path string, rep *Report) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create baseline: %w", err)
+	}
+	if err := writeJSON(f, rep); err != nil {
+		f.Close()
+		return fmt.Errorf("write baseline: %w", err)
+	}
+	return f.Close()
+}
+
+// loadBaseline reads a report written by -save-baseline or -output json.
+func loadBaseline(path string) (*Report, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read baseline: %w", err)
+	}
+	var rep Report
+	if err := json.Unmarshal(data, &rep); err != nil {
+		return nil, fmt.Errorf("parse baseline %s: %w", path, err)
+	}
+	return &rep, nil
+}
+
+// parseThreshold parses a regression threshold such as "10%" or "10" into
+// a fraction.
+func parseThreshold(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid threshold %q: want a non-negative percentage such as 10%%", s)
+	}
+	return v / 100, nil
+}
+
+// workloadSettings are the settings defining the workload of a run, which
+// compareBaseline and the diff subcommand compare. Where the runs went
+// (address, databases, key prefix), their seed and the layout of their
+// report are not part of the workload and may differ.
+var workloadSettings = []struct {
+	name string
+	of   func(r *Report) any
+}{
+	{"clients", func(r *Report) any { return r.Config.Clients }},
+	{"ops_per_client", func(r *Report) any { return r.Config.OpsPerClient }},
+	{"duration", func(r *Report) any { return r.Config.Duration }},
+	{"workload", func(r *Report) any { return r.Config.Workload }},
+	{"pipeline", func(r *Report) any { return r.Config.Pipeline }},
+	{"ratio", func(r *Report) any { return r.Config.Ratio }},
+	{"preload", func(r *Report) any { return r.Config.Preload }},
+	{"keyspace", func(r *Report) any { return r.Config.Keyspace }},
+	{"key_dist", func(r *Report) any { return r.Config.KeyDist }},
+	{"value_size_min", func(r *Report) any { return r.Config.ValueSizeMin }},
+	{"value_size_max", func(r *Report) any { return r.Config.ValueSizeMax }},
+	{"ttl", func(r *Report) any { return r.Config.TTL }},
+	{"hot_keys", func(r *Report) any { return r.Config.HotKeys }},
+	{"hot_fraction", func(r *Report) any { return r.Config.HotFraction }},
+	{"requested_rate", func(r *Report) any { return r.RequestedRate }},
+	{"rate_scope", func(r *Report) any { return r.RateScope }},
+	{"loop", func(r *Report) any {
+		if r.OpenLoop != nil {
+			return loopOpen
+		}
+		return loopClosed
+	}},
+	{"think_time", func(r *Report) any {
+		if r.ThinkTime == nil {
+			return ""
+		}
+		return r.ThinkTime.Spec
+	}},
+	{"frontend", func(r *Report) any { return r.Config.Frontend }},
+	{"client", func(r *Report) any { return r.Config.Client }},
+	{"protocol", func(r *Report) any { return r.Config.Protocol }},
+	{"transport", func(r *Report) any { return r.Config.Transport }},
+	{"tls", func(r *Report) any { return r.Config.TLS }},
+	{"cluster", func(r *Report) any { return r.Config.Cluster }},
+	{"churn", func(r *Report) any { return r.Config.Churn }},
+	{"resilience", func(r *Report) any { return r.Config.Resilience }},
+	{"op_timeout", func(r *Report) any { return r.Config.OpTimeout }},
+	{"key_size", func(r *Report) any { return r.Config.KeySize }},
+	{"raw_values", func(r *Report) any { return r.Config.RawValues }},
+	{"key_template", func(r *Report) any { return r.Config.KeyTemplate }},
+	{"value_template", func(r *Report) any { return r.Config.ValueTemplate }},
+	{"counter_keys", func(r *Report) any { return r.Config.CounterKeys }},
+	{"hash_fields", func(r *Report) any { return r.Config.HashFields }},
+	{"zset_members", func(r *Report) any { return r.Config.ZSetMembers }},
+	{"zset_range", func(r *Report) any { return r.Config.ZSetRange }},
+	{"set_members", func(r *Report) any { return r.Config.SetMembers }},
+	{"batch_keys", func(r *Report) any { return r.Config.BatchKeys }},
+	{"txn_retries", func(r *Report) any { return r.Config.TxnRetries }},
+}
+
+// configMismatches lists the workloadSettings that differ between two runs.
+func configMismatches(base, cur *Report) []string {
+	var diffs []string
+	for _, s := range workloadSettings {
+		if b, c := s.of(base), s.of(cur); b != c {
+			diffs = append(diffs, fmt.Sprintf("%s: baseline %v, this run %v", s.name, b, c))
+		}
+	}
+	return diffs
+}
+
+// baselineMetric is one compared measurement.
+type baselineMetric struct {
+	name      string
+	base, cur float64
+	unit      string
+	// higherIsBetter is true for throughput and false for latency.
+	higherIsBetter bool
+}
+
+// regression returns how much worse cur is than base, as a fraction; it is
+// negative when the run improved.
+func (m baselineMetric) regression() float64 {
+	if m.base == 0 {
+		return 0
+	}
+	if m.higherIsBetter {
+		return (m.base - m.cur) / m.base
+	}
+	return (m.cur - m.base) / m.base
+}
+
+// baselineComparison is the result of checking a run against a baseline.
+type baselineComparison struct {
+	threshold float64
+	metrics   []baselineMetric
+	// envDiffs lists how the environment of the run differs from the
+	// baseline's, which the numbers may owe to.
+	envDiffs []string
+}
+
+// regressed reports whether any metric got worse by more than the threshold.
+func (c *baselineComparison) regressed() bool {
+	for _, m := range c.metrics {
+		if m.regression() > c.threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// compareBaseline checks throughput and p99 latency of cur against base. It
+// refuses runs whose workloads differ, since their numbers are not
+// comparable.
+func compareBaseline(base, cur *Report, threshold float64) (*baselineComparison, error) {
+	if diffs := configMismatches(base, cur); len(diffs) > 0 {
+		return nil, fmt.Errorf("baseline was run with a different workload:\n  %s", strings.Join(diffs, "\n  "))
+	}
+	if base.Latency == nil || cur.Latency == nil {
+		return nil, fmt.Errorf("baseline comparison needs completed operations in both runs")
+	}
+	return &baselineComparison{
+		threshold: threshold,
+		envDiffs:  environmentMismatches(base.Environment, cur.Environment),
+		metrics: []baselineMetric{
+			{name: "throughput", base: base.Throughput, cur: cur.Throughput, unit: "ops/s", higherIsBetter: true},
+			{name: "p99 latency", base: float64(base.Latency.P99Ns) / 1e3, cur: float64(cur.Latency.P99Ns) / 1e3, unit: "µs"},
+		},
+	}, nil
+}
+
+// printBaselineComparison writes the human-readable diff against the baseline.
+func printBaselineComparison(w io.Writer, c *baselineComparison) {
+	fmt.Fprintf(w, "Baseline comparison (fail threshold %.1f%%):\n", 100*c.threshold)
+	for _, d := range c.envDiffs {
+		fmt.Fprintf(w, "  WARNING: environment differs from the baseline's: %s\n", d)
+	}
+	for _, m := range c.metrics {
+		verdict := "ok"
+		if r := m.regression(); r > c.threshold {
+			verdict = fmt.Sprintf("REGRESSION (%.1f%% worse)", 100*r)
+		}
+		fmt.Fprintf(w, "  %s: %.0f -> %.0f %s: %s\n", m.name, m.base, m.cur, m.unit, verdict)
+	}
+}
@@ -0,0 +1,134 @@
+package loadgen
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// syntheticReport returns a minimal result document for baseline tests.
+func syntheticReport(throughput float64, p99Ns int64) *Report {
+	return &Report{
+		Config:     jsonConfig{Addr: "a:6379", Clients: 50, OpsPerClient: 1000, Workload: "get", Keyspace: 1000, ValueSizeMin: 100, ValueSizeMax: 100, KeyPrefix: "run1:"},
+		Throughput: throughput,
+		Latency:    &latencySummary{Count: 50000, P99Ns: p99Ns},
+	}
+}
+
+func TestCompareBaseline(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		throughput float64
+		p99Ns      int64
+		regressed  bool
+	}{
+		{"unchanged", 10000, 1000000, false},
+		{"faster", 12000, 800000, false},
+		{"within threshold", 9500, 1050000, false},
+		{"throughput drop", 8500, 1000000, true},
+		{"p99 increase", 10000, 1200000, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmp, err := compareBaseline(syntheticReport(10000, 1000000), syntheticReport(tc.throughput, tc.p99Ns), 0.10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cmp.regressed(); got != tc.regressed {
+				t.Errorf("regressed = %v, want %v", got, tc.regressed)
+			}
+		})
+	}
+}
+
+func TestCompareBaselineRefusesDifferentWorkload(t *testing.T) {
+	base := syntheticReport(10000, 1000000)
+	cur := syntheticReport(10000, 1000000)
+	cur.Config.Clients = 100
+	cur.Config.ValueSizeMax = 200
+	// The target and namespace are expected to change between runs.
+	cur.Config.Addr = "b:6379"
+	cur.Config.KeyPrefix = "run2:"
+
+	_, err := compareBaseline(base, cur, 0.10)
+
+	if err == nil {
+		t.Fatal("compared runs with different workloads")
+	}
+	for _, field := range []string{"clients", "value_size_max"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error %q does not name %s", err, field)
+		}
+	}
+	if strings.Contains(err.Error(), "addr") || strings.Contains(err.Error(), "key_prefix") {
+		t.Errorf("error %q names a setting that may differ", err)
+	}
+}
+
+func TestCompareBaselineRefusesEachSetting(t *testing.T) {
+	for _, tc := range []struct {
+		field  string
+		change func(r *Report)
+	}{
+		{"requested_rate", func(r *Report) { r.RequestedRate = 5000 }},
+		{"rate_scope", func(r *Report) { r.RateScope = rateClient }},
+		{"loop", func(r *Report) { r.OpenLoop = &jsonOpenLoop{} }},
+		{"think_time", func(r *Report) { r.ThinkTime = &jsonThinkTime{Spec: "exp:1ms"} }},
+		{"frontend", func(r *Report) { r.Config.Frontend = protocolMemcache }},
+		{"client", func(r *Report) { r.Config.Client = clientRaw }},
+		{"protocol", func(r *Report) { r.Config.Protocol = 3 }},
+		{"transport", func(r *Report) { r.Config.Transport = "unix" }},
+		{"tls", func(r *Report) { r.Config.TLS = true }},
+		{"cluster", func(r *Report) { r.Config.Cluster = true }},
+		{"churn", func(r *Report) { r.Config.Churn = true }},
+		{"resilience", func(r *Report) { r.Config.Resilience = true }},
+		{"op_timeout", func(r *Report) { r.Config.OpTimeout = "10ms" }},
+		{"key_size", func(r *Report) { r.Config.KeySize = 64 }},
+		{"raw_values", func(r *Report) { r.Config.RawValues = true }},
+		{"key_template", func(r *Report) { r.Config.KeyTemplate = "user:{seq}" }},
+		{"value_template", func(r *Report) { r.Config.ValueTemplate = "{pad:100}" }},
+		{"counter_keys", func(r *Report) { r.Config.CounterKeys = 10 }},
+		{"hash_fields", func(r *Report) { r.Config.HashFields = 5 }},
+		{"zset_members", func(r *Report) { r.Config.ZSetMembers = 50 }},
+		{"zset_range", func(r *Report) { r.Config.ZSetRange = 5 }},
+		{"set_members", func(r *Report) { r.Config.SetMembers = 50 }},
+		{"batch_keys", func(r *Report) { r.Config.BatchKeys = 20 }},
+		{"txn_retries", func(r *Report) { r.Config.TxnRetries = 3 }},
+	} {
+		t.Run(tc.field, func(t *testing.T) {
+			cur := syntheticReport(10000, 1000000)
+			tc.change(cur)
+			_, err := compareBaseline(syntheticReport(10000, 1000000), cur, 0.10)
+			if err == nil || !strings.Contains(err.Error(), tc.field+":") {
+				t.Errorf("compared runs differing in %s: %v", tc.field, err)
+			}
+		})
+	}
+}
+
+func TestBaselineFileRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	if err := saveBaseline(path, syntheticReport(10000, 1000000)); err != nil {
+		t.Fatal(err)
+	}
+	base, err := loadBaseline(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmp, err := compareBaseline(base, syntheticReport(10000, 1000000), 0.10)
+	if err != nil || cmp.regressed() {
+		t.Errorf("round-tripped baseline does not match itself: %v", err)
+	}
+}
+
+func TestParseThreshold(t *testing.T) {
+	for in, want := range map[string]float64{"10%": 0.10, "5": 0.05, " 2.5% ": 0.025} {
+		if got, err := parseThreshold(in); err != nil || got != want {
+			t.Errorf("parseThreshold(%q) = %v, %v, want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "ten", "-5%"} {
+		if _, err := parseThreshold(in); err == nil {
+			t.Errorf("parseThreshold(%q) accepted", in)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+// validateChurn checks the flags of -churn, which only times single SET and
+// GET commands, each on a connection of its own.
+func (c *config) validateChurn() error {
+	if c.pipeline > 1 {
+		return errors.New("-pipeline does not apply to -churn, which sends one command per connection")
+	}
+	if c.workload == workloadSet {
+		return nil
+	}
+	if c.mix == nil || c.workload == workloadScan {
+		return fmt.Errorf("-churn does not apply to -workload %s", c.workload)
+	}
+	for op := opType(0); op < numOpTypes; op++ {
+		if c.mix.share(op) > 0 && op != opSet && op != opGet {
+			return fmt.Errorf("-churn only runs SET and GET, not %s", op)
+		}
+	}
+	return nil
+}
+
+// churnStats describes the connections opened by -churn.
+type churnStats struct {
+	dials int64
+	// connect is the latency of the successful dials, and command that of
+	// the first command on the new connection, AUTH and SELECT included.
+	connect *stats.Histogram
+	command *stats.Histogram
+	// handshake is the TLS handshake part of connect; nil without -tls.
+	handshake *stats.Histogram
+	// dialErrors counts the failed dials by cause.
+	dialErrors [numErrClasses]int64
+	// peak is the most connections the run had open at once, dialing
+	// included; it is set on the merged result only.
+	peak int64
+}
+
+func (s *churnStats) merge(o *churnStats) {
+	s.dials += o.dials
+	s.connect = mergeHistogram(s.connect, o.connect)
+	s.command = mergeHistogram(s.command, o.command)
+	s.handshake = mergeHistogram(s.handshake, o.handshake)
+	for c, n := range o.dialErrors {
+		s.dialErrors[c] += n
+	}
+}
+
+// churnStats returns the -churn statistics of r, allocating them on first
+// use.
+func (r *workerResult) churnStats() *churnStats {
+	if r.churn == nil {
+		r.churn = &churnStats{connect: r.layout.New(), command: r.layout.New()}
+	}
+	return r.churn
+}
+
+// connOpened counts a connection being dialed and tracks the peak number
+// open at once.
+func (st *runState) connOpened() {
+	n := st.churnOpen.Add(1)
+	for {
+		peak := st.churnPeak.Load()
+		if n <= peak || st.churnPeak.CompareAndSwap(peak, n) {
+			return
+		}
+	}
+}
+
+// issueChurn sends op on a connection dialed for it alone and closes it
+// once the reply is in. go-redis retries are disabled so every operation
+// is exactly one dial.
+func (w *worker) issueChurn(ctx context.Context, op opType) pendingOp {
+	cfg := w.run.cfg
+	var (
+		dialed             bool
+		connect, handshake time.Duration
+		dialErr            error
+	)
+	var d net.Dialer
+	rdb := redis.NewClient(&redis.Options{
+		Network:    cfg.network(),
+		Addr:       cfg.dialAddr(),
+		Password:   cfg.password,
+		DB:         cfg.db,
+		PoolSize:   1,
+		MaxRetries: -1,
+		Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			w.run.connOpened()
+			dialed = true
+			start := time.Now()
+			conn, err := d.DialContext(ctx, network, addr)
+			if err == nil && cfg.tlsConfig != nil {
+				// go-redis only handshakes in its default dialer.
+				established := time.Now()
+				conn, err = tlsHandshake(ctx, conn, cfg.tlsConfig, addr)
+				handshake = time.Since(established)
+			}
+			connect, dialErr = time.Since(start), err
+			return conn, err
+		},
+	})
+	start := time.Now()
+	p := w.issue(ctx, rdb, op)
+	total := time.Since(start)
+	rdb.Close()
+	if !dialed {
+		return p
+	}
+	w.run.churnOpen.Add(-1)
+	s := w.result.churnStats()
+	s.dials++
+	if dialErr != nil {
+		s.dialErrors[classifyError(dialErr)]++
+		return p
+	}
+	s.connect.Record(connect)
+	s.command.Record(total - connect)
+	if cfg.tlsConfig != nil {
+		if s.handshake == nil {
+			s.handshake = cfg.layout.New()
+		}
+		s.handshake.Record(handshake)
+	}
+	return p
+}
+
+// jsonChurn is the -churn section of the JSON report.
+type jsonChurn struct {
+	Dials int64 `json:"dials"`
+	// DialErrors counts the failed dials by cause; client file descriptor
+	// exhaustion is kept apart from refusals by the server.
+	DialErrors    map[string]int64 `json:"dial_errors,omitempty"`
+	DialErrorRate float64          `json:"dial_error_rate"`
+	// PeakConnections is the most connections open at once, dials in
+	// progress and connections not yet closed included.
+	PeakConnections int64           `json:"peak_connections"`
+	Connect         *latencySummary `json:"connect_latency"`
+	FirstCommand    *latencySummary `json:"first_command_latency"`
+	// TLSHandshake is the handshake part of Connect and
+	// TLSHandshakeShare its share of the total connect time.
+	TLSHandshake      *latencySummary `json:"tls_handshake_latency,omitempty"`
+	TLSHandshakeShare float64         `json:"tls_handshake_share,omitempty"`
+}
+
+// buildChurn summarises the connections of a -churn run, nil without it.
+func buildChurn(total *workerResult) *jsonChurn {
+	s := total.churn
+	if s == nil {
+		return nil
+	}
+	rep := &jsonChurn{
+		Dials:           s.dials,
+		PeakConnections: s.peak,
+		Connect:         summarizeLatency(s.connect),
+		FirstCommand:    summarizeLatency(s.command),
+		TLSHandshake:    summarizeLatency(s.handshake),
+	}
+	if s.handshake != nil && s.connect.Cumulative() > 0 {
+		rep.TLSHandshakeShare = float64(s.handshake.Cumulative()) / float64(s.connect.Cumulative())
+	}
+	var failed int64
+	for c := errClass(0); c < numErrClasses; c++ {
+		if n := s.dialErrors[c]; n > 0 {
+			if rep.DialErrors == nil {
+				rep.DialErrors = make(map[string]int64)
+			}
+			rep.DialErrors[c.String()] = n
+			failed += n
+		}
+	}
+	if s.dials > 0 {
+		rep.DialErrorRate = float64(failed) / float64(s.dials)
+	}
+	return rep
+}
+
+// printChurn writes the -churn section of the summary.
+func printChurn(w io.Writer, u latencyUnit, total *workerResult) {
+	rep := buildChurn(total)
+	if rep == nil {
+		return
+	}
+	fmt.Fprintf(w, "Connection churn: %d dials, peak %d connections open at once, %.2f%% of dials failed\n",
+		rep.Dials, rep.PeakConnections, 100*rep.DialErrorRate)
+	if n := total.churn.dialErrors[errFDExhausted]; n > 0 {
+		fmt.Fprintf(w, "WARNING: %d dials failed because the client ran out of file descriptors (raise ulimit -n); these are not server refusals\n", n)
+	}
+	for c := errClass(0); c < numErrClasses; c++ {
+		if n := total.churn.dialErrors[c]; n > 0 && c != errFDExhausted {
+			fmt.Fprintf(w, "  dial %s: %d\n", c, n)
+		}
+	}
+	printLatency(w, u, "Connect", total.churn.connect)
+	if total.churn.handshake != nil {
+		printLatency(w, u, "TLS handshake", total.churn.handshake)
+		fmt.Fprintf(w, "TLS handshake share of connect time: %.1f%%\n", 100*rep.TLSHandshakeShare)
+	}
+	printLatency(w, u, "First command", total.churn.command)
+}
@@ -0,0 +1,71 @@
+package loadgen
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestChurnDialsPerOperation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-churn", "-ratio", "get=0.5,set=0.5", "-preload", "10", "-keyspace", "10",
+		"-clients", "4", "-ops", "25", "-key-prefix", "ch:")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := buildChurn(res.total)
+	if c == nil {
+		t.Fatal("no churn report")
+	}
+	if c.Dials != 100 || c.Connect.Count != 100 || c.FirstCommand.Count != 100 {
+		t.Errorf("%d dials, %d connect and %d first-command samples, want 100 each", c.Dials, c.Connect.Count, c.FirstCommand.Count)
+	}
+	if c.PeakConnections < 1 || c.PeakConnections > 4 {
+		t.Errorf("peak of %d connections with 4 clients", c.PeakConnections)
+	}
+	if n := mr.TotalConnectionCount(); n < 100 {
+		t.Errorf("server saw %d connections, want at least one per operation", n)
+	}
+}
+
+func TestChurnRefusedDials(t *testing.T) {
+	// Take a free port and close it so every dial is refused.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	cfg := testConfig(t, "-addr", addr, "-churn", "-clients", "1", "-ops", "3")
+
+	res := runBenchmark(context.Background(), nil, cfg)
+
+	s := res.total.churn
+	if s == nil || s.dials != 3 || s.dialErrors[errRefused] != 3 || s.dialErrors[errFDExhausted] != 0 {
+		t.Errorf("churn stats %+v, want 3 refused dials", s)
+	}
+	if s != nil && s.connect.Count() != 0 {
+		t.Errorf("%d connect samples from refused dials", s.connect.Count())
+	}
+}
+
+func TestChurnValidation(t *testing.T) {
+	for _, args := range [][]string{
+		{"-churn", "-pipeline", "10"},
+		{"-churn", "-workload", "hash"},
+		{"-churn", "-ratio", "get=0.5,incr=0.5"},
+		{"-churn", "-workload", "queue"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	if _, err := parseFlags([]string{"-churn", "-workload", "get"}); err != nil {
+		t.Errorf("-churn -workload get: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	cleanupFlush   = "flush"
+	cleanupScanDel = "scan-del"
+)
+
+// cleanupScanCount is the COUNT hint passed to SCAN; each page of matches is
+// deleted with one pipelined round trip.
+const cleanupScanCount = 1000
+
+// cleanupResult describes the removal of benchmark keys after a run.
+type cleanupResult struct {
+	mode    string
+	removed int64
+	elapsed time.Duration
+	err     error
+}
+
+// cleanupKeys removes the keys written by the run, in every database of
+// -dbs. flush drops the whole database; scan-del only deletes keys under
+// -key-prefix so other data on a shared server survives.
+func cleanupKeys(ctx context.Context, rdb redis.UniversalClient, cfg *config) *cleanupResult {
+	mode := cfg.cleanup
+	res := &cleanupResult{mode: mode}
+	start := time.Now()
+	clients := cfg.dbClients
+	if clients == nil {
+		clients = []redis.UniversalClient{rdb}
+	}
+	for _, c := range clients {
+		var n int64
+		var err error
+		switch mode {
+		case cleanupFlush:
+			n, err = flushDB(ctx, c)
+		case cleanupScanDel:
+			n, err = scanDelete(ctx, c, cfg.keyPattern())
+		}
+		res.removed += n
+		if err != nil && res.err == nil {
+			res.err = err
+		}
+	}
+	res.elapsed = time.Since(start)
+	return res
+}
+
+func flushDB(ctx context.Context, rdb redis.UniversalClient) (int64, error) {
+	var removed atomic.Int64
+	err := forEachNode(ctx, rdb, func(ctx context.Context, c *redis.Client) error {
+		n, err := c.DBSize(ctx).Result()
+		if err != nil {
+			return err
+		}
+		if err := c.FlushDB(ctx).Err(); err != nil {
+			return err
+		}
+		removed.Add(n)
+		return nil
+	})
+	return removed.Load(), err
+}
+
+// scanDelete deletes every key matching pattern and returns how many DEL
+// actually removed. A cluster is scanned node by node.
+func scanDelete(ctx context.Context, rdb redis.UniversalClient, pattern string) (int64, error) {
+	var removed atomic.Int64
+	err := forEachNode(ctx, rdb, func(ctx context.Context, c *redis.Client) error {
+		n, err := scanDeleteNode(ctx, c, pattern)
+		removed.Add(n)
+		return err
+	})
+	return removed.Load(), err
+}
+
+// scanDeleteNode deletes the keys matching pattern on a single node.
+func scanDeleteNode(ctx context.Context, rdb *redis.Client, pattern string) (int64, error) {
+	var removed int64
+	var cursor uint64
+	for {
+		keys, next, err := rdb.Scan(ctx, cursor, pattern, cleanupScanCount).Result()
+		if err != nil {
+			return removed, err
+		}
+		if len(keys) > 0 {
+			pipe := rdb.Pipeline()
+			cmds := make([]*redis.IntCmd, len(keys))
+			for i, k := range keys {
+				cmds[i] = pipe.Del(ctx, k)
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				return removed, err
+			}
+			for _, c := range cmds {
+				removed += c.Val()
+			}
+		}
+		if next == 0 {
+			return removed, nil
+		}
+		cursor = next
+	}
+}
+
+// printCleanup writes the cleanup summary line.
+func printCleanup(w io.Writer, c *cleanupResult) {
+	fmt.Fprintf(w, "Cleanup (%s): removed %d keys in %v\n", c.mode, c.removed, c.elapsed.Round(time.Millisecond))
+	if c.err != nil {
+		fmt.Fprintf(w, "Cleanup failed: %v\n", c.err)
+	}
+}
@@ -0,0 +1,41 @@
+package loadgen
+
+import (
+	"context"
+	"testing"
+)
+
+func TestScanDelCleanupSparesOtherKeys(t *testing.T) {
+	mr, rdb := newTestServer(t)
+	cfg := testConfig(t, "-clients", "4", "-ops", "50", "-key-prefix", "run[1]:", "-cleanup", "scan-del")
+	runBenchmark(context.Background(), rdb, cfg)
+	mr.Set(cfg.keyName(3), "v")
+	// Neither another run's keys nor a key matching the unescaped
+	// pattern may be touched.
+	mr.Set("tenant:session", "v")
+	mr.Set("run1:key3", "v")
+
+	res := cleanupKeys(context.Background(), rdb, cfg)
+
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.removed != 201 {
+		t.Errorf("removed %d keys, want 201", res.removed)
+	}
+	if keys := mr.Keys(); len(keys) != 2 {
+		t.Errorf("keys left after cleanup: %v, want run1:key3 and tenant:session", keys)
+	}
+}
+
+func TestFlushCleanup(t *testing.T) {
+	mr, rdb := newTestServer(t)
+	mr.Set("a", "1")
+	mr.Set("b", "2")
+
+	res := cleanupKeys(context.Background(), rdb, testConfig(t, "-cleanup", "flush"))
+
+	if res.err != nil || res.removed != 2 || len(mr.Keys()) != 0 {
+		t.Errorf("flush removed %d keys (err %v), %d left", res.removed, res.err, len(mr.Keys()))
+	}
+}
@@ -0,0 +1,292 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+)
+
+// ExitInterrupted is the exit status of a run stopped by SIGINT or SIGTERM.
+const ExitInterrupted = 130
+
+// Execute runs the benchmark of the command line tool on args, the arguments
+// after the program name, and returns its exit status. Unlike Run it writes
+// the summary to stdout, the logs and flag errors to stderr, and the files
+// the flags ask for. Canceling ctx interrupts the run as SIGINT does: the
+// workers finish their in-flight operation and the summary of what ran is
+// still written.
+func Execute(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	cfg, err := parseArgs(args, stderr)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	cfg.stdout, cfg.stderr = stdout, stderr
+	cfg.logger, cfg.logOut = newLogger(cfg, stderr), stderr
+
+	if cfg.metricsAddr != "" {
+		if cfg.metrics, err = listenMetrics(cfg.metricsAddr, cfg.buckets, cfg.logger); err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+		cfg.logger.Info("serving metrics", "url", "http://"+cfg.metrics.addr+"/metrics")
+	}
+
+	if cfg.profiler, err = startProfiler(cfg); err != nil {
+		cfg.logger.Error(err.Error())
+		return 1
+	}
+
+	if cfg.rawOut != "" {
+		if cfg.raw, err = newRawWriter(cfg.rawOut, cfg.clients); err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+	}
+	if cfg.hdrOut != "" {
+		if cfg.hdr, err = newHdrWriter(cfg.hdrOut, cfg.clients, cfg.mixedCommands(), cfg.layout); err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+	}
+	if cfg.timeSeriesOut != "" {
+		if cfg.seriesOut, err = newSeriesWriter(cfg.timeSeriesOut); err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+	}
+	if cfg.recordPath != "" {
+		if cfg.recorder, err = newTraceWriter(cfg.recordPath, cfg); err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+	}
+
+	rootCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		cfg.logger.Warn("interrupted: waiting for in-flight operations, press Ctrl-C again to exit immediately")
+	})
+	defer stop()
+
+	if cfg.mode == modeAgent {
+		if err := runAgent(rootCtx, cfg); err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+		return 0
+	}
+	run := runTarget
+	if cfg.mode == modeCoordinator {
+		run = runCoordinator
+	} else {
+		// The agents of a coordinator share its target: none of them
+		// takes the lock.
+		locks, err := acquireRunLocks(rootCtx, cfg)
+		if err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+		defer locks.release()
+	}
+
+	switch {
+	case cfg.tenants != nil:
+		err := runTenants(rootCtx, cfg)
+		stopMetrics(cfg)
+		if err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+		var results []*runResult
+		for _, t := range cfg.tenants {
+			if t.res != nil {
+				results = append(results, t.res)
+			}
+		}
+		if len(results) == 0 {
+			return ExitInterrupted
+		}
+		if err := writeTenants(cfg); err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+		if code := checkVerification(cfg, results); code != 0 {
+			return code
+		}
+	case cfg.scenario != "":
+		phases, err := runScenario(rootCtx, cfg)
+		stopMetrics(cfg)
+		if err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+		if len(phases) == 0 {
+			return ExitInterrupted
+		}
+		if err := writeScenario(cfg, phases); err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+		var results []*runResult
+		for _, p := range phases {
+			if p.res != nil {
+				results = append(results, p.res)
+			}
+		}
+		if code := checkVerification(cfg, results); code != 0 {
+			return code
+		}
+	case cfg.searchSpec != "":
+		search, err := runSearch(rootCtx, cfg)
+		stopMetrics(cfg)
+		if err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+		if len(search.probes) == 0 {
+			return ExitInterrupted
+		}
+		if err := writeSearch(cfg, search); err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+		results := make([]*runResult, len(search.probes))
+		for i, p := range search.probes {
+			results[i] = p.res
+		}
+		if code := checkVerification(cfg, results); code != 0 {
+			return code
+		}
+		if code := search.exitCode(); code != 0 {
+			return code
+		}
+	case cfg.sweeping():
+		steps, err := runSweep(rootCtx, cfg)
+		stopMetrics(cfg)
+		if err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+		if len(steps) == 0 {
+			return ExitInterrupted
+		}
+		if err := writeSweep(cfg, steps); err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+		results := make([]*runResult, len(steps))
+		for i, s := range steps {
+			results[i] = s.res
+		}
+		if code := checkVerification(cfg, results); code != 0 {
+			return code
+		}
+	case cfg.addr2 == "":
+		res, err := run(rootCtx, cfg)
+		stopMetrics(cfg)
+		if err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+		if cfg.raw != nil {
+			// Workers flushed their queues before the run returned.
+			if err := cfg.raw.close(); err != nil {
+				cfg.logger.Error(err.Error())
+				return 1
+			}
+			res.raw = cfg.raw
+		}
+		if cfg.hdr != nil {
+			if err := cfg.hdr.close(res); err != nil {
+				cfg.logger.Error(err.Error())
+				return 1
+			}
+			res.hdr = cfg.hdr
+		}
+		if cfg.seriesOut != nil {
+			if err := cfg.seriesOut.close(); err != nil {
+				cfg.logger.Error(err.Error())
+				return 1
+			}
+			res.seriesOut = cfg.seriesOut
+		}
+		if cfg.heatmapOut != "" && res.heatmap != nil {
+			if err := writeHeatmapCSV(cfg.heatmapOut, res.heatmap); err != nil {
+				cfg.logger.Error(err.Error())
+				return 1
+			}
+			res.heatmapPath = cfg.heatmapOut
+		}
+		if cfg.recorder != nil {
+			if err := cfg.recorder.close(); err != nil {
+				cfg.logger.Error(err.Error())
+				return 1
+			}
+			res.record = cfg.recorder
+		}
+		if err := writeReport(cfg, res); err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+		if code := exportResults([]*config{cfg}, []*runResult{res}); code != 0 {
+			return code
+		}
+		if res.verifyFailed() {
+			return exitVerifyFailed
+		}
+		if code := checkAborted([]*runResult{res}); code != 0 {
+			return code
+		}
+		if code := checkBaseline(cfg, res); code != 0 {
+			return code
+		}
+		if code := checkSLA(cfg, res); code != 0 {
+			return code
+		}
+	default:
+		// Both targets see the same seeded traffic, one after the other.
+		cfgs := []*config{cfg, cfg.secondTarget()}
+		var results []*runResult
+		for _, c := range cfgs {
+			if rootCtx.Err() != nil {
+				break
+			}
+			cfg.logger.Info("running", "target", targetLabel(c, cfgs[0]))
+			res, err := runTarget(rootCtx, c)
+			if err != nil {
+				cfg.logger.Error(err.Error())
+				return 1
+			}
+			results = append(results, res)
+		}
+		stopMetrics(cfg)
+		if len(results) < len(cfgs) {
+			cfg.logger.Error("interrupted before every target ran: no comparison")
+			return ExitInterrupted
+		}
+		if err := writeComparison(cfgs, results); err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+		if code := exportResults(cfgs, results); code != 0 {
+			return code
+		}
+		if code := checkVerification(cfg, results); code != 0 {
+			return code
+		}
+		if code := checkAborted(results); code != 0 {
+			return code
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ExitInterrupted
+	}
+	return 0
+}
@@ -0,0 +1,170 @@
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// clientCPUInterval is how often the tool samples its own CPU time
+	// over the measured window.
+	clientCPUInterval = time.Second
+	// clientBusy is the share of its CPUs above which the tool, rather
+	// than the server, likely bounds the results.
+	clientBusy = 0.9
+	// clientsPerProc is the most clients per GOMAXPROCS before the
+	// scheduler, rather than the server, delays them.
+	clientsPerProc = 250
+	// procTicks is the clock tick of the CPU times of /proc, USER_HZ.
+	procTicks = 100
+)
+
+// clientsCrowded reports whether so many clients share the CPUs of the
+// tool that their latency includes waiting to be scheduled.
+func (c *config) clientsCrowded() bool {
+	return c.clients > clientsPerProc*runtime.GOMAXPROCS(0)
+}
+
+// processCPU returns the CPU time the process has used, user and system,
+// and where it was read: /proc/self/stat, or the estimate of the Go runtime
+// where there is no /proc.
+func processCPU() (time.Duration, string) {
+	if b, err := os.ReadFile("/proc/self/stat"); err == nil {
+		// The command name may hold spaces: the fields follow its
+		// closing parenthesis, utime and stime 14th and 15th.
+		if i := strings.LastIndexByte(string(b), ')'); i >= 0 {
+			f := strings.Fields(string(b[i+1:]))
+			if len(f) > 12 {
+				utime, err1 := strconv.ParseInt(f[11], 10, 64)
+				stime, err2 := strconv.ParseInt(f[12], 10, 64)
+				if err1 == nil && err2 == nil {
+					return time.Duration(utime+stime) * time.Second / procTicks, "proc"
+				}
+			}
+		}
+	}
+	s := []metrics.Sample{{Name: "/cpu/classes/total:cpu-seconds"}, {Name: "/cpu/classes/idle:cpu-seconds"}}
+	metrics.Read(s)
+	if s[0].Value.Kind() != metrics.KindFloat64 || s[1].Value.Kind() != metrics.KindFloat64 {
+		return 0, ""
+	}
+	busy := s[0].Value.Float64() - s[1].Value.Float64()
+	return time.Duration(busy * float64(time.Second)), "runtime"
+}
+
+// cpuMonitor samples the CPU time and goroutines of the tool over the
+// measured window, to tell a saturated load generator from a slow server.
+type cpuMonitor struct {
+	procs    int
+	start    time.Time
+	startCPU time.Duration
+	source   string
+
+	// peak is the highest utilization of an interval, peakGoroutines the
+	// most goroutines seen; both are owned by the sampling goroutine
+	// until done is closed.
+	peak           float64
+	peakGoroutines int
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func startCPUMonitor() *cpuMonitor {
+	m := &cpuMonitor{procs: runtime.GOMAXPROCS(0), start: time.Now(), stop: make(chan struct{}), done: make(chan struct{})}
+	m.startCPU, m.source = processCPU()
+	m.peakGoroutines = runtime.NumGoroutine()
+	go m.run()
+	return m
+}
+
+func (m *cpuMonitor) run() {
+	defer close(m.done)
+	t := time.NewTicker(clientCPUInterval)
+	defer t.Stop()
+	last, lastCPU := m.start, m.startCPU
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-t.C:
+		}
+		now := time.Now()
+		cpu, _ := processCPU()
+		m.peak = max(m.peak, m.utilization(cpu-lastCPU, now.Sub(last)))
+		m.peakGoroutines = max(m.peakGoroutines, runtime.NumGoroutine())
+		last, lastCPU = now, cpu
+	}
+}
+
+// utilization returns the share of the CPUs of the tool that cpu of them
+// over wall amounts to.
+func (m *cpuMonitor) utilization(cpu, wall time.Duration) float64 {
+	if wall <= 0 {
+		return 0
+	}
+	return cpu.Seconds() / (wall.Seconds() * float64(m.procs))
+}
+
+// finish stops the monitor at the end of the measured window and reports
+// the load of the tool over it.
+func (m *cpuMonitor) finish(cfg *config) *clientLoad {
+	end := time.Now()
+	cpu, _ := processCPU()
+	close(m.stop)
+	<-m.done
+	l := &clientLoad{
+		GOMAXPROCS:     m.procs,
+		NumCPU:         runtime.NumCPU(),
+		Clients:        cfg.clients,
+		PeakGoroutines: max(m.peakGoroutines, runtime.NumGoroutine()),
+		CPUSource:      m.source,
+	}
+	if m.source != "" {
+		mean := m.utilization(cpu-m.startCPU, end.Sub(m.start))
+		l.CPUPercent = 100 * mean
+		l.PeakCPUPercent = 100 * max(m.peak, mean)
+		// Below a second the clock ticks of /proc are too coarse to judge.
+		if end.Sub(m.start) >= clientCPUInterval && mean > clientBusy {
+			l.Warnings = append(l.Warnings, fmt.Sprintf("load generator CPU was %.0f%% of GOMAXPROCS %d; results likely client-bound", l.CPUPercent, m.procs))
+		}
+	}
+	if cfg.clientsCrowded() {
+		l.Warnings = append(l.Warnings, fmt.Sprintf("%d clients on GOMAXPROCS %d; latency includes waiting to be scheduled on the load generator", cfg.clients, m.procs))
+	}
+	return l
+}
+
+// clientLoad is the load of the tool itself over the measured window.
+// CPUPercent is the CPU time used over that available to GOMAXPROCS, and
+// PeakCPUPercent the same over the busiest interval.
+type clientLoad struct {
+	GOMAXPROCS     int      `json:"gomaxprocs"`
+	NumCPU         int      `json:"num_cpu"`
+	Clients        int      `json:"clients"`
+	CPUPercent     float64  `json:"cpu_percent"`
+	PeakCPUPercent float64  `json:"peak_cpu_percent"`
+	PeakGoroutines int      `json:"peak_goroutines"`
+	CPUSource      string   `json:"cpu_source,omitempty"`
+	Warnings       []string `json:"warnings,omitempty"`
+}
+
+// printClientLoad writes the load generator line of the summary and its
+// warnings.
+func printClientLoad(w io.Writer, l *clientLoad) {
+	if l.CPUSource != "" {
+		fmt.Fprintf(w, "Load generator: CPU %.0f%% of GOMAXPROCS %d (peak %.0f%%), %d goroutines at most, for %d clients\n",
+			l.CPUPercent, l.GOMAXPROCS, l.PeakCPUPercent, l.PeakGoroutines, l.Clients)
+	} else {
+		fmt.Fprintf(w, "Load generator: GOMAXPROCS %d, %d goroutines at most, for %d clients\n", l.GOMAXPROCS, l.PeakGoroutines, l.Clients)
+	}
+	for _, warning := range l.Warnings {
+		fmt.Fprintf(w, "WARNING: %s\n", warning)
+	}
+}
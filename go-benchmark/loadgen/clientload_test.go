@@ -0,0 +1,86 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessCPU(t *testing.T) {
+	before, source := processCPU()
+	if source == "" {
+		t.Skip("no CPU time on this platform")
+	}
+	for start := time.Now(); time.Since(start) < 50*time.Millisecond; {
+	}
+	if after, _ := processCPU(); after <= before {
+		t.Errorf("CPU time went from %v to %v over a busy loop (%s)", before, after, source)
+	}
+}
+
+// monitorSince returns a stopped monitor whose window started wall ago
+// with the CPU time of the process less cpu.
+func monitorSince(t *testing.T, wall, cpu time.Duration) *cpuMonitor {
+	t.Helper()
+	now, source := processCPU()
+	if source == "" {
+		t.Skip("no CPU time on this platform")
+	}
+	m := &cpuMonitor{procs: 1, start: time.Now().Add(-wall), startCPU: now - cpu, source: source, stop: make(chan struct{}), done: make(chan struct{})}
+	close(m.done)
+	return m
+}
+
+func TestClientLoadWarnsWhenSaturated(t *testing.T) {
+	cfg := testConfig(t, "-clients", "4")
+
+	l := monitorSince(t, 2*time.Second, 1900*time.Millisecond).finish(cfg)
+	if l.CPUPercent < 90 || len(l.Warnings) != 1 || !strings.Contains(l.Warnings[0], "client-bound") {
+		t.Errorf("CPU %.0f%%, warnings %q", l.CPUPercent, l.Warnings)
+	}
+
+	l = monitorSince(t, 2*time.Second, 200*time.Millisecond).finish(cfg)
+	if l.CPUPercent > 50 || len(l.Warnings) != 0 {
+		t.Errorf("CPU %.0f%%, warnings %q", l.CPUPercent, l.Warnings)
+	}
+
+	// A window too short to judge warns of nothing, however busy.
+	if l := monitorSince(t, 10*time.Millisecond, 10*time.Millisecond).finish(cfg); len(l.Warnings) != 0 {
+		t.Errorf("short window warnings %q", l.Warnings)
+	}
+}
+
+func TestClientLoadWarnsOfCrowdedClients(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(1))
+	cfg := testConfig(t, "-clients", "1000")
+	if !cfg.clientsCrowded() {
+		t.Fatal("1000 clients on GOMAXPROCS 1 not crowded")
+	}
+	l := monitorSince(t, 0, 0).finish(cfg)
+	if len(l.Warnings) != 1 || !strings.Contains(l.Warnings[0], "1000 clients on GOMAXPROCS 1") {
+		t.Errorf("warnings %q", l.Warnings)
+	}
+}
+
+func TestRunReportsClientLoad(t *testing.T) {
+	_, rdb := newTestServer(t)
+	cfg := testConfig(t, "-clients", "4", "-ops", "50")
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	l := res.load
+	if l == nil || l.GOMAXPROCS != runtime.GOMAXPROCS(0) || l.Clients != 4 || l.PeakGoroutines < 4 {
+		t.Fatalf("client load %+v", l)
+	}
+	var out bytes.Buffer
+	printSummary(&out, cfg, res)
+	if !strings.Contains(out.String(), "Load generator: ") {
+		t.Errorf("summary lacks the client load:\n%s", out.String())
+	}
+	if rep := buildReport(cfg, res); rep.ClientLoad != l {
+		t.Errorf("report client load %+v", rep.ClientLoad)
+	}
+}
@@ -0,0 +1,309 @@
+package loadgen
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// -client-name names every connection of a run with CLIENT SETNAME, so the
+// server's CLIENT LIST tells the benchmark's connections from the others:
+// a connection is named <prefix>-<run ID>-w<client> after the client that
+// dialed it, the run ID being random for every invocation, or
+// <prefix>-<run ID>-ctl when dialed outside a client, by the preload, the
+// monitors, the checks or the pool keeping its idle minimum. A pooled
+// connection serves whichever client takes it next, so its name tells who
+// opened it rather than who uses it. A server refusing CLIENT SETNAME runs
+// the connection unnamed; the refusals are counted.
+
+// clientRunIDBytes is the length of a run ID in bytes, twice that in hex.
+const clientRunIDBytes = 3
+
+// clientWorkerKey is the context key holding the number of the client
+// issuing a command, which names the connections it dials.
+type clientWorkerKey struct{}
+
+// validateClientNames checks -client-name and -client-list, and draws the
+// run ID.
+func (c *config) validateClientNames() error {
+	if c.clientNamePrefix == "" {
+		if c.clientList {
+			return errors.New("-client-list requires -client-name, whose names tell the connections of the run")
+		}
+		return nil
+	}
+	switch {
+	case strings.IndexFunc(c.clientNamePrefix, func(r rune) bool { return r <= ' ' || r == 0x7f }) >= 0:
+		return fmt.Errorf("-client-name %q: a connection name cannot hold spaces or control characters", c.clientNamePrefix)
+	case c.protocol != protocolRedis || (c.protocol2 != "" && c.protocol2 != protocolRedis):
+		return errors.New("-client-name names RESP connections: it does not apply to -protocol memcache or http")
+	case c.churn:
+		return errors.New("-client-name does not apply to -churn, whose connections live for one operation: naming them would add a command to every measured dial")
+	case c.clientList && !c.usesPool():
+		return errors.New("-client-list compares the connections of the server with those of the pool, and the workload gives every client connections of its own, closed once it ends")
+	}
+	id := make([]byte, clientRunIDBytes)
+	_, _ = rand.Read(id)
+	c.clientRunID = hex.EncodeToString(id)
+	return nil
+}
+
+// clientNamer names the connections of a run and counts the names the
+// server accepted and refused.
+type clientNamer struct {
+	base     string
+	named    atomic.Int64
+	rejected atomic.Int64
+
+	mu sync.Mutex
+	// reply is the first refusal of the server.
+	reply string
+}
+
+func newClientNamer(cfg *config) *clientNamer {
+	return &clientNamer{base: cfg.clientNamePrefix + "-" + cfg.clientRunID}
+}
+
+// withClient returns the context of the commands of a client, which names
+// the connections it dials.
+func (c *config) withClient(ctx context.Context, clientID int) context.Context {
+	if c.namer == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, clientWorkerKey{}, clientID+c.clientOffset)
+}
+
+// name returns the name of a connection dialed under ctx.
+func (n *clientNamer) name(ctx context.Context) string {
+	if id, ok := ctx.Value(clientWorkerKey{}).(int); ok {
+		return fmt.Sprintf("%s-w%03d", n.base, id)
+	}
+	return n.base + "-ctl"
+}
+
+// setName names a new connection with setname, which sends CLIENT SETNAME.
+// A refusal of the server is counted and leaves the connection in use;
+// only a failure to reach the server loses it.
+func (n *clientNamer) setName(ctx context.Context, setname func(ctx context.Context, name string) error) error {
+	err := setname(ctx, n.name(ctx))
+	var refused redis.Error
+	switch {
+	case err == nil:
+		n.named.Add(1)
+	case errors.As(err, &refused):
+		n.rejected.Add(1)
+		n.mu.Lock()
+		if n.reply == "" {
+			n.reply = err.Error()
+		}
+		n.mu.Unlock()
+		return nil
+	}
+	return err
+}
+
+// onConnect is the go-redis OnConnect hook of the clients of the run.
+func (n *clientNamer) onConnect(ctx context.Context, cn *redis.Conn) error {
+	return n.setName(ctx, func(ctx context.Context, name string) error {
+		return cn.ClientSetName(ctx, name).Err()
+	})
+}
+
+// clientNamesReport describes the names of the connections of a run:
+// Named and Rejected count the connections the server named and those
+// whose CLIENT SETNAME it refused, Rejection its first refusal.
+type clientNamesReport struct {
+	Prefix    string `json:"prefix"`
+	RunID     string `json:"run_id"`
+	Named     int64  `json:"named"`
+	Rejected  int64  `json:"rejected"`
+	Rejection string `json:"rejection,omitempty"`
+	// ClientList is what CLIENT LIST showed after the run, with
+	// -client-list.
+	ClientList *clientListReport `json:"client_list,omitempty"`
+}
+
+// clientListReport is the connections of the run CLIENT LIST showed once
+// it was over. Clients counts the connections named after a client,
+// Workers the distinct clients among them, and Control those dialed
+// outside any; Others are the connections of other clients of the
+// server. Ages are in seconds, output buffer sizes in bytes: OutputMemory
+// is what the replies queued for the connections of the run held, and
+// OutputBufMax and OutputListMax the fullest fixed buffer (obl) and
+// longest reply list (oll) among them. PoolConns is what the pool held at
+// the time, for comparison.
+type clientListReport struct {
+	Unavailable   string   `json:"unavailable,omitempty"`
+	Connections   int      `json:"connections"`
+	Clients       int      `json:"clients"`
+	Workers       int      `json:"workers"`
+	Control       int      `json:"control"`
+	Others        int      `json:"others"`
+	AgeMinS       int64    `json:"age_min_s"`
+	AgeMedianS    int64    `json:"age_median_s"`
+	AgeMaxS       int64    `json:"age_max_s"`
+	OutputMemory  int64    `json:"output_memory"`
+	OutputMemMax  int64    `json:"output_memory_max"`
+	OutputBufMax  int64    `json:"output_buf_max"`
+	OutputListMax int64    `json:"output_list_max"`
+	PoolConns     *uint32  `json:"pool_conns,omitempty"`
+	Notes         []string `json:"notes,omitempty"`
+}
+
+// clientListEntry is a line of CLIENT LIST.
+type clientListEntry struct {
+	name                string
+	age, omem, obl, oll int64
+}
+
+// parseClientList parses the reply of CLIENT LIST, a line of field=value
+// pairs per connection. A field missing from a line, as servers that do
+// not track it leave it out, reads as 0.
+func parseClientList(reply string) []clientListEntry {
+	var entries []clientListEntry
+	for _, line := range strings.Split(reply, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var e clientListEntry
+		for _, field := range strings.Fields(line) {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			n, _ := strconv.ParseInt(value, 10, 64)
+			switch key {
+			case "name":
+				e.name = value
+			case "age":
+				e.age = n
+			case "omem":
+				e.omem = n
+			case "obl":
+				e.obl = n
+			case "oll":
+				e.oll = n
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// captureClientList reads CLIENT LIST on every node after the run. A
+// server refusing it leaves the reason in Unavailable.
+func captureClientList(ctx context.Context, rdb redis.UniversalClient, n *clientNamer) *clientListReport {
+	var entries []clientListEntry
+	var mu sync.Mutex
+	err := forEachNode(ctx, rdb, func(ctx context.Context, c *redis.Client) error {
+		reply, err := c.ClientList(ctx).Result()
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		entries = append(entries, parseClientList(reply)...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return &clientListReport{Unavailable: err.Error()}
+	}
+	rep := &clientListReport{}
+	workers := make(map[string]bool)
+	var ages []int64
+	for _, e := range entries {
+		switch {
+		case e.name == n.base+"-ctl":
+			rep.Control++
+		case strings.HasPrefix(e.name, n.base+"-w"):
+			rep.Clients++
+			workers[e.name] = true
+		default:
+			rep.Others++
+			continue
+		}
+		ages = append(ages, e.age)
+		rep.OutputMemory += e.omem
+		rep.OutputMemMax = max(rep.OutputMemMax, e.omem)
+		rep.OutputBufMax = max(rep.OutputBufMax, e.obl)
+		rep.OutputListMax = max(rep.OutputListMax, e.oll)
+	}
+	rep.Connections, rep.Workers = rep.Clients+rep.Control, len(workers)
+	if len(ages) > 0 {
+		sort.Slice(ages, func(i, j int) bool { return ages[i] < ages[j] })
+		rep.AgeMinS, rep.AgeMedianS, rep.AgeMaxS = ages[0], ages[len(ages)/2], ages[len(ages)-1]
+	}
+	return rep
+}
+
+// buildClientNames reports the names of a run, cross-checking the
+// connections CLIENT LIST showed, if captured, with those of the pool.
+func buildClientNames(cfg *config, list *clientListReport, pool *poolReport) *clientNamesReport {
+	n := cfg.namer
+	rep := &clientNamesReport{Prefix: cfg.clientNamePrefix, RunID: cfg.clientRunID, Named: n.named.Load(), Rejected: n.rejected.Load(), ClientList: list}
+	n.mu.Lock()
+	rep.Rejection = n.reply
+	n.mu.Unlock()
+	if list == nil || list.Unavailable != "" {
+		return rep
+	}
+	switch {
+	case rep.Named == 0 && rep.Rejected > 0:
+		list.Notes = append(list.Notes, "the server named no connection: CLIENT LIST cannot tell those of the run")
+	case rep.Rejected > 0:
+		list.Notes = append(list.Notes, fmt.Sprintf("%d connections of the run are unnamed: CLIENT LIST counts them among the others", rep.Rejected))
+	}
+	if pool != nil {
+		conns := pool.TotalConns
+		list.PoolConns = &conns
+		switch {
+		case cfg.cluster:
+			list.Notes = append(list.Notes, "the pool of a -cluster run spans replicas too, and CLIENT LIST was read on the masters: the counts do not compare")
+		case uint32(list.Connections) < conns:
+			list.Notes = append(list.Notes, fmt.Sprintf("the server lists %d connections of the run and the pool holds %d: a proxy in between, or connections the server closed", list.Connections, conns))
+		case uint32(list.Connections) > conns:
+			list.Notes = append(list.Notes, fmt.Sprintf("the server lists %d connections of the run and the pool holds %d: connections of the run outside the pool, or closed ones the server has not noticed yet", list.Connections, conns))
+		}
+	}
+	return rep
+}
+
+// printClientNames writes the connection names section of the summary.
+func printClientNames(w io.Writer, rep *clientNamesReport) {
+	fmt.Fprintf(w, "Client names: %s-%s-w<client>, %d connections named\n", rep.Prefix, rep.RunID, rep.Named)
+	if rep.Rejected > 0 {
+		fmt.Fprintf(w, "WARNING: the server refused CLIENT SETNAME on %d connections (%s); they ran unnamed\n", rep.Rejected, rep.Rejection)
+	}
+	l := rep.ClientList
+	if l == nil {
+		return
+	}
+	if l.Unavailable != "" {
+		fmt.Fprintf(w, "CLIENT LIST: unavailable (%s)\n", l.Unavailable)
+		return
+	}
+	fmt.Fprintf(w, "CLIENT LIST: %d connections of the run (%d worker connections for %d clients, %d control connections), %d of other clients",
+		l.Connections, l.Clients, l.Workers, l.Control, l.Others)
+	if l.PoolConns != nil {
+		fmt.Fprintf(w, "; the pool holds %d", *l.PoolConns)
+	}
+	fmt.Fprintln(w)
+	if l.Connections > 0 {
+		fmt.Fprintf(w, "  age %ds min, %ds median, %ds max; output buffers %d bytes (max %d per connection, obl max %d, oll max %d)\n",
+			l.AgeMinS, l.AgeMedianS, l.AgeMaxS, l.OutputMemory, l.OutputMemMax, l.OutputBufMax, l.OutputListMax)
+	}
+	for _, note := range l.Notes {
+		fmt.Fprintf(w, "  note: %s\n", note)
+	}
+}
@@ -0,0 +1,217 @@
+package loadgen
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeNamedServer is a RESP server that keeps the CLIENT SETNAME of its
+// connections, or refuses it when reject is set, and answers CLIENT LIST
+// with them. seen holds every name set, of closed connections too.
+type fakeNamedServer struct {
+	reject bool
+
+	mu    sync.Mutex
+	names map[int]string
+	seen  map[string]bool
+	next  int
+}
+
+func startFakeNamedServer(t *testing.T, reject bool) (*fakeNamedServer, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeNamedServer{reject: reject, names: make(map[int]string), seen: make(map[string]bool)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, ln.Addr().String()
+}
+
+func (s *fakeNamedServer) serve(conn net.Conn) {
+	s.mu.Lock()
+	id := s.next
+	s.next++
+	s.names[id] = ""
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.names, id)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readBulkCommand(r)
+		if err != nil || len(args) == 0 {
+			return
+		}
+		reply := "+OK\r\n"
+		switch cmd := strings.ToLower(args[0]); {
+		case cmd == "client" && strings.EqualFold(args[1], "setname"):
+			if s.reject {
+				reply = "-ERR unknown subcommand 'setname'\r\n"
+			} else {
+				s.mu.Lock()
+				s.names[id] = args[2]
+				s.seen[args[2]] = true
+				s.mu.Unlock()
+			}
+		case cmd == "client" && strings.EqualFold(args[1], "list"):
+			var b strings.Builder
+			s.mu.Lock()
+			for c, name := range s.names {
+				fmt.Fprintf(&b, "id=%d addr=127.0.0.1:%d name=%s age=%d idle=0 obl=0 oll=0 omem=%d\n", c, 5000+c, name, c, 64*c)
+			}
+			s.mu.Unlock()
+			reply = bulk(b.String())
+		case cmd == "ping":
+			reply = "+PONG\r\n"
+		case cmd == "get":
+			reply = "$-1\r\n"
+		case cmd == "info":
+			reply = bulk("")
+		}
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+// wasNamed reports whether a connection was named name.
+func (s *fakeNamedServer) wasNamed(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seen[name]
+}
+
+func TestClientNamesRun(t *testing.T) {
+	s, addr := startFakeNamedServer(t, false)
+	// A connection of another client of the server.
+	other, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	cfg := testConfig(t, "-addr", addr, "-clients", "3", "-pool-size", "3", "-ops", "60", "-workload", "set",
+		"-client-name", "bench", "-client-list")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := res.clientNames
+	if rep == nil || rep.Named == 0 || rep.Rejected != 0 || len(rep.RunID) != 2*clientRunIDBytes {
+		t.Fatalf("names %+v", rep)
+	}
+	l := rep.ClientList
+	if l == nil || l.Unavailable != "" || l.Clients == 0 || l.Workers == 0 || l.Others != 1 || l.PoolConns == nil {
+		t.Fatalf("client list %+v", l)
+	}
+	if int(*l.PoolConns) != l.Connections && len(l.Notes) == 0 {
+		t.Errorf("%d connections listed, %d pooled, without a note", l.Connections, *l.PoolConns)
+	}
+	if !s.wasNamed("bench-" + rep.RunID + "-ctl") {
+		t.Error("no connection named after the preload or checks")
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "Client names: bench-"+rep.RunID+"-w<client>") ||
+		!strings.Contains(buf.String(), fmt.Sprintf("(%d worker connections for %d clients, %d control connections), 1 of other clients", l.Clients, l.Workers, l.Control)) {
+		t.Errorf("summary:\n%s", buf.String())
+	}
+	if buildReport(cfg, res).ClientNames != rep {
+		t.Error("the JSON report lacks the client names")
+	}
+}
+
+func TestClientNamesRawClient(t *testing.T) {
+	s, addr := startFakeNamedServer(t, false)
+	cfg := testConfig(t, "-addr", addr, "-clients", "2", "-ops", "20", "-workload", "set", "-client", "raw", "-client-name", "raw")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.clientNames == nil || res.clientNames.Named < 2 {
+		t.Fatalf("names %+v", res.clientNames)
+	}
+	// Every client dials a connection of its own.
+	for _, name := range []string{"raw-" + cfg.clientRunID + "-w000", "raw-" + cfg.clientRunID + "-w001"} {
+		if !s.wasNamed(name) {
+			t.Errorf("no connection named %s", name)
+		}
+	}
+}
+
+func TestClientNamesRejected(t *testing.T) {
+	_, addr := startFakeNamedServer(t, true)
+	cfg := testConfig(t, "-addr", addr, "-clients", "2", "-ops", "20", "-workload", "set", "-client-name", "bench", "-client-list")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.total.errors() != 0 {
+		t.Fatalf("%d errors", res.total.errors())
+	}
+	rep := res.clientNames
+	if rep.Named != 0 || rep.Rejected == 0 || !strings.Contains(rep.Rejection, "unknown subcommand") {
+		t.Fatalf("names %+v", rep)
+	}
+	if l := rep.ClientList; l == nil || !strings.Contains(strings.Join(l.Notes, "\n"), "the server named no connection") {
+		t.Errorf("client list %+v", l)
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "WARNING: the server refused CLIENT SETNAME") {
+		t.Errorf("summary:\n%s", buf.String())
+	}
+}
+
+func TestParseClientListReply(t *testing.T) {
+	reply := "id=3 addr=127.0.0.1:52100 laddr=127.0.0.1:6379 fd=8 name=bench-a1b2c3-w042 age=12 idle=0 flags=N db=0 sub=0 psub=0 multi=-1 qbuf=0 qbuf-free=0 argv-mem=0 obl=16 oll=2 omem=40960 tot-mem=61464 events=r cmd=client|list user=default\n" +
+		"id=4 addr=127.0.0.1:52102 name= age=3\n"
+	entries := parseClientList(reply)
+	if len(entries) != 2 {
+		t.Fatalf("entries %+v", entries)
+	}
+	if e := entries[0]; e.name != "bench-a1b2c3-w042" || e.age != 12 || e.obl != 16 || e.oll != 2 || e.omem != 40960 {
+		t.Errorf("entry %+v", e)
+	}
+	if e := entries[1]; e.name != "" || e.age != 3 || e.omem != 0 {
+		t.Errorf("entry %+v", e)
+	}
+}
+
+func TestValidateClientNames(t *testing.T) {
+	for _, args := range [][]string{
+		{"-client-list"},
+		{"-client-name", "my bench"},
+		{"-client-name", "bench", "-churn"},
+		{"-client-name", "bench", "-protocol", "memcache", "-workload", "set"},
+		{"-client-name", "bench", "-client-list", "-client", "raw"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+	a := testConfig(t, "-client-name", "bench")
+	b := testConfig(t, "-client-name", "bench")
+	if a.clientRunID == "" || a.clientRunID == b.clientRunID {
+		t.Errorf("run IDs %q and %q", a.clientRunID, b.clientRunID)
+	}
+}
@@ -0,0 +1,34 @@
+package loadgen
+
+import (
+	"context"
+	"time"
+)
+
+// clock abstracts time so scheduling and timing logic can be tested without
+// real sleeps.
+type clock interface {
+	Now() time.Time
+	// Sleep blocks for d or until ctx is done and reports whether the full
+	// duration elapsed.
+	Sleep(ctx context.Context, d time.Duration) bool
+}
+
+// realClock is the wall clock used for actual runs.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
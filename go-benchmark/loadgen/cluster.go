@@ -0,0 +1,230 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// seeds returns the -cluster seed addresses of -addr.
+func (c *config) seeds() []string {
+	var addrs []string
+	for _, a := range strings.Split(c.addr, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// validateCluster rejects what cannot run on Redis Cluster. Every command
+// must touch a single key, or the keys of one slot, and go-redis routes it
+// by that key; commands over keys of several slots fail with CROSSSLOT.
+func (c *config) validateCluster() error {
+	if len(c.seeds()) == 0 {
+		return errors.New("-cluster needs at least one seed address in -addr")
+	}
+	for _, a := range c.seeds() {
+		if network, _ := splitAddr(a); network == "unix" {
+			return errors.New("-cluster does not apply to unix sockets")
+		}
+	}
+	if c.db != 0 {
+		return errors.New("-cluster only has database 0")
+	}
+	if c.churn {
+		return errors.New("-churn does not apply to -cluster")
+	}
+	if c.pipeline > 1 {
+		return errors.New("-pipeline does not apply to -cluster: a batch would span nodes")
+	}
+	if c.usesBatches() {
+		return errors.New("MGET and MSET do not apply to -cluster: the keys of a batch are spread over slots")
+	}
+	if c.workload == workloadScan {
+		return errors.New("-workload scan does not apply to -cluster: SCAN walks a single node")
+	}
+	if c.script != nil && c.scriptKeys > 1 {
+		return errors.New("-cluster needs -script-keys of at most 1: a script's keys must share a slot")
+	}
+	if c.workload == workloadQueue && c.queueBlock > 0 && c.queueKeys > 1 {
+		return errors.New("-queue-block does not apply to -cluster with several -queue-keys: BRPOP would span slots")
+	}
+	// Transactions WATCH and write a single counter, so they stay on one
+	// slot.
+	return nil
+}
+
+// newClient returns the client of a run: a cluster client over the -addr
+// seeds with -cluster, whose per-node counters are returned as well, a
+// failover client with -sentinel-master, or a single-node client.
+func newClient(cfg *config) (redis.UniversalClient, *clusterNodes) {
+	if cfg.sentinelMaster != "" {
+		return newFailoverClient(cfg), nil
+	}
+	if !cfg.cluster {
+		return redis.NewClient(cfg.clientOptions()), nil
+	}
+	opt := cfg.clientOptions()
+	nodes := &clusterNodes{byAddr: make(map[string]*nodeCounters)}
+	rdb := redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs:        cfg.seeds(),
+		Password:     opt.Password,
+		PoolSize:     opt.PoolSize,
+		MinIdleConns: opt.MinIdleConns,
+		PoolTimeout:  opt.PoolTimeout,
+		TLSConfig:    opt.TLSConfig,
+		MaxRetries:   opt.MaxRetries,
+		OnConnect:    opt.OnConnect,
+		NewClient: func(opt *redis.Options) *redis.Client {
+			c := redis.NewClient(opt)
+			c.AddHook(nodeHook{nodes.node(opt.Addr)})
+			return c
+		},
+	})
+	return rdb, nodes
+}
+
+// forEachNode calls fn with every master of a cluster client, or with the
+// client itself.
+func forEachNode(ctx context.Context, rdb redis.UniversalClient, fn func(ctx context.Context, c *redis.Client) error) error {
+	if cc, ok := rdb.(*redis.ClusterClient); ok {
+		return cc.ForEachMaster(ctx, fn)
+	}
+	return fn(ctx, rdb.(*redis.Client))
+}
+
+// nodeCounters counts the commands one cluster node processed and the
+// redirections it answered. The cluster client follows them itself.
+type nodeCounters struct {
+	ops   atomic.Int64
+	moved atomic.Int64
+	ask   atomic.Int64
+}
+
+func (n *nodeCounters) record(cmd redis.Cmder) {
+	n.ops.Add(1)
+	if err := cmd.Err(); err != nil {
+		switch msg := err.Error(); {
+		case strings.HasPrefix(msg, "MOVED "):
+			n.moved.Add(1)
+		case strings.HasPrefix(msg, "ASK "):
+			n.ask.Add(1)
+		}
+	}
+}
+
+// nodeHook records the commands of a node client into its counters.
+type nodeHook struct {
+	n *nodeCounters
+}
+
+func (h nodeHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h nodeHook) AfterProcess(_ context.Context, cmd redis.Cmder) error {
+	h.n.record(cmd)
+	return nil
+}
+
+func (h nodeHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h nodeHook) AfterProcessPipeline(_ context.Context, cmds []redis.Cmder) error {
+	for _, cmd := range cmds {
+		h.n.record(cmd)
+	}
+	return nil
+}
+
+// clusterNodes holds the counters of every node the cluster client has
+// connected to.
+type clusterNodes struct {
+	mu     sync.Mutex
+	byAddr map[string]*nodeCounters
+}
+
+// node returns the counters of addr, creating them on first use.
+func (c *clusterNodes) node(addr string) *nodeCounters {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n, ok := c.byAddr[addr]
+	if !ok {
+		n = &nodeCounters{}
+		c.byAddr[addr] = n
+	}
+	return n
+}
+
+// nodeSnapshot is a copy of the counters of one node.
+type nodeSnapshot struct {
+	ops, moved, ask int64
+}
+
+// snapshot copies the counters of every node, to be subtracted from those
+// at the end of the run.
+func (c *clusterNodes) snapshot() map[string]nodeSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := make(map[string]nodeSnapshot, len(c.byAddr))
+	for addr, n := range c.byAddr {
+		s[addr] = nodeSnapshot{ops: n.ops.Load(), moved: n.moved.Load(), ask: n.ask.Load()}
+	}
+	return s
+}
+
+// clusterReport describes the nodes a -cluster run reached, warmup
+// included. Redirections are followed by go-redis and counted here; a
+// steady stream of them means the client's slot map is stale.
+type clusterReport struct {
+	Seeds []string      `json:"seeds"`
+	Moved int64         `json:"moved"`
+	Ask   int64         `json:"ask"`
+	Nodes []clusterNode `json:"nodes"`
+}
+
+// clusterNode is the share of the commands of a run one node processed,
+// redirected ones included.
+type clusterNode struct {
+	Addr  string  `json:"addr"`
+	Ops   int64   `json:"ops"`
+	Share float64 `json:"share"`
+}
+
+// buildCluster summarises the node counters gathered since before.
+func buildCluster(cfg *config, before, after map[string]nodeSnapshot) *clusterReport {
+	rep := &clusterReport{Seeds: cfg.seeds()}
+	var total int64
+	for addr, a := range after {
+		b := before[addr]
+		rep.Moved += a.moved - b.moved
+		rep.Ask += a.ask - b.ask
+		if ops := a.ops - b.ops; ops > 0 {
+			rep.Nodes = append(rep.Nodes, clusterNode{Addr: addr, Ops: ops})
+			total += ops
+		}
+	}
+	sort.Slice(rep.Nodes, func(i, j int) bool { return rep.Nodes[i].Addr < rep.Nodes[j].Addr })
+	for i := range rep.Nodes {
+		rep.Nodes[i].Share = float64(rep.Nodes[i].Ops) / float64(total)
+	}
+	return rep
+}
+
+// printCluster writes the cluster section of the summary.
+func printCluster(w io.Writer, rep *clusterReport) {
+	fmt.Fprintf(w, "Cluster: %d nodes reached from %d seeds, %d MOVED and %d ASK redirections\n",
+		len(rep.Nodes), len(rep.Seeds), rep.Moved, rep.Ask)
+	for _, n := range rep.Nodes {
+		fmt.Fprintf(w, "  %s: %d commands (%.1f%%)\n", n.Addr, n.Ops, 100*n.Share)
+	}
+}
@@ -0,0 +1,69 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestClusterTarget(t *testing.T) {
+	// miniredis answers CLUSTER SLOTS as a single node holding every slot.
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-cluster", "-addr", mr.Addr()+", "+mr.Addr(), "-ratio", "get=0.5,set=0.5", "-preload", "100", "-keyspace", "100",
+		"-clients", "2", "-ops", "50", "-cleanup", "scan-del", "-key-prefix", "cl:")
+	if len(cfg.seeds()) != 2 {
+		t.Fatalf("seeds %q, want 2", cfg.seeds())
+	}
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.total.errors() != 0 {
+		t.Errorf("%d errors", res.total.errors())
+	}
+	c := res.cluster
+	if c == nil || len(c.Nodes) != 1 || c.Nodes[0].Addr != mr.Addr() || c.Nodes[0].Share != 1 || c.Nodes[0].Ops < 100 {
+		t.Fatalf("cluster report %+v, want every command on %s", c, mr.Addr())
+	}
+	if c.Moved != 0 || c.Ask != 0 {
+		t.Errorf("%d MOVED, %d ASK from a single node", c.Moved, c.Ask)
+	}
+	if res.cleanup == nil || res.cleanup.removed != 100 {
+		t.Errorf("cleanup %+v, want 100 keys removed", res.cleanup)
+	}
+}
+
+func TestClusterRedirectCounts(t *testing.T) {
+	n := &nodeCounters{}
+	for _, err := range []error{errors.New("MOVED 3999 127.0.0.1:7001"), errors.New("ASK 3999 127.0.0.1:7002"), nil} {
+		cmd := redis.NewStringCmd(context.Background(), "get", "k")
+		cmd.SetErr(err)
+		n.record(cmd)
+	}
+	if n.ops.Load() != 3 || n.moved.Load() != 1 || n.ask.Load() != 1 {
+		t.Errorf("%d ops, %d MOVED, %d ASK, want 3, 1 and 1", n.ops.Load(), n.moved.Load(), n.ask.Load())
+	}
+}
+
+func TestClusterRejectsMultiKey(t *testing.T) {
+	for _, args := range [][]string{
+		{"-cluster", "-workload", "mget"},
+		{"-cluster", "-pipeline", "10"},
+		{"-cluster", "-workload", "scan"},
+		{"-cluster", "-workload", "script", "-script-keys", "2"},
+		{"-cluster", "-db", "1"},
+		{"-cluster", "-churn"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	if _, err := parseFlags([]string{"-cluster", "-workload", "txn"}); err != nil {
+		t.Errorf("single-key transactions rejected: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+// comparedMetric is one row of the side-by-side comparison.
+type comparedMetric struct {
+	name string
+	// a and b are the metric's values for the two targets, in the unit
+	// given by unit, printed with decimals decimals.
+	a, b     float64
+	unit     string
+	decimals int
+}
+
+// delta returns the change from a to b in percent, 0 when a is 0.
+func (m comparedMetric) delta() float64 {
+	if m.a == 0 {
+		return 0
+	}
+	return 100 * (m.b - m.a) / m.a
+}
+
+// compareResults lists throughput and the latency percentiles of two runs,
+// the latencies in unit.
+func compareResults(a, b *runResult, unit latencyUnit) []comparedMetric {
+	ms := []comparedMetric{{
+		name: "throughput",
+		a:    throughput(a),
+		b:    throughput(b),
+		unit: "ops/s",
+	}}
+	ha, hb := a.total.latency, b.total.latency
+	for _, p := range []struct {
+		name string
+		at   func(h *stats.Histogram) time.Duration
+	}{
+		{"mean", (*stats.Histogram).Mean},
+		{"p50", func(h *stats.Histogram) time.Duration { return h.Percentile(50) }},
+		{"p90", func(h *stats.Histogram) time.Duration { return h.Percentile(90) }},
+		{"p99", func(h *stats.Histogram) time.Duration { return h.Percentile(99) }},
+		{"p99.9", func(h *stats.Histogram) time.Duration { return h.Percentile(99.9) }},
+		{"max", (*stats.Histogram).Maximum},
+	} {
+		ms = append(ms, comparedMetric{
+			name:     p.name + " latency",
+			a:        unit.value(p.at(ha)),
+			b:        unit.value(p.at(hb)),
+			unit:     unit.symbol,
+			decimals: unit.decimals,
+		})
+	}
+	return ms
+}
+
+// throughput is the measured operations per second of res.
+func throughput(res *runResult) float64 {
+	elapsed := res.elapsed()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(res.total.latency.Count()) / elapsed.Seconds()
+}
+
+// jsonComparison is the -output json document of a run with -addr2.
+type jsonComparison struct {
+	Targets []*Report `json:"targets"`
+	// DeltasPercent maps each compared metric to the change from the first
+	// target to the second, in percent.
+	DeltasPercent map[string]float64 `json:"deltas_percent"`
+}
+
+// writeComparison renders both runs and their comparison to -out, or to
+// stdout when no file was given.
+func writeComparison(cfgs []*config, results []*runResult) error {
+	if cfgs[0].htmlReport != "" {
+		if err := writeHTMLReport(cfgs[0].htmlReport, cfgs, results); err != nil {
+			return err
+		}
+	}
+	var w io.Writer = cfgs[0].stdout
+	if out := cfgs[0].out; out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			return fmt.Errorf("create report file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	// Both runs and the table share the unit of the first run.
+	unit := cfgs[0].displayUnit(results[0].total.latency)
+	metrics := compareResults(results[0], results[1], unit)
+	if cfgs[0].output == outputJSON {
+		doc := &jsonComparison{DeltasPercent: make(map[string]float64)}
+		for i, res := range results {
+			doc.Targets = append(doc.Targets, buildReport(cfgs[i], res))
+		}
+		for _, m := range metrics {
+			doc.DeltasPercent[m.name] = m.delta()
+		}
+		return writeJSON(w, doc)
+	}
+
+	for i, res := range results {
+		c := *cfgs[i]
+		c.latencyUnit = unit.name
+		printSummary(w, &c, res)
+		fmt.Fprintln(w)
+	}
+	printComparison(w, targetLabel(cfgs[0], cfgs[1]), targetLabel(cfgs[1], cfgs[0]), metrics)
+	return nil
+}
+
+// printComparison writes the side-by-side table of two targets.
+func printComparison(w io.Writer, addrA, addrB string, metrics []comparedMetric) {
+	fmt.Fprintln(w, "Comparison:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "metric\t%s\t%s\tdelta\t\n", addrA, addrB)
+	for _, m := range metrics {
+		fmt.Fprintf(tw, "%s\t%.*f %s\t%.*f %s\t%+.1f%%\t\n", m.name, m.decimals, m.a, m.unit, m.decimals, m.b, m.unit, m.delta())
+	}
+	tw.Flush()
+}
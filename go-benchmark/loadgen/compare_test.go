@@ -0,0 +1,43 @@
+package loadgen
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCompareTargetsSeeIdenticalTraffic(t *testing.T) {
+	mrA, rdbA := newTestServer(t)
+	mrB, rdbB := newTestServer(t)
+	cfgA := testConfig(t, "-clients", "3", "-ops", "40", "-value-size-range", "1:64", "-addr", mrA.Addr(), "-addr2", mrB.Addr())
+	cfgB := cfgA.forTarget(cfgA.addr2)
+
+	runBenchmark(context.Background(), rdbA, cfgA)
+	runBenchmark(context.Background(), rdbB, cfgB)
+
+	keys := mrA.Keys()
+	if len(keys) != 120 || !reflect.DeepEqual(keys, mrB.Keys()) {
+		t.Fatalf("targets hold different keys: %d vs %d", len(keys), len(mrB.Keys()))
+	}
+	for _, k := range keys {
+		a, _ := mrA.Get(k)
+		b, _ := mrB.Get(k)
+		if a != b {
+			t.Fatalf("key %s holds different values on the two targets", k)
+		}
+	}
+}
+
+func TestComparedMetricDelta(t *testing.T) {
+	for _, tc := range []struct {
+		a, b, want float64
+	}{
+		{100, 150, 50},
+		{200, 100, -50},
+		{0, 10, 0},
+	} {
+		if got := (comparedMetric{a: tc.a, b: tc.b}).delta(); got != tc.want {
+			t.Errorf("delta(%v -> %v) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
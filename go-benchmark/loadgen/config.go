@@ -0,0 +1,1307 @@
+package loadgen
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+
+	"go-benchmark/workload"
+)
+
+// config holds the effective benchmark configuration built from the command line.
+type config struct {
+	addr     string
+	password string
+	db       int
+	// stdout receives the reports not written to -out and stderr the
+	// -progress line: the streams given to Execute, discarded otherwise.
+	stdout io.Writer
+	stderr io.Writer
+	// logger receives what the run logs: progress, warnings and the
+	// multi-line reports printed beside the summary, through logOut when
+	// set and line by line through logger otherwise. Execute logs to its
+	// stderr once -log-level and -quiet are known, Run to the logger of its
+	// Config; both discard by default.
+	logger *slog.Logger
+	logOut io.Writer
+	// dbList is -dbs, parsed into dbs. runTarget opens dbClients, the
+	// client of each, for the run.
+	dbList       string
+	dbs          []int
+	dbClients    []redis.UniversalClient
+	clients      int
+	opsPerClient int
+	workload     string
+	preload      int
+	keyspace     int
+	// boundedSets is set by an explicit -keyspace, which the set workload
+	// then writes into instead of fresh keys.
+	boundedSets bool
+	// keySize is -key-size, and keyPad the padding keys are cut from.
+	keySize        int
+	keyPad         string
+	ratio          string
+	keyDist        string
+	expireTTLRange string
+	ttlRange       string
+	verifyExpiry   bool
+	expirySample   int
+	expiryGrace    time.Duration
+	// keyTemplate and valueTemplate are compiled from -key-template and
+	// -value-template; templateSeed draws the random placeholders of keys,
+	// and keyCollisions counts the indexes of the keyspace sharing a key,
+	// exactly or by estimate.
+	keyTemplateSpec    string
+	valueTemplateSpec  string
+	keyTemplate        *workload.Template
+	valueTemplate      *workload.Template
+	templateSeed       uint64
+	keyCollisions      int
+	keyCollisionsExact bool
+	// watchNotifications subscribes to the expired and evicted keyevent
+	// notifications; notifyWatch is the watcher of the current run.
+	watchNotifications bool
+	notifyWatch        *notifyWatcher
+	// ttlSample is the reservoir of keys whose PTTL ttlSampler samples
+	// every ttlSampleInterval; 0 without -ttl-sample.
+	ttlSample         int
+	ttlSampleInterval time.Duration
+	ttlSampler        *ttlSampler
+	// force runs despite the run lock of another run.
+	force bool
+	// mixTolerance is how far in share a command of the achieved mix may
+	// stray from the requested one before the summary warns.
+	mixTolerance float64
+	// verifyExpired is the fraction of SETs written with expiredTTL and
+	// read back once it and expiredGrace passed, by expiredCheck, of at
+	// most expiredSample keys at a time.
+	verifyExpired float64
+	expiredTTL    time.Duration
+	expiredGrace  time.Duration
+	expiredSample int
+	expiredCheck  *expiredChecker
+	// shadowAddr is the target -shadow mirrors the run to; shadow is the
+	// mirror of the current run.
+	shadowAddr  string
+	shadowReads float64
+	shadowQueue int
+	shadow      *shadowMirror
+	// soak records the resources of the tool in the time series and
+	// checks them against the -soak-max bounds; soakMonitor is the
+	// monitor of the current run.
+	soak            bool
+	soakLogInterval time.Duration
+	soakGoroutines  int
+	soakHeap        int
+	soakFDs         int
+	soakGC          float64
+	soakMonitor     *soakMonitor
+	zipfTheta       float64
+	loopKeys        int
+	rerefWindow     int
+	rerefProb       float64
+	duration        time.Duration
+	rate            float64
+	rateScope       string
+	warmup          time.Duration
+	maxErrorRate    float64
+	errorBudget     int64
+	errorWindow     time.Duration
+	pipeline        int
+	maxInflight     int
+	queueTimeout    time.Duration
+	progress        bool
+	fairness        bool
+	// expireChurn is set by -workload churn, which runs as the set
+	// workload; churnInterval is how often it samples the server.
+	expireChurn   bool
+	churnInterval time.Duration
+	// percentileWindow is the length of the sliding window of the
+	// windowed percentiles in the time series and progress line.
+	percentileWindow time.Duration
+	// steadyState replaces the fixed warmup: the measured window starts
+	// once throughput and p99 have held within steadyTolerance for
+	// steadyIntervals intervals of steadyInterval, or after steadyMax.
+	steadyState     bool
+	steadyTolerance float64
+	steadyIntervals int
+	steadyInterval  time.Duration
+	steadyMax       time.Duration
+	// slaSpec is -sla as given and sla its parsed conditions.
+	slaSpec string
+	sla     []slaCondition
+	// searchSpec is -search as given and searchSLO its parsed conditions;
+	// the other search fields are its flags.
+	searchSpec      string
+	searchSLO       []slaCondition
+	searchStart     float64
+	searchInterval  time.Duration
+	searchWarmup    time.Duration
+	searchCooldown  time.Duration
+	searchConfirm   time.Duration
+	searchBudget    time.Duration
+	searchPrecision float64
+	// storePath is the -store database runs are appended to.
+	storePath string
+	// htmlReport is the -report page.
+	htmlReport string
+	// logLevelName is -log-level as given and logLevel its level, raised to
+	// errors only by -quiet. logSample is the one-in-n operations logged at
+	// debug level.
+	logLevelName string
+	logLevel     slog.Level
+	logSample    int
+	quiet        bool
+	// push is the -push target, pushSink the sink it names.
+	push       string
+	pushSink   pushSink
+	pushSeries bool
+	runTag     string
+	// tags are the -tag flags, stored in the report.
+	tags tagList
+	// captureOutliers is the latency above which operations are kept,
+	// at most outlierLimit of them.
+	captureOutliers time.Duration
+	outlierLimit    int
+	// collectSlowlog reads the server's slowlog of the measured window
+	// through slowlog, started by main.
+	collectSlowlog bool
+	slowlog        *slowlogCollector
+	output         string
+	// latencyUnit is the unit of the text report, or auto; histogramMax
+	// and histogramGrowth the layout of the latency histograms, which
+	// validateLatency builds into layout.
+	latencyUnit     string
+	histogramMax    time.Duration
+	histogramGrowth float64
+	layout          stats.Layout
+	valueSize       int
+	rawValues       bool
+	valueSizeRange  string
+	// sizeBounds are the upper bounds of the -size-buckets latency is
+	// bucketed by, nil when it is not.
+	sizeBucketsSpec string
+	sizeBounds      []int
+	// largeValuesSpec is -large-values, parsed into large, and
+	// memoryBudget the MiB its clients may hold in flight.
+	largeValuesSpec string
+	large           *largeValues
+	memoryBudget    int
+	out             string
+	cleanup         string
+	keyPrefix       string
+	addr2           string
+	saveBaseline    string
+	baselinePath    string
+	failThreshold   string
+	metricsAddr     string
+	metricsBuckets  string
+	rawOut          string
+	hdrOut          string
+	heatmapOut      string
+	timeSeriesOut   string
+	heatmapJSON     bool
+	heatmapInterval time.Duration
+	opTimeout       time.Duration
+	rampUp          time.Duration
+	rampSteps       int
+	scenario        string
+	client          string
+	// protocol is the front end of -protocol, protocol2 that of -addr2;
+	// target is the Target of the current run on any but redis.
+	protocol  string
+	protocol2 string
+	target    Target
+	// The memcache target sends memcacheFlags with every set, and
+	// noreply commands under memcacheNoreply; memcacheMaxValue is the
+	// largest value the server stores.
+	memcacheFlags    uint
+	memcacheNoreply  bool
+	memcacheMaxValue int
+	// The http target sends its requests under httpPath, keeps
+	// httpMaxIdle idle connections, negotiates HTTP/2 under http2 and
+	// sends TTLs in the httpTTL header or query parameter httpTTLName.
+	httpPath    string
+	httpMaxIdle int
+	http2       bool
+	httpTTL     string
+	httpTTLName string
+	resp        int
+	resp2       int
+	// negotiated is the protocol -client raw settled on with the target,
+	// and helloErr the reply of a server that rejected HELLO 3.
+	negotiated        int
+	helloErr          string
+	recordPath        string
+	replayPath        string
+	replayTiming      string
+	replaySpeed       float64
+	monitorCommands   string
+	monitorRewrite    string
+	monitorValueScale float64
+	sweepClients      string
+	sweepCooldown     time.Duration
+	sweepFlush        bool
+	sweepValueSizes   string
+	sweepBatchKeys    string
+	batchKeys         int
+	scanClients       int
+	scanCount         int
+	scanMatch         string
+	scanPause         time.Duration
+	txnRetries        int
+	scriptPath        string
+	scriptKeys        int
+	scriptArgs        int
+	pubsubChannels    int
+	pubsubSubscribers int
+	pubsubDrain       time.Duration
+	trackingStaleness time.Duration
+	loop              string
+	openQueue         int
+	// patternSpec is -pattern, parsed into pattern by validateLoop.
+	patternSpec string
+	pattern     *pattern
+	// faultSpec is -fault-inject, parsed into faultPlan by validateFaults;
+	// faults is the injector of the run in progress.
+	faultSpec string
+	faultPlan *faultPlan
+	faults    *faultInjector
+	// latencySplit times the wait for a pool connection apart from the
+	// round trip of every command.
+	latencySplit bool
+	// thinkTimeSpec is -think-time, parsed into think by validate.
+	thinkTimeSpec     string
+	think             *thinkTime
+	churn             bool
+	unixSocket        string
+	useTLS            bool
+	tlsCA             string
+	tlsCert           string
+	tlsKey            string
+	tlsSkipVerify     bool
+	cluster           bool
+	sentinelAddrs     string
+	sentinelMaster    string
+	verify            bool
+	verifyFraction    float64
+	verifyFinal       bool
+	verifyFinalOut    string
+	evictPressure     bool
+	evictCheck        float64
+	evictFillTimeout  time.Duration
+	captureInfo       bool
+	infoInterval      time.Duration
+	probe             bool
+	probeInterval     time.Duration
+	retries           int
+	retryBackoff      string
+	resilience        bool
+	resilienceBackoff string
+	outageCmd         string
+	outageAt          time.Duration
+	hotKeys           int
+	hotFraction       float64
+	counterKeys       int
+	hashFields        int
+	zsetSize          int
+	zsetRange         int
+	zsetVerify        float64
+	appendChunkSize   int
+	appendMax         int
+	appendVerify      float64
+	setSize           int
+	setMissRatio      float64
+	queueProducers    int
+	queueKeys         int
+	queueBlock        time.Duration
+	// queueDepthInterval is how often the queue workload polls LLEN.
+	queueDepthInterval time.Duration
+	// backoffMin and backoffMax are parsed from -resilience-backoff, and
+	// retryBase and retryMax from -retry-backoff.
+	backoffMin, backoffMax time.Duration
+	retryBase, retryMax    time.Duration
+	// checkFullKeys is set when every hash, sorted set or set of the
+	// keyspace holds all its fields or members throughout the run, so
+	// reply sizes and memberships can be checked.
+	checkFullKeys bool
+	lockKeys      int
+	lockTTL       time.Duration
+	lockHold      time.Duration
+	lockRelease   bool
+	// seed derives every random generator of the run, so runs with the
+	// same seed and configuration send the same commands in the same
+	// per-client order.
+	seed int64
+
+	mix *commandMix
+	// tlsConfig is loaded from the -tls flags; nil without -tls.
+	tlsConfig *tls.Config
+	// script is the -script source, or the built-in one, when the
+	// workload calls it.
+	script       *redis.Script
+	expireTTLMin time.Duration
+	expireTTLMax time.Duration
+	ttlMin       time.Duration
+	ttlMax       time.Duration
+	threshold    float64
+	sweep        []int
+	sweepSizes   []int
+	sweepBatches []int
+	// hotPool holds the key indexes of the -hot-keys pool.
+	hotPool []int
+	// poolSize is the connection pool size of the client, 0 for the
+	// go-redis default.
+	poolSize     int
+	minIdleConns int
+	poolTimeout  time.Duration
+	buckets      []time.Duration
+	// clientNamePrefix is -client-name, clientRunID the run ID its names
+	// carry and clientList -client-list; namer names the connections of
+	// the run in progress.
+	clientNamePrefix string
+	clientRunID      string
+	clientList       bool
+	namer            *clientNamer
+	// metrics is started by main when -metrics-addr is set.
+	metrics *metricsServer
+	// cpuProfile, memProfile and pprofAddr profile the tool itself, by
+	// profiler, started by main.
+	cpuProfile string
+	memProfile string
+	pprofAddr  string
+	profiler   *profiler
+	// mode is -mode: local, agent serving -listen, or coordinator of the
+	// agents of -agents, parsed into agents. An agent's share of the
+	// keyspace starts at keyOffset, and its clients are numbered from
+	// clientOffset.
+	mode         string
+	listen       string
+	agentList    string
+	agents       []string
+	keyOffset    int
+	clientOffset int
+	// observe, when set, is handed the state of each run as it starts;
+	// agents stream its live counters.
+	observe func(*runState)
+	// raw is opened by main when -raw-out is set.
+	raw *rawWriter
+	// hdr is opened by main when -hdr-out is set.
+	hdr *hdrWriter
+	// seriesOut is opened by main when -timeseries-out is set.
+	seriesOut *seriesWriter
+	// recorder is opened by main when -record is set, and trace is the
+	// -replay trace read by parseArgs.
+	recorder *traceWriter
+	trace    *trace
+	// explicit holds the flags given on the command line, by name.
+	explicit map[string]string
+	// phases are the phases of a -scenario run.
+	phases []*scenarioPhase
+	values *workload.ValuePool
+	zipf   *workload.Zipfian
+	// tenants are the tenants of a -scenario run, calibrated alone first
+	// under calibrate.
+	tenants   []*scenarioPhase
+	calibrate bool
+	// beforeRun, when set, is called by runTarget once the run is
+	// prepared, just before it starts.
+	beforeRun func()
+}
+
+// parseFlags builds a config from the given command line arguments and validates it.
+func parseFlags(args []string) (*config, error) {
+	return parseArgs(args, io.Discard)
+}
+
+// parseArgs is parseFlags writing flag errors and usage to out.
+func parseArgs(args []string, out io.Writer) (*config, error) {
+	cfg := &config{stdout: io.Discard, stderr: io.Discard, logger: discardLogger()}
+	fs := flag.NewFlagSet("go-benchmark", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.StringVar(&cfg.addr, "addr", "localhost:6379", "server address: host:port, or unix:///path/to.sock for a unix domain socket")
+	fs.StringVar(&cfg.addr2, "addr2", "", "second server to run the identical workload against and compare with -addr, in the same form")
+	fs.BoolVar(&cfg.cluster, "cluster", false, "target a Redis Cluster; -addr lists seed addresses separated by commas")
+	fs.StringVar(&cfg.sentinelAddrs, "sentinel-addrs", "", "Sentinel addresses separated by commas, with -sentinel-master")
+	fs.StringVar(&cfg.sentinelMaster, "sentinel-master", "", "connect to the master of this name through -sentinel-addrs and report failovers during the run")
+	fs.BoolVar(&cfg.useTLS, "tls", false, "connect with TLS")
+	fs.StringVar(&cfg.tlsCA, "tls-ca", "", "PEM file of the CA certificates that verify the server (default: the system roots)")
+	fs.StringVar(&cfg.tlsCert, "tls-cert", "", "PEM file of the client certificate, with -tls-key")
+	fs.StringVar(&cfg.tlsKey, "tls-key", "", "PEM file of the client certificate's private key")
+	fs.BoolVar(&cfg.tlsSkipVerify, "tls-skip-verify", false, "do not verify the server certificate")
+	fs.StringVar(&cfg.unixSocket, "unix-socket", "", "connect through this unix domain socket instead of -addr")
+	fs.StringVar(&cfg.password, "password", "", "server password")
+	fs.IntVar(&cfg.db, "db", 0, "database number to select")
+	fs.StringVar(&cfg.dbList, "dbs", "", "spread the clients over these databases, e.g. 0-15 or 0,2,4, each with a client of its own")
+	fs.IntVar(&cfg.clients, "clients", 1000, "number of concurrent clients")
+	fs.IntVar(&cfg.opsPerClient, "ops", 10000, "number of operations per client")
+	fs.StringVar(&cfg.workload, "workload", workloadSet, "workload to run: "+workloadNames())
+	fs.IntVar(&cfg.preload, "preload", 100000, "number of keys written before a get or mixed workload")
+	fs.IntVar(&cfg.keyspace, "keyspace", 0, "number of distinct keys used by get and mixed workloads (default: -preload); bounds the keys of the set workload, otherwise fresh")
+	fs.IntVar(&cfg.keySize, "key-size", 0, "pad every key to this many bytes (0: no padding)")
+	fs.StringVar(&cfg.keyTemplateSpec, "key-template", "", "shape keys after -key-prefix like user:{seq}:profile, with the placeholders {seq}, {worker}, {rand:uuid}, {rand:int:A-B} and {pad:N}")
+	fs.StringVar(&cfg.valueTemplateSpec, "value-template", "", "shape values like {\"id\":{seq},\"token\":\"{rand:uuid}\",\"bio\":\"{pad:200}\"}, with the placeholders of -key-template but {worker}")
+	fs.DurationVar(&cfg.duration, "duration", 0, "run for this long instead of a fixed number of operations (exclusive with -ops)")
+	fs.IntVar(&cfg.valueSize, "value-size", 0, "size in bytes of random binary values (default: short \"value<n>\" strings)")
+	fs.BoolVar(&cfg.rawValues, "raw-values", false, "store the random bytes of -value-size as they are, without the header naming the key and checksumming the value")
+	fs.StringVar(&cfg.valueSizeRange, "value-size-range", "", "draw value sizes uniformly from min:max bytes")
+	fs.StringVar(&cfg.sizeBucketsSpec, "size-buckets", defaultSizeBuckets, "upper bounds of the payload sizes latency is bucketed by, reported when sizes vary or MGET and MSET run")
+	fs.StringVar(&cfg.largeValuesSpec, "large-values", "", "write values of this many MiB, or of min:max MiB such as 10:50, sliced from one shared buffer and checked on every GET")
+	fs.IntVar(&cfg.memoryBudget, "memory-budget", 1024, "MiB of -large-values the clients may hold in flight; fewer clients run unless -clients is set, which only warns")
+	fs.DurationVar(&cfg.warmup, "warmup", 0, "run the workload for this long before measuring and discard the results")
+	fs.BoolVar(&cfg.steadyState, "steady-state", false, "instead of -warmup, start measuring once throughput and p99 are stable, or after -steady-max")
+	fs.Float64Var(&cfg.steadyTolerance, "steady-tolerance", 0.05, "largest relative standard deviation of throughput and p99 over the -steady-intervals held stable")
+	fs.IntVar(&cfg.steadyIntervals, "steady-intervals", 5, "consecutive intervals throughput and p99 must be stable over for -steady-state")
+	fs.DurationVar(&cfg.steadyInterval, "steady-interval", time.Second, "length of the intervals -steady-state samples throughput and p99 over")
+	fs.DurationVar(&cfg.steadyMax, "steady-max", time.Minute, "longest -steady-state waits for stability before measuring anyway")
+	fs.Float64Var(&cfg.rate, "rate", 0, "target operations per second across all clients or, under -rate-scope client, of every client (0: unlimited; 1000 under -workload churn)")
+	fs.StringVar(&cfg.rateScope, "rate-scope", rateGlobal, "what -rate limits: global, the clients together, or client, every client on its own with staggered schedules")
+	fs.StringVar(&cfg.loop, "loop", loopClosed, "scheduling: closed, each client sending once its previous operation completed, or open, arrivals generated at -rate whatever the server does, at most one in flight per client")
+	fs.IntVar(&cfg.openQueue, "open-queue", 0, "arrivals of -loop open that may wait for a busy client before further ones are dropped (default: -clients)")
+	fs.StringVar(&cfg.patternSpec, "pattern", "", "shape of the arrival rate of -loop open in place of -rate: burst:10000x100ms/1s, ramp:1000..50000/5m or sine:mean=20000,amp=15000,period=60s")
+	fs.StringVar(&cfg.thinkTimeSpec, "think-time", "", "pause of each client between operations, or pipelines: a duration, exp:MEAN or uniform:MIN-MAX, excluded from latency")
+	fs.IntVar(&cfg.pipeline, "pipeline", 1, "number of commands sent per pipelined batch (1: no pipelining)")
+	fs.IntVar(&cfg.maxInflight, "max-inflight", 0, "most commands in flight across all clients, a pipeline counting as its commands; the time waiting for room is reported as queue wait (0: no cap)")
+	fs.DurationVar(&cfg.queueTimeout, "queue-timeout", 0, "shed an operation still waiting to be sent this long after it was due, under -max-inflight or -loop open, instead of sending it late (0: wait)")
+	fs.Float64Var(&cfg.maxErrorRate, "max-error-rate", 0, "abort the run when the fraction of failed operations exceeds this, e.g. 0.05 (0: never)")
+	fs.Int64Var(&cfg.errorBudget, "error-budget", 0, "abort the run when more than this many operations fail in a row or within -error-window (0: never)")
+	fs.DurationVar(&cfg.errorWindow, "error-window", time.Second, "window over which -error-budget counts failures")
+	fs.BoolVar(&cfg.progress, "progress", false, "print a status line every second to stderr during the run")
+	fs.StringVar(&cfg.logLevelName, "log-level", "info", "least severe messages logged to stderr: error, warn, info or debug, which also logs one operation in -log-sample")
+	fs.IntVar(&cfg.logSample, "log-sample", 1000, "with -log-level debug, log one operation in this many (1: every operation, 0: none)")
+	fs.BoolVar(&cfg.quiet, "quiet", false, "print the JSON report and nothing else but errors, for scripts")
+	fs.DurationVar(&cfg.percentileWindow, "percentile-window", 10*time.Second, "length of the sliding window of the p50 and p99 in the time series and progress line (0: off)")
+	fs.BoolVar(&cfg.fairness, "fairness", false, "report the distribution of operations and latency across clients, with the slowest clients")
+	fs.DurationVar(&cfg.captureOutliers, "capture-outliers", 0, "keep the operations slower than this, with their time, command, key and client (0: off)")
+	fs.IntVar(&cfg.outlierLimit, "capture-outliers-max", 1000, "number of the slowest outliers kept by -capture-outliers")
+	fs.BoolVar(&cfg.collectSlowlog, "collect-slowlog", false, "reset the server's slowlog when the measured window starts and report its entries after the run, matched with -capture-outliers")
+	fs.StringVar(&cfg.output, "output", outputText, "report format: text or json")
+	fs.StringVar(&cfg.latencyUnit, "latency-unit", latencyAuto, "unit of the latencies of the text report: ns, us, ms, s, or auto for the coarsest in which the median is at least 1")
+	fs.DurationVar(&cfg.histogramMax, "histogram-max", stats.DefaultLayout.Highest, "largest latency the histograms track; slower operations are counted as overflow")
+	fs.Float64Var(&cfg.histogramGrowth, "histogram-growth", stats.DefaultLayout.Growth, "largest ratio between the bounds of a histogram bucket, the resolution of percentiles")
+	fs.StringVar(&cfg.keyPrefix, "key-prefix", "", "namespace prepended to every key (default: bench-<timestamp>-<random>:, unique per run)")
+	fs.StringVar(&cfg.saveBaseline, "save-baseline", "", "write this run's JSON results to a baseline file")
+	fs.StringVar(&cfg.storePath, "store", "", "append the run, its summary metrics and time series to this SQLite database, listed by the history and diff subcommands")
+	fs.StringVar(&cfg.htmlReport, "report", "", "also write a self-contained HTML page with the summary, configuration and latency charts to this file")
+	fs.StringVar(&cfg.push, "push", "", "after the report, push the summary metrics to influx://[user:pass@]host:8086/db (influxs:// for HTTPS) or graphite://host:2003[/prefix]")
+	fs.BoolVar(&cfg.pushSeries, "push-series", false, "with -push, also push the per-second time series")
+	fs.StringVar(&cfg.runTag, "run-tag", "", "tag the points of -push with run_tag=this")
+	fs.Var(&cfg.tags, "tag", "key=value kept verbatim in the report, the store and the points of -push; repeatable")
+	fs.StringVar(&cfg.slaSpec, "sla", "", "conditions the results must meet, e.g. p99<2ms,errors<0.1%,throughput>30000; a failed one exits with status 5 for latency, 6 for errors, 7 for throughput")
+	fs.StringVar(&cfg.baselinePath, "compare-baseline", "", "compare throughput and p99 with a baseline file and exit 3 on regression")
+	fs.StringVar(&cfg.failThreshold, "fail-threshold", "10%", "regression tolerated by -compare-baseline, in percent")
+	fs.StringVar(&cfg.metricsAddr, "metrics-addr", "", "serve Prometheus metrics on this address during the run, e.g. :9100")
+	fs.StringVar(&cfg.cpuProfile, "cpuprofile", "", "write a CPU profile of the tool over the measured window to this file")
+	fs.StringVar(&cfg.memProfile, "memprofile", "", "write a heap profile of the tool, sampling the allocations of the measured window only, to this file")
+	fs.StringVar(&cfg.pprofAddr, "pprof-addr", "", "serve net/http/pprof for the tool on this address during the run, e.g. localhost:6060")
+	fs.StringVar(&cfg.mode, "mode", modeLocal, "local: run the benchmark; agent: wait on -listen for a coordinator to send a share of one; coordinator: split the run across -agents and merge their results")
+	fs.StringVar(&cfg.listen, "listen", ":7777", "address an agent serves its coordinators on")
+	fs.StringVar(&cfg.agentList, "agents", "", "comma-separated host:port of the agents of -mode coordinator")
+	fs.IntVar(&cfg.keyOffset, "key-offset", 0, "index of the first key of the keyspace, so agents each get a range of it (set by -mode coordinator)")
+	fs.IntVar(&cfg.clientOffset, "client-offset", 0, "number of the first client in keys, so agents write keys of their own (set by -mode coordinator)")
+	fs.StringVar(&cfg.metricsBuckets, "metrics-buckets", defaultMetricsBuckets, "upper bounds of the exported latency histogram")
+	fs.StringVar(&cfg.rawOut, "raw-out", "", "stream one CSV row per measured operation to this file, gzipped if it ends in .gz")
+	fs.StringVar(&cfg.hdrOut, "hdr-out", "", "write a histogram per second of the measured window and the cumulative one to this file in the HdrHistogram log format, tagged by command under a mix of commands")
+	fs.StringVar(&cfg.faultSpec, "fault-inject", "", "developer mode: inject faults into the commands, such as delay=0.01:50ms,drop=0.001,reset=0.001,corrupt=0.001, each a probability per command drawn from -seed; corrupt flips a bit of GET replies")
+	fs.BoolVar(&cfg.latencySplit, "latency-split", false, "break the latency of every command down into the wait for a pool connection and the round trip on it")
+	fs.StringVar(&cfg.timeSeriesOut, "timeseries-out", "", "write one CSV row per time series interval to this file, written as the run goes")
+	fs.StringVar(&cfg.heatmapOut, "heatmap-out", "", "write the latency heatmap, operations by interval and latency bucket, to this file as CSV")
+	fs.BoolVar(&cfg.heatmapJSON, "heatmap-json", false, "include the latency heatmap grid in the JSON report")
+	fs.DurationVar(&cfg.heatmapInterval, "heatmap-interval", time.Second, "length of the intervals of the latency heatmap")
+	fs.Int64Var(&cfg.seed, "seed", 0, "seed of all random generators, to replay a run exactly (default: random, printed in the summary)")
+	fs.DurationVar(&cfg.opTimeout, "op-timeout", 0, "fail an operation that takes longer than this and count it as timed out (0: no limit)")
+	fs.DurationVar(&cfg.rampUp, "ramp-up", 0, "start clients gradually over this long; measurement starts once all are running")
+	fs.IntVar(&cfg.rampSteps, "ramp-steps", 0, "start -ramp-up clients in this many equal groups (default: one client at a time)")
+	fs.StringVar(&cfg.client, "client", clientGoRedis, "client sending the workload: go-redis, or raw, a minimal RESP client with one connection per client for SET, GET and DEL")
+	fs.StringVar(&cfg.protocol, "protocol", protocolRedis, "front end of the server: redis (RESP through -client), or memcache, the memcached text protocol, for SET, GET, DEL, EXPIRE (touch) and MGET workloads, or http, PUT and GET of -http-path, for SET and GET workloads")
+	fs.StringVar(&cfg.protocol2, "protocol2", "", "front end of the -addr2 target, to compare two front ends of one server (default -protocol)")
+	fs.UintVar(&cfg.memcacheFlags, "memcache-flags", 0, "flags the memcache target stores with every value")
+	fs.BoolVar(&cfg.memcacheNoreply, "memcache-noreply", false, "send set, delete and touch with noreply, timing only their writes; their DEL and EXPIRE hits are not known and count as hits")
+	fs.IntVar(&cfg.memcacheMaxValue, "memcache-max-value", 1<<20, "largest value the memcached server stores, its -I; larger -value-size values are rejected, 0 leaves it to the server")
+	fs.StringVar(&cfg.httpPath, "http-path", "/keys", "path of the keys of the http target, PUT and GET <path>/{key}")
+	fs.IntVar(&cfg.httpMaxIdle, "http-max-idle", 0, "idle connections the http target keeps, its MaxIdleConnsPerHost (default -clients)")
+	fs.BoolVar(&cfg.http2, "http2", false, "negotiate HTTP/2 with the http target, over -tls; HTTP/1.1 otherwise")
+	fs.StringVar(&cfg.httpTTL, "http-ttl", httpTTLHeader, "how the http target sends the TTL of a PUT, in whole seconds: header or query")
+	fs.StringVar(&cfg.httpTTLName, "http-ttl-name", "", "name of the TTL header or query parameter of -http-ttl (default X-TTL, or ttl for query)")
+	fs.IntVar(&cfg.resp, "resp", resp2, "protocol to speak: 2, or 3, negotiated with HELLO 3 by -client raw, falling back to 2 when the server rejects it")
+	fs.IntVar(&cfg.resp2, "resp2", 0, "protocol of the -addr2 target; without -addr2, compares the two protocols against -addr")
+	fs.StringVar(&cfg.recordPath, "record", "", "write the commands the run sends, with their keys, value sizes and offsets, to this trace file")
+	fs.StringVar(&cfg.replayPath, "replay", "", "send the commands of a -record trace instead of generating a workload, each recorded connection on one client")
+	fs.StringVar(&cfg.replayTiming, "replay-timing", replayOriginal, "pace of -replay: original keeps the recorded offsets, fast sends every command as soon as its client is free")
+	fs.Float64Var(&cfg.replaySpeed, "replay-speed", 1, "with -replay-timing original, replay this many times faster than recorded; below 1 stretches the replay")
+	fs.StringVar(&cfg.monitorCommands, "monitor-commands", "", "replay only these commands of a MONITOR log, e.g. get,set (default: every supported one)")
+	fs.StringVar(&cfg.monitorRewrite, "monitor-rewrite", "", "rewrite key prefixes of a MONITOR log, e.g. prod:=bench: (comma-separated, first match wins)")
+	fs.Float64Var(&cfg.monitorValueScale, "monitor-value-scale", 1, "scale the SET value sizes of a MONITOR log by this factor")
+	fs.StringVar(&cfg.scenario, "scenario", "", "run the phases described in this file in order, or its tenants together; each sets flags over the command line")
+	fs.StringVar(&cfg.sweepClients, "sweep-clients", "", "run once per client count, e.g. 10,50,100, and print throughput and p99 per step")
+	fs.DurationVar(&cfg.sweepCooldown, "sweep-cooldown", 5*time.Second, "pause between -sweep-clients steps")
+	fs.StringVar(&cfg.sweepValueSizes, "sweep-value-size", "", "run once per value size in bytes, e.g. 64,1024,16384, and print latency and MB/s per step")
+	fs.StringVar(&cfg.sweepBatchKeys, "sweep-batch-keys", "", "run an mget or mset workload once per -batch-keys, e.g. 1,10,100,1000, and print keys/s and per-key latency per step")
+	fs.BoolVar(&cfg.sweepFlush, "sweep-flush", false, "FLUSHDB between -sweep-clients steps")
+	fs.StringVar(&cfg.searchSpec, "search", "", "search for the highest rate meeting these -sla style conditions, e.g. p99<2ms, with paced probes, and confirm it")
+	fs.Float64Var(&cfg.searchStart, "search-start", 1000, "rate of the first -search probe, doubled while probes pass and halved while they fail")
+	fs.DurationVar(&cfg.searchInterval, "search-interval", 10*time.Second, "measured length of a -search probe")
+	fs.DurationVar(&cfg.searchWarmup, "search-warmup", 2*time.Second, "unmeasured warmup at the rate of every -search probe")
+	fs.DurationVar(&cfg.searchCooldown, "search-cooldown", 2*time.Second, "idle pause between -search probes")
+	fs.DurationVar(&cfg.searchConfirm, "search-confirm", 30*time.Second, "length of the run confirming the rate -search found (0: none)")
+	fs.DurationVar(&cfg.searchBudget, "search-budget", 10*time.Minute, "total time -search may take, the confirmation included")
+	fs.Float64Var(&cfg.searchPrecision, "search-precision", 0.05, "stop bisecting once the lowest failing rate is within this fraction of the highest passing one")
+	fs.IntVar(&cfg.hotKeys, "hot-keys", 0, "number of keys in a hot pool shared by all clients (0: no hot keys)")
+	fs.Float64Var(&cfg.hotFraction, "hot-fraction", 0.9, "fraction of operations that target the -hot-keys pool")
+	fs.IntVar(&cfg.batchKeys, "batch-keys", 10, "number of keys per MGET or MSET")
+	fs.IntVar(&cfg.scanClients, "scan-clients", 1, "clients of the scan workload that run SCAN passes; the others run the -ratio foreground mix")
+	fs.IntVar(&cfg.scanCount, "scan-count", 100, "COUNT hint of every SCAN")
+	fs.StringVar(&cfg.scanMatch, "scan-match", "", "MATCH pattern of every SCAN (default: the keys of the keyspace)")
+	fs.DurationVar(&cfg.scanPause, "scan-pause", 0, "pause between SCAN passes, so the foreground also runs without a scan in progress")
+	fs.StringVar(&cfg.scriptPath, "script", "", "Lua file run by the script workload with EVALSHA (default: a built-in get-or-set)")
+	fs.IntVar(&cfg.scriptKeys, "script-keys", 1, "number of keyspace keys passed to every script call as KEYS")
+	fs.IntVar(&cfg.scriptArgs, "script-args", 1, "number of generated values passed to every script call as ARGV")
+	fs.IntVar(&cfg.pubsubChannels, "pubsub-channels", 1, "number of channels the pubsub workload publishes to")
+	fs.IntVar(&cfg.pubsubSubscribers, "pubsub-subscribers", 1, "subscriber connections per channel of the pubsub workload")
+	fs.DurationVar(&cfg.trackingStaleness, "tracking-staleness", 100*time.Millisecond, "how long after a SET the tracking workload's local caches may still hold an older value of the key before it counts as a violation")
+	fs.DurationVar(&cfg.pubsubDrain, "pubsub-drain", time.Second, "how long subscribers may take to receive the last messages after publishing stops")
+	fs.IntVar(&cfg.poolSize, "pool-size", 0, "connections in the client pool (default: 10 per CPU, or the client count of a -sweep-clients step)")
+	fs.IntVar(&cfg.minIdleConns, "min-idle-conns", 0, "idle connections the pool keeps open")
+	fs.DurationVar(&cfg.poolTimeout, "pool-timeout", 0, "how long an operation waits for a free pooled connection (default: 4s)")
+	fs.StringVar(&cfg.clientNamePrefix, "client-name", "", "name every connection with CLIENT SETNAME as <prefix>-<run ID>-w<client>, or -ctl outside the clients, to find the run in CLIENT LIST")
+	fs.BoolVar(&cfg.clientList, "client-list", false, "read CLIENT LIST after the run and report the connections of the run the server holds, their age and output buffers (requires -client-name)")
+	fs.BoolVar(&cfg.verify, "verify", false, "read SETs back on the same connection and compare the value byte for byte; mismatches and unexpected misses fail the run")
+	fs.Float64Var(&cfg.verifyFraction, "verify-fraction", 0.1, "fraction of SETs -verify reads back")
+	fs.BoolVar(&cfg.verifyFinal, "verify-final", false, "after the run, read back every key written and check it holds a value the workload can have left; discrepancies fail the run")
+	fs.StringVar(&cfg.verifyFinalOut, "verify-final-out", "verify-final.txt", "file -verify-final writes its first discrepancies to")
+	fs.BoolVar(&cfg.evictPressure, "evict-pressure", false, "fill the server with unique keys until it evicts or stops growing, then run the workload and report latency under eviction")
+	fs.Float64Var(&cfg.evictCheck, "evict-check", 0.01, "fraction of -evict-pressure SETs followed by a read of a recently written key, to count keys already evicted")
+	fs.DurationVar(&cfg.evictFillTimeout, "evict-fill-timeout", 5*time.Minute, "longest the -evict-pressure fill may take to reach eviction")
+	fs.BoolVar(&cfg.captureInfo, "capture-info", false, "record server INFO, DBSIZE and MEMORY STATS before, during and after the run")
+	fs.DurationVar(&cfg.infoInterval, "info-interval", 5*time.Second, "how often -capture-info takes a snapshot during the run")
+	fs.BoolVar(&cfg.probe, "probe", false, "PING the server in the background on a connection of its own, to compare the latency a lightly loaded client sees with the workload's")
+	fs.DurationVar(&cfg.probeInterval, "probe-interval", 100*time.Millisecond, "how often -probe sends a PING")
+	fs.IntVar(&cfg.retries, "retries", 0, "times an operation failing with connection loss or a timeout is retried before it counts as an error")
+	fs.StringVar(&cfg.retryBackoff, "retry-backoff", defaultRetryBackoff, "base:max exponential backoff with jitter between -retries attempts")
+	fs.BoolVar(&cfg.resilience, "resilience", false, "keep running through connection loss and report each outage, time to recover and latency after recovery")
+	fs.StringVar(&cfg.resilienceBackoff, "resilience-backoff", defaultResilienceBackoff, "min:max exponential backoff of a client after a failed reconnect under -resilience")
+	fs.StringVar(&cfg.outageCmd, "outage-cmd", "", "shell command run -outage-at into the measured run to cause an outage, e.g. \"systemctl restart htcache\"")
+	fs.DurationVar(&cfg.outageAt, "outage-at", 0, "when -outage-cmd runs, from the start of the measured window")
+	fs.BoolVar(&cfg.churn, "churn", false, "dial a fresh connection for every SET or GET and close it afterwards, timing connect and first command apart")
+	fs.IntVar(&cfg.hashFields, "hash-fields", 10, "number of fields per hash of the hash workload; -value-size sets the size of each field value")
+	fs.IntVar(&cfg.zsetSize, "zset-members", 100, "cardinality the zset workload seeds every sorted set to")
+	fs.IntVar(&cfg.zsetRange, "zset-range", 10, "number of top entries read by ZRANGE and ZREVRANGE")
+	fs.Float64Var(&cfg.zsetVerify, "zset-verify", 0.1, "fraction of ZRANGE and ZREVRANGE replies checked for correct ordering")
+	fs.IntVar(&cfg.appendChunkSize, "append-chunk", 64, "bytes every APPEND of the append workload adds, and every GETRANGE reads")
+	fs.IntVar(&cfg.appendMax, "append-max", 64<<10, "value length at which the append workload deletes the key and starts it over")
+	fs.Float64Var(&cfg.appendVerify, "append-verify", 0.1, "fraction of GETRANGE replies checked against the chunks appended")
+	fs.IntVar(&cfg.setSize, "set-members", 100, "cardinality the sets workload fills every set to")
+	fs.Float64Var(&cfg.setMissRatio, "set-miss-ratio", 0.5, "fraction of SISMEMBER queries for names that are not members")
+	fs.IntVar(&cfg.queueProducers, "queue-producers", 0, "clients of the queue workload that LPUSH; the others pop (default: half of -clients)")
+	fs.IntVar(&cfg.queueKeys, "queue-keys", 1, "number of lists the queue workload pushes to and pops from")
+	fs.DurationVar(&cfg.queueBlock, "queue-block", 0, "consume with BRPOP blocking this long, in whole seconds (0: RPOP)")
+	fs.DurationVar(&cfg.queueDepthInterval, "queue-depth-interval", time.Second, "how often the queue workload samples queue depth with LLEN")
+	fs.IntVar(&cfg.counterKeys, "counter-keys", 100, "number of counters INCRed by the incr workload, or incremented by the txn workload, and verified after the run")
+	fs.IntVar(&cfg.txnRetries, "txn-retries", 100, "retries of a txn transaction whose EXEC was aborted by a conflicting write before it fails")
+	fs.IntVar(&cfg.lockKeys, "lock-keys", 10, "number of locks the setnx workload contends on")
+	fs.DurationVar(&cfg.lockTTL, "lock-ttl", 10*time.Second, "TTL of a lock taken by the setnx workload")
+	fs.DurationVar(&cfg.lockHold, "lock-hold", 0, "how long the setnx workload holds an acquired lock before releasing it")
+	fs.BoolVar(&cfg.lockRelease, "lock-release", true, "release acquired locks with DEL; otherwise they are only freed by -lock-ttl")
+	fs.BoolVar(&cfg.force, "force", false, "start even though another run holds the run lock "+runLockKey+" of the server, without taking it")
+	fs.StringVar(&cfg.cleanup, "cleanup", "", "remove benchmark keys after the run: flush (FLUSHDB) or scan-del (SCAN + DEL of the tool's own keys)")
+	fs.StringVar(&cfg.out, "out", "", "write the report to this file instead of stdout")
+	fs.StringVar(&cfg.keyDist, "key-dist", keyDistUniform, "key distribution over -keyspace: uniform, sequential, zipfian, or the locality patterns scan, loop and lru-friendly")
+	fs.Float64Var(&cfg.zipfTheta, "zipf-theta", 0.99, "skew of the zipfian key distribution, in (0, 1)")
+	fs.IntVar(&cfg.loopKeys, "loop-keys", 0, "number of keys -key-dist loop cycles over, best slightly more than the cache holds")
+	fs.IntVar(&cfg.rerefWindow, "reref-window", 1000, "number of recent fresh keys -key-dist lru-friendly re-references")
+	fs.Float64Var(&cfg.rerefProb, "reref-prob", 0.9, "probability -key-dist lru-friendly re-references a key of -reref-window instead of drawing a fresh one")
+	fs.StringVar(&cfg.expireTTLRange, "expire-ttl", "1s:60s", "TTL range min:max assigned by EXPIRE operations")
+	fs.StringVar(&cfg.ttlRange, "ttl", "", "TTL applied to SET, fixed (10s) or a min:max range (default: no TTL, "+defaultChurnTTL+" under -workload churn)")
+	fs.BoolVar(&cfg.verifyExpiry, "verify-expiry", false, "after the run, check that a sample of keys written with -ttl expired on time")
+	fs.IntVar(&cfg.expirySample, "expiry-sample", 1000, "number of keys tracked by -verify-expiry")
+	fs.DurationVar(&cfg.expiryGrace, "expiry-grace", 10*time.Second, "how long past its TTL a key may survive before -verify-expiry gives up on it")
+	fs.IntVar(&cfg.ttlSample, "ttl-sample", 0, "sample the PTTL of this many keys written with -ttl during the run and report how far it drifts from the TTL set (0: off)")
+	fs.DurationVar(&cfg.ttlSampleInterval, "ttl-sample-interval", 100*time.Millisecond, "how often -ttl-sample reads the PTTL of its keys")
+	fs.Float64Var(&cfg.verifyExpired, "verify-expired", 0, "write this fraction of SETs under fresh keys with -expired-ttl and fail the run if a GET past the TTL and -expired-grace still finds one (0: off)")
+	fs.DurationVar(&cfg.expiredTTL, "expired-ttl", time.Second, "TTL of the SETs -verify-expired reads back")
+	fs.DurationVar(&cfg.expiredGrace, "expired-grace", 100*time.Millisecond, "how long past its TTL -verify-expired waits before it reads a key back")
+	fs.IntVar(&cfg.expiredSample, "expired-sample", 100000, "most keys -verify-expired tracks at a time; beyond it, the keys read back are a uniform sample")
+	fs.BoolVar(&cfg.soak, "soak", false, "record the goroutines, heap, GC pauses and open files of the tool in the time series, log its pool stats and report whether the client stayed healthy")
+	fs.DurationVar(&cfg.soakLogInterval, "soak-log-interval", time.Minute, "how often -soak logs the connection pool stats")
+	fs.IntVar(&cfg.soakGoroutines, "soak-max-goroutines", 100, "goroutines the tool may gain monotonically over a -soak before it warns")
+	fs.IntVar(&cfg.soakHeap, "soak-max-heap", 256, "MiB of heap in use the tool may gain monotonically over a -soak before it warns")
+	fs.IntVar(&cfg.soakFDs, "soak-max-fds", 50, "open file descriptors the tool may gain monotonically over a -soak before it warns")
+	fs.Float64Var(&cfg.soakGC, "soak-max-gc", 0.05, "fraction of a -soak the tool may spend in GC pauses before it warns")
+	fs.DurationVar(&cfg.churnInterval, "churn-interval", time.Second, "how often -workload churn samples DBSIZE and used_memory")
+	fs.StringVar(&cfg.shadowAddr, "shadow", "", "second server to send every write to as well, comparing the replies of -shadow-reads of the reads, in the form of -addr")
+	fs.Float64Var(&cfg.shadowReads, "shadow-reads", 0.1, "fraction of the reads -shadow also sends to the shadow and compares byte for byte")
+	fs.IntVar(&cfg.shadowQueue, "shadow-queue", 1024, "operations that may wait for the -shadow target before further ones are dropped")
+	fs.BoolVar(&cfg.watchNotifications, "watch-notifications", false, "subscribe to the expired and evicted keyevent notifications during the run and time those of a sample of the keys written with -ttl")
+	fs.StringVar(&cfg.ratio, "ratio", "", "command mix for a mixed or hash workload, e.g. get=0.9,set=0.1 (implies -workload mixed unless -workload hash)")
+	fs.Float64Var(&cfg.mixTolerance, "mix-tolerance", defaultMixTolerance, "largest share by which a command of the achieved mix may stray from the requested mix before the summary warns, e.g. 0.02 for 2 points")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool)
+	cfg.explicit = make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+		cfg.explicit[f.Name] = f.Value.String()
+	})
+	if set["duration"] && set["ops"] {
+		return nil, errors.New("-duration and -ops are mutually exclusive")
+	}
+	if set["value-size"] && set["value-size-range"] {
+		return nil, errors.New("-value-size and -value-size-range are mutually exclusive")
+	}
+	if set["unix-socket"] {
+		if set["addr"] {
+			return nil, errors.New("-addr and -unix-socket are mutually exclusive")
+		}
+		cfg.addr = unixScheme + cfg.unixSocket
+	}
+	if set["sentinel-master"] {
+		if set["addr"] || set["unix-socket"] {
+			return nil, errors.New("-sentinel-master replaces -addr and -unix-socket")
+		}
+		cfg.addr = sentinelScheme + cfg.sentinelMaster
+	}
+	if set["resp2"] && !set["addr2"] {
+		cfg.addr2 = cfg.addr
+	}
+	if !set["key-prefix"] {
+		cfg.keyPrefix = defaultKeyPrefix()
+	}
+	if set["keyspace"] && cfg.workload == workloadSet {
+		cfg.boundedSets = true
+		if !set["preload"] {
+			// The set workload writes its keyspace itself.
+			cfg.preload = 0
+		}
+	}
+	if !set["seed"] {
+		cfg.seed = time.Now().UnixNano()
+	}
+	if set["replay"] {
+		opts, err := cfg.parseMonitorOptions()
+		if err != nil {
+			return nil, err
+		}
+		if cfg.trace, err = readTraceFile(cfg.replayPath, opts); err != nil {
+			return nil, fmt.Errorf("-replay: %w", err)
+		}
+		cfg.applyTrace()
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// forTarget returns a copy of c aimed at addr. Everything else, including
+// the seed, is shared so the copy generates identical traffic.
+func (c *config) forTarget(addr string) *config {
+	t := *c
+	t.addr, t.addr2 = addr, ""
+	return &t
+}
+
+// parseDurationRange parses "min:max" durations such as "1s:60s". A single
+// duration is accepted as a fixed range.
+func parseDurationRange(s string) (time.Duration, time.Duration, error) {
+	lo, hi, ok := strings.Cut(s, ":")
+	if !ok {
+		hi = lo
+	}
+	min, err := time.ParseDuration(strings.TrimSpace(lo))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid duration range %q: %v", s, err)
+	}
+	max, err := time.ParseDuration(strings.TrimSpace(hi))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid duration range %q: %v", s, err)
+	}
+	if min < 0 || max < min {
+		return 0, 0, fmt.Errorf("invalid duration range %q: want 0 <= min <= max", s)
+	}
+	return min, max, nil
+}
+
+// validate rejects configurations that cannot produce a meaningful run.
+func (c *config) validate() error {
+	if c.addr == "" {
+		return errors.New("-addr must not be empty")
+	}
+	if c.addr2 == c.addr && (c.resp2 == 0 || c.resp2 == c.resp) && (c.protocol2 == "" || c.protocol2 == c.protocol) {
+		return errors.New("-addr2 must differ from -addr, -resp2 from -resp or -protocol2 from -protocol")
+	}
+	if err := c.loadTLS(); err != nil {
+		return err
+	}
+	for _, addr := range []string{c.addr, c.addr2} {
+		if network, path := splitAddr(addr); network == "unix" && path == "" {
+			return fmt.Errorf("%q names no socket path", addr)
+		}
+	}
+	if c.addr2 != "" && (c.saveBaseline != "" || c.baselinePath != "" || c.rawOut != "" || c.hdrOut != "") {
+		return errors.New("-save-baseline, -compare-baseline, -raw-out and -hdr-out cannot be combined with -addr2")
+	}
+	if c.scenario != "" {
+		if err := c.validateScenario(); err != nil {
+			return err
+		}
+	}
+	if c.sweepClients != "" || c.sweepValueSizes != "" || c.sweepBatchKeys != "" {
+		if c.addr2 != "" || c.saveBaseline != "" || c.baselinePath != "" || c.rawOut != "" || c.hdrOut != "" {
+			return errors.New("sweeps cannot be combined with -addr2, -save-baseline, -compare-baseline, -raw-out or -hdr-out")
+		}
+		n := 0
+		for _, s := range []string{c.sweepClients, c.sweepValueSizes, c.sweepBatchKeys} {
+			if s != "" {
+				n++
+			}
+		}
+		if n > 1 {
+			return errors.New("-sweep-clients, -sweep-value-size and -sweep-batch-keys are mutually exclusive")
+		}
+		if c.sweepValueSizes != "" && (c.valueSize != 0 || c.valueSizeRange != "") {
+			return errors.New("-sweep-value-size replaces -value-size and -value-size-range")
+		}
+		var err error
+		if c.sweepClients != "" {
+			if c.sweep, err = parseLevels(c.sweepClients); err != nil {
+				return fmt.Errorf("-sweep-clients: %w", err)
+			}
+		}
+		if c.sweepValueSizes != "" {
+			if c.sweepSizes, err = parseLevels(c.sweepValueSizes); err != nil {
+				return fmt.Errorf("-sweep-value-size: %w", err)
+			}
+		}
+		if c.sweepBatchKeys != "" {
+			if c.sweepBatches, err = parseLevels(c.sweepBatchKeys); err != nil {
+				return fmt.Errorf("-sweep-batch-keys: %w", err)
+			}
+		}
+		if c.sweepCooldown < 0 {
+			return fmt.Errorf("-sweep-cooldown must not be negative, got %v", c.sweepCooldown)
+		}
+	}
+	var err error
+	if c.threshold, err = parseThreshold(c.failThreshold); err != nil {
+		return fmt.Errorf("-fail-threshold: %w", err)
+	}
+	if c.buckets, err = parseBuckets(c.metricsBuckets); err != nil {
+		return fmt.Errorf("-metrics-buckets: %w", err)
+	}
+	if c.clients <= 0 {
+		return fmt.Errorf("-clients must be positive, got %d", c.clients)
+	}
+	if c.duration < 0 {
+		return fmt.Errorf("-duration must not be negative, got %v", c.duration)
+	}
+	if c.duration == 0 && c.opsPerClient <= 0 {
+		return fmt.Errorf("-ops must be positive, got %d", c.opsPerClient)
+	}
+	if c.db < 0 {
+		return fmt.Errorf("-db must not be negative, got %d", c.db)
+	}
+	if c.ratio != "" {
+		mix, err := parseRatio(c.ratio)
+		if err != nil {
+			return err
+		}
+		c.mix = mix
+		if !c.mixedCommands() {
+			c.workload = workloadMixed
+		}
+	}
+	if c.workload == workloadChurn {
+		if err := c.setupChurn(); err != nil {
+			return err
+		}
+	} else if _, ok := c.explicit["churn-interval"]; ok {
+		return errors.New("-churn-interval requires -workload churn")
+	}
+	switch c.workload {
+	case workloadSet:
+	case workloadMixed:
+		if c.mix == nil {
+			return errors.New("-workload mixed requires -ratio")
+		}
+	case workloadHash:
+		if c.mix == nil {
+			c.mix, _ = parseRatio(defaultHashRatio)
+		}
+	case workloadZSet:
+		if c.mix == nil {
+			c.mix, _ = parseRatio(defaultZSetRatio)
+		}
+	case workloadAppend:
+		if c.mix == nil {
+			c.mix, _ = parseRatio(defaultAppendRatio)
+		}
+		if err := c.validateAppend(); err != nil {
+			return err
+		}
+	case workloadSets:
+		if c.mix == nil {
+			c.mix, _ = parseRatio(defaultSetsRatio)
+		}
+	case workloadScan:
+		if c.mix == nil {
+			c.mix, _ = parseRatio(defaultScanForeground)
+		}
+		if err := c.validateScan(); err != nil {
+			return err
+		}
+	case workloadScript:
+		c.mix = singleOpMix(opScript)
+	case workloadTracking:
+		if c.mix == nil {
+			c.mix, _ = parseRatio(defaultTrackingRatio)
+		}
+		if _, ok := c.explicit["key-dist"]; !ok {
+			c.keyDist = keyDistZipfian
+		}
+		if err := c.validateTracking(); err != nil {
+			return err
+		}
+	case workloadPubSub:
+		// Every client publishes; there is no mix.
+		if err := c.validatePubSub(); err != nil {
+			return err
+		}
+	case workloadQueue:
+		// Each client's role picks its command; there is no mix.
+		if err := c.validateQueue(); err != nil {
+			return err
+		}
+	default:
+		// Any other workload issues a single command over the keyspace.
+		op, ok := parseOpType(c.workload)
+		if !ok || !singleOpWorkloads[op] {
+			return fmt.Errorf("-workload must be one of %s, got %q", workloadNames(), c.workload)
+		}
+		c.mix = singleOpMix(op)
+	}
+	if c.usesCounters() && c.counterKeys <= 0 {
+		return fmt.Errorf("-counter-keys must be positive, got %d", c.counterKeys)
+	}
+	if c.mix != nil && c.mix.share(opScript) > 0 {
+		if err := c.loadScript(); err != nil {
+			return err
+		}
+	}
+	if c.mix != nil && c.mix.share(opTxn) > 0 {
+		if err := c.validateTxn(); err != nil {
+			return err
+		}
+	}
+	if err := c.validatePool(); err != nil {
+		return err
+	}
+	if err := c.validateClientNames(); err != nil {
+		return err
+	}
+	if err := c.validateMixTolerance(); err != nil {
+		return err
+	}
+	if err := c.validateRetries(); err != nil {
+		return err
+	}
+	if c.probe {
+		if err := c.validateProbe(); err != nil {
+			return err
+		}
+	}
+	if c.captureInfo {
+		if err := c.validateInfo(); err != nil {
+			return err
+		}
+	}
+	if c.evictPressure {
+		if err := c.validateEvictPressure(); err != nil {
+			return err
+		}
+	}
+	if err := c.validateLoop(); err != nil {
+		return err
+	}
+	if err := c.validateRateScope(); err != nil {
+		return err
+	}
+	if err := c.validateInflight(); err != nil {
+		return err
+	}
+	if err := c.validateThinkTime(); err != nil {
+		return err
+	}
+	if err := c.validateOutliers(); err != nil {
+		return err
+	}
+	if err := c.validateWindow(); err != nil {
+		return err
+	}
+	if c.verify {
+		if err := c.validateVerify(); err != nil {
+			return err
+		}
+	}
+	if c.verifyFinal {
+		if err := c.validateVerifyFinal(); err != nil {
+			return err
+		}
+	}
+	if c.recordPath != "" {
+		if err := c.validateRecord(); err != nil {
+			return err
+		}
+	}
+	if err := c.validateResp(); err != nil {
+		return err
+	}
+	switch c.client {
+	case clientGoRedis:
+	case clientRaw:
+		if err := c.validateRawClient(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("-client must be %q or %q, got %q", clientGoRedis, clientRaw, c.client)
+	}
+	if err := c.validateProtocol(); err != nil {
+		return err
+	}
+	if c.churn {
+		if err := c.validateChurn(); err != nil {
+			return err
+		}
+	}
+	if c.mix != nil {
+		for op, workload := range workloadOps {
+			if c.mix.share(op) > 0 && c.workload != workload {
+				return fmt.Errorf("%s only runs in -workload %s", op, workload)
+			}
+		}
+	}
+	if c.mix != nil {
+		if err := c.validateKeyKinds(); err != nil {
+			return err
+		}
+	}
+	if c.usesHashes() {
+		if err := c.validateHashes(); err != nil {
+			return err
+		}
+	}
+	if c.usesZSets() {
+		if err := c.validateZSets(); err != nil {
+			return err
+		}
+	}
+	if c.usesSets() {
+		if c.setSize <= 0 {
+			return fmt.Errorf("-set-members must be positive, got %d", c.setSize)
+		}
+		if c.setMissRatio < 0 || c.setMissRatio > 1 {
+			return fmt.Errorf("-set-miss-ratio must be between 0 and 1, got %v", c.setMissRatio)
+		}
+	}
+	if c.usesBatches() {
+		if c.batchKeys <= 0 {
+			return fmt.Errorf("-batch-keys must be positive, got %d", c.batchKeys)
+		}
+	} else if c.sweepBatchKeys != "" {
+		return errors.New("-sweep-batch-keys requires a workload with MGET or MSET")
+	}
+	if c.mix != nil && c.mix.share(opSetNX) > 0 {
+		if err := c.validateLocks(); err != nil {
+			return err
+		}
+	}
+	if c.expireTTLRange != "" {
+		var err error
+		if c.expireTTLMin, c.expireTTLMax, err = parseDurationRange(c.expireTTLRange); err != nil {
+			return fmt.Errorf("-expire-ttl: %w", err)
+		}
+		if c.expireTTLMin <= 0 {
+			return errors.New("-expire-ttl: TTLs must be positive")
+		}
+	}
+	if c.ttlRange != "" {
+		var err error
+		if c.ttlMin, c.ttlMax, err = parseDurationRange(c.ttlRange); err != nil {
+			return fmt.Errorf("-ttl: %w", err)
+		}
+		if c.ttlMin <= 0 {
+			return errors.New("-ttl: TTLs must be positive")
+		}
+	}
+	if c.verifyExpiry {
+		if c.ttlMax == 0 {
+			return errors.New("-verify-expiry requires -ttl")
+		}
+		if c.workload != workloadSet || c.boundedSets {
+			// Overwrites in a shared keyspace legitimately extend a key's
+			// life, which would be reported as late expiry.
+			return errors.New("-verify-expiry requires -workload set without -keyspace, which writes every key once")
+		}
+		if c.expirySample <= 0 {
+			return fmt.Errorf("-expiry-sample must be positive, got %d", c.expirySample)
+		}
+	}
+	if c.opTimeout < 0 {
+		return fmt.Errorf("-op-timeout must not be negative, got %v", c.opTimeout)
+	}
+	if c.rampUp < 0 {
+		return fmt.Errorf("-ramp-up must not be negative, got %v", c.rampUp)
+	}
+	if c.rampSteps < 0 {
+		return fmt.Errorf("-ramp-steps must not be negative, got %d", c.rampSteps)
+	}
+	if c.warmup < 0 {
+		return fmt.Errorf("-warmup must not be negative, got %v", c.warmup)
+	}
+	if c.rate < 0 {
+		return fmt.Errorf("-rate must not be negative, got %v", c.rate)
+	}
+	if c.pipeline < 1 {
+		return fmt.Errorf("-pipeline must be at least 1, got %d", c.pipeline)
+	}
+	if c.maxErrorRate < 0 || c.maxErrorRate > 1 {
+		return fmt.Errorf("-max-error-rate must be between 0 and 1, got %v", c.maxErrorRate)
+	}
+	if err := c.validateErrorBudget(); err != nil {
+		return err
+	}
+	if c.cluster {
+		if err := c.validateCluster(); err != nil {
+			return err
+		}
+	}
+	if c.sentinelMaster != "" {
+		if err := c.validateSentinel(); err != nil {
+			return err
+		}
+	} else if c.sentinelAddrs != "" {
+		return errors.New("-sentinel-addrs requires -sentinel-master")
+	}
+	if err := c.validateResilience(); err != nil {
+		return err
+	}
+	switch c.cleanup {
+	case "", cleanupFlush:
+	case cleanupScanDel:
+		if c.keyPrefix == "" {
+			return errors.New("-cleanup scan-del needs a non-empty -key-prefix to scope the deletion")
+		}
+	default:
+		return fmt.Errorf("-cleanup must be %q or %q, got %q", cleanupFlush, cleanupScanDel, c.cleanup)
+	}
+	if c.output != outputText && c.output != outputJSON {
+		return fmt.Errorf("-output must be %q or %q, got %q", outputText, outputJSON, c.output)
+	}
+	if err := c.validateLargeValues(); err != nil {
+		return err
+	}
+	if err := c.validateLatency(); err != nil {
+		return err
+	}
+	if c.valueSize < 0 {
+		return fmt.Errorf("-value-size must not be negative, got %d", c.valueSize)
+	}
+	minSize, maxSize := c.valueSize, c.valueSize
+	if c.valueSizeRange != "" {
+		var err error
+		if minSize, maxSize, err = workload.ParseSizeRange(c.valueSizeRange); err != nil {
+			return fmt.Errorf("-value-size-range: %w", err)
+		}
+	}
+	for _, n := range c.sweepSizes {
+		// One pool covering the largest step is sliced for every step.
+		maxSize = max(maxSize, n)
+	}
+	if maxSize > 0 {
+		c.values = workload.NewValuePool(minSize, maxSize, rand.New(rand.NewSource(c.seed)))
+	}
+	if err := c.validateMemcacheValues(maxSize); err != nil {
+		return err
+	}
+	if c.trace != nil {
+		if err := c.validateReplay(); err != nil {
+			return err
+		}
+	} else {
+		for _, name := range replayOnly {
+			if _, ok := c.explicit[name]; ok {
+				return fmt.Errorf("-%s requires -replay", name)
+			}
+		}
+	}
+	if c.preload < 0 {
+		return fmt.Errorf("-preload must not be negative, got %d", c.preload)
+	}
+	if c.keyspace < 0 {
+		return fmt.Errorf("-keyspace must not be negative, got %d", c.keyspace)
+	}
+	if c.keyspace == 0 {
+		c.keyspace = c.preload
+	}
+	if c.usesKeyspace() && c.keyspace == 0 {
+		return fmt.Errorf("a %s workload needs a non-empty keyspace: set -preload or -keyspace", c.workload)
+	}
+	if err := c.validateKeySize(); err != nil {
+		return err
+	}
+	if err := c.validateTemplates(); err != nil {
+		return err
+	}
+	if c.usesHashes() || c.usesZSets() || c.usesSets() {
+		c.checkFullKeys = c.keyspaceComplete()
+	}
+	if c.usesKeyspace() {
+		if err := c.validateKeyDist(); err != nil {
+			return err
+		}
+	}
+	if c.hotKeys < 0 {
+		return fmt.Errorf("-hot-keys must not be negative, got %d", c.hotKeys)
+	}
+	if c.hotKeys > 0 {
+		if c.hotFraction < 0 || c.hotFraction > 1 {
+			return fmt.Errorf("-hot-fraction must be between 0 and 1, got %v", c.hotFraction)
+		}
+		if c.workload != workloadSet && !c.usesKeyspace() {
+			return fmt.Errorf("-hot-keys does not apply to the %s workload", c.workload)
+		}
+		if c.hotKeys > c.keyspace {
+			return fmt.Errorf("-hot-keys %d exceeds the keyspace of %d keys", c.hotKeys, c.keyspace)
+		}
+		c.hotPool = workload.NewHotPool(c.hotKeys, c.keyspace, c.seed)
+	}
+	if err := c.validateDBs(); err != nil {
+		return err
+	}
+	if err := c.validateNotifications(); err != nil {
+		return err
+	}
+	if err := c.validateTTLSample(); err != nil {
+		return err
+	}
+	if err := c.validateReadAfterExpiry(); err != nil {
+		return err
+	}
+	if err := c.validateShadow(); err != nil {
+		return err
+	}
+	if err := c.validateSizeBuckets(); err != nil {
+		return err
+	}
+	if err := c.validateSoak(); err != nil {
+		return err
+	}
+	if err := c.validateStore(); err != nil {
+		return err
+	}
+	if err := c.validatePush(); err != nil {
+		return err
+	}
+	if err := c.validateSteadyState(); err != nil {
+		return err
+	}
+	if err := c.validateLatencySplit(); err != nil {
+		return err
+	}
+	if err := c.validateFaults(); err != nil {
+		return err
+	}
+	if err := c.validateHeatmap(); err != nil {
+		return err
+	}
+	if err := c.validateTimeSeriesOut(); err != nil {
+		return err
+	}
+	if err := c.validateHTMLReport(); err != nil {
+		return err
+	}
+	if err := c.validateLogging(); err != nil {
+		return err
+	}
+	if err := c.validateProfile(); err != nil {
+		return err
+	}
+	if err := c.validateDistributed(); err != nil {
+		return err
+	}
+	if err := c.validateSearch(); err != nil {
+		return err
+	}
+	return c.validateSLA()
+}
+
+// validateLocks checks the flags of the setnx workload.
+func (c *config) validateLocks() error {
+	if c.lockKeys <= 0 {
+		return fmt.Errorf("-lock-keys must be positive, got %d", c.lockKeys)
+	}
+	if c.lockTTL < time.Millisecond {
+		return fmt.Errorf("-lock-ttl must be at least 1ms, got %v", c.lockTTL)
+	}
+	if c.lockHold < 0 {
+		return fmt.Errorf("-lock-hold must not be negative, got %v", c.lockHold)
+	}
+	if c.pipeline > 1 {
+		// Each acquisition is read back before the next SETNX.
+		return errors.New("-pipeline does not apply to SETNX")
+	}
+	return nil
+}
+
+// validateHashes checks the flags of a workload over a keyspace of hashes.
+func (c *config) validateHashes() error {
+	if c.hashFields <= 0 {
+		return fmt.Errorf("-hash-fields must be positive, got %d", c.hashFields)
+	}
+	return nil
+}
+
+// validateZSets checks the flags of a workload over a keyspace of sorted
+// sets.
+func (c *config) validateZSets() error {
+	if c.zsetSize <= 0 {
+		return fmt.Errorf("-zset-members must be positive, got %d", c.zsetSize)
+	}
+	if c.zsetRange <= 0 {
+		return fmt.Errorf("-zset-range must be positive, got %d", c.zsetRange)
+	}
+	if c.zsetVerify < 0 || c.zsetVerify > 1 {
+		return fmt.Errorf("-zset-verify must be between 0 and 1, got %v", c.zsetVerify)
+	}
+	return nil
+}
+
+// validateQueue checks the flags of the queue workload.
+func (c *config) validateQueue() error {
+	if c.clients < 2 {
+		return fmt.Errorf("-workload %s needs at least 2 clients, got %d", workloadQueue, c.clients)
+	}
+	if p := c.producers(); p < 1 || p >= c.clients {
+		return fmt.Errorf("-queue-producers must be between 1 and %d, got %d", c.clients-1, p)
+	}
+	if c.queueKeys <= 0 {
+		return fmt.Errorf("-queue-keys must be positive, got %d", c.queueKeys)
+	}
+	if c.queueBlock < 0 || c.queueBlock%time.Second != 0 {
+		return fmt.Errorf("-queue-block must be a whole number of seconds, got %v", c.queueBlock)
+	}
+	if c.queueDepthInterval <= 0 {
+		return fmt.Errorf("-queue-depth-interval must be positive, got %v", c.queueDepthInterval)
+	}
+	return nil
+}
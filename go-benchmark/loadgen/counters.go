@@ -0,0 +1,140 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// exitVerifyFailed is the exit status of a run whose end-of-run data check
+// failed: INCR or transaction counters that do not add up or queue
+// messages unaccounted for.
+const exitVerifyFailed = 4
+
+// counterBatch is the number of counters reset or read per round trip.
+const counterBatch = 1000
+
+// counterName returns the key of counter i of the -counter-keys pool.
+func (c *config) counterName(i int) string {
+	return c.keyPrefix + "counter" + strconv.Itoa(i)
+}
+
+// counterTally counts the INCRs a worker sent to each counter. Failed INCRs
+// are kept apart because the server may or may not have applied them.
+type counterTally struct {
+	ok     []int64
+	failed []int64
+}
+
+func newCounterTally(n int) *counterTally {
+	return &counterTally{ok: make([]int64, n), failed: make([]int64, n)}
+}
+
+func (t *counterTally) merge(o *counterTally) {
+	for i := range o.ok {
+		t.ok[i] += o.ok[i]
+		t.failed[i] += o.failed[i]
+	}
+}
+
+// counterReport is the result of checking every counter after the run.
+type counterReport struct {
+	Counters int `json:"counters"`
+	// Increments is the number of successful INCRs, warmup included.
+	Increments int64             `json:"increments"`
+	Mismatches []counterMismatch `json:"mismatches,omitempty"`
+	// Error is set when the counters could not be read back.
+	Error string `json:"error,omitempty"`
+}
+
+// counterMismatch is a counter whose value is outside the range its INCRs
+// allow: at least Expected, and at most Expected plus the INCRs that failed
+// without a reply.
+type counterMismatch struct {
+	Key      string `json:"key"`
+	Expected int64  `json:"expected"`
+	Failed   int64  `json:"failed,omitempty"`
+	Actual   int64  `json:"actual"`
+}
+
+func (r *counterReport) failed() bool {
+	return len(r.Mismatches) > 0 || r.Error != ""
+}
+
+// resetCounters deletes every counter so that the run starts from zero.
+func resetCounters(ctx context.Context, rdb redis.UniversalClient, cfg *config) error {
+	for from := 0; from < cfg.counterKeys; from += counterBatch {
+		// One DEL per counter, as a cluster spreads them over slots.
+		pipe := rdb.Pipeline()
+		for i := from; i < from+counterBatch && i < cfg.counterKeys; i++ {
+			pipe.Del(ctx, cfg.counterName(i))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("reset counters: %w", err)
+		}
+	}
+	return nil
+}
+
+// verifyCounters reads every counter back and compares it with the INCRs
+// the workers counted.
+func verifyCounters(ctx context.Context, rdb redis.UniversalClient, cfg *config, t *counterTally) *counterReport {
+	rep := &counterReport{Counters: cfg.counterKeys}
+	for i := range t.ok {
+		rep.Increments += t.ok[i]
+	}
+	for from := 0; from < cfg.counterKeys; from += counterBatch {
+		pipe := rdb.Pipeline()
+		var cmds []*redis.StringCmd
+		for i := from; i < from+counterBatch && i < cfg.counterKeys; i++ {
+			cmds = append(cmds, pipe.Get(ctx, cfg.counterName(i)))
+		}
+		_, _ = pipe.Exec(ctx)
+		for j, cmd := range cmds {
+			actual, err := cmd.Int64()
+			if isMiss(err) {
+				// Never incremented.
+				actual, err = 0, nil
+			}
+			if err != nil {
+				rep.Error = fmt.Sprintf("read %s: %v", cmd.Args()[1], err)
+				return rep
+			}
+			i := from + j
+			if m, ok := checkCounter(cfg.counterName(i), t.ok[i], t.failed[i], actual); !ok {
+				rep.Mismatches = append(rep.Mismatches, m)
+			}
+		}
+	}
+	return rep
+}
+
+// checkCounter reports whether actual is a value the counter can hold after
+// ok successful and failed unacknowledged INCRs.
+func checkCounter(key string, ok, failed, actual int64) (counterMismatch, bool) {
+	m := counterMismatch{Key: key, Expected: ok, Failed: failed, Actual: actual}
+	return m, actual >= ok && actual <= ok+failed
+}
+
+// printCounterReport writes the counter verification section.
+func printCounterReport(w io.Writer, rep *counterReport) {
+	fmt.Fprintf(w, "Counter verification (%d counters, %d increments):", rep.Counters, rep.Increments)
+	switch {
+	case rep.Error != "":
+		fmt.Fprintf(w, " FAILED: %s\n", rep.Error)
+	case len(rep.Mismatches) > 0:
+		fmt.Fprintf(w, " FAILED: %d counters do not match\n", len(rep.Mismatches))
+		for _, m := range rep.Mismatches {
+			if m.Failed > 0 {
+				fmt.Fprintf(w, "  %s: expected %d-%d, actual %d\n", m.Key, m.Expected, m.Expected+m.Failed, m.Actual)
+			} else {
+				fmt.Fprintf(w, "  %s: expected %d, actual %d\n", m.Key, m.Expected, m.Actual)
+			}
+		}
+	default:
+		fmt.Fprintln(w, " OK")
+	}
+}
@@ -0,0 +1,64 @@
+package loadgen
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckCounter(t *testing.T) {
+	tests := []struct {
+		ok, failed, actual int64
+		want               bool
+	}{
+		{ok: 10, actual: 10, want: true},
+		{ok: 10, actual: 9, want: false},
+		{ok: 10, actual: 11, want: false},
+		// Failed INCRs may or may not have been applied.
+		{ok: 10, failed: 2, actual: 10, want: true},
+		{ok: 10, failed: 2, actual: 12, want: true},
+		{ok: 10, failed: 2, actual: 13, want: false},
+	}
+	for _, tt := range tests {
+		if _, got := checkCounter("c", tt.ok, tt.failed, tt.actual); got != tt.want {
+			t.Errorf("checkCounter(ok=%d, failed=%d, actual=%d) = %v, want %v", tt.ok, tt.failed, tt.actual, got, tt.want)
+		}
+	}
+}
+
+func TestIncrCountersVerify(t *testing.T) {
+	addr := newTestServerAddr(t)
+	cfg := testConfig(t, "-addr", addr, "-workload", "incr", "-clients", "8", "-ops", "50",
+		"-counter-keys", "5", "-warmup", "20ms")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep := res.counters
+	if rep == nil || rep.failed() {
+		t.Fatalf("counter report = %+v, want a successful check", rep)
+	}
+	if want := res.total.warmupOps + res.total.latency.Count(); rep.Increments != want {
+		t.Errorf("verified %d increments, want %d including warmup", rep.Increments, want)
+	}
+	if res.preload != nil {
+		t.Error("incr workload preloaded the keyspace")
+	}
+}
+
+func TestVerifyCountersReportsMismatch(t *testing.T) {
+	mr, rdb := newTestServer(t)
+	cfg := testConfig(t, "-workload", "incr", "-counter-keys", "2", "-key-prefix", "p:")
+	mr.Set("p:counter0", "3")
+	mr.Set("p:counter1", "7")
+	tally := newCounterTally(2)
+	tally.ok[0], tally.ok[1] = 3, 5
+
+	rep := verifyCounters(context.Background(), rdb, cfg, tally)
+
+	want := counterMismatch{Key: "p:counter1", Expected: 5, Actual: 7}
+	if len(rep.Mismatches) != 1 || rep.Mismatches[0] != want {
+		t.Errorf("mismatches = %+v, want [%+v]", rep.Mismatches, want)
+	}
+}
@@ -0,0 +1,185 @@
+package loadgen
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+// parseDBList parses the databases of -dbs, a comma-separated list of
+// numbers and ranges such as 0-3,8.
+func parseDBList(s string) ([]int, error) {
+	var dbs []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(part), "-")
+		first, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("invalid database %q", part)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil {
+				return nil, fmt.Errorf("invalid database range %q", part)
+			}
+		}
+		if first < 0 || last < first {
+			return nil, fmt.Errorf("invalid database range %q: want 0 <= first <= last", part)
+		}
+		for db := first; db <= last; db++ {
+			if seen[db] {
+				return nil, fmt.Errorf("database %d listed twice", db)
+			}
+			seen[db] = true
+			dbs = append(dbs, db)
+		}
+	}
+	return dbs, nil
+}
+
+// validateDBs checks -dbs. Its databases each get a client of their own, so
+// no connection switches with SELECT, and the clients of the run are dealt
+// to them in turn.
+func (c *config) validateDBs() error {
+	if c.dbList == "" {
+		return nil
+	}
+	if _, ok := c.explicit["db"]; ok {
+		return errors.New("-dbs replaces -db")
+	}
+	var err error
+	if c.dbs, err = parseDBList(c.dbList); err != nil {
+		return fmt.Errorf("-dbs: %w", err)
+	}
+	switch {
+	case c.cluster:
+		return errors.New("-dbs does not apply to -cluster, which only has database 0")
+	case c.client == clientRaw, c.churn, c.resilience, c.workload == workloadTracking:
+		return errors.New("-dbs shares a client per database, which -client raw, -churn, -resilience and the tracking workload do not use")
+	case c.usesCounters(), c.workload == workloadQueue, c.workload == workloadPubSub:
+		return fmt.Errorf("-dbs does not apply to the %s workload, whose keys are checked in one database", c.workload)
+	case c.verifyExpiry, c.verifyFinal, c.evictPressure, c.recordPath != "", c.trace != nil:
+		return errors.New("-dbs does not apply to -verify-expiry, -verify-final, -evict-pressure, -record or -replay, which follow one database")
+	case c.clients < len(c.dbs):
+		return fmt.Errorf("-dbs lists %d databases for %d clients", len(c.dbs), c.clients)
+	}
+	// The client of the run, which prepares and inspects the server, works
+	// on the first database.
+	c.db = c.dbs[0]
+	return nil
+}
+
+// forDB returns a copy of c working on database db.
+func (c *config) forDB(db int) *config {
+	t := *c
+	t.db = db
+	return &t
+}
+
+// dbOf returns the index in -dbs of the database of client i.
+func (c *config) dbOf(i int) int {
+	return i % len(c.dbs)
+}
+
+// openDBClients opens the client of every database of -dbs, of which the
+// first is rdb, the client of the run.
+func openDBClients(cfg *config, rdb redis.UniversalClient) []redis.UniversalClient {
+	clients := []redis.UniversalClient{rdb}
+	for _, db := range cfg.dbs[1:] {
+		c, _ := newClient(cfg.forDB(db))
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// closeDBClients closes the clients opened by openDBClients but the first.
+func closeDBClients(clients []redis.UniversalClient) {
+	for _, c := range clients[1:] {
+		c.Close()
+	}
+}
+
+// add accounts for the preload of another database in p.
+func (p *preloadResult) add(o *preloadResult) {
+	p.keys += o.keys
+	p.written += o.written
+	p.failed += o.failed
+	p.elapsed += o.elapsed
+	if o.evicted >= 0 {
+		p.evicted = max(p.evicted, 0) + o.evicted
+	}
+	if p.oom == nil {
+		p.oom = o.oom
+	}
+}
+
+// dbStats are the measured operations of the clients of one database.
+type dbStats struct {
+	db      int
+	clients int
+	latency *stats.Histogram
+	errors  int64
+}
+
+// collectDBs splits the worker results of a -dbs run by database.
+func collectDBs(cfg *config, results []*workerResult) []dbStats {
+	dbs := make([]dbStats, len(cfg.dbs))
+	for i, db := range cfg.dbs {
+		dbs[i] = dbStats{db: db, latency: cfg.layout.New()}
+	}
+	for i, r := range results {
+		s := &dbs[cfg.dbOf(i)]
+		s.clients++
+		s.latency.Merge(r.latency)
+		s.errors += r.errors()
+	}
+	return dbs
+}
+
+// jsonDB is one database of the -dbs section of the JSON report.
+type jsonDB struct {
+	DB         int             `json:"db"`
+	Clients    int             `json:"clients"`
+	Ops        int64           `json:"ops"`
+	Errors     int64           `json:"errors"`
+	Throughput float64         `json:"throughput_ops_per_sec"`
+	Latency    *latencySummary `json:"latency"`
+}
+
+func buildDBs(res *runResult) []jsonDB {
+	var dbs []jsonDB
+	for _, s := range res.dbs {
+		j := jsonDB{DB: s.db, Clients: s.clients, Ops: s.latency.Count(), Errors: s.errors, Latency: summarizeLatency(s.latency)}
+		if elapsed := res.elapsed(); elapsed > 0 {
+			j.Throughput = float64(j.Ops) / elapsed.Seconds()
+		}
+		dbs = append(dbs, j)
+	}
+	return dbs
+}
+
+// printDBs breaks the summary of a -dbs run down by database, the way
+// printCommandBreakdown does by command.
+func printDBs(w io.Writer, u latencyUnit, res *runResult, elapsed time.Duration) {
+	if res.dbs == nil {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "db\tops\tops/s\tshare\terrors\tmean\tp50\tp99\tmax\t")
+	total := res.total.attempts()
+	for _, s := range res.dbs {
+		printBreakdownRow(tw, u, strconv.Itoa(s.db), s.latency, s.errors, total, elapsed)
+		fmt.Fprintln(tw)
+	}
+	printBreakdownRow(tw, u, "all", res.total.latency, res.total.errors(), total, elapsed)
+	fmt.Fprintln(tw)
+	tw.Flush()
+}
@@ -0,0 +1,92 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDBList(t *testing.T) {
+	for s, want := range map[string][]int{
+		"3":        {3},
+		"0-3":      {0, 1, 2, 3},
+		"0, 2,4-5": {0, 2, 4, 5},
+	} {
+		got, err := parseDBList(s)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%q parsed as %v, %v", s, got, err)
+		}
+	}
+	for _, s := range []string{"", "a", "3-1", "-1", "0-", "1,0-2"} {
+		if _, err := parseDBList(s); err == nil {
+			t.Errorf("%q was accepted", s)
+		}
+	}
+	for _, args := range [][]string{
+		{"-dbs", "0-1", "-db", "1"},
+		{"-dbs", "0-3", "-clients", "2"},
+		{"-dbs", "0-1", "-clients", "2", "-cluster"},
+		{"-dbs", "0-1", "-clients", "2", "-churn"},
+		{"-dbs", "0-1", "-clients", "2", "-workload", "incr"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
+
+func TestDBsRun(t *testing.T) {
+	mr, _ := newTestServer(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-dbs", "1-3", "-clients", "6", "-ops", "10", "-workload", "get", "-preload", "5")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every database got the keyspace, and every client read its own.
+	for db := 0; db <= 4; db++ {
+		want := 5
+		if db == 0 || db == 4 {
+			want = 0
+		}
+		if n := len(mr.DB(db).Keys()); n != want {
+			t.Errorf("db %d holds %d keys, want %d", db, n, want)
+		}
+	}
+	if res.preload == nil || res.preload.written != 15 {
+		t.Errorf("preload %+v", res.preload)
+	}
+	if s := res.total.ops[opGet]; s.hits != 60 {
+		t.Errorf("%d of 60 GETs hit", s.hits)
+	}
+	rep := buildReport(cfg, res)
+	if len(rep.DBs) != 3 || rep.Config.DBs != "1-3" {
+		t.Fatalf("dbs %+v of %q", rep.DBs, rep.Config.DBs)
+	}
+	for i, d := range rep.DBs {
+		if d.DB != i+1 || d.Clients != 2 || d.Ops != 20 || d.Latency == nil {
+			t.Errorf("db %d: %+v", i+1, d)
+		}
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "(dbs 1-3,") || !strings.Contains(buf.String(), "33.3%") {
+		t.Errorf("summary lacks the databases:\n%s", buf.String())
+	}
+
+	// Cleanup covers every database.
+	cfg = testConfig(t, "-addr", mr.Addr(), "-dbs", "1-3", "-clients", "3", "-ops", "10", "-keyspace", "10", "-cleanup", "scan-del",
+		"-key-prefix", cfg.keyPrefix)
+	if res, err = runTarget(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	for db := 1; db <= 3; db++ {
+		if keys := mr.DB(db).Keys(); len(keys) != 0 {
+			t.Errorf("db %d kept %v", db, keys)
+		}
+	}
+	if res.cleanup == nil || res.cleanup.removed < 15 || res.cleanup.err != nil {
+		t.Errorf("cleanup %+v", res.cleanup)
+	}
+}
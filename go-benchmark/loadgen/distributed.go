@@ -0,0 +1,887 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+// Values of -mode.
+const (
+	modeLocal       = "local"
+	modeAgent       = "agent"
+	modeCoordinator = "coordinator"
+)
+
+const (
+	// agentStatsInterval is how often a running agent sends its live
+	// counters, which double as its heartbeat.
+	agentStatsInterval = time.Second
+	// agentStartLead is how far ahead of the handshakes the coordinator
+	// schedules the start, so every agent has its spec in time.
+	agentStartLead = time.Second
+	// handshakeRounds is the number of clock readings taken of each agent;
+	// the one with the shortest round trip is kept.
+	handshakeRounds = 5
+)
+
+// agentTimeout is how long the coordinator waits for a message of an agent
+// before giving it up as lost. A variable so tests need not wait as long.
+var agentTimeout = 10 * time.Second
+
+// coordinatorFlags are the flags the coordinator keeps to itself: its mode,
+// the outputs of the merged report, and the settings split across the
+// agents, which are given their share instead.
+var coordinatorFlags = map[string]bool{
+	"mode": true, "agents": true, "listen": true,
+	"output": true, "out": true, "report": true, "store": true, "push": true, "push-series": true, "run-tag": true, "tag": true,
+	"sla": true, "save-baseline": true, "compare-baseline": true, "fail-threshold": true,
+	"metrics-buckets": true, "pprof-addr": true,
+	"progress": true, "log-level": true, "log-sample": true, "quiet": true,
+	"clients": true, "seed": true, "key-prefix": true, "keyspace": true, "preload": true, "rate": true,
+	"key-offset": true, "client-offset": true,
+}
+
+// distributedUnsupported are the flags whose results the agents do not
+// send back, or that need one process to see every client.
+var distributedUnsupported = []string{
+	"addr2", "resp2", "scenario", "sweep-clients", "sweep-value-size", "sweep-batch-keys",
+	"record", "replay", "raw-out", "hdr-out", "heatmap-out", "heatmap-json", "timeseries-out", "fault-inject", "latency-split", "steady-state", "pattern", "max-inflight", "queue-timeout", "cpuprofile", "memprofile",
+	"verify", "verify-final", "verify-expiry", "fairness", "capture-outliers", "capture-info", "probe",
+	"evict-pressure", "resilience", "outage-cmd", "sentinel-master", "cleanup", "churn", "retries", "hot-keys", "metrics-addr", "dbs", "large-values", "watch-notifications", "collect-slowlog", "ttl-sample", "verify-expired", "shadow", "soak", "client-list",
+}
+
+// validateDistributed checks -mode and the flags of the agents and
+// coordinator.
+func (c *config) validateDistributed() error {
+	_, listen := c.explicit["listen"]
+	switch c.mode {
+	case modeLocal:
+		if listen {
+			return errors.New("-listen requires -mode agent")
+		}
+		if c.agentList != "" {
+			return errors.New("-agents requires -mode coordinator")
+		}
+		if c.keyOffset < 0 || c.clientOffset < 0 {
+			return errors.New("-key-offset and -client-offset must not be negative")
+		}
+		return nil
+	case modeAgent:
+		if c.agentList != "" {
+			return errors.New("-agents requires -mode coordinator")
+		}
+		return nil
+	case modeCoordinator:
+	default:
+		return fmt.Errorf("-mode must be %s, %s or %s, got %q", modeLocal, modeAgent, modeCoordinator, c.mode)
+	}
+	if listen {
+		return errors.New("-listen requires -mode agent")
+	}
+	c.agents = nil
+	for _, a := range strings.Split(c.agentList, ",") {
+		if a = strings.TrimSpace(a); a == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(a); err != nil {
+			return fmt.Errorf("-agents: %q is not host:port", a)
+		}
+		for _, b := range c.agents {
+			if a == b {
+				return fmt.Errorf("-agents lists %s twice", a)
+			}
+		}
+		c.agents = append(c.agents, a)
+	}
+	if len(c.agents) == 0 {
+		return errors.New("-mode coordinator requires -agents host1:7777,host2:7777")
+	}
+	for _, name := range distributedUnsupported {
+		if _, ok := c.explicit[name]; ok {
+			return fmt.Errorf("-%s cannot be combined with -mode coordinator", name)
+		}
+	}
+	switch {
+	case c.workload == workloadQueue, c.workload == workloadPubSub, c.workload == workloadScan, c.workload == workloadScript:
+		return fmt.Errorf("the %s workload needs every client in one process: no -mode coordinator", c.workload)
+	case c.usesCounters(), c.mix != nil && c.mix.share(opSetNX) > 0:
+		return errors.New("counters and locks are verified by one process: INCR, txn and SETNX cannot run with -mode coordinator")
+	case c.loop == loopOpen:
+		return errors.New("-loop open cannot be combined with -mode coordinator")
+	case c.usesKeyspace() && c.localityDist():
+		return fmt.Errorf("-key-dist %s follows re-reference distances across every client in one process: no -mode coordinator", c.keyDist)
+	case c.workload == workloadAppend:
+		return errors.New("the agents do not send back the APPEND latency by value length: no -workload append with -mode coordinator")
+	case c.expireChurn:
+		return errors.New("every agent would sample the same server: no -workload churn with -mode coordinator")
+	case c.clients < len(c.agents):
+		return fmt.Errorf("-clients %d leaves some of the %d agents idle", c.clients, len(c.agents))
+	case c.usesKeyspace() && c.keyspace < len(c.agents):
+		return fmt.Errorf("-keyspace %d cannot be split across %d agents", c.keyspace, len(c.agents))
+	}
+	return nil
+}
+
+// agentSpec is what the coordinator asks of an agent: the command line of
+// its share of the run and when to start it, by the agent's clock.
+type agentSpec struct {
+	Args    []string  `json:"args"`
+	StartAt time.Time `json:"start_at"`
+}
+
+// agentHello answers the handshake.
+type agentHello struct {
+	Version string    `json:"version"`
+	Time    time.Time `json:"time"`
+}
+
+// agentMessage is a line of the stream an agent answers a run with: live
+// counters every agentStatsInterval, then the result or the error that
+// ended the run.
+type agentMessage struct {
+	Stats  *agentStats  `json:"stats,omitempty"`
+	Result *agentResult `json:"result,omitempty"`
+	Error  string       `json:"error,omitempty"`
+}
+
+type agentStats struct {
+	Ops       int64 `json:"ops"`
+	Errors    int64 `json:"errors"`
+	Measuring bool  `json:"measuring"`
+}
+
+// agentResult carries the part of a runResult the coordinator merges.
+type agentResult struct {
+	Start       time.Time         `json:"start"`
+	End         time.Time         `json:"end"`
+	Partial     bool              `json:"partial"`
+	AbortReason string            `json:"abort_reason,omitempty"`
+	WarmupNs    int64             `json:"warmup_ns,omitempty"`
+	Backlog     int64             `json:"backlog,omitempty"`
+	Latency     *stats.Encoded    `json:"latency"`
+	Response    *stats.Encoded    `json:"response,omitempty"`
+	Batch       *stats.Encoded    `json:"batch,omitempty"`
+	Ops         []agentOpStats    `json:"ops"`
+	ErrClasses  map[string]int64  `json:"error_classes,omitempty"`
+	Bytes       int64             `json:"bytes_written"`
+	BytesRead   int64             `json:"bytes_read"`
+	WarmupOps   int64             `json:"warmup_ops"`
+	Series      []timePoint       `json:"series"`
+	SeriesFrom  float64           `json:"series_from"`
+	Slowest     *jsonAgentSlowest `json:"slowest,omitempty"`
+	// Keys holds the registers of the agent's key sketch.
+	Keys []byte `json:"keys,omitempty"`
+}
+
+type agentOpStats struct {
+	Op       string         `json:"op"`
+	Latency  *stats.Encoded `json:"latency,omitempty"`
+	Hits     int64          `json:"hits"`
+	Misses   int64          `json:"misses"`
+	Errors   int64          `json:"errors"`
+	Timeouts int64          `json:"timeouts"`
+}
+
+type jsonAgentSlowest struct {
+	LatencyNs int64     `json:"latency_ns"`
+	At        time.Time `json:"at"`
+	Op        string    `json:"op"`
+	Key       string    `json:"key"`
+}
+
+func opByName(name string) (opType, bool) {
+	for op := opType(0); op < numOpTypes; op++ {
+		if opNames[op] == name {
+			return op, true
+		}
+	}
+	return 0, false
+}
+
+func encodeAgentResult(res *runResult) *agentResult {
+	t := res.total
+	r := &agentResult{
+		Start:       res.start,
+		End:         res.end,
+		Partial:     res.partial,
+		AbortReason: res.abortReason,
+		WarmupNs:    int64(res.warmup),
+		Backlog:     res.backlog,
+		Latency:     stats.Encode(t.latency),
+		Response:    stats.Encode(t.response),
+		Batch:       stats.Encode(t.batch),
+		ErrClasses:  make(map[string]int64),
+		Bytes:       t.bytesWritten,
+		BytesRead:   t.bytesRead,
+		WarmupOps:   t.warmupOps,
+		Series:      res.series,
+		SeriesFrom:  res.seriesFrom,
+	}
+	for op := opType(0); op < numOpTypes; op++ {
+		if s := &t.ops[op]; s.attempts() > 0 {
+			r.Ops = append(r.Ops, agentOpStats{Op: op.String(), Latency: stats.Encode(s.latency),
+				Hits: s.hits, Misses: s.misses, Errors: s.errors, Timeouts: s.timeouts})
+		}
+	}
+	for c := errClass(0); c < numErrClasses; c++ {
+		if n := t.errClasses[c]; n > 0 {
+			r.ErrClasses[c.String()] = n
+		}
+	}
+	if s := t.slowest; s.latency > 0 {
+		r.Slowest = &jsonAgentSlowest{LatencyNs: int64(s.latency), At: s.at, Op: s.op.String(), Key: s.key}
+	}
+	if t.keys != nil {
+		r.Keys = t.keys.reg[:]
+	}
+	return r
+}
+
+// total rebuilds the merged worker result of the agent, its times moved
+// by skew to the coordinator's clock and its histograms in the layout of
+// the coordinator's cfg.
+func (r *agentResult) total(cfg *config, skew time.Duration) (*workerResult, error) {
+	t := newWorkerResult(cfg.layout)
+	var err error
+	if r.Latency != nil {
+		if t.latency, err = stats.Decode(r.Latency); err != nil {
+			return nil, err
+		}
+	}
+	if t.response, err = stats.Decode(r.Response); err != nil {
+		return nil, err
+	}
+	if t.batch, err = stats.Decode(r.Batch); err != nil {
+		return nil, err
+	}
+	for _, o := range r.Ops {
+		op, ok := opByName(o.Op)
+		if !ok {
+			return nil, fmt.Errorf("unknown command %q", o.Op)
+		}
+		s := &t.ops[op]
+		if s.latency, err = stats.Decode(o.Latency); err != nil {
+			return nil, err
+		}
+		s.hits, s.misses, s.errors, s.timeouts = o.Hits, o.Misses, o.Errors, o.Timeouts
+	}
+	for c := errClass(0); c < numErrClasses; c++ {
+		t.errClasses[c] = r.ErrClasses[c.String()]
+	}
+	t.bytesWritten, t.bytesRead, t.warmupOps = r.Bytes, r.BytesRead, r.WarmupOps
+	if s := r.Slowest; s != nil {
+		op, _ := opByName(s.Op)
+		t.slowest = slowOp{latency: time.Duration(s.LatencyNs), at: s.At.Add(-skew), op: op, key: s.Key}
+	}
+	if len(r.Keys) > 0 {
+		t.keys = &keySketch{}
+		if len(r.Keys) != len(t.keys.reg) {
+			return nil, fmt.Errorf("key sketch of %d registers, want %d", len(r.Keys), len(t.keys.reg))
+		}
+		copy(t.keys.reg[:], r.Keys)
+	}
+	return t, nil
+}
+
+// runAgent serves the agent API on -listen until ctx is cancelled, running
+// the share of the benchmark each coordinator asks for, one at a time.
+func runAgent(ctx context.Context, cfg *config) error {
+	ln, err := net.Listen("tcp", cfg.listen)
+	if err != nil {
+		return fmt.Errorf("agent listener: %w", err)
+	}
+	srv := &http.Server{
+		Handler:           (&agent{logger: cfg.logger, logOut: cfg.logOut}).handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+		// Runs stop when the agent is interrupted, and their partial
+		// results still reach the coordinator.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	cfg.logger.Info("agent listening", "addr", ln.Addr().String(), "version", describeTool())
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), agentTimeout)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+	}()
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// agent runs one coordinator's spec at a time, logging where the agent
+// process does.
+type agent struct {
+	logger *slog.Logger
+	logOut io.Writer
+
+	mu sync.Mutex
+	// stop cancels the run in progress; nil when idle.
+	stop context.CancelFunc
+}
+
+func (a *agent) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(agentHello{Version: describeTool(), Time: time.Now()})
+	})
+	mux.HandleFunc("/run", a.serveRun)
+	mux.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "POST only", http.StatusMethodNotAllowed)
+			return
+		}
+		a.mu.Lock()
+		if a.stop != nil {
+			a.stop()
+		}
+		a.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// serveRun runs a spec and streams its messages, one JSON document per
+// line. A coordinator that goes away stops the run.
+func (a *agent) serveRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "POST only", http.StatusMethodNotAllowed)
+		return
+	}
+	var spec agentSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		http.Error(w, "invalid spec: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	cfg, err := parseFlags(spec.Args)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cfg.logger, cfg.logOut = a.logger, a.logOut
+	runCtx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	a.mu.Lock()
+	if a.stop != nil {
+		a.mu.Unlock()
+		http.Error(w, "a run is already in progress", http.StatusConflict)
+		return
+	}
+	a.stop = cancel
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.stop = nil
+		a.mu.Unlock()
+	}()
+	cfg.logger.Info("run requested", "coordinator", r.RemoteAddr, "target", cfg.addr, "clients", cfg.clients, "start_in", time.Until(spec.StartAt).Round(time.Millisecond))
+
+	var st atomic.Pointer[runState]
+	cfg.observe = func(s *runState) { st.Store(s) }
+	type outcome struct {
+		res *runResult
+		err error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		if !(realClock{}).Sleep(runCtx, time.Until(spec.StartAt)) {
+			done <- outcome{err: errors.New("stopped before the start")}
+			return
+		}
+		res, err := runTarget(runCtx, cfg)
+		done <- outcome{res, err}
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	send := func(m agentMessage) error {
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+	// The first message answers the request at once; the coordinator
+	// waits for the next ones no longer than agentTimeout.
+	if err := send(agentMessage{Stats: &agentStats{}}); err != nil {
+		cancel()
+	}
+	t := time.NewTicker(agentStatsInterval)
+	defer t.Stop()
+	for {
+		select {
+		case o := <-done:
+			if o.err != nil {
+				cfg.logger.Error("run failed", "err", o.err)
+				_ = send(agentMessage{Error: o.err.Error()})
+				return
+			}
+			if err := send(agentMessage{Result: encodeAgentResult(o.res)}); err != nil {
+				cfg.logger.Error("cannot send the result", "err", err)
+				return
+			}
+			cfg.logger.Info("run done", "ops", o.res.total.latency.Count(), "partial", o.res.partial)
+			return
+		case <-t.C:
+			var stats agentStats
+			if s := st.Load(); s != nil {
+				stats = agentStats{Ops: s.live.ops.Load(), Errors: s.live.errors.Load(), Measuring: s.measuring.Load()}
+			}
+			if err := send(agentMessage{Stats: &stats}); err != nil {
+				// The coordinator is gone: nobody wants the result.
+				cfg.logger.Warn("coordinator lost: stopping the run", "err", err)
+				cancel()
+			}
+		}
+	}
+}
+
+// agentLink is the coordinator's side of one agent.
+type agentLink struct {
+	addr    string
+	version string
+	// skew is how far the agent's clock is ahead of the coordinator's,
+	// measured at the handshake to within half of rtt.
+	skew, rtt time.Duration
+	// The share of the run given to the agent.
+	clients, clientOffset int
+	keyspace, keyOffset   int
+	preload               int
+	seed                  int64
+	rate                  float64
+	live                  atomic.Pointer[agentStats]
+	// finished is set once run returned; result, total and lost are
+	// read after that only.
+	finished atomic.Bool
+	result   *agentResult
+	total    *workerResult
+	// lost says why the agent returned no result, and lostAfter when, from
+	// the scheduled start; lost is empty for an agent that did.
+	lost      string
+	lostAfter time.Duration
+}
+
+// handshake reads the agent's clock handshakeRounds times and keeps the
+// reading with the shortest round trip, the most precise one.
+func (l *agentLink) handshake(ctx context.Context, client *http.Client) error {
+	for i := 0; i < handshakeRounds; i++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+l.addr+"/hello", nil)
+		if err != nil {
+			return err
+		}
+		sent := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		var hello agentHello
+		err = json.NewDecoder(resp.Body).Decode(&hello)
+		resp.Body.Close()
+		received := time.Now()
+		if err != nil {
+			return fmt.Errorf("handshake: %w", err)
+		}
+		if rtt := received.Sub(sent); i == 0 || rtt < l.rtt {
+			// The agent read its clock about halfway through the round
+			// trip.
+			l.rtt, l.version = rtt, hello.Version
+			l.skew = hello.Time.Sub(sent.Add(rtt / 2))
+		}
+	}
+	return nil
+}
+
+// splitAgents gives every agent its share of the clients, the keyspace, the
+// preload and the rate, and a seed of its own.
+func splitAgents(cfg *config, links []*agentLink) {
+	n := len(links)
+	clients, keys := 0, 0
+	for i, l := range links {
+		l.clients = cfg.clients / n
+		if i < cfg.clients%n {
+			l.clients++
+		}
+		l.clientOffset = clients
+		clients += l.clients
+		l.seed = cfg.seed + int64(i)
+		switch {
+		case cfg.rateScope == rateClient:
+			// Every client of every agent keeps the rate.
+			l.rate = cfg.rate
+		case cfg.rate > 0:
+			l.rate = cfg.rate * float64(l.clients) / float64(cfg.clients)
+		}
+		if cfg.usesKeyspace() {
+			l.keyspace = cfg.keyspace / n
+			if i < cfg.keyspace%n {
+				l.keyspace++
+			}
+			l.keyOffset = keys
+			keys += l.keyspace
+			l.preload = int(int64(cfg.preload) * int64(l.keyspace) / int64(cfg.keyspace))
+		}
+	}
+}
+
+// agentArgs returns the command line of the agent's share: the flags given
+// to the coordinator, but those kept to itself, then the agent's share.
+func agentArgs(cfg *config, l *agentLink) []string {
+	var names []string
+	for name := range cfg.explicit {
+		if !coordinatorFlags[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var args []string
+	for _, name := range names {
+		args = append(args, "-"+name+"="+cfg.explicit[name])
+	}
+	args = append(args,
+		"-clients="+strconv.Itoa(l.clients),
+		"-client-offset="+strconv.Itoa(l.clientOffset),
+		"-seed="+strconv.FormatInt(l.seed, 10),
+		"-key-prefix="+cfg.keyPrefix)
+	if cfg.usesKeyspace() {
+		args = append(args,
+			"-keyspace="+strconv.Itoa(l.keyspace),
+			"-key-offset="+strconv.Itoa(l.keyOffset),
+			"-preload="+strconv.Itoa(l.preload))
+	}
+	if l.rate > 0 {
+		args = append(args, "-rate="+strconv.FormatFloat(l.rate, 'g', -1, 64))
+	}
+	return args
+}
+
+// run sends the agent its spec and reads its stream until the result, or
+// until the agent fails, disconnects or says nothing for agentTimeout.
+func (l *agentLink) run(client *http.Client, cfg *config, start time.Time) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var silent atomic.Bool
+	watchdog := time.AfterFunc(agentTimeout, func() {
+		silent.Store(true)
+		cancel()
+	})
+	defer watchdog.Stop()
+	lose := func(reason string) {
+		l.lost, l.lostAfter = reason, time.Since(start)
+		if l.lostAfter < 0 {
+			l.lostAfter = 0
+		}
+	}
+
+	body, err := json.Marshal(agentSpec{Args: agentArgs(cfg, l), StartAt: start.Add(l.skew)})
+	if err != nil {
+		lose(err.Error())
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+l.addr+"/run", bytes.NewReader(body))
+	if err != nil {
+		lose(err.Error())
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		lose(err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		lose(fmt.Sprintf("%s: %s", resp.Status, strings.TrimSpace(string(msg))))
+		return
+	}
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var m agentMessage
+		if err := dec.Decode(&m); err != nil {
+			switch {
+			case silent.Load():
+				lose(fmt.Sprintf("no message for %v", agentTimeout))
+			case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+				lose("connection closed")
+			default:
+				lose(err.Error())
+			}
+			return
+		}
+		watchdog.Reset(agentTimeout)
+		switch {
+		case m.Error != "":
+			lose("run failed: " + m.Error)
+			return
+		case m.Result != nil:
+			total, err := m.Result.total(cfg, l.skew)
+			if err != nil {
+				lose("invalid result: " + err.Error())
+				return
+			}
+			l.result, l.total = m.Result, total
+			return
+		case m.Stats != nil:
+			l.live.Store(m.Stats)
+		}
+	}
+}
+
+// stop asks the agent to end its run early; it still sends its partial
+// result.
+func (l *agentLink) stop(client *http.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+l.addr+"/stop", nil)
+	if err != nil {
+		return
+	}
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// runCoordinator runs the benchmark of cfg across -agents: it measures the
+// clock of each, splits the run between them, starts them all at once and
+// merges what they measured. An agent lost on the way makes the run
+// partial.
+func runCoordinator(rootCtx context.Context, cfg *config) (*runResult, error) {
+	client := &http.Client{}
+	links := make([]*agentLink, len(cfg.agents))
+	handshake, cancel := context.WithTimeout(rootCtx, agentTimeout)
+	defer cancel()
+	var maxRTT time.Duration
+	for i, addr := range cfg.agents {
+		l := &agentLink{addr: addr}
+		if err := l.handshake(handshake, client); err != nil {
+			return nil, fmt.Errorf("agent %s: %w", addr, err)
+		}
+		if l.version != describeTool() {
+			cfg.logger.Warn("agent runs another version", "agent", addr, "version", l.version, "coordinator", describeTool())
+		}
+		cfg.logger.Info("agent ready", "agent", addr, "skew", l.skew, "rtt", l.rtt)
+		maxRTT = max(maxRTT, l.rtt)
+		links[i] = l
+	}
+	splitAgents(cfg, links)
+
+	start := time.Now().Add(agentStartLead + maxRTT)
+	var wg sync.WaitGroup
+	for _, l := range links {
+		wg.Add(1)
+		go func(l *agentLink) {
+			defer wg.Done()
+			l.run(client, cfg, start)
+			l.finished.Store(true)
+			if l.lost != "" {
+				cfg.logger.Error("agent lost", "agent", l.addr, "after", l.lostAfter.Round(time.Millisecond), "reason", l.lost)
+			}
+		}(l)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	t := time.NewTicker(agentStatsInterval)
+	defer t.Stop()
+	interrupted := rootCtx.Done()
+	var lastOps int64
+	for {
+		select {
+		case <-interrupted:
+			// Agents stop their runs and still send what they measured.
+			interrupted = nil
+			for _, l := range links {
+				if !l.finished.Load() {
+					go l.stop(client)
+				}
+			}
+		case <-t.C:
+			var ops, errs int64
+			running := 0
+			for _, l := range links {
+				if s := l.live.Load(); s != nil && !l.finished.Load() {
+					ops, errs = ops+s.Ops, errs+s.Errors
+					running++
+				}
+			}
+			if cfg.progress {
+				cfg.logger.Info("progress", "ops", ops, "ops/s", ops-lastOps, "errors", errs, "agents", running)
+			}
+			lastOps = ops
+		case <-done:
+			return mergeAgents(cfg, links)
+		}
+	}
+}
+
+// mergeAgents merges the results of the agents into one run, its times on
+// the coordinator's clock.
+func mergeAgents(cfg *config, links []*agentLink) (*runResult, error) {
+	res := &runResult{total: newWorkerResult(cfg.layout), agents: links, env: captureEnvironment("")}
+	var lost []string
+	for _, l := range links {
+		r := l.result
+		if r == nil {
+			lost = append(lost, fmt.Sprintf("agent %s lost after %v: %s", l.addr, l.lostAfter.Round(time.Millisecond), l.lost))
+			continue
+		}
+		start, end := r.Start.Add(-l.skew), r.End.Add(-l.skew)
+		if res.start.IsZero() || start.Before(res.start) {
+			res.start = start
+		}
+		if end.After(res.end) {
+			res.end = end
+		}
+		res.total.merge(l.total)
+		res.partial = res.partial || r.Partial
+		if r.AbortReason != "" && res.abortReason == "" {
+			res.abortReason = l.addr + ": " + r.AbortReason
+		}
+		res.warmup = max(res.warmup, time.Duration(r.WarmupNs))
+		res.backlog += r.Backlog
+	}
+	if res.start.IsZero() {
+		return nil, errors.New("no agent returned results: " + strings.Join(lost, "; "))
+	}
+	if len(lost) > 0 {
+		res.partial = true
+		res.abortReason = strings.Join(lost, "; ")
+	}
+	res.series, res.seriesFrom = mergeAgentSeries(links, res.start)
+	return res, nil
+}
+
+// mergeAgentSeries adds up the time series of the agents interval by
+// interval, shifted by when each started measuring. Percentiles cannot be
+// added: an interval shows the worst p99 of the agents.
+func mergeAgentSeries(links []*agentLink, start time.Time) ([]timePoint, float64) {
+	var merged []timePoint
+	from := 0.0
+	first := true
+	for _, l := range links {
+		r := l.result
+		if r == nil {
+			continue
+		}
+		offset := r.Start.Add(-l.skew).Sub(start).Seconds()
+		if f := r.SeriesFrom + offset; first || f < from {
+			from, first = f, false
+		}
+		for i, p := range r.Series {
+			if i == len(merged) {
+				merged = append(merged, timePoint{})
+			}
+			m := &merged[i]
+			m.T = max(m.T, p.T+offset)
+			m.Ops += p.Ops
+			m.Errors += p.Errors
+			m.Clients += p.Clients
+			m.P99Ns = max(m.P99Ns, p.P99Ns)
+		}
+	}
+	return merged, from
+}
+
+// jsonAgent is the share of one agent in a -mode coordinator run.
+type jsonAgent struct {
+	Agent        string          `json:"agent"`
+	Version      string          `json:"version"`
+	Clients      int             `json:"clients"`
+	KeyOffset    int             `json:"key_offset,omitempty"`
+	Keyspace     int             `json:"keyspace,omitempty"`
+	ClockSkewNs  int64           `json:"clock_skew_ns"`
+	RTTNs        int64           `json:"rtt_ns"`
+	StartDelayNs int64           `json:"start_delay_ns"`
+	Ops          int64           `json:"ops"`
+	FailedOps    int64           `json:"failed_ops"`
+	Throughput   float64         `json:"throughput_ops_per_sec"`
+	Latency      *latencySummary `json:"latency"`
+	Partial      bool            `json:"partial"`
+	Lost         string          `json:"lost,omitempty"`
+	LostAfterSec float64         `json:"lost_after_seconds,omitempty"`
+}
+
+func buildAgents(res *runResult) []jsonAgent {
+	var agents []jsonAgent
+	for _, l := range res.agents {
+		a := jsonAgent{
+			Agent:       l.addr,
+			Version:     l.version,
+			Clients:     l.clients,
+			KeyOffset:   l.keyOffset,
+			Keyspace:    l.keyspace,
+			ClockSkewNs: int64(l.skew),
+			RTTNs:       int64(l.rtt),
+			Lost:        l.lost,
+		}
+		if r := l.result; r != nil {
+			a.StartDelayNs = int64(r.Start.Add(-l.skew).Sub(res.start))
+			a.Ops = l.total.latency.Count()
+			a.FailedOps = l.total.errors()
+			if elapsed := r.End.Sub(r.Start); elapsed > 0 {
+				a.Throughput = float64(a.Ops) / elapsed.Seconds()
+			}
+			a.Latency = summarizeLatency(l.total.latency)
+			a.Partial = r.Partial
+		} else {
+			a.LostAfterSec = l.lostAfter.Seconds()
+		}
+		agents = append(agents, a)
+	}
+	return agents
+}
+
+// printAgents writes the per-agent breakdown of a -mode coordinator run.
+func printAgents(w io.Writer, res *runResult) {
+	if len(res.agents) == 0 {
+		return
+	}
+	agents := buildAgents(res)
+	var skew time.Duration
+	for _, a := range agents {
+		skew = max(skew, time.Duration(a.ClockSkewNs).Abs())
+	}
+	fmt.Fprintf(w, "Agents: %d, clock skew up to %v (corrected, measured at the handshake)\n", len(agents), skew)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "agent\tclients\tops\tops/s\terrors\tp50\tp99\tskew\trtt\tstart\tstatus\t")
+	for _, a := range agents {
+		if a.Lost != "" {
+			fmt.Fprintf(tw, "%s\t%d\t\t\t\t\t\t%v\t%v\t\tLOST after %.1fs: %s\t\n", a.Agent, a.Clients,
+				time.Duration(a.ClockSkewNs), time.Duration(a.RTTNs), a.LostAfterSec, a.Lost)
+			continue
+		}
+		p50, p99 := "N/A", "N/A"
+		if l := a.Latency; l != nil {
+			p50, p99 = time.Duration(l.P50Ns).String(), time.Duration(l.P99Ns).String()
+		}
+		status := "ok"
+		if a.Partial {
+			status = "partial"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.0f\t%d\t%s\t%s\t%v\t%v\t+%v\t%s\t\n", a.Agent, a.Clients, a.Ops, a.Throughput, a.FailedOps,
+			p50, p99, time.Duration(a.ClockSkewNs), time.Duration(a.RTTNs), time.Duration(a.StartDelayNs).Round(time.Microsecond), status)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,170 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestAgent serves the agent API in process and returns its host:port.
+func newTestAgent(t *testing.T, h http.Handler) string {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestCoordinatorMergesAgents(t *testing.T) {
+	mr, _ := newTestServer(t)
+	agents := newTestAgent(t, (&agent{logger: discardLogger()}).handler()) + "," + newTestAgent(t, (&agent{logger: discardLogger()}).handler())
+	cfg := testConfig(t, "-addr", mr.Addr(), "-mode", "coordinator", "-agents", agents,
+		"-clients", "3", "-duration", "1200ms", "-workload", "get", "-preload", "10")
+	res, err := runCoordinator(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := res.total.attempts(); res.partial || n == 0 || res.total.ops[opGet].attempts() != n {
+		t.Fatalf("partial %v, %d operations, %d GETs", res.partial, n, res.total.ops[opGet].attempts())
+	}
+	// Each agent preloaded its own half of the keyspace.
+	for i := 0; i < 10; i++ {
+		if !mr.Exists(cfg.keyName(i)) {
+			t.Errorf("%s was not preloaded", cfg.keyName(i))
+		}
+	}
+	if mr.Exists(cfg.keyName(10)) {
+		t.Errorf("%s beyond the keyspace was written", cfg.keyName(10))
+	}
+
+	rep := buildReport(cfg, res)
+	if len(rep.Agents) != 2 {
+		t.Fatalf("%d agents reported", len(rep.Agents))
+	}
+	var agentOps int64
+	for i, want := range []struct{ clients, keyOffset int }{{2, 0}, {1, 5}} {
+		a := rep.Agents[i]
+		if a.Clients != want.clients || a.KeyOffset != want.keyOffset || a.Keyspace != 5 || a.Ops == 0 || a.Lost != "" {
+			t.Errorf("agent %d: %+v", i, a)
+		}
+		agentOps += a.Ops + a.FailedOps
+		// Both agents run on this machine's clock.
+		if d := time.Duration(a.ClockSkewNs).Abs(); d > 100*time.Millisecond {
+			t.Errorf("agent %d: clock skew %v", i, d)
+		}
+	}
+	var seriesOps, seriesClients int64
+	for _, p := range rep.TimeSeries {
+		seriesOps += p.Ops + p.Errors
+		seriesClients = max(seriesClients, p.Clients)
+	}
+	if rep.Config.Clients != 3 || rep.TotalOps+rep.FailedOps != agentOps || seriesOps != agentOps || seriesClients != 3 {
+		t.Errorf("merged report: clients %d, %d ops of the agents' %d, %d in the time series of up to %d clients",
+			rep.Config.Clients, rep.TotalOps+rep.FailedOps, agentOps, seriesOps, seriesClients)
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "Agents: 2, clock skew up to") {
+		t.Errorf("summary lacks the agents:\n%s", buf.String())
+	}
+}
+
+func TestCoordinatorLostAgent(t *testing.T) {
+	saved := agentTimeout
+	agentTimeout = 1500 * time.Millisecond
+	t.Cleanup(func() { agentTimeout = saved })
+
+	// silent's clock is an hour ahead; it answers a run with one message,
+	// then nothing.
+	var startAt time.Time
+	silent := http.NewServeMux()
+	silent.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(agentHello{Version: describeTool(), Time: time.Now().Add(time.Hour)})
+	})
+	silent.HandleFunc("/run", func(w http.ResponseWriter, r *http.Request) {
+		var spec agentSpec
+		json.NewDecoder(r.Body).Decode(&spec)
+		startAt = spec.StartAt
+		json.NewEncoder(w).Encode(agentMessage{Stats: &agentStats{}})
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	// closing hangs up after one message.
+	closing := http.NewServeMux()
+	closing.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(agentHello{Version: describeTool(), Time: time.Now()})
+	})
+	closing.HandleFunc("/run", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(agentMessage{Stats: &agentStats{}})
+	})
+
+	addr := newTestServerAddr(t)
+	agents := []string{newTestAgent(t, (&agent{logger: discardLogger()}).handler()), newTestAgent(t, silent), newTestAgent(t, closing)}
+	cfg := testConfig(t, "-addr", addr, "-mode", "coordinator", "-agents", strings.Join(agents, ","), "-clients", "3", "-ops", "20")
+	begin := time.Now()
+	res, err := runCoordinator(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(begin) > 5*time.Second {
+		t.Errorf("the run took %v", time.Since(begin))
+	}
+	if !res.partial || !strings.Contains(res.abortReason, "agent "+agents[1]+" lost") || res.total.attempts() != 20 {
+		t.Errorf("partial %v, %d operations, reason %q", res.partial, res.total.attempts(), res.abortReason)
+	}
+	if l := res.agents[1]; l.lost != "no message for 1.5s" || l.skew < 59*time.Minute || l.skew > 61*time.Minute {
+		t.Errorf("silent agent lost %q, skew %v", l.lost, l.skew)
+	}
+	if d := time.Until(startAt); d < 59*time.Minute {
+		t.Errorf("silent agent asked to start in %v, not on its own clock", d)
+	}
+	if l := res.agents[2]; l.lost != "connection closed" {
+		t.Errorf("closing agent lost %q", l.lost)
+	}
+	var buf bytes.Buffer
+	printAgents(&buf, res)
+	if !strings.Contains(buf.String(), "LOST after") {
+		t.Errorf("breakdown lacks the lost agents:\n%s", buf.String())
+	}
+}
+
+func TestDistributedFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-mode", "cluster"},
+		{"-agents", "a:7777"},
+		{"-listen", ":7777"},
+		{"-mode", "coordinator"},
+		{"-mode", "coordinator", "-agents", "a"},
+		{"-mode", "coordinator", "-agents", "a:1,a:1"},
+		{"-mode", "coordinator", "-agents", "a:1,b:1", "-clients", "1"},
+		{"-mode", "coordinator", "-agents", "a:1", "-clients", "2", "-fairness"},
+		{"-mode", "coordinator", "-agents", "a:1", "-clients", "2", "-workload", "queue"},
+		{"-mode", "coordinator", "-agents", "a:1", "-clients", "2", "-workload", "incr"},
+		{"-mode", "agent", "-agents", "a:1"},
+		{"-key-offset", "-1"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+
+	cfg := testConfig(t, "-mode", "coordinator", "-agents", "a:1, b:1", "-clients", "5", "-workload", "get",
+		"-keyspace", "100", "-preload", "50", "-rate", "1000", "-output", "json", "-duration", "10s", "-seed", "7")
+	links := []*agentLink{{addr: "a:1"}, {addr: "b:1"}}
+	splitAgents(cfg, links)
+	got := strings.Join(agentArgs(cfg, links[1]), " ")
+	want := "-duration=10s -workload=get -clients=2 -client-offset=3 -seed=8 -key-prefix=" + cfg.keyPrefix +
+		" -keyspace=50 -key-offset=50 -preload=25 -rate=400"
+	if got != want {
+		t.Errorf("agent args\n%s, want\n%s", got, want)
+	}
+	// The agent accepts its share.
+	agentCfg := testConfig(t, agentArgs(cfg, links[1])...)
+	if agentCfg.keyName(0) != cfg.keyName(50) || agentCfg.uniqueKey(0, 1) != cfg.uniqueKey(3, 1) {
+		t.Errorf("agent keys %s and %s", agentCfg.keyName(0), agentCfg.uniqueKey(0, 1))
+	}
+}
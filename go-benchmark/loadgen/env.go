@@ -0,0 +1,155 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// tagList collects the repeatable -tag key=value flags, kept verbatim in
+// the report.
+type tagList map[string]string
+
+func (t *tagList) String() string {
+	if t == nil {
+		return ""
+	}
+	return formatTags(*t)
+}
+
+func (t *tagList) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("%q: want key=value", s)
+	}
+	if *t == nil {
+		*t = make(tagList)
+	}
+	if _, dup := (*t)[k]; dup {
+		return fmt.Errorf("tag %q given twice", k)
+	}
+	(*t)[k] = v
+	return nil
+}
+
+// tagKeys returns the keys of tags, sorted.
+func tagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatTags returns the tags as key=value pairs, sorted by key.
+func formatTags(tags map[string]string) string {
+	keys := tagKeys(tags)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + tags[k]
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// environment describes where a run came from: the tool and the machine it
+// ran on, and the version of the server it measured.
+type environment struct {
+	ToolVersion string `json:"tool_version"`
+	GoVersion   string `json:"go_version"`
+	GOMAXPROCS  int    `json:"gomaxprocs"`
+	NumCPU      int    `json:"num_cpu"`
+	OS          string `json:"os"`
+	Arch        string `json:"arch"`
+	Hostname    string `json:"hostname,omitempty"`
+	// ServerVersion is the version INFO reported, empty for a server
+	// that reports none.
+	ServerVersion string `json:"server_version,omitempty"`
+}
+
+// captureEnvironment returns the environment of this process for a run
+// against a server of serverVersion.
+func captureEnvironment(serverVersion string) *environment {
+	host, _ := os.Hostname()
+	return &environment{
+		ToolVersion:   describeTool(),
+		GoVersion:     runtime.Version(),
+		GOMAXPROCS:    runtime.GOMAXPROCS(0),
+		NumCPU:        runtime.NumCPU(),
+		OS:            runtime.GOOS,
+		Arch:          runtime.GOARCH,
+		Hostname:      host,
+		ServerVersion: serverVersion,
+	}
+}
+
+// serverVersionFields are the INFO fields giving the server version, in
+// order of preference.
+var serverVersionFields = []string{"redis_version", "valkey_version", "htcache_version", "version"}
+
+// serverVersion returns the version the server reports in INFO, empty when
+// INFO fails or has none.
+func serverVersion(ctx context.Context, rdb redis.UniversalClient) string {
+	info, err := rdb.Info(ctx).Result()
+	if err != nil {
+		return ""
+	}
+	fields := parseInfo(info)
+	for _, name := range serverVersionFields {
+		if v := fields[name]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// environmentMismatches lists the facts of the environments of two runs
+// that differ; a run stored before environments were captured has none
+// and matches any.
+func environmentMismatches(base, cur *environment) []string {
+	if base == nil || cur == nil {
+		return nil
+	}
+	fields := []struct {
+		name      string
+		base, cur any
+	}{
+		{"tool_version", base.ToolVersion, cur.ToolVersion},
+		{"go_version", base.GoVersion, cur.GoVersion},
+		{"gomaxprocs", base.GOMAXPROCS, cur.GOMAXPROCS},
+		{"num_cpu", base.NumCPU, cur.NumCPU},
+		{"os", base.OS, cur.OS},
+		{"arch", base.Arch, cur.Arch},
+		{"hostname", base.Hostname, cur.Hostname},
+		{"server_version", base.ServerVersion, cur.ServerVersion},
+	}
+	var diffs []string
+	for _, f := range fields {
+		if f.base != f.cur {
+			diffs = append(diffs, fmt.Sprintf("%s: %v, then %v", f.name, f.base, f.cur))
+		}
+	}
+	return diffs
+}
+
+// printEnvironment writes the tags and environment of a run.
+func printEnvironment(w io.Writer, cfg *config, env *environment) {
+	if len(cfg.tags) > 0 {
+		fmt.Fprintf(w, "Tags: %s\n", formatTags(cfg.tags))
+	}
+	if env == nil {
+		return
+	}
+	server := "server version unknown"
+	if env.ServerVersion != "" {
+		server = "server " + env.ServerVersion
+	}
+	fmt.Fprintf(w, "Environment: tool %s, %s %s/%s, GOMAXPROCS %d of %d CPUs, host %s, %s\n",
+		env.ToolVersion, env.GoVersion, env.OS, env.Arch, env.GOMAXPROCS, env.NumCPU, env.Hostname, server)
+}
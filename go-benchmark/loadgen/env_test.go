@@ -0,0 +1,122 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestTagFlags(t *testing.T) {
+	cfg := testConfig(t, "-clients", "1", "-tag", "instance=c6i.large", "-tag", "commit=abc=1", "-tag", "note=")
+	want := map[string]string{"instance": "c6i.large", "commit": "abc=1", "note": ""}
+	if len(cfg.tags) != len(want) {
+		t.Fatalf("tags %v, want %v", cfg.tags, want)
+	}
+	for k, v := range want {
+		if cfg.tags[k] != v {
+			t.Errorf("tag %s = %q, want %q", k, cfg.tags[k], v)
+		}
+	}
+	for _, args := range [][]string{
+		{"-tag", "instance"},
+		{"-tag", "=x"},
+		{"-tag", "a=1", "-tag", "a=2"},
+	} {
+		if _, err := parseFlags(append(args, "-clients", "1")); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
+
+func TestEnvironmentReport(t *testing.T) {
+	addr := newTestServerAddr(t)
+	path := filepath.Join(t.TempDir(), "results.db")
+	cfg := testConfig(t, "-addr", addr, "-clients", "1", "-ops", "20", "-tag", "instance=c6i.large", "-tag", "commit=abc")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := buildReport(cfg, res)
+	env := rep.Environment
+	if env == nil || env.GoVersion != runtime.Version() || env.OS != runtime.GOOS || env.GOMAXPROCS != runtime.GOMAXPROCS(0) || env.ToolVersion == "" {
+		t.Fatalf("environment %+v", env)
+	}
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, rep); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Tags        map[string]string `json:"tags"`
+		Environment map[string]any    `json:"environment"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Tags["instance"] != "c6i.large" || doc.Environment["go_version"] != runtime.Version() {
+		t.Errorf("JSON tags %v, environment %v", doc.Tags, doc.Environment)
+	}
+	buf.Reset()
+	printSummary(&buf, cfg, res)
+	for _, want := range []string{"Tags: commit=abc, instance=c6i.large", "Environment: tool ", "GOMAXPROCS"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, buf.String())
+		}
+	}
+
+	id, err := storeRun(path, rep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := openStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var tags int
+	var stored string
+	if err := db.QueryRow("SELECT COUNT(*) FROM tags WHERE run_id = ?", id).Scan(&tags); err != nil || tags != 2 {
+		t.Errorf("%d tags stored, %v", tags, err)
+	}
+	if err := db.QueryRow("SELECT environment FROM runs WHERE id = ?", id).Scan(&stored); err != nil || !strings.Contains(stored, runtime.Version()) {
+		t.Errorf("environment stored as %q, %v", stored, err)
+	}
+}
+
+func TestEnvironmentMismatches(t *testing.T) {
+	a := captureEnvironment("7.2.4")
+	b := *a
+	if diffs := environmentMismatches(a, &b); len(diffs) != 0 {
+		t.Errorf("identical environments differ: %v", diffs)
+	}
+	b.Hostname, b.ServerVersion = "other", "7.4.0"
+	diffs := environmentMismatches(a, &b)
+	if len(diffs) != 2 || !strings.HasPrefix(diffs[0], "hostname:") || !strings.Contains(diffs[1], "7.2.4, then 7.4.0") {
+		t.Errorf("diffs %v", diffs)
+	}
+	// Runs from before environments were captured match any.
+	if diffs := environmentMismatches(nil, a); len(diffs) != 0 {
+		t.Errorf("a missing environment differs: %v", diffs)
+	}
+
+	base, cur := syntheticReport(10000, 1000000), syntheticReport(10000, 1000000)
+	base.Environment, cur.Environment = a, &b
+	cmp, err := compareBaseline(base, cur, 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	printBaselineComparison(&buf, cmp)
+	if strings.Count(buf.String(), "WARNING: environment differs") != 2 || cmp.regressed() {
+		t.Errorf("baseline comparison:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	printRunDiff(&buf, &storedRun{id: 1, report: base}, &storedRun{id: 2, report: cur})
+	if !strings.Contains(buf.String(), "different environments") || !strings.Contains(buf.String(), "server_version: 7.2.4, then 7.4.0") {
+		t.Errorf("diff:\n%s", buf.String())
+	}
+}
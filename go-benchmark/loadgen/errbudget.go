@@ -0,0 +1,114 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// exitAborted is the exit status of a run aborted before its end by
+// -error-budget or -max-error-rate, whose results are partial.
+const exitAborted = 9
+
+// pingTimeout bounds the PING that checks the server answers before the
+// workers start.
+const pingTimeout = 5 * time.Second
+
+// errorWindowSlots is how many polls of the live error count span one
+// -error-window: the window slides by a tenth of itself.
+const errorWindowSlots = 10
+
+// checkReachable sends a PING, so that a run against a wrong address fails
+// at once instead of counting every operation it sends as failed.
+func checkReachable(ctx context.Context, rdb redis.UniversalClient) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("cannot reach the server: %w", err)
+	}
+	return nil
+}
+
+// validateErrorBudget checks -error-budget and -error-window.
+func (c *config) validateErrorBudget() error {
+	if c.errorBudget == 0 {
+		if _, ok := c.explicit["error-window"]; ok {
+			return errors.New("-error-window requires -error-budget")
+		}
+		return nil
+	}
+	switch {
+	case c.errorBudget < 0:
+		return fmt.Errorf("-error-budget must not be negative, got %d", c.errorBudget)
+	case c.errorWindow <= 0:
+		return fmt.Errorf("-error-window must be positive, got %v", c.errorWindow)
+	case c.resilience:
+		return errors.New("-error-budget would abort the outages -resilience measures")
+	}
+	return nil
+}
+
+// errorBreaker trips once more than -error-budget operations in a row
+// failed. A success only writes the shared streak when it ends one, so
+// runs without failures never contend on it.
+type errorBreaker struct {
+	budget int64
+	streak atomic.Int64
+	trip   func(reason string)
+}
+
+func newErrorBreaker(budget int64, trip func(reason string)) *errorBreaker {
+	return &errorBreaker{budget: budget, trip: trip}
+}
+
+func (b *errorBreaker) failure() {
+	if n := b.streak.Add(1); n == b.budget+1 {
+		b.trip(fmt.Sprintf("%d consecutive failures exceeded -error-budget %d", n, b.budget))
+	}
+}
+
+func (b *errorBreaker) success() {
+	if b.streak.Load() != 0 {
+		b.streak.Store(0)
+	}
+}
+
+// watchErrorBudget polls the live error count and calls abort once more
+// than budget operations failed within window. It keeps the count of the
+// last errorWindowSlots polls, so the workers count nothing beyond the
+// live counters.
+func watchErrorBudget(ctx context.Context, live *liveCounters, budget int64, window time.Duration, abort func(reason string)) {
+	t := time.NewTicker(max(window/errorWindowSlots, time.Millisecond))
+	defer t.Stop()
+	// ring[i] is the error count errorWindowSlots polls before the poll
+	// that overwrites it, one window ago.
+	var ring [errorWindowSlots]int64
+	for i := 0; ; i = (i + 1) % errorWindowSlots {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		errs := live.errors.Load()
+		if n := errs - ring[i]; n > budget {
+			abort(fmt.Sprintf("%d failures within %v exceeded -error-budget %d", n, window, budget))
+			return
+		}
+		ring[i] = errs
+	}
+}
+
+// checkAborted returns exitAborted when a run was aborted, 0 otherwise.
+// The summary states why.
+func checkAborted(results []*runResult) int {
+	for _, res := range results {
+		if res.abortReason != "" {
+			return exitAborted
+		}
+	}
+	return 0
+}
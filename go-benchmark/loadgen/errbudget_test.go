@@ -0,0 +1,141 @@
+package loadgen
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFailingServer starts a server that answers PING and replies reply,
+// an error line, to every other command.
+func newFailingServer(t *testing.T, reply string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					args, err := readBulkCommand(r)
+					if err != nil {
+						return
+					}
+					if strings.EqualFold(args[0], "PING") {
+						conn.Write([]byte("+PONG\r\n"))
+					} else {
+						conn.Write([]byte(reply + "\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestUnreachableServer(t *testing.T) {
+	// Nothing listens on a just-closed port.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	cfg := testConfig(t, "-addr", addr, "-clients", "2", "-ops", "5")
+
+	res, err := runTarget(context.Background(), cfg)
+
+	if err == nil || !strings.Contains(err.Error(), "cannot reach the server") {
+		t.Errorf("got %v, %v, want the PING to fail", res, err)
+	}
+}
+
+func TestErrorBreaker(t *testing.T) {
+	var tripped atomic.Value
+	b := newErrorBreaker(2, func(reason string) { tripped.Store(reason) })
+	b.failure()
+	b.failure()
+	b.success()
+	b.failure()
+	b.failure()
+	if tripped.Load() != nil {
+		t.Fatalf("tripped after a success reset the streak: %v", tripped.Load())
+	}
+	b.failure()
+	if reason, _ := tripped.Load().(string); reason != "3 consecutive failures exceeded -error-budget 2" {
+		t.Errorf("reason %q", reason)
+	}
+}
+
+func TestWatchErrorBudget(t *testing.T) {
+	live := &liveCounters{}
+	reasons := make(chan string, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchErrorBudget(ctx, live, 5, 50*time.Millisecond, func(reason string) { reasons <- reason })
+	live.errors.Add(3)
+	time.Sleep(120 * time.Millisecond)
+	live.errors.Add(3)
+	select {
+	case reason := <-reasons:
+		t.Fatalf("aborted on errors more than a window apart: %s", reason)
+	case <-time.After(30 * time.Millisecond):
+	}
+	live.errors.Add(3)
+	select {
+	case reason := <-reasons:
+		if !strings.Contains(reason, "6 failures within 50ms exceeded -error-budget 5") {
+			t.Errorf("reason %q", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not aborted")
+	}
+}
+
+func TestErrorBudgetAbort(t *testing.T) {
+	addr := newFailingServer(t, "-ERR injected")
+	cfg := testConfig(t, "-addr", addr, "-clients", "2", "-ops", "1000000", "-error-budget", "10")
+
+	res, err := runTarget(context.Background(), cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.partial || !strings.Contains(res.abortReason, "exceeded -error-budget 10") {
+		t.Fatalf("partial %v, reason %q", res.partial, res.abortReason)
+	}
+	if n := res.total.attempts(); n >= 1000000 {
+		t.Errorf("%d operations sent, want the run cut short", n)
+	}
+	if code := checkAborted([]*runResult{res}); code != exitAborted {
+		t.Errorf("exit status %d, want %d", code, exitAborted)
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "Load test aborted: ") {
+		t.Errorf("summary lacks the reason:\n%s", buf.String())
+	}
+	for _, args := range [][]string{
+		{"-error-budget", "-1"},
+		{"-error-window", "2s"},
+		{"-error-budget", "5", "-error-window", "0"},
+		{"-error-budget", "5", "-resilience"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
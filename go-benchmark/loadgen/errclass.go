@@ -0,0 +1,86 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// errClass buckets operation failures by their likely cause.
+type errClass int
+
+const (
+	errRefused errClass = iota
+	errTimeout
+	errReset
+	errProtocol
+	errServer
+	errFDExhausted
+	errOther
+	numErrClasses
+)
+
+var errClassNames = [numErrClasses]string{
+	errRefused:     "connection refused",
+	errTimeout:     "timeout",
+	errReset:       "connection reset",
+	errProtocol:    "protocol error",
+	errServer:      "server error reply",
+	errFDExhausted: "client out of file descriptors",
+	errOther:       "other",
+}
+
+func (c errClass) String() string {
+	return errClassNames[c]
+}
+
+// classifyError maps an error returned by go-redis or a Target to its
+// errClass.
+func classifyError(err error) errClass {
+	var netErr net.Error
+	var redisErr redis.Error
+	var protoErr *respProtocolError
+	var mcProtoErr *memcacheProtocolError
+	var statusErr *httpStatusError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return errRefused
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE):
+		// The client's own limit, not the server turning connections away.
+		return errFDExhausted
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return errTimeout
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed):
+		return errReset
+	}
+	if errors.As(err, &statusErr) {
+		// A 5xx is the server failing the request, any other status one
+		// it could not make sense of.
+		if statusErr.code >= 500 {
+			return errServer
+		}
+		return errProtocol
+	}
+	msg := err.Error()
+	switch {
+	// go-redis does not export its pool or parser errors, so match on text.
+	case strings.Contains(msg, "connection pool timeout"):
+		return errTimeout
+	case strings.HasPrefix(msg, "redis: invalid reply"), strings.HasPrefix(msg, "redis: can't parse"),
+		strings.HasPrefix(msg, "redis: got "), errors.As(err, &protoErr), errors.As(err, &mcProtoErr):
+		return errProtocol
+	case errors.As(err, &redisErr):
+		// Error replies (ERR, WRONGTYPE, OOM...) are well-formed protocol
+		// messages, so they are kept apart from parse failures.
+		return errServer
+	}
+	return errOther
+}
@@ -0,0 +1,45 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisReplyError mimics go-redis' unexported error reply type.
+type redisReplyError string
+
+func (e redisReplyError) Error() string { return string(e) }
+func (redisReplyError) RedisError()     {}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want errClass
+	}{
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, errRefused},
+		{context.DeadlineExceeded, errTimeout},
+		{&net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}, errTimeout},
+		{errors.New("redis: connection pool timeout"), errTimeout},
+		{&net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, errReset},
+		{io.EOF, errReset},
+		{fmt.Errorf("redis: can't parse %q", "?"), errProtocol},
+		{redisReplyError("ERR unknown command"), errServer},
+		{respError("WRONGTYPE Operation against a key holding the wrong kind of value"), errServer},
+		{&respProtocolError{"unexpected reply type '*'"}, errProtocol},
+		{&net.OpError{Op: "dial", Err: os.NewSyscallError("socket", syscall.EMFILE)}, errFDExhausted},
+		{redis.ErrClosed, errOther},
+	}
+	for _, tt := range tests {
+		if got := classifyError(tt.err); got != tt.want {
+			t.Errorf("classifyError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+const (
+	// evictPollInterval is how often the fill of -evict-pressure reads
+	// INFO.
+	evictPollInterval = 250 * time.Millisecond
+	// evictPlateauPolls is how many polls used_memory must not grow for
+	// the fill to consider the server full.
+	evictPlateauPolls = 4
+	// evictRecentKeys is how many of its latest SET keys a client keeps to
+	// read back under -evict-pressure.
+	evictRecentKeys = 256
+)
+
+// Reasons the fill of -evict-pressure stopped.
+const (
+	fillEvicting    = "evicting"
+	fillPlateau     = "used_memory plateau"
+	fillOOM         = "OOM"
+	fillTimeout     = "timeout"
+	fillInterrupted = "interrupted"
+)
+
+// validateEvictPressure checks the flags of -evict-pressure, whose workload
+// must keep writing for eviction to go on.
+func (c *config) validateEvictPressure() error {
+	if c.workload != workloadSet && (c.mix == nil || c.mix.share(opSet) == 0) {
+		return fmt.Errorf("-evict-pressure needs a workload that SETs, not -workload %s", c.workload)
+	}
+	switch {
+	case c.pipeline > 1:
+		return errors.New("-pipeline does not apply to -evict-pressure, which reads keys back per operation")
+	case c.cluster:
+		return errors.New("-evict-pressure does not apply to -cluster: INFO describes a single node")
+	case c.evictCheck < 0 || c.evictCheck > 1:
+		return fmt.Errorf("-evict-check must be between 0 and 1, got %v", c.evictCheck)
+	case c.evictFillTimeout <= 0:
+		return fmt.Errorf("-evict-fill-timeout must be positive, got %v", c.evictFillTimeout)
+	}
+	return nil
+}
+
+// memoryInfo is the part of INFO that -evict-pressure watches. A field the
+// server does not report is negative.
+type memoryInfo struct {
+	used, evicted float64
+}
+
+// readMemoryInfo reads used_memory and evicted_keys.
+func readMemoryInfo(ctx context.Context, rdb redis.UniversalClient) (memoryInfo, error) {
+	m := memoryInfo{used: -1, evicted: -1}
+	info, err := rdb.Info(ctx, "memory", "stats").Result()
+	if err != nil {
+		return m, err
+	}
+	fields := parseInfo(info)
+	if v, err := strconv.ParseFloat(fields["used_memory"], 64); err == nil {
+		m.used = v
+	}
+	if v, err := strconv.ParseFloat(fields["evicted_keys"], 64); err == nil {
+		m.evicted = v
+	}
+	return m, nil
+}
+
+// pressureFill describes the fill that brings the server to maxmemory.
+type pressureFill struct {
+	written, failed int64
+	elapsed         time.Duration
+	stopped         string
+	// usedMemory is used_memory once the fill stopped, -1 when the server
+	// does not report it.
+	usedMemory float64
+	// oom is the first out-of-memory reply: a server that refuses writes
+	// instead of evicting.
+	oom error
+	// before is the SET latency until the server started evicting.
+	before *stats.Histogram
+}
+
+// fillToPressure writes unique keys of -value-size from every client until
+// the server evicts, its used_memory stops growing, it answers OOM or
+// -evict-fill-timeout passes. Only SETs answered before eviction was seen
+// are timed.
+func fillToPressure(ctx context.Context, rdb redis.UniversalClient, cfg *config) (*pressureFill, error) {
+	base, err := readMemoryInfo(ctx, rdb)
+	if err != nil {
+		return nil, err
+	}
+	if base.used < 0 && base.evicted < 0 {
+		return nil, errors.New("-evict-pressure needs used_memory or evicted_keys in INFO")
+	}
+	res := &pressureFill{before: cfg.layout.New(), usedMemory: base.used}
+	fillCtx, stop := context.WithTimeout(ctx, cfg.evictFillTimeout)
+	defer stop()
+	var (
+		mu       sync.Mutex
+		evicting atomic.Bool
+		written  atomic.Int64
+		failed   atomic.Int64
+	)
+	finish := func(reason string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if res.stopped == "" {
+			res.stopped = reason
+		}
+		stop()
+	}
+
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < cfg.clients; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(cfg.seed + int64(cfg.clients+w)))
+			h := cfg.layout.New()
+			defer func() {
+				mu.Lock()
+				res.before.Merge(h)
+				mu.Unlock()
+			}()
+			for i := 0; fillCtx.Err() == nil; i++ {
+				// Fill keys are named after clients the workload does
+				// not have, so its own keys are all new.
+				begin := time.Now()
+				key := cfg.uniqueKey(cfg.clients+w, i)
+				err := rdb.Set(fillCtx, key, cfg.keyValue(rng, key, cfg.clients+w, uint64(i), i), 0).Err()
+				d := time.Since(begin)
+				switch {
+				case err == nil:
+					written.Add(1)
+					if !evicting.Load() {
+						h.Record(d)
+					}
+				case fillCtx.Err() != nil:
+				default:
+					failed.Add(1)
+					if isOOM(err) {
+						mu.Lock()
+						if res.oom == nil {
+							res.oom = err
+						}
+						mu.Unlock()
+						finish(fillOOM)
+					}
+				}
+			}
+		}(w)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t := time.NewTicker(evictPollInterval)
+		defer t.Stop()
+		peak, flat := base.used, 0
+		for {
+			select {
+			case <-fillCtx.Done():
+				return
+			case <-t.C:
+			}
+			m, err := readMemoryInfo(ctx, rdb)
+			if err != nil {
+				continue
+			}
+			res.usedMemory = m.used
+			if base.evicted >= 0 && m.evicted > base.evicted {
+				evicting.Store(true)
+				finish(fillEvicting)
+				return
+			}
+			if m.used <= peak {
+				if flat++; flat >= evictPlateauPolls {
+					finish(fillPlateau)
+					return
+				}
+			} else {
+				peak, flat = m.used, 0
+			}
+		}
+	}()
+	wg.Wait()
+	res.elapsed = time.Since(start)
+	res.written, res.failed = written.Load(), failed.Load()
+	if res.stopped == "" {
+		res.stopped = fillTimeout
+		if ctx.Err() != nil {
+			res.stopped = fillInterrupted
+		}
+	}
+	return res, nil
+}
+
+// printPressureFill writes the fill summary of -evict-pressure.
+func printPressureFill(w io.Writer, f *pressureFill) {
+	fmt.Fprintf(w, "Eviction pressure fill: %d keys written, %d failed in %v, stopped on %s\n",
+		f.written, f.failed, f.elapsed.Round(time.Millisecond), f.stopped)
+	if f.oom != nil {
+		fmt.Fprintf(w, "WARNING: the server refuses writes with OOM instead of evicting: %v\n", f.oom)
+	}
+}
+
+// evictStats counts what -evict-pressure finds during the measured run.
+type evictStats struct {
+	// checked counts the reads of recently written keys, of which evicted
+	// missed and failed errored.
+	checked, evicted, failed int64
+	// oom counts the writes refused with OOM.
+	oom int64
+}
+
+func (s *evictStats) merge(o *evictStats) {
+	s.checked += o.checked
+	s.evicted += o.evicted
+	s.failed += o.failed
+	s.oom += o.oom
+}
+
+// evictStats returns the -evict-pressure counts of r, allocating them on
+// first use.
+func (r *workerResult) evictStats() *evictStats {
+	if r.evict == nil {
+		r.evict = &evictStats{}
+	}
+	return r.evict
+}
+
+// afterEvictPressure follows an accounted operation of a -evict-pressure
+// run. After -evict-check of the successful SETs, one of the client's
+// latest keys is read back; a miss is a key already evicted. The read is
+// not part of the latency of the run.
+func (w *worker) afterEvictPressure(p pendingOp) {
+	if !w.measuring {
+		return
+	}
+	err := p.cmd.Err()
+	if err != nil && isOOM(err) {
+		w.result.evictStats().oom++
+		return
+	}
+	if p.op != opSet || err != nil {
+		return
+	}
+	if len(w.recent) > 0 && w.rng.Float64() < w.run.cfg.evictCheck {
+		s := w.result.evictStats()
+		opCtx, cancel := w.opContext()
+		err := w.rdb.Get(opCtx, w.recent[w.rng.Intn(len(w.recent))]).Err()
+		cancel()
+		s.checked++
+		switch {
+		case isMiss(err):
+			s.evicted++
+		case err != nil:
+			s.failed++
+		}
+	}
+	if len(w.recent) < evictRecentKeys {
+		w.recent = append(w.recent, p.key)
+	} else {
+		w.recent[w.recentNext] = p.key
+		w.recentNext = (w.recentNext + 1) % evictRecentKeys
+	}
+}
+
+// evictPoint is the eviction rate over the interval ending T seconds into
+// the measured window.
+type evictPoint struct {
+	T             float64 `json:"t"`
+	EvictedPerSec float64 `json:"evicted_per_sec"`
+	UsedMemory    float64 `json:"used_memory,omitempty"`
+}
+
+// evictMonitor reads evicted_keys every second of the measured run.
+type evictMonitor struct {
+	stopCh chan struct{}
+	done   sync.WaitGroup
+	at     []time.Time
+	infos  []memoryInfo
+}
+
+func startEvictMonitor(rdb redis.UniversalClient) *evictMonitor {
+	m := &evictMonitor{stopCh: make(chan struct{})}
+	if info, err := readMemoryInfo(context.Background(), rdb); err == nil {
+		m.at, m.infos = append(m.at, time.Now()), append(m.infos, info)
+	}
+	m.done.Add(1)
+	go func() {
+		defer m.done.Done()
+		t := time.NewTicker(sampleInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				m.poll(rdb)
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+func (m *evictMonitor) poll(rdb redis.UniversalClient) {
+	if info, err := readMemoryInfo(context.Background(), rdb); err == nil {
+		m.at, m.infos = append(m.at, time.Now()), append(m.infos, info)
+	}
+}
+
+// stop ends polling, takes a last reading and returns the eviction rates
+// relative to origin, with the keys evicted in all.
+func (m *evictMonitor) stop(rdb redis.UniversalClient, origin time.Time) ([]evictPoint, int64) {
+	close(m.stopCh)
+	m.done.Wait()
+	m.poll(rdb)
+	points := []evictPoint{}
+	var evicted int64
+	for i := 1; i < len(m.infos); i++ {
+		prev, cur := m.infos[i-1], m.infos[i]
+		p := evictPoint{T: m.at[i].Sub(origin).Seconds(), UsedMemory: max(cur.used, 0)}
+		if cur.evicted >= 0 && prev.evicted >= 0 {
+			n := cur.evicted - prev.evicted
+			evicted += int64(n)
+			if d := m.at[i].Sub(m.at[i-1]).Seconds(); d > 0 {
+				p.EvictedPerSec = n / d
+			}
+		}
+		points = append(points, p)
+	}
+	return points, evicted
+}
+
+// evictReport is the -evict-pressure section of a run.
+type evictReport struct {
+	FillWritten int64   `json:"fill_written"`
+	FillFailed  int64   `json:"fill_failed"`
+	FillSeconds float64 `json:"fill_seconds"`
+	FillStopped string  `json:"fill_stopped"`
+	UsedMemory  float64 `json:"used_memory,omitempty"`
+	// OOM is set when the server refused writes with OOM rather than
+	// evicting, during the fill or the run; OOMErrors counts the refused
+	// writes of the run.
+	OOM       bool  `json:"oom"`
+	OOMErrors int64 `json:"oom_errors"`
+	// BeforeEviction is the SET latency of the fill until eviction was
+	// seen, and DuringEviction that of the measured run.
+	BeforeEviction *latencySummary `json:"before_eviction_latency"`
+	DuringEviction *latencySummary `json:"during_eviction_latency"`
+	P99Ratio       float64         `json:"p99_ratio,omitempty"`
+	Evicted        int64           `json:"evicted"`
+	// RecentChecked counts the reads of recently written keys, of which
+	// RecentEvicted found the key gone.
+	RecentChecked      int64        `json:"recent_checked"`
+	RecentEvicted      int64        `json:"recent_evicted"`
+	RecentEvictedRatio float64      `json:"recent_evicted_ratio"`
+	RecentErrors       int64        `json:"recent_errors,omitempty"`
+	Series             []evictPoint `json:"series"`
+
+	before, during *stats.Histogram
+}
+
+// buildEvict summarises -evict-pressure over the fill and the run res.
+func buildEvict(f *pressureFill, series []evictPoint, evicted int64, res *runResult) *evictReport {
+	total := res.total
+	rep := &evictReport{
+		FillWritten:    f.written,
+		FillFailed:     f.failed,
+		FillSeconds:    f.elapsed.Seconds(),
+		FillStopped:    f.stopped,
+		UsedMemory:     max(f.usedMemory, 0),
+		OOM:            f.oom != nil,
+		BeforeEviction: summarizeLatency(f.before),
+		DuringEviction: summarizeLatency(total.ops[opSet].latency),
+		Evicted:        evicted,
+		Series:         series,
+		before:         f.before,
+		during:         total.ops[opSet].latency,
+	}
+	if rep.BeforeEviction != nil && rep.DuringEviction != nil && rep.BeforeEviction.P99Ns > 0 {
+		rep.P99Ratio = float64(rep.DuringEviction.P99Ns) / float64(rep.BeforeEviction.P99Ns)
+	}
+	if s := total.evict; s != nil {
+		rep.OOMErrors = s.oom
+		rep.OOM = rep.OOM || s.oom > 0
+		rep.RecentChecked, rep.RecentEvicted, rep.RecentErrors = s.checked, s.evicted, s.failed
+		if s.checked > 0 {
+			rep.RecentEvictedRatio = float64(s.evicted) / float64(s.checked)
+		}
+	}
+	return rep
+}
+
+// printEvict writes the -evict-pressure section of the summary.
+func printEvict(w io.Writer, u latencyUnit, rep *evictReport) {
+	fmt.Fprintf(w, "Eviction pressure: fill of %d keys stopped on %s after %.1fs; %d keys evicted during the run\n",
+		rep.FillWritten, rep.FillStopped, rep.FillSeconds, rep.Evicted)
+	if rep.OOM {
+		fmt.Fprintf(w, "WARNING: the server refused writes with OOM instead of evicting (%d during the run)\n", rep.OOMErrors)
+	}
+	printLatency(w, u, "SET before eviction", rep.before)
+	printLatency(w, u, "SET during eviction", rep.during)
+	if rep.P99Ratio > 0 {
+		fmt.Fprintf(w, "SET p99 during eviction is %.2fx that before\n", rep.P99Ratio)
+	}
+	if rep.RecentChecked > 0 {
+		fmt.Fprintf(w, "Recently written keys read back: %d, already evicted: %d (%.2f%%)\n",
+			rep.RecentChecked, rep.RecentEvicted, 100*rep.RecentEvictedRatio)
+	}
+}
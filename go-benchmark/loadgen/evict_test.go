@@ -0,0 +1,195 @@
+package loadgen
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCache is a RESP server holding at most capacity keys. When full it
+// evicts the oldest key, or refuses the write with OOM when oom is set.
+// tamper, when set, rewrites the reply of a GET from the current and the
+// previous value of the key.
+type fakeCache struct {
+	capacity int
+	oom      bool
+	tamper   func(value, prev string, found bool) (string, bool)
+
+	mu      sync.Mutex
+	values  map[string]string
+	prev    map[string]string
+	order   []string
+	used    int
+	evicted int
+}
+
+func startFakeCache(t *testing.T, capacity int, oom bool) string {
+	return startTamperingCache(t, capacity, oom, nil)
+}
+
+func startTamperingCache(t *testing.T, capacity int, oom bool, tamper func(value, prev string, found bool) (string, bool)) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	c := &fakeCache{capacity: capacity, oom: oom, tamper: tamper, values: make(map[string]string), prev: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go c.serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// readBulkCommand reads a command of bulk strings, which may hold any
+// byte.
+func readBulkCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func (c *fakeCache) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readBulkCommand(r)
+		if err != nil || len(args) == 0 {
+			return
+		}
+		c.mu.Lock()
+		var reply string
+		switch strings.ToLower(args[0]) {
+		case "set":
+			reply = c.set(args[1], args[2])
+		case "get":
+			v, ok := c.values[args[1]]
+			if c.tamper != nil {
+				v, ok = c.tamper(v, c.prev[args[1]], ok)
+			}
+			if ok {
+				reply = bulk(v)
+			} else {
+				reply = "$-1\r\n"
+			}
+		case "info":
+			reply = bulk(fmt.Sprintf("# Memory\r\nused_memory:%d\r\n# Stats\r\nevicted_keys:%d\r\n", c.used, c.evicted))
+		default:
+			reply = "+OK\r\n"
+		}
+		c.mu.Unlock()
+		if _, err := io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func (c *fakeCache) set(key, value string) string {
+	if old, ok := c.values[key]; ok {
+		c.prev[key] = old
+		c.used += len(value) - len(old)
+		c.values[key] = value
+		return "+OK\r\n"
+	}
+	if len(c.values) >= c.capacity {
+		if c.oom {
+			return "-OOM command not allowed when used memory > 'maxmemory'.\r\n"
+		}
+		oldest := c.order[0]
+		c.order = c.order[1:]
+		c.used -= len(c.values[oldest])
+		delete(c.values, oldest)
+		c.evicted++
+	}
+	c.values[key] = value
+	c.order = append(c.order, key)
+	c.used += len(value)
+	return "+OK\r\n"
+}
+
+func TestEvictPressure(t *testing.T) {
+	addr := startFakeCache(t, 500, false)
+	cfg := testConfig(t, "-addr", addr, "-clients", "4", "-duration", "500ms", "-value-size", "512", "-key-prefix", "ev:",
+		"-evict-pressure", "-evict-check", "0.5")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep := res.evict
+	if rep == nil {
+		t.Fatal("no eviction report")
+	}
+	if rep.FillStopped != fillEvicting || rep.FillWritten < 500 || rep.OOM {
+		t.Errorf("fill %+v, want it stopped by eviction", rep)
+	}
+	if rep.BeforeEviction == nil || rep.DuringEviction == nil || rep.P99Ratio == 0 {
+		t.Errorf("latency before %+v and during %+v eviction", rep.BeforeEviction, rep.DuringEviction)
+	}
+	if rep.Evicted < res.total.ops[opSet].attempts()/2 || len(rep.Series) == 0 {
+		t.Errorf("%d evicted during %d SETs, series %+v", rep.Evicted, res.total.ops[opSet].attempts(), rep.Series)
+	}
+	// 4 clients remember more recent keys than the server holds.
+	if rep.RecentChecked == 0 || rep.RecentEvicted == 0 || rep.RecentEvicted == rep.RecentChecked {
+		t.Errorf("read back %d recent keys, %d evicted", rep.RecentChecked, rep.RecentEvicted)
+	}
+	if got := res.total.ops[opGet].attempts(); got != 0 {
+		t.Errorf("%d read-backs counted as GET operations", got)
+	}
+}
+
+func TestEvictPressureOOM(t *testing.T) {
+	addr := startFakeCache(t, 200, true)
+	cfg := testConfig(t, "-addr", addr, "-clients", "2", "-duration", "300ms", "-key-prefix", "ev:", "-evict-pressure")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep := res.evict
+	if rep.FillStopped != fillOOM || !rep.OOM || rep.OOMErrors == 0 || rep.Evicted != 0 {
+		t.Errorf("eviction report %+v, want OOM refusals", rep)
+	}
+}
+
+func TestEvictPressureFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-evict-pressure", "-workload", "incr"},
+		{"-evict-pressure", "-pipeline", "8"},
+		{"-evict-pressure", "-evict-check", "2"},
+		{"-evict-pressure", "-evict-fill-timeout", "0"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	testConfig(t, "-evict-pressure", "-ratio", "set=1,get=3")
+}
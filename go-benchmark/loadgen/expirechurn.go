@@ -0,0 +1,301 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// workloadChurn writes fresh keys with short TTLs at a sustained rate and
+// never reads them, the write-once-expire-soon use of a cache. It runs as
+// the set workload, with -ttl and -rate defaulting to defaultChurnTTL and
+// defaultChurnRate, while a monitor samples DBSIZE and used_memory every
+// -churn-interval: when the server's expiration keeps up, both level off
+// at about the SET rate times the mean TTL; when it lags, they grow.
+const workloadChurn = "churn"
+
+const (
+	defaultChurnTTL  = "1s:10s"
+	defaultChurnRate = 1000
+	// churnTail is the share of the samples, at the end of the run, a
+	// trend is judged on, and churnTolerance how much the value may move
+	// across them, in share of its level, and still be a plateau; the
+	// plateau is reached once the value comes within churnTolerance of
+	// its level.
+	churnTail      = 0.25
+	churnTolerance = 0.05
+	// minChurnSamples is how many samples of the measured window a trend
+	// needs to be judged at all.
+	minChurnSamples = 8
+)
+
+// Verdicts of a churn trend.
+const (
+	churnPlateau      = "plateau"
+	churnGrowing      = "growing"
+	churnDeclining    = "declining"
+	churnUndetermined = "undetermined"
+)
+
+// setupChurn turns -workload churn into the set workload it runs as.
+func (c *config) setupChurn() error {
+	c.workload, c.expireChurn = workloadSet, true
+	if _, ok := c.explicit["keyspace"]; ok {
+		return errors.New("-workload churn writes fresh keys: no -keyspace")
+	}
+	if _, ok := c.explicit["ttl"]; !ok {
+		c.ttlRange = defaultChurnTTL
+	} else if c.ttlRange == "" {
+		return errors.New("-workload churn needs -ttl")
+	}
+	if _, ok := c.explicit["rate"]; !ok {
+		c.rate = defaultChurnRate
+	}
+	if c.churnInterval <= 0 {
+		return fmt.Errorf("-churn-interval must be positive, got %v", c.churnInterval)
+	}
+	return nil
+}
+
+// expireChurnSample is a reading of the monitor, T seconds into the measured
+// window. A value the server does not report is left out.
+type expireChurnSample struct {
+	T          float64 `json:"t"`
+	Keys       *int64  `json:"keys,omitempty"`
+	UsedMemory *int64  `json:"used_memory,omitempty"`
+}
+
+// expireChurnMonitor samples DBSIZE and used_memory during the run. A server
+// that fails either is no longer asked for it, and the reason is kept.
+type expireChurnMonitor struct {
+	rdb    redis.UniversalClient
+	stopCh chan struct{}
+	done   sync.WaitGroup
+
+	at      []time.Time
+	samples []expireChurnSample
+	noKeys  string
+	noMem   string
+}
+
+func startExpireChurnMonitor(rdb redis.UniversalClient, interval time.Duration) *expireChurnMonitor {
+	m := &expireChurnMonitor{rdb: rdb, stopCh: make(chan struct{})}
+	m.poll()
+	m.done.Add(1)
+	go func() {
+		defer m.done.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				m.poll()
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// poll takes a sample. An error reply gives a value up for the rest of
+// the run; any other failure only loses the sample.
+func (m *expireChurnMonitor) poll() {
+	var s expireChurnSample
+	var refused redis.Error
+	if m.noKeys == "" {
+		n, err := m.rdb.DBSize(context.Background()).Result()
+		switch {
+		case err == nil:
+			s.Keys = &n
+		case errors.As(err, &refused):
+			m.noKeys = err.Error()
+		}
+	}
+	if m.noMem == "" {
+		info, err := readMemoryInfo(context.Background(), m.rdb)
+		switch {
+		case err == nil && info.used >= 0:
+			used := int64(info.used)
+			s.UsedMemory = &used
+		case err == nil:
+			m.noMem = "INFO reports no used_memory"
+		case errors.As(err, &refused):
+			m.noMem = err.Error()
+		}
+	}
+	if s.Keys != nil || s.UsedMemory != nil {
+		m.at, m.samples = append(m.at, time.Now()), append(m.samples, s)
+	}
+}
+
+// stop ends sampling, takes a last reading and returns the samples of the
+// measured window, starting at origin.
+func (m *expireChurnMonitor) stop(origin time.Time) []expireChurnSample {
+	close(m.stopCh)
+	m.done.Wait()
+	m.poll()
+	var out []expireChurnSample
+	for i, s := range m.samples {
+		if m.at[i].Before(origin) {
+			continue
+		}
+		s.T = m.at[i].Sub(origin).Seconds()
+		out = append(out, s)
+	}
+	return out
+}
+
+// expireChurnReport is the -workload churn section of a run: whether the key
+// count and memory of the server levelled off. ExpectedKeys is the level
+// the key count reaches when expiration keeps up, the SET rate of the run
+// times the mean TTL.
+type expireChurnReport struct {
+	Interval     string              `json:"interval"`
+	ExpectedKeys int64               `json:"expected_keys"`
+	Keys         *expireChurnTrend   `json:"keys,omitempty"`
+	UsedMemory   *expireChurnTrend   `json:"used_memory,omitempty"`
+	Notes        []string            `json:"notes,omitempty"`
+	Samples      []expireChurnSample `json:"samples"`
+}
+
+// expireChurnTrend is how one value evolved over the measured window. Growth is
+// its slope over the last churnTail of the samples, per second; Level and
+// TimeToPlateau are set for a plateau.
+type expireChurnTrend struct {
+	Verdict        string  `json:"verdict"`
+	First          int64   `json:"first"`
+	Last           int64   `json:"last"`
+	Peak           int64   `json:"peak"`
+	GrowthPerSec   float64 `json:"growth_per_sec"`
+	Level          int64   `json:"plateau_level,omitempty"`
+	TimeToPlateauS float64 `json:"time_to_plateau_s,omitempty"`
+}
+
+// buildExpireChurn analyses the samples of a churn run.
+func buildExpireChurn(cfg *config, m *expireChurnMonitor, samples []expireChurnSample, res *runResult) *expireChurnReport {
+	rep := &expireChurnReport{Interval: cfg.churnInterval.String(), Samples: samples}
+	if rep.Samples == nil {
+		rep.Samples = []expireChurnSample{}
+	}
+	if elapsed := res.elapsed(); elapsed > 0 {
+		s := &res.total.ops[opSet]
+		rate := float64(s.attempts()-s.errors) / elapsed.Seconds()
+		rep.ExpectedKeys = int64(rate * ((cfg.ttlMin + cfg.ttlMax) / 2).Seconds())
+	}
+	var ts, keys, mem []float64
+	var memTs []float64
+	for _, s := range samples {
+		if s.Keys != nil {
+			ts, keys = append(ts, s.T), append(keys, float64(*s.Keys))
+		}
+		if s.UsedMemory != nil {
+			memTs, mem = append(memTs, s.T), append(mem, float64(*s.UsedMemory))
+		}
+	}
+	if m.noKeys != "" {
+		rep.Notes = append(rep.Notes, fmt.Sprintf("the server does not answer DBSIZE (%s): tracking memory only", m.noKeys))
+	} else if len(keys) > 0 {
+		rep.Keys = newExpireChurnTrend(ts, keys)
+	}
+	if m.noMem != "" {
+		rep.Notes = append(rep.Notes, fmt.Sprintf("used_memory is unavailable (%s): tracking the key count only", m.noMem))
+	} else if len(mem) > 0 {
+		rep.UsedMemory = newExpireChurnTrend(memTs, mem)
+	}
+	for _, t := range []*expireChurnTrend{rep.Keys, rep.UsedMemory} {
+		if t != nil && t.Verdict == churnUndetermined {
+			rep.Notes = append(rep.Notes, fmt.Sprintf("too few samples to judge a trend: the run needs %d of -churn-interval %v", minChurnSamples, cfg.churnInterval))
+			break
+		}
+	}
+	if res.elapsed() < 2*cfg.ttlMax {
+		rep.Notes = append(rep.Notes, fmt.Sprintf("the run lasted less than twice the longest TTL %v: the keys may not have had the time to level off", cfg.ttlMax))
+	}
+	return rep
+}
+
+// newExpireChurnTrend judges the values v sampled at the times ts.
+func newExpireChurnTrend(ts, v []float64) *expireChurnTrend {
+	t := &expireChurnTrend{Verdict: churnUndetermined, First: int64(v[0]), Last: int64(v[len(v)-1])}
+	for _, x := range v {
+		t.Peak = max(t.Peak, int64(x))
+	}
+	if len(v) < minChurnSamples {
+		return t
+	}
+	tail := max(3, int(float64(len(v))*churnTail))
+	tailTs, tailV := ts[len(ts)-tail:], v[len(v)-tail:]
+	t.GrowthPerSec = slope(tailTs, tailV)
+	var level float64
+	for _, x := range tailV {
+		level += x
+	}
+	level /= float64(tail)
+	change := t.GrowthPerSec * (tailTs[tail-1] - tailTs[0])
+	switch {
+	case change > churnTolerance*level:
+		t.Verdict = churnGrowing
+	case -change > churnTolerance*level:
+		t.Verdict = churnDeclining
+	default:
+		t.Verdict, t.Level = churnPlateau, int64(level)
+		for i, x := range v {
+			if x >= (1-churnTolerance)*level {
+				t.TimeToPlateauS = ts[i]
+				break
+			}
+		}
+	}
+	return t
+}
+
+// slope returns the least-squares slope of y over x.
+func slope(x, y []float64) float64 {
+	n := float64(len(x))
+	var sx, sy, sxx, sxy float64
+	for i := range x {
+		sx += x[i]
+		sy += y[i]
+		sxx += x[i] * x[i]
+		sxy += x[i] * y[i]
+	}
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / d
+}
+
+// printExpireChurn writes the -workload churn section of the summary.
+func printExpireChurn(w io.Writer, rep *expireChurnReport) {
+	fmt.Fprintf(w, "Expiration churn: %d samples every %s, expected level about %d keys (SET rate x mean TTL)\n",
+		len(rep.Samples), rep.Interval, rep.ExpectedKeys)
+	printExpireChurnTrend(w, "keys", rep.Keys, func(v float64) string { return fmt.Sprintf("%.0f", v) })
+	printExpireChurnTrend(w, "used_memory", rep.UsedMemory, func(v float64) string { return fmt.Sprintf("%.1f MiB", v/(1<<20)) })
+	for _, note := range rep.Notes {
+		fmt.Fprintf(w, "  note: %s\n", note)
+	}
+}
+
+func printExpireChurnTrend(w io.Writer, name string, t *expireChurnTrend, format func(float64) string) {
+	if t == nil {
+		return
+	}
+	fmt.Fprintf(w, "  %s %s -> %s (peak %s): ", name, format(float64(t.First)), format(float64(t.Last)), format(float64(t.Peak)))
+	switch t.Verdict {
+	case churnPlateau:
+		fmt.Fprintf(w, "plateau at %s after %.1fs, expiration keeps up\n", format(float64(t.Level)), t.TimeToPlateauS)
+	case churnGrowing:
+		fmt.Fprintf(w, "GROWING by %s/s at the end of the run, expiration is lagging\n", format(t.GrowthPerSec))
+	case churnDeclining:
+		fmt.Fprintf(w, "declining by %s/s at the end of the run\n", format(-t.GrowthPerSec))
+	default:
+		fmt.Fprintln(w, "undetermined")
+	}
+}
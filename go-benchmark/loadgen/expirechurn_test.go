@@ -0,0 +1,124 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+func TestExpireChurnDefaults(t *testing.T) {
+	cfg := testConfig(t, "-workload", "churn")
+	if cfg.workload != workloadSet || !cfg.expireChurn || cfg.ttlMin != time.Second || cfg.ttlMax != 10*time.Second || cfg.rate != defaultChurnRate {
+		t.Errorf("workload %s, churn %v, TTL %v:%v, rate %v", cfg.workload, cfg.expireChurn, cfg.ttlMin, cfg.ttlMax, cfg.rate)
+	}
+	cfg = testConfig(t, "-workload", "churn", "-ttl", "5s", "-rate", "0")
+	if cfg.ttlMin != 5*time.Second || cfg.rate != 0 {
+		t.Errorf("TTL %v, rate %v", cfg.ttlMin, cfg.rate)
+	}
+	for _, args := range [][]string{
+		{"-workload", "churn", "-keyspace", "100"},
+		{"-workload", "churn", "-ttl", ""},
+		{"-workload", "churn", "-churn-interval", "0s"},
+		{"-churn-interval", "1s"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
+
+func TestExpireChurnTrend(t *testing.T) {
+	var ts, flat, rising []float64
+	for i := 0; i < 20; i++ {
+		ts = append(ts, float64(i))
+		flat = append(flat, float64(min(i, 5)*100))
+		rising = append(rising, float64(i*100))
+	}
+	if tr := newExpireChurnTrend(ts, flat); tr.Verdict != churnPlateau || tr.Level != 500 || tr.TimeToPlateauS != 5 {
+		t.Errorf("levelling series %+v", tr)
+	}
+	if tr := newExpireChurnTrend(ts, rising); tr.Verdict != churnGrowing || tr.GrowthPerSec < 99 || tr.GrowthPerSec > 101 {
+		t.Errorf("rising series %+v", tr)
+	}
+	if tr := newExpireChurnTrend(ts[:5], rising[:5]); tr.Verdict != churnUndetermined {
+		t.Errorf("short series %+v", tr)
+	}
+}
+
+func TestExpireChurnMemoryOnly(t *testing.T) {
+	cfg := testConfig(t, "-workload", "churn")
+	m := &expireChurnMonitor{noKeys: "ERR unknown command 'dbsize'"}
+	var samples []expireChurnSample
+	for i := 0; i < 10; i++ {
+		used := int64(1 << 20)
+		samples = append(samples, expireChurnSample{T: float64(i), UsedMemory: &used})
+	}
+	rep := buildExpireChurn(cfg, m, samples, &runResult{total: newWorkerResult(stats.DefaultLayout)})
+	if rep.Keys != nil || rep.UsedMemory == nil || rep.UsedMemory.Verdict != churnPlateau {
+		t.Fatalf("report %+v", rep)
+	}
+	var buf bytes.Buffer
+	printExpireChurn(&buf, rep)
+	if !strings.Contains(buf.String(), "does not answer DBSIZE") || !strings.Contains(buf.String(), "used_memory 1.0 MiB -> 1.0 MiB") {
+		t.Errorf("summary:\n%s", buf.String())
+	}
+}
+
+func TestExpireChurnRun(t *testing.T) {
+	mr, _ := newTestServer(t)
+	args := []string{"-addr", mr.Addr(), "-clients", "2", "-workload", "churn", "-duration", "1200ms",
+		"-ttl", "100ms:200ms", "-rate", "2000", "-churn-interval", "50ms"}
+
+	// Without its clock advanced the server never expires a key.
+	cfg := testConfig(t, args...)
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := res.expireChurn
+	if rep == nil || rep.Keys == nil || rep.Keys.Verdict != churnGrowing {
+		t.Fatalf("lagging expiration %+v", rep)
+	}
+	if rep.UsedMemory != nil || !strings.Contains(strings.Join(rep.Notes, "\n"), "used_memory is unavailable") {
+		t.Errorf("memory %+v, notes %q", rep.UsedMemory, rep.Notes)
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "GROWING by") {
+		t.Errorf("summary:\n%s", buf.String())
+	}
+	if buildReport(cfg, res).ExpireChurn != rep {
+		t.Error("the JSON report lacks the expiration churn")
+	}
+
+	// Advanced in step with the wall clock, it expires keys as they are due.
+	// The TTLs are long enough for a step of the clock to expire a small
+	// share of the keys at once.
+	mr.FlushAll()
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		last := time.Now()
+		for {
+			select {
+			case <-stop:
+				return
+			case now := <-time.After(10 * time.Millisecond):
+				mr.FastForward(now.Sub(last))
+				last = now
+			}
+		}
+	}()
+	cfg = testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-workload", "churn", "-duration", "2500ms",
+		"-ttl", "400ms:800ms", "-rate", "2000", "-churn-interval", "50ms")
+	if res, err = runTarget(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if k := res.expireChurn.Keys; k == nil || k.Verdict != churnPlateau || k.Level == 0 || k.TimeToPlateauS <= 0 {
+		t.Errorf("expiration keeping up %+v", k)
+	}
+}
@@ -0,0 +1,328 @@
+package loadgen
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// -verify-expired writes a fraction of the SETs of a run under fresh keys
+// with the short -expired-ttl, then reads each back once that TTL and
+// -expired-grace have passed: any value the GET returns outlived its TTL, a
+// correctness failure. The keys awaiting their read sit in a heap ordered by
+// when they are due, of at most -expired-sample keys.
+
+// expiredBatch is the most due keys read in one pipeline.
+const expiredBatch = 256
+
+// expiredFailureLog is how many failures the report details.
+const expiredFailureLog = 100
+
+// validateReadAfterExpiry checks -verify-expired and the flags it uses.
+func (c *config) validateReadAfterExpiry() error {
+	if c.verifyExpired == 0 {
+		for _, name := range []string{"expired-ttl", "expired-grace", "expired-sample"} {
+			if _, ok := c.explicit[name]; ok {
+				return fmt.Errorf("-%s requires -verify-expired", name)
+			}
+		}
+		return nil
+	}
+	switch {
+	case c.verifyExpired < 0 || c.verifyExpired > 1:
+		return fmt.Errorf("-verify-expired must be in (0, 1], got %v", c.verifyExpired)
+	case c.expiredTTL < time.Millisecond:
+		// SET takes its TTL in milliseconds.
+		return fmt.Errorf("-expired-ttl must be at least 1ms, got %v", c.expiredTTL)
+	case c.expiredGrace < 0:
+		return fmt.Errorf("-expired-grace must not be negative, got %v", c.expiredGrace)
+	case c.expiredSample <= 0:
+		return fmt.Errorf("-expired-sample must be positive, got %d", c.expiredSample)
+	case c.protocol != protocolRedis:
+		return errors.New("-verify-expired reads back with a Redis client, not -protocol")
+	case c.replayPath != "" || !c.issuesSets():
+		return errors.New("-verify-expired needs a workload issuing SETs")
+	case c.verifyFinal:
+		// The final state would miss the keys that expired.
+		return errors.New("-verify-expired and -verify-final cannot be combined")
+	}
+	return nil
+}
+
+// issuesSets reports whether the workload issues SETs of its own.
+func (c *config) issuesSets() bool {
+	if c.mix != nil {
+		return c.mix.share(opSet) > 0
+	}
+	return c.workload == workloadSet
+}
+
+// expiredKey is a key -verify-expired tracks: its SET was sent at written
+// and answered at acked, and it is read at due, once ttl and the grace
+// period have passed since acked.
+type expiredKey struct {
+	key     string
+	ttl     time.Duration
+	written time.Time
+	acked   time.Time
+	due     time.Time
+}
+
+// expiredHeap orders the tracked keys by when they are due.
+type expiredHeap []*expiredKey
+
+func (h expiredHeap) Len() int           { return len(h) }
+func (h expiredHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h expiredHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *expiredHeap) Push(x any)        { *h = append(*h, x.(*expiredKey)) }
+func (h *expiredHeap) Pop() any {
+	old := *h
+	k := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return k
+}
+
+// expiredChecker holds the keys of -verify-expired until they are due and
+// reads them back on a connection of its own while the run goes on. Once
+// size keys wait, a newcomer replaces a random one with the probability of
+// reservoir sampling, so memory stays bounded however fast keys come.
+type expiredChecker struct {
+	size       int
+	ttl, grace time.Duration
+
+	mu   sync.Mutex
+	keys expiredHeap
+	seen int64
+	// wake tells the checker of a key at the top of an empty heap.
+	wake chan struct{}
+
+	rdb    redis.UniversalClient
+	logger *slog.Logger
+	drain  chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+	// The fields below are only used by the checking goroutine.
+	checked, failed, errors int64
+	failures                []expiredFailure
+}
+
+func startExpiredChecker(cfg *config) *expiredChecker {
+	own := *cfg
+	own.poolSize = 1
+	rdb, _ := newClient(&own)
+	c := &expiredChecker{size: cfg.expiredSample, ttl: cfg.expiredTTL, grace: cfg.expiredGrace, rdb: rdb, logger: cfg.logger,
+		wake: make(chan struct{}, 1), drain: make(chan struct{}), stop: make(chan struct{}), done: make(chan struct{})}
+	go c.run()
+	return c
+}
+
+// offer tracks k, a key written with a TTL, or replaces a random tracked
+// key with it (Vitter's algorithm R).
+func (c *expiredChecker) offer(rng *rand.Rand, k expiredKey) {
+	k.due = k.acked.Add(k.ttl + c.grace)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seen++
+	if len(c.keys) < c.size {
+		heap.Push(&c.keys, &k)
+		if len(c.keys) == 1 {
+			select {
+			case c.wake <- struct{}{}:
+			default:
+			}
+		}
+		return
+	}
+	if j := rng.Int63n(c.seen); j < int64(c.size) {
+		c.keys[j] = &k
+		heap.Fix(&c.keys, int(j))
+	}
+}
+
+// popDue removes up to expiredBatch keys due before now, and returns them
+// with how long until the next key is due, negative when none waits.
+func (c *expiredChecker) popDue(now time.Time) ([]*expiredKey, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var due []*expiredKey
+	for len(c.keys) > 0 && c.keys[0].due.Before(now) && len(due) < expiredBatch {
+		due = append(due, heap.Pop(&c.keys).(*expiredKey))
+	}
+	if len(c.keys) == 0 {
+		return due, -1
+	}
+	return due, c.keys[0].due.Sub(now)
+}
+
+func (c *expiredChecker) run() {
+	defer close(c.done)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	drain := c.drain
+	for {
+		due, next := c.popDue(time.Now())
+		if len(due) > 0 {
+			c.check(due)
+			continue
+		}
+		if next < 0 && drain == nil {
+			return
+		}
+		var expired <-chan time.Time
+		if next >= 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			// Strictly after the due time.
+			timer.Reset(next + time.Millisecond)
+			expired = timer.C
+		}
+		select {
+		case <-expired:
+		case <-c.wake:
+		case <-drain:
+			drain = nil
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// check reads the due keys back in one pipeline.
+func (c *expiredChecker) check(due []*expiredKey) {
+	pipe := c.rdb.Pipeline()
+	cmds := make([]*redis.StringCmd, len(due))
+	for i, k := range due {
+		cmds[i] = pipe.Get(context.Background(), k.key)
+	}
+	read := time.Now()
+	_, _ = pipe.Exec(context.Background())
+	for i, k := range due {
+		v, err := cmds[i].Result()
+		switch {
+		case errors.Is(err, redis.Nil):
+			c.checked++
+		case err != nil:
+			c.errors++
+		default:
+			c.checked++
+			c.failed++
+			c.fail(k, []byte(v), read)
+		}
+	}
+}
+
+// fail logs a key read back after its TTL ran out and keeps the first
+// expiredFailureLog for the report.
+func (c *expiredChecker) fail(k *expiredKey, value []byte, read time.Time) {
+	h, kind := decodeValue(value, k.key)
+	if kind == valueIntact {
+		kind = "intact"
+	}
+	f := expiredFailure{Key: k.key, TTLNs: int64(k.ttl), Written: k.written, Acked: k.acked, Read: read,
+		LateNs: int64(read.Sub(k.acked.Add(k.ttl))), Value: kind}
+	if kind == "intact" && len(value) >= valueHeaderSize {
+		f.Writer, f.Seq = &h.writer, &h.seq
+	}
+	c.logger.Error("CORRECTNESS FAILURE: a GET after the TTL returned a value", "key", k.key, "ttl", k.ttl,
+		"written", k.written.Format(time.RFC3339Nano), "read", read.Format(time.RFC3339Nano), "value", kind,
+		"writer", h.writer, "seq", h.seq)
+	if len(c.failures) < expiredFailureLog {
+		c.failures = append(c.failures, f)
+	}
+}
+
+// finish waits for the keys still tracked to come due and be read, or for
+// ctx to be done, and reports what the reads showed.
+func (c *expiredChecker) finish(ctx context.Context) *expiredReport {
+	close(c.drain)
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		close(c.stop)
+		<-c.done
+	}
+	c.rdb.Close()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &expiredReport{
+		TTLNs:     int64(c.ttl),
+		GraceNs:   int64(c.grace),
+		Written:   c.seen,
+		Checked:   c.checked,
+		Failed:    c.failed,
+		Errors:    c.errors,
+		Unchecked: len(c.keys),
+		Failures:  c.failures,
+	}
+}
+
+// expiredReport is the outcome of -verify-expired. Written counts the SETs
+// of the run given the short TTL, and Checked those read back after it,
+// tracked at most -expired-sample at a time; every one that still held a
+// value Failed.
+type expiredReport struct {
+	TTLNs     int64            `json:"ttl_ns"`
+	GraceNs   int64            `json:"grace_ns"`
+	Written   int64            `json:"written"`
+	Checked   int64            `json:"checked"`
+	Failed    int64            `json:"failed"`
+	Errors    int64            `json:"errors,omitempty"`
+	Unchecked int              `json:"unchecked,omitempty"`
+	Failures  []expiredFailure `json:"failures,omitempty"`
+}
+
+// expiredFailure is a key a GET found after its TTL. Value is how the value
+// read decoded; Writer and Seq are those of its header when intact.
+type expiredFailure struct {
+	Key     string    `json:"key"`
+	TTLNs   int64     `json:"ttl_ns"`
+	Written time.Time `json:"written"`
+	Acked   time.Time `json:"acked"`
+	Read    time.Time `json:"read"`
+	// LateNs is how long after the TTL ran out the GET was sent.
+	LateNs int64   `json:"late_ns"`
+	Value  string  `json:"value"`
+	Writer *int    `json:"writer,omitempty"`
+	Seq    *uint64 `json:"seq,omitempty"`
+}
+
+func (r *expiredReport) failed() bool {
+	return r != nil && r.Failed > 0
+}
+
+// printExpiredReport writes the -verify-expired section of the summary.
+func printExpiredReport(w io.Writer, rep *expiredReport) {
+	fmt.Fprintf(w, "Read after expiry: %d of %d keys with TTL %v read back after %v of grace, %d still held a value",
+		rep.Checked, rep.Written, time.Duration(rep.TTLNs), time.Duration(rep.GraceNs), rep.Failed)
+	if rep.Errors > 0 {
+		fmt.Fprintf(w, ", %d errors", rep.Errors)
+	}
+	if rep.Unchecked > 0 {
+		fmt.Fprintf(w, ", %d not read before the interrupt", rep.Unchecked)
+	}
+	fmt.Fprintln(w)
+	if rep.failed() {
+		fmt.Fprintf(w, "CORRECTNESS FAILURE: %d keys were read back after their TTL ran out\n", rep.Failed)
+		for _, f := range rep.Failures {
+			fmt.Fprintf(w, "  %s: TTL %v, written %s, read %v after expiry, %s value", f.Key, time.Duration(f.TTLNs),
+				f.Written.Format(time.RFC3339Nano), time.Duration(f.LateNs), f.Value)
+			if f.Writer != nil {
+				fmt.Fprintf(w, " of client %d, seq %d", *f.Writer, *f.Seq)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
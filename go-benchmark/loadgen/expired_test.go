@@ -0,0 +1,108 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpiredCheckerBoundsItsKeys(t *testing.T) {
+	c := &expiredChecker{size: 10, wake: make(chan struct{}, 1)}
+	rng := rand.New(rand.NewSource(1))
+	now := time.Now()
+	for i := 0; i < 1000; i++ {
+		c.offer(rng, expiredKey{key: "k", ttl: time.Duration(i) * time.Millisecond, acked: now})
+	}
+	if c.seen != 1000 || len(c.keys) != 10 {
+		t.Fatalf("%d of %d keys tracked", len(c.keys), c.seen)
+	}
+	// The sample reaches past the first keys offered, and comes out in
+	// the order it is due.
+	due, next := c.popDue(now.Add(time.Hour))
+	if len(due) != 10 || next >= 0 || due[9].ttl < 10*time.Millisecond {
+		t.Fatalf("%d due, next in %v", len(due), next)
+	}
+	for i := 1; i < len(due); i++ {
+		if due[i].due.Before(due[i-1].due) {
+			t.Errorf("key %d due at %v before %v", i, due[i].due, due[i-1].due)
+		}
+	}
+}
+
+func TestVerifyExpiredRun(t *testing.T) {
+	mr, _ := newTestServer(t)
+	// The in-process server expires keys only as its clock is advanced.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+				mr.FastForward(time.Second)
+			}
+		}
+	}()
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-ops", "40", "-verify-expired", "0.5",
+		"-expired-ttl", "20ms", "-expired-grace", "10ms")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := res.expired
+	if rep == nil || rep.Written == 0 || rep.Checked != rep.Written || rep.failed() || res.verifyFailed() {
+		t.Fatalf("read after expiry %+v", rep)
+	}
+	if buildReport(cfg, res).ReadAfterExpiry != rep {
+		t.Error("the JSON report lacks the read after expiry")
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "Read after expiry: ") {
+		t.Errorf("summary lacks the read after expiry:\n%s", buf.String())
+	}
+}
+
+func TestVerifyExpiredFindsLiveKeys(t *testing.T) {
+	// Without its clock advanced the server never expires a key.
+	mr, _ := newTestServer(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-ops", "20", "-value-size", "64",
+		"-verify-expired", "1", "-expired-ttl", "5ms", "-expired-grace", "0s")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := res.expired
+	if rep == nil || rep.Failed != 40 || !res.verifyFailed() || len(rep.Failures) != 40 {
+		t.Fatalf("read after expiry %+v", rep)
+	}
+	if f := rep.Failures[0]; f.Value != "intact" || f.Writer == nil || f.LateNs <= 0 || !f.Read.After(f.Acked) {
+		t.Errorf("failure %+v", f)
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "CORRECTNESS FAILURE: 40 keys were read back after their TTL ran out") {
+		t.Errorf("summary lacks the failure:\n%s", buf.String())
+	}
+}
+
+func TestValidateReadAfterExpiry(t *testing.T) {
+	for _, args := range [][]string{
+		{"-expired-ttl", "1s"},
+		{"-verify-expired", "1.5"},
+		{"-verify-expired", "0.1", "-expired-ttl", "100us"},
+		{"-verify-expired", "0.1", "-expired-sample", "0"},
+		{"-verify-expired", "0.1", "-workload", "get"},
+		{"-verify-expired", "0.1", "-verify-final"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
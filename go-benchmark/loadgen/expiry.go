@@ -0,0 +1,154 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+// expirySample is a key written with a TTL together with the moment by which
+// the server must have expired it.
+type expirySample struct {
+	key      string
+	deadline time.Time
+}
+
+// expiryReservoir keeps a uniform random sample of at most size keys out of
+// all TTL'd SETs a worker issued (Vitter's algorithm R), so tracking expiry
+// costs fixed memory however long the run is.
+type expiryReservoir struct {
+	size    int
+	seen    int64
+	samples []expirySample
+	// watch is told the keys sampled and replaced under
+	// -watch-notifications; nil without it.
+	watch *notifyWatcher
+}
+
+func (r *expiryReservoir) offer(rng *rand.Rand, s expirySample) {
+	r.seen++
+	if len(r.samples) < r.size {
+		r.samples = append(r.samples, s)
+		r.watch.want(s.key)
+		return
+	}
+	if j := rng.Int63n(r.seen); j < int64(r.size) {
+		r.watch.forget(r.samples[j].key)
+		r.samples[j] = s
+		r.watch.want(s.key)
+	}
+}
+
+// expiryReport summarises the observed expiration behaviour.
+type expiryReport struct {
+	Sampled int `json:"sampled"`
+	// PresentAfterTTL counts keys still readable at the first check after
+	// their deadline.
+	PresentAfterTTL int `json:"present_after_ttl"`
+	// NeverExpired counts keys still present once the grace period ran out.
+	NeverExpired int `json:"never_expired"`
+	// Lateness is how long past its deadline each key was last seen alive,
+	// zero for keys that expired on time.
+	Lateness *latencySummary `json:"lateness"`
+
+	lateness *stats.Histogram
+}
+
+// expiryPollInterval is how often sampled keys are checked once due.
+const expiryPollInterval = 50 * time.Millisecond
+
+// verifyExpiry waits for every sampled key to reach its deadline and then
+// polls it until it disappears or grace has passed, recording how long it
+// outlived its TTL into a histogram of layout l. Keys are checked in
+// pipelined batches of EXISTS.
+func verifyExpiry(ctx context.Context, rdb redis.UniversalClient, samples []expirySample, grace time.Duration, l stats.Layout) *expiryReport {
+	rep := &expiryReport{Sampled: len(samples), lateness: l.New()}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].deadline.Before(samples[j].deadline) })
+
+	type tracked struct {
+		expirySample
+		checked  bool
+		lastSeen time.Time
+	}
+	pending := make([]*tracked, len(samples))
+	for i, s := range samples {
+		pending[i] = &tracked{expirySample: s}
+	}
+
+	t := time.NewTicker(expiryPollInterval)
+	defer t.Stop()
+	for len(pending) > 0 {
+		select {
+		case <-ctx.Done():
+			// Whatever is left was not verified; count it as unresolved.
+			rep.NeverExpired += len(pending)
+			rep.Lateness = summarizeLatency(rep.lateness)
+			return rep
+		case <-t.C:
+		}
+		now := time.Now()
+		due := 0
+		for due < len(pending) && !pending[due].deadline.After(now) {
+			due++
+		}
+		if due == 0 {
+			continue
+		}
+
+		pipe := rdb.Pipeline()
+		cmds := make([]*redis.IntCmd, due)
+		for i := 0; i < due; i++ {
+			cmds[i] = pipe.Exists(ctx, pending[i].key)
+		}
+		_, _ = pipe.Exec(ctx)
+
+		remaining := pending[:0]
+		for i, p := range pending {
+			if i >= due {
+				remaining = append(remaining, p)
+				continue
+			}
+			present := cmds[i].Err() == nil && cmds[i].Val() > 0
+			if present {
+				if !p.checked {
+					rep.PresentAfterTTL++
+				}
+				p.lastSeen = now
+			}
+			p.checked = true
+			switch {
+			case !present:
+				late := time.Duration(0)
+				if !p.lastSeen.IsZero() {
+					late = p.lastSeen.Sub(p.deadline)
+				}
+				rep.lateness.Record(late)
+			case now.Sub(p.deadline) > grace:
+				rep.NeverExpired++
+				rep.lateness.Record(now.Sub(p.deadline))
+			default:
+				remaining = append(remaining, p)
+			}
+		}
+		pending = remaining
+	}
+	rep.Lateness = summarizeLatency(rep.lateness)
+	return rep
+}
+
+// printExpiryReport writes the expiry verification section.
+func printExpiryReport(w io.Writer, rep *expiryReport) {
+	fmt.Fprintf(w, "Expiry verification (%d sampled keys):\n", rep.Sampled)
+	fmt.Fprintf(w, "  present after TTL: %d\n", rep.PresentAfterTTL)
+	fmt.Fprintf(w, "  never expired within grace: %d\n", rep.NeverExpired)
+	if h := rep.lateness; h.Count() > 0 {
+		fmt.Fprintf(w, "  lateness p99: %v, max: %v\n", h.Percentile(99), h.Maximum())
+	}
+}
@@ -0,0 +1,49 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+func TestExpiryReservoirBounded(t *testing.T) {
+	r := &expiryReservoir{size: 10}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		r.offer(rng, expirySample{key: fmt.Sprint(i)})
+	}
+	if len(r.samples) != 10 || r.seen != 10000 {
+		t.Errorf("reservoir holds %d of %d seen, want 10", len(r.samples), r.seen)
+	}
+}
+
+func TestVerifyExpiry(t *testing.T) {
+	mr, rdb := newTestServer(t)
+	deadline := time.Now().Add(20 * time.Millisecond)
+	var samples []expirySample
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("k%d", i)
+		mr.Set(key, "v")
+		samples = append(samples, expirySample{key: key, deadline: deadline})
+	}
+	// Half the keys expire on time; the other half outlive their TTL.
+	for i := 0; i < 5; i++ {
+		mr.Del(fmt.Sprintf("k%d", i))
+	}
+
+	rep := verifyExpiry(context.Background(), rdb, samples, 150*time.Millisecond, stats.DefaultLayout)
+
+	if rep.Sampled != 10 || rep.PresentAfterTTL != 5 || rep.NeverExpired != 5 {
+		t.Errorf("report = %+v, want 10 sampled, 5 present after TTL, 5 never expired", rep)
+	}
+	if max := rep.lateness.Maximum(); max < 150*time.Millisecond {
+		t.Errorf("max lateness %v, want at least the grace period", max)
+	}
+	if p50 := rep.lateness.Percentile(50); p50 != 0 {
+		t.Errorf("median lateness %v, want 0 for keys that expired on time", p50)
+	}
+}
@@ -0,0 +1,145 @@
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// fairnessSlowest is the number of clients -fairness lists by name.
+const fairnessSlowest = 5
+
+// clientStats is what -fairness keeps of one client's measured operations
+// once its result was merged into the total.
+type clientStats struct {
+	client int
+	ops    int64
+	errors int64
+	mean   time.Duration
+	p99    time.Duration
+}
+
+// collectClients summarises the result of every client, indexed by client,
+// before they are merged. A client still waiting for its ramp-up slot when
+// the run ended shows zero operations.
+func collectClients(results []*workerResult) []clientStats {
+	clients := make([]clientStats, len(results))
+	for i, r := range results {
+		clients[i] = clientStats{
+			client: i,
+			ops:    r.attempts(),
+			errors: r.errors(),
+			mean:   r.latency.Mean(),
+			p99:    r.latency.Percentile(99),
+		}
+	}
+	return clients
+}
+
+// fairness is the distribution of operations across clients.
+type fairness struct {
+	minOps, medianOps, maxOps int64
+	// cv is the coefficient of variation of the operations per client: their
+	// standard deviation over their mean.
+	cv float64
+	// slowest are the clients with the highest p99, slowest first.
+	slowest []clientStats
+}
+
+func buildFairness(clients []clientStats) fairness {
+	var f fairness
+	if len(clients) == 0 {
+		return f
+	}
+	ops := make([]int64, len(clients))
+	var sum float64
+	for i, c := range clients {
+		ops[i] = c.ops
+		sum += float64(c.ops)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i] < ops[j] })
+	f.minOps, f.maxOps = ops[0], ops[len(ops)-1]
+	if n := len(ops); n%2 == 1 {
+		f.medianOps = ops[n/2]
+	} else {
+		f.medianOps = (ops[n/2-1] + ops[n/2]) / 2
+	}
+	if mean := sum / float64(len(ops)); mean > 0 {
+		var sq float64
+		for _, n := range ops {
+			sq += (float64(n) - mean) * (float64(n) - mean)
+		}
+		f.cv = math.Sqrt(sq/float64(len(ops))) / mean
+	}
+	slowest := append([]clientStats(nil), clients...)
+	sort.SliceStable(slowest, func(i, j int) bool {
+		if slowest[i].p99 != slowest[j].p99 {
+			return slowest[i].p99 > slowest[j].p99
+		}
+		return slowest[i].mean > slowest[j].mean
+	})
+	f.slowest = slowest[:min(fairnessSlowest, len(slowest))]
+	return f
+}
+
+// jsonClient is one client of the -fairness section of the JSON report.
+type jsonClient struct {
+	Client int   `json:"client"`
+	Ops    int64 `json:"ops"`
+	Errors int64 `json:"errors"`
+	MeanNs int64 `json:"mean_ns"`
+	P99Ns  int64 `json:"p99_ns"`
+}
+
+func toJSONClient(c clientStats) jsonClient {
+	return jsonClient{Client: c.client, Ops: c.ops, Errors: c.errors, MeanNs: int64(c.mean), P99Ns: int64(c.p99)}
+}
+
+// jsonFairness is the -fairness section of the JSON report.
+type jsonFairness struct {
+	MinOps    int64   `json:"min_ops"`
+	MedianOps int64   `json:"median_ops"`
+	MaxOps    int64   `json:"max_ops"`
+	CV        float64 `json:"coefficient_of_variation"`
+	// Slowest lists the clients with the highest p99, slowest first, and
+	// Clients every client by index.
+	Slowest []jsonClient `json:"slowest"`
+	Clients []jsonClient `json:"clients"`
+}
+
+// buildFairnessReport returns the -fairness section, nil when the flag is
+// not set.
+func buildFairnessReport(res *runResult) *jsonFairness {
+	if res.clients == nil {
+		return nil
+	}
+	f := buildFairness(res.clients)
+	rep := &jsonFairness{MinOps: f.minOps, MedianOps: f.medianOps, MaxOps: f.maxOps, CV: f.cv}
+	for _, c := range f.slowest {
+		rep.Slowest = append(rep.Slowest, toJSONClient(c))
+	}
+	rep.Clients = make([]jsonClient, len(res.clients))
+	for i, c := range res.clients {
+		rep.Clients[i] = toJSONClient(c)
+	}
+	return rep
+}
+
+// printFairness writes the -fairness section of the summary.
+func printFairness(w io.Writer, res *runResult) {
+	if res.clients == nil {
+		return
+	}
+	f := buildFairness(res.clients)
+	fmt.Fprintf(w, "Fairness across %d clients: ops per client min %d, median %d, max %d, coefficient of variation %.3f\n",
+		len(res.clients), f.minOps, f.medianOps, f.maxOps, f.cv)
+	if len(f.slowest) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "  %-8s %10s %8s %12s %12s\n", "client", "ops", "errors", "mean", "p99")
+	for _, c := range f.slowest {
+		fmt.Fprintf(w, "  %-8d %10d %8d %12v %12v\n", c.client, c.ops, c.errors, c.mean, c.p99)
+	}
+}
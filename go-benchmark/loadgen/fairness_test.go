@@ -0,0 +1,88 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildFairness(t *testing.T) {
+	clients := []clientStats{
+		{client: 0, ops: 100, p99: time.Millisecond},
+		{client: 1, ops: 100, p99: 3 * time.Millisecond},
+		{client: 2, ops: 40, p99: 9 * time.Millisecond},
+		{client: 3, ops: 160, p99: 2 * time.Millisecond, mean: time.Millisecond},
+		{client: 4, ops: 100, p99: 2 * time.Millisecond},
+		{client: 5, ops: 100, p99: 0},
+	}
+	f := buildFairness(clients)
+	if f.minOps != 40 || f.medianOps != 100 || f.maxOps != 160 {
+		t.Errorf("ops min %d, median %d, max %d", f.minOps, f.medianOps, f.maxOps)
+	}
+	// The mean is 100 and the variance (60² + 60²) / 6.
+	if want := math.Sqrt(1200) / 100; math.Abs(f.cv-want) > 1e-9 {
+		t.Errorf("coefficient of variation %v, want %v", f.cv, want)
+	}
+	var order []int
+	for _, c := range f.slowest {
+		order = append(order, c.client)
+	}
+	// Equal p99s rank the higher mean first.
+	if got, want := fmt.Sprint(order), "[2 1 3 4 0]"; got != want {
+		t.Errorf("slowest clients %s, want %s", got, want)
+	}
+	if f := buildFairness([]clientStats{{ops: 0}, {ops: 0}}); f.cv != 0 || f.maxOps != 0 {
+		t.Errorf("clients without operations gave %+v", f)
+	}
+}
+
+func TestFairnessReport(t *testing.T) {
+	addr := newTestServerAddr(t)
+	cfg := testConfig(t, "-addr", addr, "-clients", "8", "-duration", "200ms", "-fairness")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.clients) != 8 {
+		t.Fatalf("statistics of %d clients kept, want 8", len(res.clients))
+	}
+	var sum int64
+	for i, c := range res.clients {
+		if c.client != i || c.ops == 0 || c.p99 < c.mean/2 {
+			t.Errorf("client %d: %+v", i, c)
+		}
+		sum += c.ops
+	}
+	if sum != res.total.attempts() {
+		t.Errorf("clients account for %d of %d operations", sum, res.total.attempts())
+	}
+
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	for _, want := range []string{"Fairness across 8 clients", "coefficient of variation", "client"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, buf.String())
+		}
+	}
+	var rep struct {
+		Fairness *jsonFairness `json:"fairness"`
+	}
+	buf.Reset()
+	if err := writeJSON(&buf, buildReport(cfg, res)); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil || rep.Fairness == nil || len(rep.Fairness.Clients) != 8 || len(rep.Fairness.Slowest) != fairnessSlowest {
+		t.Errorf("JSON fairness section %+v, %v", rep.Fairness, err)
+	}
+
+	// Without the flag nothing is kept.
+	cfg = testConfig(t, "-addr", addr, "-clients", "2", "-ops", "10")
+	if res, err = runTarget(context.Background(), cfg); err != nil || res.clients != nil || buildFairnessReport(res) != nil {
+		t.Errorf("a run without -fairness kept %d clients, %v", len(res.clients), err)
+	}
+}
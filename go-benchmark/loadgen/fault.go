@@ -0,0 +1,273 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Faults of -fault-inject, a developer mode that checks the tool's own
+// accounting: delay holds a command back before it is sent, drop loses its
+// reply so the command times out, reset fails it with a connection reset and
+// corrupt flips a bit of a GET reply.
+const (
+	faultDelay = iota
+	faultDrop
+	faultReset
+	faultCorrupt
+	numFaults
+)
+
+var faultNames = [numFaults]string{"delay", "drop", "reset", "corrupt"}
+
+// faultSalt separates the fault generator from the workers' command
+// generators, so that adding -fault-inject does not change the commands
+// sent.
+const faultSalt = 0x6661756c74
+
+// faultPlan is the parsed -fault-inject: the probability of every fault per
+// command, and how long a delay lasts.
+type faultPlan struct {
+	prob  [numFaults]float64
+	delay time.Duration
+}
+
+// parseFaultPlan parses delay=P:DURATION,drop=P,reset=P,corrupt=P, any
+// subset in any order. A command suffers at most one fault, so the
+// probabilities add up to at most 1.
+func parseFaultPlan(s string) (*faultPlan, error) {
+	plan := &faultPlan{}
+	var seen [numFaults]bool
+	var total float64
+	for _, part := range strings.Split(s, ",") {
+		name, arg, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid fault %q: want NAME=PROBABILITY", part)
+		}
+		f := -1
+		for i, n := range faultNames {
+			if n == name {
+				f = i
+			}
+		}
+		if f < 0 {
+			return nil, fmt.Errorf("unknown fault %q: want %s", name, strings.Join(faultNames[:], ", "))
+		}
+		if seen[f] {
+			return nil, fmt.Errorf("fault %s given twice", name)
+		}
+		seen[f] = true
+		if f == faultDelay {
+			var d string
+			if arg, d, ok = strings.Cut(arg, ":"); !ok {
+				return nil, fmt.Errorf("invalid fault %q: want delay=PROBABILITY:DURATION", part)
+			}
+			var err error
+			if plan.delay, err = time.ParseDuration(d); err != nil || plan.delay <= 0 {
+				return nil, fmt.Errorf("invalid fault %q: want a positive delay", part)
+			}
+		}
+		p, err := strconv.ParseFloat(arg, 64)
+		if err != nil || p < 0 || p > 1 {
+			return nil, fmt.Errorf("invalid fault %q: want a probability in [0, 1]", part)
+		}
+		plan.prob[f] = p
+		total += p
+	}
+	if total > 1 {
+		return nil, fmt.Errorf("fault probabilities add up to %v, over 1", total)
+	}
+	return plan, nil
+}
+
+// validateFaults checks -fault-inject. The faults are injected by a hook of
+// the go-redis client, so they need a workload that runs through its pool.
+func (c *config) validateFaults() error {
+	if c.faultSpec == "" {
+		return nil
+	}
+	var err error
+	if c.faultPlan, err = parseFaultPlan(c.faultSpec); err != nil {
+		return fmt.Errorf("-fault-inject: %w", err)
+	}
+	if c.protocol != protocolRedis || !c.usesPool() || c.dbs != nil {
+		return errors.New("-fault-inject needs the pooled go-redis client: not -protocol, -client raw, -churn, -resilience, -dbs or the tracking workload")
+	}
+	return nil
+}
+
+// faultInjector is the go-redis hook of -fault-inject. It draws the fault
+// of every command from a generator seeded with the run seed.
+type faultInjector struct {
+	plan *faultPlan
+	// readTimeout is how long the client waits for a reply that never
+	// comes, negative for no limit.
+	readTimeout time.Duration
+
+	mu  sync.Mutex
+	rng *rand.Rand
+
+	injected [numFaults]atomic.Int64
+	// disarmed is set once the run is over, so the checks after it see
+	// the server as it is.
+	disarmed atomic.Bool
+}
+
+// faultKey is the context key under which BeforeProcess hands its draw to
+// AfterProcess.
+type faultKey struct{}
+
+func newFaultInjector(cfg *config) *faultInjector {
+	t := cfg.clientOptions().ReadTimeout
+	if t == 0 {
+		// The go-redis default.
+		t = 3 * time.Second
+	}
+	return &faultInjector{plan: cfg.faultPlan, readTimeout: t, rng: rand.New(rand.NewSource(cfg.seed ^ faultSalt))}
+}
+
+// draw returns the fault of the next command, -1 for none. A corruption is
+// only counted once after finds a reply to corrupt.
+func (fi *faultInjector) draw() int {
+	if fi.disarmed.Load() {
+		return -1
+	}
+	fi.mu.Lock()
+	u := fi.rng.Float64()
+	fi.mu.Unlock()
+	for f, p := range fi.plan.prob {
+		if u < p {
+			if f != faultCorrupt {
+				fi.injected[f].Add(1)
+			}
+			return f
+		}
+		u -= p
+	}
+	return -1
+}
+
+// errInjectedReset is the error of a reset command, which classifies as
+// the reset of a real connection does.
+var errInjectedReset = &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+
+// injectedTimeout is the error of a command whose reply was dropped once
+// the client's read timeout expires.
+type injectedTimeout struct{}
+
+func (injectedTimeout) Error() string   { return "injected fault: reply dropped: i/o timeout" }
+func (injectedTimeout) Timeout() bool   { return true }
+func (injectedTimeout) Temporary() bool { return true }
+
+// before injects the faults that strike before the commands are sent.
+func (fi *faultInjector) before(ctx context.Context) (context.Context, error) {
+	f := fi.draw()
+	switch f {
+	case faultDelay:
+		t := time.NewTimer(fi.plan.delay)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx, ctx.Err()
+		}
+	case faultReset:
+		return ctx, errInjectedReset
+	}
+	return context.WithValue(ctx, faultKey{}, f), nil
+}
+
+// after injects the faults that strike the replies, once the commands ran.
+func (fi *faultInjector) after(ctx context.Context, cmds []redis.Cmder) error {
+	switch ctx.Value(faultKey{}) {
+	case faultDrop:
+		var expired <-chan time.Time
+		if fi.readTimeout > 0 {
+			t := time.NewTimer(fi.readTimeout)
+			defer t.Stop()
+			expired = t.C
+		}
+		select {
+		case <-expired:
+			return injectedTimeout{}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case faultCorrupt:
+		for _, cmd := range cmds {
+			if c, ok := cmd.(*redis.StringCmd); ok && c.Err() == nil && c.Val() != "" {
+				fi.mu.Lock()
+				i, bit := fi.rng.Intn(len(c.Val())), byte(1)<<fi.rng.Intn(8)
+				fi.mu.Unlock()
+				b := []byte(c.Val())
+				b[i] ^= bit
+				c.SetVal(string(b))
+				fi.injected[faultCorrupt].Add(1)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (fi *faultInjector) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return fi.before(ctx)
+}
+
+func (fi *faultInjector) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return fi.after(ctx, []redis.Cmder{cmd})
+}
+
+// BeforeProcessPipeline and AfterProcessPipeline strike a pipeline as a
+// whole: a fault delays, drops or resets all of its commands, or corrupts
+// the first GET reply.
+func (fi *faultInjector) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return fi.before(ctx)
+}
+
+func (fi *faultInjector) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return fi.after(ctx, cmds)
+}
+
+// faultReport is the JSON form of -fault-inject: the faults injected, warmup
+// included.
+type faultReport struct {
+	Spec      string `json:"spec"`
+	Delayed   int64  `json:"delayed"`
+	DelayNs   int64  `json:"delay_ns,omitempty"`
+	Dropped   int64  `json:"dropped"`
+	Reset     int64  `json:"reset"`
+	Corrupted int64  `json:"corrupted"`
+}
+
+func buildFaultReport(cfg *config, fi *faultInjector) *faultReport {
+	if fi == nil {
+		return nil
+	}
+	return &faultReport{
+		Spec:      cfg.faultSpec,
+		Delayed:   fi.injected[faultDelay].Load(),
+		DelayNs:   int64(fi.plan.delay),
+		Dropped:   fi.injected[faultDrop].Load(),
+		Reset:     fi.injected[faultReset].Load(),
+		Corrupted: fi.injected[faultCorrupt].Load(),
+	}
+}
+
+// printFaults writes the -fault-inject summary line.
+func printFaults(w io.Writer, r *faultReport) {
+	fmt.Fprintf(w, "Fault injection (%s): %d delayed by %v, %d replies dropped, %d reset, %d corrupted; the results measure the injector, not the server\n",
+		r.Spec, r.Delayed, time.Duration(r.DelayNs), r.Dropped, r.Reset, r.Corrupted)
+}
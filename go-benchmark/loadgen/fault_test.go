@@ -0,0 +1,100 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFaultFlags(t *testing.T) {
+	cfg := testConfig(t, "-clients", "1", "-fault-inject", "delay=0.1:5ms,drop=0.2,reset=0.3")
+	if p := cfg.faultPlan; p.delay != 5*time.Millisecond || p.prob != [numFaults]float64{0.1, 0.2, 0.3, 0} {
+		t.Errorf("plan %+v", p)
+	}
+	for _, spec := range []string{
+		"delay=0.1", "delay=0.1:0s", "drop=2", "lag=0.1", "drop", "drop=0.1,drop=0.2", "drop=0.6,reset=0.6",
+	} {
+		if _, err := parseFlags([]string{"-clients", "1", "-fault-inject", spec}); err == nil {
+			t.Errorf("-fault-inject %s was accepted", spec)
+		}
+	}
+	for _, args := range [][]string{
+		{"-client", "raw"},
+		{"-churn"},
+		{"-mode", "coordinator", "-agents", "a:1,b:2"},
+	} {
+		if _, err := parseFlags(append(args, "-clients", "1", "-fault-inject", "drop=0.1")); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
+
+// runFaults runs a short benchmark through the injector and returns its
+// report.
+func runFaults(t *testing.T, args ...string) *Report {
+	t.Helper()
+	addr := newTestServerAddr(t)
+	cfg := testConfig(t, append(args, "-addr", addr, "-clients", "2", "-seed", "7")...)
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := buildReport(cfg, res)
+	if rep.Faults == nil {
+		t.Fatal("no fault report")
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "Fault injection ("+cfg.faultSpec+")") {
+		t.Errorf("summary lacks the faults:\n%s", buf.String())
+	}
+	return rep
+}
+
+func TestFaultDrop(t *testing.T) {
+	// A dropped reply times out at -op-timeout, and counts as a timeout.
+	rep := runFaults(t, "-ops", "400", "-op-timeout", "20ms", "-fault-inject", "drop=0.05")
+	f := rep.Faults
+	if f.Dropped == 0 || f.Reset != 0 || f.Delayed != 0 {
+		t.Fatalf("faults %+v", f)
+	}
+	if rep.FailedOps != f.Dropped || rep.ErrorClasses["timeout"] != f.Dropped || rep.Timeouts["SET"] != f.Dropped {
+		t.Errorf("%d dropped, %d failed, %d timeouts by class, %v timeouts by command", f.Dropped, rep.FailedOps,
+			rep.ErrorClasses["timeout"], rep.Timeouts)
+	}
+}
+
+func TestFaultReset(t *testing.T) {
+	rep := runFaults(t, "-ops", "400", "-fault-inject", "reset=0.1")
+	f := rep.Faults
+	if f.Reset == 0 || rep.FailedOps != f.Reset || rep.ErrorClasses["connection reset"] != f.Reset || len(rep.Timeouts) != 0 {
+		t.Errorf("%d reset, %d failed, error classes %v, timeouts %v", f.Reset, rep.FailedOps, rep.ErrorClasses, rep.Timeouts)
+	}
+}
+
+func TestFaultCorrupt(t *testing.T) {
+	// Only the -verify read-backs are GETs, so every corruption is one.
+	rep := runFaults(t, "-ops", "400", "-verify", "-verify-fraction", "1", "-fault-inject", "corrupt=0.2")
+	f := rep.Faults
+	if f.Corrupted == 0 || rep.FailedOps != 0 || rep.Verify == nil || rep.Verify.Corrupt != f.Corrupted || rep.Verify.Stale != 0 {
+		t.Errorf("%d corrupted, %d failed, verify %+v", f.Corrupted, rep.FailedOps, rep.Verify)
+	}
+}
+
+func TestFaultDelay(t *testing.T) {
+	// A delayed command holds back the paced ones behind it: the response
+	// latency, from the intended send times, shows what the service
+	// latency hides.
+	rep := runFaults(t, "-rate", "400", "-duration", "1s", "-fault-inject", "delay=0.05:40ms")
+	f := rep.Faults
+	switch {
+	case f.Delayed == 0 || rep.FailedOps != 0:
+		t.Fatalf("faults %+v, %d failed", f, rep.FailedOps)
+	case rep.Latency.MaxNs < int64(40*time.Millisecond):
+		t.Errorf("latency max %v under the 40ms delay", time.Duration(rep.Latency.MaxNs))
+	case rep.ResponseLatency == nil || rep.ResponseLatency.P90Ns <= rep.Latency.P90Ns:
+		t.Errorf("response latency %+v not above latency %+v", rep.ResponseLatency, rep.Latency)
+	}
+}
@@ -0,0 +1,327 @@
+package loadgen
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// finalBatch is the number of keys read per round trip by -verify-final.
+	finalBatch = 1000
+	// maxFinalDiscrepancies is how many discrepancies -verify-final writes
+	// to -verify-final-out.
+	maxFinalDiscrepancies = 100
+)
+
+// Kinds of -verify-final discrepancies. An extra key is one under the
+// run's prefix that neither the preload nor the workload wrote.
+const (
+	finalCorrupt = "corrupt"
+	finalMissing = "missing"
+	finalExtra   = "extra"
+)
+
+// finalOps are the commands whose effect on the keyspace -verify-final
+// tracks.
+var finalOps = map[opType]bool{opSet: true, opGet: true, opDel: true, opExpire: true, opMGet: true}
+
+// validateVerifyFinal checks the flags of -verify-final.
+func (c *config) validateVerifyFinal() error {
+	if c.usesHashes() || c.usesZSets() || c.usesSets() {
+		return fmt.Errorf("-verify-final checks string values and does not apply to the %s workload", c.workload)
+	}
+	if c.workload != workloadSet {
+		if c.mix == nil {
+			return fmt.Errorf("-verify-final does not apply to the %s workload", c.workload)
+		}
+		for _, op := range c.mix.ops {
+			if !finalOps[op] {
+				return fmt.Errorf("-verify-final cannot track the writes of %s", opNames[op])
+			}
+		}
+	}
+	if c.evictPressure {
+		return errors.New("-verify-final does not apply to -evict-pressure, whose fill keys it does not track")
+	}
+	if c.workload == workloadSet && !c.boundedSets && c.trace == nil && c.duration > 0 {
+		// Every SET would write a fresh key, tracked until the end.
+		return errors.New("-verify-final tracks every key written: bound the keys of a -duration set workload with -keyspace")
+	}
+	if c.verifyFinalOut == "" {
+		return errors.New("-verify-final-out must name a file")
+	}
+	return nil
+}
+
+// finalKey is what one worker knows a key may hold at the end of the run.
+type finalKey struct {
+	// values are the last acknowledged SET of the worker and those sent
+	// after it whose reply was lost, any of which the server may hold.
+	values [][]byte
+	// set is true once the worker SET the key, whose earlier value is then
+	// no longer expected.
+	set bool
+	// gone is true when the key may legitimately be absent: the worker
+	// deleted it, gave it a TTL, or lost the reply of such a command.
+	// deleted is true when a DEL was acknowledged and no SET followed.
+	gone    bool
+	deleted bool
+}
+
+// writeLog records the writes of one worker for -verify-final. It
+// outlives the warmup switch, as warmup writes change the keyspace too. It
+// holds the keys written, which validateVerifyFinal bounds by -keyspace or
+// -ops, with the values each may hold.
+type writeLog struct {
+	keys map[string]*finalKey
+}
+
+func newWriteLog() *writeLog {
+	return &writeLog{keys: make(map[string]*finalKey)}
+}
+
+// record notes the effect of p, which failed with err if not nil. A
+// command the server refused with an error reply did nothing; one whose
+// reply was lost may or may not have been applied.
+func (l *writeLog) record(p pendingOp, err error) {
+	var refused redis.Error
+	if err != nil && errors.As(err, &refused) {
+		return
+	}
+	k := l.keys[p.key]
+	switch p.op {
+	case opSet:
+		if k == nil {
+			k = &finalKey{}
+			l.keys[p.key] = k
+		}
+		if err == nil {
+			k.values, k.gone = k.values[:0], false
+		}
+		k.values = append(k.values, p.written)
+		k.set, k.deleted = true, false
+		k.gone = k.gone || p.ttl > 0
+	case opDel, opExpire:
+		if k == nil {
+			k = &finalKey{}
+			l.keys[p.key] = k
+		}
+		if err == nil && p.op == opDel {
+			k.values, k.deleted = nil, true
+		}
+		k.gone = true
+	}
+}
+
+// merge folds the writes of another worker into l. Workers race on shared
+// keys, so the key may hold the last value written by any of them.
+func (l *writeLog) merge(o *writeLog) {
+	for key, ok := range o.keys {
+		k := l.keys[key]
+		if k == nil {
+			l.keys[key] = &finalKey{values: append([][]byte(nil), ok.values...), set: ok.set, gone: ok.gone, deleted: ok.deleted}
+			continue
+		}
+		k.values = append(k.values, ok.values...)
+		k.set = k.set || ok.set
+		k.gone = k.gone || ok.gone
+		k.deleted = k.deleted || ok.deleted
+	}
+}
+
+// preloadValues regenerates the values preloadKeys wrote: the same
+// per-client generators draw them in the same order.
+func preloadValues(cfg *config) [][]byte {
+	values := make([][]byte, cfg.preload)
+	batch := max(preloadBatch, cfg.pipeline)
+	batches := (cfg.preload + batch - 1) / batch
+	for w := 0; w < cfg.clients && w < batches; w++ {
+		rng := rand.New(rand.NewSource(cfg.seed + int64(w)))
+		for b := w; b < batches; b += cfg.clients {
+			for i := b * batch; i < (b+1)*batch && i < cfg.preload; i++ {
+				values[i] = cfg.keyValue(rng, cfg.keyName(i), preloadWriter, uint64(i), i)
+			}
+		}
+	}
+	return values
+}
+
+// finalDiscrepancy is a key whose value at the end of the run is not one
+// the workload can have left.
+type finalDiscrepancy struct {
+	Key  string
+	Kind string
+	// Detail is how a corrupt structured value is wrong.
+	Detail   string
+	Expected []byte
+	Actual   []byte
+}
+
+// finalReport is the result of -verify-final.
+type finalReport struct {
+	Keys            int    `json:"keys"`
+	Correct         int64  `json:"correct"`
+	Corrupt         int64  `json:"corrupt"`
+	Missing         int64  `json:"missing"`
+	Extra           int64  `json:"extra"`
+	ExpectedMissing int64  `json:"expected_missing,omitempty"`
+	ElapsedNs       int64  `json:"elapsed_ns"`
+	File            string `json:"discrepancy_file,omitempty"`
+	// Error is set when the keyspace could not be read back.
+	Error string `json:"error,omitempty"`
+
+	discrepancies []finalDiscrepancy
+}
+
+func (r *finalReport) failed() bool {
+	return r.Corrupt > 0 || r.Missing > 0 || r.Extra > 0 || r.Error != ""
+}
+
+func (r *finalReport) add(d finalDiscrepancy) {
+	if len(r.discrepancies) < maxFinalDiscrepancies {
+		r.discrepancies = append(r.discrepancies, d)
+	}
+}
+
+// verifyFinal reads back every key the preload and the workload wrote and
+// compares it with the values they can have left, then scans the prefix
+// for keys neither wrote. Keys may only be missing when they were deleted
+// or given a TTL.
+func verifyFinal(ctx context.Context, rdb redis.UniversalClient, cfg *config, log *writeLog, preloaded bool) *finalReport {
+	start := time.Now()
+	rep := &finalReport{}
+	defer func() { rep.ElapsedNs = int64(time.Since(start)) }()
+
+	expected := make(map[string]*finalKey, len(log.keys))
+	for key, k := range log.keys {
+		expected[key] = k
+	}
+	if preloaded {
+		for i, v := range preloadValues(cfg) {
+			key := cfg.keyName(i)
+			switch k := expected[key]; {
+			case k == nil:
+				expected[key] = &finalKey{values: [][]byte{v}}
+			case !k.set && !k.deleted:
+				// Only EXPIREs or unacknowledged DELs touched the key.
+				k.values = append(k.values, v)
+			}
+		}
+	}
+	rep.Keys = len(expected)
+
+	keys := make([]string, 0, len(expected))
+	for key := range expected {
+		keys = append(keys, key)
+	}
+	for from := 0; from < len(keys); from += finalBatch {
+		chunk := keys[from:min(from+finalBatch, len(keys))]
+		pipe := rdb.Pipeline()
+		cmds := make([]*redis.StringCmd, len(chunk))
+		for i, key := range chunk {
+			cmds[i] = pipe.Get(ctx, key)
+		}
+		_, _ = pipe.Exec(ctx)
+		for i, cmd := range cmds {
+			got, err := cmd.Bytes()
+			if err != nil && !isMiss(err) {
+				rep.Error = fmt.Sprintf("read %s: %v", chunk[i], err)
+				return rep
+			}
+			rep.check(chunk[i], expected[chunk[i]], got, isMiss(err))
+		}
+	}
+
+	var mu sync.Mutex
+	err := forEachNode(ctx, rdb, func(ctx context.Context, c *redis.Client) error {
+		iter := c.Scan(ctx, 0, cfg.keyPattern(), cleanupScanCount).Iterator()
+		for iter.Next(ctx) {
+			if _, ok := expected[iter.Val()]; ok {
+				continue
+			}
+			mu.Lock()
+			rep.Extra++
+			rep.add(finalDiscrepancy{Key: iter.Val(), Kind: finalExtra})
+			mu.Unlock()
+		}
+		return iter.Err()
+	})
+	if err != nil {
+		rep.Error = fmt.Sprintf("scan: %v", err)
+	}
+	return rep
+}
+
+// check classifies the value read back for key.
+func (r *finalReport) check(key string, k *finalKey, got []byte, miss bool) {
+	var want []byte
+	if len(k.values) > 0 {
+		want = k.values[len(k.values)-1]
+	}
+	switch {
+	case miss && (k.gone || len(k.values) == 0):
+		r.ExpectedMissing++
+		return
+	case miss:
+		r.Missing++
+		r.add(finalDiscrepancy{Key: key, Kind: finalMissing, Expected: want})
+		return
+	}
+	for _, v := range k.values {
+		if bytes.Equal(got, v) {
+			r.Correct++
+			return
+		}
+	}
+	r.Corrupt++
+	r.add(finalDiscrepancy{Key: key, Kind: finalCorrupt, Detail: classifyValue(got, want, key), Expected: want, Actual: got})
+}
+
+// writeDiscrepancies writes the discrepancies found to path, one per line.
+func writeDiscrepancies(path string, rep *finalReport) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	for _, d := range rep.discrepancies {
+		kind := d.Kind
+		if d.Detail != valueIntact && d.Detail != valueUnstructured {
+			kind += " (" + d.Detail + ")"
+		}
+		fmt.Fprintf(bw, "%s\t%s\texpected=%q\tactual=%q\n", kind, d.Key, valuePrefix(d.Expected), valuePrefix(d.Actual))
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// printFinalReport writes the -verify-final section of the summary.
+func printFinalReport(w io.Writer, rep *finalReport) {
+	fmt.Fprintf(w, "Final keyspace check (%d keys in %v): %d correct, %d corrupt, %d missing, %d extra",
+		rep.Keys, time.Duration(rep.ElapsedNs).Round(time.Millisecond), rep.Correct, rep.Corrupt, rep.Missing, rep.Extra)
+	if rep.ExpectedMissing > 0 {
+		fmt.Fprintf(w, ", %d deleted or expired", rep.ExpectedMissing)
+	}
+	fmt.Fprintln(w)
+	switch {
+	case rep.Error != "":
+		fmt.Fprintf(w, "  FAILED: %s\n", rep.Error)
+	case rep.failed() && rep.File != "":
+		fmt.Fprintf(w, "  FAILED: first %d discrepancies written to %s\n", len(rep.discrepancies), rep.File)
+	case rep.failed():
+		fmt.Fprintln(w, "  FAILED")
+	}
+}
@@ -0,0 +1,126 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestVerifyFinalClean(t *testing.T) {
+	mr := miniredis.RunT(t)
+	out := filepath.Join(t.TempDir(), "final.txt")
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "4", "-ops", "300", "-warmup", "50ms",
+		"-ratio", "get=2,set=3,del=1,expire=1", "-preload", "500", "-value-size", "32", "-key-prefix", "vf:",
+		"-verify-final", "-verify-final-out", out)
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep := res.final
+	if rep == nil || rep.Keys != 500 || rep.failed() || res.verifyFailed() {
+		t.Fatalf("final check %+v, want all 500 keys accounted for", rep)
+	}
+	if rep.Correct == 0 || rep.ExpectedMissing == 0 || rep.Correct+rep.ExpectedMissing != 500 {
+		t.Errorf("%d correct and %d deleted or expired of 500", rep.Correct, rep.ExpectedMissing)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("discrepancy file written for a clean run: %v", err)
+	}
+}
+
+func TestVerifyFinalPreload(t *testing.T) {
+	_, rdb := newTestServer(t)
+	cfg := testConfig(t, "-workload", "get", "-clients", "3", "-preload", "1001", "-value-size-range", "10:100")
+	preloadKeys(context.Background(), rdb, cfg)
+
+	rep := verifyFinal(context.Background(), rdb, cfg, newWriteLog(), true)
+	if rep.Keys != 1001 || rep.Correct != 1001 || rep.failed() {
+		t.Errorf("final check %+v, want every regenerated preload value to match", rep)
+	}
+}
+
+func TestVerifyFinalDiscrepancies(t *testing.T) {
+	mr, rdb := newTestServer(t)
+	cfg := testConfig(t, "-clients", "2", "-ops", "50", "-key-prefix", "vf:", "-verify-final")
+	res := runBenchmark(context.Background(), rdb, cfg)
+	keys := mr.Keys()
+	if len(res.total.writes.keys) != 100 || len(keys) != 100 {
+		t.Fatalf("%d keys recorded, %d on the server, want 100", len(res.total.writes.keys), len(keys))
+	}
+
+	mr.Set(keys[0], "truncated")
+	mr.Del(keys[1])
+	mr.Set("vf:stray", "1")
+	mr.Set("other", "1")
+	rep := verifyFinal(context.Background(), rdb, cfg, res.total.writes, false)
+	if rep.Correct != 98 || rep.Corrupt != 1 || rep.Missing != 1 || rep.Extra != 1 || !rep.failed() {
+		t.Fatalf("final check %+v", rep)
+	}
+
+	out := filepath.Join(t.TempDir(), "final.txt")
+	if err := writeDiscrepancies(out, rep); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"corrupt\t" + keys[0] + "\texpected=\"value", `actual="truncated"`, "missing\t" + keys[1], "extra\tvf:stray"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("discrepancy file lacks %q:\n%s", want, data)
+		}
+	}
+}
+
+// replyError is an error reply of the server, which go-redis reports as a
+// redis.Error.
+type replyError string
+
+func (e replyError) Error() string { return string(e) }
+func (replyError) RedisError()     {}
+
+func TestWriteLogLostReplies(t *testing.T) {
+	l := newWriteLog()
+	set := func(value string, err error) {
+		l.record(pendingOp{op: opSet, key: "k", written: []byte(value)}, err)
+	}
+	set("v1", nil)
+	set("v2", errors.New("i/o timeout"))
+	set("v3", replyError("ERR refused"))
+	k := l.keys["k"]
+	if len(k.values) != 2 || string(k.values[0]) != "v1" || string(k.values[1]) != "v2" || k.gone {
+		t.Errorf("after a lost reply the key may hold %q, gone %v", k.values, k.gone)
+	}
+
+	l.record(pendingOp{op: opDel, key: "k"}, nil)
+	o := newWriteLog()
+	o.record(pendingOp{op: opSet, key: "k", written: []byte("v4")}, nil)
+	l.merge(o)
+	if k := l.keys["k"]; len(k.values) != 1 || string(k.values[0]) != "v4" || !k.gone {
+		t.Errorf("merged key may hold %q, gone %v", k.values, k.gone)
+	}
+}
+
+func TestVerifyFinalFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-verify-final", "-workload", "incr"},
+		{"-verify-final", "-workload", "hash", "-preload", "10"},
+		{"-verify-final", "-ratio", "get=1,mset=1", "-preload", "10"},
+		{"-verify-final", "-evict-pressure"},
+		{"-verify-final", "-verify-final-out", ""},
+		{"-verify-final", "-duration", "10s"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	testConfig(t, "-verify-final", "-ratio", "get=1,set=1,mget=1", "-preload", "10")
+	testConfig(t, "-verify-final", "-duration", "10s", "-keyspace", "100")
+}
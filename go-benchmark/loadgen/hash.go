@@ -0,0 +1,79 @@
+package loadgen
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// defaultHashRatio is the command mix of -workload hash without -ratio.
+const defaultHashRatio = "hget=0.8,hset=0.1,hgetall=0.1"
+
+// hashOps are the commands that treat keys of the keyspace as hashes of
+// -hash-fields fields.
+var hashOps = map[opType]bool{
+	opHSet:    true,
+	opHGet:    true,
+	opHGetAll: true,
+}
+
+// hashField returns the name of field i of every hash.
+func hashField(i int) string {
+	return "f" + strconv.Itoa(i)
+}
+
+// usesHashes reports whether the keyspace holds hashes instead of strings.
+func (c *config) usesHashes() bool {
+	if c.mix == nil {
+		return false
+	}
+	for _, op := range c.mix.ops {
+		if hashOps[op] {
+			return true
+		}
+	}
+	return false
+}
+
+// hashValues returns the field/value pairs that fill a whole hash.
+func (c *config) hashValues(rng *rand.Rand, i int) []interface{} {
+	args := make([]interface{}, 0, 2*c.hashFields)
+	for f := 0; f < c.hashFields; f++ {
+		args = append(args, hashField(f), c.nextValue(rng, i))
+	}
+	return args
+}
+
+// issueHash sends one HSET, HGET or HGETALL to key.
+func (w *worker) issueHash(ctx context.Context, c redis.Cmdable, op opType, key string, hot bool) pendingOp {
+	cfg := w.run.cfg
+	field := hashField(w.rng.Intn(cfg.hashFields))
+	switch op {
+	case opHSet:
+		value := cfg.nextValue(w.rng, w.seq)
+		return pendingOp{op: op, cmd: c.HSet(ctx, key, field, value), bytes: len(value), key: key, hot: hot}
+	case opHGet:
+		return pendingOp{op: op, cmd: c.HGet(ctx, key, field), key: key, hot: hot}
+	default:
+		return pendingOp{op: op, cmd: c.HGetAll(ctx, key), key: key, hot: hot}
+	}
+}
+
+// checkHashSize records an HGETALL reply of n fields, which must be the
+// full hash whenever -hash-fields can be relied on.
+func (w *worker) checkHashSize(n int) {
+	if w.run.cfg.checkFullKeys && n != w.run.cfg.hashFields {
+		w.result.hashSizeMismatches++
+	}
+}
+
+// hashReplySize returns the number of fields and value bytes of an HGETALL
+// reply.
+func hashReplySize(m map[string]string) (fields, bytes int) {
+	for _, v := range m {
+		bytes += len(v)
+	}
+	return len(m), bytes
+}
@@ -0,0 +1,53 @@
+package loadgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestHashWorkload(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-workload", "hash", "-preload", "20", "-hash-fields", "4",
+		"-value-size", "8", "-clients", "4", "-ops", "100", "-key-prefix", "h:")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fields, _ := mr.HKeys("h:key19"); len(fields) != 4 {
+		t.Errorf("preloaded hash has %d fields, want 4", len(fields))
+	}
+	for _, op := range []opType{opHSet, opHGet, opHGetAll} {
+		if s := &res.total.ops[op]; s.latency == nil || s.errors > 0 {
+			t.Errorf("%s: %+v, want successful operations", op, s)
+		}
+	}
+	if n := res.total.ops[opHGet].misses; n > 0 {
+		t.Errorf("%d HGET misses on a preloaded keyspace", n)
+	}
+	if !cfg.checkFullKeys || res.total.hashSizeMismatches != 0 {
+		t.Errorf("checked %v, %d HGETALL size mismatches, want none", cfg.checkFullKeys, res.total.hashSizeMismatches)
+	}
+}
+
+func TestHashWorkloadDetectsTruncatedReplies(t *testing.T) {
+	mr, rdb := newTestServer(t)
+	cfg := testConfig(t, "-workload", "hash", "-ratio", "hgetall=1", "-preload", "1", "-hash-fields", "3",
+		"-clients", "1", "-ops", "5", "-key-prefix", "h:")
+	mr.HSet("h:key0", "f0", "v", "f1", "v")
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	if n := res.total.hashSizeMismatches; n != 5 {
+		t.Errorf("%d size mismatches, want all 5 HGETALLs of a 2-field hash", n)
+	}
+}
+
+func TestHashWorkloadRejectsStringCommands(t *testing.T) {
+	if _, err := parseFlags([]string{"-ratio", "hget=1,get=1"}); err == nil {
+		t.Error("mixing GET and HGET over one keyspace was accepted")
+	}
+}
@@ -0,0 +1,214 @@
+package loadgen
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+// hdrMaxRatio is the unit of the Interval_Max column of -hdr-out: values
+// are nanoseconds, the maximum is printed in milliseconds as HdrHistogram
+// tools expect.
+const hdrMaxRatio = float64(time.Millisecond)
+
+// hdrWriter writes the -hdr-out log: one histogram per sampleInterval of
+// the measured window, in the HdrHistogram log format, then the cumulative
+// histogram of the run. Under a mix of commands every command also gets a
+// stream of its own, tagged with its name.
+type hdrWriter struct {
+	path   string
+	perOp  bool
+	layout stats.Layout
+	// recorders are the interval histograms of the workers, by client ID.
+	recorders []*hdrRecorder
+
+	file *os.File
+	w    *bufio.Writer
+	// base is the start of the measured window, which the timestamps of
+	// the log count from, and last the start of the interval in progress.
+	base, last time.Time
+	intervals  int64
+
+	stopCh chan struct{}
+	done   sync.WaitGroup
+}
+
+// hdrRecorder holds the histograms of a worker for the interval in
+// progress. The worker records without a lock: at the end of every
+// interval the writer swaps in fresh histograms, then waits for a record
+// that may still hold the old ones, counted by started and finished, which
+// only the worker moves.
+type hdrRecorder struct {
+	perOp             bool
+	layout            stats.Layout
+	active            atomic.Pointer[hdrInterval]
+	started, finished atomic.Int64
+}
+
+// hdrInterval is what a worker recorded over an interval.
+type hdrInterval struct {
+	all *stats.Histogram
+	ops [numOpTypes]*stats.Histogram
+}
+
+// newHdrWriter creates path for the histograms of workers clients, of
+// layout l.
+func newHdrWriter(path string, workers int, perOp bool, l stats.Layout) (*hdrWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create histogram log: %w", err)
+	}
+	hw := &hdrWriter{
+		path:      path,
+		perOp:     perOp,
+		layout:    l,
+		recorders: make([]*hdrRecorder, workers),
+		file:      f,
+		w:         bufio.NewWriter(f),
+		stopCh:    make(chan struct{}),
+	}
+	for i := range hw.recorders {
+		hw.recorders[i] = &hdrRecorder{perOp: perOp, layout: l}
+		hw.recorders[i].active.Store(&hdrInterval{all: l.New()})
+	}
+	return hw, nil
+}
+
+// recorder returns the interval histograms of worker.
+func (hw *hdrWriter) recorder(worker int) *hdrRecorder {
+	return hw.recorders[worker]
+}
+
+// record adds a measured operation to the interval in progress.
+func (r *hdrRecorder) record(op opType, d time.Duration) {
+	r.started.Add(1)
+	h := r.active.Load()
+	h.all.Record(d)
+	if r.perOp {
+		if h.ops[op] == nil {
+			h.ops[op] = r.layout.New()
+		}
+		h.ops[op].Record(d)
+	}
+	r.finished.Add(1)
+}
+
+// swap returns the histograms of the interval in progress and starts the
+// next one. A record that loaded the old histograms started before the
+// swap, so once finished catches up with started no record holds them.
+func (r *hdrRecorder) swap() *hdrInterval {
+	old := r.active.Swap(&hdrInterval{all: r.layout.New()})
+	for n := r.started.Load(); r.finished.Load() < n; {
+		runtime.Gosched()
+	}
+	return old
+}
+
+// start writes the header of the log and writes an interval every
+// sampleInterval from start, the beginning of the measured window, on.
+func (hw *hdrWriter) start(start time.Time) {
+	hw.base, hw.last = start, start
+	secs := float64(start.UnixNano()) / float64(time.Second)
+	fmt.Fprintf(hw.w, "#[Histogram log format version 1.3]\n")
+	fmt.Fprintf(hw.w, "#[StartTime: %.3f (seconds since epoch), %s]\n", secs, start.Format(time.RFC1123))
+	fmt.Fprintf(hw.w, "#[BaseTime: %.3f (seconds since epoch)]\n", secs)
+	fmt.Fprintln(hw.w, `"StartTimestamp","Interval_Length","Interval_Max","Interval_Compressed_Histogram"`)
+	hw.done.Add(1)
+	go func() {
+		defer hw.done.Done()
+		t := time.NewTicker(sampleInterval)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				hw.interval(now)
+			case <-hw.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// interval writes the histograms of the interval ending at end.
+func (hw *hdrWriter) interval(end time.Time) {
+	all := hw.layout.New()
+	var ops [numOpTypes]*stats.Histogram
+	for _, r := range hw.recorders {
+		rec := r.swap()
+		all.Merge(rec.all)
+		for op, h := range rec.ops {
+			if h == nil {
+				continue
+			}
+			if ops[op] == nil {
+				ops[op] = hw.layout.New()
+			}
+			ops[op].Merge(h)
+		}
+	}
+	hw.line("", hw.last, end, all)
+	for op, h := range ops {
+		if h != nil {
+			hw.line(opType(op).String(), hw.last, end, h)
+		}
+	}
+	hw.last = end
+	hw.intervals++
+}
+
+// line writes h, recorded in [from, to), tagged with tag unless empty.
+func (hw *hdrWriter) line(tag string, from, to time.Time, h *stats.Histogram) {
+	if tag != "" {
+		fmt.Fprintf(hw.w, "Tag=%s,", tag)
+	}
+	fmt.Fprintf(hw.w, "%.3f,%.3f,%.3f,%s\n", from.Sub(hw.base).Seconds(), to.Sub(from).Seconds(),
+		float64(h.Maximum())/hdrMaxRatio, base64.StdEncoding.EncodeToString(stats.EncodeHdr(h)))
+}
+
+// close writes the last partial interval and the cumulative histograms of
+// res, tagged "cumulative" and "cumulative.<command>", and closes the
+// file. It runs once every worker has returned.
+func (hw *hdrWriter) close(res *runResult) error {
+	if hw.base.IsZero() {
+		// The run ended before its measured window began.
+		hw.start(res.start)
+	}
+	close(hw.stopCh)
+	hw.done.Wait()
+	if res.end.After(hw.last) {
+		hw.interval(res.end)
+	}
+	hw.line("cumulative", hw.base, res.end, res.total.latency)
+	if hw.perOp {
+		for op := opType(0); op < numOpTypes; op++ {
+			if h := res.total.ops[op].latency; h != nil && h.Count() > 0 {
+				hw.line("cumulative."+op.String(), hw.base, res.end, h)
+			}
+		}
+	}
+	err := hw.w.Flush()
+	if cerr := hw.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write histogram log: %w", err)
+	}
+	return nil
+}
+
+// printHdrLog writes the -hdr-out summary line.
+func printHdrLog(w io.Writer, hw *hdrWriter) {
+	streams := "one stream"
+	if hw.perOp {
+		streams = "a stream per command"
+	}
+	fmt.Fprintf(w, "HDR histograms: %d intervals and the cumulative histogram, %s, written to %s\n", hw.intervals, streams, hw.path)
+}
@@ -0,0 +1,106 @@
+package loadgen
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+func TestHdrLog(t *testing.T) {
+	_, rdb := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "run.hlog")
+	cfg := testConfig(t, "-clients", "2", "-workload", "mixed", "-ratio", "get=0.5,set=0.5", "-duration", "1500ms", "-rate", "400", "-hdr-out", path)
+	hw, err := newHdrWriter(path, cfg.clients, cfg.mixedCommands(), cfg.layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.hdr = hw
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+	if err := hw.close(res); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if !strings.HasPrefix(lines[0], "#[Histogram log format version 1.3]") || !strings.HasPrefix(lines[2], "#[BaseTime: ") {
+		t.Fatalf("header:\n%s", strings.Join(lines[:4], "\n"))
+	}
+	var untagged int
+	var length float64
+	tags := map[string]bool{}
+	for _, l := range lines[4:] {
+		fields := strings.Split(l, ",")
+		if tag, ok := strings.CutPrefix(fields[0], "Tag="); ok {
+			tags[tag] = true
+			fields = fields[1:]
+		} else {
+			untagged++
+			d, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				t.Fatalf("line %q: %v", l, err)
+			}
+			length += d
+		}
+		if len(fields) != 4 {
+			t.Fatalf("line %q has %d fields", l, len(fields))
+		}
+		if h, err := base64.StdEncoding.DecodeString(fields[3]); err != nil || !strings.HasPrefix(string(h), "\x1c\x84\x93\x14") {
+			t.Errorf("histogram of %q: %v", l, err)
+		}
+	}
+	if untagged != 2 || untagged != int(hw.intervals) {
+		t.Errorf("%d intervals, writer counted %d, want one per second and the last partial one", untagged, hw.intervals)
+	}
+	if want := res.elapsed().Seconds(); length < want-0.01 || length > want+0.01 {
+		t.Errorf("intervals cover %.3fs of a %.3fs run", length, want)
+	}
+	for _, tag := range []string{"GET", "SET", "cumulative", "cumulative.GET", "cumulative.SET"} {
+		if !tags[tag] {
+			t.Errorf("no line tagged %s among %v", tag, tags)
+		}
+	}
+}
+
+func TestHdrRecorderSwap(t *testing.T) {
+	hw, err := newHdrWriter(filepath.Join(t.TempDir(), "run.hlog"), 1, true, stats.DefaultLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hw.file.Close()
+	r := hw.recorder(0)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100000; i++ {
+			r.record(opGet, time.Duration(i))
+		}
+	}()
+	// Swapping while the worker records loses no operation.
+	var all, get int64
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		rec := r.swap()
+		all += rec.all.Count()
+		if h := rec.ops[opGet]; h != nil {
+			get += h.Count()
+		}
+	}
+	if all != 100000 || get != 100000 {
+		t.Errorf("swapped out %d operations, %d GETs, want 100000", all, get)
+	}
+}
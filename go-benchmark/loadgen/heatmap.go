@@ -0,0 +1,215 @@
+package loadgen
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+// heatmapCollector builds the latency heatmap of a run: every worker counts
+// its latencies into a row of its own, and every -heatmap-interval the
+// collector swaps the rows out and appends their sum to the grid, so the
+// workers never share a row.
+type heatmapCollector struct {
+	// recorders are the rows of the workers, by client ID.
+	recorders []*heatmapRecorder
+	grid      stats.Heatmap
+	// base is the start of the measured window.
+	base time.Time
+
+	stopCh chan struct{}
+	done   sync.WaitGroup
+}
+
+// heatmapRecorder is the row of a worker for the interval in progress.
+type heatmapRecorder struct {
+	mu  sync.Mutex
+	row stats.HeatmapRow
+}
+
+// newHeatmapCollector returns a collector of the latencies of workers
+// clients by interval.
+func newHeatmapCollector(interval time.Duration, workers int) *heatmapCollector {
+	hc := &heatmapCollector{
+		recorders: make([]*heatmapRecorder, workers),
+		grid:      stats.Heatmap{Interval: interval},
+		stopCh:    make(chan struct{}),
+	}
+	for i := range hc.recorders {
+		hc.recorders[i] = &heatmapRecorder{}
+	}
+	return hc
+}
+
+// record counts a measured operation in the interval in progress.
+func (r *heatmapRecorder) record(d time.Duration) {
+	r.mu.Lock()
+	r.row.Record(d)
+	r.mu.Unlock()
+}
+
+// swapInto adds the interval in progress to row and starts the next one.
+func (r *heatmapRecorder) swapInto(row *stats.HeatmapRow) {
+	r.mu.Lock()
+	row.Add(&r.row)
+	r.row = stats.HeatmapRow{}
+	r.mu.Unlock()
+}
+
+// start appends a row every interval from start, the beginning of the
+// measured window, on.
+func (hc *heatmapCollector) start(start time.Time) {
+	hc.base = start
+	hc.done.Add(1)
+	go func() {
+		defer hc.done.Done()
+		t := time.NewTicker(hc.grid.Interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				hc.rotate()
+			case <-hc.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// rotate appends the sum of the rows of the workers to the grid.
+func (hc *heatmapCollector) rotate() {
+	var row stats.HeatmapRow
+	for _, r := range hc.recorders {
+		r.swapInto(&row)
+	}
+	hc.grid.Rows = append(hc.grid.Rows, row)
+}
+
+// stop appends the last partial interval, up to end, and returns the grid.
+// It runs once every worker has returned.
+func (hc *heatmapCollector) stop(start, end time.Time) *stats.Heatmap {
+	if hc.base.IsZero() {
+		// The run ended before its measured window began.
+		hc.start(start)
+	}
+	close(hc.stopCh)
+	hc.done.Wait()
+	hc.grid.Length = end.Sub(hc.base)
+	if hc.grid.Length > time.Duration(len(hc.grid.Rows))*hc.grid.Interval {
+		hc.rotate()
+	}
+	return &hc.grid
+}
+
+// keepsHeatmap reports whether the run collects a latency heatmap, for
+// -heatmap-out, -heatmap-json or the -report page.
+func (c *config) keepsHeatmap() bool {
+	return c.heatmapOut != "" || c.heatmapJSON || c.htmlReport != ""
+}
+
+// validateHeatmap checks the -heatmap flags.
+func (c *config) validateHeatmap() error {
+	if c.heatmapInterval <= 0 {
+		return errors.New("-heatmap-interval must be positive")
+	}
+	if c.heatmapOut != "" && (c.addr2 != "" || c.sweeping() || c.scenario != "") {
+		return errors.New("-heatmap-out cannot be combined with -addr2, sweeps or -scenario")
+	}
+	return nil
+}
+
+// writeHeatmapCSV writes the grid of -heatmap-out to path.
+func writeHeatmapCSV(path string, h *stats.Heatmap) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("create heatmap: %w", err)
+	}
+	err = h.WriteCSV(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write heatmap: %w", err)
+	}
+	return nil
+}
+
+// jsonHeatmap is the latency heatmap of the JSON report: the grid itself
+// under -heatmap-json, the file it was written to under -heatmap-out.
+type jsonHeatmap struct {
+	Path            string  `json:"path,omitempty"`
+	IntervalSeconds float64 `json:"interval_seconds"`
+	Intervals       int     `json:"intervals"`
+	// BoundsNs are the lower bounds of the latency buckets, and Rows the
+	// counts of every interval by latency bucket.
+	BoundsNs []int64   `json:"bounds_ns,omitempty"`
+	Rows     [][]int64 `json:"rows,omitempty"`
+}
+
+// heatmapReport returns the heatmap of the JSON report of res, nil when
+// neither -heatmap-out nor -heatmap-json asked for one.
+func heatmapReport(cfg *config, res *runResult) *jsonHeatmap {
+	h := res.heatmap
+	if h == nil || (cfg.heatmapOut == "" && !cfg.heatmapJSON) {
+		return nil
+	}
+	rep := &jsonHeatmap{Path: res.heatmapPath, IntervalSeconds: h.Interval.Seconds(), Intervals: len(h.Rows)}
+	if cfg.heatmapJSON {
+		rep.BoundsNs = make([]int64, stats.HeatmapBuckets)
+		for i := range rep.BoundsNs {
+			rep.BoundsNs[i] = int64(stats.HeatmapBound(i))
+		}
+		rep.Rows = make([][]int64, len(h.Rows))
+		for i := range h.Rows {
+			rep.Rows[i] = h.Rows[i][:]
+		}
+	}
+	return rep
+}
+
+// htmlHeatmap is the heatmap of the -report page, trimmed to the latency
+// buckets that counted an operation.
+type htmlHeatmap struct {
+	IntervalSeconds float64   `json:"interval_seconds"`
+	EndsSeconds     []float64 `json:"ends_seconds"`
+	BoundsNs        []int64   `json:"bounds_ns"`
+	Rows            [][]int64 `json:"rows"`
+}
+
+func heatmapPage(h *stats.Heatmap) *htmlHeatmap {
+	if h == nil || len(h.Rows) == 0 {
+		return nil
+	}
+	lo, hi := stats.HeatmapBuckets, -1
+	for _, row := range h.Rows {
+		for i, c := range row {
+			if c > 0 {
+				lo, hi = min(lo, i), max(hi, i)
+			}
+		}
+	}
+	if hi < 0 {
+		return nil
+	}
+	page := &htmlHeatmap{IntervalSeconds: h.Interval.Seconds()}
+	for i := lo; i <= hi+1; i++ {
+		page.BoundsNs = append(page.BoundsNs, int64(stats.HeatmapBound(i)))
+	}
+	for n := range h.Rows {
+		page.EndsSeconds = append(page.EndsSeconds, h.End(n).Seconds())
+		page.Rows = append(page.Rows, h.Rows[n][lo:hi+1])
+	}
+	return page
+}
+
+// printHeatmap writes the -heatmap-out summary line.
+func printHeatmap(w io.Writer, res *runResult) {
+	h := res.heatmap
+	fmt.Fprintf(w, "Heatmap: %d intervals of %v by %d latency buckets, written to %s\n",
+		len(h.Rows), h.Interval, stats.HeatmapBuckets, res.heatmapPath)
+}
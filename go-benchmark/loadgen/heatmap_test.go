@@ -0,0 +1,110 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go-benchmark/stats"
+)
+
+func TestHeatmapFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-heatmap-interval", "0"},
+		{"-heatmap-out", "h.csv", "-addr2", "localhost:6380"},
+		{"-heatmap-out", "h.csv", "-sweep-clients", "1,2"},
+		{"-heatmap-json", "-mode", "coordinator", "-agents", "a:1,b:2"},
+		{"-heatmap-out", "h.csv", "-search", "p99<5ms"},
+	} {
+		if _, err := parseFlags(append(args, "-clients", "1")); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
+
+func TestHeatmap(t *testing.T) {
+	_, rdb := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "heatmap.csv")
+	cfg := testConfig(t, "-clients", "2", "-duration", "1500ms", "-rate", "400", "-heatmap-interval", "500ms",
+		"-heatmap-out", path, "-heatmap-json")
+	res := runBenchmark(context.Background(), rdb, cfg)
+	h := res.heatmap
+	if h == nil {
+		t.Fatal("no heatmap")
+	}
+	// Three full intervals, and a last sliver when the run overshot the
+	// last tick.
+	if n := len(h.Rows); n < 3 || n > 4 {
+		t.Errorf("%d intervals of a %v run", n, res.elapsed())
+	}
+	var sum stats.HeatmapRow
+	for i := range h.Rows {
+		sum.Add(&h.Rows[i])
+	}
+	var total int64
+	for _, c := range sum {
+		total += c
+	}
+	if total != res.total.latency.Count() {
+		t.Errorf("heatmap counts %d operations, the run %d", total, res.total.latency.Count())
+	}
+
+	if err := writeHeatmapCSV(path, h); err != nil {
+		t.Fatal(err)
+	}
+	res.heatmapPath = path
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(b), "\n"); lines != len(h.Rows)+1 {
+		t.Errorf("CSV of %d lines for %d intervals", lines, len(h.Rows))
+	}
+
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, buildReport(cfg, res)); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Heatmap jsonHeatmap `json:"heatmap"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if j := doc.Heatmap; j.Path != path || j.IntervalSeconds != 0.5 || len(j.Rows) != len(h.Rows) ||
+		len(j.BoundsNs) != stats.HeatmapBuckets || len(j.Rows[0]) != stats.HeatmapBuckets {
+		t.Errorf("JSON heatmap at %s, %vs intervals, %d rows, %d bounds", j.Path, j.IntervalSeconds, len(j.Rows), len(j.BoundsNs))
+	}
+	buf.Reset()
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "Heatmap: ") || !strings.Contains(buf.String(), path) {
+		t.Errorf("summary lacks the heatmap:\n%s", buf.String())
+	}
+
+	// The page trims the grid to the latency buckets in use.
+	page := heatmapPage(h)
+	if page == nil || len(page.Rows) != len(h.Rows) || len(page.BoundsNs) != len(page.Rows[0])+1 {
+		t.Fatalf("page heatmap %+v", page)
+	}
+	if len(page.Rows[0]) >= stats.HeatmapBuckets {
+		t.Errorf("page keeps all %d latency buckets", len(page.Rows[0]))
+	}
+}
+
+func TestHeatmapWithoutJSON(t *testing.T) {
+	_, rdb := newTestServer(t)
+	cfg := testConfig(t, "-clients", "1", "-ops", "50")
+	if res := runBenchmark(context.Background(), rdb, cfg); res.heatmap != nil || buildReport(cfg, res).Heatmap != nil {
+		t.Error("a heatmap was kept unasked")
+	}
+	// -report keeps the grid for the page alone.
+	cfg = testConfig(t, "-clients", "1", "-ops", "50", "-report", filepath.Join(t.TempDir(), "r.html"))
+	res := runBenchmark(context.Background(), rdb, cfg)
+	if res.heatmap == nil || buildReport(cfg, res).Heatmap != nil {
+		t.Errorf("-report heatmap %v, in the JSON report %v", res.heatmap, buildReport(cfg, res).Heatmap)
+	}
+}
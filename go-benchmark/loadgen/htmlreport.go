@@ -0,0 +1,102 @@
+package loadgen
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+// htmlPage is the page of -report. It draws its charts with inline script
+// and SVG, so the file works offline; the data replaces htmlDataMarker.
+//
+//go:embed htmlreport.html
+var htmlPage string
+
+const htmlDataMarker = "/*DATA*/null"
+
+// cdfPercentiles are the points of the latency CDF: every percent, then
+// finer steps through the tail.
+var cdfPercentiles = func() []float64 {
+	var ps []float64
+	for p := 1.0; p < 99; p++ {
+		ps = append(ps, p)
+	}
+	for i := 990; i < 999; i++ {
+		ps = append(ps, float64(i)/10)
+	}
+	for i := 9990; i < 9999; i++ {
+		ps = append(ps, float64(i)/100)
+	}
+	return append(ps, 99.99, 99.999, 100)
+}()
+
+// cdfPoint is one point of the latency CDF: P percent of the operations
+// completed within LatencyNs.
+type cdfPoint struct {
+	P         float64 `json:"p"`
+	LatencyNs int64   `json:"latency_ns"`
+}
+
+// htmlRun is one run of the page: its JSON report, latency CDF and
+// latency heatmap.
+type htmlRun struct {
+	Label   string       `json:"label"`
+	Report  *Report      `json:"report"`
+	CDF     []cdfPoint   `json:"cdf"`
+	Heatmap *htmlHeatmap `json:"heatmap,omitempty"`
+}
+
+// htmlData is embedded in the page.
+type htmlData struct {
+	Generated string    `json:"generated"`
+	Runs      []htmlRun `json:"runs"`
+}
+
+func latencyCDF(h *stats.Histogram) []cdfPoint {
+	if h == nil || h.Count() == 0 {
+		return nil
+	}
+	cdf := make([]cdfPoint, len(cdfPercentiles))
+	for i, p := range cdfPercentiles {
+		cdf[i] = cdfPoint{P: p, LatencyNs: int64(h.Percentile(p))}
+	}
+	return cdf
+}
+
+// validateHTMLReport checks -report.
+func (c *config) validateHTMLReport() error {
+	if c.htmlReport != "" && (c.sweeping() || c.scenario != "") {
+		return errors.New("-report draws single runs and -addr2 comparisons, not sweeps or -scenario")
+	}
+	return nil
+}
+
+// writeHTMLReport writes the -report page of the runs, overlaid on the same
+// charts when there are two.
+func writeHTMLReport(path string, cfgs []*config, results []*runResult) error {
+	data := htmlData{Generated: time.Now().Format(time.RFC1123)}
+	for i, res := range results {
+		label := cfgs[i].addr
+		if len(cfgs) == 2 {
+			label = targetLabel(cfgs[i], cfgs[1-i])
+		}
+		data.Runs = append(data.Runs, htmlRun{Label: label, Report: buildReport(cfgs[i], res), CDF: latencyCDF(res.total.latency),
+			Heatmap: heatmapPage(res.heatmap)})
+	}
+	// json.Marshal escapes <, > and &, so the data cannot close the script
+	// element it is embedded in.
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(strings.Replace(htmlPage, htmlDataMarker, string(b), 1)), 0o644); err != nil {
+		return fmt.Errorf("write HTML report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package loadgen
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// htmlReportData extracts the data embedded in a -report page.
+func htmlReportData(t *testing.T, page string) htmlData {
+	t.Helper()
+	const open = `<script id="data" type="application/json">`
+	i := strings.Index(page, open)
+	j := strings.Index(page[i:], "</script>")
+	if i < 0 || j < 0 {
+		t.Fatal("page has no data element")
+	}
+	var data htmlData
+	if err := json.Unmarshal([]byte(page[i+len(open):i+j]), &data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestHTMLReport(t *testing.T) {
+	addr := newTestServerAddr(t)
+	path := filepath.Join(t.TempDir(), "report.html")
+	cfg := testConfig(t, "-addr", addr, "-clients", "2", "-duration", "300ms", "-report", path,
+		"-key-prefix", "</script><b>")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeHTMLReport(path, []*config{cfg}, []*runResult{res}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(b)
+	// Self-contained: nothing is loaded from elsewhere.
+	for _, ref := range []string{"src=", "href=", "@import"} {
+		if strings.Contains(page, ref) {
+			t.Errorf("page references %q", ref)
+		}
+	}
+	if strings.Count(page, "</script>") != 2 {
+		t.Error("the data closed its script element")
+	}
+	data := htmlReportData(t, page)
+	if len(data.Runs) != 1 {
+		t.Fatalf("%d runs embedded", len(data.Runs))
+	}
+	run := data.Runs[0]
+	switch {
+	case run.Label != addr || run.Report.Config.KeyPrefix != "</script><b>":
+		t.Errorf("run %q with key prefix %q", run.Label, run.Report.Config.KeyPrefix)
+	case run.Report.TotalOps != res.total.latency.Count() || len(run.Report.TimeSeries) == 0:
+		t.Errorf("report of %d ops over %d intervals", run.Report.TotalOps, len(run.Report.TimeSeries))
+	case len(run.CDF) != len(cdfPercentiles) || run.CDF[len(run.CDF)-1].P != 100 ||
+		run.CDF[len(run.CDF)-1].LatencyNs != int64(res.total.latency.Maximum()):
+		t.Errorf("CDF of %d points ending at %+v", len(run.CDF), run.CDF[len(run.CDF)-1])
+	}
+	for i := 1; i < len(run.CDF); i++ {
+		if run.CDF[i].P <= run.CDF[i-1].P || run.CDF[i].LatencyNs < run.CDF[i-1].LatencyNs {
+			t.Fatalf("CDF not increasing at %d: %+v, %+v", i, run.CDF[i-1], run.CDF[i])
+		}
+	}
+}
+
+func TestHTMLReportComparison(t *testing.T) {
+	addr, addr2 := newTestServerAddr(t), newTestServerAddr(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.html")
+	cfg := testConfig(t, "-addr", addr, "-addr2", addr2, "-clients", "2", "-ops", "50", "-report", path, "-out", filepath.Join(dir, "summary.txt"))
+	cfgs := []*config{cfg, cfg.secondTarget()}
+	var results []*runResult
+	for _, c := range cfgs {
+		res, err := runTarget(context.Background(), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, res)
+	}
+	if err := writeComparison(cfgs, results); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := htmlReportData(t, string(b))
+	if len(data.Runs) != 2 || data.Runs[0].Label != addr || data.Runs[1].Label != addr2 || data.Runs[1].Report.Config.Addr != addr2 {
+		t.Errorf("comparison page of %d runs: %+v", len(data.Runs), data.Runs)
+	}
+	if _, err := parseFlags([]string{"-report", path, "-sweep-clients", "1,2"}); err == nil {
+		t.Error("-report was accepted with a sweep")
+	}
+}
@@ -0,0 +1,305 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Ways of -http-ttl to send the TTL of a PUT.
+const (
+	httpTTLHeader = "header"
+	httpTTLQuery  = "query"
+)
+
+// httpOnlyFlags are the options of the http target.
+var httpOnlyFlags = []string{"http-path", "http-max-idle", "http2", "http-ttl", "http-ttl-name"}
+
+// validateHTTP checks the options of the http target.
+func (c *config) validateHTTP() error {
+	if !strings.HasPrefix(c.httpPath, "/") {
+		return fmt.Errorf("-http-path must start with /, got %q", c.httpPath)
+	}
+	if c.httpMaxIdle < 0 {
+		return fmt.Errorf("-http-max-idle must not be negative, got %d", c.httpMaxIdle)
+	}
+	if c.http2 && c.tlsConfig == nil {
+		// Go's client only negotiates HTTP/2 through TLS ALPN.
+		return errors.New("-http2 requires -tls")
+	}
+	switch c.httpTTL {
+	case httpTTLHeader, httpTTLQuery:
+	default:
+		return fmt.Errorf("-http-ttl must be %s or %s, got %q", httpTTLHeader, httpTTLQuery, c.httpTTL)
+	}
+	if c.httpTTLName == "" {
+		c.httpTTLName = "X-TTL"
+		if c.httpTTL == httpTTLQuery {
+			c.httpTTLName = "ttl"
+		}
+	}
+	return nil
+}
+
+// httpMaxIdleConns returns the idle connections the transport keeps, by
+// default one per client so none has to dial again.
+func (c *config) httpMaxIdleConns() int {
+	if c.httpMaxIdle > 0 {
+		return c.httpMaxIdle
+	}
+	return c.clients
+}
+
+// httpStatusError is a response with a status other than success or, for
+// a GET, 404.
+type httpStatusError struct {
+	code int
+	body string
+}
+
+func (e *httpStatusError) Error() string {
+	if e.body == "" {
+		return "http: " + http.StatusText(e.code)
+	}
+	return fmt.Sprintf("http: %s: %s", http.StatusText(e.code), e.body)
+}
+
+// httpErrorBody bounds the body of an error response kept in its error.
+const httpErrorBody = 200
+
+// httpTarget speaks to the HTTP API of the server: PUT /keys/{k} and
+// GET /keys/{k} under -http-path.
+type httpTarget struct {
+	cfg    *config
+	base   string
+	client *http.Client
+	trace  *httptrace.ClientTrace
+	conns  httpConnCounters
+}
+
+// httpConnCounters count how the requests got their connection.
+type httpConnCounters struct {
+	requests, reused, wasIdle, dials, http2 atomic.Int64
+}
+
+func newHTTPTarget(cfg *config) *httpTarget {
+	dialer := &net.Dialer{Timeout: respDialTimeout}
+	tr := &http.Transport{
+		// Every request goes to the -addr of cfg, a unix socket included.
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, cfg.network(), cfg.dialAddr())
+		},
+		MaxIdleConnsPerHost: cfg.httpMaxIdleConns(),
+		MaxConnsPerHost:     cfg.poolSize,
+		IdleConnTimeout:     90 * time.Second,
+		DisableCompression:  true,
+		ForceAttemptHTTP2:   cfg.http2,
+	}
+	scheme := "http"
+	if cfg.tlsConfig != nil {
+		scheme = "https"
+		tr.TLSClientConfig = cfg.tlsConfig.Clone()
+	}
+	if !cfg.http2 {
+		// A non-nil empty map keeps the transport to HTTP/1.1.
+		tr.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	host := cfg.dialAddr()
+	if cfg.network() == "unix" {
+		host = "localhost"
+	}
+	t := &httpTarget{
+		cfg:    cfg,
+		base:   scheme + "://" + host + strings.TrimSuffix(cfg.httpPath, "/") + "/",
+		client: &http.Client{Transport: tr},
+	}
+	t.trace = &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.conns.requests.Add(1)
+			if info.Reused {
+				t.conns.reused.Add(1)
+			}
+			if info.WasIdle {
+				t.conns.wasIdle.Add(1)
+			}
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				t.conns.dials.Add(1)
+			}
+		},
+	}
+	return t
+}
+
+// do sends a request for key and returns its response, whose body the
+// caller closes.
+func (t *httpTarget) do(ctx context.Context, method, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	u := t.base + url.PathEscape(key)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, t.trace), method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ProtoMajor == 2 {
+		t.conns.http2.Add(1)
+	}
+	return resp, nil
+}
+
+// statusError drains the body of resp, so its connection can be reused,
+// and returns its error.
+func statusError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, httpErrorBody))
+	io.Copy(io.Discard, resp.Body)
+	return &httpStatusError{code: resp.StatusCode, body: strings.TrimSpace(string(b))}
+}
+
+// Set PUTs value under key, with its TTL in whole seconds, rounded up, in
+// the -http-ttl-name header or query parameter.
+func (t *httpTarget) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	var query url.Values
+	var header http.Header
+	if ttl > 0 {
+		secs := strconv.FormatInt(int64((ttl+time.Second-1)/time.Second), 10)
+		if t.cfg.httpTTL == httpTTLQuery {
+			query = url.Values{t.cfg.httpTTLName: {secs}}
+		} else {
+			header = http.Header{http.CanonicalHeaderKey(t.cfg.httpTTLName): {secs}}
+		}
+	}
+	resp, err := t.do(ctx, http.MethodPut, key, query, value, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return statusError(resp)
+	}
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// Get GETs key; a 404 is a miss.
+func (t *httpTarget) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	resp, err := t.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		_, err := io.Copy(io.Discard, resp.Body)
+		return nil, false, err
+	case resp.StatusCode/100 != 2:
+		return nil, false, statusError(resp)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, max(resp.ContentLength, 0)))
+	if _, err := buf.ReadFrom(resp.Body); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+// Del, Expire and MGet are not part of the API; validateProtocol rejects
+// the workloads that send them.
+
+func (t *httpTarget) Del(context.Context, string) (bool, error) {
+	return false, &capabilityError{protocolHTTP, "DEL"}
+}
+
+func (t *httpTarget) Expire(context.Context, string, time.Duration) (bool, error) {
+	return false, &capabilityError{protocolHTTP, "EXPIRE"}
+}
+
+func (t *httpTarget) MGet(context.Context, []string) ([][]byte, error) {
+	return nil, &capabilityError{protocolHTTP, "MGET"}
+}
+
+func (t *httpTarget) Capabilities() Capability { return protocolCaps[protocolHTTP] }
+
+func (t *httpTarget) Close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
+
+// connReport returns what the counters of t have counted.
+func (t *httpTarget) connReport() *httpConnReport {
+	return &httpConnReport{
+		MaxIdlePerHost: t.cfg.httpMaxIdleConns(),
+		Clients:        t.cfg.clients,
+		Requests:       t.conns.requests.Load(),
+		Reused:         t.conns.reused.Load(),
+		WasIdle:        t.conns.wasIdle.Load(),
+		Dials:          t.conns.dials.Load(),
+		HTTP2:          t.conns.http2.Load(),
+	}
+}
+
+// httpConnReport describes how the requests of the http target got their
+// connection, from httptrace, over the run, warmup included.
+type httpConnReport struct {
+	MaxIdlePerHost int `json:"max_idle_per_host"`
+	Clients        int `json:"clients"`
+	// Requests counts the requests that got a connection, Reused those
+	// that got one an earlier request used, WasIdle those that took it
+	// from the idle pool, and Dials the connections opened.
+	Requests int64 `json:"requests"`
+	Reused   int64 `json:"reused"`
+	WasIdle  int64 `json:"was_idle"`
+	Dials    int64 `json:"dials"`
+	// HTTP2 counts the responses that came over HTTP/2.
+	HTTP2 int64 `json:"http2"`
+}
+
+// since returns the counts of r taken after before.
+func (r *httpConnReport) since(before *httpConnReport) *httpConnReport {
+	d := *r
+	d.Requests -= before.Requests
+	d.Reused -= before.Reused
+	d.WasIdle -= before.WasIdle
+	d.Dials -= before.Dials
+	d.HTTP2 -= before.HTTP2
+	return &d
+}
+
+// printHTTPConns writes the connection reuse section of the summary.
+func printHTTPConns(w io.Writer, r *httpConnReport) {
+	reused := 0.0
+	if r.Requests > 0 {
+		reused = 100 * float64(r.Reused) / float64(r.Requests)
+	}
+	fmt.Fprintf(w, "HTTP connections: %d requests, %d on a reused connection (%.1f%%), %d from the idle pool; %d dials, at most %d idle\n",
+		r.Requests, r.Reused, reused, r.WasIdle, r.Dials, r.MaxIdlePerHost)
+	if r.HTTP2 > 0 {
+		fmt.Fprintf(w, "  %d responses over HTTP/2\n", r.HTTP2)
+	}
+	if r.Dials > int64(r.Clients) {
+		fmt.Fprintf(w, "WARNING: %d connections dialed for %d clients; latency includes connection setup, not only the server (raise -http-max-idle)\n",
+			r.Dials, r.Clients)
+	}
+}
@@ -0,0 +1,158 @@
+package loadgen
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newHTTPServer serves PUT and GET of /keys/{k} for the tests, recording
+// the TTL of every PUT. A key starting with "fail" gets a 503, one
+// starting with "bad" a 400 and one starting with "slow" waits 200ms.
+func newHTTPServer(t *testing.T, tls bool) (*httptest.Server, map[string]string) {
+	t.Helper()
+	var mu sync.Mutex
+	data, ttls := make(map[string][]byte), make(map[string]string)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := strings.CutPrefix(r.URL.Path, "/keys/")
+		switch {
+		case !ok:
+			http.NotFound(w, r)
+			return
+		case strings.HasPrefix(key, "fail"):
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		case strings.HasPrefix(key, "bad"):
+			http.Error(w, "bad key", http.StatusBadRequest)
+			return
+		case strings.HasPrefix(key, "slow"):
+			time.Sleep(200 * time.Millisecond)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			v, _ := io.ReadAll(r.Body)
+			data[key] = v
+			ttls[key] = r.Header.Get("X-TTL") + r.URL.Query().Get("expire")
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			v, ok := data[key]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(v)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+	if tls {
+		srv.EnableHTTP2 = true
+		srv.StartTLS()
+	} else {
+		srv.Start()
+	}
+	t.Cleanup(srv.Close)
+	return srv, ttls
+}
+
+func httpAddr(srv *httptest.Server) string {
+	return strings.TrimPrefix(strings.TrimPrefix(srv.URL, "https://"), "http://")
+}
+
+func TestHTTPTarget(t *testing.T) {
+	srv, ttls := newHTTPServer(t, false)
+	cfg := testConfig(t, "-addr", httpAddr(srv), "-protocol", "http", "-clients", "1")
+	ht := newHTTPTarget(cfg)
+	defer ht.Close()
+	ctx := context.Background()
+
+	if err := ht.Set(ctx, "k 1/2", []byte("value"), 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	v, found, err := ht.Get(ctx, "k 1/2")
+	if err != nil || !found || string(v) != "value" {
+		t.Errorf("get = %q, %v, %v", v, found, err)
+	}
+	if ttls["k 1/2"] != "2" {
+		t.Errorf("TTL header %q, want the TTL rounded up to 2s", ttls["k 1/2"])
+	}
+	if _, found, err := ht.Get(ctx, "missing"); found || err != nil {
+		t.Errorf("get of a missing key = %v, %v", found, err)
+	}
+	for key, want := range map[string]errClass{"fail": errServer, "bad": errProtocol} {
+		if err := ht.Set(ctx, key, []byte("v"), 0); err == nil || classifyError(err) != want {
+			t.Errorf("PUT of %s: %v, want %v", key, err, want)
+		}
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, _, err := ht.Get(timeoutCtx, "slow"); err == nil || classifyError(err) != errTimeout {
+		t.Errorf("GET past its deadline: %v", err)
+	}
+	conns := ht.connReport()
+	if conns.Requests != 6 || conns.Reused == 0 {
+		t.Errorf("connections %+v", conns)
+	}
+}
+
+func TestHTTPTTLQuery(t *testing.T) {
+	srv, ttls := newHTTPServer(t, false)
+	cfg := testConfig(t, "-addr", httpAddr(srv), "-protocol", "http", "-clients", "1", "-http-ttl", "query", "-http-ttl-name", "expire")
+	ht := newHTTPTarget(cfg)
+	defer ht.Close()
+
+	if err := ht.Set(context.Background(), "k", []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ttls["k"] != "60" {
+		t.Errorf("TTL parameter %q", ttls["k"])
+	}
+}
+
+func TestHTTPRun(t *testing.T) {
+	srv, _ := newHTTPServer(t, false)
+	cfg := testConfig(t, "-addr", httpAddr(srv), "-protocol", "http", "-ratio", "get=0.7,set=0.3",
+		"-keyspace", "40", "-preload", "20", "-clients", "4", "-ops", "50", "-value-size", "32")
+
+	res, err := runTarget(context.Background(), cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.preload == nil || res.preload.written != 20 || res.total.attempts() != 200 || res.total.errors() != 0 {
+		t.Fatalf("preload %+v, %d operations, %d failed", res.preload, res.total.attempts(), res.total.errors())
+	}
+	if get := res.total.ops[opGet]; get.hits == 0 || get.misses == 0 {
+		t.Errorf("GET %d hits, %d misses", get.hits, get.misses)
+	}
+	conns := res.httpConns
+	if conns == nil || conns.Requests != 200 || conns.Dials > 4 || conns.Reused < 196 {
+		t.Errorf("connections %+v, want every request after the first of each client on a reused one", conns)
+	}
+	var b strings.Builder
+	printSummary(&b, cfg, res)
+	if !strings.Contains(b.String(), "HTTP connections: 200 requests") || strings.Contains(b.String(), "WARNING: ") {
+		t.Errorf("summary:\n%s", b.String())
+	}
+}
+
+func TestHTTP2(t *testing.T) {
+	srv, _ := newHTTPServer(t, true)
+	cfg := testConfig(t, "-addr", httpAddr(srv), "-protocol", "http", "-clients", "1", "-tls", "-tls-skip-verify", "-http2")
+	ht := newHTTPTarget(cfg)
+	defer ht.Close()
+
+	if err := ht.Set(context.Background(), "k", []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if conns := ht.connReport(); conns.HTTP2 != 1 {
+		t.Errorf("connections %+v, want the response over HTTP/2", conns)
+	}
+}
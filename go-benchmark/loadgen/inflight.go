@@ -0,0 +1,238 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// validateInflight checks -max-inflight and -queue-timeout.
+func (c *config) validateInflight() error {
+	switch {
+	case c.maxInflight < 0:
+		return fmt.Errorf("-max-inflight must not be negative, got %d", c.maxInflight)
+	case c.maxInflight > 0 && c.maxInflight < c.pipeline:
+		return fmt.Errorf("-max-inflight %d is below -pipeline %d, whose batches could never be sent", c.maxInflight, c.pipeline)
+	case c.queueTimeout < 0:
+		return fmt.Errorf("-queue-timeout must not be negative, got %v", c.queueTimeout)
+	case c.queueTimeout > 0 && c.maxInflight == 0 && c.loop != loopOpen:
+		return errors.New("-queue-timeout requires -max-inflight or -loop open, where operations wait to be sent")
+	}
+	return nil
+}
+
+// errShed is the wait for the in-flight cap outlasting -queue-timeout.
+var errShed = errors.New("queue timeout")
+
+// inflightGate caps the commands in flight across all clients at
+// -max-inflight. Waiters are served in order, so a pipeline waiting for
+// room for its whole batch is not starved by single commands. The gate
+// also follows how many commands were in flight over the measured window.
+type inflightGate struct {
+	max int64
+
+	mu      sync.Mutex
+	cur     int64
+	waiters []*gateWaiter
+
+	// from is the start of the measured window, last the time cur last
+	// changed and area the integral of cur since from, in commands times
+	// nanoseconds; peak is the most in flight at once since from.
+	from, last time.Time
+	area       float64
+	peak       int64
+}
+
+type gateWaiter struct {
+	n     int64
+	ready chan struct{}
+}
+
+func newInflightGate(max int) *inflightGate {
+	now := time.Now()
+	return &inflightGate{max: int64(max), from: now, last: now}
+}
+
+// account adds the time since the last change to the integral of g; the
+// caller holds g.mu.
+func (g *inflightGate) account(now time.Time) {
+	if now.After(g.last) {
+		g.area += float64(g.cur) * float64(now.Sub(g.last))
+		g.last = now
+	}
+}
+
+// add changes the commands in flight by n; the caller holds g.mu.
+func (g *inflightGate) add(n int64) {
+	g.account(time.Now())
+	g.cur += n
+	g.peak = max(g.peak, g.cur)
+}
+
+// acquire waits for room for n commands until deadline, when it returns
+// errShed, or until ctx is done. A zero deadline waits as long as it takes.
+func (g *inflightGate) acquire(ctx context.Context, n int, deadline time.Time) error {
+	g.mu.Lock()
+	if len(g.waiters) == 0 && g.cur+int64(n) <= g.max {
+		g.add(int64(n))
+		g.mu.Unlock()
+		return nil
+	}
+	wt := &gateWaiter{n: int64(n), ready: make(chan struct{})}
+	g.waiters = append(g.waiters, wt)
+	g.mu.Unlock()
+
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		expired = t.C
+	}
+	err := errShed
+	select {
+	case <-wt.ready:
+		return nil
+	case <-expired:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-wt.ready:
+		// Granted while giving up: take it.
+		return nil
+	default:
+	}
+	for i, o := range g.waiters {
+		if o == wt {
+			g.waiters = append(g.waiters[:i], g.waiters[i+1:]...)
+			break
+		}
+	}
+	// The waiter behind may fit where this one did not.
+	g.grant()
+	return err
+}
+
+// release returns the room of n commands.
+func (g *inflightGate) release(n int) {
+	g.mu.Lock()
+	g.add(-int64(n))
+	g.grant()
+	g.mu.Unlock()
+}
+
+// grant lets the waiters at the head of the queue in while they fit; the
+// caller holds g.mu.
+func (g *inflightGate) grant() {
+	for len(g.waiters) > 0 && g.cur+g.waiters[0].n <= g.max {
+		wt := g.waiters[0]
+		g.waiters = g.waiters[1:]
+		g.add(wt.n)
+		close(wt.ready)
+	}
+}
+
+// startWindow starts following the commands in flight at now, the start of
+// the measured window.
+func (g *inflightGate) startWindow(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.from, g.last, g.area, g.peak = now, now, 0, g.cur
+}
+
+// inflightReport describes the -max-inflight cap of a run in its JSON
+// report.
+type inflightReport struct {
+	Max int `json:"max"`
+	// Peak is the most commands in flight at once and Average their
+	// time-average over the measured window.
+	Peak    int64   `json:"peak"`
+	Average float64 `json:"average"`
+	// Shed counts the operations that waited longer than QueueTimeoutNs,
+	// -queue-timeout, and were never sent.
+	QueueTimeoutNs int64 `json:"queue_timeout_ns,omitempty"`
+	Shed           int64 `json:"shed,omitempty"`
+	// QueueWait is the time from enqueue, the intended send time of a
+	// paced operation, to send.
+	QueueWait *latencySummary `json:"queue_wait,omitempty"`
+}
+
+// report returns what g followed by end.
+func (g *inflightGate) report(end time.Time) *inflightReport {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.account(end)
+	r := &inflightReport{Max: int(g.max), Peak: g.peak}
+	if d := end.Sub(g.from); d > 0 {
+		r.Average = g.area / float64(d)
+	}
+	return r
+}
+
+// dispatch waits for room for n commands enqueued at enqueued under
+// -max-inflight, recording the wait, and returns the function releasing
+// them. It returns false when the operation was shed, or the run ended,
+// instead.
+func (w *worker) dispatch(runCtx context.Context, n int, enqueued time.Time) (func(), bool) {
+	cfg, g := w.run.cfg, w.run.gate
+	var deadline time.Time
+	if cfg.queueTimeout > 0 {
+		deadline = enqueued.Add(cfg.queueTimeout)
+		if time.Now().After(deadline) {
+			// An arrival of the open loop that waited too long for a
+			// client.
+			w.shed(n)
+			return nil, false
+		}
+	}
+	if g == nil {
+		return func() {}, true
+	}
+	if err := g.acquire(runCtx, n, deadline); err != nil {
+		if err == errShed {
+			w.shed(n)
+		}
+		return nil, false
+	}
+	if w.measuring {
+		w.result.recordQueueWait(time.Since(enqueued))
+	}
+	return func() { g.release(n) }, true
+}
+
+// shed counts n operations dropped by -queue-timeout.
+func (w *worker) shed(n int) {
+	if w.measuring {
+		w.result.shed += int64(n)
+	}
+}
+
+// recordQueueWait records how long an operation waited for the in-flight
+// cap from its enqueue time.
+func (r *workerResult) recordQueueWait(d time.Duration) {
+	if r.queueWait == nil {
+		r.queueWait = r.layout.New()
+	}
+	r.queueWait.Record(d)
+}
+
+// printInflight writes the -max-inflight section of the summary.
+func printInflight(w io.Writer, u latencyUnit, cfg *config, res *runResult) {
+	r := res.inflight
+	if r == nil {
+		return
+	}
+	fmt.Fprintf(w, "In flight: at most %d commands, peak %d, time-average %.1f", r.Max, r.Peak, r.Average)
+	if cfg.queueTimeout > 0 {
+		fmt.Fprintf(w, "; %d operations shed after waiting -queue-timeout %v", r.Shed, cfg.queueTimeout)
+	}
+	fmt.Fprintln(w)
+	if res.total.queueWait != nil {
+		printLatency(w, u, "Queue wait (enqueue to send)", res.total.queueWait)
+	}
+}
@@ -0,0 +1,134 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+)
+
+func TestInflightGate(t *testing.T) {
+	g := newInflightGate(2)
+	ctx := context.Background()
+	if err := g.acquire(ctx, 2, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.acquire(ctx, 1, time.Now().Add(10*time.Millisecond)); err != errShed {
+		t.Fatalf("acquire past the cap = %v, want it shed", err)
+	}
+
+	// A pipeline waiting for room for two is served before a later single
+	// command.
+	order := make(chan int, 2)
+	go func() {
+		if g.acquire(ctx, 2, time.Time{}) == nil {
+			order <- 2
+		}
+	}()
+	for g.waiting() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		if g.acquire(ctx, 1, time.Time{}) == nil {
+			order <- 1
+		}
+	}()
+	for g.waiting() < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	g.release(1)
+	select {
+	case n := <-order:
+		t.Fatalf("waiter for %d let in with one free", n)
+	case <-time.After(10 * time.Millisecond):
+	}
+	g.release(1)
+	if n := <-order; n != 2 {
+		t.Fatalf("waiter for %d let in first", n)
+	}
+	g.release(2)
+	<-order
+	if r := g.report(time.Now()); r.Peak != 2 || r.Max != 2 {
+		t.Errorf("report %+v", r)
+	}
+}
+
+// waiting returns the number of waiters of g.
+func (g *inflightGate) waiting() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.waiters)
+}
+
+// newSlowServer returns a miniredis taking d to serve every command.
+func newSlowServer(t *testing.T, d time.Duration) *miniredis.Miniredis {
+	mr := miniredis.RunT(t)
+	mr.Server().SetPreHook(func(*server.Peer, string, ...string) bool {
+		time.Sleep(d)
+		return false
+	})
+	return mr
+}
+
+func TestMaxInflight(t *testing.T) {
+	mr := newSlowServer(t, 2*time.Millisecond)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "6", "-max-inflight", "2", "-duration", "300ms", "-preload", "0")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := res.inflight
+	if r == nil || r.Peak != 2 || r.Average < 1 || r.Average > 2 {
+		t.Fatalf("in flight %+v, want two at a time", r)
+	}
+	// Six clients share two slots: each waits for about two others.
+	if q := res.total.queueWait; q == nil || q.Count() != res.total.attempts() || q.Percentile(50) < time.Millisecond {
+		t.Errorf("queue wait %v of %d operations", q, res.total.attempts())
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	for _, want := range []string{"In flight: at most 2 commands, peak 2", "Queue wait (enqueue to send) latency"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestQueueTimeoutSheds(t *testing.T) {
+	mr := newSlowServer(t, 3*time.Millisecond)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "4", "-pipeline", "1", "-max-inflight", "1",
+		"-queue-timeout", "1ms", "-duration", "200ms", "-preload", "0")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.total.shed == 0 || res.inflight.Shed != res.total.shed {
+		t.Fatalf("shed %d, report %+v", res.total.shed, res.inflight)
+	}
+	if w := res.total.queueWait; w == nil || w.Maximum() > 5*time.Millisecond {
+		t.Errorf("an operation was sent %v after it was enqueued, past -queue-timeout", w.Maximum())
+	}
+	if rep := buildReport(cfg, res); rep.Inflight == nil || rep.Inflight.QueueTimeoutNs != int64(time.Millisecond) {
+		t.Errorf("report %+v", rep.Inflight)
+	}
+}
+
+func TestValidateInflight(t *testing.T) {
+	for _, args := range [][]string{
+		{"-max-inflight", "-1"},
+		{"-max-inflight", "4", "-pipeline", "8"},
+		{"-queue-timeout", "1ms"},
+		{"-max-inflight", "4", "-queue-timeout", "-1ms"},
+	} {
+		if _, err := parseFlags(append(args, "-clients", "2")); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	testConfig(t, "-clients", "2", "-loop", "open", "-rate", "100", "-queue-timeout", "5ms")
+}
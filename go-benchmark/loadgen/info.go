@@ -0,0 +1,229 @@
+package loadgen
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// infoFields are the INFO fields -capture-info keeps. Servers such as
+// htcache omit some of them; only those present are recorded.
+var infoFields = []string{
+	"used_memory", "used_memory_rss", "used_memory_peak", "maxmemory", "mem_fragmentation_ratio",
+	"connected_clients", "blocked_clients", "total_connections_received", "rejected_connections",
+	"total_commands_processed", "instantaneous_ops_per_sec",
+	"keyspace_hits", "keyspace_misses", "evicted_keys", "expired_keys",
+}
+
+// infoDeltaFields are the counters whose growth over the run the summary
+// prints.
+var infoDeltaFields = []string{"evicted_keys", "expired_keys", "rejected_connections", "keyspace_hits", "keyspace_misses"}
+
+// Phases of an INFO snapshot.
+const (
+	infoBefore = "before"
+	infoDuring = "during"
+	infoAfter  = "after"
+)
+
+// validateInfo checks the flags of -capture-info.
+func (c *config) validateInfo() error {
+	if c.infoInterval <= 0 {
+		return fmt.Errorf("-info-interval must be positive, got %v", c.infoInterval)
+	}
+	return nil
+}
+
+// parseInfo splits an INFO reply into its fields, skipping the section
+// headers.
+func parseInfo(info string) map[string]string {
+	fields := make(map[string]string)
+	sc := bufio.NewScanner(strings.NewReader(info))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// infoSnapshot is the server state at T seconds from the start of the
+// measured window. Fields are the numeric infoFields the server reported;
+// DBSize and Memory, the numeric entries of MEMORY STATS, are nil when the
+// server does not support the command.
+type infoSnapshot struct {
+	T      float64            `json:"t"`
+	Phase  string             `json:"phase"`
+	Fields map[string]float64 `json:"fields"`
+	DBSize *int64             `json:"dbsize,omitempty"`
+	Memory map[string]float64 `json:"memory_stats,omitempty"`
+
+	at time.Time
+}
+
+// captureInfo takes a snapshot of the server through rdb. On a cluster,
+// INFO describes a single node.
+func captureInfo(ctx context.Context, rdb redis.UniversalClient, phase string) (infoSnapshot, error) {
+	s := infoSnapshot{Phase: phase, at: time.Now(), Fields: make(map[string]float64)}
+	info, err := rdb.Info(ctx).Result()
+	if err != nil {
+		return s, err
+	}
+	fields := parseInfo(info)
+	for _, name := range infoFields {
+		if v, err := strconv.ParseFloat(fields[name], 64); err == nil {
+			s.Fields[name] = v
+		}
+	}
+	if n, err := rdb.DBSize(ctx).Result(); err == nil {
+		s.DBSize = &n
+	}
+	if reply, err := rdb.Do(ctx, "MEMORY", "STATS").Slice(); err == nil {
+		s.Memory = memoryStats(reply)
+	}
+	return s, nil
+}
+
+// memoryStats keeps the numeric entries of a MEMORY STATS reply, a flat
+// list of names and values; nested entries such as db.0 are skipped.
+func memoryStats(reply []interface{}) map[string]float64 {
+	stats := make(map[string]float64)
+	for i := 0; i+1 < len(reply); i += 2 {
+		name, ok := reply[i].(string)
+		if !ok {
+			continue
+		}
+		switch v := reply[i+1].(type) {
+		case int64:
+			stats[name] = float64(v)
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				stats[name] = f
+			}
+		}
+	}
+	return stats
+}
+
+// infoMonitor takes INFO snapshots every -info-interval while the run is
+// in progress.
+type infoMonitor struct {
+	stopCh chan struct{}
+	done   sync.WaitGroup
+	// mu guards snapshots, which -timeseries-out reads as they come.
+	mu        sync.Mutex
+	snapshots []infoSnapshot
+}
+
+func startInfoMonitor(rdb redis.UniversalClient, cfg *config) *infoMonitor {
+	m := &infoMonitor{stopCh: make(chan struct{})}
+	m.done.Add(1)
+	go func() {
+		defer m.done.Done()
+		t := time.NewTicker(cfg.infoInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if s, err := captureInfo(context.Background(), rdb, infoDuring); err == nil {
+					m.mu.Lock()
+					m.snapshots = append(m.snapshots, s)
+					m.mu.Unlock()
+				}
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// latestIn returns the last snapshot taken after from and up to to, false
+// when there is none or m is nil.
+func (m *infoMonitor) latestIn(from, to time.Time) (infoSnapshot, bool) {
+	if m == nil {
+		return infoSnapshot{}, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.snapshots) - 1; i >= 0; i-- {
+		if at := m.snapshots[i].at; at.After(from) && !at.After(to) {
+			return m.snapshots[i], true
+		} else if !at.After(from) {
+			break
+		}
+	}
+	return infoSnapshot{}, false
+}
+
+// stop ends polling and returns the snapshots taken.
+func (m *infoMonitor) stop() []infoSnapshot {
+	close(m.stopCh)
+	m.done.Wait()
+	return m.snapshots
+}
+
+// infoReport is the server-side view of a -capture-info run.
+type infoReport struct {
+	IntervalNs int64          `json:"interval_ns"`
+	Snapshots  []infoSnapshot `json:"snapshots"`
+	// Deltas is the growth of the infoDeltaFields counters from the first
+	// snapshot to the last, for those the server reported in both.
+	Deltas map[string]float64 `json:"deltas"`
+}
+
+// buildInfo times the snapshots from start and computes the deltas.
+func buildInfo(cfg *config, snapshots []infoSnapshot, start time.Time) *infoReport {
+	rep := &infoReport{IntervalNs: int64(cfg.infoInterval), Snapshots: snapshots, Deltas: make(map[string]float64)}
+	if rep.Snapshots == nil {
+		rep.Snapshots = []infoSnapshot{}
+	}
+	for i := range rep.Snapshots {
+		rep.Snapshots[i].T = rep.Snapshots[i].at.Sub(start).Seconds()
+	}
+	if len(snapshots) < 2 {
+		return rep
+	}
+	first, last := snapshots[0].Fields, snapshots[len(snapshots)-1].Fields
+	for _, name := range infoDeltaFields {
+		a, okA := first[name]
+		b, okB := last[name]
+		if okA && okB {
+			rep.Deltas[name] = b - a
+		}
+	}
+	return rep
+}
+
+// printInfo writes the server-side section of the summary.
+func printInfo(w io.Writer, rep *infoReport) {
+	fmt.Fprintf(w, "Server INFO: %d snapshots\n", len(rep.Snapshots))
+	if len(rep.Snapshots) == 0 {
+		return
+	}
+	first, last := rep.Snapshots[0].Fields, rep.Snapshots[len(rep.Snapshots)-1].Fields
+	if a, ok := first["used_memory"]; ok {
+		if b, ok := last["used_memory"]; ok {
+			fmt.Fprintf(w, "  used_memory: %.0f -> %.0f bytes\n", a, b)
+		}
+	}
+	for _, name := range infoDeltaFields {
+		if d, ok := rep.Deltas[name]; ok {
+			fmt.Fprintf(w, "  %s: %+.0f\n", name, d)
+		}
+	}
+	if d := rep.Deltas["rejected_connections"]; d > 0 {
+		fmt.Fprintf(w, "WARNING: the server rejected %.0f connections during the run\n", d)
+	}
+}
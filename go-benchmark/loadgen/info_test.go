@@ -0,0 +1,88 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestParseInfo(t *testing.T) {
+	info := "# Memory\r\nused_memory:1024\r\nmem_fragmentation_ratio:1.50\r\n\r\n# Keyspace\r\ndb0:keys=3,expires=0\r\n"
+	f := parseInfo(info)
+	if f["used_memory"] != "1024" || f["mem_fragmentation_ratio"] != "1.50" || f["db0"] != "keys=3,expires=0" {
+		t.Errorf("parsed %v", f)
+	}
+	if _, ok := f["# Memory"]; ok {
+		t.Error("section header parsed as a field")
+	}
+
+	stats := memoryStats([]interface{}{"peak.allocated", int64(2048), "fragmentation", "1.25", "db.0", []interface{}{"overhead.hashtable.main", int64(72)}})
+	if len(stats) != 2 || stats["peak.allocated"] != 2048 || stats["fragmentation"] != 1.25 {
+		t.Errorf("MEMORY STATS parsed as %v", stats)
+	}
+}
+
+func TestCaptureInfo(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-duration", "300ms", "-key-prefix", "in:",
+		"-capture-info", "-info-interval", "100ms")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep := res.info
+	if rep == nil || len(rep.Snapshots) < 3 {
+		t.Fatalf("info %+v, want snapshots before, during and after", rep)
+	}
+	first, last := rep.Snapshots[0], rep.Snapshots[len(rep.Snapshots)-1]
+	if first.Phase != infoBefore || last.Phase != infoAfter || rep.Snapshots[1].Phase != infoDuring {
+		t.Errorf("phases %s ... %s", first.Phase, last.Phase)
+	}
+	if first.T > 0 || last.T < 0.3 {
+		t.Errorf("snapshots at %.3fs and %.3fs of a 300ms run", first.T, last.T)
+	}
+	// miniredis only reports connected_clients and has no MEMORY STATS.
+	if _, ok := last.Fields["connected_clients"]; !ok || len(last.Fields) != 1 {
+		t.Errorf("fields %v, want only those the server reports", last.Fields)
+	}
+	if last.Memory != nil || last.DBSize == nil || *last.DBSize == 0 {
+		t.Errorf("memory stats %v, dbsize %v", last.Memory, last.DBSize)
+	}
+	if len(rep.Deltas) != 0 {
+		t.Errorf("deltas %v of counters the server lacks", rep.Deltas)
+	}
+}
+
+func TestInfoDeltas(t *testing.T) {
+	start := time.Unix(100, 0)
+	snap := func(phase string, at time.Duration, fields map[string]float64) infoSnapshot {
+		return infoSnapshot{Phase: phase, at: start.Add(at), Fields: fields}
+	}
+	cfg := testConfig(t, "-capture-info")
+	rep := buildInfo(cfg, []infoSnapshot{
+		snap(infoBefore, -time.Second, map[string]float64{"evicted_keys": 10, "rejected_connections": 0, "used_memory": 100}),
+		snap(infoDuring, time.Second, map[string]float64{"evicted_keys": 20}),
+		snap(infoAfter, 2*time.Second, map[string]float64{"evicted_keys": 50, "rejected_connections": 3, "expired_keys": 7, "used_memory": 900}),
+	}, start)
+
+	if rep.Snapshots[0].T != -1 || rep.Snapshots[2].T != 2 {
+		t.Errorf("snapshot times %v and %v", rep.Snapshots[0].T, rep.Snapshots[2].T)
+	}
+	if len(rep.Deltas) != 2 || rep.Deltas["evicted_keys"] != 40 || rep.Deltas["rejected_connections"] != 3 {
+		t.Errorf("deltas %v", rep.Deltas)
+	}
+
+	var buf bytes.Buffer
+	printInfo(&buf, rep)
+	for _, want := range []string{"evicted_keys: +40", "used_memory: 100 -> 900", "rejected 3 connections"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package loadgen
+
+import (
+	"fmt"
+	"math/rand"
+
+	"go-benchmark/workload"
+)
+
+// Supported values for -key-dist.
+const (
+	keyDistUniform    = "uniform"
+	keyDistSequential = "sequential"
+	keyDistZipfian    = "zipfian"
+	// The locality patterns stress an eviction policy. A scan makes one
+	// pass over -keyspace after another, the clients striding through it
+	// together; a loop does the same over the -loop-keys first keys, a set
+	// meant to be slightly larger than the cache; lru-friendly re-references
+	// the keys of a sliding window of recent ones.
+	keyDistScan        = "scan"
+	keyDistLoop        = "loop"
+	keyDistLRUFriendly = "lru-friendly"
+)
+
+// newKeyChooser returns the configured distribution for one worker. Workers
+// use their own rng, and shared read-only state such as the zipfian constants
+// lives on cfg.
+func newKeyChooser(cfg *config, rng *rand.Rand, clientID int) workload.KeyChooser {
+	switch cfg.keyDist {
+	case keyDistSequential:
+		// Start workers at evenly spaced offsets so they do not all walk the
+		// same keys in lockstep.
+		start := int(int64(clientID) * int64(cfg.keyspace) / int64(cfg.clients))
+		return &workload.SequentialKeys{N: cfg.keyspace, Cur: start}
+	case keyDistZipfian:
+		return &workload.ZipfianKeys{Z: cfg.zipf, Rng: rng}
+	case keyDistScan, keyDistLoop:
+		n := cfg.keyspace
+		if cfg.keyDist == keyDistLoop {
+			n = cfg.loopKeys
+		}
+		start := clientID % n
+		return &workload.StridedKeys{N: n, Start: start, Stride: cfg.clients, Cur: start}
+	case keyDistLRUFriendly:
+		return &workload.ReuseWindowKeys{N: cfg.keyspace, Window: cfg.rerefWindow, Reref: cfg.rerefProb, Rng: rng}
+	default:
+		return &workload.UniformKeys{N: cfg.keyspace, Rng: rng}
+	}
+}
+
+// validateKeyDist checks -key-dist and prepares any shared generator state.
+func (c *config) validateKeyDist() error {
+	switch c.keyDist {
+	case keyDistUniform, keyDistSequential:
+		return nil
+	case keyDistZipfian:
+		z, err := workload.NewZipfian(c.keyspace, c.zipfTheta)
+		if err != nil {
+			return fmt.Errorf("-key-dist zipfian: %w", err)
+		}
+		c.zipf = z
+		return nil
+	case keyDistScan:
+		return nil
+	case keyDistLoop:
+		if c.loopKeys < 1 || c.loopKeys > c.keyspace {
+			return fmt.Errorf("-key-dist loop requires -loop-keys between 1 and -keyspace %d, got %d", c.keyspace, c.loopKeys)
+		}
+		return nil
+	case keyDistLRUFriendly:
+		switch {
+		case c.rerefWindow < 1:
+			return fmt.Errorf("-reref-window must be positive, got %d", c.rerefWindow)
+		case c.rerefProb < 0 || c.rerefProb >= 1:
+			return fmt.Errorf("-reref-prob must be in [0, 1), got %v", c.rerefProb)
+		}
+		return nil
+	default:
+		return fmt.Errorf("-key-dist must be one of uniform, sequential, zipfian, scan, loop or lru-friendly, got %q", c.keyDist)
+	}
+}
+
+// localityDist reports whether -key-dist is one of the locality patterns,
+// whose re-reference distances the run follows.
+func (c *config) localityDist() bool {
+	switch c.keyDist {
+	case keyDistScan, keyDistLoop, keyDistLRUFriendly:
+		return true
+	}
+	return false
+}
+
+// describeKeyDist returns the key distribution with its parameters.
+func describeKeyDist(cfg *config) string {
+	switch cfg.keyDist {
+	case keyDistZipfian:
+		return fmt.Sprintf("zipfian (theta %v)", cfg.zipfTheta)
+	case keyDistLoop:
+		return fmt.Sprintf("loop (over %d keys)", cfg.loopKeys)
+	case keyDistLRUFriendly:
+		return fmt.Sprintf("lru-friendly (window %d, re-reference %v)", cfg.rerefWindow, cfg.rerefProb)
+	}
+	return cfg.keyDist
+}
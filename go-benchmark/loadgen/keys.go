@@ -0,0 +1,140 @@
+package loadgen
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"go-benchmark/workload"
+)
+
+// keyPadByte fills keys out to -key-size. Keys end in their index, so
+// padded keys stay distinct.
+const keyPadByte = "_"
+
+// validateKeySize checks -key-size against the longest key the run can
+// generate, which must fit unpadded.
+func (c *config) validateKeySize() error {
+	if c.keySize < 0 {
+		return fmt.Errorf("-key-size must not be negative, got %d", c.keySize)
+	}
+	if c.keySize == 0 {
+		return nil
+	}
+	var longest int
+	switch {
+	case c.usesKeyspace():
+		longest = len(c.keyPrefix + "key" + strconv.Itoa(c.keyspace-1+c.keyOffset))
+	case c.workload == workloadSet:
+		// Fresh keys end in a random non-negative int.
+		longest = len(c.keyPrefix + "client" + strconv.Itoa(c.clients-1+c.clientOffset) + "-key" + strconv.Itoa(math.MaxInt))
+	default:
+		return fmt.Errorf("-key-size does not apply to the %s workload, whose keys are its own", c.workload)
+	}
+	if longest > c.keySize {
+		return fmt.Errorf("-key-size %d is shorter than the keys of the run, of up to %d bytes with -key-prefix %q", c.keySize, longest, c.keyPrefix)
+	}
+	c.keyPad = strings.Repeat(keyPadByte, c.keySize)
+	return nil
+}
+
+// padKey pads key out to -key-size.
+func (c *config) padKey(key string) string {
+	if n := c.keySize - len(key); n > 0 {
+		return key + c.keyPad[:n]
+	}
+	return key
+}
+
+// touchedKeys records the keys of the keyspace a worker picks in the key
+// sketch of its current result, which the warmup switch replaces along with
+// the rest.
+type touchedKeys struct {
+	workload.KeyChooser
+	w *worker
+}
+
+func (t touchedKeys) Next() int {
+	i := t.KeyChooser.Next()
+	t.w.result.keySketch().add(i + t.w.run.cfg.keyOffset)
+	if r := t.w.run.reuse; r != nil {
+		t.w.result.recordReuse(r.touch(i))
+	}
+	return i
+}
+
+// keySketchBits sets the 4096 registers of a keySketch, which estimates
+// within about 2% in 4 KB per worker.
+const keySketchBits = 12
+
+// keySketch is a HyperLogLog sketch of the key indexes a run touched. The
+// indexes are hashed without a seed, so the sketches of workers and agents
+// merge register by register.
+type keySketch struct {
+	reg [1 << keySketchBits]uint8
+}
+
+func (s *keySketch) add(i int) {
+	h := mix64(uint64(i))
+	r := &s.reg[h>>(64-keySketchBits)]
+	// The rank of the remaining bits, capped by their width.
+	if rank := uint8(bits.LeadingZeros64(h<<keySketchBits|1<<(keySketchBits-1)) + 1); rank > *r {
+		*r = rank
+	}
+}
+
+func (s *keySketch) merge(o *keySketch) {
+	for i, r := range o.reg {
+		s.reg[i] = max(s.reg[i], r)
+	}
+}
+
+// estimate returns the number of distinct indexes added, counted exactly
+// enough by linear counting while registers are still empty.
+func (s *keySketch) estimate() int64 {
+	m := float64(len(s.reg))
+	var sum float64
+	zeros := 0
+	for _, r := range s.reg {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(e))
+}
+
+// mix64 is the splitmix64 finalizer, spreading consecutive indexes over
+// the whole hash.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// keySketch returns the key sketch of r, allocating it on first use.
+func (r *workerResult) keySketch() *keySketch {
+	if r.keys == nil {
+		r.keys = &keySketch{}
+	}
+	return r.keys
+}
+
+// keysTouched returns the estimated number of distinct keys of the keyspace
+// the measured run used, at most the keyspace itself, or 0 when it used no
+// keyspace.
+func keysTouched(cfg *config, total *workerResult) int64 {
+	if total.keys == nil {
+		return 0
+	}
+	return min(total.keys.estimate(), int64(cfg.keyspace))
+}
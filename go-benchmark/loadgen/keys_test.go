@@ -0,0 +1,95 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestKeySketch(t *testing.T) {
+	for _, n := range []int{10, 1000, 100000} {
+		var a, b keySketch
+		for i := 0; i < n; i++ {
+			// Every key touched twice, by one worker or the other.
+			a.add(i)
+			if i%2 == 0 {
+				a.add(i)
+			} else {
+				b.add(i)
+			}
+		}
+		if e := a.estimate(); e < int64(n)*97/100 || e > int64(n)*103/100 {
+			t.Errorf("%d keys estimated as %d", n, e)
+		}
+		a.merge(&b)
+		if e := a.estimate(); e < int64(n)*97/100 || e > int64(n)*103/100 {
+			t.Errorf("%d keys estimated as %d once merged", n, e)
+		}
+	}
+	var empty keySketch
+	if e := empty.estimate(); e != 0 {
+		t.Errorf("empty sketch estimated as %d", e)
+	}
+}
+
+func TestBoundedKeyspace(t *testing.T) {
+	mr, _ := newTestServer(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "3", "-ops", "100", "-keyspace", "10", "-key-size", "64")
+	if cfg.preload != 0 {
+		t.Errorf("the set workload preloads %d keys", cfg.preload)
+	}
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := mr.Keys()
+	if len(keys) != 10 {
+		t.Fatalf("300 SETs over a keyspace of 10 wrote %d keys", len(keys))
+	}
+	for _, key := range keys {
+		if len(key) != 64 || !strings.HasPrefix(key, cfg.keyPrefix+"key") {
+			t.Errorf("key %q of %d bytes", key, len(key))
+		}
+	}
+	rep := buildReport(cfg, res)
+	if rep.KeysTouched != 10 || rep.Config.Keyspace != 10 || rep.Config.KeySize != 64 {
+		t.Errorf("%d of %d keys touched, key size %d", rep.KeysTouched, rep.Config.Keyspace, rep.Config.KeySize)
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "Keyspace: 10 keys, distribution: uniform, about 10 distinct keys touched") {
+		t.Errorf("summary lacks the keyspace:\n%s", buf.String())
+	}
+
+	// A later run reads the same keys whatever its clients.
+	get := testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-ops", "20", "-workload", "get",
+		"-keyspace", "10", "-key-size", "64", "-key-prefix", cfg.keyPrefix)
+	if res, err = runTarget(context.Background(), get); err != nil {
+		t.Fatal(err)
+	}
+	if s := res.total.ops[opGet]; s.hits != 40 {
+		t.Errorf("%d of 40 GETs hit the keys set", s.hits)
+	}
+}
+
+func TestKeySizeFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-key-size", "-1"},
+		{"-key-size", "10", "-key-prefix", "bench:"},
+		{"-key-size", "64", "-workload", "queue"},
+		{"-keyspace", "10", "-ttl", "1s", "-verify-expiry"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	// Fresh keys are padded too.
+	cfg := testConfig(t, "-key-size", "80", "-key-prefix", "bench:")
+	if key := cfg.uniqueKey(1, 7); len(key) != 80 || !strings.HasPrefix(key, "bench:client1-key7_") {
+		t.Errorf("fresh key %q", key)
+	}
+	if cfg.usesKeyspace() {
+		t.Error("the set workload uses a keyspace without -keyspace")
+	}
+}
@@ -0,0 +1,328 @@
+package loadgen
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-benchmark/stats"
+
+	"go-benchmark/workload"
+)
+
+const (
+	// largeValueUnit is the unit of -large-values and -memory-budget.
+	largeValueUnit = 1 << 20
+	// largeTimeoutBase and largeMinRate scale the operation timeout of
+	// -large-values without -op-timeout: the base plus the time the largest
+	// value takes at the slowest transfer allowed for, in bytes per second.
+	largeTimeoutBase = 5 * time.Second
+	largeMinRate     = 10 << 20
+	// largePoolSeed seeds the value pool of -large-values whatever -seed,
+	// so the value of a key is the same in every run reading it.
+	largePoolSeed = 0x6c61726765
+)
+
+// largeWrongSize is the kind of a large GET reply that is neither the value
+// of its key nor a prefix of it, and not as long as the value either.
+const largeWrongSize = "wrong size"
+
+// largeValueOps are the commands of a -large-values run.
+var largeValueOps = map[opType]bool{opSet: true, opGet: true, opDel: true}
+
+// largeValues are the sizes of -large-values and what fitting them into
+// -memory-budget did to the run.
+type largeValues struct {
+	min, max int
+	// requested is the number of clients lowered to fit the budget, 0 when
+	// none were.
+	requested int
+	// overBudget is set when the clients of the run may hold more than the
+	// budget in flight.
+	overBudget bool
+	// scaledTimeout is set when the operation timeout was derived from the
+	// value size rather than set with -op-timeout.
+	scaledTimeout bool
+}
+
+// parseLargeValues parses -large-values, a size or a min:max range in MiB.
+func parseLargeValues(s string) (int, int, error) {
+	if !strings.Contains(s, ":") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid size %q: want MiB or min:max", s)
+		}
+		s = fmt.Sprintf("%d:%d", n, n)
+	}
+	min, max, err := workload.ParseSizeRange(s)
+	if err != nil {
+		return 0, 0, err
+	}
+	if min < 1 {
+		return 0, 0, fmt.Errorf("invalid size range %q: values must be at least 1 MiB", s)
+	}
+	return min * largeValueUnit, max * largeValueUnit, nil
+}
+
+// validateLargeValues checks -large-values and sets up its run: one value
+// pool covers the largest value, clients are lowered to what -memory-budget
+// holds in flight unless -clients was set, and without -op-timeout
+// operations get a timeout scaled to the largest value.
+func (c *config) validateLargeValues() error {
+	if c.largeValuesSpec == "" {
+		if _, ok := c.explicit["memory-budget"]; ok {
+			return errors.New("-memory-budget requires -large-values")
+		}
+		return nil
+	}
+	min, max, err := parseLargeValues(c.largeValuesSpec)
+	if err != nil {
+		return fmt.Errorf("-large-values: %w", err)
+	}
+	if c.workload != workloadSet {
+		if c.mix == nil {
+			return fmt.Errorf("-large-values does not apply to the %s workload", c.workload)
+		}
+		for _, op := range c.mix.ops {
+			if !largeValueOps[op] {
+				return fmt.Errorf("-large-values runs SET, GET and DEL, not %s", opNames[op])
+			}
+		}
+	}
+	switch {
+	case c.valueSize != 0 || c.valueSizeRange != "" || len(c.sweepSizes) > 0:
+		return errors.New("-large-values replaces -value-size, -value-size-range and -sweep-value-size")
+	case c.verify || c.verifyFinal:
+		return errors.New("-large-values checks every GET itself and cannot be combined with -verify or -verify-final, which copy values")
+	case c.recordPath != "" || c.trace != nil:
+		return errors.New("-large-values cannot be combined with -record or -replay")
+	case c.memoryBudget <= 0:
+		return fmt.Errorf("-memory-budget must be positive, got %d", c.memoryBudget)
+	}
+	c.large = &largeValues{min: min, max: max}
+	// A client holds a pipeline of values in flight, each up to the
+	// largest.
+	perClient := int64(c.pipeline) * int64(max)
+	budget := int64(c.memoryBudget) * largeValueUnit
+	if int64(c.clients)*perClient > budget {
+		if _, ok := c.explicit["clients"]; !ok && budget >= perClient {
+			c.large.requested = c.clients
+			c.clients = int(budget / perClient)
+		} else {
+			c.large.overBudget = true
+		}
+	}
+	if _, ok := c.explicit["op-timeout"]; !ok {
+		c.opTimeout = largeTimeoutBase + time.Duration(max)*time.Second/largeMinRate
+		c.large.scaledTimeout = true
+	}
+	c.values = workload.NewValuePool(min, max, rand.New(rand.NewSource(largePoolSeed)))
+	return nil
+}
+
+// largeValue returns the value of key, a slice of the value pool whose size
+// and offset follow from the key. Every write of a key, in any run with the
+// same sizes, stores the same bytes, so a GET knows what it must read
+// without the run keeping what it wrote, and the pool is the only copy of
+// any value in memory.
+func (c *config) largeValue(key string) []byte {
+	h := mix64(hashKey(key))
+	size := c.large.min + int(h%uint64(c.large.max-c.large.min+1))
+	off := int(mix64(h) % uint64(len(c.values.Bytes())-size+1))
+	return c.values.Bytes()[off : off+size]
+}
+
+// largeReplyKind describes how got, the reply of a GET through go-redis,
+// differs from want, the value of its key: cut short, of another size, or
+// of the right size with wrong bytes.
+func largeReplyKind(got string, want []byte) string {
+	switch {
+	case len(got) < len(want) && got == string(want[:len(got)]):
+		return valueTruncated
+	case len(got) != len(want):
+		return largeWrongSize
+	case got != string(want):
+		return valueCorruptFiller
+	}
+	return valueIntact
+}
+
+// largeBytesKind is largeReplyKind for the reply of the raw client, which
+// reads into a buffer of its own.
+func largeBytesKind(got, want []byte) string {
+	switch {
+	case len(got) < len(want) && bytes.HasPrefix(want, got):
+		return valueTruncated
+	case len(got) != len(want):
+		return largeWrongSize
+	case !bytes.Equal(got, want):
+		return valueCorruptFiller
+	}
+	return valueIntact
+}
+
+// largeStats count the large GET replies read and how they were wrong, and
+// split the GETs of the raw client at the first byte of their reply.
+type largeStats struct {
+	checked   int64
+	truncated int64
+	wrongSize int64
+	corrupt   int64
+	readBytes int64
+	// ttfb is the time from sending a GET to the first byte of its reply,
+	// and transfer the time from there to the last; both are nil without
+	// -client raw, as go-redis returns replies whole.
+	ttfb     *stats.Histogram
+	transfer *stats.Histogram
+}
+
+func (s *largeStats) merge(o *largeStats) {
+	s.checked += o.checked
+	s.truncated += o.truncated
+	s.wrongSize += o.wrongSize
+	s.corrupt += o.corrupt
+	s.readBytes += o.readBytes
+	s.ttfb = mergeHistogram(s.ttfb, o.ttfb)
+	s.transfer = mergeHistogram(s.transfer, o.transfer)
+}
+
+// failed reports whether a large GET read a value other than its key's.
+func (s *largeStats) failed() bool {
+	return s != nil && s.truncated+s.wrongSize+s.corrupt > 0
+}
+
+// largeStats returns the large value statistics of r, allocating them on
+// first use.
+func (r *workerResult) largeStats() *largeStats {
+	if r.large == nil {
+		r.large = &largeStats{}
+	}
+	return r.large
+}
+
+// checkLarge counts a GET reply of n bytes of the kind largeReplyKind or
+// largeBytesKind found.
+func (w *worker) checkLarge(kind string, n int) {
+	s := w.result.largeStats()
+	s.checked++
+	s.readBytes += int64(n)
+	switch kind {
+	case valueTruncated:
+		s.truncated++
+	case largeWrongSize:
+		s.wrongSize++
+	case valueCorruptFiller:
+		s.corrupt++
+	}
+}
+
+// recordFirstByte splits a GET of the raw client sent at start and read
+// completely at end at firstByte, when its reply started to arrive.
+func (w *worker) recordFirstByte(firstByte, start, end time.Time) {
+	if firstByte.Before(start) {
+		return
+	}
+	s := w.result.largeStats()
+	if s.ttfb == nil {
+		s.ttfb, s.transfer = w.result.layout.New(), w.result.layout.New()
+	}
+	s.ttfb.Record(firstByte.Sub(start))
+	s.transfer.Record(end.Sub(firstByte))
+}
+
+// jsonLargeValues describes a -large-values run: its sizes and clients,
+// the throughput of its reads and writes, the GET replies checked and,
+// with -client raw, the split of GETs at their first byte.
+type jsonLargeValues struct {
+	MinBytes          int             `json:"min_bytes"`
+	MaxBytes          int             `json:"max_bytes"`
+	MemoryBudgetBytes int64           `json:"memory_budget_bytes"`
+	Clients           int             `json:"clients"`
+	ClientsRequested  int             `json:"clients_requested,omitempty"`
+	OverBudget        bool            `json:"over_budget"`
+	OpTimeout         string          `json:"op_timeout,omitempty"`
+	TimeoutScaled     bool            `json:"op_timeout_scaled"`
+	BytesRead         int64           `json:"bytes_read"`
+	ReadMBPerSec      float64         `json:"read_mb_per_sec"`
+	WriteMBPerSec     float64         `json:"write_mb_per_sec"`
+	Checked           int64           `json:"gets_checked"`
+	Truncated         int64           `json:"gets_truncated"`
+	WrongSize         int64           `json:"gets_wrong_size"`
+	Corrupt           int64           `json:"gets_corrupt"`
+	TimeToFirstByte   *latencySummary `json:"time_to_first_byte,omitempty"`
+	Transfer          *latencySummary `json:"transfer,omitempty"`
+}
+
+func buildLargeValues(cfg *config, res *runResult) *jsonLargeValues {
+	l, s := cfg.large, res.total.largeStats()
+	j := &jsonLargeValues{
+		MinBytes:          l.min,
+		MaxBytes:          l.max,
+		MemoryBudgetBytes: int64(cfg.memoryBudget) * largeValueUnit,
+		Clients:           cfg.clients,
+		ClientsRequested:  l.requested,
+		OverBudget:        l.overBudget,
+		TimeoutScaled:     l.scaledTimeout,
+		BytesRead:         s.readBytes,
+		ReadMBPerSec:      megabytesPerSecond(s.readBytes, res.elapsed()),
+		WriteMBPerSec:     megabytesPerSecond(res.total.bytesWritten, res.elapsed()),
+		Checked:           s.checked,
+		Truncated:         s.truncated,
+		WrongSize:         s.wrongSize,
+		Corrupt:           s.corrupt,
+		TimeToFirstByte:   summarizeLatency(s.ttfb),
+		Transfer:          summarizeLatency(s.transfer),
+	}
+	if cfg.opTimeout > 0 {
+		j.OpTimeout = cfg.opTimeout.String()
+	}
+	return j
+}
+
+// printLargeValues writes the -large-values section of the summary.
+func printLargeValues(w io.Writer, cfg *config, res *runResult) {
+	if cfg.large == nil {
+		return
+	}
+	j := buildLargeValues(cfg, res)
+	fmt.Fprintf(w, "Large values: %d-%d MiB, %d clients", j.MinBytes/largeValueUnit, j.MaxBytes/largeValueUnit, j.Clients)
+	if j.ClientsRequested > 0 {
+		fmt.Fprintf(w, " (lowered from %d to fit -memory-budget %d MiB)", j.ClientsRequested, cfg.memoryBudget)
+	}
+	switch {
+	case j.TimeoutScaled:
+		fmt.Fprintf(w, ", operation timeout %v (scaled to the value size)\n", cfg.opTimeout)
+	case cfg.opTimeout > 0:
+		fmt.Fprintf(w, ", operation timeout %v\n", cfg.opTimeout)
+	default:
+		fmt.Fprintln(w, ", no operation timeout")
+	}
+	if j.OverBudget {
+		fmt.Fprintf(w, "WARNING: %d clients with up to %d values of %d MiB in flight each may hold %d MiB, over -memory-budget %d MiB\n",
+			j.Clients, cfg.pipeline, j.MaxBytes/largeValueUnit, int64(j.Clients)*int64(cfg.pipeline)*int64(j.MaxBytes/largeValueUnit), cfg.memoryBudget)
+	}
+	if j.BytesRead > 0 {
+		fmt.Fprintf(w, "Bytes read: %d (%.2f MB/s)\n", j.BytesRead, j.ReadMBPerSec)
+	}
+	switch t := j.TimeToFirstByte; {
+	case t != nil:
+		fmt.Fprintf(w, "GET time to first byte: p50 %v, p99 %v; transfer after it: p50 %v, p99 %v\n",
+			time.Duration(t.P50Ns), time.Duration(t.P99Ns), time.Duration(j.Transfer.P50Ns), time.Duration(j.Transfer.P99Ns))
+	case j.Checked > 0:
+		fmt.Fprintln(w, "GET time to first byte: not measurable through go-redis, which returns replies whole; use -client raw")
+	}
+	switch {
+	case j.Checked == 0:
+		fmt.Fprintln(w, "Large GETs: none read a value")
+	case j.Truncated+j.WrongSize+j.Corrupt > 0:
+		fmt.Fprintf(w, "CORRUPTION: of %d large GETs, %d were cut short, %d had the wrong size and %d held wrong bytes\n",
+			j.Checked, j.Truncated, j.WrongSize, j.Corrupt)
+	default:
+		fmt.Fprintf(w, "Large GETs: %d read, all complete and intact\n", j.Checked)
+	}
+}
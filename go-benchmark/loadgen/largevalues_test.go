@@ -0,0 +1,100 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLargeValuesFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-large-values", "0:2"},
+		{"-large-values", "2:1"},
+		{"-large-values", "a"},
+		{"-memory-budget", "64"},
+		{"-large-values", "1", "-memory-budget", "0"},
+		{"-large-values", "1", "-value-size", "100"},
+		{"-large-values", "1", "-workload", "hash"},
+		{"-large-values", "1", "-workload", "queue"},
+		{"-large-values", "1", "-verify"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+
+	// Without -clients the run fits the budget; with it, it only warns.
+	cfg := testConfig(t, "-large-values", "1:2", "-memory-budget", "9")
+	if cfg.clients != 4 || cfg.large.requested != 1000 || cfg.large.overBudget {
+		t.Errorf("%d clients of %d, over budget %v", cfg.clients, cfg.large.requested, cfg.large.overBudget)
+	}
+	if want := largeTimeoutBase + 200*time.Millisecond; cfg.opTimeout != want || !cfg.large.scaledTimeout {
+		t.Errorf("operation timeout %v, want %v", cfg.opTimeout, want)
+	}
+	if opt := cfg.clientOptions(); opt.ReadTimeout != cfg.opTimeout || opt.WriteTimeout != cfg.opTimeout {
+		t.Errorf("read timeout %v, write timeout %v", opt.ReadTimeout, opt.WriteTimeout)
+	}
+	cfg = testConfig(t, "-large-values", "2", "-memory-budget", "9", "-clients", "8", "-op-timeout", "1s")
+	if cfg.clients != 8 || !cfg.large.overBudget || cfg.opTimeout != time.Second || cfg.large.scaledTimeout {
+		t.Errorf("%d clients, over budget %v, timeout %v", cfg.clients, cfg.large.overBudget, cfg.opTimeout)
+	}
+
+	// The value of a key is the same every time, within the sizes.
+	key := cfg.keyName(3)
+	v := cfg.largeValue(key)
+	if len(v) != 2<<20 || !bytes.Equal(v, cfg.structure(nil, key, 0, 0)) {
+		t.Errorf("value of %d bytes", len(v))
+	}
+	for kind, got := range map[string][]byte{
+		valueIntact:        v,
+		valueTruncated:     v[:1000],
+		largeWrongSize:     append(bytes.Clone(v), 'x'),
+		valueCorruptFiller: append(bytes.Clone(v[1:]), 0),
+	} {
+		if k := largeBytesKind(got, v); k != kind {
+			t.Errorf("%s reply classified as %q", kind, k)
+		}
+		if k := largeReplyKind(string(got), v); k != kind {
+			t.Errorf("%s string reply classified as %q", kind, k)
+		}
+	}
+}
+
+func TestLargeValuesRun(t *testing.T) {
+	mr, rdb := newTestServer(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-large-values", "1:2", "-clients", "2", "-ops", "4",
+		"-ratio", "set=0.5,get=0.5", "-preload", "4")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := res.total.largeStats()
+	if s.checked != res.total.ops[opGet].hits || s.checked == 0 || s.failed() || s.ttfb != nil {
+		t.Errorf("large GETs %+v of %d hits", s, res.total.ops[opGet].hits)
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	for _, want := range []string{"Large values: 1-2 MiB, 2 clients, operation timeout", "all complete and intact", "not measurable through go-redis"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, buf.String())
+		}
+	}
+
+	// A value cut short is corruption, and the raw client times the first
+	// byte.
+	key := cfg.keyName(0)
+	mr.Set(key, string(cfg.largeValue(key)[:1<<19]))
+	raw := testConfig(t, "-addr", mr.Addr(), "-large-values", "1:2", "-clients", "1", "-ops", "20",
+		"-workload", "get", "-preload", "4", "-keyspace", "1", "-client", "raw", "-key-prefix", cfg.keyPrefix)
+	res = runBenchmark(context.Background(), rdb, raw)
+	s = res.total.largeStats()
+	if s.checked != 20 || s.truncated != 20 || !res.verifyFailed() || s.ttfb.Count() != 20 {
+		t.Errorf("large GETs %+v", s)
+	}
+	rep := buildReport(raw, res)
+	if j := rep.LargeValues; j == nil || j.Truncated != 20 || j.TimeToFirstByte == nil || j.BytesRead != 20<<19 {
+		t.Errorf("large values report %+v", j)
+	}
+}
@@ -0,0 +1,86 @@
+package loadgen
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+// latencyAuto is the -latency-unit that picks the unit from the median.
+const latencyAuto = "auto"
+
+// latencyUnit is a unit the text report prints latencies in.
+type latencyUnit struct {
+	name   string
+	symbol string
+	d      time.Duration
+	// decimals keeps three significant digits of a value of the unit
+	// above 1.
+	decimals int
+}
+
+// latencyUnits are the units of -latency-unit, finest first.
+var latencyUnits = []latencyUnit{
+	{"ns", "ns", time.Nanosecond, 0},
+	{"us", "µs", time.Microsecond, 1},
+	{"ms", "ms", time.Millisecond, 3},
+	{"s", "s", time.Second, 3},
+}
+
+// findLatencyUnit returns the unit named name.
+func findLatencyUnit(name string) (latencyUnit, bool) {
+	for _, u := range latencyUnits {
+		if u.name == name {
+			return u, true
+		}
+	}
+	return latencyUnit{}, false
+}
+
+// format returns d in u, such as "85.3µs".
+func (u latencyUnit) format(d time.Duration) string {
+	return strconv.FormatFloat(u.value(d), 'f', u.decimals, 64) + u.symbol
+}
+
+// value returns d as a number of u.
+func (u latencyUnit) value(d time.Duration) float64 {
+	return float64(d) / float64(u.d)
+}
+
+// validateLatency checks -latency-unit and sets the layout of the run's
+// histograms from -histogram-max and -histogram-growth.
+func (c *config) validateLatency() error {
+	if _, ok := findLatencyUnit(c.latencyUnit); !ok && c.latencyUnit != latencyAuto {
+		return fmt.Errorf("-latency-unit must be %s, ns, us, ms or s, got %q", latencyAuto, c.latencyUnit)
+	}
+	if c.histogramMax < stats.MinHighest {
+		return fmt.Errorf("-histogram-max must be at least %v, got %v", stats.MinHighest, c.histogramMax)
+	}
+	if c.histogramGrowth < stats.MinGrowth || c.histogramGrowth > stats.MaxGrowth {
+		return fmt.Errorf("-histogram-growth must be between %v and %v, got %v", stats.MinGrowth, stats.MaxGrowth, c.histogramGrowth)
+	}
+	c.layout = stats.Layout{Highest: c.histogramMax, Growth: c.histogramGrowth}
+	return c.layout.Validate()
+}
+
+// displayUnit returns the unit of -latency-unit or, under auto, the
+// coarsest unit in which the median of h is at least 1.
+func (c *config) displayUnit(h *stats.Histogram) latencyUnit {
+	if u, ok := findLatencyUnit(c.latencyUnit); ok {
+		return u
+	}
+	if h == nil || h.Count() == 0 {
+		return latencyUnits[1]
+	}
+	median := h.Percentile(50)
+	u := latencyUnits[0]
+	for _, next := range latencyUnits[1:] {
+		if median < next.d {
+			break
+		}
+		u = next
+	}
+	return u
+}
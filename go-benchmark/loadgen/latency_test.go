@@ -0,0 +1,79 @@
+package loadgen
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+func TestDisplayUnit(t *testing.T) {
+	cfg := testConfig(t, "-clients", "1")
+	for _, tc := range []struct {
+		median time.Duration
+		want   string
+	}{
+		{400 * time.Nanosecond, "ns"},
+		{85 * time.Microsecond, "us"},
+		{999 * time.Microsecond, "us"},
+		{3 * time.Millisecond, "ms"},
+		{2 * time.Second, "s"},
+	} {
+		h := stats.NewHistogram()
+		h.Record(tc.median)
+		if got := cfg.displayUnit(h).name; got != tc.want {
+			t.Errorf("median %v: unit %s, want %s", tc.median, got, tc.want)
+		}
+	}
+	if got := cfg.displayUnit(stats.NewHistogram()).name; got != "us" {
+		t.Errorf("empty histogram: unit %s", got)
+	}
+	cfg = testConfig(t, "-clients", "1", "-latency-unit", "ms")
+	h := stats.NewHistogram()
+	h.Record(85 * time.Microsecond)
+	if u := cfg.displayUnit(h); u.name != "ms" || u.format(85300*time.Nanosecond) != "0.085ms" {
+		t.Errorf("-latency-unit ms: %s, %s", u.name, u.format(85300*time.Nanosecond))
+	}
+}
+
+func TestValidateLatency(t *testing.T) {
+	for _, args := range [][]string{
+		{"-latency-unit", "minutes"},
+		{"-histogram-max", "100ns"},
+		{"-histogram-growth", "1"},
+		{"-histogram-growth", "3"},
+	} {
+		if _, err := parseFlags(append(args, "-clients", "1")); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	cfg := testConfig(t, "-clients", "1", "-histogram-max", "10s", "-histogram-growth", "1.001")
+	if l := cfg.layout; l.Highest != 10*time.Second || l.Growth != 1.001 {
+		t.Errorf("layout %+v", l)
+	}
+	if h := newWorkerResult(cfg.layout).latency; h.Highest() != 10*time.Second {
+		t.Errorf("histogram highest %v", h.Highest())
+	}
+}
+
+func TestLatencyOverflowReported(t *testing.T) {
+	_, rdb := newTestServer(t)
+	cfg := testConfig(t, "-clients", "2", "-ops", "20", "-histogram-max", "1us", "-latency-unit", "us")
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	if n := res.total.latency.Overflow(); n == 0 {
+		t.Fatal("no operation overflowed a 1µs histogram")
+	}
+	var b strings.Builder
+	printSummary(&b, cfg, res)
+	if !strings.Contains(b.String(), "samples above -histogram-max 1µs") || !strings.Contains(b.String(), "µs\n") {
+		t.Errorf("summary:\n%s", b.String())
+	}
+	rep := buildReport(cfg, res)
+	if rep.Latency == nil || rep.Latency.Overflow != res.total.latency.Overflow() || rep.Config.LatencyUnit != "us" || rep.Config.HistogramMaxNs != 1000 {
+		t.Errorf("report latency %+v, config unit %s, max %d", rep.Latency, rep.Config.LatencyUnit, rep.Config.HistogramMaxNs)
+	}
+}
@@ -0,0 +1,90 @@
+package loadgen
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// liveHistogramBuckets covers the whole int64 nanosecond range with four
+// linear sub-buckets per power of two (about 25% relative resolution).
+const liveHistogramBuckets = 248
+
+// liveHistogram is a coarse latency histogram that many goroutines record
+// into concurrently with atomic adds. It is meant for observers that need a
+// view of latency while the run is in progress, such as the progress line;
+// the exact per-worker histograms remain the source of the final report.
+type liveHistogram struct {
+	counts [liveHistogramBuckets]atomic.Int64
+}
+
+func liveBucket(ns int64) int {
+	if ns < 4 {
+		if ns < 0 {
+			return 0
+		}
+		return int(ns)
+	}
+	n := bits.Len64(uint64(ns))
+	sub := (ns >> uint(n-3)) & 3
+	return (n-2)*4 + int(sub)
+}
+
+// liveBucketUpper returns the largest value that maps to bucket idx.
+func liveBucketUpper(idx int) int64 {
+	if idx < 4 {
+		return int64(idx)
+	}
+	n := idx/4 + 2
+	sub := int64(idx % 4)
+	return (5+sub)<<uint(n-3) - 1
+}
+
+func (h *liveHistogram) record(d time.Duration) {
+	h.counts[liveBucket(int64(d))].Add(1)
+}
+
+// liveSnapshot is a point-in-time copy of a liveHistogram. Subtracting two
+// snapshots yields the distribution of the samples recorded in between.
+type liveSnapshot [liveHistogramBuckets]int64
+
+func (h *liveHistogram) snapshot() *liveSnapshot {
+	var s liveSnapshot
+	for i := range h.counts {
+		s[i] = h.counts[i].Load()
+	}
+	return &s
+}
+
+// since returns the samples recorded between prev and s.
+func (s *liveSnapshot) since(prev *liveSnapshot) *liveSnapshot {
+	var d liveSnapshot
+	for i := range s {
+		d[i] = s[i] - prev[i]
+	}
+	return &d
+}
+
+// percentile returns the upper bound of the bucket holding the p-th
+// percentile, or 0 when the snapshot is empty.
+func (s *liveSnapshot) percentile(p float64) time.Duration {
+	var total int64
+	for _, c := range s {
+		total += c
+	}
+	if total == 0 {
+		return 0
+	}
+	target := int64(p / 100 * float64(total))
+	if target < 1 {
+		target = 1
+	}
+	var seen int64
+	for i, c := range s {
+		seen += c
+		if seen >= target {
+			return time.Duration(liveBucketUpper(i))
+		}
+	}
+	return time.Duration(liveBucketUpper(liveHistogramBuckets - 1))
+}
@@ -0,0 +1,35 @@
+package loadgen
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLiveBucketBounds(t *testing.T) {
+	for _, v := range []int64{0, 1, 3, 4, 7, 8, 1000, 123456789, 1 << 40} {
+		idx := liveBucket(v)
+		if idx < 0 || idx >= liveHistogramBuckets {
+			t.Fatalf("liveBucket(%d) = %d out of range", v, idx)
+		}
+		if up := liveBucketUpper(idx); up < v || (idx > 0 && liveBucketUpper(idx-1) >= v) {
+			t.Errorf("value %d not within bucket %d (upper %d)", v, idx, up)
+		}
+	}
+}
+
+func TestLiveSnapshotWindow(t *testing.T) {
+	var h liveHistogram
+	for i := 0; i < 100; i++ {
+		h.record(time.Millisecond)
+	}
+	before := h.snapshot()
+	for i := 0; i < 100; i++ {
+		h.record(10 * time.Millisecond)
+	}
+	window := h.snapshot().since(before)
+
+	p99 := window.percentile(99)
+	if p99 < 10*time.Millisecond || p99 > 13*time.Millisecond {
+		t.Errorf("window p99 = %v, want ~10ms", p99)
+	}
+}
@@ -0,0 +1,238 @@
+package loadgen
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Two runs against one server skew each other's numbers without either
+// noticing. Before it starts, a run takes a cooperative lock on the server:
+// it sets runLockKey, naming who holds it, if no other run has, and refuses
+// to start if one has, unless -force. The lock outlives the expected length
+// of the run by runLockMargin, is extended while the run goes on and is
+// deleted at the end, interrupted runs included; one killed outright leaves
+// it to expire.
+
+const (
+	// runLockKey is the key of the run lock, outside any key prefix.
+	runLockKey = "__htcache_bench_lock__"
+	// runLockMargin is how long the lock outlives the expected end of the
+	// run, and runLockRefresh how often it is extended.
+	runLockMargin  = time.Minute
+	runLockRefresh = 15 * time.Second
+	// busyClients and busyOps are the connections, besides that of the
+	// check, and operations per second above which a server looks busy
+	// before the run starts.
+	busyClients = 10
+	busyOps     = 100
+)
+
+// runLockRenew extends the lock if it still holds the value of the run,
+// whose token no other run shares; runLockRelease deletes it under the same
+// condition.
+var (
+	runLockRenew = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	runLockRelease = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// runLockHolder is the value of the run lock: who holds it, and the token
+// that tells its own lock from another's.
+type runLockHolder struct {
+	Host    string    `json:"host"`
+	User    string    `json:"user"`
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+	Token   string    `json:"token"`
+}
+
+func (h runLockHolder) String() string {
+	return fmt.Sprintf("%s@%s (pid %d), since %s", h.User, h.Host, h.PID, h.Started.Format(time.RFC3339))
+}
+
+func newRunLockHolder() runLockHolder {
+	h := runLockHolder{User: os.Getenv("USER"), PID: os.Getpid(), Started: time.Now()}
+	h.Host, _ = os.Hostname()
+	if u, err := user.Current(); err == nil {
+		h.User = u.Username
+	}
+	token := make([]byte, 8)
+	_, _ = rand.Read(token)
+	h.Token = hex.EncodeToString(token)
+	return h
+}
+
+// expectedLength is how long a run of c is expected to last, 0 when it
+// runs to a count of operations.
+func (c *config) expectedLength() time.Duration {
+	if c.duration == 0 {
+		return 0
+	}
+	return c.warmup + c.rampUp + c.duration
+}
+
+// runLock is the lock a run holds on one server.
+type runLock struct {
+	rdb    redis.UniversalClient
+	logger *slog.Logger
+	addr   string
+	holder runLockHolder
+	value  string
+	end    time.Time
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// runLocks are the locks of a run, one per target.
+type runLocks []*runLock
+
+// acquireRunLocks checks that the targets of cfg look idle and takes their
+// run locks. It fails when another run holds one, unless -force, under
+// which the run goes on without taking it. A server that cannot be locked,
+// whether unreachable or refusing the command, is warned of and left to the
+// run to report.
+func acquireRunLocks(rootCtx context.Context, cfg *config) (runLocks, error) {
+	targets := []*config{cfg}
+	if cfg.addr2 != "" {
+		targets = append(targets, cfg.secondTarget())
+	}
+	var locks runLocks
+	for _, t := range targets {
+		if t.protocol != protocolRedis {
+			continue
+		}
+		l, err := acquireRunLock(rootCtx, t)
+		if err != nil {
+			locks.release()
+			return nil, err
+		}
+		if l != nil {
+			locks = append(locks, l)
+		}
+	}
+	return locks, nil
+}
+
+func acquireRunLock(rootCtx context.Context, cfg *config) (*runLock, error) {
+	own := *cfg
+	own.poolSize = 1
+	rdb, _ := newClient(&own)
+	checkServerBusy(rootCtx, cfg.logger, rdb, cfg.addr)
+
+	l := &runLock{rdb: rdb, logger: cfg.logger, addr: cfg.addr, holder: newRunLockHolder(), stop: make(chan struct{}), done: make(chan struct{})}
+	value, _ := json.Marshal(l.holder)
+	l.value = string(value)
+	l.end = l.holder.Started.Add(cfg.expectedLength())
+	ok, err := rdb.SetNX(rootCtx, runLockKey, l.value, cfg.expectedLength()+runLockMargin).Result()
+	if err != nil {
+		cfg.logger.Warn("cannot take the run lock: running without it", "target", cfg.addr, "err", err)
+		rdb.Close()
+		return nil, nil
+	}
+	if !ok {
+		held := describeRunLock(rootCtx, rdb)
+		rdb.Close()
+		if !cfg.force {
+			return nil, fmt.Errorf("%s: another run holds the run lock %s: %s; wait for it to end or pass -force", cfg.addr, runLockKey, held)
+		}
+		cfg.logger.Warn("another run holds the run lock: running anyway (-force)", "target", cfg.addr, "holder", held)
+		return nil, nil
+	}
+	cfg.logger.Debug("took the run lock", "target", cfg.addr, "key", runLockKey)
+	go l.refresh()
+	return l, nil
+}
+
+// describeRunLock returns who holds the run lock of rdb, as well as its
+// value tells.
+func describeRunLock(rootCtx context.Context, rdb redis.UniversalClient) string {
+	value, err := rdb.Get(rootCtx, runLockKey).Result()
+	if err != nil {
+		return "unknown holder"
+	}
+	var h runLockHolder
+	if json.Unmarshal([]byte(value), &h) != nil || h.Host == "" {
+		return strconv.Quote(value)
+	}
+	return h.String()
+}
+
+// refresh extends the lock every runLockRefresh until the run ends, and
+// takes it again when it is gone, as a FLUSHDB of -cleanup or
+// -sweep-flush deletes it.
+func (l *runLock) refresh() {
+	defer close(l.done)
+	t := time.NewTicker(runLockRefresh)
+	defer t.Stop()
+	lost := false
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-t.C:
+		}
+		ttl := max(time.Until(l.end), 0) + runLockMargin
+		n, err := runLockRenew.Run(context.Background(), l.rdb, []string{runLockKey}, l.value, ttl.Milliseconds()).Int()
+		if err == nil && n == 0 {
+			if ok, _ := l.rdb.SetNX(context.Background(), runLockKey, l.value, ttl).Result(); ok {
+				n = 1
+			}
+		}
+		switch {
+		case err != nil:
+			l.logger.Warn("cannot extend the run lock", "target", l.addr, "err", err)
+		case n == 0 && !lost:
+			lost = true
+			l.logger.Warn("another run took the run lock", "target", l.addr, "holder", describeRunLock(context.Background(), l.rdb))
+		}
+	}
+}
+
+// release stops extending the locks and deletes them, if they still are
+// those of the run. It uses the uncancelled context, so an interrupted run
+// releases them too.
+func (locks runLocks) release() {
+	for _, l := range locks {
+		close(l.stop)
+		<-l.done
+		if err := runLockRelease.Run(context.Background(), l.rdb, []string{runLockKey}, l.value).Err(); err != nil {
+			l.logger.Warn("cannot release the run lock: it expires on its own", "target", l.addr, "err", err)
+		}
+		l.rdb.Close()
+	}
+}
+
+// checkServerBusy warns when the server already serves other clients or
+// traffic before the run starts, telling of INFO's connected_clients and
+// instantaneous_ops_per_sec. A server without INFO is not checked.
+func checkServerBusy(rootCtx context.Context, logger *slog.Logger, rdb redis.UniversalClient, addr string) {
+	info, err := rdb.Info(rootCtx).Result()
+	if err != nil {
+		return
+	}
+	fields := parseInfo(info)
+	clients, errClients := strconv.Atoi(fields["connected_clients"])
+	ops, errOps := strconv.ParseFloat(fields["instantaneous_ops_per_sec"], 64)
+	// The connection of the check is one of the clients.
+	if (errClients == nil && clients-1 > busyClients) || (errOps == nil && ops > busyOps) {
+		logger.Warn("the server already looks busy: other clients may skew the results", "target", addr,
+			"connected_clients", fields["connected_clients"], "instantaneous_ops_per_sec", fields["instantaneous_ops_per_sec"])
+	}
+}
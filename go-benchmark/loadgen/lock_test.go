@@ -0,0 +1,95 @@
+package loadgen
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRunLockRefusesASecondRun(t *testing.T) {
+	mr, _ := newTestServer(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "1", "-duration", "2m")
+
+	first, err := acquireRunLocks(context.Background(), cfg)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("first run: %d locks, %v", len(first), err)
+	}
+	if ttl := mr.TTL(runLockKey); ttl != 2*time.Minute+runLockMargin {
+		t.Errorf("lock TTL %v, want the run and %v", ttl, runLockMargin)
+	}
+
+	_, err = acquireRunLocks(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "another run holds the run lock") || !strings.Contains(err.Error(), first[0].holder.String()) {
+		t.Fatalf("second run: %v", err)
+	}
+
+	forced := testConfig(t, "-addr", mr.Addr(), "-clients", "1", "-force")
+	logs := captureLogs(t, forced)
+	if locks, err := acquireRunLocks(context.Background(), forced); err != nil || len(locks) != 0 {
+		t.Fatalf("forced run: %d locks, %v", len(locks), err)
+	}
+	if !strings.Contains(logs.String(), "running anyway (-force)") {
+		t.Errorf("forced run logged:\n%s", logs.String())
+	}
+
+	first.release()
+	if mr.Exists(runLockKey) {
+		t.Error("the lock outlived its run")
+	}
+}
+
+func TestRunLockKeepsAnotherRunsLock(t *testing.T) {
+	mr, rdb := newTestServer(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "1")
+	locks, err := acquireRunLocks(context.Background(), cfg)
+	if err != nil || len(locks) != 1 {
+		t.Fatalf("%d locks, %v", len(locks), err)
+	}
+	value := locks[0].value
+
+	// Extending the lock sets its TTL.
+	if n, err := runLockRenew.Run(context.Background(), rdb, []string{runLockKey}, value, (2 * time.Minute).Milliseconds()).Int(); err != nil || n != 1 {
+		t.Fatalf("renew: %d, %v", n, err)
+	}
+	if ttl := mr.TTL(runLockKey); ttl != 2*time.Minute {
+		t.Errorf("renewed TTL %v", ttl)
+	}
+
+	// Once the lock expired and another run took it, it is left alone.
+	mr.Set(runLockKey, "other")
+	if n, _ := runLockRenew.Run(context.Background(), rdb, []string{runLockKey}, value, 1000).Int(); n != 0 {
+		t.Error("renewed the lock of another run")
+	}
+	locks.release()
+	if v, _ := mr.Get(runLockKey); v != "other" {
+		t.Errorf("the lock of another run became %q", v)
+	}
+	if got := describeRunLock(context.Background(), rdb); got != `"other"` {
+		t.Errorf("holder %s", got)
+	}
+}
+
+func TestCheckServerBusy(t *testing.T) {
+	mr, rdb := newTestServer(t)
+	cfg := testConfig(t)
+	logs := captureLogs(t, cfg)
+	checkServerBusy(context.Background(), cfg.logger, rdb, mr.Addr())
+	if logs.Len() != 0 {
+		t.Errorf("idle server logged:\n%s", logs.String())
+	}
+
+	for i := 0; i < busyClients+1; i++ {
+		c := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		defer c.Close()
+		if err := c.Ping(context.Background()).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkServerBusy(context.Background(), cfg.logger, rdb, mr.Addr())
+	if !strings.Contains(logs.String(), "the server already looks busy") {
+		t.Errorf("busy server logged:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,207 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+// maxLockConflicts bounds the double-holder cases kept for the report; all
+// of them are counted.
+const maxLockConflicts = 10
+
+// lockName returns the key of lock i of the -lock-keys pool.
+func (c *config) lockName(i int) string {
+	return c.keyPrefix + "lock" + strconv.Itoa(i)
+}
+
+// lockAttempt is the lock a worker is trying to acquire. A rejected SETNX is
+// retried on the same lock until it succeeds, so the time to acquire spans
+// every attempt.
+type lockAttempt struct {
+	want  int
+	since time.Time
+	// tokens numbers the acquisition attempts so every SETNX writes a value
+	// no other attempt writes.
+	tokens int
+}
+
+// lockStats describes the acquisitions of the setnx workload. Acquisitions
+// and rejections are the hits and misses of the SETNX opStats.
+type lockStats struct {
+	// wait is the time from the first attempt on a lock until it was
+	// acquired.
+	wait          *stats.Histogram
+	releases      int64
+	releaseErrors int64
+	readErrors    int64
+	// expiredEarly counts locks already gone when read back, which means
+	// -lock-ttl is too short for the round trip.
+	expiredEarly int64
+}
+
+func (s *lockStats) merge(o *lockStats) {
+	s.wait.Merge(o.wait)
+	s.releases += o.releases
+	s.releaseErrors += o.releaseErrors
+	s.readErrors += o.readErrors
+	s.expiredEarly += o.expiredEarly
+}
+
+// lockConflict is an acquisition whose read-back found another holder's
+// value: two workers believed they held the lock at the same time.
+type lockConflict struct {
+	Key    string `json:"key"`
+	Client int    `json:"client"`
+	Token  string `json:"token"`
+	Found  string `json:"found"`
+}
+
+// lockStats returns the lock statistics of r, allocating them on first use.
+func (r *workerResult) lockStats() *lockStats {
+	if r.locks == nil {
+		r.locks = &lockStats{wait: r.layout.New()}
+	}
+	return r.locks
+}
+
+// issueSetNX tries to take the lock the worker is after, picking a new one
+// from the pool once the previous one was acquired.
+func (w *worker) issueSetNX(ctx context.Context, c redis.Cmdable) pendingOp {
+	cfg := w.run.cfg
+	l := &w.lock
+	if l.want < 0 {
+		l.want = w.rng.Intn(cfg.lockKeys)
+		l.since = time.Time{}
+	}
+	l.tokens++
+	key := cfg.lockName(l.want)
+	token := fmt.Sprintf("client%d-%d", w.id, l.tokens)
+	return pendingOp{op: opSetNX, cmd: c.SetNX(ctx, key, token, cfg.lockTTL), key: key, counter: l.want}
+}
+
+// afterSetNX follows an accounted SETNX. An acquired lock is read back to
+// check that the worker's own value is stored, then held for -lock-hold and
+// released with DEL unless -lock-release=false. Neither step is an operation
+// of the run: they are not timed and do not count towards -ops.
+func (w *worker) afterSetNX(runCtx context.Context, p pendingOp, start, end time.Time) {
+	cfg := w.run.cfg
+	l := &w.lock
+	if l.since.IsZero() {
+		l.since = start
+	}
+	cmd := p.cmd.(*redis.BoolCmd)
+	if cmd.Err() != nil || !cmd.Val() {
+		return
+	}
+	l.want = -1
+	stats := w.result.lockStats()
+	stats.wait.Record(end.Sub(l.since))
+
+	token := cmd.Args()[2].(string)
+	opCtx, cancel := w.opContext()
+	found, err := w.rdb.Get(opCtx, p.key).Result()
+	cancel()
+	switch {
+	case isMiss(err):
+		stats.expiredEarly++
+	case err != nil:
+		stats.readErrors++
+	case found != token:
+		// Kept across the warmup switch like the INCR counters: a second
+		// holder is a correctness failure whenever it happens.
+		w.conflicts++
+		if len(w.conflictLog) < maxLockConflicts {
+			w.conflictLog = append(w.conflictLog, lockConflict{Key: p.key, Client: w.id, Token: token, Found: found})
+		}
+	}
+
+	if cfg.lockHold > 0 {
+		(realClock{}).Sleep(runCtx, cfg.lockHold)
+	}
+	if cfg.lockRelease {
+		opCtx, cancel := w.opContext()
+		err := w.rdb.Del(opCtx, p.key).Err()
+		cancel()
+		if err != nil {
+			stats.releaseErrors++
+		} else {
+			stats.releases++
+		}
+	}
+}
+
+// jsonLocks describes the acquisitions of the setnx workload.
+type jsonLocks struct {
+	Keys                  int             `json:"keys"`
+	Acquired              int64           `json:"acquired"`
+	Rejected              int64           `json:"rejected"`
+	SuccessRatio          float64         `json:"success_ratio"`
+	TimeToAcquire         *latencySummary `json:"time_to_acquire"`
+	Releases              int64           `json:"releases"`
+	ReleaseErrors         int64           `json:"release_errors,omitempty"`
+	ReadbackErrors        int64           `json:"readback_errors,omitempty"`
+	ExpiredBeforeReadback int64           `json:"expired_before_readback,omitempty"`
+	DoubleHolders         int64           `json:"double_holders"`
+	Conflicts             []lockConflict  `json:"conflicts,omitempty"`
+}
+
+// buildLocks summarises the setnx workload of r, nil when it issued no
+// SETNX.
+func buildLocks(cfg *config, r *workerResult) *jsonLocks {
+	s := &r.ops[opSetNX]
+	if s.attempts() == 0 {
+		return nil
+	}
+	l := r.locks
+	if l == nil {
+		l = &lockStats{wait: r.layout.New()}
+	}
+	rep := &jsonLocks{
+		Keys:                  cfg.lockKeys,
+		Acquired:              s.hits,
+		Rejected:              s.misses,
+		TimeToAcquire:         summarizeLatency(l.wait),
+		Releases:              l.releases,
+		ReleaseErrors:         l.releaseErrors,
+		ReadbackErrors:        l.readErrors,
+		ExpiredBeforeReadback: l.expiredEarly,
+		DoubleHolders:         r.lockConflicts,
+		Conflicts:             r.lockConflictLog,
+	}
+	if n := s.hits + s.misses; n > 0 {
+		rep.SuccessRatio = float64(s.hits) / float64(n)
+	}
+	return rep
+}
+
+// printLocks writes the lock contention section of the summary.
+func printLocks(w io.Writer, u latencyUnit, cfg *config, r *workerResult) {
+	rep := buildLocks(cfg, r)
+	if rep == nil {
+		return
+	}
+	fmt.Fprintf(w, "Locks: %d keys, TTL %v, hold %v, release: %v\n", cfg.lockKeys, cfg.lockTTL, cfg.lockHold, cfg.lockRelease)
+	fmt.Fprintf(w, "Acquisitions: %d, rejections: %d, success ratio: %.2f%%\n", rep.Acquired, rep.Rejected, 100*rep.SuccessRatio)
+	if rep.ExpiredBeforeReadback > 0 || rep.ReadbackErrors > 0 || rep.ReleaseErrors > 0 {
+		fmt.Fprintf(w, "Lock expired before read-back: %d, read-back errors: %d, release errors: %d\n",
+			rep.ExpiredBeforeReadback, rep.ReadbackErrors, rep.ReleaseErrors)
+	}
+	if rep.DoubleHolders > 0 {
+		fmt.Fprintf(w, "WARNING: %d acquisitions read back another holder's value (two holders of one lock)\n", rep.DoubleHolders)
+		for _, c := range rep.Conflicts {
+			fmt.Fprintf(w, "  %s: client %d wrote %q, found %q\n", c.Key, c.Client, c.Token, c.Found)
+		}
+	} else {
+		fmt.Fprintln(w, "Double holders: none")
+	}
+	if r.locks != nil {
+		printLatency(w, u, "Time-to-acquire", r.locks.wait)
+	}
+}
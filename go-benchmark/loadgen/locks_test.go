@@ -0,0 +1,69 @@
+package loadgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestSetNXWorkload(t *testing.T) {
+	mr, rdb := newTestServer(t)
+	cfg := testConfig(t, "-workload", "setnx", "-clients", "8", "-ops", "50", "-lock-keys", "3")
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	s := &res.total.ops[opSetNX]
+	if s.hits == 0 || s.misses == 0 || s.hits+s.misses != 400 {
+		t.Errorf("%d acquired, %d rejected, want both of 400 attempts", s.hits, s.misses)
+	}
+	l := res.total.locks
+	if l == nil || l.wait.Count() != s.hits || l.releases != s.hits {
+		t.Errorf("lock stats = %+v, want %d waits and releases", l, s.hits)
+	}
+	if res.total.lockConflicts != 0 {
+		t.Errorf("%d double holders reported on a correct server", res.total.lockConflicts)
+	}
+	// Every acquired lock was released.
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("locks left behind: %v", keys)
+	}
+}
+
+// stolenLock is a client hook that makes every read-back find another
+// holder's value, as a server granting one lock twice would.
+type stolenLock struct{}
+
+func (stolenLock) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (stolenLock) AfterProcess(_ context.Context, cmd redis.Cmder) error {
+	if get, ok := cmd.(*redis.StringCmd); ok && cmd.Name() == "get" {
+		get.SetVal("intruder")
+	}
+	return nil
+}
+
+func (stolenLock) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (stolenLock) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+func TestSetNXDetectsDoubleHolder(t *testing.T) {
+	_, rdb := newTestServer(t)
+	rdb.AddHook(stolenLock{})
+	cfg := testConfig(t, "-workload", "setnx", "-clients", "2", "-ops", "20", "-lock-keys", "1")
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	acquired := res.total.ops[opSetNX].hits
+	if acquired == 0 || res.total.lockConflicts != acquired {
+		t.Errorf("%d double holders for %d acquisitions, want one each", res.total.lockConflicts, acquired)
+	}
+	rep := buildLocks(cfg, res.total)
+	if len(rep.Conflicts) == 0 || rep.Conflicts[0].Found != "intruder" {
+		t.Errorf("conflicts = %+v, want the intruder's value", rep.Conflicts)
+	}
+}
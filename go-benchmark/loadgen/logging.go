@@ -0,0 +1,150 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// discardLogger returns a logger that logs nothing.
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// errorLogInterval is the least time between two warnings about failed
+// operations; the failures in between are counted, not logged.
+const errorLogInterval = time.Second
+
+// logLevels are the values of -log-level.
+var logLevels = map[string]slog.Level{
+	"error": slog.LevelError,
+	"warn":  slog.LevelWarn,
+	"info":  slog.LevelInfo,
+	"debug": slog.LevelDebug,
+}
+
+// validateLogging parses -log-level and checks -quiet, which keeps nothing
+// but errors on stderr and the JSON report on stdout.
+func (c *config) validateLogging() error {
+	level, ok := logLevels[strings.ToLower(c.logLevelName)]
+	if !ok {
+		return fmt.Errorf("-log-level must be error, warn, info or debug, got %q", c.logLevelName)
+	}
+	c.logLevel = level
+	if c.logSample < 0 {
+		return errors.New("-log-sample must not be negative")
+	}
+	if !c.quiet {
+		return nil
+	}
+	if _, ok := c.explicit["log-level"]; ok {
+		return errors.New("-quiet cannot be combined with -log-level")
+	}
+	if c.progress {
+		return errors.New("-quiet cannot be combined with -progress")
+	}
+	if _, ok := c.explicit["output"]; ok && c.output != outputJSON {
+		return errors.New("-quiet prints the JSON report only: drop -output text")
+	}
+	c.output = outputJSON
+	c.logLevel = slog.LevelError
+	return nil
+}
+
+// newLogger returns the logger of cfg, writing to w.
+func newLogger(cfg *config, w io.Writer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: cfg.logLevel,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			// Wall-clock milliseconds line the logs up with the progress
+			// line; the date is noise for a run of minutes.
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				a.Value = slog.StringValue(a.Value.Time().Format("15:04:05.000"))
+			}
+			return a
+		},
+	}))
+}
+
+// logWriter returns c.logOut when c.logger prints messages of level, for
+// the multi-line reports printed beside the summary, and io.Discard
+// otherwise.
+func (c *config) logWriter(level slog.Level) io.Writer {
+	switch {
+	case !c.logger.Enabled(context.Background(), level):
+		return io.Discard
+	case c.logOut == nil:
+		return &lineLogger{logger: c.logger, level: level}
+	}
+	return c.logOut
+}
+
+// lineLogger logs each complete line written to it as a message of level.
+type lineLogger struct {
+	logger *slog.Logger
+	level  slog.Level
+	buf    []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		l.logger.Log(context.Background(), l.level, string(l.buf[:i]))
+		l.buf = l.buf[i+1:]
+	}
+}
+
+// errorLog turns the failed operations of a run into warnings, at most one
+// per errorLogInterval however many fail, so that a server refusing
+// connections shows without flooding the terminal.
+type errorLog struct {
+	logger *slog.Logger
+	// next is the earliest time, in Unix nanoseconds, of the next warning.
+	next atomic.Int64
+	// suppressed counts the failures since the last warning.
+	suppressed atomic.Int64
+}
+
+// failed logs a failure of op on key by client, or counts it when another
+// was logged less than errorLogInterval ago.
+func (l *errorLog) failed(client int, op opType, key string, class errClass, err error) {
+	now := time.Now().UnixNano()
+	next := l.next.Load()
+	if now < next || !l.next.CompareAndSwap(next, now+int64(errorLogInterval)) {
+		l.suppressed.Add(1)
+		return
+	}
+	attrs := []any{"client", client, "op", op, "key", key, "class", class, "err", err}
+	if n := l.suppressed.Swap(0); n > 0 {
+		attrs = append(attrs, "suppressed", n)
+	}
+	l.logger.Warn("operation failed", attrs...)
+}
+
+// flush logs the failures suppressed since the last warning, once the run
+// is over.
+func (l *errorLog) flush() {
+	if n := l.suppressed.Swap(0); n > 0 {
+		l.logger.Warn("more operations failed", "suppressed", n)
+	}
+}
+
+// logOp logs a completed operation at debug level; the workers call it for
+// one operation in -log-sample.
+func logOp(logger *slog.Logger, client int, p pendingOp, latency time.Duration, err error) {
+	attrs := []any{"client", client, "op", p.op, "key", p.key, "latency", latency}
+	if err != nil {
+		attrs = append(attrs, "err", err)
+	}
+	logger.Debug("operation", attrs...)
+}
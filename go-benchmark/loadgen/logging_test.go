@@ -0,0 +1,88 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// captureLogs sends the logs of cfg to the returned buffer.
+func captureLogs(t *testing.T, cfg *config) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	cfg.logger = newLogger(cfg, &buf)
+	return &buf
+}
+
+func TestErrorLogRateLimit(t *testing.T) {
+	cfg := testConfig(t)
+	logs := captureLogs(t, cfg)
+	l := errorLog{logger: cfg.logger}
+	err := errors.New("connection refused")
+	for i := 0; i < 100; i++ {
+		l.failed(i, opGet, "key", errRefused, err)
+	}
+	l.flush()
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("100 failures logged as:\n%s", logs)
+	}
+	if !strings.Contains(lines[0], `level=WARN msg="operation failed" client=0 op=GET key=key class="connection refused"`) {
+		t.Errorf("first failure logged as %s", lines[0])
+	}
+	if !strings.Contains(lines[1], "suppressed=99") {
+		t.Errorf("suppressed failures logged as %s", lines[1])
+	}
+	// A quiet run was never warned.
+	logs.Reset()
+	l.flush()
+	if logs.Len() > 0 {
+		t.Errorf("flush without failures logged %s", logs)
+	}
+}
+
+func TestDebugOpSampling(t *testing.T) {
+	addr := newTestServerAddr(t)
+	cfg := testConfig(t, "-addr", addr, "-clients", "2", "-ops", "100", "-log-level", "debug", "-log-sample", "10")
+	logs := captureLogs(t, cfg)
+	if _, err := runTarget(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(logs.String(), "msg=operation "); n != 20 {
+		t.Errorf("%d of 200 operations logged with -log-sample 10:\n%s", n, logs)
+	}
+
+	// Info level logs no operation.
+	cfg = testConfig(t, "-addr", addr, "-clients", "2", "-ops", "100", "-log-sample", "1")
+	logs = captureLogs(t, cfg)
+	if _, err := runTarget(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(logs.String(), "msg=operation ") {
+		t.Errorf("operations logged at info level:\n%s", logs)
+	}
+}
+
+func TestLoggingFlags(t *testing.T) {
+	cfg := testConfig(t, "-quiet")
+	if cfg.output != outputJSON || cfg.logLevel != slog.LevelError {
+		t.Errorf("-quiet left output %q and level %v", cfg.output, cfg.logLevel)
+	}
+	if cfg := testConfig(t, "-log-level", "WARN"); cfg.logLevel != slog.LevelWarn {
+		t.Errorf("-log-level WARN parsed as %v", cfg.logLevel)
+	}
+	for _, args := range [][]string{
+		{"-log-level", "trace"},
+		{"-log-sample", "-1"},
+		{"-quiet", "-output", "text"},
+		{"-quiet", "-progress"},
+		{"-quiet", "-log-level", "debug"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
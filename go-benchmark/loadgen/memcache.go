@@ -0,0 +1,387 @@
+package loadgen
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// memcacheMaxKey is the longest key the memcached text protocol allows.
+	memcacheMaxKey = 250
+	// memcacheRelativeTTL is the longest exptime memcached takes as
+	// seconds from now rather than as a Unix time.
+	memcacheRelativeTTL = 30 * 24 * time.Hour
+)
+
+// memcacheOnlyFlags are the options of the memcache target.
+var memcacheOnlyFlags = []string{"memcache-flags", "memcache-noreply", "memcache-max-value"}
+
+// validateMemcache checks that the keys of the run are valid memcached
+// keys: at most memcacheMaxKey bytes, without spaces or control characters.
+func (c *config) validateMemcache() error {
+	if c.memcacheFlags > math.MaxUint32 {
+		return fmt.Errorf("-memcache-flags %d exceeds the 32 bits of memcached flags", c.memcacheFlags)
+	}
+	if c.memcacheMaxValue < 0 {
+		return fmt.Errorf("-memcache-max-value must not be negative, got %d", c.memcacheMaxValue)
+	}
+	if c.keySize > memcacheMaxKey {
+		return fmt.Errorf("-key-size %d exceeds the %d bytes of a memcached key", c.keySize, memcacheMaxKey)
+	}
+	if strings.IndexFunc(c.keyPrefix, func(r rune) bool { return r <= ' ' || r == 0x7f }) >= 0 {
+		return fmt.Errorf("-key-prefix %q: memcached keys cannot hold spaces or control characters", c.keyPrefix)
+	}
+	return nil
+}
+
+// validateMemcacheValues rejects values of up to maxSize bytes that a
+// memcache target would refuse as larger than -memcache-max-value.
+func (c *config) validateMemcacheValues(maxSize int) error {
+	if c.protocol != protocolMemcache && c.protocol2 != protocolMemcache {
+		return nil
+	}
+	if c.memcacheMaxValue > 0 && maxSize > c.memcacheMaxValue {
+		return fmt.Errorf("values of up to %d bytes exceed -memcache-max-value %d, the largest the memcached server stores", maxSize, c.memcacheMaxValue)
+	}
+	return nil
+}
+
+// memcacheError is an ERROR, CLIENT_ERROR or SERVER_ERROR reply. Like a
+// RESP error reply it is a redis.Error, a well-formed answer of the server.
+type memcacheError string
+
+func (e memcacheError) Error() string { return string(e) }
+func (memcacheError) RedisError()     {}
+
+// memcacheProtocolError is a reply the memcache target cannot parse.
+type memcacheProtocolError struct {
+	msg string
+}
+
+func (e *memcacheProtocolError) Error() string { return "memcache: " + e.msg }
+
+// errTargetClosed is returned by a Target used after Close.
+var errTargetClosed = errors.New("target closed")
+
+// memcacheTarget speaks the memcached text protocol over a pool of at most
+// -pool-size connections, dialed on demand.
+type memcacheTarget struct {
+	cfg  *config
+	idle chan *memcacheConn
+	// slots holds a token per connection that may be open.
+	slots  chan struct{}
+	closed chan struct{}
+}
+
+// memcacheConn is a connection of the memcache target. Commands are
+// encoded into out, kept across commands.
+type memcacheConn struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	out      []byte
+	deadline bool
+}
+
+func newMemcacheTarget(cfg *config) *memcacheTarget {
+	n := cfg.effectivePoolSize()
+	t := &memcacheTarget{cfg: cfg, idle: make(chan *memcacheConn, n), slots: make(chan struct{}, n), closed: make(chan struct{})}
+	for i := 0; i < n; i++ {
+		t.slots <- struct{}{}
+	}
+	return t
+}
+
+// conn takes an idle connection, or dials one while the pool has room,
+// waiting for either until ctx is done.
+func (t *memcacheTarget) conn(ctx context.Context) (*memcacheConn, error) {
+	select {
+	case c := <-t.idle:
+		return c, nil
+	default:
+	}
+	select {
+	case c := <-t.idle:
+		return c, nil
+	case <-t.slots:
+	case <-t.closed:
+		return nil, errTargetClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	c, err := t.dial(ctx)
+	if err != nil {
+		t.slots <- struct{}{}
+		return nil, err
+	}
+	return c, nil
+}
+
+func (t *memcacheTarget) dial(ctx context.Context) (*memcacheConn, error) {
+	d := &net.Dialer{Timeout: respDialTimeout}
+	conn, err := d.DialContext(ctx, t.cfg.network(), t.cfg.dialAddr())
+	if err != nil {
+		return nil, err
+	}
+	if t.cfg.tlsConfig != nil {
+		tc := tls.Client(conn, t.cfg.tlsConfig)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+	return &memcacheConn{conn: conn, r: bufio.NewReaderSize(conn, 1<<16)}, nil
+}
+
+// release returns c to the pool after a command that failed with err. A
+// connection that failed other than with an error reply is closed, as its
+// stream may be out of step. So is any that failed under -memcache-noreply:
+// the error reply may answer an earlier command sent with noreply.
+func (t *memcacheTarget) release(c *memcacheConn, err error) {
+	var reply memcacheError
+	if err != nil && (t.cfg.memcacheNoreply || !errors.As(err, &reply)) {
+		c.conn.Close()
+		t.slots <- struct{}{}
+		return
+	}
+	select {
+	case <-t.closed:
+		c.conn.Close()
+		t.slots <- struct{}{}
+	default:
+		t.idle <- c
+	}
+}
+
+// do sends the command encoded by encode and parses its reply with parse.
+func (t *memcacheTarget) do(ctx context.Context, encode func([]byte) []byte, parse func(*memcacheConn) error) error {
+	c, err := t.conn(ctx)
+	if err != nil {
+		return err
+	}
+	if d, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(d)
+		c.deadline = true
+	} else if c.deadline {
+		c.conn.SetDeadline(time.Time{})
+		c.deadline = false
+	}
+	c.out = encode(c.out[:0])
+	if _, err = c.conn.Write(c.out); err == nil {
+		err = parse(c)
+	}
+	t.release(c, err)
+	return err
+}
+
+// memcacheExptime converts ttl into an exptime: whole seconds, rounded up,
+// or a Unix time beyond memcacheRelativeTTL.
+func memcacheExptime(ttl time.Duration) int64 {
+	switch {
+	case ttl <= 0:
+		return 0
+	case ttl > memcacheRelativeTTL:
+		return time.Now().Add(ttl).Unix()
+	}
+	return int64((ttl + time.Second - 1) / time.Second)
+}
+
+// appendNoreply ends a storage, delete or touch command, with noreply
+// under -memcache-noreply.
+func (t *memcacheTarget) appendNoreply(b []byte) []byte {
+	if t.cfg.memcacheNoreply {
+		b = append(b, " noreply"...)
+	}
+	return append(b, "\r\n"...)
+}
+
+func (t *memcacheTarget) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return t.do(ctx, func(b []byte) []byte {
+		b = append(b, "set "...)
+		b = append(b, key...)
+		b = append(b, ' ')
+		b = strconv.AppendUint(b, uint64(t.cfg.memcacheFlags), 10)
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, memcacheExptime(ttl), 10)
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, int64(len(value)), 10)
+		b = t.appendNoreply(b)
+		b = append(b, value...)
+		return append(b, "\r\n"...)
+	}, func(c *memcacheConn) error {
+		if t.cfg.memcacheNoreply {
+			return nil
+		}
+		line, err := c.readLine()
+		switch {
+		case err != nil:
+			return err
+		case string(line) == "NOT_STORED":
+			// The server declined the write, as it may under memory
+			// pressure: a reply like SERVER_ERROR.
+			return memcacheError(line)
+		case string(line) != "STORED":
+			return &memcacheProtocolError{fmt.Sprintf("unexpected reply %q to set", line)}
+		}
+		return nil
+	})
+}
+
+func (t *memcacheTarget) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	var value []byte
+	var found bool
+	err := t.do(ctx, func(b []byte) []byte {
+		b = append(b, "get "...)
+		b = append(b, key...)
+		return append(b, "\r\n"...)
+	}, func(c *memcacheConn) error {
+		return c.readValues(func(_ string, v []byte) {
+			value, found = v, true
+		})
+	})
+	return value, found, err
+}
+
+// MGet reads keys with one get of many keys. The server answers with the
+// keys it holds, in any order.
+func (t *memcacheTarget) MGet(ctx context.Context, keys []string) ([][]byte, error) {
+	found := make(map[string][]byte, len(keys))
+	err := t.do(ctx, func(b []byte) []byte {
+		b = append(b, "get"...)
+		for _, key := range keys {
+			b = append(b, ' ')
+			b = append(b, key...)
+		}
+		return append(b, "\r\n"...)
+	}, func(c *memcacheConn) error {
+		return c.readValues(func(key string, v []byte) {
+			found[key] = v
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = found[key]
+	}
+	return values, nil
+}
+
+func (t *memcacheTarget) Del(ctx context.Context, key string) (bool, error) {
+	return t.found(ctx, "delete", func(b []byte) []byte {
+		b = append(b, "delete "...)
+		b = append(b, key...)
+		return t.appendNoreply(b)
+	}, "DELETED")
+}
+
+// Expire sets the TTL of key with touch.
+func (t *memcacheTarget) Expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return t.found(ctx, "touch", func(b []byte) []byte {
+		b = append(b, "touch "...)
+		b = append(b, key...)
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, memcacheExptime(ttl), 10)
+		return t.appendNoreply(b)
+	}, "TOUCHED")
+}
+
+// found sends a delete or touch, whose reply is hit when the key existed
+// and NOT_FOUND when it did not. Sent with noreply, the key counts as
+// found.
+func (t *memcacheTarget) found(ctx context.Context, command string, encode func([]byte) []byte, hit string) (bool, error) {
+	found := t.cfg.memcacheNoreply
+	err := t.do(ctx, encode, func(c *memcacheConn) error {
+		if t.cfg.memcacheNoreply {
+			return nil
+		}
+		line, err := c.readLine()
+		switch {
+		case err != nil:
+			return err
+		case string(line) == hit:
+			found = true
+		case string(line) != "NOT_FOUND":
+			return &memcacheProtocolError{fmt.Sprintf("unexpected reply %q to %s", line, command)}
+		}
+		return nil
+	})
+	return found, err
+}
+
+func (t *memcacheTarget) Capabilities() Capability { return protocolCaps[protocolMemcache] }
+
+// Close closes the idle connections; those in use are closed as they are
+// released.
+func (t *memcacheTarget) Close() error {
+	close(t.closed)
+	for {
+		select {
+		case c := <-t.idle:
+			c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// readLine reads a reply line without its CRLF. Error replies come back
+// as a memcacheError.
+func (c *memcacheConn) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+	if bytes.Equal(line, []byte("ERROR")) || bytes.HasPrefix(line, []byte("CLIENT_ERROR")) || bytes.HasPrefix(line, []byte("SERVER_ERROR")) {
+		return nil, memcacheError(line)
+	}
+	return line, nil
+}
+
+// readValues reads the VALUE blocks of a get reply up to its END, passing
+// the key and value of each to add.
+func (c *memcacheConn) readValues(add func(key string, value []byte)) error {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if string(line) == "END" {
+			return nil
+		}
+		// VALUE <key> <flags> <bytes> [<cas>]
+		f := bytes.Fields(line)
+		if len(f) < 4 || string(f[0]) != "VALUE" {
+			return &memcacheProtocolError{fmt.Sprintf("unexpected reply %q to get", line)}
+		}
+		if _, err := strconv.ParseUint(string(f[2]), 10, 32); err != nil {
+			return &memcacheProtocolError{fmt.Sprintf("invalid flags in %q", line)}
+		}
+		n, err := strconv.Atoi(string(f[3]))
+		if err != nil || n < 0 {
+			return &memcacheProtocolError{fmt.Sprintf("invalid value length in %q", line)}
+		}
+		// The key is read out of the line before the value overwrites it.
+		key := string(f[1])
+		value := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, value); err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(value, []byte("\r\n")) {
+			return &memcacheProtocolError{fmt.Sprintf("value of %q overruns its %d bytes", key, n)}
+		}
+		add(key, value[:n])
+	}
+}
@@ -0,0 +1,265 @@
+package loadgen
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memcacheServer is a memcached text protocol server of set, get, delete
+// and touch for the tests. A command on a key starting with "fail" gets
+// SERVER_ERROR, as does a set of a value larger than maxValue when it is
+// positive.
+type memcacheServer struct {
+	addr     string
+	maxValue int
+	mu       sync.Mutex
+	data     map[string][]byte
+	flags    map[string]string
+	ttls     map[string]int64
+	commands int
+}
+
+func newMemcacheServer(t *testing.T) *memcacheServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &memcacheServer{addr: ln.Addr().String(), data: make(map[string][]byte), flags: make(map[string]string), ttls: make(map[string]int64)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *memcacheServer) serve(conn net.Conn) {
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			fmt.Fprint(w, "ERROR\r\n")
+			w.Flush()
+			continue
+		}
+		key := f[1]
+		noreply := f[len(f)-1] == "noreply"
+		reply := func(msg string) {
+			if !noreply {
+				fmt.Fprintf(w, "%s\r\n", msg)
+			}
+		}
+		s.mu.Lock()
+		s.commands++
+		switch {
+		case f[0] == "set" && len(f) >= 5:
+			n, _ := strconv.Atoi(f[4])
+			value := make([]byte, n+2)
+			if _, err := io.ReadFull(r, value); err != nil {
+				s.mu.Unlock()
+				return
+			}
+			if s.maxValue > 0 && n > s.maxValue {
+				reply("SERVER_ERROR object too large for cache")
+				break
+			}
+			s.data[key], s.flags[key] = value[:n], f[2]
+			s.ttls[key], _ = strconv.ParseInt(f[3], 10, 64)
+			reply("STORED")
+		case strings.HasPrefix(key, "fail"):
+			reply("SERVER_ERROR out of memory")
+		case f[0] == "get":
+			for _, key := range f[1:] {
+				if v, ok := s.data[key]; ok {
+					fmt.Fprintf(w, "VALUE %s %s %d\r\n%s\r\n", key, s.flags[key], len(v), v)
+				}
+			}
+			fmt.Fprint(w, "END\r\n")
+		case f[0] == "delete":
+			if _, ok := s.data[key]; ok {
+				delete(s.data, key)
+				reply("DELETED")
+			} else {
+				reply("NOT_FOUND")
+			}
+		case f[0] == "touch" && len(f) >= 3:
+			if _, ok := s.data[key]; ok {
+				s.ttls[key], _ = strconv.ParseInt(f[2], 10, 64)
+				reply("TOUCHED")
+			} else {
+				reply("NOT_FOUND")
+			}
+		default:
+			fmt.Fprint(w, "ERROR\r\n")
+		}
+		s.mu.Unlock()
+		w.Flush()
+	}
+}
+
+func TestMemcacheTarget(t *testing.T) {
+	s := newMemcacheServer(t)
+	s.maxValue = 16
+	cfg := testConfig(t, "-addr", s.addr, "-protocol", "memcache", "-clients", "1", "-pool-size", "2", "-memcache-flags", "42")
+	mc := newMemcacheTarget(cfg)
+	defer mc.Close()
+	ctx := context.Background()
+
+	if err := mc.Set(ctx, "k", []byte("v\r\nwith CRLF"), 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	v, found, err := mc.Get(ctx, "k")
+	if err != nil || !found || string(v) != "v\r\nwith CRLF" {
+		t.Errorf("get = %q, %v, %v", v, found, err)
+	}
+	if s.ttls["k"] != 2 || s.flags["k"] != "42" {
+		t.Errorf("exptime %d, flags %s: want the TTL rounded up to 2s and -memcache-flags", s.ttls["k"], s.flags["k"])
+	}
+	if found, err := mc.Expire(ctx, "k", time.Minute); !found || err != nil || s.ttls["k"] != 60 {
+		t.Errorf("touch = %v, %v, exptime %d", found, err, s.ttls["k"])
+	}
+	if found, err := mc.Expire(ctx, "missing", time.Minute); found || err != nil {
+		t.Errorf("touch of a missing key = %v, %v", found, err)
+	}
+	values, err := mc.MGet(ctx, []string{"missing", "k", "k"})
+	if err != nil || len(values) != 3 || values[0] != nil || string(values[1]) != "v\r\nwith CRLF" || string(values[2]) != "v\r\nwith CRLF" {
+		t.Errorf("multi-get = %q, %v", values, err)
+	}
+	if _, found, err := mc.Get(ctx, "missing"); found || err != nil {
+		t.Errorf("get of a missing key = %v, %v", found, err)
+	}
+	if found, err := mc.Del(ctx, "k"); !found || err != nil {
+		t.Errorf("delete = %v, %v", found, err)
+	}
+	if found, err := mc.Del(ctx, "k"); found || err != nil {
+		t.Errorf("second delete = %v, %v", found, err)
+	}
+	_, _, err = mc.Get(ctx, "fail")
+	var reply memcacheError
+	if !errors.As(err, &reply) || classifyError(err) != errServer {
+		t.Errorf("SERVER_ERROR returned %v", err)
+	}
+	err = mc.Set(ctx, "big", make([]byte, 17), 0)
+	if !errors.As(err, &reply) || classifyError(err) != errServer {
+		t.Errorf("set of a value over the server's limit returned %v", err)
+	}
+	// The error replies leave the connection usable.
+	if _, _, err := mc.Get(ctx, "k"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemcacheNoreply(t *testing.T) {
+	s := newMemcacheServer(t)
+	cfg := testConfig(t, "-addr", s.addr, "-protocol", "memcache", "-clients", "1", "-pool-size", "1", "-memcache-noreply")
+	mc := newMemcacheTarget(cfg)
+	defer mc.Close()
+	ctx := context.Background()
+
+	if err := mc.Set(ctx, "k", []byte("v"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := mc.Del(ctx, "missing"); !found || err != nil {
+		t.Errorf("delete with noreply = %v, %v, want it counted as found", found, err)
+	}
+	// The get reads its own reply: the commands before it sent none.
+	if v, found, err := mc.Get(ctx, "k"); err != nil || !found || string(v) != "v" {
+		t.Errorf("get = %q, %v, %v", v, found, err)
+	}
+	if s.commands != 3 {
+		t.Errorf("server saw %d commands, want 3", s.commands)
+	}
+}
+
+func TestMemcacheRun(t *testing.T) {
+	s := newMemcacheServer(t)
+	cfg := testConfig(t, "-addr", s.addr, "-protocol", "memcache", "-ratio", "get=0.5,set=0.4,del=0.1",
+		"-keyspace", "50", "-preload", "50", "-clients", "4", "-ops", "100", "-value-size", "64")
+
+	res, err := runTarget(context.Background(), cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := res.total
+	if res.preload == nil || res.preload.written != 50 || total.attempts() != 400 || total.errors() != 0 {
+		t.Fatalf("preload %+v, %d operations, %d failed", res.preload, total.attempts(), total.errors())
+	}
+	if get := total.ops[opGet]; get.hits == 0 || get.latency.Count() == 0 {
+		t.Errorf("GET stats %+v", get)
+	}
+	if rep := buildReport(cfg, res); rep.Config.Frontend != protocolMemcache {
+		t.Errorf("report front end %q", rep.Config.Frontend)
+	}
+}
+
+func TestMemcacheWorkloads(t *testing.T) {
+	s := newMemcacheServer(t)
+	cfg := testConfig(t, "-addr", s.addr, "-protocol", "memcache", "-ratio", "mget=0.5,expire=0.5",
+		"-batch-keys", "4", "-keyspace", "20", "-preload", "10", "-clients", "2", "-ops", "50")
+
+	res, err := runTarget(context.Background(), cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	mget, expire := res.total.ops[opMGet], res.total.ops[opExpire]
+	if mget.errors != 0 || mget.hits+mget.misses != 4*mget.latency.Count() || mget.hits == 0 || mget.misses == 0 {
+		t.Errorf("MGET %d calls, %d hits, %d misses, %d errors", mget.latency.Count(), mget.hits, mget.misses, mget.errors)
+	}
+	if expire.errors != 0 || expire.hits == 0 || expire.misses == 0 {
+		t.Errorf("EXPIRE %d hits, %d misses, %d errors", expire.hits, expire.misses, expire.errors)
+	}
+}
+
+func TestMemcacheCompare(t *testing.T) {
+	mr, _ := newTestServer(t)
+	s := newMemcacheServer(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-addr2", s.addr, "-protocol2", "memcache",
+		"-workload", "get", "-keyspace", "10", "-preload", "10", "-clients", "2", "-ops", "20")
+	cfg2 := cfg.secondTarget()
+
+	if cfg2.protocol != protocolMemcache || cfg2.addr != s.addr || cfg2.protocol2 != "" {
+		t.Fatalf("second target %s over %s", cfg2.addr, cfg2.protocol)
+	}
+	for _, c := range []*config{cfg, cfg2} {
+		res, err := runTarget(context.Background(), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if get := res.total.ops[opGet]; get.hits != 40 {
+			t.Errorf("%s: GET %d hits", targetLabel(c, cfg), get.hits)
+		}
+	}
+	if label := targetLabel(cfg2, cfg); label != s.addr+" memcache" {
+		t.Errorf("label %q", label)
+	}
+}
+
+func TestMemcacheUnreachable(t *testing.T) {
+	cfg := testConfig(t, "-addr", "127.0.0.1:1", "-protocol", "memcache", "-clients", "1")
+
+	if _, err := runTarget(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "cannot reach") {
+		t.Errorf("runTarget = %v", err)
+	}
+}
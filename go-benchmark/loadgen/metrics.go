@@ -0,0 +1,156 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMetricsBuckets are the upper bounds of the exported latency
+// histogram unless -metrics-buckets says otherwise.
+const defaultMetricsBuckets = "100us,250us,500us,1ms,2.5ms,5ms,10ms,25ms,50ms,100ms,250ms,500ms,1s"
+
+// parseBuckets parses a comma-separated, strictly increasing list of
+// durations.
+func parseBuckets(s string) ([]time.Duration, error) {
+	var bounds []time.Duration
+	for _, part := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket %q: %v", part, err)
+		}
+		if d <= 0 || (len(bounds) > 0 && d <= bounds[len(bounds)-1]) {
+			return nil, fmt.Errorf("buckets must be positive and increasing, got %q", s)
+		}
+		bounds = append(bounds, d)
+	}
+	return bounds, nil
+}
+
+// bucketCounts is a fixed-bucket latency histogram in the Prometheus
+// layout, recorded into with atomic adds like liveHistogram.
+type bucketCounts struct {
+	bounds []time.Duration
+	// counts[i] holds samples in (bounds[i-1], bounds[i]]; the extra last
+	// slot holds samples above every bound.
+	counts []atomic.Int64
+	sumNs  atomic.Int64
+}
+
+func newBucketCounts(bounds []time.Duration) *bucketCounts {
+	return &bucketCounts{bounds: bounds, counts: make([]atomic.Int64, len(bounds)+1)}
+}
+
+func (b *bucketCounts) record(d time.Duration) {
+	i := 0
+	for i < len(b.bounds) && d > b.bounds[i] {
+		i++
+	}
+	b.counts[i].Add(1)
+	b.sumNs.Add(int64(d))
+}
+
+// metricsServer exposes the live counters of the current run on /metrics
+// in the Prometheus text exposition format.
+type metricsServer struct {
+	srv *http.Server
+	// addr is the bound address, which differs from the flag for port 0.
+	addr    string
+	buckets []time.Duration
+	// run is the run being observed; it is kept after the run finishes so a
+	// final scrape still sees its totals.
+	run atomic.Pointer[runState]
+}
+
+// listenMetrics starts serving /metrics on addr. The listener is bound
+// before it returns, so metrics are reachable before the load starts.
+func listenMetrics(addr string, buckets []time.Duration, logger *slog.Logger) (*metricsServer, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("metrics listener: %w", err)
+	}
+	m := &metricsServer{addr: ln.Addr().String(), buckets: buckets}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.serveMetrics)
+	m.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := m.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("metrics server failed", "err", err)
+		}
+	}()
+	return m, nil
+}
+
+// attach makes st the run reported on /metrics and gives it the bucket
+// histogram to record into.
+func (m *metricsServer) attach(st *runState) {
+	st.live.buckets = newBucketCounts(m.buckets)
+	m.run.Store(st)
+}
+
+// shutdown stops the server, letting in-flight scrapes finish.
+func (m *metricsServer) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_ = m.srv.Shutdown(ctx)
+}
+
+func (m *metricsServer) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if st := m.run.Load(); st != nil {
+		writeMetrics(w, st)
+	}
+}
+
+// writeMetrics renders the live counters of st. Every series carries the
+// target address so the two runs of -addr2 can be told apart.
+func writeMetrics(w io.Writer, st *runState) {
+	live := st.live
+	target := fmt.Sprintf("target=%q", st.cfg.addr)
+
+	fmt.Fprintln(w, "# HELP gobench_operations_total Operations completed, including failed ones and warmup.")
+	fmt.Fprintln(w, "# TYPE gobench_operations_total counter")
+	fmt.Fprintf(w, "gobench_operations_total{%s} %d\n", target, live.ops.Load())
+
+	fmt.Fprintln(w, "# HELP gobench_errors_total Failed operations by error class.")
+	fmt.Fprintln(w, "# TYPE gobench_errors_total counter")
+	for c := errClass(0); c < numErrClasses; c++ {
+		class := strings.ReplaceAll(c.String(), " ", "_")
+		fmt.Fprintf(w, "gobench_errors_total{%s,class=%q} %d\n", target, class, live.errClasses[c].Load())
+	}
+
+	if b := live.buckets; b != nil {
+		fmt.Fprintln(w, "# HELP gobench_latency_seconds Service time of successful operations.")
+		fmt.Fprintln(w, "# TYPE gobench_latency_seconds histogram")
+		var cumulative int64
+		for i, bound := range b.bounds {
+			cumulative += b.counts[i].Load()
+			le := strconv.FormatFloat(bound.Seconds(), 'g', -1, 64)
+			fmt.Fprintf(w, "gobench_latency_seconds_bucket{%s,le=%q} %d\n", target, le, cumulative)
+		}
+		cumulative += b.counts[len(b.bounds)].Load()
+		fmt.Fprintf(w, "gobench_latency_seconds_bucket{%s,le=\"+Inf\"} %d\n", target, cumulative)
+		fmt.Fprintf(w, "gobench_latency_seconds_sum{%s} %g\n", target, time.Duration(b.sumNs.Load()).Seconds())
+		fmt.Fprintf(w, "gobench_latency_seconds_count{%s} %d\n", target, cumulative)
+	}
+
+	fmt.Fprintln(w, "# HELP gobench_active_clients Clients currently issuing operations.")
+	fmt.Fprintln(w, "# TYPE gobench_active_clients gauge")
+	fmt.Fprintf(w, "gobench_active_clients{%s} %d\n", target, live.active.Load())
+
+	fmt.Fprintln(w, "# HELP gobench_target_rate_ops Requested aggregate operations per second, 0 when unpaced.")
+	fmt.Fprintln(w, "# TYPE gobench_target_rate_ops gauge")
+	rate := 0.0
+	if st.pace != nil || st.clientPace != nil {
+		rate = st.cfg.aggregateRate()
+	}
+	fmt.Fprintf(w, "gobench_target_rate_ops{%s} %g\n", target, rate)
+}
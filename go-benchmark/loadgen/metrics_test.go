@@ -0,0 +1,54 @@
+package loadgen
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBuckets(t *testing.T) {
+	got, err := parseBuckets("1ms, 10ms,1s")
+	if err != nil || len(got) != 3 || got[1] != 10*time.Millisecond {
+		t.Errorf("parseBuckets = %v, %v", got, err)
+	}
+	for _, in := range []string{"", "1ms,1ms", "10ms,1ms", "0s", "fast"} {
+		if _, err := parseBuckets(in); err == nil {
+			t.Errorf("parseBuckets(%q) accepted", in)
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	_, rdb := newTestServer(t)
+	cfg := testConfig(t, "-clients", "2", "-ops", "50", "-metrics-buckets", "1ns,1h")
+	m, err := listenMetrics("127.0.0.1:0", cfg.buckets, cfg.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.shutdown()
+	cfg.metrics = m
+
+	runBenchmark(context.Background(), rdb, cfg)
+
+	resp, err := http.Get("http://" + m.addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{
+		`gobench_operations_total{target="localhost:6379"} 100`,
+		`gobench_errors_total{target="localhost:6379",class="timeout"} 0`,
+		`gobench_latency_seconds_bucket{target="localhost:6379",le="1e-09"} 0`,
+		`gobench_latency_seconds_bucket{target="localhost:6379",le="3600"} 100`,
+		`gobench_latency_seconds_count{target="localhost:6379"} 100`,
+		`gobench_active_clients{target="localhost:6379"} 0`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics lack %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// usesBatches reports whether the workload issues MGET or MSET.
+func (c *config) usesBatches() bool {
+	return c.mix != nil && (c.mix.share(opMGet) > 0 || c.mix.share(opMSet) > 0)
+}
+
+// issueBatch sends one MGET or MSET of -batch-keys keys. The operation counts
+// as hot when any of its keys came from the -hot-keys pool.
+func (w *worker) issueBatch(ctx context.Context, c redis.Cmdable, op opType) pendingOp {
+	cfg := w.run.cfg
+	keys := make([]string, cfg.batchKeys)
+	anyHot := false
+	for i := range keys {
+		key, hot := w.hotKey()
+		if !hot {
+			key = cfg.keyName(w.keys.Next())
+		}
+		keys[i], anyHot = key, anyHot || hot
+	}
+	if op == opMGet {
+		return pendingOp{op: op, cmd: c.MGet(ctx, keys...), key: keys[0], hot: anyHot}
+	}
+	pairs := make([]interface{}, 0, 2*len(keys))
+	size := 0
+	for _, key := range keys {
+		w.valueSeq++
+		value := cfg.keyValue(w.rng, key, w.id, w.valueSeq, w.seq)
+		pairs = append(pairs, key, value)
+		size += len(value)
+	}
+	return pendingOp{op: op, cmd: c.MSet(ctx, pairs...), bytes: size, key: keys[0], hot: anyHot}
+}
+
+// countMGet accounts each key of an MGET reply as a hit or, for a nil, a
+// miss. It returns the bytes read and whether any key was found. A reply
+// with the wrong number of elements is counted on its own.
+func (w *worker) countMGet(stats *opStats, values []interface{}) (size int, found bool) {
+	return w.countBatch(stats, len(values), func(i int) (int, bool) {
+		s, ok := values[i].(string)
+		return len(s), ok
+	})
+}
+
+// countValues is countMGet for the values a Target read, nil for a
+// missing key.
+func (w *worker) countValues(stats *opStats, values [][]byte) (size int, found bool) {
+	return w.countBatch(stats, len(values), func(i int) (int, bool) {
+		return len(values[i]), values[i] != nil
+	})
+}
+
+// countBatch accounts the n replies of an MGET, of which value returns the
+// size and whether the key existed.
+func (w *worker) countBatch(stats *opStats, n int, value func(i int) (int, bool)) (size int, found bool) {
+	if n != w.run.cfg.batchKeys {
+		w.result.mgetWrongLength++
+	}
+	for i := 0; i < n; i++ {
+		s, ok := value(i)
+		if !ok {
+			stats.misses++
+			continue
+		}
+		stats.hits++
+		size += s
+		found = true
+	}
+	return size, found
+}
+
+// perKey scales a per-command latency summary down to the share of each of
+// n keys.
+func perKey(s *latencySummary, n int) *latencySummary {
+	if s == nil {
+		return nil
+	}
+	d := int64(n)
+	return &latencySummary{
+		Count:  s.Count * d,
+		MinNs:  s.MinNs / d,
+		MeanNs: s.MeanNs / d,
+		P50Ns:  s.P50Ns / d,
+		P90Ns:  s.P90Ns / d,
+		P99Ns:  s.P99Ns / d,
+		P999Ns: s.P999Ns / d,
+		MaxNs:  s.MaxNs / d,
+	}
+}
+
+// jsonBatch describes the MGET and MSET commands of a run. Their per-call
+// latency is in Commands like that of any other command.
+type jsonBatch struct {
+	// KeysPerSec counts the keys read or written per second.
+	KeysPerSec float64 `json:"keys_per_sec"`
+	// PerKeyLatency is the per-call latency divided by -batch-keys.
+	PerKeyLatency map[string]*latencySummary `json:"per_key_latency"`
+	// MGetWrongLength counts MGET replies without one element per key.
+	MGetWrongLength int64 `json:"mget_wrong_length"`
+}
+
+// buildBatch summarises the MGET and MSET commands of res, nil when there
+// were none.
+func buildBatch(cfg *config, res *runResult) *jsonBatch {
+	var b *jsonBatch
+	var calls int64
+	for _, op := range []opType{opMGet, opMSet} {
+		s := &res.total.ops[op]
+		if s.attempts() == 0 {
+			continue
+		}
+		if b == nil {
+			b = &jsonBatch{PerKeyLatency: make(map[string]*latencySummary), MGetWrongLength: res.total.mgetWrongLength}
+		}
+		b.PerKeyLatency[op.String()] = perKey(summarizeLatency(s.latency), cfg.batchKeys)
+		if s.latency != nil {
+			calls += s.latency.Count()
+		}
+	}
+	if b != nil {
+		if elapsed := res.elapsed(); elapsed > 0 {
+			b.KeysPerSec = float64(calls*int64(cfg.batchKeys)) / elapsed.Seconds()
+		}
+	}
+	return b
+}
+
+// printBatch writes the multi-key command section of the summary.
+func printBatch(w io.Writer, cfg *config, res *runResult) {
+	b := buildBatch(cfg, res)
+	if b == nil {
+		return
+	}
+	fmt.Fprintf(w, "Batch: %d keys per command, %.0f keys/s\n", cfg.batchKeys, b.KeysPerSec)
+	if s := &res.total.ops[opMGet]; s.attempts() > 0 {
+		fmt.Fprintf(w, "MGET keys: %d found, %d missing (nil), %d replies of the wrong length\n", s.hits, s.misses, b.MGetWrongLength)
+	}
+	for _, op := range []opType{opMGet, opMSet} {
+		if key := b.PerKeyLatency[op.String()]; key != nil {
+			fmt.Fprintf(w, "%s per key (amortized): p50 %v, p99 %v, max %v\n", op,
+				time.Duration(key.P50Ns), time.Duration(key.P99Ns), time.Duration(key.MaxNs))
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package loadgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestMGetWorkload(t *testing.T) {
+	mr := miniredis.RunT(t)
+	// Half the keyspace is never written, so MGETs see nils.
+	cfg := testConfig(t, "-addr", mr.Addr(), "-workload", "mget", "-preload", "10", "-keyspace", "20",
+		"-batch-keys", "5", "-clients", "2", "-ops", "50", "-key-prefix", "mg:")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &res.total.ops[opMGet]
+	if got := s.hits + s.misses; got != 2*50*5 {
+		t.Errorf("accounted %d keys, want %d", got, 2*50*5)
+	}
+	if s.hits == 0 || s.misses == 0 {
+		t.Errorf("%d found, %d missing, want both", s.hits, s.misses)
+	}
+	if res.total.mgetWrongLength != 0 {
+		t.Errorf("%d wrong-length replies from a correct server", res.total.mgetWrongLength)
+	}
+	b := buildBatch(cfg, res)
+	if b == nil || b.PerKeyLatency["MGET"] == nil {
+		t.Fatalf("batch report %+v, want MGET per-key latency", b)
+	}
+	if call, key := summarizeLatency(s.latency), b.PerKeyLatency["MGET"]; key.P99Ns != call.P99Ns/5 || key.Count != call.Count*5 {
+		t.Errorf("per-key latency %+v is not the per-call %+v over 5 keys", key, call)
+	}
+}
+
+func TestMSetWorkload(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-workload", "mset", "-preload", "0", "-keyspace", "100",
+		"-key-dist", "sequential", "-batch-keys", "10", "-value-size", "8", "-clients", "1", "-ops", "10", "-key-prefix", "ms:")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(mr.Keys()); n != 100 {
+		t.Errorf("MSET wrote %d keys, want 100", n)
+	}
+	if got := res.total.bytesWritten; got != 10*10*8 {
+		t.Errorf("wrote %d bytes, want %d", got, 10*10*8)
+	}
+}
+
+func TestBatchKeysSweep(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-workload", "mget", "-sweep-batch-keys", "1,10,100", "-sweep-cooldown", "0",
+		"-preload", "100", "-clients", "1", "-ops", "5", "-key-prefix", "bs:")
+
+	steps, err := runSweep(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(steps) != 3 {
+		t.Fatalf("ran %d steps, want 3", len(steps))
+	}
+	for _, s := range steps {
+		if s.cfg.batchKeys != s.level {
+			t.Errorf("step %d sent %d keys per MGET", s.level, s.cfg.batchKeys)
+		}
+		if st := &s.res.total.ops[opMGet]; st.hits != int64(5*s.level) {
+			t.Errorf("step %d found %d keys, want %d", s.level, st.hits, 5*s.level)
+		}
+	}
+	if _, err := parseFlags([]string{"-workload", "mget", "-sweep-batch-keys", "1,10", "-sweep-clients", "1,2"}); err == nil {
+		t.Error("two swept parameters were accepted")
+	}
+}
@@ -0,0 +1,372 @@
+package loadgen
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-benchmark/workload"
+)
+
+// A MONITOR log is what redis-cli MONITOR prints, one command per line:
+//
+//	1339518083.107412 [0 127.0.0.1:60866] "set" "user:1" "\xff\x00ab"
+//
+// the time in seconds and microseconds, the database and client, then
+// every argument quoted with the escapes of the server's sdscatrepr. Each
+// client becomes a connection of the trace. The log carries the values but
+// replays only regenerate their sizes, scaled by -monitor-value-scale.
+
+// monitorScriptClient is the client MONITOR names for commands a script
+// called, which the replay does not send on their own.
+const monitorScriptClient = "lua"
+
+// monitorOptions are the -monitor flags shaping a MONITOR log into a trace.
+type monitorOptions struct {
+	// commands keeps only these commands; nil keeps every supported one.
+	commands map[opType]bool
+	rewrite  []prefixRewrite
+	scale    float64
+}
+
+// prefixRewrite replaces the key prefix from with to.
+type prefixRewrite struct {
+	from, to string
+}
+
+// parseMonitorOptions parses the -monitor flags.
+func (c *config) parseMonitorOptions() (monitorOptions, error) {
+	opts := monitorOptions{scale: c.monitorValueScale}
+	if c.monitorValueScale <= 0 || math.IsInf(c.monitorValueScale, 0) || math.IsNaN(c.monitorValueScale) {
+		return opts, fmt.Errorf("-monitor-value-scale must be positive, got %v", c.monitorValueScale)
+	}
+	if c.monitorCommands != "" {
+		opts.commands = make(map[opType]bool)
+		for _, name := range strings.Split(c.monitorCommands, ",") {
+			op, ok := parseOpType(strings.TrimSpace(name))
+			if !ok || !traceOps[op] {
+				return opts, fmt.Errorf("-monitor-commands: %q is not one of set, get, del and expire", name)
+			}
+			opts.commands[op] = true
+		}
+	}
+	if c.monitorRewrite != "" {
+		for _, rule := range strings.Split(c.monitorRewrite, ",") {
+			from, to, ok := strings.Cut(rule, "=")
+			if !ok || from == "" {
+				return opts, fmt.Errorf("-monitor-rewrite: invalid rule %q: want old=new", rule)
+			}
+			opts.rewrite = append(opts.rewrite, prefixRewrite{from: from, to: to})
+		}
+	}
+	return opts, nil
+}
+
+// given reports whether any -monitor flag was set.
+func (o monitorOptions) given() bool {
+	return o.commands != nil || o.rewrite != nil || o.scale != 1
+}
+
+// key applies the first rewrite rule matching key.
+func (o monitorOptions) key(key string) string {
+	for _, r := range o.rewrite {
+		if strings.HasPrefix(key, r.from) {
+			return r.to + key[len(r.from):]
+		}
+	}
+	return key
+}
+
+// monitorParser builds a trace from the lines of a MONITOR log.
+type monitorParser struct {
+	opts  monitorOptions
+	t     *trace
+	conns map[string]int
+	sets  []uint64
+	first time.Duration
+	n     int
+}
+
+// parseMonitor reads a MONITOR log into a trace. Commands a trace cannot
+// hold are counted by name in its skipped commands, those -monitor-commands
+// leaves out in filtered; neither ends the parse. A line that is not a
+// MONITOR line does.
+func parseMonitor(r io.Reader, opts monitorOptions) (*trace, error) {
+	p := &monitorParser{opts: opts, t: &trace{format: traceMonitor, skipped: make(map[string]int64)}, conns: make(map[string]int), first: -1}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 512<<20)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimRight(sc.Text(), "\r")
+		// redis-cli prints OK when MONITOR starts.
+		if text == "" || text == "OK" {
+			continue
+		}
+		if err := p.line(text); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if p.t.valueMax == 0 {
+		// Replays draw every value from a pool, empty ones included.
+		p.t.valueMax = 1
+	}
+	return p.t, nil
+}
+
+// line adds the command of one MONITOR line.
+func (p *monitorParser) line(text string) error {
+	stamp, rest, ok := strings.Cut(text, " [")
+	if !ok {
+		return errors.New("not a MONITOR line")
+	}
+	at, err := parseMonitorTime(stamp)
+	if err != nil {
+		return err
+	}
+	source, rest, ok := strings.Cut(rest, "] ")
+	if !ok {
+		return errors.New("unterminated [db client]")
+	}
+	_, client, ok := strings.Cut(source, " ")
+	if !ok {
+		return fmt.Errorf("malformed [db client] %q", source)
+	}
+	args, err := parseMonitorArgs(rest)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New("no command")
+	}
+	name := strings.ToUpper(args[0])
+	if client == monitorScriptClient {
+		p.t.skipped[name+" (script)"]++
+		return nil
+	}
+	recs, ok := monitorRecords(name, args[1:])
+	if !ok {
+		p.t.skipped[name]++
+		return nil
+	}
+	if p.first < 0 {
+		p.first = at
+	}
+	conn, seen := p.conns[client]
+	if !seen {
+		conn = len(p.t.conns)
+		p.conns[client] = conn
+		p.t.conns = append(p.t.conns, nil)
+		p.sets = append(p.sets, 0)
+	}
+	for _, r := range recs {
+		if p.opts.commands != nil && !p.opts.commands[r.op] {
+			p.t.filtered++
+			continue
+		}
+		// Offsets never go back within a connection.
+		r.at = at - p.first
+		if prev := p.t.conns[conn]; len(prev) > 0 {
+			r.at = max(r.at, prev[len(prev)-1].at)
+		}
+		r.key, r.conn = p.opts.key(r.key), conn
+		if r.op == opSet {
+			r.size = int(math.Round(float64(r.size) * p.opts.scale))
+			// Spread the values over the pool, which holds at least
+			// workload.ValuePoolSlack bytes past the largest one.
+			r.fill = p.n * 7919 % workload.ValuePoolSlack
+			p.sets[conn]++
+			r.seq = p.sets[conn]
+			p.t.valueMax = max(p.t.valueMax, r.size)
+		}
+		p.n++
+		p.t.conns[conn] = append(p.t.conns[conn], r)
+	}
+	return nil
+}
+
+// monitorRecords translates a command into trace records, or reports it
+// unsupported. A DEL of several keys becomes one DEL per key.
+func monitorRecords(name string, args []string) ([]traceRecord, bool) {
+	switch name {
+	case "GET":
+		if len(args) == 1 {
+			return []traceRecord{{op: opGet, key: args[0]}}, true
+		}
+	case "DEL":
+		if len(args) > 0 {
+			recs := make([]traceRecord, len(args))
+			for i, key := range args {
+				recs[i] = traceRecord{op: opDel, key: key}
+			}
+			return recs, true
+		}
+	case "EXPIRE":
+		if len(args) == 2 {
+			if secs, err := strconv.ParseInt(args[1], 10, 64); err == nil && secs > 0 {
+				return []traceRecord{{op: opExpire, key: args[0], ttl: time.Duration(secs) * time.Second}}, true
+			}
+		}
+	case "SET":
+		if len(args) < 2 {
+			return nil, false
+		}
+		rec := traceRecord{op: opSet, key: args[0], size: len(args[1])}
+		opts := args[2:]
+		for len(opts) > 0 {
+			// Only the TTL options keep the meaning of a plain SET.
+			if len(opts) < 2 {
+				return nil, false
+			}
+			n, err := strconv.ParseInt(opts[1], 10, 64)
+			if err != nil || n <= 0 {
+				return nil, false
+			}
+			switch strings.ToUpper(opts[0]) {
+			case "EX":
+				rec.ttl = time.Duration(n) * time.Second
+			case "PX":
+				rec.ttl = time.Duration(n) * time.Millisecond
+			default:
+				return nil, false
+			}
+			opts = opts[2:]
+		}
+		return []traceRecord{rec}, true
+	case "SETEX", "PSETEX":
+		if len(args) != 3 {
+			return nil, false
+		}
+		n, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || n <= 0 {
+			return nil, false
+		}
+		unit := time.Second
+		if name == "PSETEX" {
+			unit = time.Millisecond
+		}
+		return []traceRecord{{op: opSet, key: args[0], size: len(args[2]), ttl: time.Duration(n) * unit}}, true
+	}
+	return nil, false
+}
+
+// parseMonitorTime parses the seconds.microseconds stamp of a MONITOR line.
+// It is split rather than parsed as a float, which would lose microseconds.
+func parseMonitorTime(s string) (time.Duration, error) {
+	secs, frac, _ := strings.Cut(s, ".")
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed time %q", s)
+	}
+	var us int64
+	if frac != "" {
+		if len(frac) > 6 {
+			frac = frac[:6]
+		}
+		if us, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return 0, fmt.Errorf("malformed time %q", s)
+		}
+		for i := len(frac); i < 6; i++ {
+			us *= 10
+		}
+	}
+	return time.Duration(sec)*time.Second + time.Duration(us)*time.Microsecond, nil
+}
+
+// parseMonitorArgs splits the quoted arguments of a MONITOR line, undoing
+// the escapes \\, \", \n, \r, \t, \a, \b and \xHH.
+func parseMonitorArgs(s string) ([]string, error) {
+	var args []string
+	for i := 0; i < len(s); {
+		if s[i] == ' ' {
+			i++
+			continue
+		}
+		if s[i] != '"' {
+			return nil, fmt.Errorf("argument %d is not quoted", len(args)+1)
+		}
+		var b []byte
+		i++
+		for {
+			if i >= len(s) {
+				return nil, fmt.Errorf("argument %d: unterminated quote", len(args)+1)
+			}
+			c := s[i]
+			if c == '"' {
+				i++
+				break
+			}
+			if c != '\\' {
+				b = append(b, c)
+				i++
+				continue
+			}
+			if i+1 >= len(s) {
+				return nil, fmt.Errorf("argument %d: unterminated escape", len(args)+1)
+			}
+			switch e := s[i+1]; e {
+			case 'n':
+				b = append(b, '\n')
+			case 'r':
+				b = append(b, '\r')
+			case 't':
+				b = append(b, '\t')
+			case 'a':
+				b = append(b, '\a')
+			case 'b':
+				b = append(b, '\b')
+			case 'x':
+				if i+3 >= len(s) {
+					return nil, fmt.Errorf("argument %d: truncated \\x escape", len(args)+1)
+				}
+				v, err := strconv.ParseUint(s[i+2:i+4], 16, 8)
+				if err != nil {
+					return nil, fmt.Errorf("argument %d: invalid escape \\x%s", len(args)+1, s[i+2:i+4])
+				}
+				b = append(b, byte(v))
+				i += 2
+			default:
+				b = append(b, e)
+			}
+			i += 2
+		}
+		args = append(args, string(b))
+	}
+	return args, nil
+}
+
+// printSkipped writes the commands of a MONITOR log the replay left out.
+func printSkipped(w io.Writer, t *trace) {
+	if t.filtered > 0 {
+		fmt.Fprintf(w, "  %d commands left out by -monitor-commands\n", t.filtered)
+	}
+	if len(t.skipped) == 0 {
+		return
+	}
+	names := make([]string, 0, len(t.skipped))
+	var total int64
+	for name, n := range t.skipped {
+		names = append(names, name)
+		total += n
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if a, b := t.skipped[names[i]], t.skipped[names[j]]; a != b {
+			return a > b
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s %d", name, t.skipped[name])
+	}
+	fmt.Fprintf(w, "  %d unsupported commands skipped: %s\n", total, strings.Join(parts, ", "))
+}
@@ -0,0 +1,193 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// monitorLog is redis-cli MONITOR output, including commands a trace
+// cannot hold.
+const monitorLog = `OK
+1700000000.000001 [0 127.0.0.1:52100] "SET" "prod:user:1" "\x00\x01\xfe\xff" "EX" "60"
+1700000000.000500 [0 127.0.0.1:52101] "get" "prod:user:1"
+1700000000.001000 [0 127.0.0.1:52100] "del" "prod:a" "prod:b"
+1700000000.002 [0 127.0.0.1:52101] "setex" "prod:s" "10" "hello \"world\""
+1700000000.003000 [0 127.0.0.1:52101] "hset" "prod:h" "f" "v"
+1700000000.004000 [0 lua] "get" "prod:x"
+1700000000.005000 [0 127.0.0.1:52100] "eval" "return redis.call('get', KEYS[1])" "1" "prod:x"
+1700000000.006000 [0 unix:/tmp/redis.sock] "set" "other" "v" "NX"
+1700000000.007000 [0 127.0.0.1:52100] "expire" "prod:user:1" "30"
+1700000000.008000 [0 127.0.0.1:52100] "ping"
+`
+
+func TestParseMonitorArgs(t *testing.T) {
+	for line, want := range map[string][]string{
+		`"set" "foo" "bar"`:                        {"set", "foo", "bar"},
+		`"get" "a b"`:                              {"get", "a b"},
+		`"set" "k" "\xff\x00\x7F"`:                 {"set", "k", "\xff\x00\x7f"},
+		`"set" "k" "say \"hi\"\\"`:                 {"set", "k", `say "hi"\`},
+		`"set" "k" "l1\nl2\r\t\a\b"`:               {"set", "k", "l1\nl2\r\t\a\b"},
+		`"set" "k" ""`:                             {"set", "k", ""},
+		`"set"  "k" "{\"json\":[1,2]}"`:            {"set", "k", `{"json":[1,2]}`},
+		`"set" "\xe2\x82\xac" "\xc3\xa9t\xc3\xa9"`: {"set", "€", "été"},
+	} {
+		got, err := parseMonitorArgs(line)
+		if err != nil || strings.Join(got, "|") != strings.Join(want, "|") {
+			t.Errorf("%s: %q, %v, want %q", line, got, err, want)
+		}
+	}
+	for line, want := range map[string]string{
+		`set foo`:            "argument 1 is not quoted",
+		`"set" "foo`:         "argument 2: unterminated quote",
+		`"set" "k" "\x4`:     "argument 3: truncated \\x escape",
+		`"set" "k" "\xzz" `:  "argument 3: invalid escape \\xzz",
+		`"set" "k" "v\`:      "argument 3: unterminated escape",
+		`"get" "k"trailing"`: "argument 3 is not quoted",
+	} {
+		if _, err := parseMonitorArgs(line); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: error %v, want %q", line, err, want)
+		}
+	}
+}
+
+func TestParseMonitor(t *testing.T) {
+	tr, err := parseMonitor(strings.NewReader(monitorLog), monitorOptions{rewrite: []prefixRewrite{{"prod:", "bench:"}}, scale: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.conns) != 2 || tr.len() != 6 || tr.format != traceMonitor {
+		t.Fatalf("%d connections, %d commands", len(tr.conns), tr.len())
+	}
+	for i, want := range []traceRecord{
+		{op: opSet, key: "bench:user:1", size: 8, ttl: time.Minute, seq: 1},
+		{op: opDel, key: "bench:a", at: 999 * time.Microsecond},
+		{op: opDel, key: "bench:b", at: 999 * time.Microsecond},
+		{op: opExpire, key: "bench:user:1", at: 6999 * time.Microsecond, ttl: 30 * time.Second},
+	} {
+		got := tr.conns[0][i]
+		got.fill = 0
+		if got != want {
+			t.Errorf("connection 0, command %d: %+v, want %+v", i, got, want)
+		}
+	}
+	for i, want := range []traceRecord{
+		{op: opGet, key: "bench:user:1", at: 499 * time.Microsecond, conn: 1},
+		{op: opSet, key: "bench:s", at: 1999 * time.Microsecond, size: 26, ttl: 10 * time.Second, conn: 1, seq: 1},
+	} {
+		got := tr.conns[1][i]
+		got.fill = 0
+		if got != want {
+			t.Errorf("connection 1, command %d: %+v, want %+v", i, got, want)
+		}
+	}
+	if tr.valueMax != 26 {
+		t.Errorf("largest value %d bytes, want 26", tr.valueMax)
+	}
+	want := map[string]int64{"HSET": 1, "GET (script)": 1, "EVAL": 1, "SET": 1, "PING": 1}
+	if len(tr.skipped) != len(want) {
+		t.Errorf("skipped %v, want %v", tr.skipped, want)
+	}
+	for name, n := range want {
+		if tr.skipped[name] != n {
+			t.Errorf("skipped %d %s, want %d", tr.skipped[name], name, n)
+		}
+	}
+
+	tr, err = parseMonitor(strings.NewReader(monitorLog), monitorOptions{commands: map[opType]bool{opGet: true}, scale: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.len() != 1 || tr.filtered != 5 || tr.conns[1][0].key != "prod:user:1" {
+		t.Errorf("kept %d GETs and filtered %d commands, want 1 and 5", tr.len(), tr.filtered)
+	}
+
+	for log, want := range map[string]string{
+		"OK\nredis-cli: connection refused\n":      "line 2: not a MONITOR line",
+		"1700000000.1 [0 127.0.0.1:1 \"get\"\n":    "line 1: unterminated [db client]",
+		"17000x.1 [0 127.0.0.1:1] \"get\" \"k\"\n": `line 1: malformed time "17000x.1"`,
+		"1700000000.1 [0] \"get\" \"k\"\n":         "line 1: malformed [db client]",
+	} {
+		if _, err := parseMonitor(strings.NewReader(log), monitorOptions{scale: 1}); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: error %v, want %q", log, err, want)
+		}
+	}
+}
+
+func TestReplayMonitorLog(t *testing.T) {
+	// Stretch the log over a second, which -replay-speed compresses.
+	log := strings.Replace(monitorLog, "1700000000.008000", "1700000001.000000", 1)
+	log = strings.Replace(log, `"ping"`, `"get" "prod:s"`, 1)
+	path := filepath.Join(t.TempDir(), "monitor.log")
+	if err := os.WriteFile(path, []byte(log), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-replay", path, "-replay-speed", "5", "-monitor-rewrite", "prod:=bench:,other:=x:",
+		"-monitor-value-scale", "10", "-verify-final")
+	if cfg.clients != 2 || cfg.keyPrefix != "" || cfg.preload != 0 {
+		t.Fatalf("%d clients, prefix %q, preload %d", cfg.clients, cfg.keyPrefix, cfg.preload)
+	}
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := res.end.Sub(res.start); d < 150*time.Millisecond || d > 600*time.Millisecond {
+		t.Errorf("replay of a 1s log at 5x took %v", d)
+	}
+	// Only the GET of bench:s a second in is ordered after its SET; the
+	// other races a SET of another connection.
+	if n := res.total.ops[opGet].hits; n == 0 {
+		t.Error("the GET of bench:s missed")
+	}
+	if v, err := mr.Get("bench:s"); err != nil || len(v) != 130 {
+		t.Errorf("bench:s holds %d bytes (%v), want 13 scaled by 10", len(v), err)
+	}
+	if mr.TTL("bench:user:1") != 30*time.Second {
+		t.Errorf("bench:user:1 TTL %v, want the EXPIRE's 30s", mr.TTL("bench:user:1"))
+	}
+	if res.final == nil || res.final.failed() {
+		t.Errorf("final check %+v", res.final)
+	}
+
+	var buf bytes.Buffer
+	printTrace(&buf, cfg.displayUnit(res.total.latency), cfg, res)
+	for _, want := range []string{
+		"Replay: 7 commands of 2 connections from MONITOR log " + path + ", timing original at 5x",
+		"4 unsupported commands skipped: EVAL 1, GET (script) 1, HSET 1, SET 1",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestMonitorFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "monitor.log")
+	if err := os.WriteFile(path, []byte(monitorLog), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mr := miniredis.RunT(t)
+	trace, _ := recordTrace(t, "-addr", mr.Addr(), "-clients", "1", "-ops", "5")
+	for _, args := range [][]string{
+		{"-replay", path, "-monitor-commands", "get,incr"},
+		{"-replay", path, "-monitor-rewrite", "prod:"},
+		{"-replay", path, "-monitor-value-scale", "0"},
+		{"-replay", path, "-replay-speed", "-1"},
+		{"-replay", trace, "-monitor-commands", "get"},
+		{"-monitor-commands", "get"},
+		{"-replay-speed", "2"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	testConfig(t, "-replay", path, "-monitor-commands", "get, set", "-monitor-value-scale", "0.5", "-replay-speed", "0.5")
+}
@@ -0,0 +1,298 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+// notifyEvents are the notify-keyspace-events flags -watch-notifications
+// needs: keyevent notifications of expirations and evictions.
+const notifyEvents = "Exe"
+
+// validateNotifications checks -watch-notifications. Keyspace notifications
+// are per node and per database, so it follows the one database of a single
+// server.
+func (c *config) validateNotifications() error {
+	if !c.watchNotifications {
+		return nil
+	}
+	switch {
+	case c.cluster:
+		return errors.New("-watch-notifications does not apply to -cluster, whose nodes notify their own keys")
+	case c.dbList != "":
+		return errors.New("-watch-notifications follows one database, not -dbs")
+	case c.notifyExpiries() && c.expirySample <= 0:
+		return fmt.Errorf("-expiry-sample must be positive, got %d", c.expirySample)
+	}
+	return nil
+}
+
+// notifyExpiries reports whether -watch-notifications correlates expired
+// notifications with the keys written: the set workload with -ttl and
+// without -keyspace writes every key once, so each must expire once.
+func (c *config) notifyExpiries() bool {
+	return c.watchNotifications && c.ttlMax > 0 && c.workload == workloadSet && !c.boundedSets
+}
+
+// notifyChannel is the keyevent channel of event in the database of c.
+func (c *config) notifyChannel(event string) string {
+	return fmt.Sprintf("__keyevent@%d__:%s", c.db, event)
+}
+
+// notifyWatcher receives the expired and evicted keyevent notifications of
+// a run on a connection of its own. It times the expired notifications of
+// the keys the workers sampled, which they register as they sample them.
+type notifyWatcher struct {
+	// unavailable says why the server sends no notifications; the other
+	// fields are unused when it is set.
+	unavailable string
+	// restore is the notify-keyspace-events setting to put back after the
+	// run, with changed set when the watcher changed it.
+	restore string
+	changed bool
+	ps      *redis.PubSub
+	done    chan struct{}
+	origin  time.Time
+	prefix  string
+	layout  stats.Layout
+	logger  *slog.Logger
+
+	mu sync.Mutex
+	// wanted maps the sampled keys to when their expired notification
+	// arrived, zero until it did.
+	wanted           map[string]time.Time
+	expired, evicted int64
+	// other counts the notifications of keys the run did not write.
+	other int64
+	// evictions counts the evicted notifications of each second since
+	// origin.
+	evictions []int64
+}
+
+// startNotifyWatcher enables the notifications the watcher needs unless
+// the server already sends them and subscribes to them. A server refusing
+// CONFIG or the subscription leaves a watcher that only reports why.
+func startNotifyWatcher(ctx context.Context, rdb redis.UniversalClient, cfg *config) *notifyWatcher {
+	n := &notifyWatcher{prefix: cfg.keyPrefix, layout: cfg.layout, logger: cfg.logger, wanted: make(map[string]time.Time)}
+	vals, err := rdb.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil || len(vals) != 2 {
+		n.unavailable = fmt.Sprintf("CONFIG GET notify-keyspace-events: %v", err)
+		return n
+	}
+	n.restore, _ = vals[1].(string)
+	if missing := missingNotifyEvents(n.restore); missing != "" {
+		if err := rdb.ConfigSet(ctx, "notify-keyspace-events", n.restore+missing).Err(); err != nil {
+			n.unavailable = fmt.Sprintf("CONFIG SET notify-keyspace-events: %v", err)
+			return n
+		}
+		n.changed = true
+	}
+	channels := []string{cfg.notifyChannel("expired"), cfg.notifyChannel("evicted")}
+	n.ps = rdb.Subscribe(ctx, channels...)
+	for range channels {
+		if _, err := n.ps.Receive(ctx); err != nil {
+			n.ps.Close()
+			n.unavailable = fmt.Sprintf("SUBSCRIBE: %v", err)
+			n.restoreConfig(ctx, rdb)
+			return n
+		}
+	}
+	n.origin, n.done = time.Now(), make(chan struct{})
+	go n.run()
+	return n
+}
+
+// missingNotifyEvents returns the flags of notifyEvents that flags, a
+// notify-keyspace-events setting, lacks. A stands for every event.
+func missingNotifyEvents(flags string) string {
+	var missing strings.Builder
+	for _, f := range notifyEvents {
+		if !strings.ContainsRune(flags, f) && (f == 'E' || !strings.ContainsRune(flags, 'A')) {
+			missing.WriteRune(f)
+		}
+	}
+	return missing.String()
+}
+
+func (n *notifyWatcher) restoreConfig(ctx context.Context, rdb redis.UniversalClient) {
+	if !n.changed {
+		return
+	}
+	if err := rdb.ConfigSet(ctx, "notify-keyspace-events", n.restore).Err(); err != nil {
+		n.logger.Warn("cannot restore notify-keyspace-events", "value", n.restore, "err", err)
+	}
+}
+
+// run receives notifications until the subscription is closed.
+func (n *notifyWatcher) run() {
+	defer close(n.done)
+	for {
+		msg, err := n.ps.ReceiveMessage(context.Background())
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		n.mu.Lock()
+		switch {
+		case !strings.HasPrefix(msg.Payload, n.prefix):
+			n.other++
+		case strings.HasSuffix(msg.Channel, ":expired"):
+			n.expired++
+			if at, ok := n.wanted[msg.Payload]; ok && at.IsZero() {
+				n.wanted[msg.Payload] = now
+			}
+		default:
+			n.evicted++
+			s := int(now.Sub(n.origin) / time.Second)
+			for len(n.evictions) <= s {
+				n.evictions = append(n.evictions, 0)
+			}
+			n.evictions[s]++
+		}
+		n.mu.Unlock()
+	}
+}
+
+// want registers key, sampled by a worker, for its expired notification.
+func (n *notifyWatcher) want(key string) {
+	if n == nil || n.unavailable != "" {
+		return
+	}
+	n.mu.Lock()
+	n.wanted[key] = time.Time{}
+	n.mu.Unlock()
+}
+
+// forget drops key, replaced in the sample of its worker.
+func (n *notifyWatcher) forget(key string) {
+	if n == nil || n.unavailable != "" {
+		return
+	}
+	n.mu.Lock()
+	delete(n.wanted, key)
+	n.mu.Unlock()
+}
+
+// notified returns how many of samples got their expired notification.
+func (n *notifyWatcher) notified(samples []expirySample) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	got := 0
+	for _, s := range samples {
+		if !n.wanted[s.key].IsZero() {
+			got++
+		}
+	}
+	return got
+}
+
+// stop waits for the expired notifications of samples, up to grace past
+// the last of their deadlines, then unsubscribes, restores the setting the
+// watcher changed and reports what arrived.
+func (n *notifyWatcher) stop(ctx context.Context, rdb redis.UniversalClient, samples []expirySample, grace time.Duration) *notifyReport {
+	if n.unavailable != "" {
+		return &notifyReport{Unavailable: n.unavailable}
+	}
+	var last time.Time
+	for _, s := range samples {
+		if s.deadline.After(last) {
+			last = s.deadline
+		}
+	}
+	if len(samples) > 0 {
+		n.logger.Info("waiting for expired notifications", "keys", len(samples))
+	}
+	until := last.Add(grace)
+	for n.notified(samples) < len(samples) && time.Now().Before(until) && ctx.Err() == nil {
+		time.Sleep(expiryPollInterval)
+	}
+	n.ps.Close()
+	<-n.done
+	n.restoreConfig(context.Background(), rdb)
+
+	rep := &notifyReport{Available: true, ConfigChanged: n.changed, Expired: n.expired, Evicted: n.evicted, Other: n.other, Sampled: len(samples)}
+	latency := n.layout.New()
+	for _, s := range samples {
+		if at := n.wanted[s.key]; !at.IsZero() {
+			rep.Notified++
+			latency.Record(max(at.Sub(s.deadline), 0))
+		}
+	}
+	if rep.Sampled > 0 {
+		rep.Coverage = float64(rep.Notified) / float64(rep.Sampled)
+		rep.Latency = summarizeLatency(latency)
+	}
+	for s, count := range n.evictions {
+		if count > 0 {
+			rep.Evictions = append(rep.Evictions, notifyPoint{Second: s, Evicted: count})
+		}
+	}
+	return rep
+}
+
+// notifyReport describes the keyevent notifications of a run: how many
+// arrived, how many of the sampled keys written with a TTL were notified
+// expired and how long after their TTL, and the evictions over time.
+type notifyReport struct {
+	Available   bool   `json:"available"`
+	Unavailable string `json:"unavailable,omitempty"`
+	// ConfigChanged is set when the run enabled notify-keyspace-events
+	// flags, restored afterwards.
+	ConfigChanged bool  `json:"config_changed,omitempty"`
+	Expired       int64 `json:"expired"`
+	Evicted       int64 `json:"evicted"`
+	// Other counts the notifications of keys outside -key-prefix.
+	Other    int64   `json:"other_keys"`
+	Sampled  int     `json:"sampled,omitempty"`
+	Notified int     `json:"notified,omitempty"`
+	Coverage float64 `json:"coverage,omitempty"`
+	// Latency is how long after its deadline a sampled key was notified
+	// expired.
+	Latency   *latencySummary `json:"latency_after_ttl,omitempty"`
+	Evictions []notifyPoint   `json:"evictions,omitempty"`
+}
+
+// notifyPoint counts the evicted notifications of one second, the seconds
+// counted from the subscription just before the run.
+type notifyPoint struct {
+	Second  int   `json:"second"`
+	Evicted int64 `json:"evicted"`
+}
+
+// printNotifications writes the keyspace notification section.
+func printNotifications(w io.Writer, rep *notifyReport) {
+	if !rep.Available {
+		fmt.Fprintf(w, "Keyspace notifications: unavailable (%s)\n", rep.Unavailable)
+		return
+	}
+	fmt.Fprintf(w, "Keyspace notifications: %d expired, %d evicted, %d of other keys", rep.Expired, rep.Evicted, rep.Other)
+	if rep.ConfigChanged {
+		fmt.Fprint(w, " (notify-keyspace-events enabled for the run)")
+	}
+	fmt.Fprintln(w)
+	if rep.Sampled > 0 {
+		fmt.Fprintf(w, "Expired notifications: %d of %d sampled keys (%.1f%%)", rep.Notified, rep.Sampled, 100*rep.Coverage)
+		if l := rep.Latency; l != nil {
+			fmt.Fprintf(w, ", after the TTL: p50 %v, p99 %v, max %v",
+				time.Duration(l.P50Ns), time.Duration(l.P99Ns), time.Duration(l.MaxNs))
+		}
+		fmt.Fprintln(w)
+	}
+	if len(rep.Evictions) > 0 {
+		points := make([]string, len(rep.Evictions))
+		for i, p := range rep.Evictions {
+			points[i] = fmt.Sprintf("%ds: %d", p.Second, p.Evicted)
+		}
+		fmt.Fprintf(w, "Evictions notified per second: %s\n", strings.Join(points, ", "))
+	}
+}
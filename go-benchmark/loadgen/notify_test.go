@@ -0,0 +1,159 @@
+package loadgen
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// configProxy forwards commands to a miniredis server, which has no
+// CONFIG, and answers CONFIG GET and SET of notify-keyspace-events itself.
+type configProxy struct {
+	mu     sync.Mutex
+	events string
+	sets   int
+}
+
+func startConfigProxy(t *testing.T, mr *miniredis.Miniredis, events string) (*configProxy, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	p := &configProxy{events: events}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn, mr.Addr())
+		}
+	}()
+	return p, ln.Addr().String()
+}
+
+func (p *configProxy) serve(conn net.Conn, upstream string) {
+	defer conn.Close()
+	up, err := net.Dial("tcp", upstream)
+	if err != nil {
+		return
+	}
+	defer up.Close()
+	// The two writers of conn never overlap: CONFIG is only sent when no
+	// other reply is pending.
+	go io.Copy(conn, up)
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readBulkCommand(r)
+		if err != nil {
+			return
+		}
+		if !strings.EqualFold(args[0], "CONFIG") {
+			var b []byte
+			b = appendArray(b, len(args))
+			for _, a := range args {
+				b = appendBulk(b, a)
+			}
+			up.Write(b)
+			continue
+		}
+		p.mu.Lock()
+		if strings.EqualFold(args[1], "SET") {
+			p.events = args[3]
+			p.sets++
+			conn.Write([]byte("+OK\r\n"))
+		} else {
+			conn.Write([]byte("*2\r\n" + bulk(args[2]) + bulk(p.events)))
+		}
+		p.mu.Unlock()
+	}
+}
+
+func TestMissingNotifyEvents(t *testing.T) {
+	for flags, want := range map[string]string{"": "Exe", "KEA": "", "Ex": "e", "Kx": "Ee", "AK": "E"} {
+		if got := missingNotifyEvents(flags); got != want {
+			t.Errorf("%q lacks %q, want %q", flags, got, want)
+		}
+	}
+}
+
+func TestNotifyWatcher(t *testing.T) {
+	mr := miniredis.RunT(t)
+	proxy, addr := startConfigProxy(t, mr, "K")
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	defer rdb.Close()
+	cfg := testConfig(t, "-addr", addr, "-clients", "1", "-ttl", "1s", "-watch-notifications", "-key-prefix", "n:")
+	if !cfg.notifyExpiries() {
+		t.Fatal("the set workload with -ttl does not correlate expirations")
+	}
+	n := startNotifyWatcher(context.Background(), rdb, cfg)
+	if n.unavailable != "" || !n.changed || proxy.events != "KExe" {
+		t.Fatalf("watcher %q, events %q", n.unavailable, proxy.events)
+	}
+	now := time.Now()
+	var samples []expirySample
+	r := &expiryReservoir{size: 3, watch: n}
+	for _, key := range []string{"n:a", "n:b", "n:c"} {
+		r.offer(nil, expirySample{key: key, deadline: now})
+		samples = append(samples, expirySample{key: key, deadline: now})
+	}
+	for _, m := range [][2]string{{"expired", "n:a"}, {"expired", "n:b"}, {"expired", "other"}, {"evicted", "n:x"}, {"evicted", "n:y"}} {
+		if got := mr.Publish(cfg.notifyChannel(m[0]), m[1]); got != 1 {
+			t.Fatalf("%v reached %d subscribers", m, got)
+		}
+	}
+	rep := n.stop(context.Background(), rdb, samples, 200*time.Millisecond)
+	if !rep.Available || rep.Expired != 2 || rep.Evicted != 2 || rep.Other != 1 || rep.Notified != 2 || rep.Sampled != 3 || rep.Latency == nil {
+		t.Errorf("report %+v", rep)
+	}
+	if len(rep.Evictions) != 1 || rep.Evictions[0] != (notifyPoint{Second: 0, Evicted: 2}) {
+		t.Errorf("evictions %+v", rep.Evictions)
+	}
+	if proxy.events != "K" || proxy.sets != 2 {
+		t.Errorf("events %q after %d CONFIG SETs, not restored", proxy.events, proxy.sets)
+	}
+	var buf bytes.Buffer
+	printNotifications(&buf, rep)
+	if !strings.Contains(buf.String(), "Expired notifications: 2 of 3 sampled keys (66.7%)") {
+		t.Errorf("summary lacks the coverage:\n%s", buf.String())
+	}
+}
+
+func TestNotificationsUnavailable(t *testing.T) {
+	mr, _ := newTestServer(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-ops", "5", "-watch-notifications")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.notify == nil || res.notify.Available || !strings.Contains(res.notify.Unavailable, "CONFIG GET") {
+		t.Fatalf("notifications %+v", res.notify)
+	}
+	if rep := buildReport(cfg, res); rep.Notifications != res.notify {
+		t.Error("the JSON report lacks the notifications")
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "Keyspace notifications: unavailable") {
+		t.Errorf("summary lacks the notifications:\n%s", buf.String())
+	}
+	for _, args := range [][]string{
+		{"-watch-notifications", "-cluster"},
+		{"-watch-notifications", "-dbs", "0-1", "-clients", "2"},
+		{"-watch-notifications", "-ttl", "1s", "-expiry-sample", "0"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Scheduling modes of -loop. A closed loop has each client send its next
+// operation once the previous one completed, so a slower server is offered
+// less load. An open loop generates arrivals at -rate whatever the server
+// does and hands them to the clients, which bound the operations in flight:
+// an arrival finding every client busy waits in a queue of -open-queue
+// arrivals, and one finding the queue full is dropped.
+const (
+	loopClosed = "closed"
+	loopOpen   = "open"
+)
+
+// validateLoop checks -loop and the flags of the open loop.
+func (c *config) validateLoop() error {
+	switch c.loop {
+	case loopClosed:
+		if _, ok := c.explicit["open-queue"]; ok {
+			return errors.New("-open-queue requires -loop open")
+		}
+		if c.patternSpec != "" {
+			return errors.New("-pattern requires -loop open")
+		}
+		return nil
+	case loopOpen:
+	default:
+		return fmt.Errorf("-loop must be %q or %q, got %q", loopClosed, loopOpen, c.loop)
+	}
+	if _, ok := c.explicit["open-queue"]; !ok {
+		c.openQueue = c.clients
+	}
+	if c.patternSpec != "" {
+		if c.rate > 0 {
+			return errors.New("-pattern replaces -rate, the rate of arrivals")
+		}
+		p, err := parsePattern(c.patternSpec)
+		if err != nil {
+			return err
+		}
+		// The mean rate of the pattern stands for -rate in the report.
+		c.pattern, c.rate = p, p.meanRate()
+	}
+	switch {
+	case c.rate <= 0:
+		return errors.New("-loop open requires -rate or -pattern, the rate arrivals are generated at")
+	case c.openQueue < 0:
+		return fmt.Errorf("-open-queue must not be negative, got %d", c.openQueue)
+	case c.pipeline > 1:
+		return errors.New("-pipeline does not apply to -loop open, which dispatches arrivals one at a time")
+	case c.trace != nil:
+		return errors.New("-loop open does not apply to -replay, which keeps the timing of the trace")
+	case c.workload == workloadQueue || c.workload == workloadScan:
+		return fmt.Errorf("-loop open does not apply to the %s workload, whose clients have roles of their own", c.workload)
+	}
+	return nil
+}
+
+// openLoop generates the arrivals of an open-loop run. The counters cover
+// the measured phase and are final once done is closed.
+type openLoop struct {
+	// start is the time the offsets of the arrivals count from.
+	start    time.Time
+	arrivals chan time.Time
+	// idle is the number of clients waiting for an arrival.
+	idle atomic.Int64
+	done chan struct{}
+
+	// generated counts the arrivals, of which delayed found every client
+	// busy and dropped found the queue full too.
+	generated, delayed, dropped int64
+	// unserved counts the arrivals still queued when the run ended.
+	unserved int64
+}
+
+// startOpenLoop starts generating arrivals at -rate, or following
+// -pattern, until runCtx is done or, under -ops, every client's share of
+// the measured operations arrived.
+func startOpenLoop(runCtx context.Context, st *runState) *openLoop {
+	clk := realClock{}
+	o := &openLoop{start: clk.Now(), arrivals: make(chan time.Time, st.cfg.openQueue), done: make(chan struct{})}
+	next := constantArrivals(st.cfg.rate)
+	if p := st.cfg.pattern; p != nil {
+		next = p.arrivals()
+	}
+	go o.run(runCtx, st, clk, next)
+	return o
+}
+
+// run sends the arrivals due at the offsets next returns from the start of
+// o.
+func (o *openLoop) run(runCtx context.Context, st *runState, clk clock, next func() time.Duration) {
+	defer close(o.done)
+	defer close(o.arrivals)
+	cfg := st.cfg
+	total := int64(cfg.clients) * int64(cfg.opsPerClient)
+	for {
+		at := o.start.Add(next())
+		if !clk.Sleep(runCtx, at.Sub(clk.Now())) {
+			return
+		}
+		st.live.arrivals.Add(1)
+		measuring := st.measuring.Load()
+		if measuring {
+			if cfg.duration == 0 && o.generated == total {
+				return
+			}
+			o.generated++
+		}
+		busy := o.idle.Load() == 0
+		select {
+		case o.arrivals <- at:
+			if busy && measuring {
+				o.delayed++
+			}
+		default:
+			if measuring {
+				o.dropped++
+			}
+		}
+	}
+}
+
+// next waits for the next arrival and returns its time, or false once the
+// run is over.
+func (o *openLoop) next(runCtx context.Context) (time.Time, bool) {
+	o.idle.Add(1)
+	defer o.idle.Add(-1)
+	select {
+	case at, ok := <-o.arrivals:
+		return at, ok
+	case <-runCtx.Done():
+		return time.Time{}, false
+	}
+}
+
+// stop waits for the generator, which stops with the run, and counts the
+// arrivals left in the queue.
+func (o *openLoop) stop() {
+	<-o.done
+	for range o.arrivals {
+		o.unserved++
+	}
+}
+
+// recordQueueDelay records how long an arrival of the open loop waited for
+// a client.
+func (r *workerResult) recordQueueDelay(d time.Duration) {
+	if r.queueDelay == nil {
+		r.queueDelay = r.layout.New()
+	}
+	r.queueDelay.Record(d)
+}
+
+// jsonOpenLoop is the -loop open section of the JSON report.
+type jsonOpenLoop struct {
+	// OfferedRate is the rate arrivals were generated at over the
+	// measured window, AchievedRate that of the completed operations.
+	OfferedRate  float64 `json:"offered_ops_per_sec"`
+	AchievedRate float64 `json:"achieved_ops_per_sec"`
+	Arrivals     int64   `json:"arrivals"`
+	// MaxInFlight is the cap on operations in flight, one per client, and
+	// Queue the arrivals that may wait for a client.
+	MaxInFlight int   `json:"max_in_flight"`
+	Queue       int   `json:"queue"`
+	Delayed     int64 `json:"delayed"`
+	Dropped     int64 `json:"dropped"`
+	Unserved    int64 `json:"unserved,omitempty"`
+	// Shed counts the arrivals -queue-timeout dropped.
+	Shed int64 `json:"shed,omitempty"`
+	// QueueDelay is the time from arrival to send.
+	QueueDelay *latencySummary `json:"queue_delay,omitempty"`
+	// Pattern is the -pattern of the arrivals, whose bursts split the
+	// latency of the operations sent in them from that of the others.
+	Pattern       string          `json:"pattern,omitempty"`
+	InBursts      *latencySummary `json:"in_bursts_latency,omitempty"`
+	BetweenBursts *latencySummary `json:"between_bursts_latency,omitempty"`
+}
+
+// buildOpenLoop summarises the arrivals of an open-loop run, nil for a
+// closed loop.
+func buildOpenLoop(cfg *config, res *runResult) *jsonOpenLoop {
+	o := res.open
+	if o == nil {
+		return nil
+	}
+	rep := &jsonOpenLoop{
+		Arrivals:     o.generated,
+		AchievedRate: throughput(res),
+		MaxInFlight:  cfg.clients,
+		Queue:        cfg.openQueue,
+		Delayed:      o.delayed,
+		Dropped:      o.dropped,
+		Unserved:     o.unserved,
+		Shed:         res.total.shed,
+		QueueDelay:   summarizeLatency(res.total.queueDelay),
+	}
+	if elapsed := res.elapsed(); elapsed > 0 {
+		rep.OfferedRate = float64(o.generated) / elapsed.Seconds()
+	}
+	if p := cfg.pattern; p != nil {
+		rep.Pattern = p.spec
+		rep.InBursts = summarizeLatency(res.total.inBursts)
+		rep.BetweenBursts = summarizeLatency(res.total.betweenBursts)
+	}
+	return rep
+}
+
+// printOpenLoop writes the -loop open section of the summary.
+func printOpenLoop(w io.Writer, u latencyUnit, cfg *config, res *runResult) {
+	rep := buildOpenLoop(cfg, res)
+	if rep == nil {
+		return
+	}
+	source := fmt.Sprintf("-rate %.0f", cfg.rate)
+	if cfg.pattern != nil {
+		source = fmt.Sprintf("-pattern %s, mean %.0f", cfg.pattern.spec, cfg.rate)
+	}
+	fmt.Fprintf(w, "Open loop: %d arrivals offered at %.0f ops/s (%s), achieved %.0f ops/s\n",
+		rep.Arrivals, rep.OfferedRate, source, rep.AchievedRate)
+	fmt.Fprintf(w, "  %d arrivals delayed by the cap of %d in flight, %d dropped by the full queue of %d",
+		rep.Delayed, rep.MaxInFlight, rep.Dropped, rep.Queue)
+	if rep.Unserved > 0 {
+		fmt.Fprintf(w, ", %d still queued at the end", rep.Unserved)
+	}
+	if rep.Shed > 0 {
+		fmt.Fprintf(w, ", %d shed after -queue-timeout %v", rep.Shed, cfg.queueTimeout)
+	}
+	fmt.Fprintln(w)
+	if res.total.queueDelay != nil {
+		printLatency(w, u, "Queue delay", res.total.queueDelay)
+	}
+	if p := cfg.pattern; p != nil && p.kind == patternBurst {
+		printBursts(w, u, cfg, res)
+	}
+}
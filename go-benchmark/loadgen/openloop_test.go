@@ -0,0 +1,100 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+)
+
+func TestOpenLoop(t *testing.T) {
+	addr := newTestServerAddr(t)
+	cfg := testConfig(t, "-addr", addr, "-loop", "open", "-rate", "2000", "-clients", "4", "-ops", "100")
+	if cfg.openQueue != 4 {
+		t.Fatalf("-open-queue defaulted to %d, want -clients", cfg.openQueue)
+	}
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := res.open
+	switch {
+	case o == nil:
+		t.Fatal("no open-loop accounting")
+	case o.generated != 400:
+		t.Errorf("%d arrivals, want 4 clients x 100 ops", o.generated)
+	case res.total.attempts()+o.dropped+o.unserved != o.generated:
+		t.Errorf("%d sent, %d dropped and %d unserved of %d arrivals", res.total.attempts(), o.dropped, o.unserved, o.generated)
+	case res.total.queueDelay == nil || res.total.queueDelay.Count() != res.total.attempts():
+		t.Errorf("queue delay of %v arrivals recorded", res.total.queueDelay)
+	}
+
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	for _, want := range []string{"Open loop: 400 arrivals offered", "Queue delay", "queue delay included"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, buf.String())
+		}
+	}
+	var rep struct {
+		OpenLoop *jsonOpenLoop `json:"open_loop"`
+	}
+	buf.Reset()
+	if err := writeJSON(&buf, buildReport(cfg, res)); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil || rep.OpenLoop == nil || rep.OpenLoop.Arrivals != 400 || rep.OpenLoop.MaxInFlight != 4 {
+		t.Errorf("JSON open-loop section %+v, %v", rep.OpenLoop, err)
+	}
+}
+
+func TestOpenLoopOverload(t *testing.T) {
+	mr := miniredis.RunT(t)
+	// A server taking 5ms an operation serves one client at 200 ops/s,
+	// a fifth of the offered rate.
+	mr.Server().SetPreHook(func(*server.Peer, string, ...string) bool {
+		time.Sleep(5 * time.Millisecond)
+		return false
+	})
+	cfg := testConfig(t, "-addr", mr.Addr(), "-loop", "open", "-rate", "1000", "-clients", "1",
+		"-open-queue", "2", "-duration", "300ms", "-preload", "0")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := res.open
+	if o.delayed == 0 || o.dropped < o.generated/2 {
+		t.Errorf("%d of %d arrivals delayed and %d dropped", o.delayed, o.generated, o.dropped)
+	}
+	if sent := res.total.attempts(); sent > o.generated/3 {
+		t.Errorf("%d of %d arrivals sent by one client of a 5ms server", sent, o.generated)
+	}
+	// The queue delay is part of the response time.
+	if q, r := res.total.queueDelay.Percentile(50), res.total.response.Percentile(50); q < time.Millisecond || r < q {
+		t.Errorf("median queue delay %v, response time %v", q, r)
+	}
+}
+
+func TestOpenLoopFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-loop", "half"},
+		{"-loop", "open"},
+		{"-open-queue", "8"},
+		{"-loop", "open", "-rate", "100", "-open-queue", "-1"},
+		{"-loop", "open", "-rate", "100", "-pipeline", "8"},
+		{"-loop", "open", "-rate", "100", "-workload", "queue"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	cfg := testConfig(t, "-loop", "open", "-rate", "100", "-clients", "8", "-open-queue", "0")
+	if cfg.loop != loopOpen || cfg.openQueue != 0 {
+		t.Errorf("loop %s with a queue of %d", cfg.loop, cfg.openQueue)
+	}
+}
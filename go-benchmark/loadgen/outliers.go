@@ -0,0 +1,191 @@
+package loadgen
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// outliersPrinted is the number of outliers the summary lists; the JSON
+// report has every one kept.
+const outliersPrinted = 20
+
+// validateOutliers checks -capture-outliers and its bound.
+func (c *config) validateOutliers() error {
+	if _, ok := c.explicit["capture-outliers-max"]; ok && c.captureOutliers == 0 {
+		return errors.New("-capture-outliers-max requires -capture-outliers")
+	}
+	switch {
+	case c.captureOutliers < 0:
+		return fmt.Errorf("-capture-outliers must not be negative, got %v", c.captureOutliers)
+	case c.outlierLimit < 1:
+		return fmt.Errorf("-capture-outliers-max must be at least 1, got %d", c.outlierLimit)
+	}
+	return nil
+}
+
+// outlier is an operation slower than -capture-outliers.
+type outlier struct {
+	slowOp
+	client int
+}
+
+// outlierHeap is a min-heap on latency, so the fastest of the outliers kept
+// is the one a slower outlier replaces.
+type outlierHeap []outlier
+
+func (h outlierHeap) Len() int           { return len(h) }
+func (h outlierHeap) Less(i, j int) bool { return h[i].latency < h[j].latency }
+func (h outlierHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *outlierHeap) Push(x any)        { *h = append(*h, x.(outlier)) }
+func (h *outlierHeap) Pop() any {
+	old := *h
+	o := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return o
+}
+
+// outlierSet keeps the limit slowest outliers of a client, or of the run
+// once merged, and counts all of them.
+type outlierSet struct {
+	limit int
+	// seen counts the outliers, including those a slower one displaced.
+	seen int64
+	kept outlierHeap
+}
+
+func newOutlierSet(limit int) *outlierSet {
+	return &outlierSet{limit: limit}
+}
+
+func (s *outlierSet) offer(o outlier) {
+	s.seen++
+	s.keep(o)
+}
+
+func (s *outlierSet) keep(o outlier) {
+	switch {
+	case len(s.kept) < s.limit:
+		heap.Push(&s.kept, o)
+	case o.latency > s.kept[0].latency:
+		s.kept[0] = o
+		heap.Fix(&s.kept, 0)
+	}
+}
+
+func (s *outlierSet) merge(o *outlierSet) {
+	s.seen += o.seen
+	for _, x := range o.kept {
+		s.keep(x)
+	}
+}
+
+// worst returns the outliers kept, slowest first.
+func (s *outlierSet) worst() []outlier {
+	out := append([]outlier(nil), s.kept...)
+	sort.Slice(out, func(i, j int) bool { return out[i].latency > out[j].latency })
+	return out
+}
+
+// recordOutlier keeps an operation slower than -capture-outliers.
+func (r *workerResult) recordOutlier(cfg *config, o outlier) {
+	if r.outliers == nil {
+		r.outliers = newOutlierSet(cfg.outlierLimit)
+	}
+	r.outliers.offer(o)
+}
+
+// annotateOutliers counts the outliers kept into the interval of the time
+// series their operation started in. Outliers before the first interval,
+// such as those of the ramp-up when the series leaves it out, or after the
+// last one are not counted.
+func annotateOutliers(points []timePoint, from float64, start time.Time, s *outlierSet) {
+	if s == nil {
+		return
+	}
+	for _, o := range s.kept {
+		t := o.at.Sub(start).Seconds()
+		if t < from {
+			continue
+		}
+		i := sort.Search(len(points), func(i int) bool { return points[i].T >= t })
+		if i < len(points) {
+			points[i].Outliers++
+		}
+	}
+}
+
+// jsonOutlier is one operation of the -capture-outliers section of the
+// JSON report.
+type jsonOutlier struct {
+	At time.Time `json:"at"`
+	// T is the start of the operation relative to the measured window, as
+	// in the time series.
+	T         float64 `json:"t"`
+	LatencyNs int64   `json:"latency_ns"`
+	Command   string  `json:"command"`
+	Key       string  `json:"key"`
+	Client    int     `json:"client"`
+}
+
+// jsonOutliers is the -capture-outliers section of the JSON report.
+type jsonOutliers struct {
+	Threshold string `json:"threshold"`
+	// Count is the number of outliers, of which the Limit slowest are
+	// listed in Outliers, slowest first.
+	Count    int64         `json:"count"`
+	Limit    int           `json:"limit"`
+	Outliers []jsonOutlier `json:"outliers"`
+}
+
+// buildOutliers returns the -capture-outliers section, nil when not
+// requested.
+func buildOutliers(cfg *config, res *runResult) *jsonOutliers {
+	if cfg.captureOutliers == 0 {
+		return nil
+	}
+	rep := &jsonOutliers{Threshold: cfg.captureOutliers.String(), Limit: cfg.outlierLimit, Outliers: []jsonOutlier{}}
+	s := res.total.outliers
+	if s == nil {
+		return rep
+	}
+	rep.Count = s.seen
+	for _, o := range s.worst() {
+		rep.Outliers = append(rep.Outliers, jsonOutlier{
+			At:        o.at,
+			T:         o.at.Sub(res.start).Seconds(),
+			LatencyNs: int64(o.latency),
+			Command:   o.op.String(),
+			Key:       o.key,
+			Client:    o.client,
+		})
+	}
+	return rep
+}
+
+// printOutliers writes the -capture-outliers section of the summary.
+func printOutliers(w io.Writer, cfg *config, res *runResult) {
+	if cfg.captureOutliers == 0 {
+		return
+	}
+	s := res.total.outliers
+	if s == nil {
+		fmt.Fprintf(w, "Outliers: no operation slower than %v\n", cfg.captureOutliers)
+		return
+	}
+	worst := s.worst()
+	fmt.Fprintf(w, "Outliers: %d operations slower than %v, %d kept", s.seen, cfg.captureOutliers, len(worst))
+	if len(worst) > outliersPrinted {
+		fmt.Fprintf(w, ", the %d slowest listed", outliersPrinted)
+		worst = worst[:outliersPrinted]
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "  %-30s %8s %12s %-8s %-8s %s\n", "at", "t", "latency", "client", "command", "key")
+	for _, o := range worst {
+		fmt.Fprintf(w, "  %-30s %7.3fs %12v %-8d %-8s %s\n",
+			o.at.Format(time.RFC3339Nano), o.at.Sub(res.start).Seconds(), o.latency, o.client, o.op, o.key)
+	}
+}
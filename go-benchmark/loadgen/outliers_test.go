@@ -0,0 +1,108 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+)
+
+func TestOutlierSet(t *testing.T) {
+	a, b := newOutlierSet(3), newOutlierSet(3)
+	for i, ms := range []int{5, 1, 9, 3, 7} {
+		a.offer(outlier{slowOp: slowOp{latency: time.Duration(ms) * time.Millisecond}, client: i})
+	}
+	b.offer(outlier{slowOp: slowOp{latency: 8 * time.Millisecond}, client: 9})
+	a.merge(b)
+	var got []time.Duration
+	for _, o := range a.worst() {
+		got = append(got, o.latency)
+	}
+	if a.seen != 6 || len(got) != 3 || got[0] != 9*time.Millisecond || got[1] != 8*time.Millisecond || got[2] != 7*time.Millisecond {
+		t.Errorf("kept %v of %d outliers, want the 9ms, 8ms and 7ms of 6", got, a.seen)
+	}
+
+	start := time.Now()
+	points := []timePoint{{T: 1}, {T: 2}, {T: 3}}
+	s := newOutlierSet(10)
+	for _, sec := range []float64{-0.5, 0.2, 1.5, 1.9, 2.9, 3.5} {
+		s.offer(outlier{slowOp: slowOp{latency: time.Second, at: start.Add(time.Duration(sec * float64(time.Second)))}})
+	}
+	annotateOutliers(points, 0, start, s)
+	if points[0].Outliers != 1 || points[1].Outliers != 2 || points[2].Outliers != 1 {
+		t.Errorf("outliers per interval %d, %d, %d, want 1, 2, 1", points[0].Outliers, points[1].Outliers, points[2].Outliers)
+	}
+}
+
+func TestCaptureOutliers(t *testing.T) {
+	mr := miniredis.RunT(t)
+	// Every 50th command takes 20ms.
+	var n atomic.Int64
+	mr.Server().SetPreHook(func(*server.Peer, string, ...string) bool {
+		if n.Add(1)%50 == 0 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return false
+	})
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-duration", "1500ms", "-preload", "0",
+		"-capture-outliers", "10ms", "-capture-outliers-max", "5")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := res.total.outliers
+	if s == nil || s.seen <= 5 || len(s.kept) != 5 {
+		t.Fatalf("outliers %+v, want 5 kept of more", s)
+	}
+	for _, o := range s.kept {
+		if o.latency <= 10*time.Millisecond || o.key == "" || o.client < 0 || o.client > 1 || o.at.Before(res.start) {
+			t.Errorf("outlier %+v", o)
+		}
+	}
+	var annotated int64
+	for _, p := range res.series {
+		annotated += p.Outliers
+	}
+	if annotated == 0 || annotated > 5 {
+		t.Errorf("%d outliers annotated on the time series", annotated)
+	}
+
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	for _, want := range []string{"operations slower than 10ms, 5 kept", "outliers\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, buf.String())
+		}
+	}
+	var rep struct {
+		Outliers *jsonOutliers `json:"outliers"`
+	}
+	buf.Reset()
+	if err := writeJSON(&buf, buildReport(cfg, res)); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil || rep.Outliers == nil || len(rep.Outliers.Outliers) != 5 || rep.Outliers.Count != s.seen {
+		t.Fatalf("JSON outliers section %+v, %v", rep.Outliers, err)
+	}
+	if o := rep.Outliers.Outliers; o[0].LatencyNs < o[4].LatencyNs || o[0].Command == "" {
+		t.Errorf("JSON outliers not slowest first: %+v", o)
+	}
+}
+
+func TestOutlierFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-capture-outliers", "-1ms"},
+		{"-capture-outliers", "5ms", "-capture-outliers-max", "0"},
+		{"-capture-outliers-max", "10"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package loadgen
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// pacer spaces operations evenly at a target aggregate rate shared by all
+// workers. Instead of a token bucket guarded by a mutex it hands out slots on
+// a fixed schedule: the n-th caller is due at start + n/rate. Claiming a slot
+// is a single atomic add, so the pacer does not serialise workers.
+type pacer struct {
+	clk      clock
+	start    time.Time
+	interval float64 // nanoseconds between slots
+	rate     float64
+	next     atomic.Int64
+}
+
+// newPacer returns a pacer issuing rate operations per second, starting now
+// according to clk.
+func newPacer(rate float64, clk clock) *pacer {
+	return &pacer{clk: clk, start: clk.Now(), interval: float64(time.Second) / rate, rate: rate}
+}
+
+// slotTime returns when slot n is due.
+func (p *pacer) slotTime(n int64) time.Time {
+	return p.start.Add(time.Duration(float64(n) * p.interval))
+}
+
+// wait claims the next slot and sleeps until it is due. It returns the
+// intended send time of the slot, or false when ctx was cancelled first.
+// When the caller is already behind schedule it returns immediately.
+func (p *pacer) wait(ctx context.Context) (time.Time, bool) {
+	intended := p.slotTime(p.next.Add(1) - 1)
+	return intended, p.clk.Sleep(ctx, intended.Sub(p.clk.Now()))
+}
+
+// backlog returns how many slots were due by now but not yet claimed, i.e.
+// how far the workers have fallen behind the requested rate.
+func (p *pacer) backlog(now time.Time) int64 {
+	due := int64(float64(now.Sub(p.start)) / p.interval)
+	if claimed := p.next.Load(); due > claimed {
+		return due - claimed
+	}
+	return 0
+}
@@ -0,0 +1,99 @@
+package loadgen
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+func TestPacerAggregateRate(t *testing.T) {
+	const rate = 2000
+	p := newPacer(rate, realClock{})
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var sent int
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, ok := p.wait(ctx); !ok {
+					return
+				}
+				mu.Lock()
+				sent++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 250ms at 2000 ops/s is 500 operations regardless of worker count.
+	if sent < 400 || sent > 600 {
+		t.Errorf("sent %d operations, want ~500", sent)
+	}
+}
+
+func TestPacerSlotsAndBacklog(t *testing.T) {
+	p := newPacer(1000, realClock{})
+	start := p.start
+	if got := p.slotTime(10).Sub(start); got != 10*time.Millisecond {
+		t.Errorf("slot 10 due after %v, want 10ms", got)
+	}
+	if got := p.backlog(start.Add(50 * time.Millisecond)); got != 50 {
+		t.Errorf("backlog = %d, want 50", got)
+	}
+}
+
+// fakeClock advances only when told to; Sleep returns immediately after
+// moving time forward, so a whole paced run can be simulated instantly.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) bool {
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+	return ctx.Err() == nil
+}
+
+func TestCoordinatedOmissionCorrection(t *testing.T) {
+	clk := &fakeClock{now: time.Unix(0, 0)}
+	p := newPacer(1000, clk) // one operation every millisecond
+	cfg := &config{rate: 1000}
+	result := newWorkerResult(stats.DefaultLayout)
+
+	for i := 0; i < 2000; i++ {
+		intended, _ := p.wait(context.Background())
+		start := clk.Now()
+		service := 100 * time.Microsecond
+		if i == 500 {
+			// The server stalls once for a full second.
+			service = time.Second
+		}
+		clk.now = clk.now.Add(service)
+		result.recordSuccess(cfg, &result.ops[opSet], intended, start, clk.Now())
+	}
+
+	// Service time only sees the single stalled request.
+	if got := result.latency.Percentile(99); got > time.Millisecond {
+		t.Errorf("service p99 = %v, want ~100µs", got)
+	}
+	// Every request scheduled during the stall waited behind it, which the
+	// response time measured from the intended send time must expose.
+	if got := result.response.Percentile(99); got < 900*time.Millisecond {
+		t.Errorf("response p99 = %v, want close to the 1s stall", got)
+	}
+	if got := result.response.Percentile(50); got < result.latency.Percentile(50) {
+		t.Errorf("response p50 %v below service p50 %v", got, result.latency.Percentile(50))
+	}
+}
@@ -0,0 +1,239 @@
+package loadgen
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Shapes of -pattern, the arrival rate of an open loop over time.
+const (
+	// patternBurst crams a count of arrivals into the start of every
+	// period: burst:10000x100ms/1s.
+	patternBurst = "burst"
+	// patternRamp raises the rate linearly from one rate to another over a
+	// duration, then keeps the last: ramp:1000..50000/5m.
+	patternRamp = "ramp"
+	// patternSine swings the rate around a mean:
+	// sine:mean=20000,amp=15000,period=60s.
+	patternSine = "sine"
+)
+
+// pattern is a parsed -pattern. Offsets count from the start of the
+// arrivals.
+type pattern struct {
+	spec string
+	kind string
+	// count arrivals in the first width of every period, for a burst.
+	count         int64
+	width, period time.Duration
+	// from and to are the rates of a ramp lasting over.
+	from, to float64
+	over     time.Duration
+	// mean and amp are the rates of a sine of period.
+	mean, amp float64
+}
+
+// parsePattern parses a -pattern spec.
+func parsePattern(spec string) (*pattern, error) {
+	kind, arg, ok := strings.Cut(strings.TrimSpace(spec), ":")
+	if !ok {
+		return nil, fmt.Errorf("-pattern %q: want %s:, %s: or %s: and its parameters", spec, patternBurst, patternRamp, patternSine)
+	}
+	p := &pattern{spec: spec, kind: kind}
+	var err error
+	switch kind {
+	case patternBurst:
+		err = p.parseBurst(arg)
+	case patternRamp:
+		err = p.parseRamp(arg)
+	case patternSine:
+		err = p.parseSine(arg)
+	default:
+		err = fmt.Errorf("unknown shape %q, want %s, %s or %s", kind, patternBurst, patternRamp, patternSine)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("-pattern %q: %w", spec, err)
+	}
+	return p, nil
+}
+
+// parseBurst parses COUNTxWIDTH/PERIOD.
+func (p *pattern) parseBurst(arg string) error {
+	burst, period, ok := strings.Cut(arg, "/")
+	count, width, ok2 := strings.Cut(burst, "x")
+	if !ok || !ok2 {
+		return errors.New("want COUNTxWIDTH/PERIOD, such as 10000x100ms/1s")
+	}
+	var err error
+	if p.count, err = strconv.ParseInt(strings.TrimSpace(count), 10, 64); err != nil || p.count < 1 {
+		return fmt.Errorf("burst count %q must be a positive integer", count)
+	}
+	if p.width, err = time.ParseDuration(strings.TrimSpace(width)); err != nil || p.width <= 0 {
+		return fmt.Errorf("burst width %q must be a positive duration", width)
+	}
+	if p.period, err = time.ParseDuration(strings.TrimSpace(period)); err != nil || p.period < p.width {
+		return fmt.Errorf("burst period %q must be a duration of at least the width %v", period, p.width)
+	}
+	return nil
+}
+
+// parseRamp parses FROM..TO/DURATION.
+func (p *pattern) parseRamp(arg string) error {
+	rates, over, ok := strings.Cut(arg, "/")
+	from, to, ok2 := strings.Cut(rates, "..")
+	if !ok || !ok2 {
+		return errors.New("want FROM..TO/DURATION, such as 1000..50000/5m")
+	}
+	var err error
+	if p.from, err = parsePatternRate(from); err != nil {
+		return err
+	}
+	if p.to, err = parsePatternRate(to); err != nil {
+		return err
+	}
+	if p.over, err = time.ParseDuration(strings.TrimSpace(over)); err != nil || p.over <= 0 {
+		return fmt.Errorf("ramp duration %q must be a positive duration", over)
+	}
+	return nil
+}
+
+// parseSine parses mean=RATE,amp=RATE,period=DURATION.
+func (p *pattern) parseSine(arg string) error {
+	seen := map[string]bool{}
+	for _, kv := range strings.Split(arg, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		k = strings.TrimSpace(k)
+		if !ok || seen[k] {
+			return fmt.Errorf("parameter %q: want mean=RATE,amp=RATE,period=DURATION once each", kv)
+		}
+		seen[k] = true
+		var err error
+		switch k {
+		case "mean":
+			p.mean, err = parsePatternRate(v)
+		case "amp":
+			p.amp, err = strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil || p.amp < 0 {
+				err = fmt.Errorf("amplitude %q must be a rate of at least 0", v)
+			}
+		case "period":
+			p.period, err = time.ParseDuration(strings.TrimSpace(v))
+			if err != nil || p.period <= 0 {
+				err = fmt.Errorf("period %q must be a positive duration", v)
+			}
+		default:
+			err = fmt.Errorf("unknown parameter %q, want mean, amp or period", k)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !seen["mean"] || !seen["amp"] || !seen["period"] {
+		return errors.New("want mean=RATE,amp=RATE,period=DURATION")
+	}
+	if p.amp >= p.mean {
+		// The rate would reach zero, where no arrival ever comes.
+		return fmt.Errorf("amplitude %v must be below the mean %v", p.amp, p.mean)
+	}
+	return nil
+}
+
+func parsePatternRate(s string) (float64, error) {
+	r, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || r <= 0 {
+		return 0, fmt.Errorf("rate %q must be a positive number of operations per second", s)
+	}
+	return r, nil
+}
+
+// meanRate returns the mean rate of the pattern over a period, or over
+// the ramp.
+func (p *pattern) meanRate() float64 {
+	switch p.kind {
+	case patternBurst:
+		return float64(p.count) / p.period.Seconds()
+	case patternRamp:
+		return (p.from + p.to) / 2
+	}
+	return p.mean
+}
+
+// rateAt returns the rate of the pattern at offset t, within a burst the
+// rate of the burst.
+func (p *pattern) rateAt(t time.Duration) float64 {
+	switch p.kind {
+	case patternBurst:
+		if p.inBurst(t) {
+			return float64(p.count) / p.width.Seconds()
+		}
+		return 0
+	case patternRamp:
+		if t >= p.over {
+			return p.to
+		}
+		return p.from + (p.to-p.from)*float64(t)/float64(p.over)
+	}
+	return p.mean + p.amp*math.Sin(2*math.Pi*float64(t)/float64(p.period))
+}
+
+// inBurst reports whether offset t falls within a burst.
+func (p *pattern) inBurst(t time.Duration) bool {
+	return p.kind == patternBurst && t%p.period < p.width
+}
+
+// arrivals returns the function giving the offsets of the successive
+// arrivals of the pattern. The arrivals of a burst are spread evenly
+// across its width; those of a ramp and a sine follow the rate at the
+// previous arrival.
+func (p *pattern) arrivals() func() time.Duration {
+	if p.kind == patternBurst {
+		var n int64
+		return func() time.Duration {
+			period, i := n/p.count, n%p.count
+			n++
+			return time.Duration(period)*p.period + time.Duration(float64(p.width)*float64(i)/float64(p.count))
+		}
+	}
+	var t float64
+	return func() time.Duration {
+		at := time.Duration(t)
+		t += float64(time.Second) / p.rateAt(at)
+		return at
+	}
+}
+
+// constantArrivals returns the offsets of arrivals at rate per second.
+func constantArrivals(rate float64) func() time.Duration {
+	var n int64
+	interval := float64(time.Second) / rate
+	return func() time.Duration {
+		at := time.Duration(float64(n) * interval)
+		n++
+		return at
+	}
+}
+
+// recordBurst records d into the in-burst or between-burst histogram.
+func (r *workerResult) recordBurst(in bool, d time.Duration) {
+	h := &r.betweenBursts
+	if in {
+		h = &r.inBursts
+	}
+	if *h == nil {
+		*h = r.layout.New()
+	}
+	(*h).Record(d)
+}
+
+// printBursts writes the latency of the operations that arrived in and
+// between the bursts of -pattern burst.
+func printBursts(w io.Writer, u latencyUnit, cfg *config, res *runResult) {
+	fmt.Fprintf(w, "Bursts of -pattern %s:\n", cfg.pattern.spec)
+	printLatency(w, u, "In bursts", res.total.inBursts)
+	printLatency(w, u, "Between bursts", res.total.betweenBursts)
+}
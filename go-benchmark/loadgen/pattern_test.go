@@ -0,0 +1,151 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+)
+
+func TestParsePattern(t *testing.T) {
+	for spec, want := range map[string]pattern{
+		"burst:10000x100ms/1s":                 {kind: patternBurst, count: 10000, width: 100 * time.Millisecond, period: time.Second},
+		"ramp:1000..50000/5m":                  {kind: patternRamp, from: 1000, to: 50000, over: 5 * time.Minute},
+		"sine:mean=20000,amp=15000,period=60s": {kind: patternSine, mean: 20000, amp: 15000, period: time.Minute},
+		" sine:period=1s, amp=0 ,mean=10":      {kind: patternSine, mean: 10, period: time.Second},
+		"ramp:50000..1000/30s":                 {kind: patternRamp, from: 50000, to: 1000, over: 30 * time.Second},
+		"burst:1x1s/1s":                        {kind: patternBurst, count: 1, width: time.Second, period: time.Second},
+	} {
+		p, err := parsePattern(spec)
+		if err != nil {
+			t.Errorf("%q: %v", spec, err)
+			continue
+		}
+		want.spec = spec
+		if *p != want {
+			t.Errorf("%q = %+v, want %+v", spec, *p, want)
+		}
+	}
+	for _, spec := range []string{
+		"", "burst", "square:1", "burst:10000/1s", "burst:0x100ms/1s", "burst:10x2s/1s", "burst:10x-1s/1s",
+		"ramp:1000/5m", "ramp:0..100/1m", "ramp:1..100/0s", "ramp:a..b/1m",
+		"sine:mean=100,amp=100,period=1s", "sine:mean=100,period=1s", "sine:mean=100,amp=10,period=1s,phase=2",
+		"sine:mean=100,mean=200,amp=10,period=1s", "sine:mean=100,amp=-1,period=1s",
+	} {
+		if _, err := parsePattern(spec); err == nil {
+			t.Errorf("%q was accepted", spec)
+		}
+	}
+}
+
+// offsets returns the first n offsets of next.
+func offsets(next func() time.Duration, n int) []time.Duration {
+	out := make([]time.Duration, n)
+	for i := range out {
+		out[i] = next()
+	}
+	return out
+}
+
+func TestPatternArrivals(t *testing.T) {
+	burst, _ := parsePattern("burst:4x100ms/1s")
+	got := offsets(burst.arrivals(), 6)
+	want := []time.Duration{0, 25 * time.Millisecond, 50 * time.Millisecond, 75 * time.Millisecond, time.Second, time.Second + 25*time.Millisecond}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("burst arrivals %v, want %v", got, want)
+		}
+	}
+	if !burst.inBurst(1050*time.Millisecond) || burst.inBurst(150*time.Millisecond) || burst.meanRate() != 4 {
+		t.Errorf("burst in %v, between %v, mean %v", burst.inBurst(1050*time.Millisecond), !burst.inBurst(150*time.Millisecond), burst.meanRate())
+	}
+
+	// A ramp from 100 to 300 ops/s over 10s offers the 2000 operations
+	// of its mean rate.
+	ramp, _ := parsePattern("ramp:100..300/10s")
+	next, n := ramp.arrivals(), 0
+	for next() < 10*time.Second {
+		n++
+	}
+	if n < 1990 || n > 2010 {
+		t.Errorf("ramp offered %d arrivals in 10s, want ~2000", n)
+	}
+	if r := ramp.rateAt(time.Minute); r != 300 {
+		t.Errorf("rate after the ramp %v", r)
+	}
+
+	// A sine offers its mean over a period, more in its first half.
+	sine, _ := parsePattern("sine:mean=1000,amp=500,period=2s")
+	next, first, second := sine.arrivals(), 0, 0
+	for at := next(); at < 2*time.Second; at = next() {
+		if at < time.Second {
+			first++
+		} else {
+			second++
+		}
+	}
+	if total := first + second; math.Abs(float64(total)-2000) > 20 || first <= second {
+		t.Errorf("sine offered %d then %d arrivals, want ~2000 with more in the first half", first, second)
+	}
+}
+
+func TestPatternBurstRun(t *testing.T) {
+	mr := miniredis.RunT(t)
+	// Two clients of a 2ms server serve 1000 ops/s, half the rate of the
+	// bursts: the second half of every burst is sent after it.
+	mr.Server().SetPreHook(func(*server.Peer, string, ...string) bool {
+		time.Sleep(2 * time.Millisecond)
+		return false
+	})
+	cfg := testConfig(t, "-addr", mr.Addr(), "-loop", "open", "-pattern", "burst:100x50ms/500ms", "-clients", "2",
+		"-open-queue", "200", "-duration", "1200ms", "-preload", "0")
+	if cfg.rate != 200 {
+		t.Fatalf("-rate %v, want the mean of the pattern", cfg.rate)
+	}
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, between := res.total.inBursts, res.total.betweenBursts
+	if in == nil || between == nil || in.Count()+between.Count() != res.total.latency.Count() {
+		t.Fatalf("in bursts %v, between %v", in, between)
+	}
+	if res.open.generated != 300 {
+		t.Errorf("%d arrivals in three bursts of 100", res.open.generated)
+	}
+	var offered int64
+	for _, p := range res.series {
+		offered += p.Offered
+	}
+	if offered != res.open.generated || len(res.series) < 2 {
+		t.Errorf("time series offered %v", res.series)
+	}
+
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	for _, want := range []string{"(-pattern burst:100x50ms/500ms, mean 200)", "In bursts latency", "Between bursts latency", "offered/s"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, buf.String())
+		}
+	}
+	if rep := buildOpenLoop(cfg, res); rep.Pattern != cfg.patternSpec || rep.InBursts == nil || rep.BetweenBursts == nil {
+		t.Errorf("open-loop report %+v", rep)
+	}
+}
+
+func TestPatternFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-pattern", "burst:10x10ms/1s"},
+		{"-loop", "open", "-rate", "100", "-pattern", "burst:10x10ms/1s"},
+		{"-loop", "open", "-pattern", "burst:10x10ms"},
+	} {
+		if _, err := parseFlags(append(args, "-clients", "2")); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package loadgen
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"hash/fnv"
+	"math/rand"
+)
+
+// A structured value starts with a header naming the key it was written
+// under, the writer and its sequence number, and the total length, then
+// carries the random filler of the value pool, whose CRC-32 closes the
+// header:
+//
+//	magic "hv" | key hash (8) | seq (8) | length (4) | filler CRC-32 (4) | filler
+//
+// seq holds the writer in its top 16 bits and the writer's own count in
+// the others, all big-endian. A value shorter than the header keeps its
+// size and holds as much of the magic and key hash as fits.
+const (
+	valueMagic      = "hv"
+	valueHeaderSize = 26
+	// preloadWriter is the writer of the values preloadKeys writes, apart
+	// from every client.
+	preloadWriter = 1<<16 - 1
+)
+
+// Kinds of structured values a decoder sees. An intact value is
+// valueIntact; the others describe how it is wrong.
+const (
+	valueIntact        = ""
+	valueUnstructured  = "unstructured"
+	valueTruncated     = "truncated"
+	valueCorruptFiller = "corrupt filler"
+	valueWrongKey      = "wrong key"
+	valueStale         = "stale"
+)
+
+// valueHeader is the decoded header of a structured value.
+type valueHeader struct {
+	keyHash uint64
+	writer  int
+	seq     uint64
+	length  int
+}
+
+// keyHash is the hash binding a value to its key.
+func keyHash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// shortHeader returns the magic and key hash a value shorter than the
+// header starts with.
+func shortHeader(key string) []byte {
+	return binary.BigEndian.AppendUint64([]byte(valueMagic), keyHash(key))
+}
+
+// encodeValue returns a structured value of len(filler) bytes for key,
+// taking its filler from the former.
+func encodeValue(key string, writer int, seq uint64, filler []byte) []byte {
+	v := bytes.Clone(filler)
+	if len(v) < valueHeaderSize {
+		copy(v, shortHeader(key))
+		return v
+	}
+	copy(v, valueMagic)
+	binary.BigEndian.PutUint64(v[2:], keyHash(key))
+	binary.BigEndian.PutUint64(v[10:], uint64(writer)<<48|seq&(1<<48-1))
+	binary.BigEndian.PutUint32(v[18:], uint32(len(v)))
+	binary.BigEndian.PutUint32(v[22:], crc32.ChecksumIEEE(v[valueHeaderSize:]))
+	return v
+}
+
+// decodeValue checks value as the structured value of key and returns its
+// header and valueIntact, or how it is wrong: not a structured value at
+// all, shorter than its header says, with a filler that fails its
+// checksum, or intact but written under another key. Of a value shorter
+// than the header only the magic and key hash that fit are checked, so
+// one cut below the header size passes as a short value.
+func decodeValue(value []byte, key string) (valueHeader, string) {
+	h := valueHeader{length: len(value)}
+	if len(value) < valueHeaderSize {
+		want := shortHeader(key)
+		n := min(len(value), len(want))
+		switch {
+		case !bytes.HasPrefix(value, want[:min(n, len(valueMagic))]):
+			return h, valueUnstructured
+		case !bytes.Equal(value[:n], want[:n]):
+			return h, valueWrongKey
+		}
+		return h, valueIntact
+	}
+	if string(value[:len(valueMagic)]) != valueMagic {
+		return h, valueUnstructured
+	}
+	h.keyHash = binary.BigEndian.Uint64(value[2:])
+	seq := binary.BigEndian.Uint64(value[10:])
+	h.writer, h.seq = int(seq>>48), seq&(1<<48-1)
+	h.length = int(binary.BigEndian.Uint32(value[18:]))
+	switch {
+	case len(value) < h.length:
+		return h, valueTruncated
+	case len(value) > h.length || crc32.ChecksumIEEE(value[valueHeaderSize:]) != binary.BigEndian.Uint32(value[22:]):
+		return h, valueCorruptFiller
+	case h.keyHash != keyHash(key):
+		return h, valueWrongKey
+	}
+	return h, valueIntact
+}
+
+// classifyValue describes how got, read back for key, differs from want,
+// the value last written there: decodeValue's kinds, or valueStale for an
+// intact earlier value of the same writer. It returns valueIntact when got
+// is a different intact value, as another writer may leave.
+func classifyValue(got, want []byte, key string) string {
+	if len(got) < len(want) && bytes.HasPrefix(want, got) {
+		return valueTruncated
+	}
+	h, kind := decodeValue(got, key)
+	if kind != valueIntact {
+		return kind
+	}
+	if w, wantKind := decodeValue(want, key); wantKind == valueIntact && w.writer == h.writer && h.seq < w.seq {
+		return valueStale
+	}
+	return valueIntact
+}
+
+// keyValue returns the value writer stores under key as its write number
+// seq, for the i-th operation: structured when drawn from the value pool,
+// unless -raw-values asks for the opaque pool bytes.
+func (c *config) keyValue(rng *rand.Rand, key string, writer int, seq uint64, i int) []byte {
+	return c.structure(c.nextValue(rng, i), key, writer, seq)
+}
+
+// structure returns the value keyValue makes of v, a payload of nextValue.
+// -large-values stores the value of the key instead.
+func (c *config) structure(v []byte, key string, writer int, seq uint64) []byte {
+	switch {
+	case c.large != nil:
+		return c.largeValue(key)
+	case !c.structured():
+		return v
+	}
+	return encodeValue(key, writer, seq, v)
+}
+
+// structured reports whether structure makes structured values of the
+// payloads of nextValue.
+func (c *config) structured() bool {
+	return c.large == nil && c.values != nil && !c.rawValues
+}
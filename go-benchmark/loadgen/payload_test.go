@@ -0,0 +1,100 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStructuredValueRoundTrip(t *testing.T) {
+	filler := make([]byte, 200)
+	rand.New(rand.NewSource(1)).Read(filler)
+	for _, size := range []int{0, 1, 2, 9, 10, valueHeaderSize - 1, valueHeaderSize, valueHeaderSize + 1, 200} {
+		v := encodeValue("k1", 7, 42, filler[:size])
+		if len(v) != size {
+			t.Fatalf("%d-byte value encoded to %d bytes", size, len(v))
+		}
+		h, kind := decodeValue(v, "k1")
+		if kind != valueIntact {
+			t.Errorf("%d-byte value decoded as %q", size, kind)
+		}
+		if size >= valueHeaderSize && (h.writer != 7 || h.seq != 42 || h.length != size) {
+			t.Errorf("%d-byte value has header %+v", size, h)
+		}
+		if size >= 3 {
+			if _, kind := decodeValue(v, "k2"); kind != valueWrongKey {
+				t.Errorf("%d-byte value of k1 read as k2 decoded as %q", size, kind)
+			}
+		}
+	}
+	orig := bytes.Clone(filler)
+	if encodeValue("k1", 0, 0, filler); !bytes.Equal(filler, orig) {
+		t.Error("encoding modified the filler, which the value pool shares")
+	}
+}
+
+func TestStructuredValueFailures(t *testing.T) {
+	filler := bytes.Repeat([]byte{0xab}, 100)
+	v := encodeValue("k", 1, 5, filler)
+	newer := encodeValue("k", 1, 6, filler)
+	other := encodeValue("k", 2, 9, filler)
+
+	corrupt := bytes.Clone(v)
+	corrupt[60] ^= 1
+	for _, c := range []struct {
+		name      string
+		got, want []byte
+		kind      string
+	}{
+		{"truncated", v[:50], v, valueTruncated},
+		{"cut in the header", v[:20], v, valueTruncated},
+		{"corrupt filler", corrupt, v, valueCorruptFiller},
+		{"overlong", append(bytes.Clone(v), 0), v, valueCorruptFiller},
+		{"wrong key", encodeValue("j", 1, 5, filler), v, valueWrongKey},
+		{"stale", v, newer, valueStale},
+		{"other writer", other, newer, valueIntact},
+		{"raw bytes", filler, v, valueUnstructured},
+	} {
+		if kind := classifyValue(c.got, c.want, "k"); kind != c.kind {
+			t.Errorf("%s: classified as %q, want %q", c.name, kind, c.kind)
+		}
+	}
+}
+
+func TestKeyValue(t *testing.T) {
+	rng := func() *rand.Rand { return rand.New(rand.NewSource(3)) }
+	cfg := testConfig(t, "-value-size", "64")
+	if _, kind := decodeValue(cfg.keyValue(rng(), "k", 0, 1, 0), "k"); kind != valueIntact {
+		t.Errorf("value decoded as %q", kind)
+	}
+	raw := testConfig(t, "-value-size", "64", "-raw-values")
+	if v := raw.keyValue(rng(), "k", 0, 1, 0); !bytes.Equal(v, raw.nextValue(rng(), 0)) {
+		t.Error("-raw-values does not write the pool bytes")
+	}
+	if v := testConfig(t).keyValue(rng(), "k", 0, 1, 7); string(v) != "value7" {
+		t.Errorf("unsized value %q", v)
+	}
+}
+
+func TestVerifyFinalDescribesCorruption(t *testing.T) {
+	mr, rdb := newTestServer(t)
+	cfg := testConfig(t, "-clients", "1", "-ops", "10", "-value-size", "100", "-key-prefix", "vp:", "-verify-final")
+	res := runBenchmark(context.Background(), rdb, cfg)
+	key := mr.Keys()[0]
+	v, _ := mr.Get(key)
+	mr.Set(key, v[:60])
+
+	rep := verifyFinal(context.Background(), rdb, cfg, res.total.writes, false)
+	out := filepath.Join(t.TempDir(), "final.txt")
+	if err := writeDiscrepancies(out, rep); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(out)
+	if rep.Corrupt != 1 || !strings.HasPrefix(string(data), "corrupt (truncated)\t"+key) {
+		t.Errorf("%d corrupt, discrepancies:\n%s", rep.Corrupt, data)
+	}
+}
@@ -0,0 +1,135 @@
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// clientOptions returns the go-redis options of the client of a run, its
+// connection pool included. -resilience turns the go-redis retries off, so
+// reconnecting follows -resilience-backoff alone.
+func (c *config) clientOptions() *redis.Options {
+	opt := &redis.Options{
+		Network:      c.network(),
+		Addr:         c.dialAddr(),
+		Password:     c.password,
+		DB:           c.db,
+		PoolSize:     c.poolSize,
+		MinIdleConns: c.minIdleConns,
+		PoolTimeout:  c.poolTimeout,
+		TLSConfig:    c.tlsConfig,
+	}
+	if c.resilience {
+		opt.MaxRetries = -1
+	}
+	if c.namer != nil {
+		opt.OnConnect = c.namer.onConnect
+	}
+	if c.large != nil {
+		// The read and write timeouts of go-redis, 3s by default, would cut
+		// large values short: they follow the operation timeout instead.
+		t := c.opTimeout
+		if t == 0 {
+			t = -1
+		}
+		opt.ReadTimeout, opt.WriteTimeout = t, t
+	}
+	return opt
+}
+
+// effectivePoolSize returns the number of connections the pool may open,
+// resolving the go-redis default of 10 per GOMAXPROCS.
+func (c *config) effectivePoolSize() int {
+	if c.poolSize > 0 {
+		return c.poolSize
+	}
+	return 10 * runtime.GOMAXPROCS(0)
+}
+
+// poolStarved reports whether more clients run than the pool has
+// connections, so some wait for a connection rather than for the server.
+// -churn dials outside the pool, and -resilience, -client raw and the
+// tracking workload give every client connections of its own.
+func (c *config) poolStarved() bool {
+	return c.usesPool() && c.effectivePoolSize() < c.clients
+}
+
+// usesPool reports whether the workload goes through the connection pool.
+func (c *config) usesPool() bool {
+	return !c.churn && !c.resilience && c.client != clientRaw && c.workload != workloadTracking
+}
+
+// validatePool checks the connection pool flags.
+func (c *config) validatePool() error {
+	if c.poolSize < 0 {
+		return fmt.Errorf("-pool-size must not be negative, got %d", c.poolSize)
+	}
+	if c.minIdleConns < 0 || c.minIdleConns > c.effectivePoolSize() {
+		return fmt.Errorf("-min-idle-conns must be between 0 and the pool size %d, got %d", c.effectivePoolSize(), c.minIdleConns)
+	}
+	if c.poolTimeout < 0 {
+		return fmt.Errorf("-pool-timeout must not be negative, got %v", c.poolTimeout)
+	}
+	return nil
+}
+
+// poolReport describes the connection pool over the run, warmup included.
+type poolReport struct {
+	Size         int   `json:"size"`
+	MinIdleConns int   `json:"min_idle_conns"`
+	TimeoutNs    int64 `json:"timeout_ns"`
+	Clients      int   `json:"clients"`
+	// Hits and Misses count the connections taken from the pool idle and
+	// those that had to be dialed; Timeouts the waits for a connection
+	// that gave up after -pool-timeout.
+	Hits     uint32 `json:"hits"`
+	Misses   uint32 `json:"misses"`
+	Timeouts uint32 `json:"timeouts"`
+	// StaleConns counts the idle connections closed by the pool.
+	StaleConns uint32 `json:"stale_conns"`
+	// TotalConns and IdleConns are the connections open and idle once the
+	// run was over.
+	TotalConns uint32 `json:"total_conns"`
+	IdleConns  uint32 `json:"idle_conns"`
+}
+
+// buildPool summarises the pool statistics between before and after, the
+// PoolStats taken around the run.
+func buildPool(cfg *config, before, after *redis.PoolStats) *poolReport {
+	timeout := cfg.poolTimeout
+	if timeout == 0 {
+		// The go-redis default: the read timeout plus a second.
+		timeout = 4 * time.Second
+	}
+	return &poolReport{
+		Size:         cfg.effectivePoolSize(),
+		MinIdleConns: cfg.minIdleConns,
+		TimeoutNs:    int64(timeout),
+		Clients:      cfg.clients,
+		Hits:         after.Hits - before.Hits,
+		Misses:       after.Misses - before.Misses,
+		Timeouts:     after.Timeouts - before.Timeouts,
+		StaleConns:   after.StaleConns - before.StaleConns,
+		TotalConns:   after.TotalConns,
+		IdleConns:    after.IdleConns,
+	}
+}
+
+// printPool writes the connection pool section of the summary.
+func printPool(w io.Writer, p *poolReport) {
+	fmt.Fprintf(w, "Connection pool: %d connections (min idle %d, timeout %v) for %d clients\n",
+		p.Size, p.MinIdleConns, time.Duration(p.TimeoutNs), p.Clients)
+	fmt.Fprintf(w, "Pool stats: %d hits, %d misses, %d timeouts, %d stale; %d connections open, %d idle at the end\n",
+		p.Hits, p.Misses, p.Timeouts, p.StaleConns, p.TotalConns, p.IdleConns)
+	if p.Size < p.Clients {
+		fmt.Fprintf(w, "WARNING: the pool of %d connections is smaller than the %d clients; latency includes waiting for a connection (raise -pool-size)\n",
+			p.Size, p.Clients)
+	}
+	if p.Timeouts > 0 {
+		fmt.Fprintf(w, "WARNING: %d operations timed out waiting for a pooled connection, not for the server\n", p.Timeouts)
+	}
+}
@@ -0,0 +1,54 @@
+package loadgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestPoolStats(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "4", "-ops", "50", "-pool-size", "2", "-min-idle-conns", "1", "-key-prefix", "pl:")
+	if !cfg.poolStarved() {
+		t.Error("4 clients on 2 connections not flagged")
+	}
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := res.pool
+	if p == nil {
+		t.Fatal("no pool report")
+	}
+	if p.Size != 2 || p.Clients != 4 || p.TotalConns > 2 {
+		t.Errorf("pool %+v, want at most 2 connections for 4 clients", p)
+	}
+	if got := p.Hits + p.Misses; got < 4*50 {
+		t.Errorf("%d connections taken from the pool for %d operations", got, 4*50)
+	}
+	if p.Timeouts != 0 {
+		t.Errorf("%d pool timeouts", p.Timeouts)
+	}
+}
+
+func TestPoolFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-pool-size", "-1"},
+		{"-pool-size", "5", "-min-idle-conns", "6"},
+		{"-pool-timeout", "-1s"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	cfg := testConfig(t, "-clients", "8", "-pool-size", "8")
+	if cfg.poolStarved() {
+		t.Error("a pool as large as the client count flagged as starved")
+	}
+	if s := cfg.forClients(16); s.poolSize != 8 {
+		t.Errorf("sweep step resized the -pool-size pool to %d", s.poolSize)
+	}
+}
@@ -0,0 +1,151 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// preloadBatch is the number of SETs pipelined per round trip during preload
+// when -pipeline does not ask for more.
+const preloadBatch = 100
+
+// preloadResult describes the fill phase that runs before the measured one.
+type preloadResult struct {
+	keys    int
+	written int64
+	failed  int64
+	elapsed time.Duration
+	// evicted is the growth of the server's evicted_keys counter across the
+	// fill, -1 when the server does not report it.
+	evicted int64
+	// oom is the first out-of-memory reply; preload stops when it is seen.
+	oom error
+}
+
+func (p *preloadResult) throughput() float64 {
+	if p.elapsed <= 0 {
+		return 0
+	}
+	return float64(p.written) / p.elapsed.Seconds()
+}
+
+// preloadKeys writes keys [0, cfg.preload) through cfg.keyName, the generator the
+// read workloads use, in pipelined batches spread over all clients. A
+// keyspace of hashes, sorted sets or sets is filled with whole hashes of
+// -hash-fields fields, sorted sets of -zset-members or sets of -set-members
+// members.
+func preloadKeys(ctx context.Context, rdb redis.UniversalClient, cfg *config) *preloadResult {
+	res := &preloadResult{keys: cfg.preload, evicted: -1}
+	evictedBefore, haveEvictions := evictedKeys(ctx, rdb)
+
+	batch := preloadBatch
+	if cfg.pipeline > batch {
+		batch = cfg.pipeline
+	}
+	batches := (cfg.preload + batch - 1) / batch
+
+	fillCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	var (
+		wg      sync.WaitGroup
+		written atomic.Int64
+		failed  atomic.Int64
+		oomOnce sync.Once
+	)
+	start := time.Now()
+	for w := 0; w < cfg.clients && w < batches; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(cfg.seed + int64(w)))
+			pipe := rdb.Pipeline()
+			cmds := make([]redis.Cmder, 0, batch)
+			for b := w; b < batches && fillCtx.Err() == nil; b += cfg.clients {
+				cmds = cmds[:0]
+				for i := b * batch; i < (b+1)*batch && i < cfg.preload; i++ {
+					switch {
+					case cfg.usesHashes():
+						cmds = append(cmds, pipe.HSet(fillCtx, cfg.keyName(i), cfg.hashValues(rng, i)...))
+					case cfg.usesSets():
+						cmds = append(cmds, pipe.SAdd(fillCtx, cfg.keyName(i), cfg.setMembers()...))
+					case cfg.usesZSets():
+						cmds = append(cmds, pipe.ZAdd(fillCtx, cfg.keyName(i), cfg.zsetMembers(rng)...))
+					case cfg.workload == workloadAppend:
+						// Values of the append workload are made of chunks.
+						key := cfg.keyName(i)
+						cmds = append(cmds, pipe.Set(fillCtx, key, cfg.appendChunk(key), 0))
+					default:
+						key := cfg.keyName(i)
+						cmds = append(cmds, pipe.Set(fillCtx, key, cfg.keyValue(rng, key, preloadWriter, uint64(i), i), 0))
+					}
+				}
+				_, _ = pipe.Exec(fillCtx)
+				for _, c := range cmds {
+					err := c.Err()
+					if err == nil {
+						written.Add(1)
+						continue
+					}
+					failed.Add(1)
+					if isOOM(err) {
+						oomOnce.Do(func() {
+							res.oom = err
+							stop()
+						})
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	res.elapsed = time.Since(start)
+	res.written = written.Load()
+	res.failed = failed.Load()
+	if haveEvictions {
+		if after, ok := evictedKeys(ctx, rdb); ok {
+			res.evicted = after - evictedBefore
+		}
+	}
+	return res
+}
+
+// isOOM reports whether err is the server refusing a write for lack of memory.
+func isOOM(err error) bool {
+	return strings.HasPrefix(err.Error(), "OOM ")
+}
+
+// evictedKeys reads evicted_keys from INFO stats. Servers that do not expose
+// it report ok == false.
+func evictedKeys(ctx context.Context, rdb redis.UniversalClient) (n int64, ok bool) {
+	info, err := rdb.Info(ctx, "stats").Result()
+	if err != nil {
+		return 0, false
+	}
+	v, found := parseInfo(info)["evicted_keys"]
+	if !found {
+		return 0, false
+	}
+	n, err = strconv.ParseInt(v, 10, 64)
+	return n, err == nil
+}
+
+// printPreload writes the fill phase summary.
+func printPreload(w io.Writer, p *preloadResult) {
+	fmt.Fprintf(w, "Preloaded %d of %d keys in %v (%.0f keys/s, %d failed)\n",
+		p.written, p.keys, p.elapsed.Round(time.Millisecond), p.throughput(), p.failed)
+	if p.evicted > 0 {
+		fmt.Fprintf(w, "WARNING: server evicted %d keys during preload; reads will miss\n", p.evicted)
+	}
+	if p.oom != nil {
+		fmt.Fprintf(w, "ERROR: server ran out of memory during preload: %v\n", p.oom)
+	}
+}
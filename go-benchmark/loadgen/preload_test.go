@@ -0,0 +1,34 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPreloadFillsKeyspace(t *testing.T) {
+	mr, rdb := newTestServer(t)
+	// An odd count leaves a short final batch.
+	cfg := testConfig(t, "-workload", "get", "-clients", "3", "-preload", "1001")
+
+	p := preloadKeys(context.Background(), rdb, cfg)
+
+	if p.written != 1001 || p.failed != 0 || p.oom != nil {
+		t.Errorf("preload = %+v, want 1001 written and no failures", p)
+	}
+	if got := len(mr.Keys()); got != 1001 {
+		t.Errorf("server holds %d keys, want 1001", got)
+	}
+	if !mr.Exists(cfg.keyName(0)) || !mr.Exists(cfg.keyName(1000)) {
+		t.Error("preloaded keys do not match the workload's key names")
+	}
+}
+
+func TestIsOOM(t *testing.T) {
+	if !isOOM(errors.New("OOM command not allowed when used memory > 'maxmemory'.")) {
+		t.Error("OOM reply not recognised")
+	}
+	if isOOM(errors.New("ERR wrong number of arguments")) {
+		t.Error("non-OOM reply classified as OOM")
+	}
+}
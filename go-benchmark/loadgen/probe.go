@@ -0,0 +1,185 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+// validateProbe checks the flags of -probe.
+func (c *config) validateProbe() error {
+	if c.probeInterval <= 0 {
+		return fmt.Errorf("-probe-interval must be positive, got %v", c.probeInterval)
+	}
+	return nil
+}
+
+// newProbeClient returns the client of the PING probe: a single connection
+// of its own, outside the pool of the workload. A cluster is probed on its
+// first seed.
+func newProbeClient(cfg *config) redis.UniversalClient {
+	if cfg.sentinelMaster != "" {
+		opt := cfg.clientOptions()
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    cfg.sentinelMaster,
+			SentinelAddrs: cfg.sentinels(),
+			Password:      opt.Password,
+			DB:            opt.DB,
+			PoolSize:      1,
+			TLSConfig:     opt.TLSConfig,
+		})
+	}
+	opt := cfg.clientOptions()
+	if cfg.cluster {
+		opt.Addr = cfg.seeds()[0]
+	}
+	opt.PoolSize = 1
+	opt.MinIdleConns = 0
+	return redis.NewClient(opt)
+}
+
+// probeSample is one PING of the probe.
+type probeSample struct {
+	at      time.Time
+	latency time.Duration
+	failed  bool
+}
+
+// probeMonitor PINGs the server at a low, fixed rate on its own
+// connection, showing what a lightly loaded client sees while the workload
+// runs. Its PINGs are not part of the workload's counts.
+type probeMonitor struct {
+	rdb     redis.UniversalClient
+	stopCh  chan struct{}
+	done    sync.WaitGroup
+	samples []probeSample
+}
+
+// startProbe starts PINGing every -probe-interval.
+func startProbe(cfg *config) *probeMonitor {
+	m := &probeMonitor{rdb: newProbeClient(cfg), stopCh: make(chan struct{})}
+	m.done.Add(1)
+	go func() {
+		defer m.done.Done()
+		t := time.NewTicker(cfg.probeInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				opCtx, cancel := context.Background(), func() {}
+				if cfg.opTimeout > 0 {
+					opCtx, cancel = context.WithTimeout(context.Background(), cfg.opTimeout)
+				}
+				start := time.Now()
+				err := m.rdb.Ping(opCtx).Err()
+				m.samples = append(m.samples, probeSample{at: start, latency: time.Since(start), failed: err != nil})
+				cancel()
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// stop ends probing and returns the samples.
+func (m *probeMonitor) stop() []probeSample {
+	close(m.stopCh)
+	m.done.Wait()
+	m.rdb.Close()
+	return m.samples
+}
+
+// probePoint is the probe over one interval of the time series, ending T
+// seconds into the measured window.
+type probePoint struct {
+	T       float64 `json:"t"`
+	Samples int     `json:"samples"`
+	Errors  int     `json:"errors"`
+	P99Ns   int64   `json:"p99_ns,omitempty"`
+	MaxNs   int64   `json:"max_ns,omitempty"`
+}
+
+// probeReport describes the PING probe of a run.
+type probeReport struct {
+	IntervalNs int64 `json:"interval_ns"`
+	Errors     int64 `json:"errors"`
+	// Latency covers the PINGs of the measured window, and WorkloadP99Ns
+	// is the p99 of the workload over the same window. A workload p99
+	// far above the probe's is queueing in the client pool, not in the
+	// server.
+	Latency       *latencySummary `json:"latency"`
+	WorkloadP99Ns int64           `json:"workload_p99_ns,omitempty"`
+	// Series covers the whole probe, warmup included, at negative times.
+	Series []probePoint `json:"series"`
+
+	latency *stats.Histogram
+}
+
+// buildProbe summarises the probe samples of the run res.
+func buildProbe(cfg *config, samples []probeSample, res *runResult) *probeReport {
+	rep := &probeReport{IntervalNs: int64(cfg.probeInterval), Series: []probePoint{}, latency: cfg.layout.New()}
+	if res.total.latency.Count() > 0 {
+		rep.WorkloadP99Ns = int64(res.total.latency.Percentile(99))
+	}
+	var bucket []time.Duration
+	var point *probePoint
+	flush := func() {
+		if point == nil {
+			return
+		}
+		if len(bucket) > 0 {
+			sort.Slice(bucket, func(i, j int) bool { return bucket[i] < bucket[j] })
+			point.P99Ns = int64(bucket[(len(bucket)*99-1)/100])
+			point.MaxNs = int64(bucket[len(bucket)-1])
+		}
+		rep.Series = append(rep.Series, *point)
+		bucket = bucket[:0]
+	}
+	for _, s := range samples {
+		t := s.at.Sub(res.start).Seconds()
+		end := (math.Floor(t/sampleInterval.Seconds()) + 1) * sampleInterval.Seconds()
+		if point == nil || end != point.T {
+			flush()
+			point = &probePoint{T: end}
+		}
+		point.Samples++
+		measured := !s.at.Before(res.start) && s.at.Before(res.end)
+		if s.failed {
+			point.Errors++
+			if measured {
+				rep.Errors++
+			}
+			continue
+		}
+		bucket = append(bucket, s.latency)
+		if measured {
+			rep.latency.Record(s.latency)
+		}
+	}
+	flush()
+	rep.Latency = summarizeLatency(rep.latency)
+	return rep
+}
+
+// printProbe writes the probe section of the summary.
+func printProbe(w io.Writer, u latencyUnit, rep *probeReport) {
+	fmt.Fprintf(w, "Probe: PING every %v on a connection of its own, %d errors\n", time.Duration(rep.IntervalNs), rep.Errors)
+	printLatency(w, u, "Probe", rep.latency)
+	if rep.Latency == nil || rep.WorkloadP99Ns == 0 {
+		return
+	}
+	fmt.Fprintf(w, "Workload p99 %v vs probe p99 %v", time.Duration(rep.WorkloadP99Ns), time.Duration(rep.Latency.P99Ns))
+	if rep.Latency.P99Ns > 0 {
+		fmt.Fprintf(w, " (%.1fx)", float64(rep.WorkloadP99Ns)/float64(rep.Latency.P99Ns))
+	}
+	fmt.Fprintln(w)
+}
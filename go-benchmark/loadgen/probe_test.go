@@ -0,0 +1,81 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"go-benchmark/stats"
+)
+
+func TestProbe(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-duration", "500ms", "-warmup", "200ms", "-key-prefix", "pr:",
+		"-probe", "-probe-interval", "20ms")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := res.probe
+	if p == nil || p.Latency == nil {
+		t.Fatal("no probe latency")
+	}
+	// 25 PINGs fall in the measured window; those of the warmup are only
+	// in the series.
+	if n := p.Latency.Count; n < 15 || n > 26 {
+		t.Errorf("%d probe samples in a 500ms window at 20ms", n)
+	}
+	if p.Errors != 0 || p.WorkloadP99Ns == 0 {
+		t.Errorf("probe %+v", p)
+	}
+	var probed int64
+	for _, pt := range p.Series {
+		probed += int64(pt.Samples)
+	}
+	if probed <= p.Latency.Count || p.Series[0].T > 0 {
+		t.Errorf("series %+v does not cover the warmup", p.Series)
+	}
+	for op := range res.total.ops {
+		if opType(op) != opSet && res.total.ops[op].attempts() > 0 {
+			t.Errorf("the probe was counted as %s operations", opType(op))
+		}
+	}
+
+	var buf bytes.Buffer
+	printProbe(&buf, latencyUnits[1], p)
+	if !strings.Contains(buf.String(), "vs probe p99") {
+		t.Errorf("summary lacks the comparison:\n%s", buf.String())
+	}
+}
+
+func TestProbeSeries(t *testing.T) {
+	start := time.Unix(100, 0)
+	res := &runResult{total: newWorkerResult(stats.DefaultLayout), start: start, end: start.Add(2 * time.Second)}
+	var samples []probeSample
+	for i := 0; i < 20; i++ {
+		samples = append(samples, probeSample{at: start.Add(time.Duration(i) * 100 * time.Millisecond), latency: time.Duration(i+1) * time.Millisecond})
+	}
+	samples = append(samples, probeSample{at: start.Add(1950 * time.Millisecond), failed: true})
+	cfg := testConfig(t, "-probe", "-probe-interval", "100ms")
+
+	p := buildProbe(cfg, samples, res)
+	if len(p.Series) != 2 {
+		t.Fatalf("series %+v, want two seconds", p.Series)
+	}
+	first, second := p.Series[0], p.Series[1]
+	if first.T != 1 || first.Samples != 10 || first.P99Ns != int64(10*time.Millisecond) {
+		t.Errorf("first second %+v", first)
+	}
+	if second.T != 2 || second.Samples != 11 || second.Errors != 1 || second.MaxNs != int64(20*time.Millisecond) {
+		t.Errorf("second second %+v", second)
+	}
+	if p.Errors != 1 || p.Latency.Count != 20 {
+		t.Errorf("probe %d errors, %d samples", p.Errors, p.Latency.Count)
+	}
+}
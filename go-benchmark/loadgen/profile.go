@@ -0,0 +1,182 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	httppprof "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+)
+
+// validateProfile checks -cpuprofile and -memprofile, whose profiles cover
+// the measured window of a single run.
+func (c *config) validateProfile() error {
+	if (c.cpuProfile != "" || c.memProfile != "") && (c.addr2 != "" || c.sweeping() || c.scenario != "") {
+		return errors.New("-cpuprofile and -memprofile profile single runs, not -addr2 comparisons, sweeps or -scenario; -pprof-addr serves them all")
+	}
+	return nil
+}
+
+// profiler profiles the tool itself: -cpuprofile and -memprofile cover
+// the measured window only, started and stopped with it so that preload and
+// warmup do not show, and -pprof-addr serves net/http/pprof for the whole
+// process.
+type profiler struct {
+	logger   *slog.Logger
+	cpu, mem *os.File
+	// memRate is the MemProfileRate of the process, restored by close;
+	// heap allocations are sampled only while the window is open.
+	memRate int
+	// started is set by start, in the goroutine switching to the measured
+	// window, and read by stop once runBenchmark has waited for the switch.
+	started bool
+	srv     *http.Server
+	// addr is the bound address of -pprof-addr.
+	addr string
+}
+
+// startProfiler creates the profile files and starts serving -pprof-addr;
+// it returns nil when no profiling was asked for.
+func startProfiler(cfg *config) (*profiler, error) {
+	if cfg.cpuProfile == "" && cfg.memProfile == "" && cfg.pprofAddr == "" {
+		return nil, nil
+	}
+	p := &profiler{logger: cfg.logger, memRate: runtime.MemProfileRate}
+	var err error
+	if cfg.cpuProfile != "" {
+		if p.cpu, err = os.Create(cfg.cpuProfile); err != nil {
+			return nil, fmt.Errorf("create CPU profile: %w", err)
+		}
+	}
+	if cfg.memProfile != "" {
+		if p.mem, err = os.Create(cfg.memProfile); err != nil {
+			p.close()
+			return nil, fmt.Errorf("create heap profile: %w", err)
+		}
+		runtime.MemProfileRate = 0
+	}
+	if cfg.pprofAddr != "" {
+		ln, err := net.Listen("tcp", cfg.pprofAddr)
+		if err != nil {
+			p.close()
+			return nil, fmt.Errorf("pprof listener: %w", err)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", httppprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", httppprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", httppprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", httppprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", httppprof.Trace)
+		p.addr = ln.Addr().String()
+		p.srv = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+		go func() {
+			if err := p.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				cfg.logger.Error("pprof server failed", "err", err)
+			}
+		}()
+		cfg.logger.Info("serving pprof", "url", "http://"+p.addr+"/debug/pprof/")
+	}
+	return p, nil
+}
+
+// start opens the profiles of the measured window as it opens.
+func (p *profiler) start() {
+	if p.mem != nil {
+		runtime.MemProfileRate = p.memRate
+	}
+	if p.cpu != nil {
+		if err := pprof.StartCPUProfile(p.cpu); err != nil {
+			p.logger.Error("cannot start the CPU profile", "err", err)
+		}
+	}
+	p.started = true
+}
+
+// stop closes the profiles of the measured window as it closes and writes
+// them. A run that ended during its warmup measured nothing, and leaves its
+// profiles empty.
+func (p *profiler) stop() {
+	if !p.started {
+		if p.cpu != nil || p.mem != nil {
+			p.logger.Warn("no measured window: profiles left empty")
+		}
+		return
+	}
+	if p.cpu != nil {
+		pprof.StopCPUProfile()
+	}
+	if p.mem != nil {
+		runtime.MemProfileRate = 0
+		// The heap profile is as of the last collection.
+		runtime.GC()
+		if err := pprof.Lookup("heap").WriteTo(p.mem, 0); err != nil {
+			p.logger.Error("cannot write the heap profile", "err", err)
+		}
+	}
+}
+
+// close closes the profile files and stops serving -pprof-addr. Closing
+// again does nothing.
+func (p *profiler) close() {
+	if p.mem != nil {
+		runtime.MemProfileRate = p.memRate
+	}
+	for _, f := range []*os.File{p.cpu, p.mem} {
+		if f != nil {
+			if err := f.Close(); err != nil {
+				p.logger.Error("cannot write profile", "err", err)
+			}
+		}
+	}
+	p.cpu, p.mem = nil, nil
+	if p.srv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = p.srv.Shutdown(ctx)
+		p.srv = nil
+	}
+}
+
+// jsonProfiling records the profiling of a run, which perturbs its
+// measurements: the profiler takes CPU from the workers and the heap
+// profile samples their allocations.
+type jsonProfiling struct {
+	CPUProfile string `json:"cpu_profile,omitempty"`
+	MemProfile string `json:"mem_profile,omitempty"`
+	PprofAddr  string `json:"pprof_addr,omitempty"`
+}
+
+func buildProfiling(cfg *config) *jsonProfiling {
+	p := cfg.profiler
+	if p == nil {
+		return nil
+	}
+	return &jsonProfiling{CPUProfile: cfg.cpuProfile, MemProfile: cfg.memProfile, PprofAddr: p.addr}
+}
+
+// printProfiling notes in the summary that the run was profiled.
+func printProfiling(w io.Writer, cfg *config) {
+	p := buildProfiling(cfg)
+	if p == nil {
+		return
+	}
+	var parts []string
+	if p.CPUProfile != "" {
+		parts = append(parts, "CPU to "+p.CPUProfile)
+	}
+	if p.MemProfile != "" {
+		parts = append(parts, "heap to "+p.MemProfile)
+	}
+	if p.PprofAddr != "" {
+		parts = append(parts, "pprof on "+p.PprofAddr)
+	}
+	fmt.Fprintf(w, "Profiling: %s (the measurements are perturbed)\n", strings.Join(parts, ", "))
+}
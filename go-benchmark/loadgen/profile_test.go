@@ -0,0 +1,87 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"go-benchmark/stats"
+)
+
+func TestProfileMeasuredWindow(t *testing.T) {
+	addr := newTestServerAddr(t)
+	dir := t.TempDir()
+	cpu, mem := filepath.Join(dir, "cpu.prof"), filepath.Join(dir, "mem.prof")
+	cfg := testConfig(t, "-addr", addr, "-clients", "2", "-warmup", "100ms", "-duration", "200ms",
+		"-cpuprofile", cpu, "-memprofile", mem, "-pprof-addr", "127.0.0.1:0")
+	rate := runtime.MemProfileRate
+	p, err := startProfiler(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.profiler = p
+	defer p.close()
+	// Allocations are sampled only in the measured window.
+	if runtime.MemProfileRate != 0 {
+		t.Errorf("MemProfileRate %d before the run", runtime.MemProfileRate)
+	}
+
+	resp, err := http.Get("http://" + p.addr + "/debug/pprof/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("pprof index: %s", resp.Status)
+	}
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.started || runtime.MemProfileRate != 0 {
+		t.Errorf("profiles started %v, MemProfileRate %d after the window", p.started, runtime.MemProfileRate)
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if want := "Profiling: CPU to " + cpu + ", heap to " + mem + ", pprof on " + p.addr; !strings.Contains(buf.String(), want) {
+		t.Errorf("summary lacks %q:\n%s", want, buf.String())
+	}
+	if rep := buildReport(cfg, res); rep.Profiling == nil || rep.Profiling.CPUProfile != cpu || rep.Profiling.PprofAddr != p.addr {
+		t.Errorf("JSON profiling %+v", rep.Profiling)
+	}
+
+	p.close()
+	if runtime.MemProfileRate != rate {
+		t.Errorf("MemProfileRate %d after close, want %d", runtime.MemProfileRate, rate)
+	}
+	for _, path := range []string{cpu, mem} {
+		// Profiles are gzipped protocol buffers.
+		b, err := os.ReadFile(path)
+		if err != nil || len(b) < 2 || b[0] != 0x1f || b[1] != 0x8b {
+			t.Errorf("%s: %d bytes, %v", path, len(b), err)
+		}
+	}
+}
+
+func TestProfileFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-cpuprofile", "cpu.prof", "-addr2", "localhost:6380"},
+		{"-memprofile", "mem.prof", "-sweep-clients", "1,2"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	if p, err := startProfiler(testConfig(t)); p != nil || err != nil {
+		t.Errorf("profiler %v, %v without profiling flags", p, err)
+	}
+	if rep := buildReport(testConfig(t), &runResult{total: newWorkerResult(stats.DefaultLayout)}); rep.Profiling != nil {
+		t.Errorf("unprofiled run reports %+v", rep.Profiling)
+	}
+}
@@ -0,0 +1,67 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// isTerminal reports whether w is a file attached to a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// reportProgress prints a status line every interval until ctx is done. On a
+// terminal the line is rewritten in place; otherwise each update is a plain
+// log line so CI logs stay readable. It reads only the atomic live counters.
+func reportProgress(ctx context.Context, w io.Writer, tty bool, st *runState, interval time.Duration) {
+	start := time.Now()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	live := st.live
+	lastOps, lastT := live.ops.Load(), start
+	lastLatency := live.latency.snapshot()
+	window := newSlidingWindow(st.cfg.percentileWindow, interval)
+	for {
+		select {
+		case <-ctx.Done():
+			if tty {
+				fmt.Fprintln(w)
+			}
+			return
+		case now := <-t.C:
+			ops, errs := live.ops.Load(), live.errors.Load()
+			latency := live.latency.snapshot()
+			rate := float64(ops-lastOps) / now.Sub(lastT).Seconds()
+			delta := latency.since(lastLatency)
+			p99 := delta.percentile(99)
+
+			phase := ""
+			if !st.measuring.Load() {
+				phase = " [warmup]"
+			}
+			line := fmt.Sprintf("%7.1fs%s  ops %d  %.0f ops/s  p99 %v", now.Sub(start).Seconds(), phase, ops, rate, p99)
+			if window != nil {
+				window.add(delta)
+				line += fmt.Sprintf("  p50/%v %v  p99/%v %v", st.cfg.percentileWindow, window.percentile(50),
+					st.cfg.percentileWindow, window.percentile(99))
+			}
+			line += fmt.Sprintf("  errors %d", errs)
+			if tty {
+				// Pad so a shorter line fully overwrites the previous one.
+				fmt.Fprintf(w, "\r%-110s", line)
+			} else {
+				fmt.Fprintln(w, line)
+			}
+			lastOps, lastT, lastLatency = ops, now, latency
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Protocols of -protocol: the server's RESP front end through go-redis, or
+// another front end through its Target.
+const (
+	protocolRedis    = "redis"
+	protocolMemcache = "memcache"
+	protocolHTTP     = "http"
+)
+
+// Target is a server the workload runs against through a front end other
+// than RESP. The workers share one, so implementations must be safe for
+// concurrent use.
+type Target interface {
+	// Set writes value under key, to expire after ttl when it is positive.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Get returns the value of key and whether the key exists.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Del removes key and reports whether it existed.
+	Del(ctx context.Context, key string) (bool, error)
+	// Expire sets the TTL of key and reports whether it existed.
+	Expire(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// MGet returns the values of keys in their order, nil for a missing
+	// key.
+	MGet(ctx context.Context, keys []string) ([][]byte, error)
+	// Capabilities returns what the target supports beyond Set and Get.
+	Capabilities() Capability
+	Close() error
+}
+
+// Capability is a feature of a Target beyond Set and Get.
+type Capability uint
+
+const (
+	// CapDel is set when Del removes keys.
+	CapDel Capability = 1 << iota
+	// CapTTL is set when Set honours a TTL.
+	CapTTL
+	// CapExpire is set when Expire sets the TTL of a key.
+	CapExpire
+	// CapMGet is set when MGet reads many keys in one command.
+	CapMGet
+)
+
+// protocolCaps are the capabilities of the targets of -protocol, known
+// before connecting so a workload the target cannot run fails at once.
+// The go-redis worker of redis runs every command, beyond what a Target
+// expresses.
+var protocolCaps = map[string]Capability{
+	protocolRedis:    CapDel | CapTTL | CapExpire | CapMGet,
+	protocolMemcache: CapDel | CapTTL | CapExpire | CapMGet,
+	protocolHTTP:     CapTTL,
+}
+
+// protocolOnlyFlags are the options of the targets of -protocol other than
+// redis.
+var protocolOnlyFlags = map[string][]string{
+	protocolMemcache: memcacheOnlyFlags,
+	protocolHTTP:     httpOnlyFlags,
+}
+
+// targetOps are the commands a Target sends, with the capability each
+// needs.
+var targetOps = map[opType]Capability{opSet: 0, opGet: 0, opDel: CapDel, opExpire: CapExpire, opMGet: CapMGet}
+
+// capabilityError is a workload calling for what the -protocol target does
+// not support.
+type capabilityError struct {
+	protocol string
+	what     string
+}
+
+func (e *capabilityError) Error() string {
+	caps := protocolCaps[e.protocol]
+	var ops []string
+	for op := opType(0); op < numOpTypes; op++ {
+		if need, ok := targetOps[op]; ok && caps&need == need {
+			ops = append(ops, opNames[op])
+		}
+	}
+	last := len(ops) - 1
+	return fmt.Sprintf("the %s target does not support %s: it runs %s and %s workloads", e.protocol, e.what, strings.Join(ops[:last], ", "), ops[last])
+}
+
+// redisOnlyFlags are the flags that need the RESP front end through
+// go-redis, its pipelines, pool or server commands.
+var redisOnlyFlags = []string{
+	"cluster", "sentinel-addrs", "sentinel-master", "password", "db", "dbs", "client", "resp", "resp2",
+	"pipeline", "min-idle-conns", "pool-timeout", "verify", "verify-final", "verify-expiry", "evict-pressure",
+	"capture-info", "probe", "collect-slowlog", "watch-notifications", "ttl-sample", "shadow", "cleanup",
+	"churn", "resilience", "retries", "replay", "record", "large-values", "memory-budget",
+}
+
+// validateProtocol checks -protocol and -protocol2 and that the workload
+// only needs what their targets support.
+func (c *config) validateProtocol() error {
+	if c.protocol2 != "" && c.addr2 == "" {
+		return errors.New("-protocol2 requires -addr2, the address of the second front end")
+	}
+	used := make(map[string]bool)
+	for _, p := range []struct {
+		flag     string
+		protocol string
+	}{{"-protocol", c.protocol}, {"-protocol2", c.protocol2}} {
+		if p.protocol == "" {
+			continue
+		}
+		if err := c.validateTargetProtocol(p.flag, p.protocol); err != nil {
+			return err
+		}
+		used[p.protocol] = true
+	}
+	for protocol, names := range protocolOnlyFlags {
+		if used[protocol] {
+			continue
+		}
+		for _, name := range names {
+			if _, ok := c.explicit[name]; ok {
+				return fmt.Errorf("-%s requires -protocol %s or -protocol2 %s", name, protocol, protocol)
+			}
+		}
+	}
+	return nil
+}
+
+// validateTargetProtocol checks the protocol of the target of flag.
+func (c *config) validateTargetProtocol(flag, protocol string) error {
+	caps, ok := protocolCaps[protocol]
+	if !ok {
+		return fmt.Errorf("%s must be %s, %s or %s, got %q", flag, protocolRedis, protocolMemcache, protocolHTTP, protocol)
+	}
+	if protocol == protocolRedis {
+		return nil
+	}
+	for _, name := range redisOnlyFlags {
+		if _, ok := c.explicit[name]; ok {
+			return fmt.Errorf("-%s requires %s redis", name, flag)
+		}
+	}
+	ops := []opType{opSet}
+	switch {
+	case c.mix != nil:
+		ops = c.mix.ops
+	case c.workload != workloadSet:
+		return &capabilityError{protocol, "the " + c.workload + " workload"}
+	}
+	for _, op := range ops {
+		need, ok := targetOps[op]
+		if !ok || caps&need != need {
+			return &capabilityError{protocol, opNames[op]}
+		}
+	}
+	if c.ttlMax > 0 && caps&CapTTL == 0 {
+		return &capabilityError{protocol, "-ttl"}
+	}
+	switch protocol {
+	case protocolMemcache:
+		return c.validateMemcache()
+	case protocolHTTP:
+		return c.validateHTTP()
+	}
+	return nil
+}
+
+// newTarget returns the Target of the -protocol of cfg, one other than
+// redis, which runs on the go-redis worker.
+func newTarget(cfg *config) Target {
+	if cfg.protocol == protocolHTTP {
+		return newHTTPTarget(cfg)
+	}
+	return newMemcacheTarget(cfg)
+}
+
+// runProtocol preloads and benchmarks the -protocol target of cfg, the
+// counterpart of runTarget for the front ends without RESP.
+func runProtocol(rootCtx context.Context, cfg *config) (*runResult, error) {
+	t := newTarget(cfg)
+	defer t.Close()
+	// A GET of a key the run never writes checks the target answers.
+	checkCtx, cancel := context.WithTimeout(rootCtx, pingTimeout)
+	_, _, err := t.Get(checkCtx, cfg.keyPrefix+"reachable")
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("%s: cannot reach the server: %w", cfg.addr, err)
+	}
+
+	var preload *preloadResult
+	if cfg.usesKeyspace() && cfg.preload > 0 {
+		preload = preloadTarget(rootCtx, t, cfg)
+		printPreload(cfg.logWriter(slog.LevelInfo), preload)
+	}
+	var conns *httpConnReport
+	ht, _ := t.(*httpTarget)
+	if ht != nil {
+		conns = ht.connReport()
+	}
+	cfg.target = t
+	defer func() { cfg.target = nil }()
+	res := runBenchmark(rootCtx, nil, cfg)
+	res.preload = preload
+	if ht != nil {
+		res.httpConns = ht.connReport().since(conns)
+	}
+	return res, nil
+}
+
+// preloadTarget writes keys [0, cfg.preload) like preloadKeys, one SET at a
+// time from each of the clients.
+func preloadTarget(ctx context.Context, t Target, cfg *config) *preloadResult {
+	res := &preloadResult{keys: cfg.preload, evicted: -1}
+	var (
+		wg      sync.WaitGroup
+		written atomic.Int64
+		failed  atomic.Int64
+	)
+	start := time.Now()
+	for w := 0; w < cfg.clients && w < cfg.preload; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(cfg.seed + int64(w)))
+			for i := w; i < cfg.preload && ctx.Err() == nil; i += cfg.clients {
+				key := cfg.keyName(i)
+				if err := t.Set(ctx, key, cfg.keyValue(rng, key, preloadWriter, uint64(i), i), 0); err != nil {
+					failed.Add(1)
+					continue
+				}
+				written.Add(1)
+			}
+		}(w)
+	}
+	wg.Wait()
+	res.elapsed = time.Since(start)
+	res.written = written.Load()
+	res.failed = failed.Load()
+	return res
+}
+
+// issueTarget sends one operation of type op to the -protocol target.
+func (w *worker) issueTarget(ctx context.Context, op opType) pendingOp {
+	cfg := w.run.cfg
+	key, hot := w.hotKey()
+	var p pendingOp
+	switch op {
+	case opGet, opDel:
+		if !hot {
+			key = cfg.keyName(w.keys.Next())
+		}
+		p = pendingOp{op: op, key: key, hot: hot}
+	case opExpire:
+		ttl := cfg.expireTTLMin
+		if spread := cfg.expireTTLMax - cfg.expireTTLMin; spread > 0 {
+			ttl += time.Duration(w.rng.Int63n(int64(spread) + 1))
+		}
+		if !hot {
+			key = cfg.keyName(w.keys.Next())
+		}
+		p = pendingOp{op: op, key: key, hot: hot}
+		p.found, p.rawErr = cfg.target.Expire(ctx, key, ttl)
+		return p
+	case opMGet:
+		keys := make([]string, cfg.batchKeys)
+		for i := range keys {
+			// The first key is the one drawn above.
+			if i > 0 {
+				key, hot = w.hotKey()
+			}
+			if !hot {
+				key = cfg.keyName(w.keys.Next())
+			}
+			keys[i], p.hot = key, p.hot || hot
+		}
+		p.op, p.key = op, keys[0]
+		p.values, p.rawErr = cfg.target.MGet(ctx, keys)
+		return p
+	default:
+		s := w.nextSet(key, hot)
+		p = pendingOp{op: op, bytes: len(s.value), key: s.key, ttl: s.ttl, hot: hot}
+		w.keepSet(&p, s)
+		p.rawErr = cfg.target.Set(ctx, s.key, s.value, s.ttl)
+		return p
+	}
+	if op == opGet {
+		var v []byte
+		v, p.found, p.rawErr = cfg.target.Get(ctx, key)
+		p.replySize = len(v)
+	} else {
+		p.found, p.rawErr = cfg.target.Del(ctx, key)
+	}
+	return p
+}
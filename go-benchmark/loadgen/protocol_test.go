@@ -0,0 +1,56 @@
+package loadgen
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateProtocol(t *testing.T) {
+	for _, args := range [][]string{
+		{"-protocol", "ftp"},
+		{"-protocol", "memcache", "-pipeline", "4"},
+		{"-protocol", "memcache", "-client", "raw"},
+		{"-protocol", "memcache", "-key-size", "300"},
+		{"-protocol", "memcache", "-key-prefix", "bench run:"},
+		{"-protocol", "memcache", "-value-size", "2000000"},
+		{"-protocol", "memcache", "-memcache-max-value", "100", "-value-size-range", "10:200"},
+		{"-protocol", "memcache", "-memcache-flags", "4294967296"},
+		{"-protocol2", "memcache"},
+		{"-addr2", "localhost:11211", "-protocol2", "memcache", "-pipeline", "4"},
+		{"-memcache-noreply"},
+		{"-protocol", "http", "-http2"},
+		{"-protocol", "http", "-http-ttl", "cookie"},
+		{"-protocol", "http", "-http-path", "keys"},
+		{"-http-max-idle", "10"},
+	} {
+		if _, err := parseFlags(append(args, "-clients", "2")); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	for _, args := range [][]string{
+		{"-protocol", "memcache", "-workload", "hash"},
+		{"-protocol", "memcache", "-ratio", "get=0.5,incr=0.5"},
+		{"-protocol", "memcache", "-workload", "queue"},
+		{"-addr2", "localhost:11211", "-protocol2", "memcache", "-workload", "hash"},
+		{"-protocol", "http", "-workload", "del"},
+		{"-protocol", "http", "-ratio", "get=0.5,mget=0.5"},
+	} {
+		_, err := parseFlags(append(args, "-clients", "2"))
+		var capErr *capabilityError
+		if !errors.As(err, &capErr) {
+			t.Errorf("%v: %v, want a capability error", args, err)
+		}
+	}
+	for _, args := range [][]string{
+		{"-protocol", "memcache", "-ttl", "1s"},
+		{"-protocol", "memcache", "-workload", "mget", "-memcache-noreply"},
+		{"-protocol", "memcache", "-memcache-max-value", "0", "-value-size", "2000000"},
+		{"-addr2", "localhost:11211", "-protocol2", "memcache", "-ratio", "get=0.5,expire=0.5"},
+		{"-protocol", "http", "-ratio", "get=0.9,set=0.1", "-ttl", "10s", "-http-max-idle", "8"},
+		{"-protocol", "http", "-http2", "-tls", "-tls-skip-verify"},
+	} {
+		if _, err := parseFlags(append(args, "-clients", "2")); err != nil {
+			t.Errorf("%v: %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+// workloadPubSub has every client PUBLISH to -pubsub-channels channels, each
+// of them followed by -pubsub-subscribers subscriber connections.
+const workloadPubSub = "pubsub"
+
+// channelName returns the name of channel i.
+func (c *config) channelName(i int) string {
+	return c.keyPrefix + "channel" + strconv.Itoa(i)
+}
+
+// validatePubSub checks the flags of the pubsub workload.
+func (c *config) validatePubSub() error {
+	if c.pubsubChannels <= 0 {
+		return fmt.Errorf("-pubsub-channels must be positive, got %d", c.pubsubChannels)
+	}
+	if c.pubsubSubscribers <= 0 {
+		return fmt.Errorf("-pubsub-subscribers must be positive, got %d", c.pubsubSubscribers)
+	}
+	if c.pubsubDrain < 0 {
+		return fmt.Errorf("-pubsub-drain must not be negative, got %v", c.pubsubDrain)
+	}
+	return nil
+}
+
+// pubTally counts the messages a publisher sent per channel, warmup
+// included, and the PUBLISHes that failed, which may or may not have been
+// delivered.
+type pubTally struct {
+	published []int64
+	failed    int64
+}
+
+func (t *pubTally) merge(o *pubTally) {
+	if t.published == nil {
+		t.published = make([]int64, len(o.published))
+	}
+	for i, n := range o.published {
+		t.published[i] += n
+	}
+	t.failed += o.failed
+}
+
+// issuePublish publishes the next message of the worker to a random
+// channel. A message is "<publisher> <seq> <unixnano> <value>", where seq
+// numbers the publisher's messages on that channel from 1 so subscribers can
+// tell gaps and reordering.
+func (w *worker) issuePublish(ctx context.Context, c redis.Cmdable) pendingOp {
+	cfg := w.run.cfg
+	ch := w.rng.Intn(cfg.pubsubChannels)
+	w.pubSeq[ch]++
+	msg := strconv.AppendInt(nil, int64(w.id), 10)
+	msg = strconv.AppendInt(append(msg, ' '), w.pubSeq[ch], 10)
+	msg = strconv.AppendInt(append(msg, ' '), time.Now().UnixNano(), 10)
+	msg = append(append(msg, ' '), cfg.nextValue(w.rng, w.seq)...)
+	key := cfg.channelName(ch)
+	return pendingOp{op: opPublish, cmd: c.Publish(ctx, key, msg), bytes: len(msg), key: key, counter: ch}
+}
+
+// recordPublish tallies a completed PUBLISH.
+func (w *worker) recordPublish(p pendingOp, err error) {
+	if err != nil {
+		w.pub.failed++
+		return
+	}
+	w.pub.published[p.counter]++
+}
+
+// parseMessage splits a published message into its publisher, sequence
+// number and send time.
+func parseMessage(msg string) (publisher int, seq int64, sent time.Time, ok bool) {
+	fields := strings.SplitN(msg, " ", 4)
+	if len(fields) < 3 {
+		return 0, 0, time.Time{}, false
+	}
+	publisher, err1 := strconv.Atoi(fields[0])
+	seq, err2 := strconv.ParseInt(fields[1], 10, 64)
+	ns, err3 := strconv.ParseInt(fields[2], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, 0, time.Time{}, false
+	}
+	return publisher, seq, time.Unix(0, ns), true
+}
+
+// subscriber is one connection subscribed to a single channel. Its fields
+// other than received are owned by its goroutine until done is closed.
+type subscriber struct {
+	ps       *redis.PubSub
+	received atomic.Int64
+	done     chan struct{}
+
+	// latency is the delivery latency of the messages received while
+	// measuring.
+	latency *stats.Histogram
+	// last is the sequence number last received from each publisher.
+	last       map[int]int64
+	outOfOrder int64
+	malformed  int64
+}
+
+// subscriberGroup runs the subscribers of a pubsub run. Their lifecycle is
+// separate from the workers': they are subscribed before the first PUBLISH
+// and stopped once the published messages have arrived.
+type subscriberGroup struct {
+	subs []*subscriber
+	// subscribeErrors counts the connections that failed to subscribe.
+	subscribeErrors int
+}
+
+// startSubscribers subscribes -pubsub-subscribers connections to every
+// channel and waits for each subscription to be confirmed, so no message
+// published afterwards can be missed.
+func startSubscribers(st *runState) *subscriberGroup {
+	cfg := st.cfg
+	g := &subscriberGroup{}
+	for ch := 0; ch < cfg.pubsubChannels; ch++ {
+		for i := 0; i < cfg.pubsubSubscribers; i++ {
+			ps := st.rdb.Subscribe(context.Background(), cfg.channelName(ch))
+			if _, err := ps.Receive(context.Background()); err != nil {
+				ps.Close()
+				g.subscribeErrors++
+				continue
+			}
+			s := &subscriber{ps: ps, done: make(chan struct{}), latency: cfg.layout.New(), last: make(map[int]int64)}
+			g.subs = append(g.subs, s)
+			go s.run(st)
+		}
+	}
+	return g
+}
+
+// run receives messages until the subscription is closed.
+func (s *subscriber) run(st *runState) {
+	defer close(s.done)
+	for {
+		msg, err := s.ps.ReceiveMessage(context.Background())
+		if err != nil {
+			return
+		}
+		now := time.Now()
+		s.received.Add(1)
+		publisher, seq, sent, ok := parseMessage(msg.Payload)
+		if !ok {
+			s.malformed++
+			continue
+		}
+		if seq <= s.last[publisher] {
+			s.outOfOrder++
+		} else {
+			s.last[publisher] = seq
+		}
+		if st.measuring.Load() {
+			s.latency.Record(now.Sub(sent))
+		}
+	}
+}
+
+// received returns the messages received by all subscribers so far.
+func (g *subscriberGroup) received() int64 {
+	var n int64
+	for _, s := range g.subs {
+		n += s.received.Load()
+	}
+	return n
+}
+
+// stop waits up to drain for every delivery the publishers in t call for,
+// then closes every subscription and summarises the deliveries.
+func (g *subscriberGroup) stop(cfg *config, t *pubTally, drain time.Duration) *pubsubReport {
+	rep := &pubsubReport{
+		Channels:        cfg.pubsubChannels,
+		Subscribers:     cfg.pubsubSubscribers,
+		SubscribeErrors: g.subscribeErrors,
+		PublishFailed:   t.failed,
+	}
+	for _, n := range t.published {
+		rep.Published += n
+	}
+	rep.Expected = rep.Published * int64(cfg.pubsubSubscribers)
+	deadline := time.Now().Add(drain)
+	for g.received() < rep.Expected && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	latency := cfg.layout.New()
+	for _, s := range g.subs {
+		s.ps.Close()
+		<-s.done
+		rep.Received += s.received.Load()
+		rep.OutOfOrder += s.outOfOrder
+		rep.Malformed += s.malformed
+		latency.Merge(s.latency)
+	}
+	rep.Dropped = max(rep.Expected-rep.Received, 0)
+	if rep.Expected > 0 {
+		rep.DeliveryRatio = float64(rep.Received) / float64(rep.Expected)
+	}
+	rep.Latency = summarizeLatency(latency)
+	rep.latency = latency
+	return rep
+}
+
+// pubsubReport is the delivery accounting of the pubsub workload. Every
+// message published must reach every subscriber of its channel.
+type pubsubReport struct {
+	Channels        int   `json:"channels"`
+	Subscribers     int   `json:"subscribers_per_channel"`
+	SubscribeErrors int   `json:"subscribe_errors,omitempty"`
+	Published       int64 `json:"published"`
+	PublishFailed   int64 `json:"publish_failed,omitempty"`
+	// Expected is Published times the subscribers of each channel.
+	Expected      int64   `json:"expected"`
+	Received      int64   `json:"received"`
+	Dropped       int64   `json:"dropped"`
+	DeliveryRatio float64 `json:"delivery_ratio"`
+	// OutOfOrder counts messages received after a later one of the same
+	// publisher on the same channel.
+	OutOfOrder int64 `json:"out_of_order"`
+	Malformed  int64 `json:"malformed,omitempty"`
+	// Latency is the time from PUBLISH to delivery, measured phase only;
+	// its maximum is the worst-case delivery latency.
+	Latency *latencySummary `json:"delivery_latency"`
+
+	latency *stats.Histogram
+}
+
+// printPubSubReport writes the pubsub section of the summary.
+func printPubSubReport(w io.Writer, u latencyUnit, rep *pubsubReport) {
+	fmt.Fprintf(w, "Pub/Sub: %d channels, %d subscribers each", rep.Channels, rep.Subscribers)
+	if rep.SubscribeErrors > 0 {
+		fmt.Fprintf(w, " (%d failed to subscribe)", rep.SubscribeErrors)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Messages: %d published (%d failed), %d of %d deliveries received (%.2f%% delivery ratio)\n",
+		rep.Published, rep.PublishFailed, rep.Received, rep.Expected, 100*rep.DeliveryRatio)
+	if rep.Dropped > 0 || rep.OutOfOrder > 0 || rep.Malformed > 0 {
+		fmt.Fprintf(w, "WARNING: %d deliveries dropped, %d out of order, %d malformed\n", rep.Dropped, rep.OutOfOrder, rep.Malformed)
+	}
+	printLatency(w, u, "Delivery", rep.latency)
+	if rep.Latency != nil {
+		fmt.Fprintf(w, "Worst-case delivery latency: %v\n", time.Duration(rep.Latency.MaxNs))
+	}
+}
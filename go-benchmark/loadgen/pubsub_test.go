@@ -0,0 +1,57 @@
+package loadgen
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestPubSubWorkload(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-workload", "pubsub", "-pubsub-channels", "2", "-pubsub-subscribers", "3",
+		"-clients", "2", "-ops", "30", "-key-prefix", "ps:")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep := res.pubsub
+	if rep == nil || rep.Published != 2*30 {
+		t.Fatalf("pubsub report %+v, want %d messages published", rep, 2*30)
+	}
+	if rep.Expected != 3*rep.Published || rep.Received != rep.Expected || rep.DeliveryRatio != 1 {
+		t.Errorf("received %d of %d deliveries, want all", rep.Received, rep.Expected)
+	}
+	if rep.Dropped != 0 || rep.OutOfOrder != 0 || rep.Malformed != 0 {
+		t.Errorf("%d dropped, %d out of order, %d malformed from a correct server", rep.Dropped, rep.OutOfOrder, rep.Malformed)
+	}
+	if rep.Latency == nil || rep.Latency.Count != rep.Received {
+		t.Errorf("delivery latency %+v, want one sample per delivery", rep.Latency)
+	}
+}
+
+func TestPubSubCountsDrops(t *testing.T) {
+	cfg := testConfig(t, "-workload", "pubsub", "-pubsub-channels", "2", "-pubsub-subscribers", "2")
+	// No subscriber ever received the 5 messages.
+	g := &subscriberGroup{}
+
+	rep := g.stop(cfg, &pubTally{published: []int64{2, 3}}, 0)
+
+	if rep.Expected != 10 || rep.Dropped != 10 || rep.DeliveryRatio != 0 {
+		t.Errorf("got %+v, want 10 deliveries expected and dropped", rep)
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	sent := time.Unix(0, 1700000000123456789)
+	publisher, seq, at, ok := parseMessage("7 42 1700000000123456789 payload with spaces")
+	if !ok || publisher != 7 || seq != 42 || !at.Equal(sent) {
+		t.Errorf("got %d, %d, %v, %v", publisher, seq, at, ok)
+	}
+	if _, _, _, ok := parseMessage("7 x 1"); ok {
+		t.Error("malformed message accepted")
+	}
+}
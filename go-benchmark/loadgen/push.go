@@ -0,0 +1,310 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exitPushFailed is the exit status of a run whose results could not be
+// pushed with -push; the report was written before.
+const exitPushFailed = 8
+
+// pushTimeout bounds a whole push, however slow the sink.
+const pushTimeout = 10 * time.Second
+
+// Measurements of the pushed points.
+const (
+	pushSummary = "htcache_bench"
+	pushSeries  = "htcache_bench_series"
+)
+
+// pushPoint is one point of line protocol: a measurement with tags, fields
+// and a time.
+type pushPoint struct {
+	measurement string
+	tags        []pushTag
+	fields      []pushField
+	at          time.Time
+}
+
+type pushTag struct{ key, value string }
+
+type pushField struct {
+	key   string
+	value float64
+}
+
+// pushSink ships points to a metrics store.
+type pushSink interface {
+	push(ctx context.Context, points []pushPoint) error
+	// String names the sink for messages.
+	String() string
+}
+
+// validatePush parses -push.
+func (c *config) validatePush() error {
+	if c.push == "" {
+		if c.pushSeries {
+			return errors.New("-push-series requires -push")
+		}
+		return nil
+	}
+	if c.sweeping() || c.scenario != "" {
+		return errors.New("-push ships single runs and -addr2 comparisons, not sweeps or -scenario")
+	}
+	sink, err := parsePushTarget(c.push)
+	if err != nil {
+		return fmt.Errorf("-push: %w", err)
+	}
+	c.pushSink = sink
+	return nil
+}
+
+// parsePushTarget parses influx://[user:pass@]host:8086/db, influxs:// for
+// HTTPS, or graphite://host:2003[/prefix].
+func parsePushTarget(s string) (pushSink, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%q has no host", s)
+	}
+	path := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "influx", "influxs":
+		if path == "" || strings.Contains(path, "/") {
+			return nil, fmt.Errorf("%q names no database: want %s://host:8086/db", s, u.Scheme)
+		}
+		scheme := "http"
+		if u.Scheme == "influxs" {
+			scheme = "https"
+		}
+		q := url.Values{"db": {path}, "precision": {"ns"}}
+		if u.User != nil {
+			q.Set("u", u.User.Username())
+			if p, ok := u.User.Password(); ok {
+				q.Set("p", p)
+			}
+		}
+		write := url.URL{Scheme: scheme, Host: u.Host, Path: "/write", RawQuery: q.Encode()}
+		return &influxSink{url: write.String(), host: u.Host, db: path, client: http.DefaultClient}, nil
+	case "graphite":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return nil, fmt.Errorf("%q: want graphite://host:port", s)
+		}
+		prefix := strings.ReplaceAll(path, "/", ".")
+		if prefix == "" {
+			prefix = "htcache"
+		}
+		return &graphiteSink{addr: u.Host, prefix: prefix}, nil
+	}
+	return nil, fmt.Errorf("unsupported scheme in %q: want influx://, influxs:// or graphite://", s)
+}
+
+// buildPushPoints returns the summary point of rep and, with -push-series,
+// a point per interval of its time series.
+func buildPushPoints(cfg *config, rep *Report) []pushPoint {
+	tags := []pushTag{
+		{"target", rep.Config.Addr},
+		{"workload", rep.Config.Workload},
+		{"clients", strconv.Itoa(rep.Config.Clients)},
+	}
+	if cfg.runTag != "" {
+		tags = append(tags, pushTag{"run_tag", cfg.runTag})
+	}
+	for _, k := range tagKeys(cfg.tags) {
+		tags = append(tags, pushTag{k, cfg.tags[k]})
+	}
+	summary := pushPoint{measurement: pushSummary, tags: tags, at: rep.End}
+	for _, m := range storedMetrics(rep) {
+		summary.fields = append(summary.fields, pushField{pushFieldName(m), m.value})
+	}
+	points := []pushPoint{summary}
+	if !cfg.pushSeries {
+		return points
+	}
+	for _, p := range rep.TimeSeries {
+		pt := pushPoint{
+			measurement: pushSeries,
+			tags:        tags,
+			at:          rep.Start.Add(time.Duration(p.T * float64(time.Second))),
+			fields: []pushField{
+				{"ops", float64(p.Ops)},
+				{"errors", float64(p.Errors)},
+				{"clients", float64(p.Clients)},
+			},
+		}
+		if p.P99Ns > 0 {
+			pt.fields = append(pt.fields, pushField{"p99_ns", float64(p.P99Ns)})
+		}
+		if p.WindowP99Ns > 0 {
+			pt.fields = append(pt.fields, pushField{"window_p50_ns", float64(p.WindowP50Ns)}, pushField{"window_p99_ns", float64(p.WindowP99Ns)})
+		}
+		points = append(points, pt)
+	}
+	return points
+}
+
+// pushUnits are the field name suffixes of the summary metric units.
+var pushUnits = map[string]string{
+	"ops/s": "_ops_per_sec",
+	"ops":   "",
+	"%":     "_pct",
+	"µs":    "_us",
+	"MB/s":  "_mb_per_sec",
+}
+
+// pushFieldName turns a summary metric such as "p99 latency" in µs into a
+// field name such as p99_latency_us.
+func pushFieldName(m storedMetric) string {
+	return strings.ReplaceAll(m.name, " ", "_") + pushUnits[m.unit]
+}
+
+// pushResults pushes the results of every run to -push and returns the exit
+// status a failure calls for. The reports are written first, so a failing
+// sink loses nothing but the push.
+func pushResults(cfgs []*config, results []*runResult) int {
+	sink := cfgs[0].pushSink
+	var points []pushPoint
+	for i, res := range results {
+		points = append(points, buildPushPoints(cfgs[i], buildReport(cfgs[i], res))...)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+	defer cancel()
+	if err := sink.push(ctx, points); err != nil {
+		cfgs[0].logger.Error("PUSH FAILED", "sink", sink, "err", err)
+		return exitPushFailed
+	}
+	cfgs[0].logger.Info("pushed", "points", len(points), "sink", sink)
+	return 0
+}
+
+// influxSink writes line protocol to the /write endpoint of InfluxDB 1.x,
+// or of 2.x with its v1 compatibility API.
+type influxSink struct {
+	url      string
+	host, db string
+	client   *http.Client
+}
+
+func (s *influxSink) String() string { return "influx://" + s.host + "/" + s.db }
+
+func (s *influxSink) push(ctx context.Context, points []pushPoint) error {
+	var body bytes.Buffer
+	for _, p := range points {
+		writeInfluxLine(&body, p)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// writeInfluxLine writes p as a line of InfluxDB line protocol, tags sorted
+// by key as InfluxDB recommends.
+func writeInfluxLine(w *bytes.Buffer, p pushPoint) {
+	w.WriteString(influxEscape(p.measurement, ", "))
+	tags := append([]pushTag(nil), p.tags...)
+	sort.Slice(tags, func(i, j int) bool { return tags[i].key < tags[j].key })
+	for _, t := range tags {
+		if t.value == "" {
+			// Line protocol has no empty tag values.
+			continue
+		}
+		fmt.Fprintf(w, ",%s=%s", influxEscape(t.key, ",= "), influxEscape(t.value, ",= "))
+	}
+	for i, f := range p.fields {
+		sep := ","
+		if i == 0 {
+			sep = " "
+		}
+		fmt.Fprintf(w, "%s%s=%s", sep, influxEscape(f.key, ",= "), strconv.FormatFloat(f.value, 'g', -1, 64))
+	}
+	fmt.Fprintf(w, " %d\n", p.at.UnixNano())
+}
+
+// influxEscape backslash-escapes the characters special in a part of line
+// protocol.
+func influxEscape(s, special string) string {
+	if !strings.ContainsAny(s, special) {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// graphiteSink writes the plaintext protocol over TCP, with tags in the
+// name as Graphite 1.1 reads them: prefix.measurement.field;tag=value.
+type graphiteSink struct {
+	addr, prefix string
+}
+
+func (s *graphiteSink) String() string { return "graphite://" + s.addr + "/" + s.prefix }
+
+func (s *graphiteSink) push(ctx context.Context, points []pushPoint) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	var body bytes.Buffer
+	for _, p := range points {
+		var tags strings.Builder
+		for _, t := range p.tags {
+			if t.value != "" {
+				fmt.Fprintf(&tags, ";%s=%s", graphiteSafe(t.key), graphiteSafe(t.value))
+			}
+		}
+		for _, f := range p.fields {
+			fmt.Fprintf(&body, "%s.%s.%s%s %s %d\n", s.prefix, p.measurement, graphiteSafe(f.key), tags.String(),
+				strconv.FormatFloat(f.value, 'g', -1, 64), p.at.Unix())
+		}
+	}
+	if _, err := conn.Write(body.Bytes()); err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// graphiteSafe replaces the characters the plaintext protocol splits on.
+func graphiteSafe(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', ';', '~', '=', '\n', '\t':
+			return '_'
+		}
+		return r
+	}, s)
+}
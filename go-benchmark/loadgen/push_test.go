@@ -0,0 +1,182 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSink records the points pushed to it, or fails with err.
+type fakeSink struct {
+	points []pushPoint
+	err    error
+}
+
+func (s *fakeSink) String() string { return "fake" }
+
+func (s *fakeSink) push(ctx context.Context, points []pushPoint) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.points = append(s.points, points...)
+	return nil
+}
+
+func TestPushResults(t *testing.T) {
+	addr := newTestServerAddr(t)
+	cfg := testConfig(t, "-addr", addr, "-clients", "2", "-duration", "300ms", "-push", "graphite://localhost:2003",
+		"-push-series", "-run-tag", "nightly")
+	sink := &fakeSink{}
+	cfg.pushSink = sink
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := pushResults([]*config{cfg}, []*runResult{res}); code != 0 {
+		t.Fatalf("push exited with %d", code)
+	}
+	if len(sink.points) != 1+len(res.series) || len(res.series) == 0 {
+		t.Fatalf("%d points pushed for %d intervals", len(sink.points), len(res.series))
+	}
+	summary := sink.points[0]
+	fields := make(map[string]float64)
+	for _, f := range summary.fields {
+		fields[f.key] = f.value
+	}
+	if summary.measurement != pushSummary || fields["throughput_ops_per_sec"] <= 0 || fields["p99_latency_us"] <= 0 || fields["total_ops"] != float64(res.total.latency.Count()) {
+		t.Errorf("summary point %+v", summary)
+	}
+	tags := make(map[string]string)
+	for _, tag := range summary.tags {
+		tags[tag.key] = tag.value
+	}
+	if tags["target"] != addr || tags["workload"] != "set" || tags["clients"] != "2" || tags["run_tag"] != "nightly" {
+		t.Errorf("tags %v", tags)
+	}
+	if p := sink.points[1]; p.measurement != pushSeries || !p.at.After(res.start) {
+		t.Errorf("series point %+v", p)
+	}
+
+	// A failing sink costs the push only.
+	sink.err = errors.New("connection refused")
+	if code := pushResults([]*config{cfg}, []*runResult{res}); code != exitPushFailed {
+		t.Errorf("failed push exited with %d, want %d", code, exitPushFailed)
+	}
+}
+
+func TestInfluxSink(t *testing.T) {
+	var body string
+	var query url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body, query = string(b), r.URL.Query()
+		if query.Get("db") == "missing" {
+			http.Error(w, `{"error":"database not found"}`, http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	sink, err := parsePushTarget("influx://bench:secret@" + host + "/perf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Unix(1700000000, 5)
+	err = sink.push(context.Background(), []pushPoint{{
+		measurement: pushSummary,
+		tags:        []pushTag{{"workload", "set"}, {"target", "cache 1,a=b"}, {"run_tag", ""}},
+		fields:      []pushField{{"throughput_ops_per_sec", 30000.5}, {"p99_latency_us", 120}},
+		at:          at,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `htcache_bench,target=cache\ 1\,a\=b,workload=set throughput_ops_per_sec=30000.5,p99_latency_us=120 1700000000000000005` + "\n"
+	if body != want {
+		t.Errorf("line protocol\n%q, want\n%q", body, want)
+	}
+	if query.Get("db") != "perf" || query.Get("u") != "bench" || query.Get("p") != "secret" || query.Get("precision") != "ns" {
+		t.Errorf("query %v", query)
+	}
+
+	sink, _ = parsePushTarget("influx://" + host + "/missing")
+	if err := sink.push(context.Background(), []pushPoint{{measurement: "m", fields: []pushField{{"f", 1}}, at: at}}); err == nil || !strings.Contains(err.Error(), "database not found") {
+		t.Errorf("push to a missing database: %v", err)
+	}
+}
+
+func TestGraphiteSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		got <- string(b)
+	}()
+	sink, err := parsePushTarget("graphite://" + ln.Addr().String() + "/perf/redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sink.push(context.Background(), []pushPoint{{
+		measurement: pushSummary,
+		tags:        []pushTag{{"target", "localhost:6379"}, {"run_tag", "a b"}},
+		fields:      []pushField{{"throughput_ops_per_sec", 100}, {"p99_latency_us", 1.5}},
+		at:          time.Unix(1700000000, 0),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "perf.redis.htcache_bench.throughput_ops_per_sec;target=localhost:6379;run_tag=a_b 100 1700000000\n" +
+		"perf.redis.htcache_bench.p99_latency_us;target=localhost:6379;run_tag=a_b 1.5 1700000000\n"
+	if s := <-got; s != want {
+		t.Errorf("plaintext\n%q, want\n%q", s, want)
+	}
+
+	// Nothing listens any more.
+	ln.Close()
+	if err := sink.push(context.Background(), nil); err == nil {
+		t.Error("push to a closed port succeeded")
+	}
+}
+
+func TestPushFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-push", "http://localhost:8086/db"},
+		{"-push", "influx://localhost:8086"},
+		{"-push", "influx:///db"},
+		{"-push", "graphite://localhost"},
+		{"-push-series"},
+		{"-push", "graphite://localhost:2003", "-sweep-clients", "1,2"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	cfg := testConfig(t, "-push", "influxs://localhost:8086/perf")
+	if s, ok := cfg.pushSink.(*influxSink); !ok || !strings.HasPrefix(s.url, "https://localhost:8086/write?") {
+		t.Errorf("sink %#v", cfg.pushSink)
+	}
+	var buf bytes.Buffer
+	writeInfluxLine(&buf, pushPoint{measurement: "m", fields: []pushField{{"f", 1}}, at: time.Unix(0, 1)})
+	if buf.String() != "m f=1 1\n" {
+		t.Errorf("untagged line %q", buf.String())
+	}
+}
@@ -0,0 +1,326 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// workloadQueue splits the clients into producers pushing messages onto
+// -queue-keys lists and consumers popping them.
+const workloadQueue = "queue"
+
+// queueOps are the commands of the queue workload; they only run on the
+// queue lists, never over the keyspace.
+var queueOps = map[opType]bool{
+	opLPush: true,
+	opRPop:  true,
+	opBRPop: true,
+}
+
+// queueName returns the key of list i of the -queue-keys pool.
+func (c *config) queueName(i int) string {
+	return c.keyPrefix + "queue" + strconv.Itoa(i)
+}
+
+// queueNames returns the keys of every list of the pool.
+func (c *config) queueNames() []string {
+	keys := make([]string, c.queueKeys)
+	for i := range keys {
+		keys[i] = c.queueName(i)
+	}
+	return keys
+}
+
+// queueTally counts the messages a worker moved, warmup included. Failed
+// pushes and pops are kept apart because the server may or may not have
+// applied them.
+type queueTally struct {
+	pushed     int64
+	pushFailed int64
+	popped     int64
+	popFailed  int64
+}
+
+func (t *queueTally) merge(o *queueTally) {
+	t.pushed += o.pushed
+	t.pushFailed += o.pushFailed
+	t.popped += o.popped
+	t.popFailed += o.popFailed
+}
+
+// producers returns the number of clients that push, the others pop. The
+// default split is recomputed for every -sweep-clients step.
+func (c *config) producers() int {
+	if c.queueProducers > 0 {
+		return c.queueProducers
+	}
+	return c.clients / 2
+}
+
+// queueOp returns the command of the worker's role: the first
+// -queue-producers clients push, the others pop.
+func (w *worker) queueOp() opType {
+	cfg := w.run.cfg
+	switch {
+	case w.id < cfg.producers():
+		return opLPush
+	case cfg.queueBlock > 0:
+		return opBRPop
+	default:
+		return opRPop
+	}
+}
+
+// issueQueue pushes a message stamped with its send time, or pops one.
+func (w *worker) issueQueue(ctx context.Context, c redis.Cmdable, op opType) pendingOp {
+	cfg := w.run.cfg
+	switch op {
+	case opLPush:
+		key := cfg.queueName(w.rng.Intn(cfg.queueKeys))
+		msg := strconv.AppendInt(nil, time.Now().UnixNano(), 10)
+		msg = append(append(msg, ' '), cfg.nextValue(w.rng, w.seq)...)
+		return pendingOp{op: op, cmd: c.LPush(ctx, key, msg), bytes: len(msg), key: key}
+	case opBRPop:
+		// BRPOP serves whichever list has a message first.
+		return pendingOp{op: op, cmd: c.BRPop(ctx, cfg.queueBlock, cfg.queueNames()...), key: cfg.queueName(0)}
+	default:
+		key := cfg.queueName(w.rng.Intn(cfg.queueKeys))
+		return pendingOp{op: op, cmd: c.RPop(ctx, key), key: key}
+	}
+}
+
+// recordQueue tallies a completed queue command and, for a popped message,
+// records the time since its producer sent it.
+func (w *worker) recordQueue(p pendingOp, err error, found bool, end time.Time) {
+	t := &w.queue
+	if p.op == opLPush {
+		if err != nil {
+			t.pushFailed++
+		} else {
+			t.pushed++
+		}
+		return
+	}
+	switch {
+	case err != nil:
+		t.popFailed++
+		return
+	case !found:
+		return
+	}
+	t.popped++
+	var msg string
+	switch cmd := p.cmd.(type) {
+	case *redis.StringCmd:
+		msg = cmd.Val()
+	case *redis.StringSliceCmd:
+		msg = cmd.Val()[1]
+	}
+	if sent, ok := messageSentAt(msg); ok && w.measuring {
+		if w.result.endToEnd == nil {
+			w.result.endToEnd = w.result.layout.New()
+		}
+		w.result.endToEnd.Record(end.Sub(sent))
+	}
+}
+
+// messageSentAt returns the send time a producer embedded in msg.
+func messageSentAt(msg string) (time.Time, bool) {
+	i := strings.IndexByte(msg, ' ')
+	if i < 0 {
+		return time.Time{}, false
+	}
+	ns, err := strconv.ParseInt(msg[:i], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ns), true
+}
+
+// depthPoint is the total length of the queue lists at T seconds since the
+// measured window started.
+type depthPoint struct {
+	T     float64 `json:"t"`
+	Depth int64   `json:"depth"`
+}
+
+// depthMonitor polls the length of the queue lists with LLEN while the run
+// is in progress.
+type depthMonitor struct {
+	stopCh chan struct{}
+	done   sync.WaitGroup
+	at     []time.Time
+	depths []int64
+}
+
+func startDepthMonitor(rdb redis.UniversalClient, cfg *config) *depthMonitor {
+	m := &depthMonitor{stopCh: make(chan struct{})}
+	m.done.Add(1)
+	go func() {
+		defer m.done.Done()
+		t := time.NewTicker(cfg.queueDepthInterval)
+		defer t.Stop()
+		for {
+			select {
+			case now := <-t.C:
+				if n, err := queueDepth(context.Background(), rdb, cfg); err == nil {
+					m.at = append(m.at, now)
+					m.depths = append(m.depths, n)
+				}
+			case <-m.stopCh:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// stop ends polling and returns the samples relative to origin.
+func (m *depthMonitor) stop(origin time.Time) []depthPoint {
+	close(m.stopCh)
+	m.done.Wait()
+	points := make([]depthPoint, len(m.at))
+	for i, at := range m.at {
+		points[i] = depthPoint{T: at.Sub(origin).Seconds(), Depth: m.depths[i]}
+	}
+	return points
+}
+
+// queueDepth returns the number of messages on all queue lists.
+func queueDepth(ctx context.Context, rdb redis.UniversalClient, cfg *config) (int64, error) {
+	pipe := rdb.Pipeline()
+	cmds := make([]*redis.IntCmd, cfg.queueKeys)
+	for i := range cmds {
+		cmds[i] = pipe.LLen(ctx, cfg.queueName(i))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, c := range cmds {
+		n += c.Val()
+	}
+	return n, nil
+}
+
+// resetQueues deletes the queue lists so that the run starts with empty
+// queues.
+func resetQueues(ctx context.Context, rdb redis.UniversalClient, cfg *config) error {
+	// One DEL per list, as a cluster spreads them over slots.
+	pipe := rdb.Pipeline()
+	for _, name := range cfg.queueNames() {
+		pipe.Del(ctx, name)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("reset queues: %w", err)
+	}
+	return nil
+}
+
+// queueReport is the end-of-run accounting of the queue workload: every
+// message produced must have been consumed or be left on a queue.
+type queueReport struct {
+	Producers     int   `json:"producers"`
+	Consumers     int   `json:"consumers"`
+	Lists         int   `json:"lists"`
+	Produced      int64 `json:"produced"`
+	ProduceFailed int64 `json:"produce_failed,omitempty"`
+	Consumed      int64 `json:"consumed"`
+	ConsumeFailed int64 `json:"consume_failed,omitempty"`
+	Left          int64 `json:"left"`
+	Balanced      bool  `json:"balanced"`
+	// Error is set when the queues could not be read back.
+	Error string `json:"error,omitempty"`
+}
+
+func (r *queueReport) failed() bool {
+	return !r.Balanced
+}
+
+// verifyQueues counts the messages left on the queues and checks that they
+// account for every message produced and not consumed.
+func verifyQueues(ctx context.Context, rdb redis.UniversalClient, cfg *config, t *queueTally) *queueReport {
+	rep := &queueReport{
+		Producers:     cfg.producers(),
+		Consumers:     cfg.clients - cfg.producers(),
+		Lists:         cfg.queueKeys,
+		Produced:      t.pushed,
+		ProduceFailed: t.pushFailed,
+		Consumed:      t.popped,
+		ConsumeFailed: t.popFailed,
+	}
+	left, err := queueDepth(ctx, rdb, cfg)
+	if err != nil {
+		rep.Error = fmt.Sprintf("read queue lengths: %v", err)
+		return rep
+	}
+	rep.Left = left
+	rep.Balanced = queueBalanced(t, left)
+	return rep
+}
+
+// queueBalanced reports whether left messages on the queues are consistent
+// with t. A failed push may still have enqueued its message and a failed
+// pop may have dequeued one that was then lost.
+func queueBalanced(t *queueTally, left int64) bool {
+	seen := t.popped + left
+	return seen >= t.pushed-t.popFailed && seen <= t.pushed+t.pushFailed
+}
+
+// jsonQueue is the queue workload section of the JSON report.
+type jsonQueue struct {
+	queueReport
+	Enqueue  *latencySummary `json:"enqueue_latency"`
+	Dequeue  *latencySummary `json:"dequeue_latency"`
+	EndToEnd *latencySummary `json:"end_to_end_latency"`
+	Depth    []depthPoint    `json:"depth"`
+}
+
+// printQueueReport writes the queue section of the summary.
+func printQueueReport(w io.Writer, u latencyUnit, cfg *config, res *runResult) {
+	rep, total := res.queue, res.total
+	pop := "RPOP"
+	if cfg.queueBlock > 0 {
+		pop = fmt.Sprintf("BRPOP (block %v)", cfg.queueBlock)
+	}
+	fmt.Fprintf(w, "Queue: %d producers, %d consumers, %d lists, dequeue with %s\n", rep.Producers, rep.Consumers, rep.Lists, pop)
+	printQueueCheck(w, rep)
+	fmt.Fprintf(w, "Empty pops: %d\n", total.ops[opRPop].misses+total.ops[opBRPop].misses)
+	dequeue := opRPop
+	if cfg.queueBlock > 0 {
+		dequeue = opBRPop
+	}
+	printLatency(w, u, "Enqueue", total.ops[opLPush].latency)
+	printLatency(w, u, "Dequeue", total.ops[dequeue].latency)
+	printLatency(w, u, "End-to-end message", total.endToEnd)
+	if depth := res.queueDepth; len(depth) > 0 {
+		values := make([]float64, len(depth))
+		lo, hi := depth[0].Depth, depth[0].Depth
+		for i, p := range depth {
+			values[i] = float64(p.Depth)
+			lo, hi = min(lo, p.Depth), max(hi, p.Depth)
+		}
+		fmt.Fprintf(w, "Queue depth over time (min %d, max %d messages):\n  %s\n", lo, hi, sparkline(values))
+	}
+}
+
+// printQueueCheck writes the message accounting line.
+func printQueueCheck(w io.Writer, rep *queueReport) {
+	fmt.Fprintf(w, "Messages: %d produced, %d consumed, %d left on the queues", rep.Produced, rep.Consumed, rep.Left)
+	switch {
+	case rep.Error != "":
+		fmt.Fprintf(w, ": FAILED: %s\n", rep.Error)
+	case !rep.Balanced:
+		fmt.Fprintf(w, ": FAILED: %d messages unaccounted for (%d failed pushes, %d failed pops)\n",
+			rep.Produced-rep.Consumed-rep.Left, rep.ProduceFailed, rep.ConsumeFailed)
+	default:
+		fmt.Fprintln(w, ": OK")
+	}
+}
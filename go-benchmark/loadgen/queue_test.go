@@ -0,0 +1,74 @@
+package loadgen
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestQueueWorkload(t *testing.T) {
+	addr := newTestServerAddr(t)
+	cfg := testConfig(t, "-addr", addr, "-workload", "queue", "-clients", "4", "-queue-producers", "1",
+		"-queue-keys", "2", "-ops", "100", "-queue-depth-interval", "10ms")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep := res.queue
+	if rep == nil || rep.failed() {
+		t.Fatalf("queue report = %+v, want balanced", rep)
+	}
+	if rep.Produced != 100 || rep.Consumed+rep.Left != 100 {
+		t.Errorf("%d produced, %d consumed, %d left, want 100 produced and accounted for", rep.Produced, rep.Consumed, rep.Left)
+	}
+	if rep.Producers != 1 || rep.Consumers != 3 {
+		t.Errorf("%d producers and %d consumers, want 1 and 3", rep.Producers, rep.Consumers)
+	}
+	if h := res.total.endToEnd; h == nil || h.Count() != rep.Consumed {
+		t.Errorf("end-to-end latency of %v messages, want %d", h, rep.Consumed)
+	}
+}
+
+func TestQueueWorkloadBlockingPop(t *testing.T) {
+	_, rdb := newTestServer(t)
+	cfg := testConfig(t, "-workload", "queue", "-clients", "2", "-ops", "20", "-queue-block", "1s")
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	if s := &res.total.ops[opBRPop]; s.hits != 20 || s.errors != 0 {
+		t.Errorf("BRPOP: %+v, want all 20 messages consumed", s)
+	}
+}
+
+func TestQueueBalanced(t *testing.T) {
+	tests := []struct {
+		tally queueTally
+		left  int64
+		want  bool
+	}{
+		{queueTally{pushed: 10, popped: 7}, 3, true},
+		{queueTally{pushed: 10, popped: 7}, 2, false},
+		{queueTally{pushed: 10, popped: 7}, 4, false},
+		// A failed push may have enqueued its message, a failed pop may
+		// have lost one.
+		{queueTally{pushed: 10, pushFailed: 1, popped: 7}, 4, true},
+		{queueTally{pushed: 10, popped: 7, popFailed: 1}, 2, true},
+	}
+	for _, tt := range tests {
+		if got := queueBalanced(&tt.tally, tt.left); got != tt.want {
+			t.Errorf("queueBalanced(%+v, %d) = %v, want %v", tt.tally, tt.left, got, tt.want)
+		}
+	}
+}
+
+func TestMessageSentAt(t *testing.T) {
+	sent := time.Unix(0, 1700000000123456789)
+	if got, ok := messageSentAt("1700000000123456789 value1"); !ok || !got.Equal(sent) {
+		t.Errorf("messageSentAt = %v, %v, want %v", got, ok, sent)
+	}
+	if _, ok := messageSentAt("garbage"); ok {
+		t.Error("messageSentAt accepted a message without a timestamp")
+	}
+}
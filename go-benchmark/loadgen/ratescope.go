@@ -0,0 +1,102 @@
+package loadgen
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Scopes of -rate. A global rate is shared by all clients through one
+// pacer, whichever client is free taking the next slot. A client rate
+// limits every client on its own, as many small tenants would: a client
+// stalled by a slow reply does not hand its slots to the others.
+const (
+	rateGlobal = "global"
+	rateClient = "client"
+)
+
+// validateRateScope checks -rate-scope.
+func (c *config) validateRateScope() error {
+	switch c.rateScope {
+	case rateGlobal:
+		return nil
+	case rateClient:
+	default:
+		return fmt.Errorf("-rate-scope must be %s or %s, got %q", rateGlobal, rateClient, c.rateScope)
+	}
+	switch {
+	case c.rate <= 0:
+		return errors.New("-rate-scope client requires -rate, the rate of every client")
+	case c.loop == loopOpen:
+		return errors.New("-rate-scope client does not apply to -loop open, whose arrivals are shared by the clients")
+	}
+	return nil
+}
+
+// aggregateRate returns the operations per second -rate asks of all
+// clients together.
+func (c *config) aggregateRate() float64 {
+	if c.rateScope == rateClient {
+		return c.rate * float64(c.clients)
+	}
+	return c.rate
+}
+
+// newClientPacer returns the pacer of clientID under -rate-scope client.
+// The schedules of the clients are staggered across one interval so their
+// slots do not all fall due at once.
+func newClientPacer(cfg *config, clientID int, clk clock) *pacer {
+	p := newPacer(cfg.rate, clk)
+	p.start = p.start.Add(time.Duration(p.interval * float64(clientID) / float64(cfg.clients)))
+	return p
+}
+
+// clientRates is the spread of the rates the clients achieved under
+// -rate-scope client, in operations per second of the measured window.
+type clientRates struct {
+	min, mean, max float64
+}
+
+// collectClientRates returns the spread of the rates of the clients that
+// started, whose pacers are non-nil.
+func collectClientRates(results []*workerResult, pacers []*pacer, elapsed time.Duration) *clientRates {
+	if elapsed <= 0 {
+		return nil
+	}
+	var r *clientRates
+	var sum float64
+	n := 0
+	for i, res := range results {
+		if pacers[i] == nil {
+			continue
+		}
+		rate := float64(res.attempts()) / elapsed.Seconds()
+		if r == nil {
+			r = &clientRates{min: rate, max: rate}
+		}
+		r.min, r.max = min(r.min, rate), max(r.max, rate)
+		sum += rate
+		n++
+	}
+	if r != nil {
+		r.mean = sum / float64(n)
+	}
+	return r
+}
+
+// printRate writes the requested and achieved rate of a closed-loop paced
+// run.
+func printRate(w io.Writer, cfg *config, res *runResult, achieved float64) {
+	requested := cfg.aggregateRate()
+	if cfg.rateScope == rateClient {
+		fmt.Fprintf(w, "Requested rate: %.0f ops/s per client, %.0f ops/s in all, achieved: %.0f ops/s (%.1f%%), backlog: %d ops\n",
+			cfg.rate, requested, achieved, 100*achieved/requested, res.backlog)
+		if r := res.clientRates; r != nil {
+			fmt.Fprintf(w, "  per client: min %.0f, mean %.0f, max %.0f ops/s\n", r.min, r.mean, r.max)
+		}
+		return
+	}
+	fmt.Fprintf(w, "Requested rate: %.0f ops/s across all clients, achieved: %.0f ops/s (%.1f%%), backlog: %d ops\n",
+		requested, achieved, 100*achieved/requested, res.backlog)
+}
@@ -0,0 +1,58 @@
+package loadgen
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientPacersStaggered(t *testing.T) {
+	cfg := testConfig(t, "-clients", "4", "-rate", "100", "-rate-scope", "client")
+	clk := &fakeClock{now: time.Unix(0, 0)}
+	for i := 0; i < 4; i++ {
+		p := newClientPacer(cfg, i, clk)
+		if got, want := p.slotTime(1).Sub(clk.now), 10*time.Millisecond+time.Duration(i)*2500*time.Microsecond; got != want {
+			t.Errorf("client %d: second slot due after %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestValidateRateScope(t *testing.T) {
+	for _, args := range [][]string{
+		{"-rate-scope", "tenant", "-rate", "100"},
+		{"-rate-scope", "client"},
+		{"-rate-scope", "client", "-rate", "100", "-loop", "open"},
+	} {
+		if _, err := parseFlags(append(args, "-clients", "2")); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	if cfg := testConfig(t, "-clients", "3", "-rate", "100", "-rate-scope", "client"); cfg.aggregateRate() != 300 {
+		t.Errorf("aggregate rate %v, want 300", cfg.aggregateRate())
+	}
+}
+
+func TestRateScopeClient(t *testing.T) {
+	_, rdb := newTestServer(t)
+	cfg := testConfig(t, "-clients", "4", "-rate", "200", "-rate-scope", "client", "-duration", "500ms")
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	// 500ms at 200 ops/s is 100 operations per client, 400 in all.
+	if n := res.total.attempts(); n < 320 || n > 480 {
+		t.Errorf("%d operations, want ~400", n)
+	}
+	r := res.clientRates
+	if r == nil || r.min < 150 || r.max > 250 {
+		t.Fatalf("client rates %+v, want each ~200 ops/s", r)
+	}
+	var b strings.Builder
+	printSummary(&b, cfg, res)
+	if !strings.Contains(b.String(), "Requested rate: 200 ops/s per client, 800 ops/s in all") {
+		t.Errorf("summary:\n%s", b.String())
+	}
+	if rep := buildReport(cfg, res); rep.RequestedRate != 800 || rep.RateScope != rateClient || rep.ClientRates == nil {
+		t.Errorf("report rate %v, scope %q, clients %+v", rep.RequestedRate, rep.RateScope, rep.ClientRates)
+	}
+}
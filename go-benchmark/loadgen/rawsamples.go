@@ -0,0 +1,225 @@
+package loadgen
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// rawOutcome is the result column of a raw sample.
+type rawOutcome uint8
+
+const (
+	rawOK rawOutcome = iota
+	rawMiss
+	rawErr
+)
+
+var rawOutcomeNames = [...]string{rawOK: "ok", rawMiss: "miss", rawErr: "err"}
+
+// rawSample is one measured operation written by -raw-out.
+type rawSample struct {
+	start     time.Time
+	op        opType
+	latency   time.Duration
+	keySize   int
+	valueSize int
+	outcome   rawOutcome
+}
+
+const (
+	// rawBatchSize samples are buffered by a worker before being handed to
+	// the writer in one channel send.
+	rawBatchSize = 256
+	// rawQueueBatches is the per-worker queue depth; a worker whose queue is
+	// full drops its batch instead of waiting for the disk.
+	rawQueueBatches = 16
+)
+
+// rawWriter streams raw samples to a CSV file from a single goroutine fed by
+// bounded per-worker queues.
+type rawWriter struct {
+	path    string
+	queues  []chan []rawSample
+	dropped atomic.Int64
+	written int64
+	done    chan struct{}
+
+	file *os.File
+	gz   *gzip.Writer
+	w    *bufio.Writer
+	err  error
+	pool sampleBufferPool
+}
+
+// sampleBufferPool recycles sample batches between workers and the writer.
+type sampleBufferPool chan []rawSample
+
+func (p sampleBufferPool) get() []rawSample {
+	select {
+	case b := <-p:
+		return b[:0]
+	default:
+		return make([]rawSample, 0, rawBatchSize)
+	}
+}
+
+func (p sampleBufferPool) put(b []rawSample) {
+	select {
+	case p <- b:
+	default:
+	}
+}
+
+// newRawWriter creates path and starts the writer goroutine for workers
+// queues. The output is gzip-compressed when path ends in ".gz".
+func newRawWriter(path string, workers int) (*rawWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create raw samples file: %w", err)
+	}
+	rw := &rawWriter{
+		path:   path,
+		queues: make([]chan []rawSample, workers),
+		done:   make(chan struct{}),
+		file:   f,
+		pool:   make(sampleBufferPool, 2*workers),
+	}
+	var out io.Writer = f
+	if strings.HasSuffix(path, ".gz") {
+		rw.gz = gzip.NewWriter(f)
+		out = rw.gz
+	}
+	rw.w = bufio.NewWriterSize(out, 1<<16)
+	for i := range rw.queues {
+		rw.queues[i] = make(chan []rawSample, rawQueueBatches)
+	}
+	rw.w.WriteString("timestamp_us,command,latency_us,key_size,value_size,result\n")
+	go rw.run()
+	return rw, nil
+}
+
+// run drains every queue until all of them are closed. Queues are polled
+// rather than selected on so the number of workers is not bounded.
+func (rw *rawWriter) run() {
+	defer close(rw.done)
+	queues := append([]chan []rawSample(nil), rw.queues...)
+	open := len(queues)
+	var line []byte
+	for open > 0 {
+		idle := true
+		for i, q := range queues {
+			if q == nil {
+				continue
+			}
+			select {
+			case batch, ok := <-q:
+				idle = false
+				if !ok {
+					queues[i] = nil
+					open--
+					continue
+				}
+				for _, s := range batch {
+					line = appendRawSample(line[:0], s)
+					if _, err := rw.w.Write(line); err != nil && rw.err == nil {
+						rw.err = err
+					}
+				}
+				rw.written += int64(len(batch))
+				rw.pool.put(batch)
+			default:
+			}
+		}
+		if idle {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func appendRawSample(b []byte, s rawSample) []byte {
+	b = strconv.AppendInt(b, s.start.UnixMicro(), 10)
+	b = append(b, ',')
+	b = append(b, s.op.String()...)
+	b = append(b, ',')
+	b = strconv.AppendFloat(b, float64(s.latency)/float64(time.Microsecond), 'f', 3, 64)
+	b = append(b, ',')
+	b = strconv.AppendInt(b, int64(s.keySize), 10)
+	b = append(b, ',')
+	b = strconv.AppendInt(b, int64(s.valueSize), 10)
+	b = append(b, ',')
+	b = append(b, rawOutcomeNames[s.outcome]...)
+	return append(b, '\n')
+}
+
+// close waits for the writer to drain every queue, which requires all
+// workers to have flushed, and then flushes and closes the file.
+func (rw *rawWriter) close() error {
+	<-rw.done
+	err := rw.err
+	if ferr := rw.w.Flush(); err == nil {
+		err = ferr
+	}
+	if rw.gz != nil {
+		if gerr := rw.gz.Close(); err == nil {
+			err = gerr
+		}
+	}
+	if cerr := rw.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write raw samples: %w", err)
+	}
+	return nil
+}
+
+// rawBuffer is a worker's handle on the writer.
+type rawBuffer struct {
+	rw    *rawWriter
+	queue chan []rawSample
+	buf   []rawSample
+}
+
+func (rw *rawWriter) buffer(worker int) *rawBuffer {
+	return &rawBuffer{rw: rw, queue: rw.queues[worker], buf: rw.pool.get()}
+}
+
+// add buffers s and hands full batches to the writer without blocking.
+func (b *rawBuffer) add(s rawSample) {
+	b.buf = append(b.buf, s)
+	if len(b.buf) < rawBatchSize {
+		return
+	}
+	select {
+	case b.queue <- b.buf:
+		b.buf = b.rw.pool.get()
+	default:
+		b.rw.dropped.Add(int64(len(b.buf)))
+		b.buf = b.buf[:0]
+	}
+}
+
+// flush hands over the last partial batch and closes the queue. It runs
+// after the worker's last operation, so it may wait for the writer.
+func (b *rawBuffer) flush() {
+	if len(b.buf) > 0 {
+		b.queue <- b.buf
+	}
+	close(b.queue)
+}
+
+// printRawSamples writes the -raw-out summary line.
+func printRawSamples(w io.Writer, rw *rawWriter) {
+	fmt.Fprintf(w, "Raw samples: %d written to %s", rw.written, rw.path)
+	if d := rw.dropped.Load(); d > 0 {
+		fmt.Fprintf(w, ", %d dropped because the writer fell behind", d)
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,88 @@
+package loadgen
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRawSamplesGzip(t *testing.T) {
+	_, rdb := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "samples.csv.gz")
+	cfg := testConfig(t, "-clients", "3", "-ops", "300", "-raw-out", path)
+	rw, err := newRawWriter(path, cfg.clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.raw = rw
+
+	runBenchmark(context.Background(), rdb, cfg)
+	if err := rw.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(gz).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(rows) - 1; got != 900 || rw.written != 900 || rw.dropped.Load() != 0 {
+		t.Fatalf("file holds %d samples, writer counted %d written and %d dropped, want 900",
+			got, rw.written, rw.dropped.Load())
+	}
+	if rows[1][1] != "SET" || rows[1][5] != "ok" {
+		t.Errorf("unexpected sample row %v", rows[1])
+	}
+}
+
+func TestRawSamplesInterruptedRamp(t *testing.T) {
+	_, rdb := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "samples.csv")
+	cfg := testConfig(t, "-clients", "4", "-duration", "5s", "-ramp-up", "2s", "-raw-out", path)
+	rw, err := newRawWriter(path, cfg.clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.raw = rw
+
+	// Interrupted before the last clients start.
+	runCtx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	runBenchmark(runCtx, rdb, cfg)
+	closed := make(chan error, 1)
+	go func() { closed <- rw.close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("the writer still waits for the clients that never started")
+	}
+}
+
+func TestRawBufferDropsWhenQueueIsFull(t *testing.T) {
+	rw := &rawWriter{queues: []chan []rawSample{make(chan []rawSample, 1)}, pool: make(sampleBufferPool, 1)}
+	b := rw.buffer(0)
+
+	// Nobody drains the queue: the first batch is queued, the second dropped.
+	for i := 0; i < 2*rawBatchSize; i++ {
+		b.add(rawSample{})
+	}
+
+	if got := rw.dropped.Load(); got != rawBatchSize {
+		t.Errorf("dropped %d samples, want %d", got, rawBatchSize)
+	}
+}
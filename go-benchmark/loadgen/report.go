@@ -0,0 +1,466 @@
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+// Supported values for -output.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// writeReport writes the -report page when requested and renders res in the
+// configured format to -out, or to stdout when no file was given.
+func writeReport(cfg *config, res *runResult) error {
+	if cfg.htmlReport != "" {
+		if err := writeHTMLReport(cfg.htmlReport, []*config{cfg}, []*runResult{res}); err != nil {
+			return err
+		}
+	}
+	var w io.Writer = cfg.stdout
+	if cfg.out != "" {
+		f, err := os.Create(cfg.out)
+		if err != nil {
+			return fmt.Errorf("create report file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch cfg.output {
+	case outputJSON:
+		return writeJSON(w, buildReport(cfg, res))
+	default:
+		printSummary(w, cfg, res)
+		return nil
+	}
+}
+
+// printSummary writes the human-readable report.
+func printSummary(w io.Writer, cfg *config, res *runResult) {
+	total := res.total
+	totalTime := res.elapsed()
+	u := cfg.displayUnit(total.latency)
+	switch {
+	case res.abortReason != "":
+		fmt.Fprintf(w, "Load test aborted: %s: PARTIAL RESULTS\n", res.abortReason)
+	case res.partial:
+		fmt.Fprintln(w, "Load test interrupted: PARTIAL RESULTS")
+	default:
+		fmt.Fprintln(w, "Load test completed")
+	}
+	if n := total.timeouts(); n > 0 {
+		fmt.Fprintf(w, "WARNING: %d operations timed out after %v (counted as failed, excluded from latency)\n", n, cfg.opTimeout)
+	}
+	if cfg.dbs != nil {
+		fmt.Fprintf(w, "Target: %s (dbs %s, %s)\n", cfg.addr, cfg.dbList, cfg.transport())
+	} else {
+		fmt.Fprintf(w, "Target: %s (db %d, %s)\n", cfg.addr, cfg.db, cfg.transport())
+	}
+	fmt.Fprintf(w, "Key prefix: %q, seed: %d\n", cfg.keyPrefix, cfg.seed)
+	printTemplates(w, cfg)
+	printEnvironment(w, cfg, res.env)
+	printProfiling(w, cfg)
+	switch {
+	case cfg.trace != nil:
+		fmt.Fprintf(w, "Clients: %d, replaying %s\n", cfg.clients, cfg.replayPath)
+	case cfg.duration > 0:
+		fmt.Fprintf(w, "Clients: %d, duration: %v, workload: %s\n", cfg.clients, cfg.duration, cfg.workload)
+	default:
+		fmt.Fprintf(w, "Clients: %d, operations per client: %d, workload: %s\n", cfg.clients, cfg.opsPerClient, cfg.workload)
+	}
+	if cfg.mixedCommands() {
+		fmt.Fprintf(w, "Command mix: %s\n", cfg.mix)
+	}
+	if cfg.churn {
+		fmt.Fprintln(w, "Connections: a new one per operation (-churn)")
+	}
+	if cfg.protocol == protocolMemcache {
+		fmt.Fprintf(w, "Protocol: memcached text, a pool of %d connections (-protocol memcache)\n", cfg.effectivePoolSize())
+		if cfg.memcacheNoreply {
+			fmt.Fprintln(w, "  set, delete and touch sent with noreply: their latency is that of the write")
+		}
+	}
+	if cfg.protocol == protocolHTTP {
+		version := "HTTP/1.1"
+		if cfg.http2 {
+			version = "HTTP/2"
+		}
+		fmt.Fprintf(w, "Protocol: %s, PUT and GET %s/{key}, TTL in the %s %s (-protocol http)\n", version, strings.TrimSuffix(cfg.httpPath, "/"), cfg.httpTTLName, cfg.httpTTL)
+	}
+	if cfg.client == clientRaw {
+		// A run not started by runTarget does not negotiate and speaks RESP2.
+		fmt.Fprintf(w, "Client: raw RESP%d, one connection per client (-client raw)\n", max(cfg.negotiated, resp2))
+		if cfg.helloErr != "" {
+			fmt.Fprintf(w, "  -resp 3 fell back to RESP2: the server rejected HELLO 3 (%s)\n", cfg.helloErr)
+		}
+	}
+	if cfg.usesKeyspace() {
+		fmt.Fprintf(w, "Keyspace: %d keys, distribution: %s, about %d distinct keys touched\n",
+			cfg.keyspace, describeKeyDist(cfg), keysTouched(cfg, total))
+		printReuse(w, cfg, total)
+	}
+	if cfg.keySize > 0 {
+		fmt.Fprintf(w, "Key size: %d bytes (padded)\n", cfg.keySize)
+	}
+	if cfg.usesHashes() {
+		fmt.Fprintf(w, "Hashes: %d fields each\n", cfg.hashFields)
+	}
+	if cfg.usesZSets() {
+		fmt.Fprintf(w, "Sorted sets: %d members each, ranges of %d\n", cfg.zsetSize, cfg.zsetRange)
+	}
+	if cfg.usesSets() {
+		fmt.Fprintf(w, "Sets: %d members each, %.0f%% of SISMEMBER queries for non-members\n", cfg.setSize, 100*cfg.setMissRatio)
+	}
+	if cfg.ttlRange != "" {
+		fmt.Fprintf(w, "SET TTL: %s\n", cfg.ttlRange)
+	}
+	if v := cfg.values; v != nil {
+		format := fmt.Sprintf("structured, %d-byte header", valueHeaderSize)
+		if cfg.rawValues {
+			format = "raw"
+		}
+		if v.Min() == v.Max() {
+			fmt.Fprintf(w, "Value size: %d bytes (%s)\n", v.Min(), format)
+		} else {
+			fmt.Fprintf(w, "Value size: %d-%d bytes (%s)\n", v.Min(), v.Max(), format)
+		}
+	}
+	if res.preload != nil {
+		printPreload(w, res.preload)
+	}
+	if cfg.rampUp > 0 {
+		fmt.Fprintf(w, "Ramp-up: %d clients started over %v\n", cfg.clients, cfg.rampUp)
+	}
+	if cfg.warmup > 0 || cfg.rampUp > 0 || cfg.steadyState {
+		fmt.Fprintf(w, "Warmup: %d operations in %v (excluded from results)\n", total.warmupOps, res.warmup.Round(time.Millisecond))
+	}
+	if res.steady != nil {
+		printSteadyState(w, res.steady)
+	}
+	fmt.Fprintf(w, "Total time for operations: %v\n", totalTime)
+	completed := total.latency.Count()
+	fmt.Fprintf(w, "Completed operations: %d (%.0f ops/s)\n", completed, float64(completed)/totalTime.Seconds())
+	fmt.Fprintf(w, "Failed operations: %d\n", total.errors())
+	printErrorBreakdown(w, total)
+	if res.httpConns != nil {
+		printHTTPConns(w, res.httpConns)
+	}
+	if res.load != nil {
+		printClientLoad(w, res.load)
+	}
+	if res.pool != nil {
+		printPool(w, res.pool)
+	}
+	if res.clientNames != nil {
+		printClientNames(w, res.clientNames)
+	}
+	if res.health != nil {
+		printClientHealth(w, res.health)
+	}
+	if res.cluster != nil {
+		printCluster(w, res.cluster)
+	}
+	switch {
+	case res.open != nil:
+		printOpenLoop(w, u, cfg, res)
+	case cfg.rate > 0:
+		printRate(w, cfg, res, float64(total.attempts())/totalTime.Seconds())
+	}
+	printInflight(w, u, cfg, res)
+	printThinkTime(w, cfg, res)
+	if res.faults != nil {
+		printFaults(w, buildFaultReport(cfg, res.faults))
+	}
+	if total.bytesWritten > 0 {
+		fmt.Fprintf(w, "Bytes written: %d (%.2f MB/s)\n", total.bytesWritten, megabytesPerSecond(total.bytesWritten, totalTime))
+	}
+	if total.bytesRead > 0 {
+		fmt.Fprintf(w, "Bytes read: %d (%.2f MB/s)\n", total.bytesRead, megabytesPerSecond(total.bytesRead, totalTime))
+	}
+	printLargeValues(w, cfg, res)
+	label := strings.ToUpper(cfg.workload)
+	if cfg.workload == workloadScan {
+		// SCAN alone would read as the latency of the SCAN command.
+		label = "SCAN workload"
+	}
+	fmt.Fprintf(w, "Cumulative time for %s operations: %v\n", label, total.latency.Cumulative())
+	if total.ops[opGet].attempts() > 0 {
+		printHitRatio(w, &total.ops[opGet])
+	}
+	for _, op := range notFoundOps {
+		if s := &total.ops[op]; s.attempts() > 0 {
+			fmt.Fprintf(w, "%s: %d found, %d key not found, %d errors\n", op, s.hits, s.misses, s.errors)
+		}
+	}
+	if cfg.checkFullKeys && total.ops[opHGetAll].attempts() > 0 {
+		if n := total.hashSizeMismatches; n > 0 {
+			fmt.Fprintf(w, "WARNING: %d HGETALL replies did not hold the %d fields of the hash\n", n, cfg.hashFields)
+		} else {
+			fmt.Fprintf(w, "HGETALL replies: all held %d fields\n", cfg.hashFields)
+		}
+	}
+	if total.zsetChecked > 0 {
+		if total.zsetDisorders > 0 || total.zsetShort > 0 {
+			fmt.Fprintf(w, "WARNING: of %d sampled range replies, %d were out of order and %d short\n",
+				total.zsetChecked, total.zsetDisorders, total.zsetShort)
+		} else {
+			fmt.Fprintf(w, "Range replies: %d sampled, all correctly ordered\n", total.zsetChecked)
+		}
+	}
+	if s := &total.ops[opSIsMember]; s.attempts() > 0 {
+		fmt.Fprintf(w, "SISMEMBER: %d members, %d non-members, %d errors\n", s.hits, s.misses, s.errors)
+	}
+	if total.ops[opSIsMember].attempts() > 0 || total.ops[opSMembers].attempts() > 0 {
+		if total.setWrong > 0 || total.setShort > 0 {
+			fmt.Fprintf(w, "CORRECTNESS FAILURE: %d wrong SISMEMBER answers, %d SMEMBERS replies missing members\n",
+				total.setWrong, total.setShort)
+		} else {
+			fmt.Fprintln(w, "Set answers: all correct")
+		}
+	}
+	printLocks(w, u, cfg, total)
+	printBatch(w, cfg, res)
+	printTxn(w, cfg, total)
+	printScript(w, u, cfg, total)
+	printChurn(w, u, total)
+	printTracking(w, u, cfg, total)
+	printFairness(w, res)
+	printAgents(w, res)
+	printRetry(w, u, cfg, total)
+	printVerify(w, u, cfg, total)
+	if cfg.workload == workloadScan {
+		printScan(w, u, cfg, res)
+	}
+	if cfg.workload == workloadAppend {
+		printAppend(w, cfg, total)
+	}
+	if cfg.mixedCommands() || cfg.latencySplit {
+		printCommandBreakdown(w, u, total, totalTime)
+	}
+	if m := buildAchievedMix(cfg, total); m != nil {
+		printAchievedMix(w, m)
+	}
+	printSizes(w, total)
+	printDBs(w, u, res, totalTime)
+	printSLA(w, cfg, res)
+	printOutliers(w, cfg, res)
+	if res.slowlog != nil {
+		printSlowlog(w, cfg, res.slowlog)
+	}
+	printTimeSeries(w, res.series, res.seriesFrom, cfg.percentileWindow)
+	if res.failover != nil {
+		printFailover(w, res.failover)
+	}
+	if res.resilience != nil {
+		printResilience(w, u, res.resilience)
+	}
+	if res.probe != nil {
+		printProbe(w, u, res.probe)
+	}
+	if res.info != nil {
+		printInfo(w, res.info)
+	}
+	if res.evict != nil {
+		printEvict(w, u, res.evict)
+	}
+	if res.expireChurn != nil {
+		printExpireChurn(w, res.expireChurn)
+	}
+	if res.expiry != nil {
+		printExpiryReport(w, res.expiry)
+	}
+	if res.notify != nil {
+		printNotifications(w, res.notify)
+	}
+	if res.ttl != nil {
+		printTTLReport(w, res.ttl)
+	}
+	if res.expired != nil {
+		printExpiredReport(w, res.expired)
+	}
+	if res.shadow != nil {
+		printShadow(w, res.shadow)
+	}
+	if res.counters != nil {
+		printCounterReport(w, res.counters)
+	}
+	if res.final != nil {
+		printFinalReport(w, res.final)
+	}
+	if res.queue != nil {
+		printQueueReport(w, u, cfg, res)
+	}
+	if res.pubsub != nil {
+		printPubSubReport(w, u, res.pubsub)
+	}
+	if res.raw != nil {
+		printRawSamples(w, res.raw)
+	}
+	if res.seriesOut != nil {
+		printSeriesOut(w, res.seriesOut)
+	}
+	if res.hdr != nil {
+		printHdrLog(w, res.hdr)
+	}
+	if res.heatmapPath != "" {
+		printHeatmap(w, res)
+	}
+	if res.record != nil || cfg.trace != nil {
+		printTrace(w, u, cfg, res)
+	}
+	if res.cleanup != nil {
+		printCleanup(w, res.cleanup)
+	}
+	if total.batch != nil {
+		fmt.Fprintf(w, "Pipeline: %d commands per batch, %d batches; command latencies below are amortized per batch\n",
+			cfg.pipeline, total.batch.Count())
+		printLatency(w, u, "Pipeline batch", total.batch)
+	}
+	if total.response != nil {
+		printLatency(w, u, label+" service time", total.latency)
+		if res.open != nil {
+			printLatency(w, u, label+" response time (queue delay included)", total.response)
+		} else {
+			printLatency(w, u, label+" response time (corrected for coordinated omission)", total.response)
+		}
+	} else {
+		printLatency(w, u, label, total.latency)
+	}
+	if s := &total.ops[opSMembers]; s.attempts() > 0 {
+		// SMEMBERS scales with cardinality and would skew the combined
+		// figures above.
+		printLatency(w, u, fmt.Sprintf("SMEMBERS (%d members)", cfg.setSize), s.latency)
+	}
+	if len(cfg.hotPool) > 0 {
+		fmt.Fprintf(w, "Hot keys: %d keys targeted by %.0f%% of operations\n", cfg.hotKeys, 100*cfg.hotFraction)
+		printLatency(w, u, "Hot-key", total.hot)
+		printLatency(w, u, "Cold-key", total.cold)
+	}
+	if s := total.slowest; s.latency > 0 {
+		fmt.Fprintf(w, "Slowest operation: %s, %s %s at %s\n", u.format(s.latency), s.op, s.key, s.at.Format(time.RFC3339Nano))
+	}
+}
+
+// reportPercentiles lists the percentiles printed in every latency summary.
+var reportPercentiles = []float64{50, 90, 99, 99.9}
+
+// printLatency writes min, mean, the standard percentiles and max for h.
+// A histogram without samples, or a nil one, prints N/A rather than zeros.
+func printLatency(w io.Writer, u latencyUnit, label string, h *stats.Histogram) {
+	if h == nil || h.Count() == 0 {
+		fmt.Fprintf(w, "%s latency: N/A (no successful operations)\n", label)
+		return
+	}
+	fmt.Fprintf(w, "%s latency (%d samples):\n", label, h.Count())
+	fmt.Fprintf(w, "  min:   %s\n", u.format(h.Minimum()))
+	fmt.Fprintf(w, "  mean:  %s\n", u.format(h.Mean()))
+	for _, p := range reportPercentiles {
+		fmt.Fprintf(w, "  %-6s %s\n", fmt.Sprintf("p%v:", p), u.format(h.Percentile(p)))
+	}
+	fmt.Fprintf(w, "  max:   %s\n", u.format(h.Maximum()))
+	if n := h.Overflow(); n > 0 {
+		fmt.Fprintf(w, "  overflow: %d samples above -histogram-max %v; percentiles among them report the max\n", n, h.Highest())
+	}
+}
+
+// printHitRatio writes the GET hit/miss/error breakdown. Misses are successful
+// operations and are not counted as errors.
+func printHitRatio(w io.Writer, r *opStats) {
+	lookups := r.hits + r.misses
+	fmt.Fprintf(w, "Hits: %d, misses: %d, errors: %d\n", r.hits, r.misses, r.errors)
+	if lookups == 0 {
+		fmt.Fprintln(w, "Hit ratio: N/A")
+		return
+	}
+	fmt.Fprintf(w, "Hit ratio: %.2f%%\n", 100*float64(r.hits)/float64(lookups))
+}
+
+// printCommandBreakdown writes throughput and latency for each command that
+// was issued during the run, followed by the combined totals. The share is
+// that of the operations issued, failed ones included. Under -latency-split
+// the p50 and p99 of the wait for a connection and of the round trip
+// follow.
+func printCommandBreakdown(w io.Writer, u latencyUnit, r *workerResult, elapsed time.Duration) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	acquire, roundTrip := splitHistograms(r)
+	header := "command\tops\tops/s\tshare\terrors\tmean\tp50\tp99\tmax\t"
+	if acquire != nil {
+		header += "acquire p50\tacquire p99\tround trip p50\tround trip p99\t"
+	}
+	fmt.Fprintln(tw, header)
+	total := r.attempts()
+	for op := opType(0); op < numOpTypes; op++ {
+		s := &r.ops[op]
+		if s.attempts() == 0 {
+			continue
+		}
+		printBreakdownRow(tw, u, op.String(), s.latency, s.errors, total, elapsed)
+		if acquire != nil {
+			printSplitColumns(tw, u, s.acquire, s.roundTrip)
+		}
+		fmt.Fprintln(tw)
+	}
+	printBreakdownRow(tw, u, "all", r.latency, r.errors(), total, elapsed)
+	if acquire != nil {
+		printSplitColumns(tw, u, acquire, roundTrip)
+	}
+	fmt.Fprintln(tw)
+	tw.Flush()
+}
+
+// printSplitColumns completes a row of printCommandBreakdown with the
+// -latency-split percentiles, N/A when its commands were not timed.
+func printSplitColumns(w io.Writer, u latencyUnit, acquire, roundTrip *stats.Histogram) {
+	if acquire == nil || acquire.Count() == 0 {
+		fmt.Fprint(w, "N/A\tN/A\tN/A\tN/A\t")
+		return
+	}
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t", u.format(acquire.Percentile(50)), u.format(acquire.Percentile(99)),
+		u.format(roundTrip.Percentile(50)), u.format(roundTrip.Percentile(99)))
+}
+
+// printBreakdownRow writes the cells of one row of a breakdown table, for
+// the caller to end.
+func printBreakdownRow(w io.Writer, u latencyUnit, name string, h *stats.Histogram, errors, total int64, elapsed time.Duration) {
+	var ops int64
+	if h != nil {
+		ops = h.Count()
+	}
+	share := "N/A"
+	if total > 0 {
+		share = fmt.Sprintf("%.1f%%", 100*float64(ops+errors)/float64(total))
+	}
+	if ops == 0 {
+		fmt.Fprintf(w, "%s\t0\t0\t%s\t%d\tN/A\tN/A\tN/A\tN/A\t", name, share, errors)
+		return
+	}
+	fmt.Fprintf(w, "%s\t%d\t%.0f\t%s\t%d\t%s\t%s\t%s\t%s\t", name, ops,
+		float64(ops)/elapsed.Seconds(), share, errors,
+		u.format(h.Mean()), u.format(h.Percentile(50)), u.format(h.Percentile(99)), u.format(h.Maximum()))
+}
+
+// megabytesPerSecond converts a byte count over d into MB/s (10^6 bytes).
+func megabytesPerSecond(bytes int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(bytes) / 1e6 / d.Seconds()
+}
+
+// printErrorBreakdown lists failed operations per error class.
+func printErrorBreakdown(w io.Writer, r *workerResult) {
+	for c := errClass(0); c < numErrClasses; c++ {
+		if n := r.errClasses[c]; n > 0 {
+			fmt.Fprintf(w, "  %s: %d\n", c, n)
+		}
+	}
+}
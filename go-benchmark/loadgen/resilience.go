@@ -0,0 +1,315 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+// defaultResilienceBackoff is the -resilience-backoff range.
+const defaultResilienceBackoff = "50ms:1s"
+
+// maxTriggerOutput caps the output of -outage-cmd kept for the report.
+const maxTriggerOutput = 1024
+
+// validateResilience checks the flags of -resilience and -outage-cmd.
+func (c *config) validateResilience() error {
+	if c.outageCmd != "" && !c.resilience {
+		return errors.New("-outage-cmd requires -resilience")
+	}
+	if !c.resilience {
+		return nil
+	}
+	if c.duration == 0 {
+		// Failed operations would use up -ops during the outage.
+		return errors.New("-resilience requires -duration")
+	}
+	switch {
+	case c.pipeline > 1:
+		return errors.New("-pipeline does not apply to -resilience, which times the outage per operation")
+	case c.cluster:
+		return errors.New("-resilience does not apply to -cluster")
+	case c.sentinelMaster != "":
+		return errors.New("-resilience does not apply to -sentinel-master, which reports failovers itself")
+	}
+	var err error
+	if c.backoffMin, c.backoffMax, err = parseDurationRange(c.resilienceBackoff); err != nil {
+		return fmt.Errorf("-resilience-backoff: %w", err)
+	}
+	if c.backoffMin <= 0 {
+		return errors.New("-resilience-backoff: the minimum must be positive")
+	}
+	if c.outageCmd != "" && (c.outageAt <= 0 || c.outageAt >= c.duration) {
+		return fmt.Errorf("-outage-at must be within -duration %v, got %v", c.duration, c.outageAt)
+	}
+	return nil
+}
+
+// isConnectionLoss reports whether err means the server could not be
+// reached, as opposed to it answering with an error.
+func isConnectionLoss(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch classifyError(err) {
+	case errRefused, errReset, errTimeout:
+		return true
+	}
+	return false
+}
+
+// outage is a window in which operations failed for connection loss: from
+// the first such failure to the next success of any client.
+type outage struct {
+	start, end time.Time
+	failed     int64
+}
+
+// outageTracker follows the reachability of the server across all clients.
+// Successes outside an outage only load an atomic.
+type outageTracker struct {
+	down atomic.Bool
+	// started is set by the first outage; latency is split around it.
+	started atomic.Bool
+
+	mu      sync.Mutex
+	outages []outage
+}
+
+// failure records an operation that failed for connection loss at t.
+func (t *outageTracker) failure(at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.down.Load() {
+		t.outages = append(t.outages, outage{start: at})
+		t.down.Store(true)
+		t.started.Store(true)
+	}
+	t.outages[len(t.outages)-1].failed++
+}
+
+// success records an operation issued at start that reached the server at
+// end. It ends the outage in progress unless it was already in flight when
+// the outage began.
+func (t *outageTracker) success(start, end time.Time) {
+	if !t.down.Load() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if o := &t.outages[len(t.outages)-1]; t.down.Load() && start.After(o.start) {
+		o.end = end
+		t.down.Store(false)
+	}
+}
+
+// newResilienceClient returns the client of one -resilience worker. Its
+// pool holds a single connection; once enough dials failed, a go-redis pool
+// only retries once a second, so a worker replaces the whole client to
+// reconnect when its backoff says so.
+func newResilienceClient(cfg *config) *redis.Client {
+	opt := cfg.clientOptions()
+	opt.PoolSize = 1
+	opt.MinIdleConns = 0
+	return redis.NewClient(opt)
+}
+
+// afterResilience follows an accounted operation of a -resilience run. A
+// connection loss makes the worker back off, exponentially from the
+// minimum of -resilience-backoff up to its maximum, and reconnect before
+// its next operation; any reply resets the backoff.
+func (w *worker) afterResilience(runCtx context.Context, p pendingOp, start, end time.Time) {
+	tr := w.run.outages
+	cfg := w.run.cfg
+	if isConnectionLoss(p.cmd.Err()) {
+		if w.measuring {
+			tr.failure(end)
+		}
+		if w.backoff == 0 {
+			w.backoff = cfg.backoffMin
+		} else {
+			w.backoff = min(2*w.backoff, cfg.backoffMax)
+		}
+		(realClock{}).Sleep(runCtx, w.backoff)
+		w.rdb.Close()
+		w.rdb = newResilienceClient(cfg)
+		return
+	}
+	w.backoff = 0
+	if !w.measuring {
+		return
+	}
+	tr.success(start, end)
+	if p.cmd.Err() != nil && !isMiss(p.cmd.Err()) {
+		return
+	}
+	r := w.result.resilienceStats()
+	h := r.pre
+	if tr.started.Load() {
+		h = r.post
+	}
+	h.Record(end.Sub(start))
+}
+
+// resilienceStats splits the latency of a -resilience run around outages.
+type resilienceStats struct {
+	// pre holds the operations before the first outage and post those
+	// after a recovery.
+	pre  *stats.Histogram
+	post *stats.Histogram
+}
+
+// resilienceStats returns the -resilience latencies of r, allocating them
+// on first use.
+func (r *workerResult) resilienceStats() *resilienceStats {
+	if r.resilience == nil {
+		r.resilience = &resilienceStats{pre: r.layout.New(), post: r.layout.New()}
+	}
+	return r.resilience
+}
+
+func (s *resilienceStats) merge(o *resilienceStats) {
+	s.pre.Merge(o.pre)
+	s.post.Merge(o.post)
+}
+
+// outageTrigger runs -outage-cmd once, -outage-at into the measured run.
+type outageTrigger struct {
+	cmd   string
+	timer *time.Timer
+	done  chan struct{}
+	rep   triggerReport
+}
+
+// triggerReport describes the run of -outage-cmd.
+type triggerReport struct {
+	Command string `json:"command"`
+	// AtSeconds is when the command started, from the start of the
+	// measured window.
+	AtSeconds       float64 `json:"at_seconds"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Error           string  `json:"error,omitempty"`
+	Output          string  `json:"output,omitempty"`
+	started         time.Time
+}
+
+// startOutageTrigger schedules cmd through sh -c after delay.
+func startOutageTrigger(cmd string, delay time.Duration) *outageTrigger {
+	t := &outageTrigger{cmd: cmd, done: make(chan struct{}), rep: triggerReport{Command: cmd}}
+	t.timer = time.AfterFunc(delay, func() {
+		defer close(t.done)
+		t.rep.started = time.Now()
+		out, err := exec.Command("sh", "-c", cmd).CombinedOutput()
+		t.rep.DurationSeconds = time.Since(t.rep.started).Seconds()
+		if err != nil {
+			t.rep.Error = err.Error()
+		}
+		if len(out) > maxTriggerOutput {
+			out = out[:maxTriggerOutput]
+		}
+		t.rep.Output = strings.TrimSpace(string(out))
+	})
+	return t
+}
+
+// wait waits for the command to finish, and returns nil when the run ended
+// before it was due.
+func (t *outageTrigger) wait(start time.Time) *triggerReport {
+	if t.timer.Stop() {
+		return nil
+	}
+	<-t.done
+	t.rep.AtSeconds = t.rep.started.Sub(start).Seconds()
+	return &t.rep
+}
+
+// jsonOutage is one outage of a -resilience run. Times are in seconds from
+// the start of the measured window.
+type jsonOutage struct {
+	StartSeconds float64 `json:"start_seconds"`
+	// EndSeconds is the first success after the outage; Recovered is
+	// false when the run ended first.
+	EndSeconds       float64 `json:"end_seconds,omitempty"`
+	Recovered        bool    `json:"recovered"`
+	RecoverySeconds  float64 `json:"time_to_recover_seconds"`
+	FailedOperations int64   `json:"failed_operations"`
+}
+
+// resilienceReport is the -resilience section of a run.
+type resilienceReport struct {
+	Outages []jsonOutage   `json:"outages"`
+	Trigger *triggerReport `json:"trigger,omitempty"`
+	// PreOutage is the latency before the first outage and PostRecovery
+	// that after a recovery; P99Ratio is their p99 ratio.
+	PreOutage    *latencySummary `json:"pre_outage_latency,omitempty"`
+	PostRecovery *latencySummary `json:"post_recovery_latency,omitempty"`
+	P99Ratio     float64         `json:"p99_ratio,omitempty"`
+
+	pre, post *stats.Histogram
+}
+
+// buildResilience summarises the outages tracked in t over a run that
+// measured from start to end.
+func buildResilience(t *outageTracker, trigger *triggerReport, total *workerResult, start, end time.Time) *resilienceReport {
+	rep := &resilienceReport{Outages: []jsonOutage{}, Trigger: trigger}
+	t.mu.Lock()
+	for _, o := range t.outages {
+		j := jsonOutage{StartSeconds: o.start.Sub(start).Seconds(), FailedOperations: o.failed, Recovered: !o.end.IsZero()}
+		if j.Recovered {
+			j.EndSeconds = o.end.Sub(start).Seconds()
+			j.RecoverySeconds = o.end.Sub(o.start).Seconds()
+		} else {
+			j.RecoverySeconds = end.Sub(o.start).Seconds()
+		}
+		rep.Outages = append(rep.Outages, j)
+	}
+	t.mu.Unlock()
+	if s := total.resilience; s != nil {
+		rep.pre, rep.post = s.pre, s.post
+		rep.PreOutage, rep.PostRecovery = summarizeLatency(s.pre), summarizeLatency(s.post)
+		if rep.PreOutage != nil && rep.PostRecovery != nil && rep.PreOutage.P99Ns > 0 {
+			rep.P99Ratio = float64(rep.PostRecovery.P99Ns) / float64(rep.PreOutage.P99Ns)
+		}
+	}
+	return rep
+}
+
+// printResilience writes the -resilience section of the summary.
+func printResilience(w io.Writer, u latencyUnit, rep *resilienceReport) {
+	if t := rep.Trigger; t != nil {
+		status := "succeeded"
+		if t.Error != "" {
+			status = "failed: " + t.Error
+		}
+		fmt.Fprintf(w, "Outage command %q ran at %.1fs for %.1fs and %s\n", t.Command, t.AtSeconds, t.DurationSeconds, status)
+	}
+	if len(rep.Outages) == 0 {
+		fmt.Fprintln(w, "Resilience: no outage observed")
+		return
+	}
+	fmt.Fprintf(w, "Resilience: %d outages\n", len(rep.Outages))
+	for i, o := range rep.Outages {
+		if o.Recovered {
+			fmt.Fprintf(w, "  outage %d: %.1fs to %.1fs, recovered in %.3fs, %d operations lost\n",
+				i+1, o.StartSeconds, o.EndSeconds, o.RecoverySeconds, o.FailedOperations)
+		} else {
+			fmt.Fprintf(w, "  outage %d: from %.1fs, NOT RECOVERED after %.3fs, %d operations lost\n",
+				i+1, o.StartSeconds, o.RecoverySeconds, o.FailedOperations)
+		}
+	}
+	printLatency(w, u, "Pre-outage", rep.pre)
+	printLatency(w, u, "Post-recovery", rep.post)
+	if rep.P99Ratio > 0 {
+		fmt.Fprintf(w, "Post-recovery p99 is %.2fx the pre-outage baseline\n", rep.P99Ratio)
+	}
+}
@@ -0,0 +1,76 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestResilienceOutage(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-duration", "1500ms", "-key-prefix", "rs:",
+		"-resilience", "-resilience-backoff", "5ms:50ms", "-outage-cmd", "echo restarting", "-outage-at", "400ms")
+	go func() {
+		time.Sleep(400 * time.Millisecond)
+		mr.Close()
+		time.Sleep(300 * time.Millisecond)
+		if err := mr.Restart(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rep := res.resilience
+	if rep == nil {
+		t.Fatal("no resilience report")
+	}
+	if len(rep.Outages) != 1 {
+		t.Fatalf("outages %+v, want one", rep.Outages)
+	}
+	o := rep.Outages[0]
+	if !o.Recovered || o.FailedOperations == 0 {
+		t.Errorf("outage %+v, want a recovery after failed operations", o)
+	}
+	if o.RecoverySeconds < 0.2 || o.RecoverySeconds > 0.6 {
+		t.Errorf("recovered in %.3fs of a 300ms outage", o.RecoverySeconds)
+	}
+	if rep.PreOutage == nil || rep.PostRecovery == nil || rep.P99Ratio == 0 {
+		t.Errorf("latency before %+v and after %+v the outage", rep.PreOutage, rep.PostRecovery)
+	}
+	if tr := rep.Trigger; tr == nil || tr.Error != "" || tr.Output != "restarting" || tr.AtSeconds < 0.3 {
+		t.Errorf("trigger %+v", tr)
+	}
+
+	var buf bytes.Buffer
+	printResilience(&buf, latencyUnits[1], rep)
+	if !strings.Contains(buf.String(), "recovered in") {
+		t.Errorf("summary lacks the recovery:\n%s", buf.String())
+	}
+}
+
+func TestResilienceFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-resilience"},
+		{"-resilience", "-duration", "1s", "-pipeline", "4"},
+		{"-resilience", "-duration", "1s", "-resilience-backoff", "0:1s"},
+		{"-outage-cmd", "true", "-duration", "1s"},
+		{"-resilience", "-duration", "1s", "-outage-cmd", "true"},
+		{"-resilience", "-duration", "1s", "-outage-cmd", "true", "-outage-at", "2s"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	cfg := testConfig(t, "-resilience", "-duration", "1s")
+	if cfg.clientOptions().MaxRetries != -1 {
+		t.Error("go-redis retries left on under -resilience")
+	}
+}
@@ -0,0 +1,603 @@
+package loadgen
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Clients of -client: go-redis, or the raw client of this file, which
+// encodes and parses RESP itself into buffers reused across commands.
+const (
+	clientGoRedis = "go-redis"
+	clientRaw     = "raw"
+)
+
+// rawClientOps are the commands the raw client sends.
+var rawClientOps = map[opType]bool{opSet: true, opGet: true, opDel: true}
+
+// respDialTimeout bounds the dial of a raw client connection, as go-redis
+// does by default.
+const respDialTimeout = 5 * time.Second
+
+// Protocols of -resp. go-redis v8 speaks RESP2 only; the raw client
+// negotiates RESP3 with HELLO 3.
+const (
+	resp2 = 2
+	resp3 = 3
+)
+
+// validateResp checks -resp and -resp2.
+func (c *config) validateResp() error {
+	for _, p := range []struct {
+		flag  string
+		proto int
+	}{{"-resp", c.resp}, {"-resp2", c.resp2}} {
+		switch {
+		case p.flag == "-resp2" && p.proto == 0:
+		case p.proto != resp2 && p.proto != resp3:
+			return fmt.Errorf("%s must be 2 or 3, got %d", p.flag, p.proto)
+		case p.proto == resp3 && c.client != clientRaw:
+			return fmt.Errorf("%s 3 requires -client raw: go-redis v8 speaks RESP2 only", p.flag)
+		}
+	}
+	return nil
+}
+
+// secondTarget returns the configuration of the second target of a
+// comparison: -addr2, speaking -resp2 and through -protocol2 when given.
+func (c *config) secondTarget() *config {
+	t := c.forTarget(c.addr2)
+	if c.resp2 != 0 {
+		t.resp = c.resp2
+	}
+	if c.protocol2 != "" {
+		t.protocol = c.protocol2
+	}
+	t.resp2, t.protocol2 = 0, ""
+	return t
+}
+
+// targetLabel names the target of c in a comparison: its address, with its
+// front end when the targets differ in theirs, or its protocol when both
+// share the address.
+func targetLabel(c, other *config) string {
+	if c.protocol != other.protocol {
+		return c.addr + " " + c.protocol
+	}
+	if c.addr == other.addr {
+		return fmt.Sprintf("%s RESP%d", c.addr, c.resp)
+	}
+	return c.addr
+}
+
+// negotiateResp settles the protocol the raw client speaks with the target
+// of cfg before the run, so a server without RESP3 costs one message rather
+// than an error per operation. It returns the protocol and, when the
+// server rejected HELLO 3, its error reply.
+func negotiateResp(ctx context.Context, cfg *config) (int, string, error) {
+	if cfg.resp != resp3 {
+		return resp2, "", nil
+	}
+	c, err := dialResp(ctx, cfg, resp2)
+	if err != nil {
+		return 0, "", err
+	}
+	defer c.close()
+	var reply respError
+	switch err := c.hello(ctx); {
+	case errors.As(err, &reply):
+		return resp2, string(reply), nil
+	case err != nil:
+		return 0, "", fmt.Errorf("HELLO 3: %w", err)
+	}
+	return resp3, "", nil
+}
+
+// validateRawClient checks the flags of -client raw.
+func (c *config) validateRawClient() error {
+	if c.workload != workloadSet {
+		if c.mix == nil {
+			return fmt.Errorf("-client raw does not apply to the %s workload", c.workload)
+		}
+		for _, op := range c.mix.ops {
+			if !rawClientOps[op] {
+				return fmt.Errorf("-client raw sends SET, GET and DEL, not %s", opNames[op])
+			}
+		}
+	}
+	switch {
+	case c.pipeline > 1:
+		return errors.New("-pipeline does not apply to -client raw, which sends one command at a time")
+	case c.cluster || c.sentinelMaster != "":
+		return errors.New("-client raw connects to a single node, not a -cluster or -sentinel-master")
+	case c.churn || c.resilience || c.retries > 0:
+		return errors.New("-client raw keeps one connection per client and cannot be combined with -churn, -resilience or -retries")
+	case c.verify || c.evictPressure || c.replayPath != "":
+		return errors.New("-client raw does not apply to -verify, -evict-pressure or -replay")
+	}
+	return nil
+}
+
+// respError is an error reply of the server. Like the errors go-redis
+// returns for one, it is a redis.Error.
+type respError string
+
+func (e respError) Error() string { return string(e) }
+func (respError) RedisError()     {}
+
+// respProtocolError is a reply the raw client cannot parse.
+type respProtocolError struct {
+	msg string
+}
+
+func (e *respProtocolError) Error() string { return "resp: " + e.msg }
+
+// respConn is a connection of the raw client. Commands are encoded into
+// out and bulk replies read into bulk, both kept across commands, so a
+// command allocates nothing once they have grown.
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	out  []byte
+	bulk []byte
+	// deadline is set while the connection carries one.
+	deadline bool
+	// proto is the protocol the connection speaks.
+	proto int
+	// timing is set under -large-values, where roundTrip notes in
+	// firstByte when the first byte of each reply arrived.
+	timing    bool
+	firstByte time.Time
+}
+
+// dialResp opens a raw client connection to the target of cfg speaking
+// proto, and authenticates and selects the database like go-redis does.
+func dialResp(ctx context.Context, cfg *config, proto int) (*respConn, error) {
+	d := &net.Dialer{Timeout: respDialTimeout}
+	conn, err := d.DialContext(ctx, cfg.network(), cfg.dialAddr())
+	if err != nil {
+		return nil, err
+	}
+	if cfg.tlsConfig != nil {
+		tc := tls.Client(conn, cfg.tlsConfig)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+	c := &respConn{conn: conn, r: bufio.NewReaderSize(conn, 1<<16), proto: resp2, timing: cfg.large != nil}
+	if cfg.password != "" {
+		if err := c.status(ctx, "AUTH", cfg.password); err != nil {
+			c.close()
+			return nil, fmt.Errorf("AUTH: %w", err)
+		}
+	}
+	if proto == resp3 {
+		// After AUTH: servers refuse HELLO on a connection not yet
+		// authenticated.
+		if err := c.hello(ctx); err != nil {
+			c.close()
+			return nil, fmt.Errorf("HELLO 3: %w", err)
+		}
+	}
+	if cfg.db != 0 {
+		if err := c.status(ctx, "SELECT", strconv.Itoa(cfg.db)); err != nil {
+			c.close()
+			return nil, fmt.Errorf("SELECT: %w", err)
+		}
+	}
+	if cfg.namer != nil {
+		err := cfg.namer.setName(ctx, func(ctx context.Context, name string) error {
+			return c.status(ctx, "CLIENT", "SETNAME", name)
+		})
+		if err != nil {
+			c.close()
+			return nil, fmt.Errorf("CLIENT SETNAME: %w", err)
+		}
+	}
+	return c, nil
+}
+
+func (c *respConn) close() error {
+	return c.conn.Close()
+}
+
+// appendArray appends the header of a command of n arguments.
+func appendArray(b []byte, n int) []byte {
+	b = append(b, '*')
+	b = strconv.AppendInt(b, int64(n), 10)
+	return append(b, '\r', '\n')
+}
+
+// appendBulk appends one argument as a bulk string.
+func appendBulk[T string | []byte](b []byte, arg T) []byte {
+	b = append(b, '$')
+	b = strconv.AppendInt(b, int64(len(arg)), 10)
+	b = append(b, '\r', '\n')
+	b = append(b, arg...)
+	return append(b, '\r', '\n')
+}
+
+// respReply is a parsed reply. A string aliases the connection's buffer and
+// is only valid until the next reply is read. An aggregate carries its
+// number of elements in n, a map and an attribute their number of pairs;
+// the elements follow.
+type respReply struct {
+	kind byte
+	str  []byte
+	n    int64
+	// null is set for the RESP2 null bulk string and array and the RESP3
+	// null: a missing key.
+	null bool
+}
+
+// aggregate reports whether elements follow the reply.
+func (r respReply) aggregate() bool {
+	switch r.kind {
+	case '*', '%', '~', '>', '|':
+		return !r.null
+	}
+	return false
+}
+
+// elements is the number of replies that follow an aggregate.
+func (r respReply) elements() int64 {
+	if r.kind == '%' || r.kind == '|' {
+		return 2 * r.n
+	}
+	return r.n
+}
+
+// roundTrip sends the command in c.out and reads its reply, bounded by the
+// deadline of ctx. A server error reply is returned as a respError with
+// the reply; any other error leaves the connection unusable.
+func (c *respConn) roundTrip(ctx context.Context) (respReply, error) {
+	if d, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(d)
+		c.deadline = true
+	} else if c.deadline {
+		c.conn.SetDeadline(time.Time{})
+		c.deadline = false
+	}
+	if _, err := c.conn.Write(c.out); err != nil {
+		return respReply{}, err
+	}
+	if c.timing {
+		// An error surfaces again reading the reply.
+		if _, err := c.r.Peek(1); err == nil {
+			c.firstByte = time.Now()
+		}
+	}
+	for {
+		rep, err := c.readReply()
+		switch {
+		case err != nil:
+			return rep, err
+		case rep.kind == '>':
+			// Push messages arrive out of band and answer nothing the
+			// raw client sends.
+			if err := c.skip(rep); err != nil {
+				return rep, err
+			}
+			continue
+		case rep.kind == '-' || rep.kind == '!':
+			err = respError(rep.str)
+		}
+		return rep, err
+	}
+}
+
+// readReply parses one reply. Attributes, which RESP3 servers may send
+// ahead of any reply, are read past.
+func (c *respConn) readReply() (respReply, error) {
+	for {
+		rep, err := c.readValue()
+		if err != nil || rep.kind != '|' {
+			return rep, err
+		}
+		if err := c.skip(rep); err != nil {
+			return rep, err
+		}
+	}
+}
+
+// skip reads past the elements of an aggregate, nested ones included.
+func (c *respConn) skip(rep respReply) error {
+	for i := int64(0); rep.aggregate() && i < rep.elements(); i++ {
+		el, err := c.readValue()
+		if err != nil {
+			return err
+		}
+		if err := c.skip(el); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readValue parses the next value of the stream: a scalar, or the header of
+// an aggregate.
+func (c *respConn) readValue() (respReply, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return respReply{}, err
+	}
+	rep := respReply{kind: line[0]}
+	switch rep.kind {
+	case '+', '-', '#', ',', '(':
+		// Simple strings, errors, booleans, doubles and big numbers are
+		// short: copying them keeps the reader's buffer free for the
+		// next reply.
+		rep.str = append(c.bulk[:0], line[1:]...)
+		c.bulk = rep.str
+		return rep, nil
+	case ':':
+		if rep.n, err = parseRespInt(line[1:]); err != nil {
+			return rep, err
+		}
+		return rep, nil
+	case '_':
+		if len(line) != 1 {
+			return rep, &respProtocolError{fmt.Sprintf("malformed null %q", line)}
+		}
+		rep.null = true
+		return rep, nil
+	case '*', '%', '~', '>', '|':
+		if rep.n, err = parseRespInt(line[1:]); err != nil {
+			return rep, err
+		}
+		switch {
+		case rep.n == -1 && rep.kind == '*':
+			rep.null = true
+		case rep.n < 0:
+			return rep, &respProtocolError{fmt.Sprintf("invalid aggregate length %d", rep.n)}
+		}
+		return rep, nil
+	case '$', '!', '=':
+		// Bulk strings, blob errors and verbatim strings.
+		n, err := parseRespInt(line[1:])
+		switch {
+		case err != nil:
+			return rep, err
+		case n == -1 && rep.kind == '$':
+			rep.null = true
+			return rep, nil
+		case n < 0:
+			return rep, &respProtocolError{fmt.Sprintf("invalid bulk length %d", n)}
+		}
+		if cap(c.bulk) < int(n)+2 {
+			c.bulk = make([]byte, n+2)
+		}
+		buf := c.bulk[:n+2]
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return rep, err
+		}
+		if buf[n] != '\r' || buf[n+1] != '\n' {
+			return rep, &respProtocolError{"bulk string not terminated by CRLF"}
+		}
+		rep.str = buf[:n]
+		if rep.kind == '=' {
+			// A verbatim string starts with its format, as in txt:.
+			if n < 4 || rep.str[3] != ':' {
+				return rep, &respProtocolError{"verbatim string without a format"}
+			}
+			rep.str = rep.str[4:]
+		}
+		return rep, nil
+	}
+	return rep, &respProtocolError{fmt.Sprintf("unexpected reply type %q", rep.kind)}
+}
+
+// readLine returns the next CRLF-terminated line without its terminator.
+// It aliases the reader's buffer.
+func (c *respConn) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, &respProtocolError{"reply line too long"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, &respProtocolError{fmt.Sprintf("malformed reply line %q", line)}
+	}
+	return line[:len(line)-2], nil
+}
+
+// parseRespInt parses the integer of a reply line without allocating.
+func parseRespInt(b []byte) (int64, error) {
+	if len(b) == 0 {
+		return 0, &respProtocolError{"empty integer"}
+	}
+	neg := b[0] == '-'
+	if neg {
+		b = b[1:]
+	}
+	var n int64
+	for _, d := range b {
+		if d < '0' || d > '9' || n > (1<<63-1)/10 {
+			return 0, &respProtocolError{fmt.Sprintf("invalid integer %q", b)}
+		}
+		n = n*10 + int64(d-'0')
+	}
+	if neg {
+		n = -n
+	}
+	return n, nil
+}
+
+// command sends a command of string arguments and returns its reply.
+func (c *respConn) command(ctx context.Context, args ...string) (respReply, error) {
+	c.out = appendArray(c.out[:0], len(args))
+	for _, a := range args {
+		c.out = appendBulk(c.out, a)
+	}
+	return c.roundTrip(ctx)
+}
+
+// status sends a command of string arguments expecting a status reply.
+func (c *respConn) status(ctx context.Context, args ...string) error {
+	rep, err := c.command(ctx, args...)
+	if err == nil && rep.kind != '+' {
+		err = &respProtocolError{fmt.Sprintf("unexpected reply type %q to %s", rep.kind, args[0])}
+	}
+	return err
+}
+
+// integer sends a command of string arguments expecting an integer reply.
+func (c *respConn) integer(ctx context.Context, args ...string) (int64, error) {
+	rep, err := c.command(ctx, args...)
+	if err == nil && rep.kind != ':' {
+		err = &respProtocolError{fmt.Sprintf("unexpected reply type %q to %s", rep.kind, args[0])}
+	}
+	return rep.n, err
+}
+
+// hello switches the connection to RESP3 with HELLO 3 and checks the
+// protocol the server reports in its reply.
+func (c *respConn) hello(ctx context.Context) error {
+	c.out = appendArray(c.out[:0], 2)
+	c.out = appendBulk(c.out, "HELLO")
+	c.out = appendBulk(c.out, "3")
+	rep, err := c.roundTrip(ctx)
+	if err != nil {
+		return err
+	}
+	if rep.kind != '%' {
+		return &respProtocolError{fmt.Sprintf("unexpected reply type %q to HELLO", rep.kind)}
+	}
+	proto := int64(-1)
+	for i := int64(0); i < rep.n; i++ {
+		key, err := c.readReply()
+		if err != nil {
+			return err
+		}
+		name := string(key.str)
+		val, err := c.readReply()
+		if err != nil {
+			return err
+		}
+		if name == "proto" {
+			proto = val.n
+		}
+		if err := c.skip(val); err != nil {
+			return err
+		}
+	}
+	if proto != resp3 {
+		return &respProtocolError{fmt.Sprintf("HELLO 3 answered with protocol %d", proto)}
+	}
+	c.proto = resp3
+	return nil
+}
+
+// ping sends PING.
+func (c *respConn) ping(ctx context.Context) error {
+	return c.status(ctx, "PING")
+}
+
+// set sends SET key value, with PX ttl when ttl is positive.
+func (c *respConn) set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	n := 3
+	if ttl > 0 {
+		n = 5
+	}
+	c.out = appendArray(c.out[:0], n)
+	c.out = appendBulk(c.out, "SET")
+	c.out = appendBulk(c.out, key)
+	c.out = appendBulk(c.out, value)
+	if ttl > 0 {
+		c.out = appendBulk(c.out, "PX")
+		var digits [20]byte
+		c.out = appendBulk(c.out, strconv.AppendInt(digits[:0], max(int64(ttl/time.Millisecond), 1), 10))
+	}
+	rep, err := c.roundTrip(ctx)
+	if err == nil && rep.kind != '+' {
+		err = &respProtocolError{fmt.Sprintf("unexpected reply type %q to SET", rep.kind)}
+	}
+	return err
+}
+
+// get sends GET key and returns the value, which aliases the connection's
+// buffer, and whether the key exists.
+func (c *respConn) get(ctx context.Context, key string) ([]byte, bool, error) {
+	c.out = appendArray(c.out[:0], 2)
+	c.out = appendBulk(c.out, "GET")
+	c.out = appendBulk(c.out, key)
+	rep, err := c.roundTrip(ctx)
+	if err == nil && rep.kind != '$' && rep.kind != '_' {
+		err = &respProtocolError{fmt.Sprintf("unexpected reply type %q to GET", rep.kind)}
+	}
+	return rep.str, !rep.null, err
+}
+
+// del sends DEL key and returns the number of keys removed.
+func (c *respConn) del(ctx context.Context, key string) (int64, error) {
+	c.out = appendArray(c.out[:0], 2)
+	c.out = appendBulk(c.out, "DEL")
+	c.out = appendBulk(c.out, key)
+	rep, err := c.roundTrip(ctx)
+	if err == nil && rep.kind != ':' {
+		err = &respProtocolError{fmt.Sprintf("unexpected reply type %q to DEL", rep.kind)}
+	}
+	return rep.n, err
+}
+
+// issueRaw sends one operation of type op on the worker's raw connection,
+// dialing it first if needed. A connection that failed other than with an
+// error reply is closed and dialed again by the next operation.
+func (w *worker) issueRaw(ctx context.Context, op opType) pendingOp {
+	cfg := w.run.cfg
+	key, hot := w.hotKey()
+	var p pendingOp
+	var s setArgs
+	switch op {
+	case opGet, opDel:
+		if !hot {
+			key = cfg.keyName(w.keys.Next())
+		}
+		p = pendingOp{op: op, key: key, hot: hot}
+	default:
+		s = w.nextSet(key, hot)
+		p = pendingOp{op: op, bytes: len(s.value), key: s.key, ttl: s.ttl, hot: hot}
+		w.keepSet(&p, s)
+	}
+	if w.resp == nil {
+		if w.resp, p.rawErr = dialResp(ctx, cfg, cfg.negotiated); p.rawErr != nil {
+			return p
+		}
+	}
+	switch op {
+	case opGet:
+		var v []byte
+		v, p.found, p.rawErr = w.resp.get(ctx, key)
+		p.replySize, p.reply, p.firstByte = len(v), v, w.resp.firstByte
+	case opDel:
+		var n int64
+		n, p.rawErr = w.resp.del(ctx, key)
+		p.found = n > 0
+	default:
+		p.rawErr = w.resp.set(ctx, s.key, s.value, s.ttl)
+	}
+	var reply respError
+	if p.rawErr != nil && !errors.As(p.rawErr, &reply) {
+		w.dropResp()
+	}
+	return p
+}
+
+// dropResp closes the worker's raw connection.
+func (w *worker) dropResp() {
+	if w.resp != nil {
+		w.resp.close()
+		w.resp = nil
+	}
+}
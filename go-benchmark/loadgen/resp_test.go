@@ -0,0 +1,333 @@
+package loadgen
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"go-benchmark/stats"
+)
+
+// respResponder serves SET, GET and PING on a local listener, replying +OK,
+// value and +PONG without looking at keys, and rejects HELLO like a server
+// older than RESP3. It allocates nothing per command, so a benchmark
+// against it counts the client's allocations only. It returns the
+// listener's address.
+func respResponder(tb testing.TB, value []byte) string {
+	tb.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { ln.Close() })
+	ok, pong, get := []byte("+OK\r\n"), []byte("+PONG\r\n"), appendBulk(nil, value)
+	hello := []byte("-ERR unknown command 'HELLO'\r\n")
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				c := &respConn{r: r}
+				for {
+					line, err := c.readLine()
+					if err != nil || line[0] != '*' {
+						return
+					}
+					n, err := parseRespInt(line[1:])
+					if err != nil {
+						return
+					}
+					reply := ok
+					for i := int64(0); i < n; i++ {
+						rep, err := c.readReply()
+						if err != nil {
+							return
+						}
+						switch {
+						case i > 0:
+						case bytes.EqualFold(rep.str, []byte("GET")):
+							reply = get
+						case bytes.EqualFold(rep.str, []byte("PING")):
+							reply = pong
+						case bytes.EqualFold(rep.str, []byte("HELLO")):
+							reply = hello
+						}
+					}
+					if _, err := conn.Write(reply); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestRespBinaryValues(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.RequireAuth("secret")
+	c, err := dialResp(context.Background(), testConfig(t, "-addr", mr.Addr(), "-password", "secret", "-db", "2"), resp2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+
+	// Every byte value, CRLFs and a CRLF-looking tail included.
+	value := make([]byte, 0, 260)
+	for i := 0; i < 256; i++ {
+		value = append(value, byte(i))
+	}
+	value = append(value, "\r\n$3"...)
+	ctx := context.Background()
+	if err := c.set(ctx, "bin", value, 1500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	mr.Select(2)
+	if got, _ := mr.Get("bin"); got != string(value) {
+		t.Errorf("server holds %q", got)
+	}
+	if ttl := mr.TTL("bin"); ttl != 1500*time.Millisecond {
+		t.Errorf("TTL %v, want 1.5s", ttl)
+	}
+	got, found, err := c.get(ctx, "bin")
+	if err != nil || !found || !bytes.Equal(got, value) {
+		t.Errorf("GET: %q, %v, %v", got, found, err)
+	}
+	if err := c.set(ctx, "empty", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, found, err := c.get(ctx, "empty"); err != nil || !found || len(got) != 0 {
+		t.Errorf("GET of an empty value: %q, %v, %v", got, found, err)
+	}
+	if _, found, err := c.get(ctx, "missing"); err != nil || found {
+		t.Errorf("GET of a missing key: %v, %v", found, err)
+	}
+	for key, want := range map[string]int64{"bin": 1, "missing": 0} {
+		if n, err := c.del(ctx, key); err != nil || n != want {
+			t.Errorf("DEL %s: %d, %v, want %d", key, n, err, want)
+		}
+	}
+}
+
+func TestRespErrorReplies(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.Lpush("list", "a")
+	ctx := context.Background()
+	c, err := dialResp(ctx, testConfig(t, "-addr", mr.Addr()), resp2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+
+	_, _, err = c.get(ctx, "list")
+	var reply respError
+	if !errors.As(err, &reply) || !strings.HasPrefix(err.Error(), "WRONGTYPE") || classifyError(err) != errServer {
+		t.Fatalf("GET of a list: error %v", err)
+	}
+	// An error reply leaves the connection in step.
+	if err := c.ping(ctx); err != nil {
+		t.Errorf("PING after an error reply: %v", err)
+	}
+
+	if _, err := dialResp(ctx, testConfig(t, "-addr", mr.Addr(), "-password", "wrong"), resp2); err == nil || !strings.HasPrefix(err.Error(), "AUTH: ") {
+		t.Errorf("AUTH without a password set: error %v", err)
+	}
+}
+
+func TestRespReplyParsing(t *testing.T) {
+	parse := func(in string) (respReply, error) {
+		c := &respConn{r: bufio.NewReader(strings.NewReader(in))}
+		return c.readReply()
+	}
+	for in, want := range map[string]respReply{
+		"+OK\r\n":                   {kind: '+', str: []byte("OK")},
+		"-ERR bad\r\n":              {kind: '-', str: []byte("ERR bad")},
+		":-42\r\n":                  {kind: ':', n: -42},
+		"$-1\r\n":                   {kind: '$', null: true},
+		"$0\r\n\r\n":                {kind: '$', str: []byte{}},
+		"$4\r\na\r\nb\r\n":          {kind: '$', str: []byte("a\r\nb")},
+		"$3\r\n\x00\xff\n\r\n":      {kind: '$', str: []byte("\x00\xff\n")},
+		"*-1\r\n":                   {kind: '*', n: -1, null: true},
+		"%2\r\n":                    {kind: '%', n: 2},
+		"_\r\n":                     {kind: '_', null: true},
+		"#t\r\n":                    {kind: '#', str: []byte("t")},
+		",1.5\r\n":                  {kind: ',', str: []byte("1.5")},
+		"(12345678901234567890\r\n": {kind: '(', str: []byte("12345678901234567890")},
+		"!9\r\nERR boom!\r\n":       {kind: '!', str: []byte("ERR boom!")},
+		"=8\r\ntxt:a\r\nb\r\n":      {kind: '=', str: []byte("a\r\nb")},
+		// An attribute is read past.
+		"|1\r\n+ttl\r\n:3\r\n:5\r\n": {kind: ':', n: 5},
+	} {
+		got, err := parse(in)
+		if err != nil || got.kind != want.kind || !bytes.Equal(got.str, want.str) || got.n != want.n || got.null != want.null {
+			t.Errorf("%q: %+v, %v, want %+v", in, got, err, want)
+		}
+	}
+	for in, want := range map[string]string{
+		"@1\r\n":         "unexpected reply type '@'",
+		"~-1\r\n":        "invalid aggregate length -1",
+		"_x\r\n":         "malformed null",
+		"=3\r\nabc\r\n":  "verbatim string without a format",
+		"$3\r\nabcd\r\n": "not terminated by CRLF",
+		"$-2\r\n":        "invalid bulk length -2",
+		":12a\r\n":       `invalid integer "12a"`,
+		"+OK\n":          "malformed reply line",
+		":\r\n":          "empty integer",
+	} {
+		_, err := parse(in)
+		var protoErr *respProtocolError
+		if !errors.As(err, &protoErr) || !strings.Contains(err.Error(), want) || classifyError(err) != errProtocol {
+			t.Errorf("%q: error %v, want %q", in, err, want)
+		}
+	}
+	if _, err := parse("$5\r\nab"); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated bulk string: error %v", err)
+	}
+
+	// skip reads past nested aggregates.
+	c := &respConn{r: bufio.NewReader(strings.NewReader("%2\r\n+a\r\n*2\r\n:1\r\n~1\r\n_\r\n+b\r\n>1\r\n$1\r\nx\r\n:7\r\n"))}
+	rep, err := c.readReply()
+	if err == nil {
+		err = c.skip(rep)
+	}
+	if rep, err2 := c.readReply(); err != nil || err2 != nil || rep.n != 7 {
+		t.Errorf("reply after a skipped map: %+v, %v, %v", rep, err, err2)
+	}
+}
+
+func TestRespHello(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	cfg := testConfig(t, "-addr", mr.Addr(), "-client", "raw", "-resp", "3")
+	proto, rejected, err := negotiateResp(ctx, cfg)
+	if err != nil || proto != resp3 || rejected != "" {
+		t.Fatalf("negotiated RESP%d, %q, %v", proto, rejected, err)
+	}
+	c, err := dialResp(ctx, cfg, resp3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+	if err := c.set(ctx, "k", []byte("v\r\n"), 0); err != nil {
+		t.Fatal(err)
+	}
+	// RESP3 answers a missing key with its own null.
+	for key, want := range map[string]bool{"k": true, "missing": false} {
+		if v, found, err := c.get(ctx, key); err != nil || found != want || (found && string(v) != "v\r\n") {
+			t.Errorf("GET %s over RESP3: %q, %v, %v", key, v, found, err)
+		}
+	}
+
+	// A server without RESP3 is reported once, before the run.
+	cfg = testConfig(t, "-addr", respResponder(t, []byte("v")), "-client", "raw", "-resp", "3")
+	if proto, rejected, err := negotiateResp(ctx, cfg); err != nil || proto != resp2 || rejected != "ERR unknown command 'HELLO'" {
+		t.Errorf("negotiated RESP%d, %q, %v against a RESP2 server", proto, rejected, err)
+	}
+	res, err := runTarget(ctx, testConfig(t, "-addr", cfg.addr, "-client", "raw", "-resp", "3", "-clients", "2", "-ops", "50", "-ratio", "get=1,set=1", "-preload", "0", "-keyspace", "20"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := res.total.errors(); n != 0 {
+		t.Errorf("%d operations failed after the fallback", n)
+	}
+}
+
+func TestRespCompare(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-client", "raw", "-resp2", "3", "-clients", "2", "-ops", "50", "-ratio", "get=1,set=1", "-preload", "0", "-keyspace", "20")
+	second := cfg.secondTarget()
+	if second.addr != cfg.addr || cfg.resp != resp2 || second.resp != resp3 {
+		t.Fatalf("second target %s RESP%d", second.addr, second.resp)
+	}
+	var buf bytes.Buffer
+	for _, c := range []*config{cfg, second} {
+		if _, err := runTarget(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+		printSummary(&buf, c, &runResult{total: newWorkerResult(stats.DefaultLayout)})
+	}
+	if cfg.negotiated != resp2 || second.negotiated != resp3 {
+		t.Errorf("negotiated RESP%d and RESP%d", cfg.negotiated, second.negotiated)
+	}
+	if label := targetLabel(second, cfg); label != mr.Addr()+" RESP3" {
+		t.Errorf("second target labelled %q", label)
+	}
+	for _, want := range []string{"Client: raw RESP2", "Client: raw RESP3"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summaries lack %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestRawClientRun(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-client", "raw", "-clients", "4", "-ops", "100",
+		"-ratio", "get=2,set=2,del=1", "-keyspace", "50", "-preload", "50", "-ttl", "60s")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := res.total.errors(); n != 0 {
+		t.Fatalf("%d operations failed", n)
+	}
+	var total int64
+	for _, op := range []opType{opGet, opSet, opDel} {
+		total += res.total.ops[op].attempts()
+	}
+	if total != 400 {
+		t.Errorf("ran %d of 400 operations", total)
+	}
+	get, del := res.total.ops[opGet], res.total.ops[opDel]
+	if get.hits == 0 || get.hits+get.misses != get.attempts() || del.hits+del.misses != del.attempts() {
+		t.Errorf("GET %+v, DEL %+v", get, del)
+	}
+	if res.pool != nil {
+		t.Error("a raw client run reported pool stats")
+	}
+	// The preload writes without a TTL: only the keys the run set again
+	// must have one.
+	preloaded := make(map[string]string)
+	for i, v := range preloadValues(cfg) {
+		preloaded[cfg.keyName(i)] = string(v)
+	}
+	for _, key := range mr.Keys() {
+		if v, _ := mr.Get(key); mr.TTL(key) == 0 && v != preloaded[key] {
+			t.Errorf("%s has no TTL", key)
+		}
+	}
+}
+
+func TestRawClientFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-client", "redigo"},
+		{"-client", "raw", "-workload", "hash"},
+		{"-client", "raw", "-ratio", "get=1,incr=1"},
+		{"-client", "raw", "-pipeline", "8"},
+		{"-client", "raw", "-cluster"},
+		{"-client", "raw", "-churn"},
+		{"-client", "raw", "-retries", "2"},
+		{"-client", "raw", "-verify"},
+		{"-resp", "3"},
+		{"-resp", "4", "-client", "raw"},
+		{"-client", "raw", "-resp2", "2"},
+		{"-addr2", "localhost:6380", "-resp2", "3"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	testConfig(t, "-client", "raw", "-ratio", "get=1,set=1,del=1", "-clients", "500", "-pool-size", "10")
+	testConfig(t, "-client", "raw", "-addr2", "localhost:6380", "-resp", "3", "-resp2", "2")
+}
@@ -0,0 +1,672 @@
+package loadgen
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+// Report is the machine-readable result of a run, returned by Run and
+// written by -output json. Field names are part of the tool's interface;
+// add fields rather than renaming them.
+type Report struct {
+	Config         jsonConfig `json:"config"`
+	Start          time.Time  `json:"start"`
+	End            time.Time  `json:"end"`
+	ElapsedSeconds float64    `json:"elapsed_seconds"`
+	Partial        bool       `json:"partial"`
+	AbortReason    string     `json:"abort_reason,omitempty"`
+	WarmupOps      int64      `json:"warmup_ops,omitempty"`
+	WarmupSeconds  float64    `json:"warmup_seconds,omitempty"`
+	// Profiling names the profiles taken of the tool during the run.
+	Profiling  *jsonProfiling  `json:"profiling,omitempty"`
+	TotalOps   int64           `json:"total_ops"`
+	FailedOps  int64           `json:"failed_ops"`
+	Throughput float64         `json:"throughput_ops_per_sec"`
+	Latency    *latencySummary `json:"latency"`
+	// ResponseLatency is measured from the intended send time of paced runs
+	// and is omitted when -rate is not set.
+	ResponseLatency *latencySummary `json:"response_latency,omitempty"`
+	// BatchLatency is the round trip of whole pipelines when -pipeline is
+	// set; Latency then holds the amortized per-command latency.
+	BatchLatency *latencySummary  `json:"batch_latency,omitempty"`
+	Errors       map[string]int64 `json:"errors"`
+	// Commands breaks the operations of a workload mixing commands down by
+	// command.
+	Commands   map[string]jsonCommand `json:"commands,omitempty"`
+	TimeSeries []timePoint            `json:"timeseries"`
+	// TimeSeriesStart is the start of the first interval of TimeSeries,
+	// negative when the series includes the -ramp-up.
+	TimeSeriesStart float64 `json:"timeseries_start,omitempty"`
+	// TimeSeriesWindow is the -percentile-window of the windowed
+	// percentiles of TimeSeries.
+	TimeSeriesWindow string          `json:"timeseries_window,omitempty"`
+	Expiry           *expiryReport   `json:"expiry,omitempty"`
+	Notifications    *notifyReport   `json:"notifications,omitempty"`
+	TTLAccuracy      *ttlReport      `json:"ttl_accuracy,omitempty"`
+	ReadAfterExpiry  *expiredReport  `json:"read_after_expiry,omitempty"`
+	Shadow           *shadowReport   `json:"shadow,omitempty"`
+	ClientHealth     *clientHealth   `json:"client_health,omitempty"`
+	Counters         *counterReport  `json:"counters,omitempty"`
+	Final            *finalReport    `json:"verify_final,omitempty"`
+	Locks            *jsonLocks      `json:"locks,omitempty"`
+	Queue            *jsonQueue      `json:"queue,omitempty"`
+	Preload          *jsonPreload    `json:"preload,omitempty"`
+	Cleanup          *jsonCleanup    `json:"cleanup,omitempty"`
+	RawSamples       *jsonRawSamples `json:"raw_samples,omitempty"`
+	HdrLog           *jsonHdrLog     `json:"hdr_log,omitempty"`
+	Heatmap          *jsonHeatmap    `json:"heatmap,omitempty"`
+	Trace            *jsonTrace      `json:"trace,omitempty"`
+	// Timeouts counts, by command, the failed operations that hit
+	// -op-timeout.
+	Timeouts map[string]int64 `json:"timeouts,omitempty"`
+	Slowest  *jsonSlowOp      `json:"slowest,omitempty"`
+	// HotLatency and ColdLatency split Latency by whether the key came from
+	// the -hot-keys pool.
+	HotLatency   *latencySummary  `json:"hot_latency,omitempty"`
+	ColdLatency  *latencySummary  `json:"cold_latency,omitempty"`
+	ErrorClasses map[string]int64 `json:"error_classes"`
+	// RequestedRate is the aggregate -rate, of every client together
+	// under -rate-scope client, whose clients ClientRates describes.
+	RequestedRate float64          `json:"requested_rate,omitempty"`
+	RateScope     string           `json:"rate_scope,omitempty"`
+	ClientRates   *jsonClientRates `json:"client_rates,omitempty"`
+	AchievedRate  float64          `json:"achieved_rate,omitempty"`
+	Backlog       int64            `json:"backlog,omitempty"`
+	// OpenLoop accounts for the arrivals of -loop open.
+	OpenLoop *jsonOpenLoop `json:"open_loop,omitempty"`
+	// Inflight describes the -max-inflight cap.
+	Inflight *inflightReport `json:"inflight,omitempty"`
+	// ClientLoad is the load of the tool itself, to tell a saturated load
+	// generator from a slow server.
+	ClientLoad *clientLoad `json:"client_load,omitempty"`
+	// LatencySplit splits the latency of all commands under
+	// -latency-split.
+	LatencySplit *latencySplit `json:"latency_split,omitempty"`
+	// SteadyState describes how -steady-state settled the run before it
+	// was measured.
+	SteadyState *steadyReport `json:"steady_state,omitempty"`
+	// Faults counts the faults of -fault-inject.
+	Faults *faultReport `json:"fault_injection,omitempty"`
+	// ThinkTime is the -think-time of the clients and the rate it offers.
+	ThinkTime *jsonThinkTime `json:"think_time,omitempty"`
+	// ReReference is the re-reference distance distribution of a
+	// locality -key-dist.
+	ReReference *reuseReport `json:"re_reference,omitempty"`
+	// KeysTouched estimates the distinct keys of the keyspace the measured
+	// run used.
+	KeysTouched   int64   `json:"keys_touched,omitempty"`
+	BytesWritten  int64   `json:"bytes_written"`
+	WriteMBPerSec float64 `json:"write_mb_per_sec"`
+	BytesRead     int64   `json:"bytes_read"`
+	ReadMBPerSec  float64 `json:"read_mb_per_sec"`
+	Hits          int64   `json:"hits,omitempty"`
+	Misses        int64   `json:"misses,omitempty"`
+	// NotFound counts DEL, EXPIRE, HGET and HGETALL operations on keys or
+	// fields that did not exist.
+	NotFound map[string]int64 `json:"not_found,omitempty"`
+	// HashSizeMismatches counts HGETALL replies that did not hold every
+	// field; omitted unless the hashes were known to be complete.
+	HashSizeMismatches *int64 `json:"hgetall_size_mismatches,omitempty"`
+	// SetChecks counts wrong SISMEMBER answers and SMEMBERS replies that
+	// missed members.
+	SetChecks *jsonSetChecks `json:"set_checks,omitempty"`
+	// SMembersLatency is reported on its own because it scales with set
+	// cardinality.
+	SMembersLatency *latencySummary `json:"smembers_latency,omitempty"`
+	// ZRangeChecks describes the range replies sampled by -zset-verify.
+	ZRangeChecks *jsonZRangeChecks `json:"zrange_checks,omitempty"`
+	// Batch describes MGET and MSET per key.
+	Batch *jsonBatch `json:"batch,omitempty"`
+	// Scan describes the SCAN passes of the scan workload.
+	Scan *jsonScan `json:"scan,omitempty"`
+	// Append describes the APPENDs and GETRANGEs of the append workload.
+	Append *jsonAppend `json:"append,omitempty"`
+	// LargeValues describes the sizes, throughput and GET checks of
+	// -large-values.
+	LargeValues *jsonLargeValues `json:"large_values,omitempty"`
+	// LatencyBySize buckets latency by payload size under -size-buckets.
+	LatencyBySize *jsonSizes `json:"latency_by_size,omitempty"`
+	// Txn describes the transactions and their retries.
+	Txn *jsonTxn `json:"txn,omitempty"`
+	// Script describes the EVALSHA calls, latency included.
+	Script *jsonScript `json:"script,omitempty"`
+	// PubSub is the delivery accounting of the pubsub workload.
+	PubSub *pubsubReport `json:"pubsub,omitempty"`
+	// Churn describes the connections dialed by -churn.
+	Churn *jsonChurn `json:"churn,omitempty"`
+	// Tracking describes the local caches of the tracking workload.
+	Tracking *jsonTracking `json:"tracking,omitempty"`
+	// Fairness is the distribution across clients of -fairness.
+	Fairness *jsonFairness `json:"fairness,omitempty"`
+	// Agents breaks a -mode coordinator run down by agent.
+	Agents []jsonAgent `json:"agents,omitempty"`
+	// DBs breaks a -dbs run down by database.
+	DBs []jsonDB `json:"dbs,omitempty"`
+	// Outliers are the operations slower than -capture-outliers.
+	Outliers *jsonOutliers `json:"outliers,omitempty"`
+	// Slowlog holds the server's slowlog of the measured window.
+	Slowlog *jsonSlowlog `json:"slowlog,omitempty"`
+	// SLA is the outcome of the -sla conditions.
+	SLA *jsonSLA `json:"sla,omitempty"`
+	// Retry describes the retries of -retries.
+	Retry *jsonRetry `json:"retry,omitempty"`
+	// AchievedMix is the command mix sent beside the requested one.
+	AchievedMix *achievedMix `json:"achieved_mix,omitempty"`
+	// ReplayTiming is how faithfully -replay kept the pace of its trace.
+	ReplayTiming *replayTiming `json:"replay_timing,omitempty"`
+	// Verify describes the read-backs of -verify.
+	Verify *jsonVerify `json:"verify,omitempty"`
+	// Pool describes the client connection pool over the run.
+	Pool *poolReport `json:"pool,omitempty"`
+	// ClientNames describes the connection names of -client-name.
+	ClientNames *clientNamesReport `json:"client_names,omitempty"`
+	// HTTPConns describes the connection reuse of -protocol http.
+	HTTPConns *httpConnReport `json:"http_conns,omitempty"`
+	// Cluster describes the nodes of a -cluster run.
+	Cluster *clusterReport `json:"cluster,omitempty"`
+	// Failover is the failover timeline of a -sentinel-master run.
+	Failover *failoverReport `json:"failover,omitempty"`
+	// Resilience describes the outages of a -resilience run.
+	Resilience *resilienceReport `json:"resilience,omitempty"`
+	// Probe describes the background PINGs of -probe.
+	Probe *probeReport `json:"probe,omitempty"`
+	// Info holds the server snapshots of -capture-info.
+	Info *infoReport `json:"info,omitempty"`
+	// Evict describes the eviction of an -evict-pressure run.
+	Evict *evictReport `json:"evict_pressure,omitempty"`
+	// ExpireChurn tracks the keys and memory of the server under
+	// -workload churn.
+	ExpireChurn *expireChurnReport `json:"expire_churn,omitempty"`
+	// Tags are the -tag flags of the run, verbatim.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Environment describes the tool, the machine and the server version
+	// of the run.
+	Environment *environment `json:"environment,omitempty"`
+}
+
+// jsonPreload describes the fill phase run before the measured one.
+type jsonPreload struct {
+	Keys           int     `json:"keys"`
+	Written        int64   `json:"written"`
+	Failed         int64   `json:"failed"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	Throughput     float64 `json:"throughput_keys_per_sec"`
+	// Evicted is omitted when the server does not report evictions.
+	Evicted *int64 `json:"evicted,omitempty"`
+	OOM     string `json:"oom,omitempty"`
+}
+
+func buildPreload(p *preloadResult) *jsonPreload {
+	rep := &jsonPreload{
+		Keys:           p.keys,
+		Written:        p.written,
+		Failed:         p.failed,
+		ElapsedSeconds: p.elapsed.Seconds(),
+		Throughput:     p.throughput(),
+	}
+	if p.evicted >= 0 {
+		rep.Evicted = &p.evicted
+	}
+	if p.oom != nil {
+		rep.OOM = p.oom.Error()
+	}
+	return rep
+}
+
+// jsonCleanup describes the -cleanup step run after the measured phase.
+type jsonCleanup struct {
+	Mode           string  `json:"mode"`
+	Removed        int64   `json:"removed"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	Error          string  `json:"error,omitempty"`
+}
+
+// jsonZRangeChecks counts the sampled ZRANGE and ZREVRANGE replies that
+// were out of order or held fewer entries than the sorted set must have.
+type jsonZRangeChecks struct {
+	Checked    int64 `json:"checked"`
+	OutOfOrder int64 `json:"out_of_order"`
+	Short      int64 `json:"short"`
+}
+
+// jsonSetChecks is the correctness outcome of the sets workload.
+type jsonSetChecks struct {
+	WrongAnswers  int64 `json:"wrong_answers"`
+	SMembersShort int64 `json:"smembers_short"`
+}
+
+// jsonSlowOp identifies the successful operation with the longest latency.
+type jsonSlowOp struct {
+	LatencyNs int64     `json:"latency_ns"`
+	At        time.Time `json:"at"`
+	Command   string    `json:"command"`
+	Key       string    `json:"key"`
+}
+
+// jsonRawSamples describes the -raw-out file.
+type jsonRawSamples struct {
+	Path    string `json:"path"`
+	Written int64  `json:"written"`
+	Dropped int64  `json:"dropped"`
+}
+
+// jsonClientRates is the rate requested of every client under -rate-scope
+// client and the spread of those achieved, in operations per second.
+type jsonClientRates struct {
+	Requested float64 `json:"requested"`
+	Min       float64 `json:"min"`
+	Mean      float64 `json:"mean"`
+	Max       float64 `json:"max"`
+}
+
+// jsonHdrLog describes the -hdr-out file.
+type jsonHdrLog struct {
+	Path       string `json:"path"`
+	Intervals  int64  `json:"intervals"`
+	PerCommand bool   `json:"per_command"`
+}
+
+// jsonTrace describes the -record or -replay trace.
+type jsonTrace struct {
+	Path     string  `json:"path"`
+	Mode     string  `json:"mode"`
+	Commands int64   `json:"commands"`
+	Format   string  `json:"format,omitempty"`
+	Timing   string  `json:"timing,omitempty"`
+	Speed    float64 `json:"speed,omitempty"`
+	// Skipped and Filtered count the commands of a MONITOR log left out.
+	Skipped  map[string]int64 `json:"skipped,omitempty"`
+	Filtered int64            `json:"filtered,omitempty"`
+}
+
+// jsonConfig echoes the effective configuration of the run.
+type jsonConfig struct {
+	Addr         string `json:"addr"`
+	Transport    string `json:"transport"`
+	TLS          bool   `json:"tls"`
+	Cluster      bool   `json:"cluster,omitempty"`
+	DB           int    `json:"db"`
+	DBs          string `json:"dbs,omitempty"`
+	Clients      int    `json:"clients"`
+	OpsPerClient int    `json:"ops_per_client,omitempty"`
+	Duration     string `json:"duration,omitempty"`
+	Workload     string `json:"workload"`
+	Pipeline     int    `json:"pipeline,omitempty"`
+	Churn        bool   `json:"churn,omitempty"`
+	Resilience   bool   `json:"resilience,omitempty"`
+	Ratio        string `json:"ratio,omitempty"`
+	Preload      int    `json:"preload,omitempty"`
+	Keyspace     int    `json:"keyspace,omitempty"`
+	KeySize      int    `json:"key_size,omitempty"`
+	KeyDist      string `json:"key_dist,omitempty"`
+	ValueSizeMin int    `json:"value_size_min,omitempty"`
+	ValueSizeMax int    `json:"value_size_max,omitempty"`
+	RawValues    bool   `json:"raw_values,omitempty"`
+	Client       string `json:"client,omitempty"`
+	// KeyTemplate and ValueTemplate are -key-template and -value-template,
+	// and KeyCollisions the indexes of the keyspace sharing a key.
+	KeyTemplate   string `json:"key_template,omitempty"`
+	ValueTemplate string `json:"value_template,omitempty"`
+	KeyCollisions int    `json:"key_collisions,omitempty"`
+	// LatencyUnit is the unit of the text report; latencies here are in
+	// nanoseconds. HistogramMaxNs and HistogramGrowth are the layout of
+	// the histograms behind the percentiles.
+	LatencyUnit     string  `json:"latency_unit"`
+	HistogramMaxNs  int64   `json:"histogram_max_ns"`
+	HistogramGrowth float64 `json:"histogram_growth"`
+	// Frontend is the -protocol of a run on any front end but redis.
+	Frontend string `json:"frontend,omitempty"`
+	// Protocol is the protocol -client raw negotiated, and HelloError
+	// the reply of a server that rejected HELLO 3.
+	Protocol    int     `json:"protocol,omitempty"`
+	HelloError  string  `json:"hello_error,omitempty"`
+	TTL         string  `json:"ttl,omitempty"`
+	KeyPrefix   string  `json:"key_prefix"`
+	Seed        int64   `json:"seed"`
+	OpTimeout   string  `json:"op_timeout,omitempty"`
+	RampUp      string  `json:"ramp_up,omitempty"`
+	HotKeys     int     `json:"hot_keys,omitempty"`
+	HotFraction float64 `json:"hot_fraction,omitempty"`
+	CounterKeys int     `json:"counter_keys,omitempty"`
+	HashFields  int     `json:"hash_fields,omitempty"`
+	ZSetMembers int     `json:"zset_members,omitempty"`
+	ZSetRange   int     `json:"zset_range,omitempty"`
+	SetMembers  int     `json:"set_members,omitempty"`
+	BatchKeys   int     `json:"batch_keys,omitempty"`
+	TxnRetries  int     `json:"txn_retries,omitempty"`
+}
+
+// jsonCommand is the share of one command in the operations of a run.
+type jsonCommand struct {
+	Ops        int64           `json:"ops"`
+	Errors     int64           `json:"errors"`
+	Share      float64         `json:"share"`
+	Throughput float64         `json:"throughput_ops_per_sec"`
+	Latency    *latencySummary `json:"latency"`
+	// Acquire and RoundTrip split Latency under -latency-split.
+	Acquire   *latencySummary `json:"acquire,omitempty"`
+	RoundTrip *latencySummary `json:"round_trip,omitempty"`
+}
+
+// buildCommands returns the statistics of every command issued, keyed by
+// name; Share is the fraction of all operations issued, failed ones
+// included.
+func buildCommands(total *workerResult, elapsed time.Duration) map[string]jsonCommand {
+	all := total.attempts()
+	commands := make(map[string]jsonCommand)
+	for op := opType(0); op < numOpTypes; op++ {
+		s := &total.ops[op]
+		n := s.attempts()
+		if n == 0 {
+			continue
+		}
+		c := jsonCommand{Ops: n - s.errors, Errors: s.errors, Share: float64(n) / float64(all), Latency: summarizeLatency(s.latency),
+			Acquire: summarizeLatency(s.acquire), RoundTrip: summarizeLatency(s.roundTrip)}
+		if elapsed > 0 {
+			c.Throughput = float64(c.Ops) / elapsed.Seconds()
+		}
+		commands[op.String()] = c
+	}
+	return commands
+}
+
+// latencySummary holds the standard latency statistics in nanoseconds.
+type latencySummary struct {
+	Count  int64 `json:"count"`
+	MinNs  int64 `json:"min_ns"`
+	MeanNs int64 `json:"mean_ns"`
+	P50Ns  int64 `json:"p50_ns"`
+	P90Ns  int64 `json:"p90_ns"`
+	P99Ns  int64 `json:"p99_ns"`
+	P999Ns int64 `json:"p999_ns"`
+	MaxNs  int64 `json:"max_ns"`
+	// Overflow counts the samples above -histogram-max.
+	Overflow int64 `json:"overflow,omitempty"`
+}
+
+// summarizeLatency returns the statistics of h, or nil when it is empty so
+// the JSON document shows null instead of misleading zeros.
+func summarizeLatency(h *stats.Histogram) *latencySummary {
+	if h == nil || h.Count() == 0 {
+		return nil
+	}
+	return &latencySummary{
+		Count:    h.Count(),
+		MinNs:    int64(h.Minimum()),
+		MeanNs:   int64(h.Mean()),
+		P50Ns:    int64(h.Percentile(50)),
+		P90Ns:    int64(h.Percentile(90)),
+		P99Ns:    int64(h.Percentile(99)),
+		P999Ns:   int64(h.Percentile(99.9)),
+		MaxNs:    int64(h.Maximum()),
+		Overflow: h.Overflow(),
+	}
+}
+
+// buildReport converts a run into its JSON document.
+func buildReport(cfg *config, res *runResult) *Report {
+	total := res.total
+	elapsed := res.elapsed()
+	rep := &Report{
+		Config: jsonConfig{
+			Addr:       cfg.addr,
+			Transport:  cfg.network(),
+			TLS:        cfg.tlsConfig != nil,
+			Cluster:    cfg.cluster,
+			DB:         cfg.db,
+			DBs:        cfg.dbList,
+			Clients:    cfg.clients,
+			Workload:   cfg.workload,
+			Pipeline:   cfg.pipeline,
+			Churn:      cfg.churn,
+			Resilience: cfg.resilience,
+		},
+		Start:            res.start,
+		End:              res.end,
+		ElapsedSeconds:   elapsed.Seconds(),
+		Partial:          res.partial,
+		AbortReason:      res.abortReason,
+		WarmupOps:        total.warmupOps,
+		WarmupSeconds:    res.warmup.Seconds(),
+		Profiling:        buildProfiling(cfg),
+		TotalOps:         total.latency.Count(),
+		FailedOps:        total.errors(),
+		Latency:          summarizeLatency(total.latency),
+		ResponseLatency:  summarizeLatency(total.response),
+		BatchLatency:     summarizeLatency(total.batch),
+		Errors:           make(map[string]int64),
+		ErrorClasses:     make(map[string]int64),
+		TimeSeries:       res.series,
+		TimeSeriesWindow: windowLabel(cfg, res.series),
+		TimeSeriesStart:  res.seriesFrom,
+		Expiry:           res.expiry,
+		Notifications:    res.notify,
+		TTLAccuracy:      res.ttl,
+		ReadAfterExpiry:  res.expired,
+		Shadow:           res.shadow,
+		ClientHealth:     res.health,
+		Counters:         res.counters,
+		Final:            res.final,
+		Locks:            buildLocks(cfg, total),
+		BytesWritten:     total.bytesWritten,
+		WriteMBPerSec:    megabytesPerSecond(total.bytesWritten, elapsed),
+		BytesRead:        total.bytesRead,
+		ReadMBPerSec:     megabytesPerSecond(total.bytesRead, elapsed),
+		Hits:             total.ops[opGet].hits,
+		Misses:           total.ops[opGet].misses,
+	}
+	if cfg.duration > 0 {
+		rep.Config.Duration = cfg.duration.String()
+	} else {
+		rep.Config.OpsPerClient = cfg.opsPerClient
+	}
+	if cfg.rampUp > 0 {
+		rep.Config.RampUp = cfg.rampUp.String()
+	}
+	if cfg.opTimeout > 0 {
+		rep.Config.OpTimeout = cfg.opTimeout.String()
+	}
+	if p := res.preload; p != nil {
+		rep.Preload = buildPreload(p)
+	}
+	if len(cfg.hotPool) > 0 {
+		rep.Config.HotKeys = cfg.hotKeys
+		rep.Config.HotFraction = cfg.hotFraction
+		rep.HotLatency = summarizeLatency(total.hot)
+		rep.ColdLatency = summarizeLatency(total.cold)
+	}
+	if s := total.slowest; s.latency > 0 {
+		rep.Slowest = &jsonSlowOp{LatencyNs: int64(s.latency), At: s.at, Command: s.op.String(), Key: s.key}
+	}
+	for op := opType(0); op < numOpTypes; op++ {
+		if n := total.ops[op].timeouts; n > 0 {
+			if rep.Timeouts == nil {
+				rep.Timeouts = make(map[string]int64)
+			}
+			rep.Timeouts[op.String()] = n
+		}
+	}
+	if rw := res.raw; rw != nil {
+		rep.RawSamples = &jsonRawSamples{Path: rw.path, Written: rw.written, Dropped: rw.dropped.Load()}
+	}
+	rep.Heatmap = heatmapReport(cfg, res)
+	if hw := res.hdr; hw != nil {
+		rep.HdrLog = &jsonHdrLog{Path: hw.path, Intervals: hw.intervals, PerCommand: hw.perOp}
+	}
+	switch {
+	case res.record != nil:
+		rep.Trace = &jsonTrace{Path: res.record.path, Mode: "record", Commands: res.record.written}
+	case cfg.trace != nil:
+		t := cfg.trace
+		rep.Trace = &jsonTrace{Path: cfg.replayPath, Mode: "replay", Commands: int64(t.len()), Format: t.format,
+			Timing: cfg.replayTiming, Skipped: t.skipped, Filtered: t.filtered}
+		if cfg.replayTiming == replayOriginal {
+			rep.Trace.Speed = cfg.replaySpeed
+		}
+	}
+	if c := res.cleanup; c != nil {
+		rep.Cleanup = &jsonCleanup{Mode: c.mode, Removed: c.removed, ElapsedSeconds: c.elapsed.Seconds()}
+		if c.err != nil {
+			rep.Cleanup.Error = c.err.Error()
+		}
+	}
+	rep.Config.KeyPrefix = cfg.keyPrefix
+	rep.Config.KeyTemplate, rep.Config.ValueTemplate = cfg.keyTemplateSpec, cfg.valueTemplateSpec
+	rep.Config.KeyCollisions = cfg.keyCollisions
+	rep.Config.Seed = cfg.seed
+	if cfg.ttlRange != "" {
+		rep.Config.TTL = cfg.ttlRange
+	}
+	if cfg.values != nil {
+		rep.Config.ValueSizeMin = cfg.values.Min()
+		rep.Config.ValueSizeMax = cfg.values.Max()
+		rep.Config.RawValues = cfg.rawValues
+	}
+	rep.Config.Client = cfg.client
+	rep.Config.LatencyUnit = cfg.displayUnit(total.latency).name
+	rep.Config.HistogramMaxNs = int64(cfg.histogramMax)
+	rep.Config.HistogramGrowth = cfg.histogramGrowth
+	if cfg.protocol != protocolRedis {
+		rep.Config.Frontend = cfg.protocol
+	}
+	if cfg.client == clientRaw {
+		rep.Config.Protocol = cfg.negotiated
+		rep.Config.HelloError = cfg.helloErr
+	}
+	if cfg.mixedCommands() {
+		rep.Config.Ratio = cfg.mix.String()
+	}
+	if cfg.mixedCommands() || cfg.latencySplit {
+		rep.Commands = buildCommands(total, elapsed)
+	}
+	if cfg.usesHashes() {
+		rep.Config.HashFields = cfg.hashFields
+		if cfg.checkFullKeys && total.ops[opHGetAll].attempts() > 0 {
+			rep.HashSizeMismatches = &total.hashSizeMismatches
+		}
+	}
+	if cfg.usesZSets() {
+		rep.Config.ZSetMembers = cfg.zsetSize
+		rep.Config.ZSetRange = cfg.zsetRange
+	}
+	if cfg.usesSets() {
+		rep.Config.SetMembers = cfg.setSize
+		rep.SetChecks = &jsonSetChecks{WrongAnswers: total.setWrong, SMembersShort: total.setShort}
+		rep.SMembersLatency = summarizeLatency(total.ops[opSMembers].latency)
+	}
+	if cfg.workload == workloadScan {
+		rep.Scan = buildScan(cfg, res)
+	}
+	if cfg.workload == workloadAppend {
+		rep.Append = buildAppend(cfg, res.total)
+	}
+	if cfg.large != nil {
+		rep.LargeValues = buildLargeValues(cfg, res)
+	}
+	rep.LatencyBySize = buildSizes(res.total)
+	rep.Script = buildScript(cfg, total)
+	rep.PubSub = res.pubsub
+	rep.Churn = buildChurn(total)
+	rep.Tracking = buildTracking(cfg, total)
+	rep.Fairness = buildFairnessReport(res)
+	rep.Agents = buildAgents(res)
+	rep.DBs = buildDBs(res)
+	rep.Outliers = buildOutliers(cfg, res)
+	rep.Slowlog = res.slowlog
+	rep.SLA = buildSLA(cfg, res)
+	rep.Retry = buildRetry(cfg, total)
+	rep.AchievedMix = buildAchievedMix(cfg, total)
+	rep.ReplayTiming = buildReplayTiming(cfg, total)
+	rep.Verify = buildVerify(cfg, total)
+	rep.Pool = res.pool
+	rep.ClientNames = res.clientNames
+	rep.HTTPConns = res.httpConns
+	rep.Cluster = res.cluster
+	rep.Failover = res.failover
+	rep.Resilience = res.resilience
+	rep.Probe = res.probe
+	rep.Info = res.info
+	rep.Evict = res.evict
+	rep.ExpireChurn = res.expireChurn
+	if t := buildTxn(total); t != nil {
+		rep.Config.TxnRetries = cfg.txnRetries
+		rep.Txn = t
+	}
+	if cfg.usesBatches() {
+		rep.Config.BatchKeys = cfg.batchKeys
+		rep.Batch = buildBatch(cfg, res)
+	}
+	if total.zsetChecked > 0 {
+		rep.ZRangeChecks = &jsonZRangeChecks{Checked: total.zsetChecked, OutOfOrder: total.zsetDisorders, Short: total.zsetShort}
+	}
+	if res.queue != nil {
+		rep.Queue = &jsonQueue{
+			queueReport: *res.queue,
+			Enqueue:     summarizeLatency(total.ops[opLPush].latency),
+			Dequeue:     summarizeLatency(mergeHistogram(mergeHistogram(nil, total.ops[opRPop].latency), total.ops[opBRPop].latency)),
+			EndToEnd:    summarizeLatency(total.endToEnd),
+			Depth:       res.queueDepth,
+		}
+	}
+	if res.counters != nil {
+		rep.Config.CounterKeys = cfg.counterKeys
+	}
+	if cfg.usesKeyspace() {
+		rep.Config.Preload = cfg.preload
+		rep.Config.Keyspace = cfg.keyspace
+		rep.Config.KeyDist = describeKeyDist(cfg)
+		rep.KeysTouched = keysTouched(cfg, total)
+		rep.ReReference = summarizeReuse(cfg, total)
+	}
+	rep.Config.KeySize = cfg.keySize
+	if elapsed > 0 {
+		rep.Throughput = float64(rep.TotalOps) / elapsed.Seconds()
+	}
+	if cfg.rate > 0 {
+		rep.RequestedRate = cfg.aggregateRate()
+		rep.RateScope = cfg.rateScope
+		rep.AchievedRate = float64(total.attempts()) / elapsed.Seconds()
+		if r := res.clientRates; r != nil {
+			rep.ClientRates = &jsonClientRates{Requested: cfg.rate, Min: r.min, Mean: r.mean, Max: r.max}
+		}
+		rep.Backlog = res.backlog
+		rep.OpenLoop = buildOpenLoop(cfg, res)
+	}
+	rep.Inflight = res.inflight
+	rep.ClientLoad = res.load
+	rep.LatencySplit = buildLatencySplit(total)
+	rep.SteadyState = res.steady
+	rep.Faults = buildFaultReport(cfg, res.faults)
+	rep.Tags = cfg.tags
+	rep.Environment = res.env
+	rep.ThinkTime = buildThinkTime(cfg, res)
+	for op := opType(0); op < numOpTypes; op++ {
+		if s := &total.ops[op]; s.attempts() > 0 {
+			rep.Errors[op.String()] = s.errors
+		}
+	}
+	for _, op := range notFoundOps {
+		if s := &total.ops[op]; s.attempts() > 0 {
+			if rep.NotFound == nil {
+				rep.NotFound = make(map[string]int64)
+			}
+			rep.NotFound[op.String()] = s.misses
+		}
+	}
+	for c := errClass(0); c < numErrClasses; c++ {
+		if n := total.errClasses[c]; n > 0 {
+			rep.ErrorClasses[c.String()] = n
+		}
+	}
+	return rep
+}
+
+// writeJSON encodes v as indented JSON.
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,84 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+func TestJSONReportRoundTrip(t *testing.T) {
+	cfg, err := parseFlags([]string{"-clients", "2", "-ops", "3", "-ratio", "get=0.5,set=0.5", "-preload", "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := newWorkerResult(stats.DefaultLayout)
+	for _, d := range []time.Duration{time.Millisecond, 2 * time.Millisecond} {
+		total.latency.Record(d)
+		total.ops[opGet].record(stats.DefaultLayout, d)
+	}
+	total.ops[opGet].hits = 2
+	total.ops[opSet].errors = 1
+	start := time.Date(2024, 2, 12, 14, 0, 0, 0, time.UTC)
+	res := &runResult{total: total, start: start, end: start.Add(time.Second)}
+
+	rep := buildReport(cfg, res)
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, rep); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*rep, decoded) {
+		t.Errorf("round trip mismatch:\n got %+v\nwant %+v", decoded, *rep)
+	}
+
+	if decoded.TotalOps != 2 || decoded.FailedOps != 1 || decoded.Throughput != 2 {
+		t.Errorf("totals = %d ops, %d failed, %v ops/s", decoded.TotalOps, decoded.FailedOps, decoded.Throughput)
+	}
+	if decoded.Errors["SET"] != 1 || decoded.Errors["GET"] != 0 {
+		t.Errorf("errors = %v", decoded.Errors)
+	}
+	if decoded.Latency.MinNs != int64(time.Millisecond) || decoded.Latency.MaxNs != int64(2*time.Millisecond) {
+		t.Errorf("latency = %+v", decoded.Latency)
+	}
+}
+
+func TestCommandBreakdown(t *testing.T) {
+	addr := newTestServerAddr(t)
+	cfg := testConfig(t, "-addr", addr, "-clients", "2", "-ops", "50", "-ratio", "get=0.5,set=0.5", "-preload", "10")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := buildReport(cfg, res)
+	get, set := rep.Commands["GET"], rep.Commands["SET"]
+	if len(rep.Commands) != 2 || get.Ops == 0 || set.Ops == 0 || get.Latency == nil {
+		t.Fatalf("commands %+v", rep.Commands)
+	}
+	if get.Ops+set.Ops != rep.TotalOps || math.Abs(get.Share+set.Share-1) > 1e-9 {
+		t.Errorf("GET %d and SET %d of %d ops, shares %v and %v", get.Ops, set.Ops, rep.TotalOps, get.Share, set.Share)
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "share") || !strings.Contains(buf.String(), "100.0%") {
+		t.Errorf("summary lacks the command shares:\n%s", buf.String())
+	}
+
+	// A single command needs no breakdown.
+	cfg = testConfig(t, "-addr", addr, "-clients", "1", "-ops", "5", "-workload", "get")
+	if res, err = runTarget(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if rep := buildReport(cfg, res); rep.Commands != nil {
+		t.Errorf("single command run reports %+v", rep.Commands)
+	}
+}
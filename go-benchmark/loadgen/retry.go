@@ -0,0 +1,156 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+// defaultRetryBackoff is the -retry-backoff range.
+const defaultRetryBackoff = "10ms:1s"
+
+// validateRetries checks -retries and -retry-backoff.
+func (c *config) validateRetries() error {
+	if c.retries < 0 {
+		return fmt.Errorf("-retries must not be negative, got %d", c.retries)
+	}
+	if c.retries == 0 {
+		return nil
+	}
+	switch {
+	case c.pipeline > 1:
+		return errors.New("-retries does not apply to -pipeline, whose commands share one round trip")
+	case c.churn:
+		return errors.New("-retries does not apply to -churn, which dials once per operation")
+	}
+	var err error
+	if c.retryBase, c.retryMax, err = parseDurationRange(c.retryBackoff); err != nil {
+		return fmt.Errorf("-retry-backoff: %w", err)
+	}
+	if c.retryBase <= 0 {
+		return errors.New("-retry-backoff: the base must be positive")
+	}
+	return nil
+}
+
+// retryDelay returns the backoff before retry attempt n, counted from 0:
+// base doubled n times and capped at max, of which a random half is kept
+// so clients that failed together do not retry together.
+func retryDelay(rng *rand.Rand, base, max time.Duration, n int) time.Duration {
+	d := base
+	for i := 0; i < n && d < max; i++ {
+		d *= 2
+	}
+	d = min(d, max)
+	return d/2 + time.Duration(rng.Int63n(int64(d/2)+1))
+}
+
+// retryStats counts the retries of -retries.
+type retryStats struct {
+	// retried counts the operations retried at least once and retries the
+	// attempts past the first.
+	retried int64
+	retries int64
+	// gaveUp counts the operations still failing after -retries retries.
+	gaveUp int64
+	// first is the latency of the first attempt of every operation,
+	// whatever its outcome; the regular latency includes the retries.
+	first *stats.Histogram
+}
+
+func (s *retryStats) merge(o *retryStats) {
+	s.retried += o.retried
+	s.retries += o.retries
+	s.gaveUp += o.gaveUp
+	s.first.Merge(o.first)
+}
+
+// retryStats returns the -retries statistics of r, allocating them on
+// first use.
+func (r *workerResult) retryStats() *retryStats {
+	if r.retry == nil {
+		r.retry = &retryStats{first: r.layout.New()}
+	}
+	return r.retry
+}
+
+// retry re-sends the command of p while it fails with a transient error,
+// connection loss or a timeout, up to -retries times with an exponential
+// backoff between attempts. It returns when the last attempt completed.
+// The backoff gives up early when runCtx is done, so an interrupt is not
+// held up by it. Transactions retry on their own, with -txn-retries.
+func (w *worker) retry(runCtx context.Context, p *pendingOp, start, end time.Time) time.Time {
+	cfg := w.run.cfg
+	stats := w.result.retryStats()
+	stats.first.Record(end.Sub(start))
+	if p.op == opTxn {
+		return end
+	}
+	n := 0
+	for ; n < cfg.retries && isConnectionLoss(p.cmd.Err()); n++ {
+		if !(realClock{}).Sleep(runCtx, retryDelay(w.rng, cfg.retryBase, cfg.retryMax, n)) {
+			break
+		}
+		opCtx, cancel := w.opContext()
+		p.cmd.SetErr(nil)
+		_ = w.rdb.Process(opCtx, p.cmd)
+		end = time.Now()
+		p.timedOut = p.cmd.Err() != nil && opCtx.Err() != nil
+		cancel()
+	}
+	if n > 0 {
+		stats.retried++
+		stats.retries += int64(n)
+		w.result.ops[p.op].retries += int64(n)
+		if n == cfg.retries && isConnectionLoss(p.cmd.Err()) {
+			stats.gaveUp++
+		}
+	}
+	return end
+}
+
+// jsonRetry describes the retries of a -retries run.
+type jsonRetry struct {
+	MaxRetries    int   `json:"max_retries"`
+	BackoffBaseNs int64 `json:"backoff_base_ns"`
+	BackoffMaxNs  int64 `json:"backoff_max_ns"`
+	Retried       int64 `json:"retried_operations"`
+	Retries       int64 `json:"retries"`
+	GaveUp        int64 `json:"gave_up"`
+	// FirstAttempt is the latency of the first attempts alone; the
+	// latency of the run includes the backoff and the retries.
+	FirstAttempt *latencySummary `json:"first_attempt_latency"`
+}
+
+// buildRetry summarises the retries of a run, nil without -retries.
+func buildRetry(cfg *config, total *workerResult) *jsonRetry {
+	s := total.retry
+	if s == nil {
+		return nil
+	}
+	return &jsonRetry{
+		MaxRetries:    cfg.retries,
+		BackoffBaseNs: int64(cfg.retryBase),
+		BackoffMaxNs:  int64(cfg.retryMax),
+		Retried:       s.retried,
+		Retries:       s.retries,
+		GaveUp:        s.gaveUp,
+		FirstAttempt:  summarizeLatency(s.first),
+	}
+}
+
+// printRetry writes the -retries section of the summary.
+func printRetry(w io.Writer, u latencyUnit, cfg *config, total *workerResult) {
+	s := total.retry
+	if s == nil {
+		return
+	}
+	fmt.Fprintf(w, "Retries: %d operations retried %d times (at most %d, backoff %v to %v), %d gave up\n",
+		s.retried, s.retries, cfg.retries, cfg.retryBase, cfg.retryMax, s.gaveUp)
+	printLatency(w, u, "First attempt", s.first)
+}
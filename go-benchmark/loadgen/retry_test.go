@@ -0,0 +1,109 @@
+package loadgen
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRetryDelay(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, c := range []struct {
+		n        int
+		min, max time.Duration
+	}{
+		{0, 5 * time.Millisecond, 10 * time.Millisecond},
+		{2, 20 * time.Millisecond, 40 * time.Millisecond},
+		{10, 50 * time.Millisecond, 100 * time.Millisecond},
+	} {
+		for i := 0; i < 100; i++ {
+			if d := retryDelay(rng, 10*time.Millisecond, 100*time.Millisecond, c.n); d < c.min || d > c.max {
+				t.Fatalf("retry %d waited %v, want %v to %v", c.n, d, c.min, c.max)
+			}
+		}
+	}
+}
+
+func TestRetriesRideOutRestart(t *testing.T) {
+	mr := miniredis.RunT(t)
+	// A pool large enough that go-redis keeps dialing through the outage.
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-duration", "800ms", "-key-prefix", "rt:",
+		"-pool-size", "100", "-retries", "20", "-retry-backoff", "5ms:20ms")
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		mr.Close()
+		time.Sleep(100 * time.Millisecond)
+		if err := mr.Restart(); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := res.total.retry
+	if s == nil || s.retried == 0 || s.retries < s.retried {
+		t.Fatalf("retries %+v, want some across the restart", s)
+	}
+	if s.gaveUp != 0 || res.total.errors() != 0 {
+		t.Errorf("%d gave up, %d errors with retries outlasting the outage", s.gaveUp, res.total.errors())
+	}
+	if s.first.Count() != res.total.attempts() {
+		t.Errorf("%d first attempts for %d operations", s.first.Count(), res.total.attempts())
+	}
+	// The retried operations waited out the outage.
+	if res.total.latency.Maximum() < 50*time.Millisecond {
+		t.Errorf("slowest operation took %v, the retry time is not included", res.total.latency.Maximum())
+	}
+	if rep := buildRetry(cfg, res.total); rep.FirstAttempt == nil || rep.FirstAttempt.MaxNs >= int64(res.total.latency.Maximum()) {
+		t.Errorf("first attempt latency %+v", rep.FirstAttempt)
+	}
+}
+
+func TestRetriesGiveUp(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+	cfg := testConfig(t, "-addr", addr, "-clients", "2", "-duration", "300ms", "-key-prefix", "rt:",
+		"-retries", "1", "-retry-backoff", "100ms:100ms", "-op-timeout", "50ms")
+	rdb, _ := newClient(cfg)
+	defer rdb.Close()
+	begin := time.Now()
+	res := runBenchmark(context.Background(), rdb, cfg)
+	if d := time.Since(begin); d > time.Second {
+		t.Errorf("run took %v, the backoff held it up", d)
+	}
+	s := res.total.retry
+	if s == nil || s.gaveUp == 0 || s.gaveUp > s.retried {
+		t.Errorf("retries %+v, want operations giving up on a dead server", s)
+	}
+
+	// A backoff outlasting the run must not hold up an interrupt.
+	cfg = testConfig(t, "-addr", addr, "-duration", "1s", "-retries", "1", "-retry-backoff", "10s:10s")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	begin = time.Now()
+	runBenchmark(ctx, rdb, cfg)
+	if d := time.Since(begin); d > time.Second {
+		t.Errorf("interrupted run took %v to stop during a backoff", d)
+	}
+}
+
+func TestRetryFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-retries", "-1"},
+		{"-retries", "2", "-pipeline", "4"},
+		{"-retries", "2", "-churn"},
+		{"-retries", "2", "-retry-backoff", "0:1s"},
+		{"-retries", "2", "-retry-backoff", "1s:10ms"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
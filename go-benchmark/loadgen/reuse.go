@@ -0,0 +1,99 @@
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// reuseSlots caps the slots of a reuseTracker at 32 MB. The keys of a larger
+// keyspace share slots and are told apart by reuseTagBits more bits of
+// their index.
+const (
+	reuseSlots   = 1 << 22
+	reuseTagBits = 24
+)
+
+// reuseTracker follows the re-reference distance of the keys the clients
+// pick under a locality -key-dist: the number of picks of other keys, by
+// any client, since the last pick of the same key. It validates that the
+// pattern reaches the cache as intended.
+type reuseTracker struct {
+	// clock counts the picks. A slot holds the clock at the last pick of
+	// its key shifted above the tag of the key, zero for none yet.
+	clock atomic.Uint64
+	slots []atomic.Uint64
+}
+
+func newReuseTracker(keyspace int) *reuseTracker {
+	return &reuseTracker{slots: make([]atomic.Uint64, min(keyspace, reuseSlots))}
+}
+
+// touch records a pick of key i and returns its re-reference distance, or
+// false for the first pick of the key.
+func (t *reuseTracker) touch(i int) (int64, bool) {
+	now := t.clock.Add(1)
+	n := uint64(len(t.slots))
+	tag := uint64(i) / n & (1<<reuseTagBits - 1)
+	old := t.slots[uint64(i)%n].Swap(now<<reuseTagBits | tag)
+	if old == 0 || old&(1<<reuseTagBits-1) != tag {
+		return 0, false
+	}
+	return int64(now - old>>reuseTagBits - 1), true
+}
+
+// recordReuse records the re-reference distance of a pick, counted in the
+// nanoseconds of a histogram, or a first reference.
+func (r *workerResult) recordReuse(d int64, again bool) {
+	if !again {
+		r.firstRefs++
+		return
+	}
+	if r.reuse == nil {
+		r.reuse = r.layout.New()
+	}
+	r.reuse.Record(time.Duration(d))
+}
+
+// reuseReport is the re-reference distance distribution of a locality
+// -key-dist in the JSON report: distances count the picks of other keys
+// between two picks of a key.
+type reuseReport struct {
+	FirstReferences int64   `json:"first_references"`
+	ReReferences    int64   `json:"re_references"`
+	Mean            float64 `json:"mean,omitempty"`
+	P50             int64   `json:"p50,omitempty"`
+	P90             int64   `json:"p90,omitempty"`
+	P99             int64   `json:"p99,omitempty"`
+	Max             int64   `json:"max,omitempty"`
+}
+
+// summarizeReuse returns the re-reference distances of total, nil unless
+// the run followed them.
+func summarizeReuse(cfg *config, total *workerResult) *reuseReport {
+	if !cfg.localityDist() {
+		return nil
+	}
+	r := &reuseReport{FirstReferences: total.firstRefs}
+	if h := total.reuse; h != nil {
+		r.ReReferences = h.Count()
+		r.Mean = float64(h.Mean())
+		r.P50, r.P90, r.P99 = int64(h.Percentile(50)), int64(h.Percentile(90)), int64(h.Percentile(99))
+		r.Max = int64(h.Maximum())
+	}
+	return r
+}
+
+// printReuse writes the re-reference distances of a locality -key-dist.
+func printReuse(w io.Writer, cfg *config, total *workerResult) {
+	r := summarizeReuse(cfg, total)
+	if r == nil {
+		return
+	}
+	fmt.Fprintf(w, "  re-references: %d, first references: %d", r.ReReferences, r.FirstReferences)
+	if r.ReReferences > 0 {
+		fmt.Fprintf(w, "; distance in picks p50 %d, p90 %d, p99 %d, max %d, mean %.0f", r.P50, r.P90, r.P99, r.Max, r.Mean)
+	}
+	fmt.Fprintln(w)
+}
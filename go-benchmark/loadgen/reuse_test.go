@@ -0,0 +1,84 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReuseTracker(t *testing.T) {
+	tr := newReuseTracker(100)
+	for _, i := range []int{1, 2, 3} {
+		if _, again := tr.touch(i); again {
+			t.Errorf("first pick of %d taken for a re-reference", i)
+		}
+	}
+	if d, again := tr.touch(1); !again || d != 2 {
+		t.Errorf("re-reference of 1 at distance %d (%v), want 2", d, again)
+	}
+	if d, again := tr.touch(1); !again || d != 0 {
+		t.Errorf("immediate re-reference of 1 at distance %d (%v), want 0", d, again)
+	}
+
+	// Keys sharing a slot are told apart by their tag.
+	small := &reuseTracker{slots: make([]atomic.Uint64, 4)}
+	small.touch(1)
+	if _, again := small.touch(5); again {
+		t.Error("key 5 taken for a re-reference of key 1")
+	}
+	if _, again := small.touch(1); again {
+		t.Error("key 1 re-referenced after key 5 replaced it")
+	}
+}
+
+func TestLocalityFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-key-dist", "loop"},
+		{"-key-dist", "loop", "-loop-keys", "2000"},
+		{"-key-dist", "lru-friendly", "-reref-prob", "1"},
+		{"-key-dist", "lru-friendly", "-reref-window", "0"},
+		{"-key-dist", "scan", "-mode", "coordinator", "-agents", "a:1,b:2"},
+	} {
+		if _, err := parseFlags(append(args, "-clients", "2", "-keyspace", "1000")); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
+
+func TestLocalityKeyDists(t *testing.T) {
+	run := func(args ...string) *Report {
+		t.Helper()
+		_, rdb := newTestServer(t)
+		cfg := testConfig(t, append(args, "-clients", "1")...)
+		res := runBenchmark(context.Background(), rdb, cfg)
+		rep := buildReport(cfg, res)
+		if rep.ReReference == nil {
+			t.Fatalf("%v reported no re-reference distances", args)
+		}
+		var buf bytes.Buffer
+		printSummary(&buf, cfg, res)
+		if !strings.Contains(buf.String(), "re-references:") {
+			t.Errorf("%v summary without re-references:\n%s", args, buf.String())
+		}
+		return rep
+	}
+
+	// A scan shorter than the keyspace never comes back to a key.
+	if r := run("-key-dist", "scan", "-keyspace", "1000", "-ops", "500").ReReference; r.FirstReferences != 500 || r.ReReferences != 0 {
+		t.Errorf("scan: %d first references, %d re-references", r.FirstReferences, r.ReReferences)
+	}
+
+	// A loop over 50 keys comes back to each after the 49 others.
+	r := run("-key-dist", "loop", "-loop-keys", "50", "-keyspace", "1000", "-ops", "500").ReReference
+	if r.FirstReferences != 50 || r.ReReferences != 450 || r.P50 != 49 || r.Max != 49 {
+		t.Errorf("loop: %+v", r)
+	}
+
+	// lru-friendly re-references about -reref-prob of its picks.
+	r = run("-key-dist", "lru-friendly", "-reref-window", "20", "-reref-prob", "0.8", "-keyspace", "1000000000", "-ops", "2000").ReReference
+	if share := float64(r.ReReferences) / 2000; share < 0.75 || share > 0.85 {
+		t.Errorf("lru-friendly re-referenced %.2f of its picks: %+v", share, r)
+	}
+}
@@ -0,0 +1,112 @@
+// Package loadgen is the load generator behind the go-benchmark command: it
+// runs a workload against a Redis-compatible server from many clients and
+// measures the throughput and latency the server delivers.
+//
+// Programs embed it through Run, which runs one benchmark and returns its
+// Report without printing anything. The go-benchmark command runs Execute,
+// History and Diff on the standard streams it owns: the package itself never
+// prints to them or exits.
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Config is a run of Run. The zero value of a field leaves the option at
+// the default of the command line tool.
+type Config struct {
+	// Addr is the server, host:port or unix:///path/to.sock.
+	Addr string
+	// Workload is one of the -workload names, such as "set", "get" or
+	// "mixed".
+	Workload string
+	Clients  int
+	// OpsPerClient bounds the run by operations, Duration by time.
+	OpsPerClient int
+	Duration     time.Duration
+	Keyspace     int
+	Preload      int
+	ValueSize    int
+	Pipeline     int
+	// Rate paces the run at this many operations per second.
+	Rate float64
+	// Flags sets any other option as on the command line, such as
+	// []string{"-key-dist", "zipfian", "-verify"}. They apply after the
+	// fields above.
+	Flags []string
+	// Logger receives what the run logs, nothing when nil.
+	Logger *slog.Logger
+}
+
+// cliFlags are the options of the command line tool that Run does not
+// support: other kinds of runs, and outputs Run leaves to its caller.
+var cliFlags = []string{
+	"addr2", "resp2", "mode", "listen", "agents", "scenario", "search",
+	"sweep-clients", "sweep-cooldown", "sweep-value-size", "sweep-batch-keys", "sweep-flush",
+	"progress", "out", "report", "store", "push", "push-series", "run-tag", "sla",
+	"save-baseline", "compare-baseline", "fail-threshold",
+	"metrics-addr", "cpuprofile", "memprofile", "pprof-addr", "raw-out", "hdr-out", "heatmap-out", "record",
+	"force", "timeseries-out",
+}
+
+// Run preloads the server of c, runs the benchmark, verifies what the
+// options ask for and cleans up, then returns the report of the run. An
+// interrupted run, once ctx is done, returns a partial report.
+//
+// Concurrent calls run independently of each other.
+func Run(ctx context.Context, c Config) (*Report, error) {
+	cfg, err := parseArgs(c.args(), io.Discard)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range cliFlags {
+		if _, ok := cfg.explicit[name]; ok {
+			return nil, fmt.Errorf("-%s is not supported by Run", name)
+		}
+	}
+
+	if c.Logger != nil {
+		cfg.logger = c.Logger
+	}
+
+	res, err := runTarget(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return buildReport(cfg, res), nil
+}
+
+// args returns c as command line arguments.
+func (c Config) args() []string {
+	var args []string
+	str := func(name, v string) {
+		if v != "" {
+			args = append(args, "-"+name, v)
+		}
+	}
+	num := func(name string, v int) {
+		if v != 0 {
+			args = append(args, "-"+name, strconv.Itoa(v))
+		}
+	}
+	str("addr", c.Addr)
+	str("workload", c.Workload)
+	num("clients", c.Clients)
+	num("ops", c.OpsPerClient)
+	if c.Duration != 0 {
+		str("duration", c.Duration.String())
+	}
+	num("keyspace", c.Keyspace)
+	num("preload", c.Preload)
+	num("value-size", c.ValueSize)
+	num("pipeline", c.Pipeline)
+	if c.Rate != 0 {
+		str("rate", strconv.FormatFloat(c.Rate, 'g', -1, 64))
+	}
+	return append(args, c.Flags...)
+}
@@ -0,0 +1,87 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestRun(t *testing.T) {
+	mr := miniredis.RunT(t)
+	var logs bytes.Buffer
+	c := Config{Addr: mr.Addr(), Workload: "get", Clients: 4, OpsPerClient: 50, Keyspace: 100, Preload: 100, ValueSize: 32,
+		Flags: []string{"-cleanup", "scan-del"}, Logger: slog.New(slog.NewTextHandler(&logs, nil))}
+
+	rep, err := Run(context.Background(), c)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.TotalOps != 200 || rep.FailedOps != 0 || rep.Config.Workload != "get" || rep.Config.ValueSizeMax != 32 || rep.Latency == nil {
+		t.Errorf("report %+v", rep)
+	}
+	if !strings.Contains(logs.String(), "100 keys") {
+		t.Errorf("the logger lacks the preload:\n%s", logs.String())
+	}
+	if n := len(mr.Keys()); n != 0 {
+		t.Errorf("%d keys left after -cleanup", n)
+	}
+}
+
+func TestRunConcurrent(t *testing.T) {
+	// Each run keeps its own logger and histogram layout.
+	var logs [2]bytes.Buffer
+	configs := [2]Config{
+		{Addr: miniredis.RunT(t).Addr(), Workload: "get", Clients: 2, OpsPerClient: 50, Keyspace: 100, Preload: 100,
+			Flags: []string{"-histogram-max", "1us"}, Logger: slog.New(slog.NewTextHandler(&logs[0], nil))},
+		{Addr: miniredis.RunT(t).Addr(), Workload: "get", Clients: 2, OpsPerClient: 50, Keyspace: 60, Preload: 60,
+			Logger: slog.New(slog.NewTextHandler(&logs[1], nil))},
+	}
+	var reps [2]*Report
+	var errs [2]error
+	var wg sync.WaitGroup
+	for i := range configs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			reps[i], errs[i] = Run(context.Background(), configs[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+	if l := reps[0].Latency; l == nil || l.Overflow == 0 || reps[0].Config.HistogramMaxNs != 1000 {
+		t.Errorf("-histogram-max 1us run: latency %+v, config %+v", l, reps[0].Config)
+	}
+	if l := reps[1].Latency; l == nil || l.Overflow != 0 {
+		t.Errorf("default run: latency %+v", l)
+	}
+	if !strings.Contains(logs[0].String(), "100 keys") || strings.Contains(logs[0].String(), "60 keys") {
+		t.Errorf("logs of the first run:\n%s", logs[0].String())
+	}
+	if !strings.Contains(logs[1].String(), "60 keys") || strings.Contains(logs[1].String(), "100 keys") {
+		t.Errorf("logs of the second run:\n%s", logs[1].String())
+	}
+}
+
+func TestRunRejects(t *testing.T) {
+	for _, c := range []Config{
+		{Addr: "x:1", Flags: []string{"-sla", "p99<1ms"}},
+		{Addr: "x:1", Flags: []string{"-sweep-clients", "1,2"}},
+		{Addr: "x:1", Clients: -1},
+		{Addr: "127.0.0.1:1", Clients: 1},
+	} {
+		if _, err := Run(context.Background(), c); err == nil {
+			t.Errorf("%+v was run", c)
+		}
+	}
+}
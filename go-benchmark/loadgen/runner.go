@@ -0,0 +1,1445 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+	"go-benchmark/workload"
+)
+
+// runResult is the merged outcome of one measured run.
+type runResult struct {
+	total   *workerResult
+	start   time.Time
+	end     time.Time
+	partial bool
+
+	// abortReason explains why the run was stopped before completing, for
+	// reasons other than an interrupt.
+	abortReason string
+
+	// series is the per-interval throughput of the measured window.
+	series []timePoint
+
+	// seriesFrom is the start of the first interval of series relative to
+	// the measured window; it is negative when the series covers the ramp.
+	seriesFrom float64
+
+	// steady describes how -steady-state settled the run, nil when not
+	// requested.
+	steady *steadyReport
+	// load is the load of the tool over the measured window, nil when
+	// nothing was measured.
+	load *clientLoad
+
+	// preload describes the fill phase, nil when there was none.
+	preload *preloadResult
+
+	// raw is the -raw-out writer, nil when not requested.
+	raw *rawWriter
+	// hdr is the -hdr-out writer, nil when not requested.
+	hdr *hdrWriter
+	// seriesOut is the -timeseries-out writer, nil when not requested.
+	seriesOut *seriesWriter
+	// heatmap is the latency heatmap of the measured window, nil when
+	// not requested, and heatmapPath the -heatmap-out file it was
+	// written to.
+	heatmap     *stats.Heatmap
+	heatmapPath string
+	// record is the -record writer, nil when not requested.
+	record *traceWriter
+
+	// cleanup describes the -cleanup step, nil when not requested.
+	cleanup *cleanupResult
+
+	// expiry is the result of -verify-expiry, nil when not requested.
+	expiry *expiryReport
+	// ttl is the outcome of -ttl-sample, nil when not requested.
+	ttl *ttlReport
+	// expired is the outcome of -verify-expired, nil when not requested.
+	expired *expiredReport
+	// shadow is the divergence report of -shadow, nil when not requested.
+	shadow *shadowReport
+	// health is the client health of -soak, nil when not requested.
+	health *clientHealth
+	// slowlog holds the slowlog entries of -collect-slowlog, nil when not
+	// requested.
+	slowlog *jsonSlowlog
+	// notify describes the keyevent notifications of
+	// -watch-notifications, nil when not requested.
+	notify *notifyReport
+
+	// counters is the check of the INCR counters, nil when the workload
+	// issues no INCR.
+	counters *counterReport
+
+	// final is the keyspace check of -verify-final, nil when not requested.
+	final *finalReport
+
+	// queue is the message accounting of the queue workload and
+	// queueDepth the list lengths polled during the run; both are nil
+	// for other workloads.
+	queue      *queueReport
+	queueDepth []depthPoint
+
+	// pubsub is the delivery accounting of the pubsub workload.
+	pubsub *pubsubReport
+
+	// clients keeps every client's own statistics for -fairness; nil
+	// when not requested.
+	clients []clientStats
+
+	// dbs splits the operations of a -dbs run by database.
+	dbs []dbStats
+
+	// pool describes the client's connection pool; nil with -churn, whose
+	// connections are not pooled, and with -resilience, whose workers own
+	// their connection.
+	pool *poolReport
+	// httpConns describes the connection reuse of the http target, nil
+	// with any other.
+	httpConns *httpConnReport
+
+	// cluster describes the nodes of a -cluster run.
+	cluster *clusterReport
+
+	// failover is the timeline of a -sentinel-master run.
+	failover *failoverReport
+
+	// resilience describes the outages of a -resilience run.
+	resilience *resilienceReport
+
+	// probe describes the background PINGs of -probe.
+	probe *probeReport
+
+	// info holds the server snapshots of -capture-info.
+	info *infoReport
+
+	// evict describes the eviction of an -evict-pressure run.
+	evict *evictReport
+	// expireChurn tracks the keys and memory of the server under
+	// -workload churn.
+	expireChurn *expireChurnReport
+	// clientNames describes the connection names of -client-name.
+	clientNames *clientNamesReport
+
+	// warmup is how long the discarded warmup phase lasted.
+	warmup time.Duration
+
+	// backlog is the number of paced operations that were due but never
+	// sent when the run ended; zero when -rate is not set.
+	backlog int64
+	// inflight describes the -max-inflight cap, nil without one.
+	inflight *inflightReport
+	// env is the environment of the run.
+	env *environment
+	// faults is the injector of -fault-inject, nil without it.
+	faults *faultInjector
+	// clientRates is the spread of the rates of the clients under
+	// -rate-scope client, nil otherwise.
+	clientRates *clientRates
+	// open accounts for the arrivals of -loop open; nil for a closed loop.
+	open *openLoop
+
+	// agents are the agents of a -mode coordinator run, which measured it.
+	agents []*agentLink
+}
+
+// verifyFailed reports whether an end-of-run data check failed, the server
+// answered a SISMEMBER wrongly, a SCAN pass missed keys unexplained or a
+// -verify read-back did not return the value just written.
+func (r *runResult) verifyFailed() bool {
+	return (r.counters != nil && r.counters.failed()) || (r.queue != nil && r.queue.failed()) || r.total.setWrong > 0 ||
+		(r.total.scan != nil && r.total.scan.unexplained > 0) || (r.total.verify != nil && r.total.verify.failed() > 0) ||
+		(r.final != nil && r.final.failed()) || r.total.appends.failed() || r.total.large.failed() || r.ttl.failed() || r.expired.failed() || r.shadow.failed()
+}
+
+// elapsed returns the wall-clock duration of the measured run.
+func (r *runResult) elapsed() time.Duration {
+	return r.end.Sub(r.start)
+}
+
+// liveCounters are updated by workers with atomic adds only and can be read
+// at any time by background observers while the run is in progress.
+type liveCounters struct {
+	ops        atomic.Int64
+	errors     atomic.Int64
+	errClasses [numErrClasses]atomic.Int64
+	// active is the number of clients still running.
+	active atomic.Int64
+	// arrivals counts the arrivals -loop open generated, warmup included.
+	arrivals atomic.Int64
+
+	// latency is only allocated when an observer needs live percentiles.
+	latency *liveHistogram
+	// buckets is only allocated when -metrics-addr exports a histogram.
+	buckets *bucketCounts
+	// soak is only set under -soak, whose resources the sampler records.
+	soak *soakMonitor
+	// seriesOut is only set under -timeseries-out, which the sampler
+	// writes to.
+	seriesOut *seriesWriter
+}
+
+// runState is shared, read-mostly state of a run handed to every worker.
+type runState struct {
+	cfg  *config
+	rdb  redis.UniversalClient
+	pace *pacer
+	// gate caps the commands in flight under -max-inflight, nil
+	// otherwise.
+	gate *inflightGate
+	// reuse follows the re-reference distances of a locality -key-dist,
+	// nil otherwise.
+	reuse *reuseTracker
+	// heat collects the latency heatmap, nil when not requested.
+	heat *heatmapCollector
+	// clientPace are the pacers of -rate-scope client by client, each set
+	// by its client as it starts, which replace pace.
+	clientPace []*pacer
+	// open generates the arrivals of -loop open, which replaces pace.
+	open *openLoop
+	live *liveCounters
+
+	// measuring flips once from false to true when the -warmup period is
+	// over. Workers poll it between operations and discard everything
+	// recorded before they observe the switch.
+	measuring atomic.Bool
+
+	// scanning is the number of SCAN passes in progress and scanExpect
+	// the keys each must return; both are only used by the scan workload.
+	scanning   atomic.Int64
+	scanExpect map[string]bool
+
+	// churnOpen is the number of -churn connections open and churnPeak
+	// the most there were at once.
+	churnOpen atomic.Int64
+	churnPeak atomic.Int64
+
+	// outages follows the reachability of the server under -resilience.
+	outages *outageTracker
+	// breaker counts the failures in a row of -error-budget.
+	breaker *errorBreaker
+
+	// errLog warns of failed operations; logOps is set when one operation
+	// in -log-sample is logged at debug level.
+	errLog errorLog
+	logOps bool
+
+	// replayStart is the time -replay-timing original offsets count from.
+	replayStart time.Time
+}
+
+// runBenchmark starts cfg.clients workers, waits for all of them to finish
+// and merges their results. Workers stop early when ctx is cancelled.
+func runBenchmark(ctx context.Context, rdb redis.UniversalClient, cfg *config) *runResult {
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+
+	st := &runState{cfg: cfg, rdb: rdb, live: &liveCounters{soak: cfg.soakMonitor, seriesOut: cfg.seriesOut}}
+	st.errLog.logger = cfg.logger
+	st.logOps = cfg.logSample > 0 && cfg.logger.Enabled(ctx, slog.LevelDebug)
+	if cfg.progress || cfg.sentinelMaster != "" || cfg.percentileWindow > 0 || cfg.steadyState {
+		// Live, per-interval and windowed percentiles need the live
+		// histogram.
+		st.live.latency = &liveHistogram{}
+	}
+	if cfg.progress {
+		progressCtx, stopProgress := context.WithCancel(context.Background())
+		progressDone := make(chan struct{})
+		go func() {
+			defer close(progressDone)
+			reportProgress(progressCtx, cfg.stderr, isTerminal(cfg.stderr), st, time.Second)
+		}()
+		defer func() {
+			stopProgress()
+			<-progressDone
+		}()
+	}
+	if cfg.maxInflight > 0 {
+		st.gate = newInflightGate(cfg.maxInflight)
+	}
+	if cfg.usesKeyspace() && cfg.localityDist() {
+		st.reuse = newReuseTracker(cfg.keyspace)
+	}
+	if cfg.keepsHeatmap() {
+		st.heat = newHeatmapCollector(cfg.heatmapInterval, cfg.clients)
+	}
+	res := &runResult{start: time.Now(), env: captureEnvironment("")}
+	// The measured window starts once every client has been started and the
+	// warmup is over, or under -steady-state once the run has settled. The
+	// switch runs once, publishing the new start and sampler before closing
+	// switched, which is waited on before either is read again.
+	warmupStart := res.start
+	lead := cfg.rampUp + cfg.warmup
+	var warmupTimer *time.Timer
+	var series *sampler
+	if cfg.rampUp > 0 && !cfg.steadyState {
+		// Sample from the beginning so the ramp shows in the time series,
+		// at negative times relative to the measured window.
+		series = startSampler(st.live, sampleInterval, res.start.Add(lead), cfg.percentileWindow)
+		res.seriesFrom = -lead.Seconds()
+	}
+	// -duration counts from the actual start of the measured window, so a
+	// late timer cannot shorten it.
+	var endTimer atomic.Pointer[time.Timer]
+	defer func() {
+		if t := endTimer.Load(); t != nil {
+			t.Stop()
+		}
+	}()
+	var trigger *outageTrigger
+	var cpu *cpuMonitor
+	startMeasuring := func() {
+		cpu = startCPUMonitor()
+		if cfg.duration > 0 {
+			endTimer.Store(time.AfterFunc(cfg.duration, cancelRun))
+		}
+		if cfg.outageCmd != "" {
+			trigger = startOutageTrigger(cfg.outageCmd, cfg.outageAt)
+		}
+		if cfg.profiler != nil {
+			cfg.profiler.start()
+		}
+		if cfg.slowlog != nil {
+			cfg.slowlog.reset()
+		}
+		if cfg.hdr != nil {
+			cfg.hdr.start(res.start)
+		}
+		if st.heat != nil {
+			st.heat.start(res.start)
+		}
+		if st.gate != nil {
+			st.gate.startWindow(res.start)
+		}
+		st.measuring.Store(true)
+	}
+	switched := make(chan struct{})
+	var switchOnce sync.Once
+	switchWindow := func() {
+		res.start = time.Now()
+		if series == nil {
+			series = startSampler(st.live, sampleInterval, res.start, cfg.percentileWindow)
+		}
+		startMeasuring()
+		close(switched)
+	}
+	var steady *steadyWatch
+	var steadyReached bool
+	if cfg.steadyState {
+		steady = startSteadyWatch(runCtx, cfg, st.live, func() {
+			switchOnce.Do(func() {
+				steadyReached = true
+				switchWindow()
+			})
+		})
+		warmupTimer = time.AfterFunc(cfg.rampUp+cfg.steadyMax, func() {
+			switchOnce.Do(switchWindow)
+			steady.cancel()
+		})
+	} else if lead > 0 {
+		warmupTimer = time.AfterFunc(lead, func() { switchOnce.Do(switchWindow) })
+	} else {
+		series = startSampler(st.live, sampleInterval, res.start, cfg.percentileWindow)
+		startMeasuring()
+	}
+	switch {
+	case cfg.loop == loopOpen:
+		st.open = startOpenLoop(runCtx, st)
+	case cfg.rateScope == rateClient:
+		// Every client starts its own pacer.
+		st.clientPace = make([]*pacer, cfg.clients)
+	case cfg.rate > 0:
+		st.pace = newPacer(cfg.rate, realClock{})
+	}
+	// The switch may already have moved res.start.
+	st.replayStart = warmupStart
+	if cfg.recorder != nil {
+		cfg.recorder.start = warmupStart
+	}
+
+	// The first reason to abort the run is the one reported.
+	var aborted atomic.Value
+	var abortOnce sync.Once
+	abort := func(reason string) {
+		abortOnce.Do(func() {
+			aborted.Store(reason)
+			cancelRun()
+		})
+	}
+	if cfg.maxErrorRate > 0 {
+		go watchErrorRate(runCtx, st.live, cfg.maxErrorRate, abort)
+	}
+	if cfg.errorBudget > 0 {
+		st.breaker = newErrorBreaker(cfg.errorBudget, abort)
+		go watchErrorBudget(runCtx, st.live, cfg.errorBudget, cfg.errorWindow, abort)
+	}
+
+	if cfg.metrics != nil {
+		cfg.metrics.attach(st)
+	}
+	if cfg.observe != nil {
+		cfg.observe(st)
+	}
+	if cfg.workload == workloadScan {
+		st.scanExpect = cfg.scanExpected()
+	}
+	var depth *depthMonitor
+	if cfg.workload == workloadQueue {
+		depth = startDepthMonitor(rdb, cfg)
+	}
+
+	if cfg.resilience {
+		st.outages = &outageTracker{}
+	}
+
+	var watch *failoverWatch
+	if cfg.sentinelMaster != "" {
+		watch = startFailoverWatch(cfg)
+	}
+
+	var subs *subscriberGroup
+	if cfg.workload == workloadPubSub {
+		subs = startSubscribers(st)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(cfg.clients)
+
+	// Each client records into its own histogram; they are merged once all
+	// clients are done so the hot path never shares state.
+	results := make([]*workerResult, cfg.clients)
+	for i := 0; i < cfg.clients; i++ {
+		go func(clientID int) {
+			defer wg.Done()
+			if !(realClock{}).Sleep(runCtx, rampDelay(cfg, clientID)) {
+				results[clientID] = newWorkerResult(cfg.layout)
+				if cfg.raw != nil {
+					// The writer waits for the queue of every client.
+					cfg.raw.buffer(clientID).flush()
+				}
+				return
+			}
+			st.live.active.Add(1)
+			defer st.live.active.Add(-1)
+			results[clientID] = performLoadTest(runCtx, st, clientID)
+		}(i)
+	}
+
+	wg.Wait()
+	res.end = time.Now()
+	st.errLog.flush()
+	if warmupTimer != nil {
+		warmupTimer.Stop()
+		switchOnce.Do(func() {
+			// The run ended during warmup: nothing was measured.
+			res.start = res.end
+			close(switched)
+		})
+		<-switched
+		res.warmup = res.start.Sub(warmupStart)
+	}
+	if steady != nil {
+		res.steady = steady.report(cfg, steadyReached, res.warmup)
+	}
+	if cpu != nil {
+		res.load = cpu.finish(cfg)
+	}
+	if cfg.profiler != nil {
+		cfg.profiler.stop()
+	}
+	if series != nil {
+		res.series = series.stop()
+	}
+	if st.heat != nil {
+		res.heatmap = st.heat.stop(res.start, res.end)
+	}
+	if watch != nil {
+		res.failover = buildFailover(cfg, watch.initial, watch.stop(), res.series, res.seriesFrom, res.start)
+	}
+	if depth != nil {
+		res.queueDepth = depth.stop(res.start)
+	}
+	var triggered *triggerReport
+	if trigger != nil {
+		triggered = trigger.wait(res.start)
+	}
+	if st.pace != nil {
+		res.backlog = st.pace.backlog(res.end)
+	}
+	for _, p := range st.clientPace {
+		if p != nil {
+			res.backlog += p.backlog(res.end)
+		}
+	}
+	if st.clientPace != nil {
+		res.clientRates = collectClientRates(results, st.clientPace, res.elapsed())
+	}
+	if st.open != nil {
+		st.open.stop()
+		res.open = st.open
+	}
+
+	if cfg.fairness {
+		res.clients = collectClients(results)
+	}
+	if cfg.dbs != nil {
+		res.dbs = collectDBs(cfg, results)
+	}
+	res.total = newWorkerResult(cfg.layout)
+	for _, r := range results {
+		res.total.merge(r)
+	}
+	if res.total.churn != nil {
+		res.total.churn.peak = st.churnPeak.Load()
+	}
+	if st.gate != nil {
+		res.inflight = st.gate.report(res.end)
+		res.inflight.QueueTimeoutNs = int64(cfg.queueTimeout)
+		res.inflight.Shed = res.total.shed
+		res.inflight.QueueWait = summarizeLatency(res.total.queueWait)
+	}
+	annotateOutliers(res.series, res.seriesFrom, res.start, res.total.outliers)
+	if st.outages != nil {
+		res.resilience = buildResilience(st.outages, triggered, res.total, res.start, res.end)
+	}
+	if subs != nil {
+		pub := res.total.pub
+		if pub == nil {
+			pub = &pubTally{}
+		}
+		res.pubsub = subs.stop(cfg, pub, cfg.pubsubDrain)
+	}
+	if reason, ok := aborted.Load().(string); ok {
+		res.abortReason = reason
+	}
+	res.partial = ctx.Err() != nil || res.abortReason != ""
+	return res
+}
+
+// rampDelay returns when client i starts within -ramp-up. Clients start in
+// -ramp-steps equal groups spaced evenly over the ramp, one client per step
+// by default; the first group starts immediately.
+func rampDelay(cfg *config, i int) time.Duration {
+	if cfg.rampUp <= 0 {
+		return 0
+	}
+	steps := cfg.rampSteps
+	if steps <= 0 || steps > cfg.clients {
+		steps = cfg.clients
+	}
+	group := i * steps / cfg.clients
+	return time.Duration(group) * cfg.rampUp / time.Duration(steps)
+}
+
+// errorRateMinOps is the number of operations a run must have issued before
+// -max-error-rate is enforced, so a single early failure cannot abort it.
+const errorRateMinOps = 100
+
+// watchErrorRate polls the live counters and calls abort once the fraction of
+// failed operations exceeds max.
+func watchErrorRate(ctx context.Context, live *liveCounters, max float64, abort func(reason string)) {
+	t := time.NewTicker(100 * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		ops, errs := live.ops.Load(), live.errors.Load()
+		if ops < errorRateMinOps {
+			continue
+		}
+		if rate := float64(errs) / float64(ops); rate > max {
+			abort(fmt.Sprintf("error rate %.2f%% exceeded -max-error-rate %.2f%% after %d operations",
+				100*rate, 100*max, ops))
+			return
+		}
+	}
+}
+
+// worker is the per-client state of a load test. It is owned by a single
+// goroutine.
+type worker struct {
+	id  int
+	run *runState
+	// rdb is the shared client of the run, or the worker's own under
+	// -resilience.
+	rdb redis.UniversalClient
+	rng *rand.Rand
+	// pace is the pacer the worker claims its slots from, nil when
+	// unpaced.
+	pace *pacer
+	// thinkRng draws the pauses of -think-time.
+	thinkRng  *rand.Rand
+	keys      workload.KeyChooser
+	result    *workerResult
+	seq       int
+	measuring bool
+	warmupOps int64
+	expiry    *expiryReservoir
+	raw       *rawBuffer
+	hdr       *hdrRecorder
+	heat      *heatmapRecorder
+	// counters outlives the warmup switch: warmup INCRs change the
+	// counters too.
+	counters *counterTally
+	// lock and the conflicts found by its read-backs also outlive the
+	// warmup switch.
+	lock        lockAttempt
+	conflicts   int64
+	conflictLog []lockConflict
+	// queue outlives the warmup switch as well.
+	queue queueTally
+	// scan is the SCAN pass in progress, which may span the switch.
+	scan scanPass
+	// pub and the per-channel message numbers pubSeq outlive it too.
+	pub    pubTally
+	pubSeq []int64
+	// backoff is the -resilience delay after the last connection loss,
+	// zero once the server answers.
+	backoff time.Duration
+	// recent holds the latest SET keys under -evict-pressure, a ring
+	// whose oldest entry is at recentNext once full.
+	recent     []string
+	recentNext int
+	// conn is the connection of the SETs -verify reads back; sealing is
+	// set while such a SET is issued, and verifySeq numbers the sealed
+	// SETs.
+	conn      *redis.Conn
+	sealing   bool
+	verifySeq int64
+	// writes records the keyspace changes for -verify-final and outlives
+	// the warmup switch.
+	writes *writeLog
+	// valueSeq numbers the values the worker writes, across the warmup
+	// switch, and expiredSeq the keys of -verify-expired.
+	valueSeq   uint64
+	expiredSeq int
+	// trace buffers the commands -record writes. Under -replay, replay
+	// holds the commands of the worker's connections, of which replayed
+	// were sent, the last at replayLast on the recorded offset
+	// replayLastAt.
+	trace        *traceBuffer
+	replay       []traceRecord
+	replayed     int
+	replayLast   time.Time
+	replayLastAt time.Duration
+	// resp is the connection of -client raw, dialed on first use.
+	resp *respConn
+	// tracking is the client of the tracking workload, dialed on first
+	// use.
+	tracking *trackingClient
+	// appendFull is the key of the append workload the worker deletes
+	// next, its value having reached -append-max.
+	appendFull string
+	// shadowCredit accrues -shadow-reads per read: a read is compared on
+	// the shadow each time it reaches one.
+	shadowCredit float64
+	// split times the commands under -latency-split.
+	split *splitTimer
+	// opBase is the context the commands of the worker derive from,
+	// carrying split and the client number under -client-name.
+	opBase context.Context
+}
+
+// checkPhase switches the worker into the measured phase once the run has
+// left warmup, discarding the warmup measurements. Connections are owned by
+// the shared client and stay open across the switch.
+func (w *worker) checkPhase() {
+	if w.measuring || !w.run.measuring.Load() {
+		return
+	}
+	w.measuring = true
+	w.warmupOps = w.result.attempts()
+	w.result = newWorkerResult(w.run.cfg.layout)
+	if w.tracking != nil {
+		w.tracking.resetCounts()
+	}
+	w.seq = 0
+}
+
+// performLoadTest runs one client's share of the workload. With -duration
+// set it loops until runCtx is done; otherwise it issues -ops operations.
+// Commands themselves are not bound to runCtx, so an operation in flight at
+// the deadline completes and is counted. When the run is paced, the worker
+// claims its share of the aggregate -rate from the shared pacer or, under
+// -rate-scope client, paces itself.
+func performLoadTest(runCtx context.Context, st *runState, clientID int) *workerResult {
+	cfg := st.cfg
+	w := &worker{
+		id:     clientID,
+		run:    st,
+		rng:    rand.New(rand.NewSource(cfg.seed + int64(clientID))),
+		rdb:    st.rdb,
+		result: newWorkerResult(cfg.layout),
+		lock:   lockAttempt{want: -1},
+		pace:   st.pace,
+		opBase: cfg.withClient(context.Background(), clientID),
+	}
+	if st.clientPace != nil {
+		w.pace = newClientPacer(cfg, clientID, realClock{})
+		st.clientPace[clientID] = w.pace
+	}
+	if cfg.dbClients != nil {
+		w.rdb = cfg.dbClients[cfg.dbOf(clientID)]
+	}
+	shared := w.rdb
+	if cfg.resilience {
+		w.rdb = newResilienceClient(cfg)
+		defer func() { w.rdb.Close() }()
+	}
+	defer w.dropVerifyConn()
+	defer w.dropResp()
+	defer w.closeTracking()
+	if cfg.usesKeyspace() {
+		w.keys = touchedKeys{newKeyChooser(cfg, w.rng, clientID), w}
+	}
+	if cfg.think != nil {
+		w.thinkRng = rand.New(rand.NewSource((cfg.seed ^ thinkSalt) + int64(clientID)))
+	}
+	if cfg.verifyExpiry || cfg.notifyExpiries() {
+		// Split the sample evenly so the merged reservoir stays within
+		// -expiry-sample keys.
+		w.expiry = &expiryReservoir{size: (cfg.expirySample + cfg.clients - 1) / cfg.clients, watch: cfg.notifyWatch}
+	}
+	if cfg.usesCounters() {
+		w.counters = newCounterTally(cfg.counterKeys)
+	}
+	if cfg.verifyFinal {
+		w.writes = newWriteLog()
+	}
+	if cfg.workload == workloadPubSub {
+		w.pub.published = make([]int64, cfg.pubsubChannels)
+		w.pubSeq = make([]int64, cfg.pubsubChannels)
+	}
+	if cfg.raw != nil {
+		w.raw = cfg.raw.buffer(clientID)
+		defer w.raw.flush()
+	}
+	if cfg.hdr != nil {
+		w.hdr = cfg.hdr.recorder(clientID)
+	}
+	if st.heat != nil {
+		w.heat = st.heat.recorders[clientID]
+	}
+	if cfg.recorder != nil {
+		w.trace = cfg.recorder.buffer(clientID)
+		defer w.trace.flush()
+	}
+	if cfg.trace != nil {
+		w.replay = cfg.trace.forWorker(clientID, cfg.clients)
+	}
+	if cfg.latencySplit {
+		w.split = &splitTimer{}
+		w.opBase = context.WithValue(w.opBase, splitKey{}, w.split)
+	}
+
+	batch := 1
+	var pipe redis.Pipeliner
+	if cfg.pipeline > 1 {
+		batch = cfg.pipeline
+		pipe = shared.Pipeline()
+	}
+	pending := make([]pendingOp, 0, batch)
+	// thinking is set after every operation, or pipeline, once -think-time
+	// is due.
+	thinking := false
+
+	for {
+		w.checkPhase()
+		// Warmup is time-based; -ops only counts measured operations.
+		n := batch
+		switch {
+		case cfg.trace != nil:
+			// A replay ends with its trace.
+			n = min(n, len(w.replay)-w.replayed)
+		case st.open != nil:
+			// The open loop ends when its arrivals do.
+		case cfg.duration == 0 && w.measuring:
+			if left := cfg.opsPerClient - w.seq; left < n {
+				n = left
+			}
+		}
+		if runCtx.Err() != nil || n <= 0 {
+			break
+		}
+		if thinking {
+			// The run may leave warmup during the pause: loop to check.
+			thinking = false
+			if !w.pause(runCtx) {
+				break
+			}
+			continue
+		}
+		if cfg.trace != nil && !w.waitReplay(runCtx) {
+			break
+		}
+		intended, ok := w.claimSlots(runCtx, n)
+		if !ok {
+			break
+		}
+		if st.open != nil && w.measuring {
+			w.result.recordQueueDelay(time.Since(intended))
+		}
+		// An unpaced operation is enqueued once its client is ready.
+		enqueued := intended
+		if enqueued.IsZero() {
+			enqueued = time.Now()
+		}
+		release, ok := w.dispatch(runCtx, n, enqueued)
+		if !ok {
+			if runCtx.Err() != nil {
+				break
+			}
+			w.seq += n
+			continue
+		}
+
+		if w.split != nil {
+			w.split.reset()
+		}
+		if pipe == nil {
+			opCtx, cancel := w.opContext()
+			start := time.Now()
+			var p pendingOp
+			switch op := w.nextOp(); {
+			case cfg.churn:
+				p = w.issueChurn(opCtx, op)
+			case cfg.client == clientRaw:
+				p = w.issueRaw(opCtx, op)
+			case cfg.target != nil:
+				p = w.issueTarget(opCtx, op)
+			case cfg.workload == workloadTracking:
+				p = w.issueTracking(opCtx, op)
+			case cfg.verify && op == opSet && w.rng.Float64() < cfg.verifyFraction:
+				w.sealing = true
+				p = w.issue(opCtx, w.verifyConn(), op)
+				w.sealing = false
+			default:
+				p = w.issue(opCtx, w.rdb, op)
+			}
+			end := time.Now()
+			p.timedOut = p.err() != nil && opCtx.Err() != nil
+			cancel()
+			if cfg.retries > 0 {
+				end = w.retry(runCtx, &p, start, end)
+			}
+			if w.split != nil {
+				p.acquire, p.split = w.split.take()
+			}
+			release()
+			w.finish(p, intended, start, end)
+			switch p.op {
+			case opSetNX:
+				w.afterSetNX(runCtx, p, start, end)
+			case opScan:
+				w.afterScan(runCtx, p)
+			}
+			if st.outages != nil {
+				w.afterResilience(runCtx, p, start, end)
+			}
+			if cfg.evictPressure {
+				w.afterEvictPressure(p)
+			}
+			if p.value != nil {
+				w.afterVerify(p)
+			}
+		} else {
+			pending = pending[:0]
+			// -op-timeout bounds the whole batch, which is one round trip.
+			opCtx, cancel := w.opContext()
+			start := time.Now()
+			for j := 0; j < n; j++ {
+				pending = append(pending, w.issue(opCtx, pipe, w.nextOp()))
+			}
+			// Exec reports only the first failure; each command carries
+			// its own error, which finish inspects individually.
+			_, _ = pipe.Exec(opCtx)
+			end := time.Now()
+			release()
+			if opCtx.Err() != nil {
+				for j := range pending {
+					pending[j].timedOut = pending[j].cmd.Err() != nil
+				}
+			}
+			cancel()
+			w.result.recordBatch(end.Sub(start))
+			// Every command in the batch is charged an equal share of the
+			// round trip.
+			perCommand := end.Sub(start) / time.Duration(n)
+			var acquire time.Duration
+			var split bool
+			if w.split != nil {
+				acquire, split = w.split.take()
+			}
+			for _, p := range pending {
+				p.acquire, p.split = acquire/time.Duration(n), split
+				w.finish(p, intended, end.Add(-perCommand), end)
+			}
+		}
+		w.seq += n
+		thinking = cfg.think != nil
+	}
+	w.endScan()
+	if !w.measuring {
+		// Stopped before the warmup ended: everything done was warmup.
+		w.warmupOps = w.result.attempts()
+		w.result = newWorkerResult(cfg.layout)
+	}
+	w.result.warmupOps = w.warmupOps
+	if w.expiry != nil {
+		w.result.expirySamples = w.expiry.samples
+	}
+	w.result.counters = w.counters
+	w.result.writes = w.writes
+	w.result.lockConflicts, w.result.lockConflictLog = w.conflicts, w.conflictLog
+	if cfg.workload == workloadQueue {
+		w.result.queue = &w.queue
+	}
+	if cfg.workload == workloadPubSub {
+		w.result.pub = &w.pub
+	}
+	return w.result
+}
+
+func countFound(stats *opStats, found bool) {
+	if found {
+		stats.hits++
+	} else {
+		stats.misses++
+	}
+}
+
+// claimSlots waits for n consecutive slots of the pacer, or the next arrival
+// of the open loop, and returns the intended send time of the first.
+// Unpaced runs return immediately.
+func (w *worker) claimSlots(runCtx context.Context, n int) (time.Time, bool) {
+	if w.run.open != nil {
+		return w.run.open.next(runCtx)
+	}
+	if w.pace == nil {
+		return time.Time{}, true
+	}
+	var first time.Time
+	for j := 0; j < n; j++ {
+		t, ok := w.pace.wait(runCtx)
+		if !ok {
+			return t, false
+		}
+		if j == 0 {
+			first = t
+		}
+	}
+	return first, true
+}
+
+// opContext returns the context of the next command, bounded by
+// -op-timeout when set. The caller must cancel it as soon as the command
+// completes so its timer is released.
+func (w *worker) opContext() (context.Context, context.CancelFunc) {
+	if t := w.run.cfg.opTimeout; t > 0 {
+		return context.WithTimeout(w.opBase, t)
+	}
+	return w.opBase, func() {}
+}
+
+// nextOp picks the command of the next operation.
+func (w *worker) nextOp() opType {
+	cfg := w.run.cfg
+	switch {
+	case cfg.trace != nil:
+		return w.replay[w.replayed].op
+	case w.appendFull != "":
+		return opDel
+	case cfg.workload == workloadQueue:
+		return w.queueOp()
+	case cfg.workload == workloadPubSub:
+		return opPublish
+	case cfg.workload == workloadScan && w.id < cfg.scanClients:
+		// The first -scan-clients clients scan, the others run the
+		// foreground mix.
+		return opScan
+	}
+	if m := cfg.mix; m != nil {
+		return m.pick(w.rng)
+	}
+	return opSet
+}
+
+// pendingOp is an issued command whose outcome has not been accounted yet.
+type pendingOp struct {
+	op    opType
+	cmd   redis.Cmder
+	bytes int
+	key   string
+	ttl   time.Duration
+	// timedOut is set when the command failed because -op-timeout expired.
+	timedOut bool
+	// hot is set when the key came from the -hot-keys pool.
+	hot bool
+	// check is set when the reply is sampled for verification, and want
+	// is the answer a SISMEMBER must get.
+	check bool
+	want  bool
+	// counter is the index of the counter an INCR, the lock a SETNX or
+	// the channel a PUBLISH targets.
+	counter int
+	// value is the sealed value of a SET that -verify reads back.
+	value []byte
+	// written is the value of a SET, kept for -verify-final.
+	written []byte
+	// fill is where the value of a SET starts in the value pool, or the
+	// number of its legacy value, kept for -record.
+	fill int
+	// A command of -client raw has no cmd: rawErr is its error, found
+	// whether a GET or DEL found its key and replySize the size of the
+	// value a GET read. Under -large-values reply is that value, valid
+	// until the next command, and firstByte when it started to arrive.
+	// values are the replies of an MGET of a -protocol target.
+	rawErr    error
+	found     bool
+	replySize int
+	reply     []byte
+	firstByte time.Time
+	values    [][]byte
+	// local is set for a GET of the tracking workload the local cache
+	// served.
+	local bool
+	// offset is where a GETRANGE starts.
+	offset int
+	// expiring is set for a SET -verify-expired reads back.
+	expiring bool
+	// at is the recorded offset of a command of -replay.
+	at time.Duration
+	// acquire is the wait for a pool connection of the command, timed
+	// when split is set.
+	acquire time.Duration
+	split   bool
+}
+
+// err returns the error the command failed with, nil on success.
+func (p *pendingOp) err() error {
+	if p.cmd == nil {
+		return p.rawErr
+	}
+	return p.cmd.Err()
+}
+
+// issue sends one operation of type op through c. On a plain client the
+// command completes before issue returns; on a pipeline it is only queued.
+func (w *worker) issue(ctx context.Context, c redis.Cmdable, op opType) pendingOp {
+	cfg := w.run.cfg
+	if cfg.trace != nil {
+		return w.issueReplay(ctx, c)
+	}
+	key, hot := w.hotKey()
+	switch op {
+	case opGet:
+		if !hot {
+			key = cfg.keyName(w.keys.Next())
+		}
+		return pendingOp{op: op, cmd: c.Get(ctx, key), key: key, hot: hot}
+	case opDel:
+		switch {
+		case w.appendFull != "":
+			key, hot = w.appendFull, false
+			w.appendFull = ""
+		case !hot:
+			key = cfg.keyName(w.keys.Next())
+		}
+		return pendingOp{op: op, cmd: c.Del(ctx, key), key: key, hot: hot}
+	case opExpire:
+		ttl := cfg.expireTTLMin
+		if spread := cfg.expireTTLMax - cfg.expireTTLMin; spread > 0 {
+			ttl += time.Duration(w.rng.Int63n(int64(spread) + 1))
+		}
+		if !hot {
+			key = cfg.keyName(w.keys.Next())
+		}
+		return pendingOp{op: op, cmd: c.Expire(ctx, key, ttl), key: key, hot: hot}
+	case opSetNX:
+		return w.issueSetNX(ctx, c)
+	case opMGet, opMSet:
+		return w.issueBatch(ctx, c, op)
+	case opScan:
+		return w.issueScan(ctx, c)
+	case opTxn:
+		return w.issueTxn(ctx)
+	case opScript:
+		return w.issueScript(ctx, c)
+	case opPublish:
+		return w.issuePublish(ctx, c)
+	case opLPush, opRPop, opBRPop:
+		return w.issueQueue(ctx, c, op)
+	case opHSet, opHGet, opHGetAll:
+		if !hot {
+			key = cfg.keyName(w.keys.Next())
+		}
+		return w.issueHash(ctx, c, op, key, hot)
+	case opZAdd, opZRange, opZRevRange:
+		if !hot {
+			key = cfg.keyName(w.keys.Next())
+		}
+		return w.issueZSet(ctx, c, op, key, hot)
+	case opSAdd, opSIsMember, opSMembers:
+		if !hot {
+			key = cfg.keyName(w.keys.Next())
+		}
+		return w.issueSet(ctx, c, op, key, hot)
+	case opAppend, opGetRange:
+		return w.issueAppend(ctx, c, op, cfg.keyName(w.keys.Next()))
+	case opIncr:
+		// Counters have a pool of their own; -hot-keys does not apply.
+		i := w.rng.Intn(cfg.counterKeys)
+		key = cfg.counterName(i)
+		return pendingOp{op: op, cmd: c.Incr(ctx, key), key: key, counter: i}
+	default:
+		s := w.nextSet(key, hot)
+		p := pendingOp{op: op, cmd: c.Set(ctx, s.key, s.value, s.ttl), bytes: len(s.value), key: s.key, ttl: s.ttl, hot: hot, value: s.sealed}
+		w.keepSet(&p, s)
+		return p
+	}
+}
+
+// setArgs are the key, value and TTL of a SET. filler is the payload the
+// value was made of, and sealed the value when -verify reads it back.
+type setArgs struct {
+	key           string
+	value, filler []byte
+	sealed        []byte
+	ttl           time.Duration
+	// expiring is set for a SET -verify-expired reads back.
+	expiring bool
+}
+
+// nextSet draws the next SET, under key when it came from the -hot-keys
+// pool. A pure SET workload writes fresh keys; a mixed workload writes
+// into the shared keyspace so its GETs can hit.
+func (w *worker) nextSet(key string, hot bool) setArgs {
+	cfg := w.run.cfg
+	expiring := cfg.verifyExpired > 0 && !hot && !w.sealing && w.rng.Float64() < cfg.verifyExpired
+	switch {
+	case expiring:
+		// A fresh key, which no later write extends.
+		w.expiredSeq++
+		key = cfg.expiredKey(w.id, w.expiredSeq)
+	case !hot:
+		key = cfg.uniqueKey(w.id, w.rng.Int())
+		if cfg.usesKeyspace() {
+			key = cfg.keyName(w.keys.Next())
+		}
+	}
+	w.valueSeq++
+	s := setArgs{key: key, filler: cfg.nextValue(w.rng, w.seq)}
+	filler := s.filler
+	if cfg.verify {
+		// Every SET is sealed, those not read back too, so a read-back
+		// tells the value of another client from a corrupt one.
+		w.verifySeq++
+		filler = cfg.sealFiller(filler, w.id, w.verifySeq)
+	}
+	s.value = cfg.structure(filler, key, w.id, w.valueSeq)
+	if w.sealing {
+		s.sealed = s.value
+	}
+	s.ttl = cfg.ttlMin
+	if spread := cfg.ttlMax - cfg.ttlMin; spread > 0 {
+		s.ttl += time.Duration(w.rng.Int63n(int64(spread) + 1))
+	}
+	if expiring {
+		s.ttl, s.expiring = cfg.expiredTTL, true
+	}
+	return s
+}
+
+// keepSet keeps what -verify-final and -record need of the SET s in p.
+func (w *worker) keepSet(p *pendingOp, s setArgs) {
+	cfg := w.run.cfg
+	p.expiring = s.expiring
+	if cfg.verifyFinal {
+		p.written = s.value
+	}
+	if w.trace != nil {
+		p.fill = w.seq
+		if cfg.values != nil {
+			p.fill = cfg.values.Offset(s.filler)
+		}
+	}
+}
+
+// hotKey returns a key of the -hot-keys pool for the -hot-fraction of
+// operations that target it, and false for the others.
+func (w *worker) hotKey() (string, bool) {
+	cfg := w.run.cfg
+	if len(cfg.hotPool) == 0 || w.rng.Float64() >= cfg.hotFraction {
+		return "", false
+	}
+	i := cfg.hotPool[w.rng.Intn(len(cfg.hotPool))]
+	if cfg.usesKeyspace() {
+		w.result.keySketch().add(i + cfg.keyOffset)
+	}
+	return cfg.keyName(i), true
+}
+
+// finish accounts the outcome of a completed command. Outcomes that are not
+// failures, such as a GET miss, are counted as such and timed like any
+// other success; failed operations are excluded from latency percentiles.
+func (w *worker) finish(p pendingOp, intended, start, end time.Time) {
+	stats := &w.result.ops[p.op]
+	err := p.err()
+	found, valueSize := true, p.bytes
+	switch cmd := p.cmd.(type) {
+	case nil:
+		// A command of -client raw, a -protocol target or the tracking
+		// workload: GET and DEL report whether the key existed, MGET
+		// whether each of its keys did.
+		if err == nil && p.op == opMGet {
+			valueSize, found = w.countValues(stats, p.values)
+		} else if err == nil && p.op != opSet {
+			found = p.found
+			countFound(stats, found)
+			if found && p.op == opGet {
+				valueSize = p.replySize
+				if cfg := w.run.cfg; cfg.large != nil {
+					w.checkLarge(largeBytesKind(p.reply, cfg.largeValue(p.key)), len(p.reply))
+					w.recordFirstByte(p.firstByte, start, end)
+				}
+			}
+		}
+	case *redis.StringCmd:
+		switch {
+		case err == nil && p.op == opGetRange:
+			// A range past the end of the value, or of a missing key, is
+			// empty.
+			found, valueSize = len(cmd.Val()) > 0, len(cmd.Val())
+			countFound(stats, found)
+			if p.check {
+				w.checkGetRange(p, cmd.Val())
+			}
+		case err == nil:
+			stats.hits++
+			valueSize = len(cmd.Val())
+			if cfg := w.run.cfg; cfg.large != nil && p.op == opGet {
+				w.checkLarge(largeReplyKind(cmd.Val(), cfg.largeValue(p.key)), len(cmd.Val()))
+			}
+		case isMiss(err):
+			stats.misses++
+			err, found = nil, false
+		}
+	case *redis.IntCmd:
+		// DEL replies with the number of keys removed, PUBLISH with the
+		// number of subscribers reached, INCR with the new value of the
+		// counter.
+		if err == nil && (p.op == opDel || p.op == opPublish) {
+			found = cmd.Val() > 0
+			countFound(stats, found)
+		}
+		if err == nil && p.op == opAppend {
+			w.afterAppend(p, cmd.Val(), end.Sub(start))
+		}
+	case *redis.StringSliceCmd:
+		// BRPOP replies with the list and the message, or nil when it timed
+		// out on empty lists; SMEMBERS of a missing key is empty.
+		switch {
+		case err == nil && p.op == opSMembers:
+			members := cmd.Val()
+			found = len(members) > 0
+			countFound(stats, found)
+			if found {
+				w.checkSetSize(len(members))
+			}
+		case err == nil:
+			stats.hits++
+			valueSize = len(cmd.Val()[1])
+		case isMiss(err):
+			stats.misses++
+			err, found = nil, false
+		}
+	case *redis.ZSliceCmd:
+		// A range of a missing key is empty.
+		if err == nil {
+			entries := cmd.Val()
+			found = len(entries) > 0
+			countFound(stats, found)
+			valueSize = 0
+			for _, z := range entries {
+				valueSize += len(z.Member.(string))
+			}
+			if p.check {
+				w.checkZRange(p.op, entries)
+			}
+		}
+	case *redis.Cmd:
+		// A script returning nil, like a GET miss, found nothing.
+		switch {
+		case err == nil:
+			stats.hits++
+			if s, ok := cmd.Val().(string); ok {
+				valueSize = len(s)
+			}
+		case isMiss(err):
+			stats.misses++
+			err, found = nil, false
+		}
+	case *redis.SliceCmd:
+		// MGET replies with one element per key, nil for a missing one.
+		if err == nil {
+			valueSize, found = w.countMGet(stats, cmd.Val())
+		}
+	case *redis.StringStringMapCmd:
+		// HGETALL replies with an empty hash when the key does not exist.
+		if err == nil {
+			var fields int
+			fields, valueSize = hashReplySize(cmd.Val())
+			found = fields > 0
+			countFound(stats, found)
+			if found {
+				w.checkHashSize(fields)
+			}
+		}
+	case *redis.BoolCmd:
+		// EXPIRE replies false when the key does not exist, SETNX when the
+		// lock is already held, SISMEMBER when the member is absent.
+		if err == nil {
+			found = cmd.Val()
+			countFound(stats, found)
+			if p.op == opSIsMember {
+				w.checkMembership(p, found)
+			}
+		}
+	}
+	if w.raw != nil && w.measuring {
+		s := rawSample{start: start, op: p.op, latency: end.Sub(start), keySize: len(p.key), valueSize: valueSize}
+		switch {
+		case err != nil:
+			s.outcome = rawErr
+		case !found:
+			s.outcome = rawMiss
+		}
+		w.raw.add(s)
+	}
+
+	if queueOps[p.op] {
+		w.recordQueue(p, err, found, end)
+	}
+	if p.op == opPublish {
+		w.recordPublish(p, err)
+	}
+	switch {
+	case p.op != opIncr && p.op != opTxn:
+	case errors.Is(err, errTxnGaveUp):
+		// Every EXEC was aborted, so the counter is unchanged.
+		w.result.txnGaveUp++
+	case err != nil:
+		w.counters.failed[p.counter]++
+	default:
+		w.counters.ok[p.counter]++
+		if p.op == opTxn {
+			w.result.recordTxnRetries(int(p.cmd.(*redis.IntCmd).Val()))
+		}
+	}
+
+	if w.writes != nil {
+		w.writes.record(p, err)
+	}
+	if w.trace != nil {
+		w.trace.add(p, start)
+	}
+	if w.run.cfg.trace != nil && w.measuring {
+		w.recordReplayTiming(p.at, start)
+	}
+
+	if n := w.run.live.ops.Add(1); w.run.logOps && n%int64(w.run.cfg.logSample) == 0 {
+		logOp(w.run.cfg.logger, w.id, p, end.Sub(start), err)
+	}
+	if err != nil {
+		w.run.live.errors.Add(1)
+		if b := w.run.breaker; b != nil {
+			b.failure()
+		}
+		if m := w.run.cfg.shadow; m != nil && shadowWrites[p.op] {
+			// The primary may or may not have applied it.
+			m.taint(p.key)
+		}
+		stats.errors++
+		if p.timedOut {
+			stats.timeouts++
+		}
+		class := classifyError(err)
+		w.run.errLog.failed(w.id, p.op, p.key, class, err)
+		w.run.live.errClasses[class].Add(1)
+		w.result.errClasses[class]++
+		return
+	}
+	if b := w.run.breaker; b != nil {
+		b.success()
+	}
+	if m := w.run.cfg.shadow; m != nil {
+		w.mirror(m, p)
+	}
+	if l := w.run.live.latency; l != nil {
+		l.record(end.Sub(start))
+	}
+	if b := w.run.live.buckets; b != nil {
+		b.record(end.Sub(start))
+	}
+	w.result.bytesWritten += int64(p.bytes)
+	if p.bytes == 0 {
+		// A read moves the value the other way, the size of its reply.
+		w.result.bytesRead += int64(valueSize)
+	}
+	if w.expiry != nil && p.ttl > 0 && w.measuring {
+		// The TTL starts when the server applies the SET, at the latest
+		// when the reply arrives.
+		w.expiry.offer(w.rng, expirySample{key: p.key, deadline: end.Add(p.ttl)})
+	}
+	if c := w.run.cfg.expiredCheck; c != nil && p.expiring && w.measuring {
+		c.offer(w.rng, expiredKey{key: p.key, ttl: p.ttl, written: start, acked: end})
+	}
+	if s := w.run.cfg.ttlSampler; s != nil && p.ttl > 0 && w.measuring {
+		s.offer(w.rng, ttlKey{key: p.key, written: start, acked: end, ttl: p.ttl})
+	}
+	w.result.recordSuccess(w.run.cfg, stats, intended, start, end)
+	if p.split {
+		stats.recordSplit(w.result.layout, p.acquire, end.Sub(start))
+	}
+	if w.hdr != nil && w.measuring {
+		w.hdr.record(p.op, end.Sub(start))
+	}
+	if w.heat != nil && w.measuring {
+		w.heat.record(end.Sub(start))
+	}
+	if w.run.cfg.sizeBounds != nil {
+		w.result.recordSize(w.run.cfg, p.op, valueSize, end.Sub(start))
+	}
+	if len(w.run.cfg.hotPool) > 0 {
+		w.result.recordHotCold(p.hot, end.Sub(start))
+	}
+	if pat := w.run.cfg.pattern; pat != nil && pat.kind == patternBurst {
+		w.result.recordBurst(pat.inBurst(start.Sub(w.run.open.start)), end.Sub(start))
+	}
+	if w.run.cfg.workload == workloadScan && p.op != opScan {
+		w.result.recordForeground(w.run.scanning.Load() > 0, end.Sub(start))
+	}
+	if w.run.cfg.workload == workloadTracking && p.op == opGet {
+		w.result.recordTracking(p.local, end.Sub(start))
+	}
+	if d := end.Sub(start); d > w.result.slowest.latency {
+		w.result.slowest = slowOp{latency: d, at: start, op: p.op, key: p.key}
+	}
+	if d := end.Sub(start); w.run.cfg.captureOutliers > 0 && d > w.run.cfg.captureOutliers {
+		w.result.recordOutlier(w.run.cfg, outlier{slowOp: slowOp{latency: d, at: start, op: p.op, key: p.key}, client: w.id})
+	}
+}
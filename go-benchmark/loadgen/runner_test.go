@@ -0,0 +1,299 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/workload"
+)
+
+// newTestServer starts an in-process server and returns a client for it.
+func newTestServer(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return mr, rdb
+}
+
+// newTestServerAddr starts an in-process server for code that dials it
+// itself and returns its address.
+func newTestServerAddr(t *testing.T) string {
+	t.Helper()
+	return miniredis.RunT(t).Addr()
+}
+
+// testConfig parses args like the CLI does and fails the test on error.
+func testConfig(t *testing.T, args ...string) *config {
+	t.Helper()
+	cfg, err := parseFlags(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestWarmupIsExcluded(t *testing.T) {
+	_, rdb := newTestServer(t)
+	cfg := testConfig(t, "-clients", "8", "-warmup", "100ms", "-duration", "200ms")
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	if res.total.warmupOps == 0 {
+		t.Error("no warmup operations recorded")
+	}
+	if res.total.latency.Count() == 0 {
+		t.Error("no measured operations recorded")
+	}
+	if res.warmup < 100*time.Millisecond {
+		t.Errorf("warmup lasted %v, want at least 100ms", res.warmup)
+	}
+	if got := res.elapsed(); got < 200*time.Millisecond || got > time.Second {
+		t.Errorf("measured window %v, want ~200ms", got)
+	}
+}
+
+func TestWarmupWithOpsCount(t *testing.T) {
+	_, rdb := newTestServer(t)
+	cfg := testConfig(t, "-clients", "4", "-warmup", "50ms", "-ops", "25")
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	// -ops counts only measured operations, however many ran during warmup.
+	if got := res.total.attempts(); got != 100 {
+		t.Errorf("measured %d operations, want 100", got)
+	}
+	if res.total.warmupOps == 0 {
+		t.Error("no warmup operations recorded")
+	}
+}
+
+func TestPipelineCountsCommands(t *testing.T) {
+	_, rdb := newTestServer(t)
+	cfg := testConfig(t, "-clients", "2", "-ops", "10", "-pipeline", "4")
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	if got := res.total.latency.Count(); got != 20 {
+		t.Errorf("recorded %d commands, want 20", got)
+	}
+	// 10 operations in batches of 4 is 4+4+2 per client.
+	if got := res.total.batch.Count(); got != 6 {
+		t.Errorf("recorded %d batches, want 6", got)
+	}
+}
+
+func TestDelAndExpireOutcomes(t *testing.T) {
+	mr, rdb := newTestServer(t)
+	cfg := testConfig(t, "-clients", "1", "-ops", "200", "-ratio", "del=1,expire=1",
+		"-preload", "0", "-keyspace", "50", "-expire-ttl", "10s:20s")
+	for i := 0; i < 50; i += 2 {
+		mr.Set(cfg.keyName(i), "x")
+	}
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	del, exp := &res.total.ops[opDel], &res.total.ops[opExpire]
+	if del.errors != 0 || exp.errors != 0 {
+		t.Fatalf("unexpected errors: del %d, expire %d", del.errors, exp.errors)
+	}
+	if del.hits == 0 || del.misses == 0 {
+		t.Errorf("DEL found %d, not found %d; want both non-zero", del.hits, del.misses)
+	}
+	if del.hits > 25 {
+		t.Errorf("DEL removed %d keys, only 25 existed", del.hits)
+	}
+	if exp.hits+exp.misses != exp.attempts() {
+		t.Errorf("EXPIRE outcomes %d+%d do not add up to %d", exp.hits, exp.misses, exp.attempts())
+	}
+	for _, k := range mr.Keys() {
+		if ttl := mr.TTL(k); ttl != 0 && (ttl < 10*time.Second || ttl > 20*time.Second) {
+			t.Errorf("key %s has TTL %v outside 10s:20s", k, ttl)
+		}
+	}
+}
+
+// commandRecorder is a client hook that records the arguments of every
+// command sent, pipelined or not.
+type commandRecorder struct {
+	mu   sync.Mutex
+	cmds []string
+}
+
+func (r *commandRecorder) record(cmds ...redis.Cmder) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range cmds {
+		r.cmds = append(r.cmds, fmt.Sprint(c.Args()))
+	}
+}
+
+func (r *commandRecorder) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	r.record(cmd)
+	return ctx, nil
+}
+
+func (r *commandRecorder) AfterProcess(context.Context, redis.Cmder) error { return nil }
+
+func (r *commandRecorder) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	r.record(cmds...)
+	return ctx, nil
+}
+
+func (r *commandRecorder) AfterProcessPipeline(context.Context, []redis.Cmder) error { return nil }
+
+// recordRun runs cfg against a fresh server and returns the commands it
+// sent. Clients interleave nondeterministically, so the stream is sorted.
+func recordRun(t *testing.T, cfg *config) []string {
+	t.Helper()
+	_, rdb := newTestServer(t)
+	rec := &commandRecorder{}
+	rdb.AddHook(rec)
+	runBenchmark(context.Background(), rdb, cfg)
+	sort.Strings(rec.cmds)
+	return rec.cmds
+}
+
+func TestSeedReproducesCommandStream(t *testing.T) {
+	args := []string{"-clients", "3", "-ops", "100", "-ratio", "get=2,set=1,del=1,expire=1",
+		"-preload", "0", "-keyspace", "500", "-key-dist", "zipfian", "-value-size-range", "1:32",
+		"-pipeline", "4", "-key-prefix", "replay:"}
+	withSeed := func(seed string) []string {
+		return recordRun(t, testConfig(t, append(args, "-seed", seed)...))
+	}
+
+	first, second := withSeed("42"), withSeed("42")
+	if len(first) != 300 {
+		t.Fatalf("recorded %d commands, want 300", len(first))
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Error("runs with the same seed sent different commands")
+	}
+	if reflect.DeepEqual(first, withSeed("43")) {
+		t.Error("runs with different seeds sent identical commands")
+	}
+}
+
+// newStalledServer returns the address of a server that accepts connections
+// but never replies.
+func newStalledServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestOpTimeout(t *testing.T) {
+	for _, pipeline := range []string{"1", "3"} {
+		t.Run("pipeline="+pipeline, func(t *testing.T) {
+			rdb := redis.NewClient(&redis.Options{Addr: newStalledServer(t)})
+			defer rdb.Close()
+			cfg := testConfig(t, "-clients", "2", "-ops", "3", "-pipeline", pipeline, "-op-timeout", "20ms")
+
+			start := time.Now()
+			res := runBenchmark(context.Background(), rdb, cfg)
+
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("run took %v; -op-timeout did not bound the stalled commands", elapsed)
+			}
+			if got := res.total.timeouts(); got != 6 || res.total.errors() != 6 {
+				t.Errorf("%d timeouts and %d errors, want 6 of each", got, res.total.errors())
+			}
+			if n := res.total.latency.Count(); n != 0 {
+				t.Errorf("%d timed-out operations recorded in latency", n)
+			}
+		})
+	}
+}
+
+func TestRampDelay(t *testing.T) {
+	for _, tc := range []struct {
+		steps string
+		want  []time.Duration
+	}{
+		{"0", []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}},
+		{"2", []time.Duration{0, 0, 200 * time.Millisecond, 200 * time.Millisecond}},
+	} {
+		cfg := testConfig(t, "-clients", "4", "-ramp-up", "400ms", "-ramp-steps", tc.steps)
+		for i, want := range tc.want {
+			if got := rampDelay(cfg, i); got != want {
+				t.Errorf("steps %s: client %d starts at %v, want %v", tc.steps, i, got, want)
+			}
+		}
+	}
+}
+
+func TestRampUpPrecedesMeasurement(t *testing.T) {
+	_, rdb := newTestServer(t)
+	cfg := testConfig(t, "-clients", "4", "-ramp-up", "200ms", "-duration", "200ms")
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	if res.warmup < 200*time.Millisecond {
+		t.Errorf("measurement started %v into the run, want after the 200ms ramp", res.warmup)
+	}
+	if got := res.elapsed(); got < 200*time.Millisecond || got > time.Second {
+		t.Errorf("measured window %v, want the full 200ms after the ramp", got)
+	}
+	if res.seriesFrom != -0.2 {
+		t.Errorf("series starts at %v, want -0.2 so the ramp is included", res.seriesFrom)
+	}
+	// The final point is taken after the clients have stopped.
+	for _, p := range res.series[:len(res.series)-1] {
+		if p.T > 0 && p.Clients != 4 {
+			t.Errorf("%d clients active at t=%v in the measured window, want 4", p.Clients, p.T)
+		}
+	}
+}
+
+func TestHotKeys(t *testing.T) {
+	cfg := testConfig(t, "-clients", "4", "-ops", "500", "-workload", "get", "-preload", "0",
+		"-keyspace", "10000", "-hot-keys", "3", "-hot-fraction", "0.9", "-seed", "7", "-key-prefix", "hot:")
+	if !reflect.DeepEqual(cfg.hotPool, workload.NewHotPool(3, 10000, 7)) {
+		t.Fatal("hot pool is not a function of the seed")
+	}
+	hot := make(map[string]bool)
+	for _, i := range cfg.hotPool {
+		hot[fmt.Sprint([]interface{}{"get", cfg.keyName(i)})] = true
+	}
+
+	_, rdb := newTestServer(t)
+	rec := &commandRecorder{}
+	rdb.AddHook(rec)
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	var hits int
+	for _, c := range rec.cmds {
+		if hot[c] {
+			hits++
+		}
+	}
+	// All four clients share the pool, so 90% of 2000 commands land on it.
+	if share := float64(hits) / float64(len(rec.cmds)); share < 0.85 || share > 0.95 {
+		t.Errorf("%.2f of commands used the hot pool, want about 0.9", share)
+	}
+	if h, c := res.total.hot.Count(), res.total.cold.Count(); h != int64(hits) || h+c != 2000 {
+		t.Errorf("hot/cold latency recorded %d/%d operations, want %d/%d", h, c, hits, 2000-hits)
+	}
+}
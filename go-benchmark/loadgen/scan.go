@@ -0,0 +1,309 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+// workloadScan runs full SCAN passes over the keyspace on -scan-clients
+// clients while the others run the -ratio foreground mix.
+const workloadScan = "scan"
+
+// defaultScanForeground is the foreground mix of -workload scan without
+// -ratio.
+const defaultScanForeground = "get=0.9,set=0.1"
+
+// scanPass is the SCAN pass a scanner is in the middle of. It outlives the
+// warmup switch so a pass started during warmup is still checked whole.
+type scanPass struct {
+	active bool
+	cursor uint64
+	// seen counts how often each key was returned during the pass.
+	seen map[string]int
+}
+
+// scanStats describes the SCAN passes of a run.
+type scanStats struct {
+	// keys counts the keys returned by the measured SCAN calls.
+	keys   int64
+	passes int64
+	// gaps counts the passes that missed keys or returned some twice, of
+	// which unexplained had no concurrent write to account for it.
+	gaps        int64
+	unexplained int64
+	missing     int64
+	duplicates  int64
+	// extra counts keys outside the preloaded keyspace, such as those the
+	// foreground wrote during a pass.
+	extra int64
+}
+
+func (s *scanStats) merge(o *scanStats) {
+	s.keys += o.keys
+	s.passes += o.passes
+	s.gaps += o.gaps
+	s.unexplained += o.unexplained
+	s.missing += o.missing
+	s.duplicates += o.duplicates
+	s.extra += o.extra
+}
+
+// scanStats returns the SCAN statistics of r, allocating them on first use.
+func (r *workerResult) scanStats() *scanStats {
+	if r.scan == nil {
+		r.scan = &scanStats{}
+	}
+	return r.scan
+}
+
+// recordForeground records the latency of a foreground operation by whether
+// a SCAN pass was in progress when it completed.
+func (r *workerResult) recordForeground(scanning bool, d time.Duration) {
+	h := &r.fgIdle
+	if scanning {
+		h = &r.fgScanning
+	}
+	if *h == nil {
+		*h = r.layout.New()
+	}
+	(*h).Record(d)
+}
+
+// scanPattern returns the MATCH pattern of every SCAN.
+func (c *config) scanPattern() string {
+	if c.scanMatch != "" {
+		return c.scanMatch
+	}
+	if c.keyTemplate != nil {
+		return c.keyPattern()
+	}
+	return globEscape(c.keyPrefix) + "key*"
+}
+
+// scanExpected returns the keys a full pass must return: the preloaded keys
+// of the keyspace that match -scan-match.
+func (c *config) scanExpected() map[string]bool {
+	keys := make(map[string]bool)
+	for i := 0; i < min(c.preload, c.keyspace); i++ {
+		key := c.keyName(i)
+		if c.scanMatch != "" {
+			// path.Match implements the glob syntax of MATCH closely
+			// enough for key patterns.
+			if ok, _ := path.Match(c.scanMatch, key); !ok {
+				continue
+			}
+		}
+		keys[key] = true
+	}
+	return keys
+}
+
+// scanRemoves reports whether the foreground can remove keys during a pass,
+// which explains keys missing from it.
+func (c *config) scanRemoves() bool {
+	if c.scanClients >= c.clients {
+		return false
+	}
+	writesTTL := c.ttlMax > 0 && (c.mix.share(opSet) > 0 || c.mix.share(opMSet) > 0)
+	return c.mix.share(opDel) > 0 || c.mix.share(opExpire) > 0 || writesTTL
+}
+
+// scanAdds reports whether the foreground can add keys during a pass. SCAN
+// may return a key twice while the server resizes its table, which only
+// happens as keys come and go.
+func (c *config) scanAdds() bool {
+	if c.scanClients >= c.clients {
+		return false
+	}
+	writes := c.mix.share(opSet) > 0 || c.mix.share(opMSet) > 0
+	return writes && (c.keyspace > c.preload || c.scanRemoves())
+}
+
+// validateScan checks the flags of the scan workload.
+func (c *config) validateScan() error {
+	if c.scanClients < 1 || c.scanClients > c.clients {
+		return fmt.Errorf("-scan-clients must be between 1 and %d, got %d", c.clients, c.scanClients)
+	}
+	if c.scanCount <= 0 {
+		return fmt.Errorf("-scan-count must be positive, got %d", c.scanCount)
+	}
+	if _, err := path.Match(c.scanMatch, ""); err != nil {
+		return fmt.Errorf("-scan-match: invalid pattern %q", c.scanMatch)
+	}
+	if c.scanPause < 0 {
+		return fmt.Errorf("-scan-pause must not be negative, got %v", c.scanPause)
+	}
+	if c.pipeline > 1 {
+		// Each SCAN continues from the cursor of the previous one.
+		return errors.New("-pipeline does not apply to SCAN")
+	}
+	return nil
+}
+
+// issueScan sends the next SCAN of the worker's pass, starting a new pass
+// when none is in progress.
+func (w *worker) issueScan(ctx context.Context, c redis.Cmdable) pendingOp {
+	cfg := w.run.cfg
+	if !w.scan.active {
+		w.scan = scanPass{active: true, seen: make(map[string]int)}
+		w.run.scanning.Add(1)
+	}
+	match := cfg.scanPattern()
+	return pendingOp{op: opScan, cmd: c.Scan(ctx, w.scan.cursor, match, int64(cfg.scanCount)), key: match}
+}
+
+// afterScan follows an accounted SCAN: it records the keys returned and,
+// once the cursor is back at 0, checks the pass and pauses for -scan-pause
+// before the next. A failed SCAN is retried from the same cursor.
+func (w *worker) afterScan(runCtx context.Context, p pendingOp) {
+	cmd := p.cmd.(*redis.ScanCmd)
+	if cmd.Err() != nil {
+		return
+	}
+	keys, cursor := cmd.Val()
+	w.result.scanStats().keys += int64(len(keys))
+	for _, k := range keys {
+		w.scan.seen[k]++
+	}
+	w.scan.cursor = cursor
+	if cursor != 0 {
+		return
+	}
+	w.endScan()
+	w.checkScanPass()
+	w.scan.seen = nil
+	if d := w.run.cfg.scanPause; d > 0 {
+		(realClock{}).Sleep(runCtx, d)
+	}
+}
+
+// endScan marks the worker's pass as over.
+func (w *worker) endScan() {
+	if w.scan.active {
+		w.scan.active = false
+		w.run.scanning.Add(-1)
+	}
+}
+
+// checkScanPass compares the keys of a completed pass with scanExpect.
+func (w *worker) checkScanPass() {
+	cfg := w.run.cfg
+	expect := w.run.scanExpect
+	s := w.result.scanStats()
+	s.passes++
+	var found, dups, extra int64
+	for k, n := range w.scan.seen {
+		if expect[k] {
+			found++
+		} else {
+			extra++
+		}
+		dups += int64(n - 1)
+	}
+	missing := int64(len(expect)) - found
+	s.missing += missing
+	s.duplicates += dups
+	s.extra += extra
+	if missing > 0 || dups > 0 {
+		s.gaps++
+		if missing > 0 && !cfg.scanRemoves() || dups > 0 && !cfg.scanAdds() && !cfg.scanRemoves() {
+			s.unexplained++
+		}
+	}
+}
+
+// jsonScan is the scan workload section of the JSON report.
+type jsonScan struct {
+	Clients      int     `json:"clients"`
+	Count        int     `json:"count"`
+	Match        string  `json:"match"`
+	ExpectedKeys int     `json:"expected_keys"`
+	KeysReturned int64   `json:"keys_returned"`
+	KeysPerSec   float64 `json:"keys_per_sec"`
+	Passes       int64   `json:"passes"`
+	// PassesWithGaps missed keys or returned some twice; Unexplained of
+	// them had no concurrent write to account for it.
+	PassesWithGaps int64 `json:"passes_with_gaps"`
+	Unexplained    int64 `json:"unexplained"`
+	Missing        int64 `json:"missing"`
+	Duplicates     int64 `json:"duplicates"`
+	Extra          int64 `json:"extra"`
+	// Iteration is the latency of a single SCAN call.
+	Iteration *latencySummary `json:"iteration_latency"`
+	// ForegroundScanning and ForegroundIdle split the latency of the
+	// foreground commands by whether a pass was in progress.
+	ForegroundScanning *latencySummary `json:"foreground_scanning,omitempty"`
+	ForegroundIdle     *latencySummary `json:"foreground_idle,omitempty"`
+}
+
+// buildScan summarises the SCAN passes of res.
+func buildScan(cfg *config, res *runResult) *jsonScan {
+	total := res.total
+	s := total.scan
+	if s == nil {
+		s = &scanStats{}
+	}
+	rep := &jsonScan{
+		Clients:            cfg.scanClients,
+		Count:              cfg.scanCount,
+		Match:              cfg.scanPattern(),
+		ExpectedKeys:       len(cfg.scanExpected()),
+		KeysReturned:       s.keys,
+		Passes:             s.passes,
+		PassesWithGaps:     s.gaps,
+		Unexplained:        s.unexplained,
+		Missing:            s.missing,
+		Duplicates:         s.duplicates,
+		Extra:              s.extra,
+		Iteration:          summarizeLatency(total.ops[opScan].latency),
+		ForegroundScanning: summarizeLatency(total.fgScanning),
+		ForegroundIdle:     summarizeLatency(total.fgIdle),
+	}
+	if elapsed := res.elapsed(); elapsed > 0 {
+		rep.KeysPerSec = float64(s.keys) / elapsed.Seconds()
+	}
+	return rep
+}
+
+// printScan writes the scan section of the summary.
+func printScan(w io.Writer, u latencyUnit, cfg *config, res *runResult) {
+	s := buildScan(cfg, res)
+	fmt.Fprintf(w, "SCAN: %d clients, COUNT %d, MATCH %q: %d keys returned (%.0f keys/s), %d full passes\n",
+		s.Clients, s.Count, s.Match, s.KeysReturned, s.KeysPerSec, s.Passes)
+	switch {
+	case s.Passes == 0:
+		fmt.Fprintln(w, "SCAN passes: none completed, enumeration not checked")
+	case s.Unexplained > 0:
+		fmt.Fprintf(w, "CORRECTNESS FAILURE: %d of %d SCAN passes missed keys or returned duplicates that no concurrent write explains (%d missing, %d duplicates)\n",
+			s.Unexplained, s.Passes, s.Missing, s.Duplicates)
+	case s.PassesWithGaps > 0:
+		fmt.Fprintf(w, "SCAN passes: %d of %d had %d missing and %d duplicate keys, explained by concurrent writes\n",
+			s.PassesWithGaps, s.Passes, s.Missing, s.Duplicates)
+	default:
+		fmt.Fprintf(w, "SCAN passes: all %d returned the %d keys exactly once\n", s.Passes, s.ExpectedKeys)
+	}
+	if s.Extra > 0 {
+		fmt.Fprintf(w, "SCAN passes also returned %d keys outside the preloaded keyspace\n", s.Extra)
+	}
+	printLatency(w, u, "SCAN iteration", res.total.ops[opScan].latency)
+	if cfg.scanClients < cfg.clients {
+		fmt.Fprintf(w, "Foreground p99: %s while scanning, %s without a scan in progress\n",
+			foregroundP99(res.total.fgScanning), foregroundP99(res.total.fgIdle))
+	}
+}
+
+// foregroundP99 formats the p99 of h with its sample count.
+func foregroundP99(h *stats.Histogram) string {
+	if h == nil || h.Count() == 0 {
+		return "n/a (no operations)"
+	}
+	return fmt.Sprintf("%v (%d ops)", h.Percentile(99).Round(time.Microsecond), h.Count())
+}
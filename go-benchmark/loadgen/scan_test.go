@@ -0,0 +1,61 @@
+package loadgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestScanWorkload(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-workload", "scan", "-preload", "50", "-scan-count", "10",
+		"-clients", "3", "-scan-clients", "1", "-ops", "20", "-key-prefix", "sc:")
+	// Keys of another namespace are not part of the enumeration.
+	mr.Set("other:key1", "v")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := res.total.scan
+	if s == nil || s.passes == 0 {
+		t.Fatalf("scan stats %+v, want completed passes", s)
+	}
+	if s.gaps != 0 || s.extra != 0 || res.verifyFailed() {
+		t.Errorf("scan stats %+v, want every pass to return the 50 keys once", s)
+	}
+	if res.total.fgScanning == nil && res.total.fgIdle == nil {
+		t.Error("no foreground latency recorded")
+	}
+	if n := res.total.ops[opGet].attempts() + res.total.ops[opSet].attempts(); n != 2*20 {
+		t.Errorf("foreground ran %d operations, want %d", n, 2*20)
+	}
+}
+
+func TestScanWorkloadDetectsMissingKeys(t *testing.T) {
+	mr, rdb := newTestServer(t)
+	// Only the scanner runs, so nothing explains a missing key.
+	cfg := testConfig(t, "-workload", "scan", "-preload", "10", "-clients", "1", "-ops", "5", "-key-prefix", "sc:")
+	for i := 0; i < 9; i++ {
+		mr.Set(cfg.keyName(i), "v")
+	}
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	if s := res.total.scan; s == nil || s.unexplained == 0 || s.missing == 0 || !res.verifyFailed() {
+		t.Errorf("scan stats %+v, want the missing key reported as unexplained", s)
+	}
+}
+
+func TestScanMatch(t *testing.T) {
+	cfg := testConfig(t, "-workload", "scan", "-preload", "20", "-scan-match", "sc:key1*", "-key-prefix", "sc:")
+	// key1 and key10 to key19.
+	if n := len(cfg.scanExpected()); n != 11 {
+		t.Errorf("%d keys expected to match, want 11", n)
+	}
+	if _, err := parseFlags([]string{"-ratio", "scan=1"}); err == nil {
+		t.Error("SCAN in a -ratio mix was accepted")
+	}
+}
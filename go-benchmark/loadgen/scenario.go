@@ -0,0 +1,498 @@
+package loadgen
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// A -scenario file lists phases run one after the other. Each phase is a
+// map of flag names to values set over the command line, plus an optional
+// name and run, which set to false only preloads:
+//
+//	phases:
+//	  - name: fill
+//	    preload: 1000000
+//	    run: false
+//	  - name: steady
+//	    ratio: get=0.8,set=0.2
+//	    rate: 20000
+//	    duration: 10m
+//	    clients: 50
+//
+// Instead of phases, a file can list tenants, which run at the same time,
+// each with its own workers and key prefix; see tenants.go.
+//
+// The file is the subset of YAML this describes: comments, a phases or
+// tenants list of maps, top-level scalars and plain or quoted scalar
+// values.
+
+// scenarioFixed are the flags a phase cannot set, as they describe the
+// whole run.
+var scenarioFixed = map[string]bool{
+	"scenario": true, "addr2": true, "sweep-clients": true, "sweep-value-size": true, "sweep-batch-keys": true,
+	"metrics-addr": true, "raw-out": true, "hdr-out": true, "heatmap-out": true, "timeseries-out": true, "out": true, "output": true, "save-baseline": true,
+	"compare-baseline": true, "verify-final": true, "record": true, "tag": true,
+}
+
+// scenarioReplaces are the flags a phase setting the key drops from the
+// command line, as they cannot be combined with it.
+var scenarioReplaces = map[string][]string{
+	"duration":         {"ops"},
+	"ops":              {"duration"},
+	"value-size":       {"value-size-range"},
+	"value-size-range": {"value-size"},
+	"addr":             {"unix-socket", "sentinel-master"},
+	"unix-socket":      {"addr", "sentinel-master"},
+	"sentinel-master":  {"addr", "unix-socket"},
+}
+
+// scenarioField is a key of a phase and the line it is on.
+type scenarioField struct {
+	name, value string
+	line        int
+}
+
+// scenarioFile is a parsed -scenario file: its phases, or its tenants and
+// whether to calibrate them.
+type scenarioFile struct {
+	phases    []*scenarioPhase
+	tenants   []*scenarioPhase
+	calibrate bool
+}
+
+// scenarioPhase is one phase, or tenant, of a -scenario file and, once
+// run, its result: res for a phase that ran the workload, preload for one
+// that only preloaded. alone is the calibration run of a tenant, taken by
+// itself.
+type scenarioPhase struct {
+	name   string
+	line   int
+	fields []scenarioField
+	run    bool
+
+	cfg     *config
+	res     *runResult
+	preload *preloadResult
+
+	aloneCfg *config
+	alone    *runResult
+}
+
+// label names the phase in messages.
+func (p *scenarioPhase) label(i int) string {
+	if p.name == "" {
+		return fmt.Sprintf("phase %d", i+1)
+	}
+	return fmt.Sprintf("phase %d (%s)", i+1, p.name)
+}
+
+// parseScenario reads the phases or tenants of a scenario file.
+func parseScenario(r io.Reader) (*scenarioFile, error) {
+	var (
+		file    scenarioFile
+		list    *[]*scenarioPhase
+		current *scenarioPhase
+		indent  int
+	)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: indent with spaces, not tabs", n)
+		}
+		depth := len(line) - len(trimmed)
+		switch {
+		case depth == 0 && !strings.HasPrefix(trimmed, "- "):
+			key, value, err := splitScenarioField(trimmed)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			switch {
+			case key == "calibrate":
+				if file.calibrate, err = strconv.ParseBool(value); err != nil {
+					return nil, fmt.Errorf("line %d: calibrate must be true or false, got %q", n, value)
+				}
+				continue
+			case key != "phases" && key != "tenants" || value != "":
+				return nil, fmt.Errorf("line %d: unknown top-level key %q, want phases, tenants or calibrate", n, key)
+			}
+			next := &file.phases
+			if key == "tenants" {
+				next = &file.tenants
+			}
+			if list == next || len(*next) > 0 {
+				return nil, fmt.Errorf("line %d: %s given twice", n, key)
+			}
+			list, current = next, nil
+		case list == nil:
+			return nil, fmt.Errorf("line %d: phase outside the phases list", n)
+		case strings.HasPrefix(trimmed, "- ") || trimmed == "-":
+			current = &scenarioPhase{line: n, run: true}
+			*list = append(*list, current)
+			trimmed = strings.TrimLeft(strings.TrimPrefix(trimmed, "-"), " ")
+			if trimmed == "" {
+				// The first field sets the indent.
+				indent = -1
+				continue
+			}
+			indent = len(line) - len(trimmed)
+			fallthrough
+		default:
+			if current == nil {
+				return nil, fmt.Errorf("line %d: field outside a phase", n)
+			}
+			if indent < 0 && depth > 0 {
+				indent = depth
+			}
+			if d := len(line) - len(trimmed); d != indent {
+				return nil, fmt.Errorf("line %d: field indented by %d, the phase by %d", n, d, indent)
+			}
+			key, value, err := splitScenarioField(trimmed)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			if err := current.set(key, value, n); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	switch {
+	case len(file.phases) > 0 && len(file.tenants) > 0:
+		return nil, errors.New("phases and tenants cannot be combined: tenants run together, phases one after the other")
+	case len(file.phases) == 0 && len(file.tenants) == 0:
+		return nil, errors.New("no phases or tenants")
+	case file.calibrate && len(file.tenants) == 0:
+		return nil, errors.New("calibrate applies to tenants")
+	}
+	return &file, nil
+}
+
+// splitScenarioField splits "key: value" and unquotes the value.
+func splitScenarioField(s string) (key, value string, err error) {
+	key, value, ok := strings.Cut(s, ":")
+	if !ok || strings.TrimSpace(key) == "" {
+		return "", "", fmt.Errorf("%q is not a key: value field", s)
+	}
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	switch {
+	case strings.HasPrefix(value, `"`):
+		if value, err = strconv.Unquote(value); err != nil {
+			return "", "", fmt.Errorf("%s: malformed quoted value", key)
+		}
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return "", "", fmt.Errorf("%s: malformed quoted value", key)
+		}
+		value = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+	default:
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+	}
+	return key, value, nil
+}
+
+// set records the field key of the phase.
+func (p *scenarioPhase) set(key, value string, line int) error {
+	for _, f := range p.fields {
+		if f.name == key {
+			return fmt.Errorf("%s given twice", key)
+		}
+	}
+	switch key {
+	case "name":
+		p.name = value
+		return nil
+	case "run":
+		run, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("run must be true or false, got %q", value)
+		}
+		p.run = run
+		return nil
+	}
+	if scenarioFixed[key] {
+		return fmt.Errorf("%s applies to the whole scenario and must be given on the command line", key)
+	}
+	p.fields = append(p.fields, scenarioField{name: key, value: value, line: line})
+	return nil
+}
+
+// validateScenario reads -scenario and builds the configuration of every
+// phase from the command line and the phase's fields. Phases share the key
+// prefix and seed of the command line, so a phase reads the keys an
+// earlier one preloaded; one that sets neither keyspace nor preload
+// inherits the largest keyspace preloaded before it instead of preloading
+// again. Only the last phase runs -cleanup.
+func (c *config) validateScenario() error {
+	if c.sweepClients != "" || c.sweepValueSizes != "" || c.sweepBatchKeys != "" || c.addr2 != "" ||
+		c.saveBaseline != "" || c.baselinePath != "" || c.rawOut != "" || c.hdrOut != "" || c.verifyFinal {
+		return errors.New("-scenario cannot be combined with sweeps, -addr2, -save-baseline, -compare-baseline, -raw-out, -hdr-out or -verify-final")
+	}
+	f, err := os.Open(c.scenario)
+	if err != nil {
+		return fmt.Errorf("-scenario: %w", err)
+	}
+	defer f.Close()
+	file, err := parseScenario(f)
+	if err != nil {
+		return fmt.Errorf("-scenario %s: %w", c.scenario, err)
+	}
+
+	base := maps.Clone(c.explicit)
+	delete(base, "scenario")
+	base["key-prefix"] = c.keyPrefix
+	base["seed"] = strconv.FormatInt(c.seed, 10)
+	if file.tenants != nil {
+		return c.validateTenants(file, base)
+	}
+	phases := file.phases
+	keyspace := 0
+	for i, p := range phases {
+		flags, set := phaseFlags(base, p)
+		if i < len(phases)-1 {
+			delete(flags, "cleanup")
+		}
+		if keyspace > 0 && !set["keyspace"] && !set["preload"] {
+			flags["keyspace"], flags["preload"] = strconv.Itoa(keyspace), "0"
+		}
+		if p.cfg, err = parseArgs(scenarioArgs(flags), io.Discard); err != nil {
+			return fmt.Errorf("-scenario %s: %s: %w", c.scenario, p.label(i), scenarioError(err, p))
+		}
+		if !p.run && p.cfg.preload == 0 {
+			return fmt.Errorf("-scenario %s: %s: line %d: run: false needs a preload", c.scenario, p.label(i), p.line)
+		}
+		if p.cfg.preload > 0 {
+			keyspace = max(keyspace, p.cfg.keyspace)
+		}
+	}
+	c.phases = phases
+	return nil
+}
+
+// phaseFlags returns the flags of p: those of base, with the fields of p
+// set over them.
+func phaseFlags(base map[string]string, p *scenarioPhase) (flags map[string]string, set map[string]bool) {
+	flags, set = maps.Clone(base), make(map[string]bool)
+	for _, f := range p.fields {
+		for _, name := range scenarioReplaces[f.name] {
+			delete(flags, name)
+		}
+		flags[f.name] = f.value
+		set[f.name] = true
+	}
+	return flags, set
+}
+
+// scenarioArgs returns the command line setting flags, in name order.
+func scenarioArgs(flags map[string]string) []string {
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	args := make([]string, len(names))
+	for j, name := range names {
+		args[j] = "-" + name + "=" + flags[name]
+	}
+	return args
+}
+
+// scenarioError rewords a flag error in terms of the fields of the phase,
+// and names the line of the field it is about.
+func scenarioError(err error, p *scenarioPhase) error {
+	msg := err.Error()
+	if name, ok := strings.CutPrefix(msg, "flag provided but not defined: -"); ok {
+		for _, f := range p.fields {
+			if f.name == name {
+				return fmt.Errorf("line %d: unknown field %q", f.line, name)
+			}
+		}
+	}
+	for _, f := range p.fields {
+		if strings.Contains(msg, "-"+f.name+" ") || strings.Contains(msg, "-"+f.name+":") || strings.HasSuffix(msg, "-"+f.name) {
+			return fmt.Errorf("line %d: %w", f.line, err)
+		}
+	}
+	return err
+}
+
+// runScenario runs the phases in order and returns those that ran. It
+// stops after a phase that did not complete.
+func runScenario(rootCtx context.Context, cfg *config) ([]*scenarioPhase, error) {
+	var done []*scenarioPhase
+	for i, p := range cfg.phases {
+		if rootCtx.Err() != nil {
+			break
+		}
+		p.cfg.metrics = cfg.metrics
+		p.cfg.logger, p.cfg.logOut = cfg.logger, cfg.logOut
+		cfg.logger.Info("scenario phase", "phase", p.label(i), "n", i+1, "of", len(cfg.phases))
+		if !p.run {
+			if err := p.cfg.checkTransport(); err != nil {
+				return done, err
+			}
+			rdb, _ := newClient(p.cfg)
+			p.preload = preloadKeys(rootCtx, rdb, p.cfg)
+			rdb.Close()
+			printPreload(cfg.logWriter(slog.LevelInfo), p.preload)
+			done = append(done, p)
+			if p.preload.oom != nil {
+				return done, fmt.Errorf("%s: preload aborted: %w", p.label(i), p.preload.oom)
+			}
+			continue
+		}
+		res, err := runTarget(rootCtx, p.cfg)
+		if err != nil {
+			return done, fmt.Errorf("%s: %w", p.label(i), err)
+		}
+		p.res = res
+		done = append(done, p)
+		if res.partial {
+			if i < len(cfg.phases)-1 {
+				cfg.logger.Warn("stopping scenario: phase did not complete", "phase", p.label(i))
+			}
+			break
+		}
+	}
+	return done, nil
+}
+
+// scenarioOverall sums the phases that ran the workload.
+type scenarioOverall struct {
+	Phases         int             `json:"phases"`
+	Operations     int64           `json:"operations"`
+	Errors         int64           `json:"errors"`
+	ElapsedSeconds float64         `json:"elapsed_seconds"`
+	Throughput     float64         `json:"throughput_ops_per_sec"`
+	Latency        *latencySummary `json:"latency"`
+}
+
+func buildScenarioOverall(cfg *config, phases []*scenarioPhase) *scenarioOverall {
+	o := &scenarioOverall{Phases: len(phases)}
+	latency := cfg.layout.New()
+	var elapsed time.Duration
+	for _, p := range phases {
+		if p.res == nil {
+			continue
+		}
+		latency.Merge(p.res.total.latency)
+		o.Errors += p.res.total.errors()
+		elapsed += p.res.elapsed()
+	}
+	o.Operations = latency.Count()
+	o.ElapsedSeconds = elapsed.Seconds()
+	if elapsed > 0 {
+		o.Throughput = float64(o.Operations) / elapsed.Seconds()
+	}
+	o.Latency = summarizeLatency(latency)
+	return o
+}
+
+// jsonScenario is the -output json document of a -scenario run.
+type jsonScenario struct {
+	Phases  []jsonPhase      `json:"phases"`
+	Overall *scenarioOverall `json:"overall"`
+	// Stopped is set when the scenario ended before its last phase.
+	Stopped bool `json:"stopped,omitempty"`
+}
+
+// jsonPhase is one phase of a -scenario run: Result for a phase that ran
+// the workload, Preload for one that only preloaded.
+type jsonPhase struct {
+	Name    string       `json:"name,omitempty"`
+	Preload *jsonPreload `json:"preload,omitempty"`
+	Result  *Report      `json:"result,omitempty"`
+}
+
+// writeScenario renders the phases to -out, or to stdout when no file was
+// given.
+func writeScenario(cfg *config, phases []*scenarioPhase) error {
+	var w io.Writer = cfg.stdout
+	if cfg.out != "" {
+		f, err := os.Create(cfg.out)
+		if err != nil {
+			return fmt.Errorf("create report file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if cfg.output == outputJSON {
+		doc := &jsonScenario{Overall: buildScenarioOverall(cfg, phases), Stopped: len(phases) < len(cfg.phases)}
+		for _, p := range phases {
+			jp := jsonPhase{Name: p.name}
+			if p.res != nil {
+				jp.Result = buildReport(p.cfg, p.res)
+			} else {
+				jp.Preload = buildPreload(p.preload)
+			}
+			doc.Phases = append(doc.Phases, jp)
+		}
+		return writeJSON(w, doc)
+	}
+	printScenario(w, cfg, phases)
+	return nil
+}
+
+// printScenario writes the report of every phase, then a table of them
+// and the overall figures.
+func printScenario(w io.Writer, cfg *config, phases []*scenarioPhase) {
+	for i, p := range phases {
+		fmt.Fprintf(w, "=== Scenario %s ===\n", p.label(i))
+		if p.res != nil {
+			printSummary(w, p.cfg, p.res)
+		} else {
+			printPreload(w, p.preload)
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "Scenario %s against %s: %d of %d phases\n", cfg.scenario, cfg.addr, len(phases), len(cfg.phases))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "phase\tworkload\tclients\telapsed\tops\tops/s\tp50\tp99\terrors\t")
+	for i, p := range phases {
+		name := p.name
+		if name == "" {
+			name = strconv.Itoa(i + 1)
+		}
+		if p.res == nil {
+			fmt.Fprintf(tw, "%s\tpreload\t%d\t%v\t%d\t%.0f\t-\t-\t%d\t\n", name, p.cfg.clients,
+				p.preload.elapsed.Round(time.Millisecond), p.preload.written, p.preload.throughput(), p.preload.failed)
+			continue
+		}
+		h := p.res.total.latency
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%v\t%d\t%.0f\t%v\t%v\t%d\t\n", name, p.cfg.workload, p.cfg.clients,
+			p.res.elapsed().Round(time.Millisecond), h.Count(), throughput(p.res),
+			h.Percentile(50).Round(time.Microsecond), h.Percentile(99).Round(time.Microsecond), p.res.total.errors())
+	}
+	tw.Flush()
+	o := buildScenarioOverall(cfg, phases)
+	fmt.Fprintf(w, "Overall: %d operations in %v (%.0f ops/s), %d errors",
+		o.Operations, time.Duration(o.ElapsedSeconds*float64(time.Second)).Round(time.Millisecond), o.Throughput, o.Errors)
+	if o.Latency != nil {
+		fmt.Fprintf(w, ", p99 %v", time.Duration(o.Latency.P99Ns).Round(time.Microsecond))
+	}
+	fmt.Fprintln(w)
+	if len(phases) < len(cfg.phases) {
+		fmt.Fprintf(w, "Scenario stopped after %d of %d phases\n", len(phases), len(cfg.phases))
+	}
+}
@@ -0,0 +1,148 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// writeScenario writes a scenario file and returns its path.
+func writeScenarioFile(t *testing.T, doc string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "scenario.yaml")
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseScenario(t *testing.T) {
+	file, err := parseScenario(strings.NewReader(`# two phases
+phases:
+  - name: fill
+    preload: 1000   # keys
+    run: false
+
+  -
+    name: "steady state"
+    ratio: 'get=0.8,set=0.2'
+    duration: 10m
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	phases := file.phases
+	if len(phases) != 2 || phases[0].name != "fill" || phases[0].run || phases[1].name != "steady state" || !phases[1].run {
+		t.Fatalf("phases %+v", phases)
+	}
+	if f := phases[0].fields; len(f) != 1 || f[0].name != "preload" || f[0].value != "1000" || f[0].line != 4 {
+		t.Errorf("fill fields %+v", f)
+	}
+	if f := phases[1].fields; len(f) != 2 || f[0].value != "get=0.8,set=0.2" || f[1].name != "duration" {
+		t.Errorf("steady fields %+v", f)
+	}
+
+	for doc, want := range map[string]string{
+		"workload: get\n":                              `line 1: unknown top-level key "workload"`,
+		"phases:\n\t- name: a\n":                       "line 2: indent with spaces",
+		"phases:\n  - name: a\n      rate: 1\n":        "line 3: field indented by 6, the phase by 4",
+		"phases:\n  - rate: 1\n    rate: 2\n":          "line 3: rate given twice",
+		"phases:\n  - out: r.txt\n":                    "line 2: out applies to the whole scenario",
+		"phases:\n  - run: maybe\n":                    "line 2: run must be true or false",
+		"phases:\n  - name: \"a\n":                     "line 2: name: malformed quoted value",
+		"phases:\n":                                    "no phases",
+		"  - name: a\n":                                "line 1: phase outside the phases list",
+		"phases:\n  - name: a\nphases:\n  - name: b\n": "line 3: phases given twice",
+	} {
+		if _, err := parseScenario(strings.NewReader(doc)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: error %v, want %q", doc, err, want)
+		}
+	}
+}
+
+func TestScenarioValidation(t *testing.T) {
+	for doc, want := range map[string]string{
+		"phases:\n  - name: fill\n    preload: 10\n  - name: steady\n    rate: -5\n": "phase 2 (steady): line 5: -rate",
+		"phases:\n  - ops: 10\n    rat: get=1\n":                                     `phase 1: line 3: unknown field "rat"`,
+		"phases:\n  - run: false\n    preload: 0\n":                                  "phase 1: line 2: run: false needs a preload",
+		"phases:\n  - clients: many\n":                                               "phase 1: line 2: invalid value \"many\" for flag -clients",
+	} {
+		path := writeScenarioFile(t, doc)
+		if _, err := parseFlags([]string{"-scenario", path}); err == nil || !strings.Contains(err.Error(), path+": "+want) {
+			t.Errorf("%q: error %v, want %q", doc, err, want)
+		}
+	}
+	path := writeScenarioFile(t, "phases:\n  - ops: 10\n")
+	if _, err := parseFlags([]string{"-scenario", path, "-addr2", "localhost:1"}); err == nil {
+		t.Error("-scenario combined with -addr2")
+	}
+}
+
+func TestScenarioRunsPhases(t *testing.T) {
+	mr := miniredis.RunT(t)
+	path := writeScenarioFile(t, `phases:
+  - name: fill
+    preload: 300
+    run: false
+  - name: read
+    workload: get
+    clients: 3
+  - name: churn
+    clients: 2
+    ratio: del=1,set=1
+    duration: 100ms
+`)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-ops", "50", "-key-prefix", "sc:", "-cleanup", "scan-del", "-scenario", path)
+	read, churn := cfg.phases[1].cfg, cfg.phases[2].cfg
+	if read.keyspace != 300 || read.clients != 3 || read.opsPerClient != 50 || read.cleanup != "" {
+		t.Errorf("read phase: keyspace %d, %d clients, %d ops, cleanup %q", read.keyspace, read.clients, read.opsPerClient, read.cleanup)
+	}
+	if churn.duration == 0 || churn.keyPrefix != "sc:" || churn.seed != cfg.seed || churn.cleanup != cleanupScanDel {
+		t.Errorf("churn phase: duration %v, prefix %q, seed %d, cleanup %q", churn.duration, churn.keyPrefix, churn.seed, churn.cleanup)
+	}
+
+	phases, err := runScenario(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(phases) != 3 || phases[0].preload == nil || phases[0].preload.written != 300 || phases[0].res != nil {
+		t.Fatalf("%d phases, fill %+v", len(phases), phases[0].preload)
+	}
+	if r := phases[1].res.total; r.ops[opGet].hits != 150 {
+		t.Errorf("read phase hit %d of 150 preloaded keys", r.ops[opGet].hits)
+	}
+	if phases[2].res.total.ops[opDel].attempts() == 0 || len(mr.Keys()) != 0 {
+		t.Errorf("churn phase sent %d DELs, %d keys left after cleanup", phases[2].res.total.ops[opDel].attempts(), len(mr.Keys()))
+	}
+
+	var buf bytes.Buffer
+	printScenario(&buf, cfg, phases)
+	for _, want := range []string{"=== Scenario phase 2 (read) ===", "Preloaded 300 of 300 keys", "3 of 3 phases", "Overall: "} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, buf.String())
+		}
+	}
+
+	cfg.output, cfg.out = outputJSON, filepath.Join(t.TempDir(), "report.json")
+	if err := writeScenario(cfg, phases); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(cfg.out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc jsonScenario
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Phases) != 3 || doc.Phases[0].Preload == nil || doc.Phases[1].Result == nil ||
+		doc.Overall.Operations != phases[1].res.total.latency.Count()+phases[2].res.total.latency.Count() {
+		t.Errorf("JSON document %+v", doc)
+	}
+}
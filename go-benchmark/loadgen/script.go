@@ -0,0 +1,151 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// workloadScript runs a Lua script with EVALSHA over the keyspace.
+const workloadScript = "script"
+
+// defaultScript is the built-in script of -workload script without
+// -script: a get-or-set of KEYS[1] to ARGV[1].
+const defaultScript = `local v = redis.call('GET', KEYS[1])
+if v then
+	return v
+end
+redis.call('SET', KEYS[1], ARGV[1])
+return ARGV[1]
+`
+
+// errScriptUnsupported is returned when the server rejects SCRIPT LOAD, so
+// the run reports that scripting is unsupported instead of failing every
+// operation.
+var errScriptUnsupported = errors.New("scripting is not supported")
+
+// loadScript parses -script, or takes the built-in script, into
+// cfg.script.
+func (c *config) loadScript() error {
+	if c.scriptKeys < 0 || c.scriptArgs < 0 {
+		return fmt.Errorf("-script-keys and -script-args must not be negative, got %d and %d", c.scriptKeys, c.scriptArgs)
+	}
+	src := defaultScript
+	if c.scriptPath != "" {
+		data, err := os.ReadFile(c.scriptPath)
+		if err != nil {
+			return fmt.Errorf("-script: %w", err)
+		}
+		src = string(data)
+	} else if c.scriptKeys < 1 || c.scriptArgs < 1 {
+		return errors.New("the built-in script needs -script-keys and -script-args of at least 1")
+	}
+	if c.pipeline > 1 {
+		// A NOSCRIPT reply is only seen after the batch, too late to fall
+		// back to EVAL.
+		return errors.New("-pipeline does not apply to scripts")
+	}
+	c.script = redis.NewScript(src)
+	return nil
+}
+
+// scriptName describes the script in reports.
+func (c *config) scriptName() string {
+	if c.scriptPath != "" {
+		return c.scriptPath
+	}
+	return "built-in get-or-set"
+}
+
+// prepareScript loads the script into the server's script cache with
+// SCRIPT LOAD. An error reply means the server does not run scripts.
+func prepareScript(ctx context.Context, rdb redis.UniversalClient, cfg *config) error {
+	err := cfg.script.Load(ctx, rdb).Err()
+	var replyErr redis.Error
+	if errors.As(err, &replyErr) {
+		return fmt.Errorf("%w: SCRIPT LOAD replied %v", errScriptUnsupported, err)
+	}
+	if err != nil {
+		return fmt.Errorf("load script: %w", err)
+	}
+	return nil
+}
+
+// issueScript calls the script with EVALSHA on -script-keys keys of the
+// keyspace and -script-args generated values. When the server no longer
+// has the script cached it replies NOSCRIPT and the call is repeated with
+// EVAL, which sends the source and caches it again.
+func (w *worker) issueScript(ctx context.Context, c redis.Cmdable) pendingOp {
+	cfg := w.run.cfg
+	keys := make([]string, cfg.scriptKeys)
+	anyHot := false
+	for i := range keys {
+		key, hot := w.hotKey()
+		if !hot {
+			key = cfg.keyName(w.keys.Next())
+		}
+		keys[i], anyHot = key, anyHot || hot
+	}
+	args := make([]interface{}, cfg.scriptArgs)
+	for i := range args {
+		args[i] = cfg.nextValue(w.rng, w.seq)
+	}
+	cmd := cfg.script.EvalSha(ctx, c, keys, args...)
+	if err := cmd.Err(); err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		w.result.scriptFallbacks++
+		cmd = cfg.script.Eval(ctx, c, keys, args...)
+	}
+	p := pendingOp{op: opScript, cmd: cmd, hot: anyHot}
+	if len(keys) > 0 {
+		p.key = keys[0]
+	}
+	return p
+}
+
+// jsonScript describes the script calls of a run.
+type jsonScript struct {
+	Script string `json:"script"`
+	SHA    string `json:"sha"`
+	Keys   int    `json:"keys"`
+	Args   int    `json:"args"`
+	Calls  int64  `json:"calls"`
+	// Fallbacks counts the calls repeated with EVAL after NOSCRIPT.
+	Fallbacks int64           `json:"eval_fallbacks"`
+	Latency   *latencySummary `json:"latency"`
+}
+
+// buildScript summarises the script calls of res, nil when there were none.
+func buildScript(cfg *config, total *workerResult) *jsonScript {
+	s := &total.ops[opScript]
+	if s.attempts() == 0 {
+		return nil
+	}
+	return &jsonScript{
+		Script:    cfg.scriptName(),
+		SHA:       cfg.script.Hash(),
+		Keys:      cfg.scriptKeys,
+		Args:      cfg.scriptArgs,
+		Calls:     s.attempts(),
+		Fallbacks: total.scriptFallbacks,
+		Latency:   summarizeLatency(s.latency),
+	}
+}
+
+// printScript writes the script section of the summary. Script latency is
+// listed apart from the plain commands of a mix.
+func printScript(w io.Writer, u latencyUnit, cfg *config, total *workerResult) {
+	s := buildScript(cfg, total)
+	if s == nil {
+		return
+	}
+	fmt.Fprintf(w, "Script: %s (sha %s), %d keys and %d args per call, %d calls, %d EVAL fallbacks after NOSCRIPT\n",
+		s.Script, s.SHA, s.Keys, s.Args, s.Calls, s.Fallbacks)
+	if cfg.mixedCommands() {
+		printLatency(w, u, "EVALSHA", total.ops[opScript].latency)
+	}
+}
@@ -0,0 +1,59 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestScriptWorkload(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-workload", "script", "-preload", "5", "-keyspace", "10",
+		"-clients", "2", "-ops", "20", "-key-prefix", "sc:")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &res.total.ops[opScript]
+	if s.errors != 0 || s.hits != 2*20 {
+		t.Errorf("EVALSHA: %+v, want %d successful calls", s, 2*20)
+	}
+	if res.total.scriptFallbacks != 0 {
+		t.Errorf("%d EVAL fallbacks after SCRIPT LOAD", res.total.scriptFallbacks)
+	}
+	// The get-or-set fills the keys the preload left out.
+	if n := len(mr.Keys()); n <= 5 {
+		t.Errorf("%d keys after the run, want the script to have set some", n)
+	}
+}
+
+func TestScriptFallsBackToEval(t *testing.T) {
+	_, rdb := newTestServer(t)
+	cfg := testConfig(t, "-workload", "script", "-preload", "1", "-clients", "1", "-ops", "5", "-key-prefix", "sc:")
+
+	// The script was never loaded, so the first EVALSHA gets NOSCRIPT.
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	if n := res.total.scriptFallbacks; n != 1 {
+		t.Errorf("%d EVAL fallbacks, want 1", n)
+	}
+	if n := res.total.errors(); n != 0 {
+		t.Errorf("%d errors, want the fallback to succeed", n)
+	}
+}
+
+func TestScriptUnsupported(t *testing.T) {
+	// A server that answers every command but PING with an error reply.
+	addr := newFailingServer(t, "-ERR unknown command 'SCRIPT'")
+	cfg := testConfig(t, "-addr", addr, "-workload", "script", "-preload", "1", "-clients", "1", "-ops", "1")
+
+	_, err := runTarget(context.Background(), cfg)
+
+	if !errors.Is(err, errScriptUnsupported) {
+		t.Errorf("got %v, want %v", err, errScriptUnsupported)
+	}
+}
@@ -0,0 +1,359 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// searchSustained is the share of the requested rate a probe must achieve
+// to pass: a server falling behind the pacer does not sustain the rate,
+// whatever its latency.
+const searchSustained = 0.95
+
+// searchUnsupported are the flags -search cannot be combined with: the
+// search sets the rate and length of every probe itself, and its report
+// is the trajectory, not a single run.
+var searchUnsupported = []string{
+	"rate", "rate-scope", "ops", "duration", "warmup", "steady-state", "pattern", "sla",
+	"addr2", "resp2", "scenario", "sweep-clients", "sweep-value-size", "sweep-batch-keys", "mode",
+	"store", "push", "report", "save-baseline", "compare-baseline", "cleanup",
+	"raw-out", "hdr-out", "heatmap-out", "heatmap-json", "timeseries-out", "record", "cpuprofile", "memprofile",
+}
+
+// validateSearch parses -search and checks its flags.
+func (c *config) validateSearch() error {
+	if c.searchSpec == "" {
+		for _, name := range []string{"search-start", "search-interval", "search-warmup", "search-cooldown", "search-budget", "search-precision", "search-confirm"} {
+			if _, ok := c.explicit[name]; ok {
+				return fmt.Errorf("-%s requires -search", name)
+			}
+		}
+		return nil
+	}
+	for _, name := range searchUnsupported {
+		if _, ok := c.explicit[name]; ok {
+			return fmt.Errorf("-%s cannot be combined with -search", name)
+		}
+	}
+	switch {
+	case c.loop == loopOpen:
+		return errors.New("-search paces a closed loop: no -loop open")
+	case c.searchStart <= 0:
+		return fmt.Errorf("-search-start must be positive, got %v", c.searchStart)
+	case c.searchInterval <= 0:
+		return fmt.Errorf("-search-interval must be positive, got %v", c.searchInterval)
+	case c.searchWarmup < 0 || c.searchCooldown < 0 || c.searchConfirm < 0:
+		return errors.New("-search-warmup, -search-cooldown and -search-confirm must not be negative")
+	case c.searchPrecision <= 0 || c.searchPrecision >= 1:
+		return fmt.Errorf("-search-precision must be in (0, 1), got %v", c.searchPrecision)
+	}
+	if probe, confirm := c.searchCosts(); c.searchBudget < probe+confirm {
+		return fmt.Errorf("-search-budget %v leaves no time for a probe and its confirmation, %v", c.searchBudget, probe+confirm)
+	}
+	conds, err := parseSLA(c.searchSpec)
+	if err != nil {
+		return fmt.Errorf("-search: %w", err)
+	}
+	c.searchSLO = conds
+	return nil
+}
+
+// rateSearch brackets the highest rate that passes: it doubles the rate
+// from the start while probes pass, halves it while they fail, then
+// bisects between the highest pass and the lowest failure until they are
+// within precision of each other.
+type rateSearch struct {
+	precision float64
+	// pass is the highest rate that passed and fail the lowest that
+	// failed, zero for none yet.
+	pass, fail float64
+}
+
+// minSearchRate is the rate below which a search gives up finding one
+// that passes.
+const minSearchRate = 1
+
+// record notes the outcome of a probe at rate and returns the rate of the
+// next one, or false once the search is over.
+func (s *rateSearch) record(rate float64, passed bool) (float64, bool) {
+	if passed {
+		s.pass = max(s.pass, rate)
+	} else if s.fail == 0 || rate < s.fail {
+		s.fail = rate
+	}
+	switch {
+	case s.fail == 0:
+		return 2 * s.pass, true
+	case s.pass == 0:
+		next := s.fail / 2
+		return next, next >= minSearchRate
+	case s.fail-s.pass <= s.precision*s.pass:
+		return 0, false
+	}
+	return (s.pass + s.fail) / 2, true
+}
+
+// searchProbe is one measured interval of a search, or its confirmation.
+type searchProbe struct {
+	rate   float64
+	cfg    *config
+	res    *runResult
+	checks []slaCheck
+	// achieved is the throughput of the probe and sustained whether it
+	// reached searchSustained of rate.
+	achieved  float64
+	sustained bool
+	passed    bool
+}
+
+// searchResult is the outcome of -search.
+type searchResult struct {
+	probes []*searchProbe
+	// best is the highest rate that passed, zero for none, and confirm the
+	// run at that rate, nil when -search-confirm is 0 or the budget ran
+	// out.
+	best    float64
+	confirm *searchProbe
+	// stopReason is set when the search ended before bracketing a rate.
+	stopReason string
+}
+
+// forRate returns the configuration of a probe of d at rate. Probes after
+// the first find the keyspace the first preloaded.
+func (c *config) forRate(rate float64, d time.Duration, first bool) *config {
+	t := *c
+	t.rate, t.duration, t.opsPerClient = rate, d, 0
+	t.warmup = c.searchWarmup
+	t.sla = c.searchSLO
+	t.searchSpec = ""
+	if !first {
+		t.preload = 0
+	}
+	return &t
+}
+
+// probeRate runs cfg at rate for d and checks it against the SLO.
+func probeRate(rootCtx context.Context, cfg *config, rate float64, d time.Duration, first bool) (*searchProbe, error) {
+	pcfg := cfg.forRate(rate, d, first)
+	res, err := runTarget(rootCtx, pcfg)
+	if err != nil {
+		return nil, err
+	}
+	p := &searchProbe{rate: rate, cfg: pcfg, res: res, checks: evaluateSLA(pcfg, res), achieved: throughput(res)}
+	p.sustained = p.achieved >= searchSustained*rate
+	p.passed = p.sustained && slaExitCode(p.checks) == 0 && !res.partial
+	return p, nil
+}
+
+// searchCosts returns the longest a probe and the confirmation take, the
+// cooldown before them included.
+func (c *config) searchCosts() (probe, confirm time.Duration) {
+	probe = c.searchCooldown + c.searchWarmup + c.searchInterval
+	if c.searchConfirm > 0 {
+		confirm = c.searchCooldown + c.searchWarmup + c.searchConfirm
+	}
+	return probe, confirm
+}
+
+// runSearch runs -search: probes of -search-interval, each after a
+// -search-warmup and the -search-cooldown of the previous one, until the
+// highest rate meeting the SLO is bracketed, then a -search-confirm run at
+// it. A probe only starts when the budget left holds it and the
+// confirmation.
+func runSearch(rootCtx context.Context, cfg *config) (*searchResult, error) {
+	begin := time.Now()
+	probeCost, confirmCost := cfg.searchCosts()
+	s := &rateSearch{precision: cfg.searchPrecision}
+	out := &searchResult{}
+	rate, more := cfg.searchStart, true
+	for more {
+		if len(out.probes) > 0 {
+			if time.Since(begin)+probeCost+confirmCost > cfg.searchBudget {
+				out.stopReason = fmt.Sprintf("-search-budget %v exhausted", cfg.searchBudget)
+				break
+			}
+			if !(realClock{}).Sleep(rootCtx, cfg.searchCooldown) {
+				return out, nil
+			}
+		}
+		cfg.logger.Info("search probe", "n", len(out.probes)+1, "rate", fmt.Sprintf("%.0f", rate))
+		p, err := probeRate(rootCtx, cfg, rate, cfg.searchInterval, len(out.probes) == 0)
+		if err != nil {
+			return out, err
+		}
+		out.probes = append(out.probes, p)
+		if p.res.partial {
+			out.stopReason = "probe did not complete"
+			if p.res.abortReason != "" {
+				out.stopReason = p.res.abortReason
+			}
+			return out, nil
+		}
+		rate, more = s.record(rate, p.passed)
+	}
+	if !more && s.pass == 0 {
+		out.stopReason = fmt.Sprintf("no rate down to %d ops/s met the SLO", minSearchRate)
+	}
+	out.best = s.pass
+	if out.best == 0 || cfg.searchConfirm == 0 || !(realClock{}).Sleep(rootCtx, cfg.searchCooldown) {
+		return out, nil
+	}
+	cfg.logger.Info("search confirm", "rate", fmt.Sprintf("%.0f", out.best), "duration", cfg.searchConfirm)
+	p, err := probeRate(rootCtx, cfg, out.best, cfg.searchConfirm, false)
+	if err != nil {
+		return out, err
+	}
+	out.confirm = p
+	return out, nil
+}
+
+// sustainable returns the throughput the search found sustainable: that of
+// the confirmation, else that of the best probe.
+func (r *searchResult) sustainable() float64 {
+	if r.confirm != nil {
+		return r.confirm.achieved
+	}
+	for _, p := range r.probes {
+		if p.passed && p.rate == r.best {
+			return p.achieved
+		}
+	}
+	return 0
+}
+
+// exitCode returns the exit status of the search: that of the first
+// failed condition when no rate passed or the confirmation failed.
+func (r *searchResult) exitCode() int {
+	failed := r.confirm
+	if r.best == 0 && len(r.probes) > 0 {
+		failed = r.probes[len(r.probes)-1]
+	}
+	if failed == nil || failed.passed {
+		return 0
+	}
+	if code := slaExitCode(failed.checks); code != 0 {
+		return code
+	}
+	return exitSLAThroughput
+}
+
+// jsonSearchProbe is one probe of the search trajectory.
+type jsonSearchProbe struct {
+	Rate       float64 `json:"rate"`
+	Throughput float64 `json:"throughput_ops_per_sec"`
+	Sustained  bool    `json:"sustained"`
+	Passed     bool    `json:"passed"`
+	// Checks are the SLO conditions as measured, in the unit of their
+	// threshold.
+	Checks []jsonSLACheck `json:"checks"`
+}
+
+// jsonSearch is the -output json document of -search.
+type jsonSearch struct {
+	SLO        string            `json:"slo"`
+	Trajectory []jsonSearchProbe `json:"trajectory"`
+	// MaxRate is the highest requested rate that met the SLO and
+	// SustainableThroughput the throughput achieved at it, by the
+	// confirmation run when there was one.
+	MaxRate               float64 `json:"max_rate"`
+	SustainableThroughput float64 `json:"sustainable_throughput_ops_per_sec"`
+	Confirmed             *bool   `json:"confirmed,omitempty"`
+	Confirm               *Report `json:"confirm,omitempty"`
+	StopReason            string  `json:"stop_reason,omitempty"`
+}
+
+func buildSearchProbe(p *searchProbe) jsonSearchProbe {
+	j := jsonSearchProbe{Rate: p.rate, Throughput: p.achieved, Sustained: p.sustained, Passed: p.passed}
+	j.Checks = buildSLA(p.cfg, p.res).Checks
+	return j
+}
+
+// writeSearch renders the search to -out, or to stdout when no file was
+// given.
+func writeSearch(cfg *config, r *searchResult) error {
+	var w io.Writer = cfg.stdout
+	if cfg.out != "" {
+		f, err := os.Create(cfg.out)
+		if err != nil {
+			return fmt.Errorf("create report file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if cfg.output == outputJSON {
+		doc := &jsonSearch{SLO: cfg.searchSpec, MaxRate: r.best, SustainableThroughput: r.sustainable(), StopReason: r.stopReason}
+		doc.Trajectory = []jsonSearchProbe{}
+		for _, p := range r.probes {
+			doc.Trajectory = append(doc.Trajectory, buildSearchProbe(p))
+		}
+		if c := r.confirm; c != nil {
+			doc.Confirmed = &c.passed
+			doc.Confirm = buildReport(c.cfg, c.res)
+		}
+		return writeJSON(w, doc)
+	}
+	printSearch(w, cfg, r)
+	return nil
+}
+
+// printSearch writes the trajectory of the search, one row per probe, and
+// its outcome.
+func printSearch(w io.Writer, cfg *config, r *searchResult) {
+	fmt.Fprintf(w, "Search for the highest rate meeting %s against %s, workload: %s\n", cfg.searchSpec, cfg.addr, cfg.workload)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(tw, "rate\tachieved\t")
+	for _, c := range cfg.searchSLO {
+		fmt.Fprintf(tw, "%s\t", c.metric)
+	}
+	fmt.Fprintln(tw, "result\t")
+	for _, p := range r.probes {
+		fmt.Fprintf(tw, "%.0f\t%.0f\t", p.rate, p.achieved)
+		for _, chk := range p.checks {
+			v := "N/A"
+			if chk.measured {
+				v = chk.cond.format(chk.actual)
+			}
+			fmt.Fprintf(tw, "%s\t", v)
+		}
+		fmt.Fprintf(tw, "%s\t\n", searchVerdict(p))
+	}
+	tw.Flush()
+	if r.stopReason != "" {
+		fmt.Fprintf(w, "Search stopped: %s\n", r.stopReason)
+	}
+	if r.best == 0 {
+		fmt.Fprintln(w, "No rate met the SLO")
+		return
+	}
+	fmt.Fprintf(w, "Highest rate meeting the SLO: %.0f ops/s (within %.0f%%)\n", r.best, 100*cfg.searchPrecision)
+	if c := r.confirm; c != nil {
+		fmt.Fprintf(w, "Confirmed over %v: %s\n", cfg.searchConfirm, searchVerdict(c))
+		for _, chk := range c.checks {
+			fmt.Fprintln(w, "  "+chk.describe())
+		}
+	}
+	fmt.Fprintf(w, "Sustainable throughput: %.0f ops/s\n", r.sustainable())
+}
+
+// searchVerdict describes the outcome of a probe.
+func searchVerdict(p *searchProbe) string {
+	switch {
+	case p.passed:
+		return "pass"
+	case !p.sustained:
+		return fmt.Sprintf("FAIL (%.0f%% of the rate)", 100*p.achieved/p.rate)
+	}
+	var failed []string
+	for _, chk := range p.checks {
+		if !chk.passed {
+			failed = append(failed, chk.cond.text)
+		}
+	}
+	return "FAIL (" + strings.Join(failed, ", ") + ")"
+}
@@ -0,0 +1,118 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRateSearch(t *testing.T) {
+	// A server sustaining 3000 ops/s: the search doubles from 1000 to 4000,
+	// then bisects down to within 5%.
+	s := &rateSearch{precision: 0.05}
+	rate, more := 1000.0, true
+	var tried []float64
+	for more {
+		tried = append(tried, rate)
+		rate, more = s.record(rate, rate <= 3000)
+	}
+	if tried[1] != 2000 || tried[2] != 4000 || tried[3] != 3000 {
+		t.Errorf("rates tried %v", tried)
+	}
+	if s.pass < 2850 || s.pass > 3000 || s.fail-s.pass > 0.05*s.pass {
+		t.Errorf("bracketed [%v, %v] after %v", s.pass, s.fail, tried)
+	}
+
+	// Below the start, the search halves until a probe passes.
+	s = &rateSearch{precision: 0.05}
+	if next, _ := s.record(1000, false); next != 500 {
+		t.Errorf("after 1000 failed, %v", next)
+	}
+	if next, _ := s.record(500, true); next != 750 {
+		t.Errorf("after 500 passed, %v", next)
+	}
+
+	// Nothing passes: the search gives up under minSearchRate.
+	s = &rateSearch{precision: 0.05}
+	rate, more = 4, true
+	n := 0
+	for more {
+		rate, more = s.record(rate, false)
+		n++
+	}
+	if s.pass != 0 || n != 3 {
+		t.Errorf("%d probes failing from 4 ops/s, pass %v", n, s.pass)
+	}
+}
+
+func TestSearchFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-search", "p99<"},
+		{"-search", "p99<2ms", "-rate", "100"},
+		{"-search", "p99<2ms", "-duration", "1s"},
+		{"-search", "p99<2ms", "-sla", "p99<2ms"},
+		{"-search", "p99<2ms", "-search-precision", "0"},
+		{"-search", "p99<2ms", "-search-budget", "10s"},
+		{"-search-start", "100"},
+	} {
+		if _, err := parseFlags(append(args, "-clients", "2")); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	cfg := testConfig(t, "-clients", "2", "-search", "p99<2ms,errors<1%")
+	if len(cfg.searchSLO) != 2 || cfg.sla != nil {
+		t.Errorf("SLO %v, -sla %v", cfg.searchSLO, cfg.sla)
+	}
+}
+
+func TestSearchRun(t *testing.T) {
+	addr := newTestServerAddr(t)
+	out := filepath.Join(t.TempDir(), "search.json")
+	cfg := testConfig(t, "-addr", addr, "-clients", "2", "-search", "p99<1s", "-search-start", "200",
+		"-search-interval", "200ms", "-search-warmup", "50ms", "-search-cooldown", "10ms",
+		"-search-confirm", "300ms", "-search-budget", "1500ms", "-output", "json", "-out", out)
+	r, err := runSearch(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.probes) < 2 || r.best < 200 || r.confirm == nil || !strings.Contains(r.stopReason, "budget") {
+		t.Fatalf("%d probes, best %v, confirm %v, stopped: %s", len(r.probes), r.best, r.confirm, r.stopReason)
+	}
+	if p := r.probes[0]; p.rate != 200 || !p.passed || p.cfg.warmup != cfg.searchWarmup {
+		t.Errorf("first probe at %v passed %v, achieved %v", p.rate, p.passed, p.achieved)
+	}
+	if p := r.probes[1]; p.rate != 400 || p.cfg.preload != 0 {
+		t.Errorf("second probe at %v preloaded %d keys", p.rate, p.cfg.preload)
+	}
+	if r.confirm.rate != r.best || r.confirm.cfg.duration != cfg.searchConfirm {
+		t.Errorf("confirmed %v over %v", r.confirm.rate, r.confirm.cfg.duration)
+	}
+
+	if err := writeSearch(cfg, r); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc jsonSearch
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Trajectory) != len(r.probes) || doc.MaxRate != r.best || doc.Confirm == nil || doc.Confirmed == nil ||
+		doc.SustainableThroughput == 0 || len(doc.Trajectory[0].Checks) != 1 {
+		t.Errorf("JSON search:\n%s", data)
+	}
+
+	var buf bytes.Buffer
+	printSearch(&buf, cfg, r)
+	for _, want := range []string{"rate", "p99", "pass", "Search stopped: -search-budget", "Highest rate meeting the SLO", "Confirmed over 300ms: ", "Sustainable throughput"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// sentinelScheme prefixes the -addr a -sentinel-master run reports, as in
+// sentinel://mymaster; the master itself is looked up from the sentinels.
+const sentinelScheme = "sentinel://"
+
+// p99ElevatedFactor is how far above the pre-failover baseline an
+// interval's p99 must be to count as elevated.
+const p99ElevatedFactor = 2
+
+// sentinels returns the -sentinel-addrs addresses.
+func (c *config) sentinels() []string {
+	var addrs []string
+	for _, a := range strings.Split(c.sentinelAddrs, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// validateSentinel checks the flags of a run through Sentinel.
+func (c *config) validateSentinel() error {
+	if len(c.sentinels()) == 0 {
+		return errors.New("-sentinel-master requires -sentinel-addrs")
+	}
+	switch {
+	case c.cluster:
+		return errors.New("-sentinel-master and -cluster are mutually exclusive")
+	case c.churn:
+		return errors.New("-churn does not apply to -sentinel-master")
+	case c.addr2 != "":
+		return errors.New("-addr2 does not apply to -sentinel-master")
+	}
+	return nil
+}
+
+// newFailoverClient returns a client of the master that the sentinels
+// name, which follows it through failovers.
+func newFailoverClient(cfg *config) *redis.Client {
+	opt := cfg.clientOptions()
+	return redis.NewFailoverClient(&redis.FailoverOptions{
+		MasterName:    cfg.sentinelMaster,
+		SentinelAddrs: cfg.sentinels(),
+		Password:      opt.Password,
+		DB:            opt.DB,
+		PoolSize:      opt.PoolSize,
+		MinIdleConns:  opt.MinIdleConns,
+		PoolTimeout:   opt.PoolTimeout,
+		TLSConfig:     opt.TLSConfig,
+		MaxRetries:    opt.MaxRetries,
+		OnConnect:     opt.OnConnect,
+	})
+}
+
+// masterSwitch is a +switch-master announcement of the monitored master.
+type masterSwitch struct {
+	at       time.Time
+	from, to string
+}
+
+// parseSwitchMaster parses a +switch-master payload, "<name> <old-ip>
+// <old-port> <new-ip> <new-port>".
+func parseSwitchMaster(payload string) (name string, from, to string, ok bool) {
+	f := strings.Fields(payload)
+	if len(f) != 5 {
+		return "", "", "", false
+	}
+	return f[0], net.JoinHostPort(f[1], f[2]), net.JoinHostPort(f[3], f[4]), true
+}
+
+// failoverWatch subscribes to +switch-master on every sentinel and records
+// the master changes they announce. Several sentinels announce the same
+// change; it is recorded once.
+type failoverWatch struct {
+	master string
+	subs   []*redis.PubSub
+	wg     sync.WaitGroup
+
+	mu       sync.Mutex
+	initial  string
+	current  string
+	switches []masterSwitch
+}
+
+// startFailoverWatch looks up the current master and starts watching for
+// changes. Sentinels that cannot be reached are skipped.
+func startFailoverWatch(cfg *config) *failoverWatch {
+	fw := &failoverWatch{master: cfg.sentinelMaster}
+	for _, addr := range cfg.sentinels() {
+		sc := redis.NewSentinelClient(&redis.Options{Addr: addr, TLSConfig: cfg.tlsConfig})
+		if fw.initial == "" {
+			if a, err := sc.GetMasterAddrByName(context.Background(), cfg.sentinelMaster).Result(); err == nil && len(a) == 2 {
+				fw.initial = net.JoinHostPort(a[0], a[1])
+				fw.current = fw.initial
+			}
+		}
+		ps := sc.Subscribe(context.Background(), "+switch-master")
+		if _, err := ps.Receive(context.Background()); err != nil {
+			ps.Close()
+			sc.Close()
+			continue
+		}
+		fw.subs = append(fw.subs, ps)
+		fw.wg.Add(1)
+		go func() {
+			defer fw.wg.Done()
+			defer sc.Close()
+			for {
+				msg, err := ps.ReceiveMessage(context.Background())
+				if err != nil {
+					return
+				}
+				fw.record(time.Now(), msg.Payload)
+			}
+		}()
+	}
+	return fw
+}
+
+// record notes a +switch-master announcement unless it is of another master
+// or already known.
+func (fw *failoverWatch) record(at time.Time, payload string) {
+	name, from, to, ok := parseSwitchMaster(payload)
+	if !ok || name != fw.master {
+		return
+	}
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if to == fw.current {
+		return
+	}
+	fw.current = to
+	fw.switches = append(fw.switches, masterSwitch{at: at, from: from, to: to})
+}
+
+// stop ends the subscriptions and returns the recorded master changes.
+func (fw *failoverWatch) stop() []masterSwitch {
+	for _, ps := range fw.subs {
+		ps.Close()
+	}
+	fw.wg.Wait()
+	return fw.switches
+}
+
+// failoverEvent is one entry of the failover timeline. T is in seconds
+// from the start of the measured window.
+type failoverEvent struct {
+	T    float64 `json:"t"`
+	Kind string  `json:"kind"`
+	// From and To are the old and new master of a switch.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// P99ElevatedSeconds is how long after a switch the per-interval p99
+	// stayed above twice the baseline, or until the end of the run when
+	// Settled is false.
+	P99ElevatedSeconds float64 `json:"p99_elevated_seconds,omitempty"`
+	Settled            bool    `json:"settled,omitempty"`
+}
+
+// Failover timeline event kinds. Error runs are read off the time series,
+// so outages show even when no sentinel announced a switch.
+const (
+	eventSwitchMaster = "switch-master"
+	eventErrorsStart  = "errors-start"
+	eventErrorsEnd    = "errors-end"
+)
+
+// failoverReport is the failover section of a -sentinel-master run.
+type failoverReport struct {
+	Master        string          `json:"master"`
+	Sentinels     []string        `json:"sentinels"`
+	InitialMaster string          `json:"initial_master,omitempty"`
+	Switches      int             `json:"switches"`
+	BaselineP99Ns int64           `json:"baseline_p99_ns,omitempty"`
+	Events        []failoverEvent `json:"events"`
+}
+
+// buildFailover lays the master switches and the error runs of series out
+// on one timeline from start, where series starts at from seconds.
+func buildFailover(cfg *config, initial string, switches []masterSwitch, series []timePoint, from float64, start time.Time) *failoverReport {
+	rep := &failoverReport{Master: cfg.sentinelMaster, Sentinels: cfg.sentinels(), InitialMaster: initial, Switches: len(switches), Events: []failoverEvent{}}
+	firstSwitch := 1e18
+	if len(switches) > 0 {
+		firstSwitch = switches[0].at.Sub(start).Seconds()
+	}
+	rep.BaselineP99Ns = int64(baselineP99(series, firstSwitch))
+
+	prev, inErrors := from, false
+	for _, p := range series {
+		switch {
+		case p.Errors > 0 && !inErrors:
+			rep.Events = append(rep.Events, failoverEvent{T: prev, Kind: eventErrorsStart})
+		case p.Errors == 0 && inErrors:
+			rep.Events = append(rep.Events, failoverEvent{T: prev, Kind: eventErrorsEnd})
+		}
+		inErrors = p.Errors > 0
+		prev = p.T
+	}
+	for _, s := range switches {
+		e := failoverEvent{T: s.at.Sub(start).Seconds(), Kind: eventSwitchMaster, From: s.from, To: s.to}
+		e.P99ElevatedSeconds, e.Settled = p99Elevated(series, from, e.T, time.Duration(rep.BaselineP99Ns))
+		rep.Events = append(rep.Events, e)
+	}
+	sort.SliceStable(rep.Events, func(i, j int) bool { return rep.Events[i].T < rep.Events[j].T })
+	return rep
+}
+
+// baselineP99 returns the median per-interval p99 of the error-free
+// intervals that ended before the first switch, 0 when there are none.
+func baselineP99(series []timePoint, before float64) time.Duration {
+	var p99s []int64
+	for _, p := range series {
+		if p.T > before {
+			break
+		}
+		if p.Errors == 0 && p.P99Ns > 0 {
+			p99s = append(p99s, p.P99Ns)
+		}
+	}
+	if len(p99s) == 0 {
+		return 0
+	}
+	sort.Slice(p99s, func(i, j int) bool { return p99s[i] < p99s[j] })
+	return time.Duration(p99s[len(p99s)/2])
+}
+
+// p99Elevated returns how long after t the series took to reach an
+// error-free interval with a p99 within p99ElevatedFactor of baseline, and
+// whether it did before the run ended.
+func p99Elevated(series []timePoint, from, t float64, baseline time.Duration) (float64, bool) {
+	if baseline <= 0 {
+		return 0, false
+	}
+	prev := from
+	for _, p := range series {
+		if p.T > t && p.Errors == 0 && p.P99Ns > 0 && time.Duration(p.P99Ns) <= p99ElevatedFactor*baseline {
+			return max(prev-t, 0), true
+		}
+		prev = p.T
+	}
+	return max(prev-t, 0), false
+}
+
+// printFailover writes the failover timeline of the summary.
+func printFailover(w io.Writer, rep *failoverReport) {
+	fmt.Fprintf(w, "Failover: master %q via %d sentinels, %d switches", rep.Master, len(rep.Sentinels), rep.Switches)
+	if rep.InitialMaster != "" {
+		fmt.Fprintf(w, ", initially at %s", rep.InitialMaster)
+	}
+	if rep.BaselineP99Ns > 0 {
+		fmt.Fprintf(w, ", baseline p99 %v", time.Duration(rep.BaselineP99Ns))
+	}
+	fmt.Fprintln(w)
+	for _, e := range rep.Events {
+		fmt.Fprintf(w, "  %7.1fs  ", e.T)
+		switch e.Kind {
+		case eventSwitchMaster:
+			fmt.Fprintf(w, "master switched from %s to %s", e.From, e.To)
+			switch {
+			case rep.BaselineP99Ns == 0:
+			case e.Settled:
+				fmt.Fprintf(w, "; p99 elevated for %.1fs", e.P99ElevatedSeconds)
+			default:
+				fmt.Fprintf(w, "; p99 still elevated at the end, %.1fs later", e.P99ElevatedSeconds)
+			}
+		case eventErrorsStart:
+			fmt.Fprint(w, "operations started failing")
+		case eventErrorsEnd:
+			fmt.Fprint(w, "operations succeeding again")
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,193 @@
+package loadgen
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// fakeSentinel answers the few Sentinel commands go-redis sends and can
+// announce a master switch to its subscribers.
+type fakeSentinel struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	addr string
+	subs []net.Conn
+}
+
+func startFakeSentinel(t *testing.T, master string) *fakeSentinel {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSentinel{ln: ln, addr: master}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// readCommand reads one RESP array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func bulk(s string) string { return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s) }
+
+func (s *fakeSentinel) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil || len(args) == 0 {
+			return
+		}
+		var reply string
+		switch cmd := strings.ToLower(args[0]); {
+		case cmd == "sentinel" && len(args) > 1 && strings.ToLower(args[1]) == "get-master-addr-by-name":
+			s.mu.Lock()
+			host, port, _ := net.SplitHostPort(s.addr)
+			s.mu.Unlock()
+			reply = "*2\r\n" + bulk(host) + bulk(port)
+		case cmd == "sentinel":
+			reply = "*0\r\n"
+		case cmd == "subscribe":
+			for i, ch := range args[1:] {
+				reply += "*3\r\n" + bulk("subscribe") + bulk(ch) + ":" + strconv.Itoa(i+1) + "\r\n"
+			}
+			s.mu.Lock()
+			s.subs = append(s.subs, conn)
+			s.mu.Unlock()
+		case cmd == "ping":
+			reply = "*2\r\n" + bulk("pong") + bulk("")
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		s.mu.Lock()
+		_, err = conn.Write([]byte(reply))
+		s.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// switchTo makes addr the master and announces it.
+func (s *fakeSentinel) switchTo(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	oldHost, oldPort, _ := net.SplitHostPort(s.addr)
+	newHost, newPort, _ := net.SplitHostPort(addr)
+	s.addr = addr
+	msg := "*3\r\n" + bulk("message") + bulk("+switch-master") + bulk(strings.Join([]string{"mymaster", oldHost, oldPort, newHost, newPort}, " "))
+	for _, c := range s.subs {
+		c.Write([]byte(msg))
+	}
+}
+
+func TestSentinelFailover(t *testing.T) {
+	old, next := miniredis.RunT(t), miniredis.RunT(t)
+	s := startFakeSentinel(t, old.Addr())
+	cfg := testConfig(t, "-sentinel-addrs", s.ln.Addr().String(), "-sentinel-master", "mymaster",
+		"-clients", "2", "-duration", "600ms", "-key-prefix", "sn:")
+	time.AfterFunc(300*time.Millisecond, func() { s.switchTo(next.Addr()) })
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := res.failover
+	if f == nil || f.Switches != 1 || f.InitialMaster != old.Addr() {
+		t.Fatalf("failover report %+v, want one switch from %s", f, old.Addr())
+	}
+	var sw *failoverEvent
+	for i := range f.Events {
+		if f.Events[i].Kind == eventSwitchMaster {
+			sw = &f.Events[i]
+		}
+	}
+	if sw == nil || sw.From != old.Addr() || sw.To != next.Addr() || sw.T < 0.2 || sw.T > 0.6 {
+		t.Errorf("switch event %+v, want %s to %s after about 0.3s", sw, old.Addr(), next.Addr())
+	}
+	if len(old.Keys()) == 0 || len(next.Keys()) == 0 {
+		t.Errorf("%d keys written before the switch, %d after, want both", len(old.Keys()), len(next.Keys()))
+	}
+}
+
+func TestBuildFailoverTimeline(t *testing.T) {
+	ms := int64(time.Millisecond)
+	series := []timePoint{
+		{T: 1, Ops: 100, P99Ns: 1 * ms},
+		{T: 2, Ops: 100, P99Ns: 1 * ms},
+		{T: 3, Ops: 20, Errors: 80, P99Ns: 50 * ms},
+		{T: 4, Ops: 100, P99Ns: 10 * ms},
+		{T: 5, Ops: 100, P99Ns: 3 * ms},
+		{T: 6, Ops: 100, P99Ns: 2 * ms},
+	}
+	start := time.Now()
+	switches := []masterSwitch{{at: start.Add(2500 * time.Millisecond), from: "a:1", to: "b:1"}}
+	cfg := &config{sentinelMaster: "mymaster", sentinelAddrs: "s:26379"}
+
+	rep := buildFailover(cfg, "a:1", switches, series, 0, start)
+
+	if rep.BaselineP99Ns != ms {
+		t.Errorf("baseline p99 %v, want 1ms", time.Duration(rep.BaselineP99Ns))
+	}
+	var kinds []string
+	for _, e := range rep.Events {
+		kinds = append(kinds, e.Kind)
+	}
+	if got := strings.Join(kinds, ","); got != "errors-start,switch-master,errors-end" {
+		t.Fatalf("events %s", got)
+	}
+	// The first interval back within twice the baseline starts at 5s.
+	if sw := rep.Events[1]; !sw.Settled || sw.P99ElevatedSeconds != 2.5 {
+		t.Errorf("switch %+v, want p99 elevated for 2.5s", sw)
+	}
+}
+
+func TestSentinelFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-sentinel-master", "mymaster"},
+		{"-sentinel-addrs", "127.0.0.1:26379"},
+		{"-sentinel-master", "mymaster", "-sentinel-addrs", "127.0.0.1:26379", "-addr", "127.0.0.1:6379"},
+		{"-sentinel-master", "mymaster", "-sentinel-addrs", "127.0.0.1:26379", "-cluster"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
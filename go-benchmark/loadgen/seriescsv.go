@@ -0,0 +1,176 @@
+package loadgen
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -timeseries-out writes the time series as CSV, one row per interval
+// under a header row naming the columns:
+//
+//	timestamp            end of the interval, RFC 3339 with milliseconds
+//	t_s                  end of the interval in seconds since the measured window started, negative during -ramp-up
+//	ops                  operations that succeeded in the interval
+//	cumulative_ops       operations that succeeded up to the end of the interval
+//	errors               operations that failed in the interval
+//	errors_<class>       those of each error class of the summary, connection_refused, timeout and so on
+//	ops_per_sec          ops over the length of the interval
+//	window_p50_ns        p50 over the -percentile-window ending with the interval
+//	window_p99_ns        p99 over the same window
+//	clients              clients running at the end of the interval
+//	server_used_memory   used_memory of the last -capture-info snapshot taken in the interval
+//	server_evicted_keys  evicted_keys of the same snapshot, counted since the server started
+//
+// The columns are the same whatever the run: a value the run does not have,
+// such as the server columns of an interval without a snapshot, is an
+// empty cell. A row is written once the next interval ends, when the
+// snapshots taken during it are complete, and flushed at once, so an
+// interrupted run leaves every interval but the last on disk.
+
+// seriesServerFields are the INFO fields of the server columns.
+var seriesServerFields = []string{"used_memory", "evicted_keys"}
+
+// validateTimeSeriesOut checks -timeseries-out.
+func (c *config) validateTimeSeriesOut() error {
+	if c.timeSeriesOut != "" && (c.addr2 != "" || c.sweeping() || c.scenario != "") {
+		return errors.New("-timeseries-out cannot be combined with -addr2, sweeps or -scenario")
+	}
+	return nil
+}
+
+// seriesColumns returns the header row of -timeseries-out.
+func seriesColumns() []string {
+	cols := []string{"timestamp", "t_s", "ops", "cumulative_ops", "errors"}
+	for c := errClass(0); c < numErrClasses; c++ {
+		cols = append(cols, "errors_"+strings.ReplaceAll(c.String(), " ", "_"))
+	}
+	cols = append(cols, "ops_per_sec", "window_p50_ns", "window_p99_ns", "clients")
+	for _, name := range seriesServerFields {
+		cols = append(cols, "server_"+name)
+	}
+	return cols
+}
+
+// seriesRow is an interval waiting to be written.
+type seriesRow struct {
+	point      timePoint
+	from, at   time.Time
+	errClasses [numErrClasses]int64
+}
+
+// seriesWriter writes the rows of -timeseries-out as the sampler ends the
+// intervals.
+type seriesWriter struct {
+	path string
+	file *os.File
+	w    *csv.Writer
+
+	mu         sync.Mutex
+	info       *infoMonitor
+	pending    *seriesRow
+	cumulative int64
+	written    int
+	err        error
+}
+
+func newSeriesWriter(path string) (*seriesWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create time series: %w", err)
+	}
+	sw := &seriesWriter{path: path, file: f, w: csv.NewWriter(f)}
+	sw.w.Write(seriesColumns())
+	sw.w.Flush()
+	return sw, nil
+}
+
+// watchInfo aligns the server columns on the snapshots of m.
+func (sw *seriesWriter) watchInfo(m *infoMonitor) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.info = m
+}
+
+// add queues the interval of p, from from to at, and writes the one before
+// it.
+func (sw *seriesWriter) add(p timePoint, from, at time.Time, errClasses [numErrClasses]int64) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.writePending()
+	sw.pending = &seriesRow{point: p, from: from, at: at, errClasses: errClasses}
+}
+
+// writePending writes the queued interval, if any.
+func (sw *seriesWriter) writePending() {
+	r := sw.pending
+	if r == nil || sw.err != nil {
+		return
+	}
+	sw.pending = nil
+	p := r.point
+	sw.cumulative += p.Ops
+	row := []string{
+		r.at.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		strconv.FormatFloat(p.T, 'f', 3, 64),
+		strconv.FormatInt(p.Ops, 10),
+		strconv.FormatInt(sw.cumulative, 10),
+		strconv.FormatInt(p.Errors, 10),
+	}
+	for _, n := range r.errClasses {
+		row = append(row, strconv.FormatInt(n, 10))
+	}
+	rate := ""
+	if d := r.at.Sub(r.from); d > 0 {
+		rate = strconv.FormatFloat(float64(p.Ops)/d.Seconds(), 'f', 1, 64)
+	}
+	row = append(row, rate, optionalInt(p.WindowP50Ns), optionalInt(p.WindowP99Ns), strconv.FormatInt(p.Clients, 10))
+	snap, ok := sw.info.latestIn(r.from, r.at)
+	for _, name := range seriesServerFields {
+		cell := ""
+		if v, found := snap.Fields[name]; ok && found {
+			cell = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		row = append(row, cell)
+	}
+	sw.w.Write(row)
+	sw.w.Flush()
+	if sw.err = sw.w.Error(); sw.err == nil {
+		sw.written++
+	}
+}
+
+// optionalInt formats n, a value the sampler leaves 0 when it has none, as
+// an empty cell then.
+func optionalInt(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// close writes the last interval and closes the file.
+func (sw *seriesWriter) close() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.writePending()
+	err := sw.err
+	if cerr := sw.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write time series: %w", err)
+	}
+	return nil
+}
+
+// printSeriesOut writes the -timeseries-out line of the summary.
+func printSeriesOut(w io.Writer, sw *seriesWriter) {
+	fmt.Fprintf(w, "Time series: %d intervals written to %s\n", sw.written, sw.path)
+}
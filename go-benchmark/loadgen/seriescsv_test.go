@@ -0,0 +1,144 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeriesWriterRows(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "series.csv")
+	sw, err := newSeriesWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	mon := &infoMonitor{snapshots: []infoSnapshot{
+		{Fields: map[string]float64{"used_memory": 1024, "evicted_keys": 3}, at: start.Add(500 * time.Millisecond)},
+	}}
+	sw.watchInfo(mon)
+
+	var timeout [numErrClasses]int64
+	timeout[errTimeout] = 2
+	sw.add(timePoint{T: 1, Ops: 10, Errors: 2, Clients: 4, WindowP99Ns: 900}, start, start.Add(time.Second), timeout)
+	// The row of an interval is only written once the next one ends.
+	if rows := readSeries(t, path); len(rows) != 1 {
+		t.Fatalf("%d rows before the second interval", len(rows))
+	}
+	sw.add(timePoint{T: 2, Ops: 30, Clients: 4}, start.Add(time.Second), start.Add(2*time.Second), [numErrClasses]int64{})
+	if err := sw.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := readSeries(t, path)
+	cols := seriesColumns()
+	if len(rows) != 3 || strings.Join(rows[0], ",") != strings.Join(cols, ",") {
+		t.Fatalf("rows %v", rows)
+	}
+	cell := func(row []string, name string) string {
+		for i, c := range cols {
+			if c == name {
+				return row[i]
+			}
+		}
+		t.Fatalf("no column %s", name)
+		return ""
+	}
+	first, second := rows[1], rows[2]
+	for _, c := range []struct {
+		row        []string
+		name, want string
+	}{
+		{first, "ops", "10"},
+		{first, "cumulative_ops", "10"},
+		{first, "errors_timeout", "2"},
+		{first, "ops_per_sec", "10.0"},
+		{first, "window_p50_ns", ""},
+		{first, "window_p99_ns", "900"},
+		{first, "server_used_memory", "1024"},
+		{first, "server_evicted_keys", "3"},
+		{second, "cumulative_ops", "40"},
+		{second, "errors_timeout", "0"},
+		{second, "server_used_memory", ""},
+	} {
+		if got := cell(c.row, c.name); got != c.want {
+			t.Errorf("%s = %q, want %q", c.name, got, c.want)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339, cell(first, "timestamp")); err != nil {
+		t.Error(err)
+	}
+	if sw.written != 2 {
+		t.Errorf("%d intervals counted", sw.written)
+	}
+}
+
+func TestTimeSeriesOutRun(t *testing.T) {
+	mr, _ := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "series.csv")
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-duration", "2500ms", "-timeseries-out", path)
+	sw, err := newSeriesWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.seriesOut = sw
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sw.close(); err != nil {
+		t.Fatal(err)
+	}
+	res.seriesOut = sw
+
+	rows := readSeries(t, path)
+	if len(rows) != len(res.series)+1 {
+		t.Fatalf("%d rows for %d intervals", len(rows), len(res.series))
+	}
+	var total int64
+	for i, row := range rows[1:] {
+		n, _ := strconv.ParseInt(row[3], 10, 64)
+		total += res.series[i].Ops
+		if n != total {
+			t.Errorf("row %d: cumulative_ops %d, want %d", i, n, total)
+		}
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "Time series: "+strconv.Itoa(len(res.series))+" intervals written to "+path) {
+		t.Errorf("summary lacks the time series file:\n%s", buf.String())
+	}
+}
+
+func TestValidateTimeSeriesOut(t *testing.T) {
+	for _, args := range [][]string{
+		{"-timeseries-out", "x.csv", "-addr2", "localhost:1"},
+		{"-timeseries-out", "x.csv", "-sweep-clients", "1,2"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
+
+// readSeries parses the CSV at path.
+func readSeries(t *testing.T, path string) [][]string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rows
+}
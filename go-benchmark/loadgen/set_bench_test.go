@@ -0,0 +1,231 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+// benchKeys is how many keys each value size of BenchmarkRedis preloads
+// and cycles through.
+const benchKeys = 1000
+
+// benchServer returns the server BenchmarkRedis runs against, $BENCH_ADDR
+// or localhost:6379.
+func benchServer() string {
+	if addr := os.Getenv("BENCH_ADDR"); addr != "" {
+		return addr
+	}
+	return "localhost:6379"
+}
+
+// benchTarget is the client and keyspace of one value size, set up and
+// preloaded once for all the runs of its sub-benchmarks.
+type benchTarget struct {
+	cfg *config
+	rdb redis.UniversalClient
+}
+
+// newBenchTarget connects to addr the way the tool does and preloads
+// benchKeys values of size bytes under a key prefix of its own, removed
+// when the benchmark ends. It skips b when the server is not reachable.
+func newBenchTarget(b *testing.B, addr string, size int) *benchTarget {
+	cfg, err := parseFlags([]string{"-addr", addr, "-clients", "100", "-pool-size", "1000",
+		"-preload", strconv.Itoa(benchKeys), "-value-size", strconv.Itoa(size), "-cleanup", cleanupScanDel})
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+	rdb, _ := newClient(cfg)
+	if err := checkReachable(ctx, rdb); err != nil {
+		rdb.Close()
+		b.Skipf("%s: %v", addr, err)
+	}
+	b.Cleanup(func() {
+		cleanupKeys(ctx, rdb, cfg)
+		rdb.Close()
+	})
+	t := &benchTarget{cfg: cfg, rdb: rdb}
+	t.preload(b)
+	return t
+}
+
+func (t *benchTarget) preload(b *testing.B) {
+	if res := preloadKeys(context.Background(), t.rdb, t.cfg); res.failed > 0 {
+		b.Fatalf("preload: %d of %d keys failed", res.failed, res.keys)
+	}
+}
+
+// BenchmarkRedis measures SET, GET and DEL against a live server across
+// concurrency and value size, for example
+//
+//	BENCH_ADDR=localhost:6379 go test -run '^$' -bench 'Redis/GET/clients=100'
+//
+// Each sub-benchmark spreads b.N operations over its clients and reports
+// the throughput in ops/s besides ns/op and, for SET and GET, MB/s. A DEL
+// run preloads the keyspace again first, but once b.N exceeds benchKeys
+// deletes of keys already deleted make up the rest.
+func BenchmarkRedis(b *testing.B) {
+	addr := benchServer()
+	sizes := []int{64, 1 << 10, 64 << 10}
+	targets := make(map[int]*benchTarget, len(sizes))
+	for _, size := range sizes {
+		targets[size] = newBenchTarget(b, addr, size)
+	}
+	for _, cmd := range []string{"SET", "GET", "DEL"} {
+		b.Run(cmd, func(b *testing.B) {
+			for _, clients := range []int{1, 10, 100, 1000} {
+				b.Run(fmt.Sprintf("clients=%d", clients), func(b *testing.B) {
+					for _, size := range sizes {
+						b.Run("value="+formatByteSize(size), func(b *testing.B) {
+							targets[size].bench(b, cmd, clients)
+						})
+					}
+				})
+			}
+		})
+	}
+}
+
+// bench issues b.N commands of cmd from clients goroutines over the
+// preloaded keyspace.
+func (t *benchTarget) bench(b *testing.B, cmd string, clients int) {
+	cfg := t.cfg
+	if cmd == "DEL" {
+		t.preload(b)
+	} else {
+		b.SetBytes(int64(cfg.valueSize))
+	}
+	ctx := context.Background()
+	var next, failed atomic.Int64
+	// The first error is kept whatever its type, which an atomic.Value
+	// would require to stay the same.
+	var first error
+	var firstOnce sync.Once
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	start := time.Now()
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(cfg.seed + int64(c)))
+			for {
+				i := next.Add(1) - 1
+				if i >= int64(b.N) {
+					return
+				}
+				key := cfg.keyName(int(i % benchKeys))
+				var err error
+				switch cmd {
+				case "SET":
+					err = t.rdb.Set(ctx, key, cfg.values.Next(rng), 0).Err()
+				case "GET":
+					err = t.rdb.Get(ctx, key).Err()
+				case "DEL":
+					err = t.rdb.Del(ctx, key).Err()
+				}
+				if err != nil {
+					failed.Add(1)
+					firstOnce.Do(func() { first = err })
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/elapsed.Seconds(), "ops/s")
+	if n := failed.Load(); n > 0 {
+		b.Errorf("%d of %d %s failed, first: %v", n, b.N, cmd, first)
+	}
+}
+
+// BenchmarkStatsGlobalMutex measures the former stat path, where every
+// operation took a process-wide mutex to add to a shared cumulative time.
+func BenchmarkStatsGlobalMutex(b *testing.B) {
+	var mu sync.Mutex
+	var cumulative time.Duration
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			mu.Lock()
+			cumulative += time.Microsecond
+			mu.Unlock()
+		}
+	})
+	_ = cumulative
+}
+
+// BenchmarkStatsPerWorker measures the current stat path, where each worker
+// records into its own result and results are merged after the run.
+func BenchmarkStatsPerWorker(b *testing.B) {
+	var mu sync.Mutex
+	total := newWorkerResult(stats.DefaultLayout)
+	b.RunParallel(func(pb *testing.PB) {
+		r := newWorkerResult(stats.DefaultLayout)
+		for pb.Next() {
+			r.latency.Record(time.Microsecond)
+			r.ops[opSet].record(stats.DefaultLayout, time.Microsecond)
+		}
+		mu.Lock()
+		total.merge(r)
+		mu.Unlock()
+	})
+}
+
+// benchValue is the value the client benchmarks send and read back.
+var benchValue = bytes.Repeat([]byte("v"), 128)
+
+// BenchmarkClientGoRedis measures a SET and a GET through go-redis against
+// respResponder, reporting what the client allocates per pair.
+func BenchmarkClientGoRedis(b *testing.B) {
+	rdb := redis.NewClient(&redis.Options{Addr: respResponder(b, benchValue), PoolSize: 1})
+	defer rdb.Close()
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := rdb.Set(ctx, "key", benchValue, 0).Err(); err != nil {
+			b.Fatal(err)
+		}
+		if err := rdb.Get(ctx, "key").Err(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkClientRaw measures the same pair through the -client raw
+// connection.
+func BenchmarkClientRaw(b *testing.B) {
+	cfg, err := parseFlags([]string{"-addr", respResponder(b, benchValue), "-client", "raw"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := context.Background()
+	c, err := dialResp(ctx, cfg, resp2)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer c.close()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.set(ctx, "key", benchValue, 0); err != nil {
+			b.Fatal(err)
+		}
+		if _, _, err := c.get(ctx, "key"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package loadgen
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// workloadSets runs -ratio, or defaultSetsRatio, over a keyspace of sets.
+// It is not called "set", which is the SET workload.
+const workloadSets = "sets"
+
+// defaultSetsRatio is the command mix of -workload sets without -ratio.
+const defaultSetsRatio = "sadd=0.1,sismember=0.85,smembers=0.05"
+
+// setOps are the commands that treat keys of the keyspace as sets of
+// -set-members members.
+var setOps = map[opType]bool{
+	opSAdd:      true,
+	opSIsMember: true,
+	opSMembers:  true,
+}
+
+// setMember returns the name of member i of every set. Members outside
+// [0, -set-members) are never added.
+func setMember(i int) string {
+	return "m" + strconv.Itoa(i)
+}
+
+// setNonMember returns a name no set ever holds.
+func setNonMember(i int) string {
+	return "x" + strconv.Itoa(i)
+}
+
+// usesSets reports whether the keyspace holds sets.
+func (c *config) usesSets() bool {
+	if c.mix == nil {
+		return false
+	}
+	for _, op := range c.mix.ops {
+		if setOps[op] {
+			return true
+		}
+	}
+	return false
+}
+
+// setMembers returns every member of a set, to fill it to its cardinality.
+func (c *config) setMembers() []interface{} {
+	members := make([]interface{}, c.setSize)
+	for i := range members {
+		members[i] = setMember(i)
+	}
+	return members
+}
+
+// issueSet sends one SADD, SISMEMBER or SMEMBERS to key. SADD re-adds an
+// existing member so every set keeps its known membership; SISMEMBER asks
+// about a member or, for -set-miss-ratio of queries, a name that is never
+// added, and records the answer the server must give.
+func (w *worker) issueSet(ctx context.Context, c redis.Cmdable, op opType, key string, hot bool) pendingOp {
+	cfg := w.run.cfg
+	switch op {
+	case opSAdd:
+		return pendingOp{op: op, cmd: c.SAdd(ctx, key, setMember(w.rng.Intn(cfg.setSize))), key: key, hot: hot}
+	case opSIsMember:
+		if w.rng.Float64() < cfg.setMissRatio {
+			// A non-member is absent whatever else the run does.
+			return pendingOp{op: op, cmd: c.SIsMember(ctx, key, setNonMember(w.rng.Int())), key: key, hot: hot, check: true}
+		}
+		member := setMember(w.rng.Intn(cfg.setSize))
+		return pendingOp{op: op, cmd: c.SIsMember(ctx, key, member), key: key, hot: hot, check: cfg.checkFullKeys, want: true}
+	default:
+		return pendingOp{op: op, cmd: c.SMembers(ctx, key), key: key, hot: hot}
+	}
+}
+
+// checkMembership counts a SISMEMBER answer that differs from the one the
+// server must give.
+func (w *worker) checkMembership(p pendingOp, isMember bool) {
+	if p.check && isMember != p.want {
+		w.result.setWrong++
+	}
+}
+
+// checkSetSize counts an SMEMBERS reply that does not hold every member.
+func (w *worker) checkSetSize(n int) {
+	if w.run.cfg.checkFullKeys && n != w.run.cfg.setSize {
+		w.result.setShort++
+	}
+}
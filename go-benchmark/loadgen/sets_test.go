@@ -0,0 +1,44 @@
+package loadgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestSetsWorkload(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-workload", "sets", "-preload", "10", "-set-members", "30",
+		"-clients", "4", "-ops", "200", "-key-prefix", "s:")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if members, _ := mr.Members("s:key9"); len(members) != 30 {
+		t.Errorf("preloaded set has %d members, want 30", len(members))
+	}
+	s := &res.total.ops[opSIsMember]
+	if s.hits == 0 || s.misses == 0 {
+		t.Errorf("SISMEMBER: %d members, %d non-members, want queries of both", s.hits, s.misses)
+	}
+	if res.total.setWrong != 0 || res.total.setShort != 0 || res.verifyFailed() {
+		t.Errorf("%d wrong answers, %d short SMEMBERS from a correct server", res.total.setWrong, res.total.setShort)
+	}
+}
+
+func TestSetsWorkloadCountsWrongAnswers(t *testing.T) {
+	mr, rdb := newTestServer(t)
+	cfg := testConfig(t, "-workload", "sets", "-ratio", "sismember=1", "-set-miss-ratio", "0",
+		"-preload", "1", "-set-members", "5", "-clients", "1", "-ops", "20", "-key-prefix", "s:")
+	// The set lost all but one of its members.
+	mr.SAdd("s:key0", "m0")
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	if want := res.total.ops[opSIsMember].misses; want == 0 || res.total.setWrong != want {
+		t.Errorf("%d wrong answers, want one per missing member (%d)", res.total.setWrong, want)
+	}
+}
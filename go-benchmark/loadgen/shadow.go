@@ -0,0 +1,502 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+const (
+	// shadowExamples is how many diverging replies are logged and kept
+	// in the report.
+	shadowExamples = 10
+	// shadowRechecks is how many diverging reads of each queue are read
+	// again on both targets after the run.
+	shadowRechecks = 100
+	// shadowSnippet is how many bytes of each reply a diff summary quotes
+	// around the first difference.
+	shadowSnippet = 24
+)
+
+// shadowWrites are the commands -shadow sends to the shadow target as well,
+// and shadowReads those whose replies it compares.
+var (
+	shadowWrites = map[opType]bool{
+		opSet: true, opDel: true, opExpire: true, opIncr: true, opSetNX: true, opHSet: true,
+		opZAdd: true, opSAdd: true, opMSet: true, opAppend: true,
+	}
+	shadowReads = map[opType]bool{
+		opGet: true, opHGet: true, opHGetAll: true, opZRange: true, opZRevRange: true,
+		opSIsMember: true, opSMembers: true, opMGet: true, opGetRange: true,
+	}
+)
+
+// validateShadow checks -shadow. The shadow target replays the commands of
+// the go-redis client as they were sent, so every command of the workload
+// must be one it can replay without changing what the workers see.
+func (c *config) validateShadow() error {
+	if c.shadowAddr == "" {
+		for _, name := range []string{"shadow-reads", "shadow-queue"} {
+			if _, ok := c.explicit[name]; ok {
+				return fmt.Errorf("-%s requires -shadow", name)
+			}
+		}
+		return nil
+	}
+	switch {
+	case c.shadowAddr == c.addr:
+		return errors.New("-shadow must differ from -addr")
+	case c.shadowReads < 0 || c.shadowReads > 1:
+		return fmt.Errorf("-shadow-reads must be between 0 and 1, got %v", c.shadowReads)
+	case c.shadowQueue < 1:
+		return fmt.Errorf("-shadow-queue must be at least 1, got %d", c.shadowQueue)
+	case c.client == clientRaw:
+		return errors.New("-shadow replays the commands of the go-redis client, not -client raw")
+	case c.addr2 != "":
+		return errors.New("-shadow cannot be combined with -addr2")
+	case c.dbList != "":
+		return errors.New("-shadow mirrors one database, not -dbs")
+	}
+	if network, path := splitAddr(c.shadowAddr); network == "unix" && path == "" {
+		return fmt.Errorf("%q names no socket path", c.shadowAddr)
+	}
+	if c.workload != workloadSet {
+		if c.mix == nil {
+			return fmt.Errorf("-shadow does not apply to the %s workload", c.workload)
+		}
+		for _, op := range c.mix.ops {
+			if !shadowWrites[op] && !shadowReads[op] {
+				return fmt.Errorf("-shadow cannot mirror %s", opNames[op])
+			}
+		}
+	}
+	return nil
+}
+
+// shadowOp is a command of the primary for the shadow target to replay.
+// primary is the canonical reply of a read to compare, nil for a write.
+type shadowOp struct {
+	op      opType
+	key     string
+	args    []any
+	primary []byte
+}
+
+// shadowMirror replays the writes of a run, and a fraction of its reads,
+// on the shadow target. Each key maps to one of a set of queues, each
+// drained by a goroutine of its own, so the shadow applies the commands of
+// a key in the order the primary acknowledged them. A worker never waits
+// for the shadow: an operation finding its queue full is dropped.
+type shadowMirror struct {
+	addr   string
+	reads  float64
+	layout stats.Layout
+	logger *slog.Logger
+	// primary is the client of the run, which rechecks reads.
+	primary redis.UniversalClient
+	rdb     *redis.Client
+	queues  []chan shadowOp
+	wg      sync.WaitGroup
+
+	dropped atomic.Int64
+	// mu guards tainted, the keys whose writes the shadow missed, either
+	// dropped or failed on the primary, whose reads are not compared.
+	mu      sync.Mutex
+	tainted map[string]bool
+	// tallies are owned by the goroutine draining the queue of the same
+	// index until close.
+	tallies []*shadowTally
+}
+
+// shadowTally counts what one queue of the mirror replayed.
+type shadowTally struct {
+	writes, reads, mismatches, skipped, errors int64
+	latency                                    [numOpTypes]*stats.Histogram
+	// suspects are the first reads whose replies differed, checked again
+	// once the run is over.
+	suspects []shadowOp
+}
+
+// shadowMismatch is a read whose replies diverged.
+type shadowMismatch struct {
+	Command string `json:"command"`
+	Key     string `json:"key"`
+	Diff    string `json:"diff"`
+}
+
+// startShadow connects to the shadow target of cfg, a single server, with
+// a connection per queue. The queues hold -shadow-queue operations between
+// them, one per client at most.
+func startShadow(cfg *config, primary redis.UniversalClient) *shadowMirror {
+	n := min(cfg.clients, cfg.shadowQueue)
+	target := cfg.forTarget(cfg.shadowAddr)
+	target.poolSize = n
+	opt := target.clientOptions()
+	// A write retried after a lost reply could apply twice.
+	opt.MaxRetries = -1
+	if cfg.opTimeout > 0 {
+		opt.ReadTimeout, opt.WriteTimeout = cfg.opTimeout, cfg.opTimeout
+	}
+	rdb := redis.NewClient(opt)
+	m := &shadowMirror{addr: cfg.shadowAddr, reads: cfg.shadowReads, layout: cfg.layout, logger: cfg.logger, primary: primary, rdb: rdb, tainted: make(map[string]bool)}
+	for i := 0; i < n; i++ {
+		q := make(chan shadowOp, max(cfg.shadowQueue/n, 1))
+		t := &shadowTally{}
+		m.queues = append(m.queues, q)
+		m.tallies = append(m.tallies, t)
+		m.wg.Add(1)
+		go m.drain(q, t)
+	}
+	return m
+}
+
+// mirror hands the operation p, which succeeded on the primary, to the
+// shadow: every write, and reads while the fraction -shadow-reads allows.
+func (w *worker) mirror(m *shadowMirror, p pendingOp) {
+	switch {
+	case shadowWrites[p.op]:
+		m.send(shadowOp{op: p.op, key: p.key, args: copyArgs(p.cmd.Args())})
+	case shadowReads[p.op]:
+		if w.shadowCredit += m.reads; w.shadowCredit < 1 {
+			return
+		}
+		w.shadowCredit--
+		m.send(shadowOp{op: p.op, key: p.key, args: copyArgs(p.cmd.Args()), primary: canonicalReply(p.op, cmdReply(p.cmd))})
+	}
+}
+
+// copyArgs copies the byte slices of args, which may be buffers a worker
+// reuses.
+func copyArgs(args []any) []any {
+	c := make([]any, len(args))
+	for i, a := range args {
+		if b, ok := a.([]byte); ok {
+			a = bytes.Clone(b)
+		}
+		c[i] = a
+	}
+	return c
+}
+
+func (m *shadowMirror) send(op shadowOp) {
+	select {
+	case m.queues[hashKey(op.key)%uint64(len(m.queues))] <- op:
+	default:
+		m.dropped.Add(1)
+		if op.primary == nil {
+			m.taint(op.key)
+		}
+	}
+}
+
+// taint excludes key from the comparisons once the shadow missed a write
+// of it.
+func (m *shadowMirror) taint(key string) {
+	m.mu.Lock()
+	m.tainted[key] = true
+	m.mu.Unlock()
+}
+
+func (m *shadowMirror) isTainted(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.tainted[key]
+}
+
+func (m *shadowMirror) drain(q chan shadowOp, t *shadowTally) {
+	defer m.wg.Done()
+	for op := range q {
+		if op.primary != nil && m.isTainted(op.key) {
+			t.skipped++
+			continue
+		}
+		start := time.Now()
+		reply, err := resend(m.rdb, op)
+		elapsed := time.Since(start)
+		if err != nil {
+			t.errors++
+			if op.primary == nil {
+				m.taint(op.key)
+			}
+			continue
+		}
+		if t.latency[op.op] == nil {
+			t.latency[op.op] = m.layout.New()
+		}
+		t.latency[op.op].Record(elapsed)
+		if op.primary == nil {
+			t.writes++
+			continue
+		}
+		t.reads++
+		if !bytes.Equal(canonicalReply(op.op, reply), op.primary) {
+			t.mismatches++
+			if len(t.suspects) < shadowRechecks {
+				t.suspects = append(t.suspects, op)
+			}
+		}
+	}
+}
+
+// resend sends the command of op to rdb. An error reply is a reply like
+// any other; err is only set when the command got none.
+func resend(rdb redis.UniversalClient, op shadowOp) (any, error) {
+	reply, err := rdb.Do(context.Background(), op.args...).Result()
+	var rerr redis.Error
+	switch {
+	case err == redis.Nil:
+		return nil, nil
+	case errors.As(err, &rerr):
+		return rerr, nil
+	}
+	return reply, err
+}
+
+// recheck reads op again on both targets once no write is in flight, and
+// returns how their replies still differ, "" when they agree. A read that
+// raced with a write of another client differed only for a while.
+func (m *shadowMirror) recheck(op shadowOp) string {
+	primary, err := resend(m.primary, op)
+	if err != nil {
+		return fmt.Sprintf("primary: %v", err)
+	}
+	shadow, err := resend(m.rdb, op)
+	if err != nil {
+		return fmt.Sprintf("shadow: %v", err)
+	}
+	a, b := canonicalReply(op.op, primary), canonicalReply(op.op, shadow)
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	return diffReplies(a, b)
+}
+
+// cmdReply returns the reply of a read on the primary in the form the
+// shadow's Do returns it: nil for a missing value and RESP2 arrays for
+// maps.
+func cmdReply(cmd redis.Cmder) any {
+	switch c := cmd.(type) {
+	case *redis.StringCmd:
+		if c.Err() == redis.Nil {
+			return nil
+		}
+		return c.Val()
+	case *redis.IntCmd:
+		return c.Val()
+	case *redis.BoolCmd:
+		if c.Val() {
+			return int64(1)
+		}
+		return int64(0)
+	case *redis.StringSliceCmd:
+		return c.Val()
+	case *redis.SliceCmd:
+		return c.Val()
+	case *redis.StringStringMapCmd:
+		pairs := make([]any, 0, 2*len(c.Val()))
+		for k, v := range c.Val() {
+			pairs = append(pairs, k, v)
+		}
+		return pairs
+	case *redis.Cmd:
+		if c.Err() == redis.Nil {
+			return nil
+		}
+		return c.Val()
+	}
+	return fmt.Sprint(cmd)
+}
+
+// canonicalReply encodes the reply of op in RESP, sorting the members of
+// the replies whose order the server does not define.
+func canonicalReply(op opType, reply any) []byte {
+	if items := replyItems(reply); items != nil {
+		switch op {
+		case opSMembers:
+			sort.SliceStable(items, func(i, j int) bool { return fmt.Sprint(items[i]) < fmt.Sprint(items[j]) })
+		case opHGetAll:
+			pairs := make([][2]any, len(items)/2)
+			for i := range pairs {
+				pairs[i] = [2]any{items[2*i], items[2*i+1]}
+			}
+			sort.SliceStable(pairs, func(i, j int) bool { return fmt.Sprint(pairs[i][0]) < fmt.Sprint(pairs[j][0]) })
+			for i, p := range pairs {
+				items[2*i], items[2*i+1] = p[0], p[1]
+			}
+		}
+		reply = items
+	}
+	return appendReply(nil, reply)
+}
+
+// replyItems returns the elements of an array reply, nil for other replies.
+func replyItems(reply any) []any {
+	switch r := reply.(type) {
+	case []any:
+		return append(make([]any, 0, len(r)), r...)
+	case []string:
+		items := make([]any, len(r))
+		for i, s := range r {
+			items[i] = s
+		}
+		return items
+	}
+	return nil
+}
+
+func appendReply(b []byte, reply any) []byte {
+	switch r := reply.(type) {
+	case nil:
+		return append(b, "$-1\r\n"...)
+	case string:
+		return appendBulk(b, r)
+	case int64:
+		return append(strconv.AppendInt(append(b, ':'), r, 10), "\r\n"...)
+	case redis.Error:
+		return append(append(append(b, '-'), r.Error()...), "\r\n"...)
+	case []any, []string:
+		items := replyItems(r)
+		b = appendArray(b, len(items))
+		for _, item := range items {
+			b = appendReply(b, item)
+		}
+		return b
+	}
+	return appendBulk(b, fmt.Sprint(reply))
+}
+
+// diffReplies summarizes how the shadow's reply differs from the primary's:
+// their sizes and both quoted around the first byte that differs.
+func diffReplies(primary, shadow []byte) string {
+	i := 0
+	for i < len(primary) && i < len(shadow) && primary[i] == shadow[i] {
+		i++
+	}
+	from := max(i-shadowSnippet/2, 0)
+	quote := func(b []byte) string {
+		return strconv.Quote(string(b[min(from, len(b)):min(from+shadowSnippet, len(b))]))
+	}
+	return fmt.Sprintf("%d vs %d bytes, first difference at byte %d: primary %s, shadow %s",
+		len(primary), len(shadow), i, quote(primary), quote(shadow))
+}
+
+// finish waits for the queues to drain, rechecks the reads that differed,
+// disconnects and reports what the shadow replayed. primary holds the
+// operations of the run on the primary, whose latency the report sets
+// beside the shadow's.
+func (m *shadowMirror) finish(primary []opStats) *shadowReport {
+	for _, q := range m.queues {
+		close(q)
+	}
+	m.wg.Wait()
+	defer m.rdb.Close()
+	rep := &shadowReport{Addr: m.addr, Dropped: m.dropped.Load()}
+	var latency [numOpTypes]*stats.Histogram
+	for _, t := range m.tallies {
+		rep.Writes += t.writes
+		rep.Reads += t.reads
+		rep.Mismatches += t.mismatches
+		rep.Skipped += t.skipped
+		rep.Errors += t.errors
+		for _, op := range t.suspects {
+			diff := m.recheck(op)
+			if diff == "" {
+				continue
+			}
+			rep.Confirmed++
+			if len(rep.Examples) < shadowExamples {
+				m.logger.Warn("shadow reply differs", "command", opNames[op.op], "key", op.key, "diff", diff)
+				rep.Examples = append(rep.Examples, shadowMismatch{Command: opNames[op.op], Key: op.key, Diff: diff})
+			}
+		}
+		for op, h := range t.latency {
+			if h == nil {
+				continue
+			}
+			if latency[op] == nil {
+				latency[op] = h.Empty()
+			}
+			latency[op].Merge(h)
+		}
+	}
+	for op, h := range latency {
+		if h == nil {
+			continue
+		}
+		c := shadowCommand{Command: opNames[op], Shadow: summarizeLatency(h)}
+		if op < len(primary) {
+			c.Primary = summarizeLatency(primary[op].latency)
+		}
+		rep.Commands = append(rep.Commands, c)
+	}
+	return rep
+}
+
+// shadowReport is the divergence report of -shadow.
+type shadowReport struct {
+	Addr   string `json:"addr"`
+	Writes int64  `json:"writes"`
+	Reads  int64  `json:"reads_compared"`
+	// Mismatches counts the reads whose replies differed byte for byte,
+	// some of which may have raced with the write of another client, and
+	// Confirmed those still differing when read again after the run, of
+	// the first shadowRechecks of each queue.
+	Mismatches int64 `json:"mismatches"`
+	Confirmed  int64 `json:"confirmed_mismatches"`
+	// Skipped counts the reads not compared because the shadow missed a
+	// write of their key, and Dropped the operations a full queue
+	// dropped.
+	Skipped  int64            `json:"skipped"`
+	Dropped  int64            `json:"dropped"`
+	Errors   int64            `json:"errors"`
+	Commands []shadowCommand  `json:"commands"`
+	Examples []shadowMismatch `json:"mismatch_examples,omitempty"`
+}
+
+// shadowCommand sets the latency of a command on the shadow beside that of
+// every run of it on the primary.
+type shadowCommand struct {
+	Command string          `json:"command"`
+	Primary *latencySummary `json:"primary"`
+	Shadow  *latencySummary `json:"shadow"`
+}
+
+func (r *shadowReport) failed() bool {
+	return r != nil && r.Confirmed > 0
+}
+
+// printShadow writes the -shadow section of the summary.
+func printShadow(w io.Writer, rep *shadowReport) {
+	fmt.Fprintf(w, "Shadow %s: %d writes mirrored, %d reads compared, %d differed (%d still after the run), %d skipped after a missed write, %d dropped, %d errors\n",
+		rep.Addr, rep.Writes, rep.Reads, rep.Mismatches, rep.Confirmed, rep.Skipped, rep.Dropped, rep.Errors)
+	for _, c := range rep.Commands {
+		fmt.Fprintf(w, "  %-10s", c.Command)
+		for _, t := range []struct {
+			name string
+			l    *latencySummary
+		}{{"primary", c.Primary}, {"shadow", c.Shadow}} {
+			if t.l != nil {
+				fmt.Fprintf(w, "  %s p50 %v, p99 %v", t.name, time.Duration(t.l.P50Ns), time.Duration(t.l.P99Ns))
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	if rep.failed() {
+		fmt.Fprintf(w, "CORRECTNESS FAILURE: %d replies of the shadow still differed from the primary's after the run\n", rep.Confirmed)
+		for _, e := range rep.Examples {
+			fmt.Fprintf(w, "  %s %s: %s\n", e.Command, e.Key, e.Diff)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestCanonicalReply(t *testing.T) {
+	if a, b := canonicalReply(opSMembers, []string{"b", "a"}), canonicalReply(opSMembers, []any{"a", "b"}); !bytes.Equal(a, b) {
+		t.Errorf("SMEMBERS replies in another order differ: %q, %q", a, b)
+	}
+	if a, b := canonicalReply(opHGetAll, []any{"f2", "2", "f1", "1"}), canonicalReply(opHGetAll, []any{"f1", "1", "f2", "2"}); !bytes.Equal(a, b) {
+		t.Errorf("HGETALL replies in another order differ: %q, %q", a, b)
+	}
+	if a, b := canonicalReply(opZRange, []string{"b", "a"}), canonicalReply(opZRange, []any{"a", "b"}); bytes.Equal(a, b) {
+		t.Error("ZRANGE replies in another order are equal")
+	}
+	if got := string(canonicalReply(opMGet, []any{"v", nil, int64(1)})); got != "*3\r\n$1\r\nv\r\n$-1\r\n:1\r\n" {
+		t.Errorf("MGET reply %q", got)
+	}
+	diff := diffReplies([]byte("$5\r\nhello\r\n"), []byte("$5\r\nhullo\r\n"))
+	if !strings.Contains(diff, "11 vs 11 bytes, first difference at byte 5") {
+		t.Errorf("diff %q", diff)
+	}
+}
+
+func TestShadowRun(t *testing.T) {
+	primary, shadow := miniredis.RunT(t), miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", primary.Addr(), "-shadow", shadow.Addr(), "-shadow-reads", "1",
+		"-workload", "hash", "-keyspace", "20", "-preload", "20", "-clients", "2", "-ops", "200")
+
+	res, err := runTarget(context.Background(), cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := res.shadow
+	if rep == nil || rep.Writes == 0 || rep.Reads == 0 || rep.Confirmed != 0 || rep.Dropped != 0 || rep.Errors != 0 {
+		t.Fatalf("shadow %+v", rep)
+	}
+	if rep.Writes+rep.Reads != res.total.attempts() {
+		t.Errorf("%d writes and %d reads mirrored of %d operations", rep.Writes, rep.Reads, res.total.attempts())
+	}
+	for _, c := range rep.Commands {
+		if c.Primary == nil || c.Shadow == nil {
+			t.Errorf("%s lacks a latency", c.Command)
+		}
+	}
+	if buildReport(cfg, res).Shadow != rep {
+		t.Error("the JSON report lacks the shadow")
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "(0 still after the run)") {
+		t.Errorf("summary lacks the shadow:\n%s", buf.String())
+	}
+}
+
+func TestShadowMismatch(t *testing.T) {
+	mr, rdb := newTestServer(t)
+	mr.Set("k", "primary")
+	mr.Set("raced", "v")
+	shadow := miniredis.RunT(t)
+	shadow.Set("k", "shadow")
+	shadow.Set("raced", "v")
+	cfg := testConfig(t, "-addr", mr.Addr(), "-shadow", shadow.Addr(), "-clients", "1")
+	m := startShadow(cfg, rdb)
+	for _, key := range []string{"k", "raced"} {
+		primary := rdb.Get(context.Background(), key)
+		primary.SetVal("primary")
+		m.send(shadowOp{op: opGet, key: key, args: []any{"get", key}, primary: canonicalReply(opGet, cmdReply(primary))})
+	}
+	m.taint("tainted")
+	m.send(shadowOp{op: opGet, key: "tainted", args: []any{"get", "tainted"}, primary: canonicalReply(opGet, nil)})
+
+	rep := m.finish(nil)
+
+	if rep.Reads != 2 || rep.Mismatches != 2 || rep.Confirmed != 1 || rep.Skipped != 1 || len(rep.Examples) != 1 || rep.Examples[0].Key != "k" {
+		t.Fatalf("shadow %+v", rep)
+	}
+	if !rep.failed() {
+		t.Error("a mismatch does not fail the run")
+	}
+}
+
+func TestShadowDrops(t *testing.T) {
+	cfg := testConfig(t, "-addr", "127.0.0.1:1", "-shadow", newStalledServer(t), "-clients", "1", "-shadow-queue", "1", "-op-timeout", "50ms")
+	m := startShadow(cfg, nil)
+	for i := 0; i < 5; i++ {
+		m.send(shadowOp{op: opSet, key: "k", args: []any{"set", "k", "v"}})
+	}
+
+	rep := m.finish(nil)
+
+	if rep.Dropped < 3 || rep.Dropped+rep.Errors != 5 || !m.isTainted("k") {
+		t.Errorf("shadow %+v", rep)
+	}
+}
+
+func TestValidateShadow(t *testing.T) {
+	for _, args := range [][]string{
+		{"-shadow-reads", "0.5"},
+		{"-shadow", "localhost:6379"},
+		{"-shadow", "x:1", "-shadow-reads", "2"},
+		{"-shadow", "x:1", "-shadow-queue", "0"},
+		{"-shadow", "x:1", "-client", "raw"},
+		{"-shadow", "x:1", "-workload", "queue"},
+		{"-shadow", "x:1", "-dbs", "0-1", "-clients", "2"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	if _, err := parseFlags([]string{"-shadow", "x:1", "-workload", "sets", "-clients", "2"}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,267 @@
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+const (
+	// defaultSizeBuckets are the upper bounds of the payload size buckets.
+	defaultSizeBuckets = "1KB,16KB,256KB"
+	// maxSizeBuckets bounds -size-buckets so a bucket fits a sizeSample.
+	maxSizeBuckets = 32
+	// sizeFlush is how many samples a worker buffers before it records
+	// them in the histograms of their buckets.
+	sizeFlush = 1024
+)
+
+// validateSizeBuckets checks -size-buckets. Latency is bucketed by payload
+// size when it was set, when value sizes vary and when the workload issues
+// MGET or MSET, whose batches are bucketed by their total size.
+func (c *config) validateSizeBuckets() error {
+	bounds, err := parseSizeBuckets(c.sizeBucketsSpec)
+	if err != nil {
+		return fmt.Errorf("-size-buckets: %w", err)
+	}
+	_, explicit := c.explicit["size-buckets"]
+	ranged := c.valueSizeRange != "" || (c.large != nil && c.large.min < c.large.max)
+	if explicit || ranged || c.usesBatches() {
+		c.sizeBounds = bounds
+	}
+	return nil
+}
+
+// parseSizeBuckets parses increasing sizes such as "1KB,16KB,256KB", in
+// bytes or with a KB, MB or GB suffix of powers of 1024.
+func parseSizeBuckets(s string) ([]int, error) {
+	var bounds []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := parseByteSize(strings.TrimSpace(f))
+		if err != nil {
+			return nil, err
+		}
+		if len(bounds) > 0 && n <= bounds[len(bounds)-1] {
+			return nil, fmt.Errorf("sizes must increase, got %d after %d", n, bounds[len(bounds)-1])
+		}
+		bounds = append(bounds, n)
+	}
+	if len(bounds) > maxSizeBuckets {
+		return nil, fmt.Errorf("at most %d sizes, got %d", maxSizeBuckets, len(bounds))
+	}
+	return bounds, nil
+}
+
+var byteUnits = []struct {
+	suffix string
+	n      int
+}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+func parseByteSize(s string) (int, error) {
+	unit := 1
+	for _, u := range byteUnits {
+		if strings.HasSuffix(strings.ToUpper(s), u.suffix) {
+			s, unit = s[:len(s)-len(u.suffix)], u.n
+			break
+		}
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
+
+// formatByteSize writes n with the largest unit that divides it.
+func formatByteSize(n int) string {
+	for _, u := range byteUnits {
+		if n >= u.n && n%u.n == 0 {
+			return strconv.Itoa(n/u.n) + u.suffix
+		}
+	}
+	return strconv.Itoa(n) + "B"
+}
+
+// sizeSample is one operation of the size buckets: its latency in
+// nanoseconds above its bucket and whether it was an MGET or MSET, so a
+// sample costs a worker one store in a slice allocated up front.
+type sizeSample uint64
+
+const (
+	sizeBucketBits = 8
+	// sizeBatchMGet and sizeBatchMSet mark the bucket of a batch; the
+	// bucket index occupies the bits below them.
+	sizeBatchMGet = 1 << 6
+	sizeBatchMSet = 1 << 7
+)
+
+func packSizeSample(latency time.Duration, bucket int, batch int) sizeSample {
+	return sizeSample(uint64(latency)<<sizeBucketBits | uint64(bucket|batch))
+}
+
+func (s sizeSample) unpack() (time.Duration, int, int) {
+	low := int(s & (1<<sizeBucketBits - 1))
+	return time.Duration(s >> sizeBucketBits), low &^ (sizeBatchMGet | sizeBatchMSet), low & (sizeBatchMGet | sizeBatchMSet)
+}
+
+// sizeStats buckets latency by payload size. Commands go in one set of
+// buckets, MGET and MSET batches in sets of their own.
+type sizeStats struct {
+	bounds  []int
+	layout  stats.Layout
+	pending []sizeSample
+	single  []*stats.Histogram
+	mget    []*stats.Histogram
+	mset    []*stats.Histogram
+}
+
+func newSizeStats(bounds []int, l stats.Layout) *sizeStats {
+	n := len(bounds) + 1
+	return &sizeStats{bounds: bounds, layout: l, pending: make([]sizeSample, 0, sizeFlush),
+		single: make([]*stats.Histogram, n), mget: make([]*stats.Histogram, n), mset: make([]*stats.Histogram, n)}
+}
+
+// sizeStats returns the size buckets of r, allocating them on first use.
+func (r *workerResult) sizeStats(bounds []int) *sizeStats {
+	if r.sizes == nil {
+		r.sizes = newSizeStats(bounds, r.layout)
+	}
+	return r.sizes
+}
+
+// recordSize records an operation of op with a payload of size bytes: the
+// value written or read, or the values of a whole batch. Operations
+// without one, such as a DEL or a GET miss, are left out.
+func (r *workerResult) recordSize(cfg *config, op opType, size int, latency time.Duration) {
+	if size == 0 {
+		return
+	}
+	s := r.sizeStats(cfg.sizeBounds)
+	batch := 0
+	switch op {
+	case opMGet:
+		batch = sizeBatchMGet
+	case opMSet:
+		batch = sizeBatchMSet
+	}
+	s.pending = append(s.pending, packSizeSample(latency, sort.SearchInts(s.bounds, size+1), batch))
+	if len(s.pending) == cap(s.pending) {
+		s.flush()
+	}
+}
+
+// flush records the buffered samples in the histograms of their buckets.
+func (s *sizeStats) flush() {
+	for _, p := range s.pending {
+		latency, bucket, batch := p.unpack()
+		buckets := s.single
+		switch batch {
+		case sizeBatchMGet:
+			buckets = s.mget
+		case sizeBatchMSet:
+			buckets = s.mset
+		}
+		if buckets[bucket] == nil {
+			buckets[bucket] = s.layout.New()
+		}
+		buckets[bucket].Record(latency)
+	}
+	s.pending = s.pending[:0]
+}
+
+func (s *sizeStats) merge(o *sizeStats) {
+	o.flush()
+	for b := range o.single {
+		s.single[b] = mergeHistogram(s.single[b], o.single[b])
+		s.mget[b] = mergeHistogram(s.mget[b], o.mget[b])
+		s.mset[b] = mergeHistogram(s.mset[b], o.mset[b])
+	}
+}
+
+// jsonSizes is the latency by payload size. A bucket holds the payloads
+// of at least MinBytes and less than MaxBytes, 0 for the last.
+type jsonSizes struct {
+	Commands []jsonSizeBucket `json:"commands,omitempty"`
+	MGet     []jsonSizeBucket `json:"mget_batches,omitempty"`
+	MSet     []jsonSizeBucket `json:"mset_batches,omitempty"`
+}
+
+type jsonSizeBucket struct {
+	Bucket   string `json:"bucket"`
+	MinBytes int    `json:"min_bytes"`
+	MaxBytes int    `json:"max_bytes,omitempty"`
+	Count    int64  `json:"count"`
+	MeanNs   int64  `json:"mean_ns"`
+	P99Ns    int64  `json:"p99_ns"`
+}
+
+// sizeBucketLabel names bucket b of bounds, such as "1KB-16KB".
+func sizeBucketLabel(bounds []int, b int) string {
+	switch {
+	case b == 0:
+		return "<" + formatByteSize(bounds[0])
+	case b == len(bounds):
+		return ">=" + formatByteSize(bounds[b-1])
+	}
+	return formatByteSize(bounds[b-1]) + "-" + formatByteSize(bounds[b])
+}
+
+func buildSizes(total *workerResult) *jsonSizes {
+	s := total.sizes
+	if s == nil {
+		return nil
+	}
+	s.flush()
+	rows := func(buckets []*stats.Histogram) []jsonSizeBucket {
+		var rows []jsonSizeBucket
+		for b, h := range buckets {
+			if h == nil || h.Count() == 0 {
+				continue
+			}
+			row := jsonSizeBucket{Bucket: sizeBucketLabel(s.bounds, b), Count: h.Count(),
+				MeanNs: int64(h.Mean()), P99Ns: int64(h.Percentile(99))}
+			if b > 0 {
+				row.MinBytes = s.bounds[b-1]
+			}
+			if b < len(s.bounds) {
+				row.MaxBytes = s.bounds[b]
+			}
+			rows = append(rows, row)
+		}
+		return rows
+	}
+	return &jsonSizes{Commands: rows(s.single), MGet: rows(s.mget), MSet: rows(s.mset)}
+}
+
+// printSizes writes the tables of latency by payload size.
+func printSizes(w io.Writer, total *workerResult) {
+	j := buildSizes(total)
+	if j == nil {
+		return
+	}
+	for _, t := range []struct {
+		title string
+		rows  []jsonSizeBucket
+	}{
+		{"Latency by value size", j.Commands},
+		{"MGET latency by batch size", j.MGet},
+		{"MSET latency by batch size", j.MSet},
+	} {
+		if len(t.rows) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s:\n", t.title)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "size\tops\tmean\tp99\t")
+		for _, r := range t.rows {
+			fmt.Fprintf(tw, "%s\t%d\t%v\t%v\t\n", r.Bucket, r.Count, time.Duration(r.MeanNs), time.Duration(r.P99Ns))
+		}
+		tw.Flush()
+	}
+}
@@ -0,0 +1,97 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestParseSizeBuckets(t *testing.T) {
+	bounds, err := parseSizeBuckets(defaultSizeBuckets)
+	if err != nil || len(bounds) != 3 || bounds[0] != 1024 || bounds[2] != 256<<10 {
+		t.Fatalf("bounds %v, %v", bounds, err)
+	}
+	for b, want := range []string{"<1KB", "1KB-16KB", "16KB-256KB", ">=256KB"} {
+		if got := sizeBucketLabel(bounds, b); got != want {
+			t.Errorf("bucket %d is %q, want %q", b, got, want)
+		}
+	}
+	if got, _ := parseSizeBuckets("100, 2mb"); len(got) != 2 || got[0] != 100 || got[1] != 2<<20 {
+		t.Errorf("bounds %v", got)
+	}
+	for _, bad := range []string{"", "1KB,1KB", "16KB,1KB", "x", "0", "-1KB"} {
+		if _, err := parseSizeBuckets(bad); err == nil {
+			t.Errorf("%q was parsed", bad)
+		}
+	}
+	if got := formatByteSize(1536); got != "1536B" {
+		t.Errorf("1536 bytes formatted %q", got)
+	}
+}
+
+func TestSizeSample(t *testing.T) {
+	for _, batch := range []int{0, sizeBatchMGet, sizeBatchMSet} {
+		s := packSizeSample(90*time.Minute, maxSizeBuckets, batch)
+		if latency, bucket, got := s.unpack(); latency != 90*time.Minute || bucket != maxSizeBuckets || got != batch {
+			t.Errorf("unpacked %v, %d, %d", latency, bucket, got)
+		}
+	}
+}
+
+func TestSizeBuckets(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-value-size-range", "10:4000", "-size-buckets", "1KB,2KB",
+		"-clients", "2", "-ops", "1500")
+
+	res, err := runTarget(context.Background(), cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := buildSizes(res.total)
+	if j == nil || len(j.Commands) != 3 || j.MGet != nil {
+		t.Fatalf("sizes %+v", j)
+	}
+	var n int64
+	for _, r := range j.Commands {
+		n += r.Count
+	}
+	if n != res.total.attempts() {
+		t.Errorf("%d operations bucketed of %d", n, res.total.attempts())
+	}
+	if r := j.Commands[1]; r.Bucket != "1KB-2KB" || r.MinBytes != 1024 || r.MaxBytes != 2048 || r.P99Ns == 0 {
+		t.Errorf("bucket %+v", r)
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "Latency by value size:") || !strings.Contains(buf.String(), ">=2KB") {
+		t.Errorf("summary lacks the size table:\n%s", buf.String())
+	}
+	if buildReport(cfg, res).LatencyBySize == nil {
+		t.Error("the JSON report lacks the size buckets")
+	}
+
+	if cfg := testConfig(t, "-clients", "2"); cfg.sizeBounds != nil {
+		t.Error("fixed-size values are bucketed by size")
+	}
+}
+
+func TestMGetSizeBuckets(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-workload", "mget", "-preload", "20", "-keyspace", "20",
+		"-value-size", "300", "-batch-keys", "5", "-clients", "2", "-ops", "50")
+
+	res, err := runTarget(context.Background(), cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := buildSizes(res.total)
+	if j == nil || len(j.MGet) != 1 || j.MGet[0].Bucket != "1KB-16KB" || j.MGet[0].Count != res.total.ops[opMGet].latency.Count() {
+		t.Fatalf("sizes %+v", j)
+	}
+}
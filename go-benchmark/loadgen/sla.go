@@ -0,0 +1,352 @@
+package loadgen
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exit statuses of a run that failed an -sla condition, one per category.
+// When conditions of several categories fail, the status is that of the
+// first failed condition in -sla order.
+const (
+	exitSLALatency    = 5
+	exitSLAErrors     = 6
+	exitSLAThroughput = 7
+)
+
+// slaCategory groups the SLA metrics by what they measure.
+type slaCategory int
+
+const (
+	slaLatency slaCategory = iota
+	slaErrors
+	slaThroughput
+)
+
+func (c slaCategory) exitCode() int {
+	switch c {
+	case slaLatency:
+		return exitSLALatency
+	case slaErrors:
+		return exitSLAErrors
+	default:
+		return exitSLAThroughput
+	}
+}
+
+// slaCondition is one comparison of -sla, such as p99<2ms. The threshold is
+// in nanoseconds for latency, a fraction of the operations for errors and
+// operations per second for throughput.
+type slaCondition struct {
+	text     string
+	metric   string
+	category slaCategory
+	// percentile is the percentile of a pNN metric.
+	percentile float64
+	op         string
+	threshold  float64
+}
+
+// parseSLA parses a comma-separated list of conditions. A condition compares
+// a metric with <, <=, > or >= to a threshold:
+//
+//	p50, p99, p99.9, any pNN, mean, max   a duration with its unit: 500us, 2ms, 1s
+//	errors                                a percentage, 0.1%, or a fraction, 0.001
+//	throughput                            operations per second, 30000
+func parseSLA(spec string) ([]slaCondition, error) {
+	var conds []slaCondition
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return nil, fmt.Errorf("empty condition in %q", spec)
+		}
+		c, err := parseSLACondition(part)
+		if err != nil {
+			return nil, fmt.Errorf("condition %q: %w", part, err)
+		}
+		conds = append(conds, c)
+	}
+	return conds, nil
+}
+
+func parseSLACondition(s string) (slaCondition, error) {
+	c := slaCondition{text: s}
+	i := strings.IndexAny(s, "<>")
+	if i < 0 {
+		return c, errors.New("no comparison: want <, <=, > or >=")
+	}
+	c.op = s[i : i+1]
+	value := s[i+1:]
+	if strings.HasPrefix(value, "=") {
+		c.op += "="
+		value = value[1:]
+	}
+	c.metric = strings.ToLower(strings.TrimSpace(s[:i]))
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return c, errors.New("no threshold")
+	}
+	if strings.ContainsAny(value, "<>=") {
+		return c, errors.New("more than one comparison")
+	}
+	switch {
+	case c.metric == "mean" || c.metric == "max":
+		c.category = slaLatency
+	case strings.HasPrefix(c.metric, "p"):
+		p, err := strconv.ParseFloat(c.metric[1:], 64)
+		if err != nil || p <= 0 || p > 100 {
+			return c, fmt.Errorf("unknown metric %q: want pNN with 0 < NN <= 100, mean, max, errors or throughput", c.metric)
+		}
+		c.category, c.percentile = slaLatency, p
+	case c.metric == "errors":
+		c.category = slaErrors
+	case c.metric == "throughput":
+		c.category = slaThroughput
+	default:
+		return c, fmt.Errorf("unknown metric %q: want pNN, mean, max, errors or throughput", c.metric)
+	}
+
+	switch c.category {
+	case slaLatency:
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return c, fmt.Errorf("latency %q needs a unit, such as %sms", value, value)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return c, fmt.Errorf("invalid latency %q: want a duration such as 500us, 2ms or 1s", value)
+		}
+		c.threshold = float64(d)
+	case slaErrors:
+		pct := strings.HasSuffix(value, "%")
+		f, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil {
+			return c, fmt.Errorf("invalid error rate %q: want a percentage such as 0.1%% or a fraction such as 0.001", value)
+		}
+		if pct {
+			f /= 100
+		}
+		if f > 1 {
+			return c, fmt.Errorf("error rate %q exceeds 100%%", value)
+		}
+		c.threshold = f
+	case slaThroughput:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return c, fmt.Errorf("invalid throughput %q: want operations per second such as 30000", value)
+		}
+		c.threshold = f
+	}
+	if c.threshold < 0 || math.IsNaN(c.threshold) || math.IsInf(c.threshold, 0) {
+		return c, fmt.Errorf("threshold %q must be a non-negative number", value)
+	}
+	return c, nil
+}
+
+// validateSLA parses -sla.
+func (c *config) validateSLA() error {
+	if c.slaSpec == "" {
+		return nil
+	}
+	switch {
+	case c.addr2 != "", c.sweeping(), c.scenario != "":
+		return errors.New("-sla applies to a single run, not to -addr2, sweeps or -scenario")
+	}
+	conds, err := parseSLA(c.slaSpec)
+	if err != nil {
+		return fmt.Errorf("-sla: %w", err)
+	}
+	c.sla = conds
+	return nil
+}
+
+// slaCheck is the outcome of one condition.
+type slaCheck struct {
+	cond slaCondition
+	// actual is the measured value in the unit of the threshold; measured
+	// is false when there was nothing to measure, a latency condition of a
+	// run without successful operations, which fails.
+	actual   float64
+	measured bool
+	passed   bool
+}
+
+// evaluateSLA checks every -sla condition against the results. Latency
+// conditions use the response time of paced runs, which includes the time
+// behind a slow server, and the service time otherwise.
+func evaluateSLA(cfg *config, res *runResult) []slaCheck {
+	total := res.total
+	h := total.latency
+	if total.response != nil {
+		h = total.response
+	}
+	checks := make([]slaCheck, len(cfg.sla))
+	for i, c := range cfg.sla {
+		chk := slaCheck{cond: c, measured: true}
+		switch {
+		case c.category == slaErrors:
+			if n := total.attempts(); n > 0 {
+				chk.actual = float64(total.errors()) / float64(n)
+			}
+		case c.category == slaThroughput:
+			chk.actual = throughput(res)
+		case h.Count() == 0:
+			chk.measured = false
+		case c.metric == "mean":
+			chk.actual = float64(h.Mean())
+		case c.metric == "max":
+			chk.actual = float64(h.Maximum())
+		default:
+			chk.actual = float64(h.Percentile(c.percentile))
+		}
+		if chk.measured {
+			chk.passed = c.holds(chk.actual)
+		}
+		checks[i] = chk
+	}
+	return checks
+}
+
+func (c slaCondition) holds(v float64) bool {
+	switch c.op {
+	case "<":
+		return v < c.threshold
+	case "<=":
+		return v <= c.threshold
+	case ">":
+		return v > c.threshold
+	default:
+		return v >= c.threshold
+	}
+}
+
+// format renders v in the unit of the condition's metric.
+func (c slaCondition) format(v float64) string {
+	switch c.category {
+	case slaLatency:
+		return time.Duration(v).String()
+	case slaErrors:
+		return strconv.FormatFloat(100*v, 'g', 4, 64) + "%"
+	default:
+		return fmt.Sprintf("%.0f ops/s", v)
+	}
+}
+
+// describe explains the outcome of the check, with by how much a failed
+// condition was missed.
+func (chk slaCheck) describe() string {
+	c := chk.cond
+	if !chk.measured {
+		return fmt.Sprintf("%s: FAILED, no successful operations to measure", c.text)
+	}
+	if chk.passed {
+		return fmt.Sprintf("%s: ok, %s %s", c.text, c.metric, c.format(chk.actual))
+	}
+	miss := math.Abs(chk.actual - c.threshold)
+	side := "over"
+	if strings.HasPrefix(c.op, ">") {
+		side = "under"
+	}
+	s := fmt.Sprintf("%s: FAILED, %s %s, %s %s", c.text, c.metric, c.format(chk.actual), c.format(miss), side)
+	if c.threshold > 0 {
+		s += fmt.Sprintf(" (%.1f%%)", 100*miss/c.threshold)
+	}
+	return s
+}
+
+// slaExitCode returns the exit status of the first failed check, 0 when all
+// passed.
+func slaExitCode(checks []slaCheck) int {
+	for _, chk := range checks {
+		if !chk.passed {
+			return chk.cond.category.exitCode()
+		}
+	}
+	return 0
+}
+
+// checkSLA prints the failed -sla conditions to stderr, whatever the report
+// format, and returns the exit status they call for.
+func checkSLA(cfg *config, res *runResult) int {
+	if len(cfg.sla) == 0 {
+		return 0
+	}
+	checks := evaluateSLA(cfg, res)
+	for _, chk := range checks {
+		if !chk.passed {
+			cfg.logger.Error("SLA VIOLATION: " + chk.describe())
+		}
+	}
+	return slaExitCode(checks)
+}
+
+// jsonSLACheck is one condition of the -sla section of the JSON report.
+type jsonSLACheck struct {
+	Condition string `json:"condition"`
+	Category  string `json:"category"`
+	// Actual is the measured value in the unit of Threshold: nanoseconds,
+	// a fraction of the operations or operations per second. It is null
+	// for a latency condition with nothing to measure.
+	Actual    *float64 `json:"actual"`
+	Threshold float64  `json:"threshold"`
+	Passed    bool     `json:"passed"`
+	Detail    string   `json:"detail"`
+}
+
+// jsonSLA is the -sla section of the JSON report.
+type jsonSLA struct {
+	Passed     bool           `json:"passed"`
+	ExitCode   int            `json:"exit_code"`
+	Checks     []jsonSLACheck `json:"checks"`
+	Violations []string       `json:"violations"`
+}
+
+var slaCategoryNames = [...]string{slaLatency: "latency", slaErrors: "errors", slaThroughput: "throughput"}
+
+// buildSLA returns the -sla section, nil when not requested.
+func buildSLA(cfg *config, res *runResult) *jsonSLA {
+	if len(cfg.sla) == 0 {
+		return nil
+	}
+	checks := evaluateSLA(cfg, res)
+	rep := &jsonSLA{ExitCode: slaExitCode(checks), Violations: []string{}}
+	rep.Passed = rep.ExitCode == 0
+	for _, chk := range checks {
+		j := jsonSLACheck{
+			Condition: chk.cond.text,
+			Category:  slaCategoryNames[chk.cond.category],
+			Threshold: chk.cond.threshold,
+			Passed:    chk.passed,
+			Detail:    chk.describe(),
+		}
+		if chk.measured {
+			v := chk.actual
+			j.Actual = &v
+		}
+		rep.Checks = append(rep.Checks, j)
+		if !chk.passed {
+			rep.Violations = append(rep.Violations, j.Detail)
+		}
+	}
+	return rep
+}
+
+// printSLA writes the -sla section of the summary.
+func printSLA(w io.Writer, cfg *config, res *runResult) {
+	if len(cfg.sla) == 0 {
+		return
+	}
+	checks := evaluateSLA(cfg, res)
+	if code := slaExitCode(checks); code != 0 {
+		fmt.Fprintf(w, "SLA: FAILED (exit status %d)\n", code)
+	} else {
+		fmt.Fprintln(w, "SLA: met")
+	}
+	for _, chk := range checks {
+		fmt.Fprintln(w, "  "+chk.describe())
+	}
+}
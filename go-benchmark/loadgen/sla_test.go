@@ -0,0 +1,150 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+func TestParseSLA(t *testing.T) {
+	conds, err := parseSLA("p99<2ms, errors<0.1%,throughput>30000,p99.9<=500us,mean<1s,max<=1.5s,errors<0.002,p50>=10µs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		metric    string
+		category  slaCategory
+		op        string
+		threshold float64
+	}{
+		{"p99", slaLatency, "<", float64(2 * time.Millisecond)},
+		{"errors", slaErrors, "<", 0.001},
+		{"throughput", slaThroughput, ">", 30000},
+		{"p99.9", slaLatency, "<=", float64(500 * time.Microsecond)},
+		{"mean", slaLatency, "<", float64(time.Second)},
+		{"max", slaLatency, "<=", float64(1500 * time.Millisecond)},
+		{"errors", slaErrors, "<", 0.002},
+		{"p50", slaLatency, ">=", float64(10 * time.Microsecond)},
+	}
+	if len(conds) != len(want) {
+		t.Fatalf("%d conditions, want %d", len(conds), len(want))
+	}
+	for i, w := range want {
+		c := conds[i]
+		if c.metric != w.metric || c.category != w.category || c.op != w.op || c.threshold != w.threshold {
+			t.Errorf("condition %d: %+v, want %+v", i, c, w)
+		}
+	}
+	if conds[0].percentile != 99 || conds[3].percentile != 99.9 {
+		t.Errorf("percentiles %v and %v", conds[0].percentile, conds[3].percentile)
+	}
+
+	for _, spec := range []string{
+		"",
+		"p99<2ms,",
+		"p99",
+		"p99<",
+		"p99<2",
+		"p99<2 parsecs",
+		"p99<2%",
+		"p0<1ms",
+		"p101<1ms",
+		"pxx<1ms",
+		"latency<1ms",
+		"errors<five",
+		"errors<150%",
+		"errors<-1%",
+		"throughput>30k",
+		"throughput>-5",
+		"p99<<2ms",
+		"p99<2ms>1ms",
+	} {
+		if _, err := parseSLA(spec); err == nil {
+			t.Errorf("%q was accepted", spec)
+		}
+	}
+}
+
+func TestEvaluateSLA(t *testing.T) {
+	total := newWorkerResult(stats.DefaultLayout)
+	for i := 0; i < 99; i++ {
+		total.latency.Record(time.Millisecond)
+	}
+	total.latency.Record(10 * time.Millisecond)
+	total.ops[opSet].errors = 1
+	start := time.Now()
+	res := &runResult{total: total, start: start, end: start.Add(time.Second)}
+	cfg := &config{}
+	var err error
+	if cfg.sla, err = parseSLA("throughput>50,p99<2ms,errors<0.5%,max<5ms,throughput>200"); err != nil {
+		t.Fatal(err)
+	}
+	checks := evaluateSLA(cfg, res)
+	passed := []bool{true, true, false, false, false}
+	for i, chk := range checks {
+		if chk.passed != passed[i] {
+			t.Errorf("%s passed %v with %v", chk.cond.text, chk.passed, chk.actual)
+		}
+	}
+	// The first failure is the error rate.
+	if code := slaExitCode(checks); code != exitSLAErrors {
+		t.Errorf("exit status %d, want %d", code, exitSLAErrors)
+	}
+	if d := checks[2].describe(); !strings.Contains(d, "errors 0.9901%") || !strings.Contains(d, "over") {
+		t.Errorf("error rate described as %q", d)
+	}
+	if d := checks[4].describe(); !strings.Contains(d, "throughput 100 ops/s, 100 ops/s under (50.0%)") {
+		t.Errorf("throughput described as %q", d)
+	}
+
+	// Latency conditions of a run without successes fail.
+	cfg.sla, _ = parseSLA("p99<1ms")
+	empty := &runResult{total: newWorkerResult(stats.DefaultLayout), start: start, end: start.Add(time.Second)}
+	if checks := evaluateSLA(cfg, empty); checks[0].passed || slaExitCode(checks) != exitSLALatency {
+		t.Errorf("an empty run met %+v", checks[0])
+	}
+}
+
+func TestSLAReport(t *testing.T) {
+	addr := newTestServerAddr(t)
+	cfg := testConfig(t, "-addr", addr, "-clients", "2", "-ops", "50", "-sla", "p99<10s,errors<1%,throughput>1e9")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := checkSLA(cfg, res); code != exitSLAThroughput {
+		t.Errorf("exit status %d, want %d", code, exitSLAThroughput)
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	for _, want := range []string{"SLA: FAILED (exit status 7)", "p99<10s: ok", "throughput>1e9: FAILED"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, buf.String())
+		}
+	}
+	var rep struct {
+		SLA *jsonSLA `json:"sla"`
+	}
+	buf.Reset()
+	if err := writeJSON(&buf, buildReport(cfg, res)); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil || rep.SLA == nil || rep.SLA.Passed || len(rep.SLA.Checks) != 3 ||
+		len(rep.SLA.Violations) != 1 || rep.SLA.Checks[2].Category != "throughput" || rep.SLA.ExitCode != exitSLAThroughput {
+		t.Errorf("JSON sla section %+v, %v", rep.SLA, err)
+	}
+
+	for _, args := range [][]string{
+		{"-sla", "p99<2"},
+		{"-sla", "p99<2ms", "-addr2", "localhost:6380"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	// slowlogFetch is how many entries SLOWLOG GET asks each node for, far
+	// beyond the default slowlog-max-len of 128.
+	slowlogFetch = 1024
+	// slowlogArgLen and slowlogArgs bound the arguments kept of an entry.
+	slowlogArgLen = 64
+	slowlogArgs   = 8
+)
+
+// slowlogCollector resets the slowlog of every node when the measured window
+// starts and reads it back after the run.
+type slowlogCollector struct {
+	rdb redis.UniversalClient
+	// mu guards unavailable, set by the reset of the timer that starts the
+	// measured window.
+	mu          sync.Mutex
+	unavailable string
+}
+
+func newSlowlogCollector(rdb redis.UniversalClient) *slowlogCollector {
+	return &slowlogCollector{rdb: rdb}
+}
+
+// reset clears the slowlog of every node. A server without SLOWLOG leaves a
+// collector that only reports why.
+func (s *slowlogCollector) reset() {
+	err := forEachNode(context.Background(), s.rdb, func(ctx context.Context, c *redis.Client) error {
+		return c.Do(ctx, "SLOWLOG", "RESET").Err()
+	})
+	if err != nil {
+		s.mu.Lock()
+		s.unavailable = fmt.Sprintf("SLOWLOG RESET: %v", err)
+		s.mu.Unlock()
+	}
+}
+
+// slowlogEntry is one parsed entry of SLOWLOG GET.
+type slowlogEntry struct {
+	node     string
+	id       int64
+	at       time.Time
+	duration time.Duration
+	args     []string
+	// client and clientName are only in the extended format of Redis 4.0
+	// and later.
+	client     string
+	clientName string
+}
+
+// parseSlowlog parses the reply of SLOWLOG GET, whose entries are the id,
+// the unix time, the duration in microseconds and the arguments, followed
+// in the extended format by the client address and name.
+func parseSlowlog(reply any, node string) ([]slowlogEntry, error) {
+	items, ok := reply.([]any)
+	if !ok {
+		return nil, fmt.Errorf("SLOWLOG GET replied %T, not an array", reply)
+	}
+	entries := make([]slowlogEntry, 0, len(items))
+	for i, item := range items {
+		fields, ok := item.([]any)
+		if !ok || len(fields) < 4 {
+			return nil, fmt.Errorf("slowlog entry %d: want at least 4 fields", i)
+		}
+		id, ok1 := fields[0].(int64)
+		unix, ok2 := fields[1].(int64)
+		micros, ok3 := fields[2].(int64)
+		args, ok4 := fields[3].([]any)
+		if !ok1 || !ok2 || !ok3 || !ok4 {
+			return nil, fmt.Errorf("slowlog entry %d: malformed fields", i)
+		}
+		e := slowlogEntry{node: node, id: id, at: time.Unix(unix, 0), duration: time.Duration(micros) * time.Microsecond}
+		for _, a := range args {
+			if len(e.args) == slowlogArgs {
+				break
+			}
+			s := fmt.Sprint(a)
+			if len(s) > slowlogArgLen {
+				s = s[:slowlogArgLen] + "..."
+			}
+			e.args = append(e.args, s)
+		}
+		if len(fields) >= 6 {
+			e.client, _ = fields[4].(string)
+			e.clientName, _ = fields[5].(string)
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// collect reads the slowlog of every node, oldest entry first.
+func (s *slowlogCollector) collect(ctx context.Context) ([]slowlogEntry, string) {
+	s.mu.Lock()
+	unavailable := s.unavailable
+	s.mu.Unlock()
+	if unavailable != "" {
+		return nil, unavailable
+	}
+	var mu sync.Mutex
+	var entries []slowlogEntry
+	err := forEachNode(ctx, s.rdb, func(ctx context.Context, c *redis.Client) error {
+		reply, err := c.Do(ctx, "SLOWLOG", "GET", slowlogFetch).Result()
+		if err != nil {
+			return err
+		}
+		node := ""
+		if _, ok := s.rdb.(*redis.ClusterClient); ok {
+			node = c.Options().Addr
+		}
+		parsed, err := parseSlowlog(reply, node)
+		mu.Lock()
+		entries = append(entries, parsed...)
+		mu.Unlock()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Sprintf("SLOWLOG GET: %v", err)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].at.Before(entries[j].at) })
+	return entries, ""
+}
+
+// jsonSlowlog is the -collect-slowlog section of the JSON report.
+type jsonSlowlog struct {
+	Unavailable string             `json:"unavailable,omitempty"`
+	Entries     []jsonSlowlogEntry `json:"entries"`
+}
+
+// jsonSlowlogEntry is one slowlog entry. With -capture-outliers, Outliers
+// counts the outliers kept whose operation overlapped the second the entry
+// was logged in, of which SameCommand ran its command.
+type jsonSlowlogEntry struct {
+	Node        string    `json:"node,omitempty"`
+	ID          int64     `json:"id"`
+	At          time.Time `json:"at"`
+	DurationUs  int64     `json:"duration_us"`
+	Args        []string  `json:"args"`
+	Client      string    `json:"client,omitempty"`
+	ClientName  string    `json:"client_name,omitempty"`
+	Outliers    int       `json:"outliers,omitempty"`
+	SameCommand int       `json:"same_command_outliers,omitempty"`
+}
+
+// buildSlowlog correlates the slowlog entries with the outliers kept. The
+// server logs entries to the second, so an outlier matches an entry when
+// its operation overlaps that second extended by the entry's duration.
+func buildSlowlog(entries []slowlogEntry, unavailable string, outliers *outlierSet) *jsonSlowlog {
+	rep := &jsonSlowlog{Unavailable: unavailable, Entries: []jsonSlowlogEntry{}}
+	var kept []outlier
+	if outliers != nil {
+		kept = outliers.kept
+	}
+	for _, e := range entries {
+		j := jsonSlowlogEntry{Node: e.node, ID: e.id, At: e.at, DurationUs: e.duration.Microseconds(), Args: e.args,
+			Client: e.client, ClientName: e.clientName}
+		end := e.at.Add(time.Second + e.duration)
+		for _, o := range kept {
+			if o.at.Before(end) && o.at.Add(o.latency).After(e.at) {
+				j.Outliers++
+				if len(e.args) > 0 && strings.EqualFold(e.args[0], o.op.String()) {
+					j.SameCommand++
+				}
+			}
+		}
+		rep.Entries = append(rep.Entries, j)
+	}
+	return rep
+}
+
+// printSlowlog writes the -collect-slowlog section of the summary, listing
+// the slowest entries.
+func printSlowlog(w io.Writer, cfg *config, rep *jsonSlowlog) {
+	if rep.Unavailable != "" {
+		fmt.Fprintf(w, "Slowlog: unavailable (%s)\n", rep.Unavailable)
+		return
+	}
+	fmt.Fprintf(w, "Slowlog: %d entries during the measured window", len(rep.Entries))
+	if len(rep.Entries) == 0 {
+		fmt.Fprintln(w)
+		return
+	}
+	slowest := append([]jsonSlowlogEntry(nil), rep.Entries...)
+	sort.SliceStable(slowest, func(i, j int) bool { return slowest[i].DurationUs > slowest[j].DurationUs })
+	if len(slowest) > outliersPrinted {
+		fmt.Fprintf(w, ", the %d slowest listed", outliersPrinted)
+		slowest = slowest[:outliersPrinted]
+	}
+	fmt.Fprintln(w)
+	for _, e := range slowest {
+		fmt.Fprintf(w, "  %s %12v  %s", e.At.Format(time.RFC3339), time.Duration(e.DurationUs)*time.Microsecond, strings.Join(e.Args, " "))
+		if e.Node != "" {
+			fmt.Fprintf(w, " on %s", e.Node)
+		}
+		if cfg.captureOutliers > 0 {
+			fmt.Fprintf(w, "  [%d outliers, %d of the same command]", e.Outliers, e.SameCommand)
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,84 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSlowlog(t *testing.T) {
+	long := strings.Repeat("v", 100)
+	reply := []any{
+		// The extended format of Redis 4.0 and later.
+		[]any{int64(7), int64(1700000000), int64(15000), []any{"SET", "k1", long}, "127.0.0.1:5000", "bench"},
+		// The older format, without the client.
+		[]any{int64(6), int64(1700000001), int64(2500), []any{"GET", "k2", "a", "b", "c", "d", "e", "f", "g"}},
+	}
+	entries, err := parseSlowlog(reply, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d entries", len(entries))
+	}
+	e := entries[0]
+	if e.id != 7 || !e.at.Equal(time.Unix(1700000000, 0)) || e.duration != 15*time.Millisecond || e.client != "127.0.0.1:5000" || e.clientName != "bench" {
+		t.Errorf("extended entry %+v", e)
+	}
+	if len(e.args) != 3 || e.args[2] != long[:slowlogArgLen]+"..." {
+		t.Errorf("arguments %q", e.args)
+	}
+	if e := entries[1]; e.id != 6 || e.client != "" || len(e.args) != slowlogArgs {
+		t.Errorf("old entry %+v", e)
+	}
+	for _, bad := range []any{"x", []any{[]any{int64(1), int64(2)}}, []any{[]any{"1", int64(2), int64(3), []any{}}}} {
+		if _, err := parseSlowlog(bad, ""); err == nil {
+			t.Errorf("%v was parsed", bad)
+		}
+	}
+}
+
+func TestSlowlogOutliers(t *testing.T) {
+	at := time.Unix(1700000000, 0)
+	entries := []slowlogEntry{{id: 1, at: at, duration: 20 * time.Millisecond, args: []string{"set", "k"}}}
+	s := newOutlierSet(10)
+	for _, o := range []outlier{
+		{slowOp: slowOp{latency: 30 * time.Millisecond, at: at.Add(500 * time.Millisecond), op: opSet}},
+		{slowOp: slowOp{latency: 30 * time.Millisecond, at: at.Add(-10 * time.Millisecond), op: opGet}},
+		{slowOp: slowOp{latency: 30 * time.Millisecond, at: at.Add(2 * time.Second), op: opSet}},
+	} {
+		s.offer(o)
+	}
+	rep := buildSlowlog(entries, "", s)
+	if e := rep.Entries[0]; e.Outliers != 2 || e.SameCommand != 1 || e.DurationUs != 20000 {
+		t.Errorf("entry %+v", e)
+	}
+	cfg := testConfig(t, "-capture-outliers", "10ms")
+	var buf bytes.Buffer
+	printSlowlog(&buf, cfg, rep)
+	if !strings.Contains(buf.String(), "Slowlog: 1 entries") || !strings.Contains(buf.String(), "[2 outliers, 1 of the same command]") {
+		t.Errorf("summary:\n%s", buf.String())
+	}
+}
+
+func TestSlowlogUnavailable(t *testing.T) {
+	mr, _ := newTestServer(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-ops", "5", "-collect-slowlog")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.slowlog == nil || !strings.Contains(res.slowlog.Unavailable, "SLOWLOG RESET") {
+		t.Fatalf("slowlog %+v", res.slowlog)
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "Slowlog: unavailable") {
+		t.Errorf("summary lacks the slowlog note:\n%s", buf.String())
+	}
+	if rep := buildReport(cfg, res); rep.Slowlog == nil {
+		t.Error("the JSON report lacks the slowlog")
+	}
+}
@@ -0,0 +1,238 @@
+package loadgen
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// soakSegments is how many segments the time series of a soak is cut into
+// to tell growth from noise: a resource grows monotonically when the
+// minimum of every segment is at least that of the one before, which a
+// sawtooth such as the heap between collections does not defeat.
+const soakSegments = 10
+
+// soakFlags are the flags that only apply with -soak.
+var soakFlags = []string{"soak-log-interval", "soak-max-goroutines", "soak-max-heap", "soak-max-fds", "soak-max-gc"}
+
+// validateSoak checks -soak and its bounds.
+func (c *config) validateSoak() error {
+	if !c.soak {
+		for _, name := range soakFlags {
+			if _, ok := c.explicit[name]; ok {
+				return fmt.Errorf("-%s requires -soak", name)
+			}
+		}
+		return nil
+	}
+	switch {
+	case c.soakLogInterval <= 0:
+		return fmt.Errorf("-soak-log-interval must be positive, got %v", c.soakLogInterval)
+	case c.soakGoroutines < 0 || c.soakHeap < 0 || c.soakFDs < 0:
+		return errors.New("-soak-max-goroutines, -soak-max-heap and -soak-max-fds must not be negative")
+	case c.soakGC <= 0 || c.soakGC > 1:
+		return fmt.Errorf("-soak-max-gc must be in (0, 1], got %v", c.soakGC)
+	}
+	return nil
+}
+
+// soakMonitor watches the resources of the tool itself during a soak: the
+// sampler of the time series records them in every point, and the monitor
+// logs the pool statistics of the client every -soak-log-interval.
+type soakMonitor struct {
+	rdb    redis.UniversalClient
+	logger *slog.Logger
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func startSoakMonitor(rdb redis.UniversalClient, logger *slog.Logger, interval time.Duration) *soakMonitor {
+	m := &soakMonitor{rdb: rdb, logger: logger, stop: make(chan struct{}), done: make(chan struct{})}
+	go m.run(interval)
+	return m
+}
+
+func (m *soakMonitor) run(interval time.Duration) {
+	defer close(m.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-t.C:
+		}
+		s := m.rdb.PoolStats()
+		m.logger.Info("client pool", "connections", s.TotalConns, "idle", s.IdleConns, "hits", s.Hits,
+			"misses", s.Misses, "timeouts", s.Timeouts, "stale", s.StaleConns, "goroutines", runtime.NumGoroutine())
+	}
+}
+
+func (m *soakMonitor) finish() {
+	close(m.stop)
+	<-m.done
+}
+
+// sample records the resources of the process in p.
+func (m *soakMonitor) sample(p *timePoint) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	p.Goroutines = runtime.NumGoroutine()
+	p.HeapInuse = ms.HeapInuse
+	p.GCPauseNs = ms.PauseTotalNs
+	p.OpenFDs = countOpenFDs()
+	p.PoolConns = m.rdb.PoolStats().TotalConns
+}
+
+// countOpenFDs returns the number of file descriptors the process has
+// open, 0 where /proc/self/fd does not list them.
+func countOpenFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0
+	}
+	// Reading the directory takes a descriptor of its own.
+	return len(entries) - 1
+}
+
+// clientHealth tells whether the tool itself stayed sound during a soak:
+// how its resources evolved over the measured window and the warnings of
+// those that grew monotonically beyond their bounds.
+type clientHealth struct {
+	Sound      bool          `json:"sound"`
+	Samples    int           `json:"samples"`
+	Goroutines healthMetric  `json:"goroutines"`
+	HeapInuse  healthMetric  `json:"heap_inuse_bytes"`
+	OpenFDs    *healthMetric `json:"open_fds,omitempty"`
+	PoolConns  healthMetric  `json:"pool_connections"`
+	// GCPauseNs is the time the collector stopped the world during the
+	// window, and GCFraction its share of the window.
+	GCPauseNs  uint64   `json:"gc_pause_ns"`
+	GCFraction float64  `json:"gc_fraction"`
+	Warnings   []string `json:"warnings,omitempty"`
+}
+
+type healthMetric struct {
+	First   int64 `json:"first"`
+	Last    int64 `json:"last"`
+	Peak    int64 `json:"peak"`
+	Growing bool  `json:"growing"`
+}
+
+func newHealthMetric(values []int64, bound int64) healthMetric {
+	m := healthMetric{First: values[0], Last: values[len(values)-1]}
+	for _, v := range values {
+		m.Peak = max(m.Peak, v)
+	}
+	m.Growing = growsMonotonically(values, bound)
+	return m
+}
+
+// growsMonotonically reports whether the minima of the soakSegments
+// segments of values never decrease and the last exceeds the first by more
+// than bound.
+func growsMonotonically(values []int64, bound int64) bool {
+	if len(values) < soakSegments {
+		return false
+	}
+	var minima [soakSegments]int64
+	for s := range minima {
+		seg := values[s*len(values)/soakSegments : (s+1)*len(values)/soakSegments]
+		minima[s] = seg[0]
+		for _, v := range seg {
+			minima[s] = min(minima[s], v)
+		}
+		if s > 0 && minima[s] < minima[s-1] {
+			return false
+		}
+	}
+	return minima[soakSegments-1]-minima[0] > bound
+}
+
+// buildClientHealth analyses the points of series in the measured window,
+// which lasted elapsed.
+func buildClientHealth(cfg *config, series []timePoint, elapsed time.Duration) *clientHealth {
+	var goroutines, heap, fds, conns []int64
+	var pauseFirst, pauseLast uint64
+	for _, p := range series {
+		if p.T < 0 || p.Goroutines == 0 {
+			continue
+		}
+		if len(goroutines) == 0 {
+			pauseFirst = p.GCPauseNs
+		}
+		pauseLast = p.GCPauseNs
+		goroutines = append(goroutines, int64(p.Goroutines))
+		heap = append(heap, int64(p.HeapInuse))
+		fds = append(fds, int64(p.OpenFDs))
+		conns = append(conns, int64(p.PoolConns))
+	}
+	h := &clientHealth{Samples: len(goroutines)}
+	if h.Samples == 0 {
+		h.Sound = true
+		return h
+	}
+	h.Goroutines = newHealthMetric(goroutines, int64(cfg.soakGoroutines))
+	h.HeapInuse = newHealthMetric(heap, int64(cfg.soakHeap)<<20)
+	h.PoolConns = newHealthMetric(conns, int64(cfg.effectivePoolSize()))
+	if fds[0] > 0 {
+		m := newHealthMetric(fds, int64(cfg.soakFDs))
+		h.OpenFDs = &m
+	}
+	h.GCPauseNs = pauseLast - pauseFirst
+	if elapsed > 0 {
+		h.GCFraction = float64(h.GCPauseNs) / float64(elapsed)
+	}
+	if h.Goroutines.Growing {
+		h.Warnings = append(h.Warnings, fmt.Sprintf("goroutines grew monotonically from %d to %d, beyond -soak-max-goroutines %d",
+			h.Goroutines.First, h.Goroutines.Last, cfg.soakGoroutines))
+	}
+	if h.HeapInuse.Growing {
+		h.Warnings = append(h.Warnings, fmt.Sprintf("heap in use grew monotonically from %.1f to %.1f MiB, beyond -soak-max-heap %d MiB",
+			mebibytes(h.HeapInuse.First), mebibytes(h.HeapInuse.Last), cfg.soakHeap))
+	}
+	if h.OpenFDs != nil && h.OpenFDs.Growing {
+		h.Warnings = append(h.Warnings, fmt.Sprintf("open file descriptors grew monotonically from %d to %d, beyond -soak-max-fds %d",
+			h.OpenFDs.First, h.OpenFDs.Last, cfg.soakFDs))
+	}
+	if h.GCFraction > cfg.soakGC {
+		h.Warnings = append(h.Warnings, fmt.Sprintf("GC pauses took %.2f%% of the run, beyond -soak-max-gc %.2f%%",
+			100*h.GCFraction, 100*cfg.soakGC))
+	}
+	h.Sound = len(h.Warnings) == 0
+	return h
+}
+
+func mebibytes(n int64) float64 {
+	return float64(n) / (1 << 20)
+}
+
+// printClientHealth writes the client health section of the summary.
+func printClientHealth(w io.Writer, h *clientHealth) {
+	switch {
+	case h.Samples == 0:
+		fmt.Fprintln(w, "Client health: no samples in the measured window")
+		return
+	case h.Sound:
+		fmt.Fprintf(w, "Client health: sound over %d samples\n", h.Samples)
+	default:
+		fmt.Fprintf(w, "Client health: SUSPECT over %d samples, the tool itself may have skewed the measurement\n", h.Samples)
+	}
+	fmt.Fprintf(w, "  goroutines %d -> %d (peak %d)\n", h.Goroutines.First, h.Goroutines.Last, h.Goroutines.Peak)
+	fmt.Fprintf(w, "  heap in use %.1f -> %.1f MiB (peak %.1f)\n",
+		mebibytes(h.HeapInuse.First), mebibytes(h.HeapInuse.Last), mebibytes(h.HeapInuse.Peak))
+	if f := h.OpenFDs; f != nil {
+		fmt.Fprintf(w, "  open file descriptors %d -> %d (peak %d)\n", f.First, f.Last, f.Peak)
+	}
+	fmt.Fprintf(w, "  pool connections %d -> %d (peak %d)\n", h.PoolConns.First, h.PoolConns.Last, h.PoolConns.Peak)
+	fmt.Fprintf(w, "  GC pauses %v (%.2f%% of the run)\n", time.Duration(h.GCPauseNs), 100*h.GCFraction)
+	for _, warning := range h.Warnings {
+		fmt.Fprintf(w, "WARNING: %s\n", warning)
+	}
+}
@@ -0,0 +1,94 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGrowsMonotonically(t *testing.T) {
+	var sawtooth, flat, noisy []int64
+	for i := 0; i < 100; i++ {
+		// A heap collected every 5 samples, leaking 10 per sample.
+		sawtooth = append(sawtooth, int64(10*i+100*(i%5)))
+		flat = append(flat, int64(50+i%7))
+		noisy = append(noisy, int64(i*(i%3-1)))
+	}
+	for _, tc := range []struct {
+		name   string
+		values []int64
+		bound  int64
+		want   bool
+	}{
+		{"leaking sawtooth", sawtooth, 100, true},
+		{"leak within its bound", sawtooth, 2000, false},
+		{"flat", flat, 0, false},
+		{"noisy", noisy, 0, false},
+		{"too short", sawtooth[:soakSegments-1], 0, false},
+	} {
+		if got := growsMonotonically(tc.values, tc.bound); got != tc.want {
+			t.Errorf("%s: %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestClientHealth(t *testing.T) {
+	cfg := testConfig(t, "-soak", "-clients", "2", "-soak-max-goroutines", "10", "-soak-max-gc", "0.01")
+	var series []timePoint
+	for i := 0; i < 20; i++ {
+		series = append(series, timePoint{T: float64(i - 2), Goroutines: 10 + 5*i, HeapInuse: 1 << 20,
+			GCPauseNs: uint64(i) * uint64(50*time.Millisecond), OpenFDs: 8, PoolConns: 2})
+	}
+
+	h := buildClientHealth(cfg, series, 17*time.Second)
+
+	if h.Sound || h.Samples != 18 || h.Goroutines.First != 20 || !h.Goroutines.Growing || h.HeapInuse.Growing || h.OpenFDs == nil {
+		t.Fatalf("health %+v", h)
+	}
+	if len(h.Warnings) != 2 || !strings.Contains(h.Warnings[0], "goroutines grew monotonically from 20 to 105") ||
+		!strings.Contains(h.Warnings[1], "GC pauses took 5.00% of the run") {
+		t.Errorf("warnings %q", h.Warnings)
+	}
+	var buf bytes.Buffer
+	printClientHealth(&buf, h)
+	if !strings.Contains(buf.String(), "Client health: SUSPECT over 18 samples") {
+		t.Errorf("summary:\n%s", buf.String())
+	}
+}
+
+func TestSoakRun(t *testing.T) {
+	mr, _ := newTestServer(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-soak", "-soak-log-interval", "100ms", "-clients", "2", "-duration", "1100ms")
+
+	res, err := runTarget(context.Background(), cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := res.health; h == nil || h.Samples == 0 || h.Goroutines.Peak == 0 || h.HeapInuse.Peak == 0 || h.PoolConns.Peak == 0 {
+		t.Fatalf("health %+v", res.health)
+	}
+	if p := res.series[0]; p.Goroutines == 0 || p.HeapInuse == 0 {
+		t.Errorf("time series point %+v lacks the resources", p)
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "Client health: ") {
+		t.Errorf("summary lacks the client health:\n%s", buf.String())
+	}
+	if buildReport(cfg, res).ClientHealth != res.health {
+		t.Error("the JSON report lacks the client health")
+	}
+	for _, args := range [][]string{
+		{"-soak-max-heap", "10"},
+		{"-soak", "-soak-log-interval", "0"},
+		{"-soak", "-soak-max-gc", "0"},
+		{"-soak", "-soak-max-fds", "-1"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
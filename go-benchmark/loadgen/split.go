@@ -0,0 +1,181 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+// -latency-split breaks the latency of every operation down into the wait
+// for a connection of the pool and the round trip of the command on it.
+// go-redis gives no hook between the two, but it asks the context of a
+// command for its deadline as it arms the write and the read deadlines of
+// the connection it got: the wait ends at the second to last of these
+// calls. Dialing and the handshake of a new connection ask first, so they
+// count as waiting.
+
+// validateLatencySplit checks -latency-split, which times the commands
+// through a hook of the pooled go-redis client.
+func (c *config) validateLatencySplit() error {
+	if !c.latencySplit {
+		return nil
+	}
+	if c.protocol != protocolRedis || !c.usesPool() || c.dbs != nil {
+		return errors.New("-latency-split needs the pooled go-redis client: not -protocol, -client raw, -churn, -resilience, -dbs or the tracking workload")
+	}
+	return nil
+}
+
+// splitTimer times the commands of one worker. It is the context the
+// commands run under, reused from one operation to the next so timing
+// allocates nothing; a worker runs one operation at a time.
+type splitTimer struct {
+	// parent is the context of the command in progress, nil between
+	// commands.
+	parent context.Context
+	// sent is when the hook saw the command, and asked and prev the last
+	// two times its deadline was asked for.
+	sent, asked, prev time.Time
+	asks              int
+
+	// acquire is the wait for connections of the commands since reset,
+	// and timed whether every one of them was timed.
+	acquire time.Duration
+	timed   bool
+	seen    bool
+}
+
+// splitKey is the context key under which a worker hands its timer to the
+// hook.
+type splitKey struct{}
+
+func (s *splitTimer) Deadline() (time.Time, bool) {
+	if s.parent == nil {
+		return time.Time{}, false
+	}
+	s.prev, s.asked = s.asked, time.Now()
+	s.asks++
+	return s.parent.Deadline()
+}
+
+func (s *splitTimer) Done() <-chan struct{} {
+	if s.parent == nil {
+		return nil
+	}
+	return s.parent.Done()
+}
+
+func (s *splitTimer) Err() error {
+	if s.parent == nil {
+		return nil
+	}
+	return s.parent.Err()
+}
+
+func (s *splitTimer) Value(key any) any {
+	if key == (splitKey{}) {
+		return s
+	}
+	if s.parent == nil {
+		return nil
+	}
+	return s.parent.Value(key)
+}
+
+// reset starts the timing of an operation.
+func (s *splitTimer) reset() {
+	s.acquire, s.timed, s.seen = 0, true, false
+}
+
+// take returns the wait for connections of the operation, false when a
+// command of it could not be timed.
+func (s *splitTimer) take() (time.Duration, bool) {
+	return s.acquire, s.seen && s.timed
+}
+
+// splitHook is the go-redis hook of -latency-split. Commands whose context
+// carries no timer, those of the checks around the run, pass through.
+type splitHook struct{}
+
+func (splitHook) before(ctx context.Context) context.Context {
+	s, ok := ctx.Value(splitKey{}).(*splitTimer)
+	if !ok || s.parent != nil {
+		// Not a worker's, or a command go-redis runs within another, as
+		// it readies a new connection.
+		return ctx
+	}
+	s.parent, s.sent, s.asks = ctx, time.Now(), 0
+	return s
+}
+
+func (splitHook) after(ctx context.Context) {
+	s, ok := ctx.Value(splitKey{}).(*splitTimer)
+	if !ok || s.parent == nil {
+		return
+	}
+	s.seen = true
+	if s.asks >= 2 {
+		s.acquire += s.prev.Sub(s.sent)
+	} else {
+		// The command failed before it was written.
+		s.timed = false
+	}
+	s.parent = nil
+}
+
+func (h splitHook) BeforeProcess(ctx context.Context, _ redis.Cmder) (context.Context, error) {
+	return h.before(ctx), nil
+}
+
+func (h splitHook) AfterProcess(ctx context.Context, _ redis.Cmder) error {
+	h.after(ctx)
+	return nil
+}
+
+func (h splitHook) BeforeProcessPipeline(ctx context.Context, _ []redis.Cmder) (context.Context, error) {
+	return h.before(ctx), nil
+}
+
+func (h splitHook) AfterProcessPipeline(ctx context.Context, _ []redis.Cmder) error {
+	h.after(ctx)
+	return nil
+}
+
+// recordSplit records the wait for a connection and the round trip of a
+// command whose latency was d.
+func (s *opStats) recordSplit(l stats.Layout, acquire, d time.Duration) {
+	if s.acquire == nil {
+		s.acquire, s.roundTrip = l.New(), l.New()
+	}
+	acquire = min(acquire, d)
+	s.acquire.Record(acquire)
+	s.roundTrip.Record(d - acquire)
+}
+
+// latencySplit is the JSON form of -latency-split over all commands.
+type latencySplit struct {
+	Acquire   *latencySummary `json:"acquire"`
+	RoundTrip *latencySummary `json:"round_trip"`
+}
+
+// splitHistograms merges the -latency-split histograms of every command,
+// nil when none was timed.
+func splitHistograms(r *workerResult) (acquire, roundTrip *stats.Histogram) {
+	for op := range r.ops {
+		acquire = mergeHistogram(acquire, r.ops[op].acquire)
+		roundTrip = mergeHistogram(roundTrip, r.ops[op].roundTrip)
+	}
+	return acquire, roundTrip
+}
+
+func buildLatencySplit(r *workerResult) *latencySplit {
+	acquire, roundTrip := splitHistograms(r)
+	if acquire == nil {
+		return nil
+	}
+	return &latencySplit{Acquire: summarizeLatency(acquire), RoundTrip: summarizeLatency(roundTrip)}
+}
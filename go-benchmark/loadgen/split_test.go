@@ -0,0 +1,116 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitHookTimesTheWaitBeforeTheWrite(t *testing.T) {
+	s := &splitTimer{}
+	base := context.WithValue(context.Background(), splitKey{}, s)
+	s.reset()
+
+	var h splitHook
+	cmdCtx := h.before(base)
+	// The dial of a new connection asks first, then the write and the
+	// read of the command.
+	time.Sleep(5 * time.Millisecond)
+	cmdCtx.Deadline()
+	time.Sleep(5 * time.Millisecond)
+	cmdCtx.Deadline()
+	cmdCtx.Deadline()
+	h.after(cmdCtx)
+
+	acquire, ok := s.take()
+	if !ok || acquire < 10*time.Millisecond {
+		t.Errorf("acquire %v (%v), want at least 10ms", acquire, ok)
+	}
+
+	// A command that fails before it is written cannot be timed.
+	s.reset()
+	h.after(h.before(base))
+	if _, ok := s.take(); ok {
+		t.Error("untimed command taken")
+	}
+
+	// Commands without a timer pass through.
+	if got := h.before(context.Background()); got != context.Background() {
+		t.Errorf("before replaced a context without timer: %v", got)
+	}
+}
+
+func TestSplitHookDoesNotAllocate(t *testing.T) {
+	s := &splitTimer{}
+	base := context.WithValue(context.Background(), splitKey{}, s)
+	var h splitHook
+	allocs := testing.AllocsPerRun(100, func() {
+		s.reset()
+		c, _ := h.BeforeProcess(base, nil)
+		c.Deadline()
+		c.Deadline()
+		_ = h.AfterProcess(c, nil)
+		s.take()
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations per command", allocs)
+	}
+}
+
+func TestLatencySplitStarvedPool(t *testing.T) {
+	addr := newTestServerAddr(t)
+	cfg := testConfig(t, "-addr", addr, "-clients", "8", "-pool-size", "1", "-ops", "50", "-keyspace", "100", "-ratio", "get=0.5,set=0.5", "-latency-split")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquire, roundTrip := splitHistograms(res.total)
+	if acquire == nil || acquire.Count() != res.total.latency.Count() || roundTrip.Count() != acquire.Count() {
+		t.Fatalf("split %v operations of %d", acquire, res.total.latency.Count())
+	}
+	// Eight clients share one connection: most of them wait for it.
+	if acquire.Percentile(99) == 0 {
+		t.Error("no wait for the connection")
+	}
+
+	var out bytes.Buffer
+	printSummary(&out, cfg, res)
+	if !strings.Contains(out.String(), "acquire p99") {
+		t.Errorf("summary lacks the split:\n%s", out.String())
+	}
+	rep := buildReport(cfg, res)
+	if rep.LatencySplit == nil || rep.LatencySplit.Acquire.Count != acquire.Count() {
+		t.Errorf("report split %+v", rep.LatencySplit)
+	}
+	if c := rep.Commands["GET"]; c.Acquire == nil || c.RoundTrip == nil {
+		t.Errorf("GET lacks the split: %+v", c)
+	}
+}
+
+func TestLatencySplitPipeline(t *testing.T) {
+	addr := newTestServerAddr(t)
+	cfg := testConfig(t, "-addr", addr, "-clients", "2", "-ops", "20", "-pipeline", "4", "-latency-split")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := &res.total.ops[opSet]; s.acquire == nil || s.acquire.Count() != 40 {
+		t.Errorf("split %v of 40 pipelined commands", s.acquire)
+	}
+}
+
+func TestValidateLatencySplit(t *testing.T) {
+	for _, args := range [][]string{
+		{"-latency-split", "-client", "raw"},
+		{"-latency-split", "-resilience"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+// validateSteadyState checks the -steady flags. -steady-state replaces the
+// fixed -warmup: the measured window starts once the run has settled.
+func (c *config) validateSteadyState() error {
+	if !c.steadyState {
+		return nil
+	}
+	switch {
+	case c.warmup > 0:
+		return errors.New("-steady-state replaces -warmup: give one or the other")
+	case c.steadyInterval <= 0:
+		return fmt.Errorf("-steady-interval must be positive, got %v", c.steadyInterval)
+	case c.steadyIntervals < 2:
+		return fmt.Errorf("-steady-intervals must be at least 2, got %d", c.steadyIntervals)
+	case c.steadyTolerance <= 0:
+		return fmt.Errorf("-steady-tolerance must be positive, got %v", c.steadyTolerance)
+	case c.steadyMax < c.steadyInterval*time.Duration(c.steadyIntervals):
+		return fmt.Errorf("-steady-max %v is shorter than -steady-intervals %d of -steady-interval %v", c.steadyMax, c.steadyIntervals, c.steadyInterval)
+	}
+	return nil
+}
+
+// steadyReport describes how -steady-state settled the run before its
+// measured window.
+type steadyReport struct {
+	Reached bool `json:"reached"`
+	// StabilizationSeconds is how long the run took to settle, or to
+	// reach -steady-max when it did not.
+	StabilizationSeconds float64 `json:"stabilization_seconds"`
+	Intervals            int     `json:"intervals"`
+	IntervalSeconds      float64 `json:"interval_seconds"`
+	Window               int     `json:"window"`
+	Tolerance            float64 `json:"tolerance"`
+	// ThroughputSpread and P99Spread are the relative standard deviations
+	// over the last window when the measured window started; nil before a
+	// whole window was sampled.
+	ThroughputSpread *float64 `json:"throughput_spread,omitempty"`
+	P99Spread        *float64 `json:"p99_spread,omitempty"`
+}
+
+// steadyWatch samples the live counters every -steady-interval once the
+// ramp is over, and calls settled when the run has been steady for
+// -steady-intervals intervals in a row. cancel stops it early, once
+// -steady-max started the measured window or the run ended.
+type steadyWatch struct {
+	detector  *stats.SteadyDetector
+	intervals int
+	cancel    context.CancelFunc
+	done      chan struct{}
+}
+
+func startSteadyWatch(ctx context.Context, cfg *config, live *liveCounters, settled func()) *steadyWatch {
+	ctx, cancel := context.WithCancel(ctx)
+	sw := &steadyWatch{
+		detector: stats.NewSteadyDetector(cfg.steadyIntervals, cfg.steadyTolerance),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go func() {
+		defer close(sw.done)
+		if !(realClock{}).Sleep(ctx, cfg.rampUp) {
+			return
+		}
+		t := time.NewTicker(cfg.steadyInterval)
+		defer t.Stop()
+		ops, lat := live.ops.Load(), live.latency.snapshot()
+		for {
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return
+			}
+			nowOps, nowLat := live.ops.Load(), live.latency.snapshot()
+			tput := float64(nowOps-ops) / cfg.steadyInterval.Seconds()
+			p99 := float64(nowLat.since(lat).percentile(99))
+			ops, lat = nowOps, nowLat
+			sw.intervals++
+			if sw.detector.Add(tput, p99) {
+				settled()
+				return
+			}
+		}
+	}()
+	return sw
+}
+
+// report waits for the watch to stop and describes it; reached is false
+// when -steady-max started the measured window instead.
+func (sw *steadyWatch) report(cfg *config, reached bool, took time.Duration) *steadyReport {
+	sw.cancel()
+	<-sw.done
+	r := &steadyReport{
+		Reached:              reached,
+		StabilizationSeconds: took.Seconds(),
+		Intervals:            sw.intervals,
+		IntervalSeconds:      cfg.steadyInterval.Seconds(),
+		Window:               cfg.steadyIntervals,
+		Tolerance:            cfg.steadyTolerance,
+	}
+	if sw.intervals >= cfg.steadyIntervals {
+		tput, p99 := sw.detector.Spread()
+		if !math.IsInf(tput, 0) {
+			r.ThroughputSpread = &tput
+		}
+		if !math.IsInf(p99, 0) {
+			r.P99Spread = &p99
+		}
+	}
+	return r
+}
+
+// printSteadyState writes the -steady-state summary line.
+func printSteadyState(w io.Writer, r *steadyReport) {
+	spread := ""
+	if r.ThroughputSpread != nil && r.P99Spread != nil {
+		spread = fmt.Sprintf("; throughput within %.1f%%, p99 within %.1f%% over the last %d", *r.ThroughputSpread*100, *r.P99Spread*100, r.Window)
+	}
+	took := time.Duration(r.StabilizationSeconds * float64(time.Second)).Round(time.Millisecond)
+	if r.Reached {
+		fmt.Fprintf(w, "Steady state: reached after %v, %d intervals of %vs%s\n", took, r.Intervals, r.IntervalSeconds, spread)
+		return
+	}
+	fmt.Fprintf(w, "WARNING: steady state not reached: measuring anyway after %v, %d intervals of %vs, tolerance %.1f%%%s\n",
+		took, r.Intervals, r.IntervalSeconds, r.Tolerance*100, spread)
+}
@@ -0,0 +1,80 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSteadyStateStartsMeasuringOnceSettled(t *testing.T) {
+	_, rdb := newTestServer(t)
+	cfg := testConfig(t, "-clients", "4", "-rate", "2000", "-duration", "150ms",
+		"-steady-state", "-steady-interval", "50ms", "-steady-intervals", "3", "-steady-tolerance", "0.5", "-steady-max", "5s")
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	s := res.steady
+	if s == nil || !s.Reached {
+		t.Fatalf("steady state not reached: %+v", s)
+	}
+	if s.Intervals < 3 || s.ThroughputSpread == nil || *s.ThroughputSpread > 0.5 {
+		t.Errorf("steady after %d intervals, spread %v", s.Intervals, s.ThroughputSpread)
+	}
+	if res.warmup < 150*time.Millisecond || res.total.warmupOps == 0 {
+		t.Errorf("warmup of %v with %d operations, want 3 intervals of 50ms", res.warmup, res.total.warmupOps)
+	}
+	if s.StabilizationSeconds != res.warmup.Seconds() {
+		t.Errorf("stabilization took %vs, warmup %v", s.StabilizationSeconds, res.warmup)
+	}
+	if res.total.latency.Count() == 0 {
+		t.Error("no measured operations recorded")
+	}
+
+	var out bytes.Buffer
+	printSummary(&out, cfg, res)
+	if !strings.Contains(out.String(), "Steady state: reached after") {
+		t.Errorf("summary lacks the steady state:\n%s", out.String())
+	}
+}
+
+func TestSteadyStateCapProceedsAnyway(t *testing.T) {
+	_, rdb := newTestServer(t)
+	// No run is this steady: -steady-max starts the measured window.
+	cfg := testConfig(t, "-clients", "2", "-duration", "100ms",
+		"-steady-state", "-steady-interval", "20ms", "-steady-intervals", "3", "-steady-tolerance", "1e-12", "-steady-max", "150ms")
+
+	res := runBenchmark(context.Background(), rdb, cfg)
+
+	s := res.steady
+	if s == nil || s.Reached {
+		t.Fatalf("steady state reached: %+v", s)
+	}
+	if res.warmup < 150*time.Millisecond || res.total.latency.Count() == 0 {
+		t.Errorf("warmup of %v, %d measured operations", res.warmup, res.total.latency.Count())
+	}
+
+	var out bytes.Buffer
+	printSummary(&out, cfg, res)
+	if !strings.Contains(out.String(), "WARNING: steady state not reached") {
+		t.Errorf("summary lacks the warning:\n%s", out.String())
+	}
+	if rep := buildReport(cfg, res); rep.SteadyState == nil || rep.SteadyState.Reached {
+		t.Errorf("report steady state %+v", rep.SteadyState)
+	}
+}
+
+func TestValidateSteadyState(t *testing.T) {
+	for _, args := range [][]string{
+		{"-steady-state", "-warmup", "1s"},
+		{"-steady-state", "-steady-interval", "0"},
+		{"-steady-state", "-steady-intervals", "1"},
+		{"-steady-state", "-steady-tolerance", "0"},
+		{"-steady-state", "-steady-max", "2s"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
@@ -0,0 +1,492 @@
+package loadgen
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	// A pure-Go SQLite keeps the tool free of cgo for cross-compilation.
+	_ "modernc.org/sqlite"
+)
+
+// defaultStore is the database the history and diff subcommands read when
+// -store is not given.
+const defaultStore = "results.db"
+
+// toolVersion is the version stored with each run, set at build time with
+// -ldflags "-X go-benchmark/loadgen.toolVersion=$(git describe --always
+// --dirty)"; the VCS revision Go embeds in the binary is used otherwise.
+var toolVersion string
+
+// describeTool returns the version of the tool for the run history.
+func describeTool() string {
+	if toolVersion != "" {
+		return toolVersion
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	var rev, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		}
+	}
+	switch {
+	case rev != "":
+		if len(rev) > 12 {
+			rev = rev[:12]
+		}
+		if modified == "true" {
+			rev += "-dirty"
+		}
+		return rev
+	case info.Main.Version != "" && info.Main.Version != "(devel)":
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// storeMigrations creates and evolves the schema; migration i brings a
+// database to user_version i+1. Append new migrations, never edit applied
+// ones. Summary metrics are name/value rows, so a new metric needs no
+// migration and runs stored before it simply lack it.
+var storeMigrations = []string{
+	`CREATE TABLE runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		started TEXT NOT NULL,
+		tool_version TEXT NOT NULL,
+		target TEXT NOT NULL,
+		workload TEXT NOT NULL,
+		clients INTEGER NOT NULL,
+		partial INTEGER NOT NULL,
+		config TEXT NOT NULL,
+		report TEXT NOT NULL
+	);
+	CREATE TABLE metrics (
+		run_id INTEGER NOT NULL REFERENCES runs(id),
+		seq INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		value REAL NOT NULL,
+		unit TEXT NOT NULL,
+		PRIMARY KEY (run_id, name)
+	);
+	CREATE TABLE timeseries (
+		run_id INTEGER NOT NULL REFERENCES runs(id),
+		t REAL NOT NULL,
+		ops INTEGER NOT NULL,
+		errors INTEGER NOT NULL,
+		clients INTEGER NOT NULL,
+		p99_ns INTEGER NOT NULL,
+		window_p50_ns INTEGER NOT NULL,
+		window_p99_ns INTEGER NOT NULL
+	);`,
+	`ALTER TABLE runs ADD COLUMN environment TEXT NOT NULL DEFAULT '{}';
+	CREATE TABLE tags (
+		run_id INTEGER NOT NULL REFERENCES runs(id),
+		name TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (run_id, name)
+	);`,
+}
+
+// openStore opens the database at path, creating it if needed, and applies
+// the migrations it lacks. A database written by a newer version of the
+// tool is refused rather than misread.
+func openStore(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	if err := migrateStore(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return db, nil
+}
+
+func migrateStore(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	if version > len(storeMigrations) {
+		return fmt.Errorf("schema version %d is newer than this tool's %d", version, len(storeMigrations))
+	}
+	for v := version; v < len(storeMigrations); v++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(storeMigrations[v]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate to schema version %d: %w", v+1, err)
+		}
+		// PRAGMA takes no parameters.
+		if _, err := tx.Exec("PRAGMA user_version = " + strconv.Itoa(v+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrate to schema version %d: %w", v+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migrate to schema version %d: %w", v+1, err)
+		}
+	}
+	return nil
+}
+
+// storedMetric is one summary metric of a stored run.
+type storedMetric struct {
+	name  string
+	value float64
+	unit  string
+}
+
+// storedMetrics lists the summary metrics of rep kept in the history.
+func storedMetrics(rep *Report) []storedMetric {
+	ms := []storedMetric{
+		{"throughput", rep.Throughput, "ops/s"},
+		{"total ops", float64(rep.TotalOps), "ops"},
+		{"failed ops", float64(rep.FailedOps), "ops"},
+	}
+	if n := rep.TotalOps + rep.FailedOps; n > 0 {
+		ms = append(ms, storedMetric{"error rate", 100 * float64(rep.FailedOps) / float64(n), "%"})
+	}
+	for _, l := range []struct {
+		prefix string
+		s      *latencySummary
+	}{{"", rep.Latency}, {"response ", rep.ResponseLatency}} {
+		if l.s == nil {
+			continue
+		}
+		for _, p := range []struct {
+			name string
+			ns   int64
+		}{
+			{"min", l.s.MinNs}, {"mean", l.s.MeanNs}, {"p50", l.s.P50Ns}, {"p90", l.s.P90Ns},
+			{"p99", l.s.P99Ns}, {"p99.9", l.s.P999Ns}, {"max", l.s.MaxNs},
+		} {
+			ms = append(ms, storedMetric{l.prefix + p.name + " latency", float64(p.ns) / 1e3, "µs"})
+		}
+	}
+	ms = append(ms, storedMetric{"write throughput", rep.WriteMBPerSec, "MB/s"})
+	if rep.Hits+rep.Misses > 0 {
+		ms = append(ms, storedMetric{"hit ratio", 100 * float64(rep.Hits) / float64(rep.Hits+rep.Misses), "%"})
+	}
+	return ms
+}
+
+// storeRun appends rep to the database at path and returns its run id.
+func storeRun(path string, rep *Report) (int64, error) {
+	db, err := openStore(path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	config, err := json.Marshal(rep.Config)
+	if err != nil {
+		return 0, err
+	}
+	report, err := json.Marshal(rep)
+	if err != nil {
+		return 0, err
+	}
+	env, err := json.Marshal(rep.Environment)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	r, err := tx.Exec(`INSERT INTO runs (started, tool_version, target, workload, clients, partial, config, report, environment)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rep.Start.UTC().Format(time.RFC3339Nano), describeTool(), rep.Config.Addr, rep.Config.Workload,
+		rep.Config.Clients, rep.Partial, string(config), string(report), string(env))
+	if err != nil {
+		return 0, fmt.Errorf("store run: %w", err)
+	}
+	id, err := r.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	for i, m := range storedMetrics(rep) {
+		if _, err := tx.Exec("INSERT INTO metrics (run_id, seq, name, value, unit) VALUES (?, ?, ?, ?, ?)",
+			id, i, m.name, m.value, m.unit); err != nil {
+			return 0, fmt.Errorf("store metrics: %w", err)
+		}
+	}
+	for _, k := range tagKeys(rep.Tags) {
+		if _, err := tx.Exec("INSERT INTO tags (run_id, name, value) VALUES (?, ?, ?)", id, k, rep.Tags[k]); err != nil {
+			return 0, fmt.Errorf("store tags: %w", err)
+		}
+	}
+	for _, p := range rep.TimeSeries {
+		if _, err := tx.Exec(`INSERT INTO timeseries (run_id, t, ops, errors, clients, p99_ns, window_p50_ns, window_p99_ns)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, p.T, p.Ops, p.Errors, p.Clients, p.P99Ns, p.WindowP50Ns, p.WindowP99Ns); err != nil {
+			return 0, fmt.Errorf("store time series: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("store run: %w", err)
+	}
+	return id, nil
+}
+
+// storeResults appends every run to -store and returns the exit status a
+// failure calls for. The reports are already written, so a failure loses
+// nothing else.
+func storeResults(cfgs []*config, results []*runResult) int {
+	for i, res := range results {
+		id, err := storeRun(cfgs[i].storePath, buildReport(cfgs[i], res))
+		if err != nil {
+			cfgs[i].logger.Error(err.Error())
+			return 1
+		}
+		cfgs[i].logger.Info("stored", "run", id, "store", cfgs[i].storePath)
+	}
+	return 0
+}
+
+// storedRun is the history entry of a run.
+type storedRun struct {
+	id              int64
+	started         time.Time
+	version, target string
+	workload        string
+	clients         int
+	partial         bool
+	report          *Report
+	metrics         map[string]storedMetric
+	order           []string
+}
+
+func loadRuns(db *sql.DB, query string, args ...any) ([]*storedRun, error) {
+	rows, err := db.Query(`SELECT id, started, tool_version, target, workload, clients, partial, report FROM runs `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var runs []*storedRun
+	for rows.Next() {
+		r := &storedRun{metrics: make(map[string]storedMetric)}
+		var started, report string
+		if err := rows.Scan(&r.id, &started, &r.version, &r.target, &r.workload, &r.clients, &r.partial, &report); err != nil {
+			return nil, err
+		}
+		if r.started, err = time.Parse(time.RFC3339Nano, started); err != nil {
+			return nil, fmt.Errorf("run %d: %w", r.id, err)
+		}
+		r.report = &Report{}
+		if err := json.Unmarshal([]byte(report), r.report); err != nil {
+			return nil, fmt.Errorf("run %d: %w", r.id, err)
+		}
+		runs = append(runs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, r := range runs {
+		if err := r.loadMetrics(db); err != nil {
+			return nil, err
+		}
+	}
+	return runs, nil
+}
+
+func (r *storedRun) loadMetrics(db *sql.DB) error {
+	rows, err := db.Query("SELECT name, value, unit FROM metrics WHERE run_id = ? ORDER BY seq", r.id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m storedMetric
+		if err := rows.Scan(&m.name, &m.value, &m.unit); err != nil {
+			return err
+		}
+		r.metrics[m.name] = m
+		r.order = append(r.order, m.name)
+	}
+	return rows.Err()
+}
+
+// History is the history subcommand of the command line tool: it lists the
+// runs of a store, latest last, to w, and its errors to errw, and returns
+// the exit status.
+func History(args []string, w, errw io.Writer) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	fs.SetOutput(errw)
+	path := fs.String("store", defaultStore, "database written by -store")
+	limit := fs.Int("limit", 20, "number of runs listed, the latest (0: all)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(errw, "usage: history [-store results.db] [-limit n]")
+		return 2
+	}
+	db, err := openStore(*path)
+	if err != nil {
+		fmt.Fprintln(errw, err)
+		return 1
+	}
+	defer db.Close()
+	query := "ORDER BY id DESC"
+	if *limit > 0 {
+		query += " LIMIT " + strconv.Itoa(*limit)
+	}
+	runs, err := loadRuns(db, query)
+	if err != nil {
+		fmt.Fprintln(errw, err)
+		return 1
+	}
+	if len(runs) == 0 {
+		fmt.Fprintf(w, "No runs stored in %s\n", *path)
+		return 0
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "id\tstarted\tversion\ttarget\tworkload\tclients\tops/s\tp99\t")
+	for i := len(runs) - 1; i >= 0; i-- {
+		r := runs[i]
+		p99 := "N/A"
+		if l := r.report.Latency; l != nil {
+			p99 = time.Duration(l.P99Ns).String()
+		}
+		workload := r.workload
+		if r.partial {
+			workload += " (partial)"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%d\t%.0f\t%s\t\n", r.id, r.started.Local().Format("2006-01-02 15:04:05"),
+			r.version, r.target, workload, r.clients, r.report.Throughput, p99)
+	}
+	tw.Flush()
+	return 0
+}
+
+// Diff is the diff subcommand of the command line tool: it compares the
+// summary metrics of two stored runs on w, writes its errors to errw and
+// returns the exit status.
+func Diff(args []string, w, errw io.Writer) int {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(errw)
+	path := fs.String("store", defaultStore, "database written by -store")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 2 {
+		fmt.Fprintln(errw, "usage: diff [-store results.db] <id1> <id2>")
+		return 2
+	}
+	var ids [2]int64
+	for i := range ids {
+		id, err := strconv.ParseInt(fs.Arg(i), 10, 64)
+		if err != nil {
+			fmt.Fprintf(errw, "invalid run id %q\n", fs.Arg(i))
+			return 2
+		}
+		ids[i] = id
+	}
+	db, err := openStore(*path)
+	if err != nil {
+		fmt.Fprintln(errw, err)
+		return 1
+	}
+	defer db.Close()
+	var runs [2]*storedRun
+	for i, id := range ids {
+		found, err := loadRuns(db, "WHERE id = ?", id)
+		if err != nil {
+			fmt.Fprintln(errw, err)
+			return 1
+		}
+		if len(found) == 0 {
+			fmt.Fprintf(errw, "no run %d in %s\n", id, *path)
+			return 1
+		}
+		runs[i] = found[0]
+	}
+	printRunDiff(w, runs[0], runs[1])
+	return 0
+}
+
+// printRunDiff writes the runs compared, the workload settings in which they
+// differ and the metrics both have side by side.
+func printRunDiff(w io.Writer, a, b *storedRun) {
+	for _, r := range []*storedRun{a, b} {
+		fmt.Fprintf(w, "Run %d: %s, %s against %s with %d clients, version %s\n",
+			r.id, r.started.Local().Format(time.RFC3339), r.workload, r.target, r.clients, r.version)
+		if len(r.report.Tags) > 0 {
+			fmt.Fprintf(w, "  tags: %s\n", formatTags(r.report.Tags))
+		}
+	}
+	if diffs := configMismatches(a.report, b.report); len(diffs) > 0 {
+		fmt.Fprintln(w, "Workload settings differ:")
+		for _, d := range diffs {
+			fmt.Fprintln(w, "  "+d)
+		}
+	}
+	if diffs := environmentMismatches(a.report.Environment, b.report.Environment); len(diffs) > 0 {
+		fmt.Fprintln(w, "WARNING: the runs come from different environments:")
+		for _, d := range diffs {
+			fmt.Fprintln(w, "  "+d)
+		}
+	}
+	var metrics []comparedMetric
+	var missing []string
+	for _, name := range a.order {
+		ma := a.metrics[name]
+		mb, ok := b.metrics[name]
+		if !ok {
+			missing = append(missing, fmt.Sprintf("%s (run %d only)", name, a.id))
+			continue
+		}
+		metrics = append(metrics, comparedMetric{name: name, a: ma.value, b: mb.value, unit: ma.unit})
+	}
+	for _, name := range b.order {
+		if _, ok := a.metrics[name]; !ok {
+			missing = append(missing, fmt.Sprintf("%s (run %d only)", name, b.id))
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "metric\trun %d\trun %d\tdelta\t\n", a.id, b.id)
+	for _, m := range metrics {
+		fmt.Fprintf(tw, "%s\t%s %s\t%s %s\t%+.1f%%\t\n", m.name, formatMetric(m.a), m.unit, formatMetric(m.b), m.unit, m.delta())
+	}
+	tw.Flush()
+	if len(missing) > 0 {
+		fmt.Fprintln(w, "Not compared:")
+		for _, m := range missing {
+			fmt.Fprintln(w, "  "+m)
+		}
+	}
+}
+
+// formatMetric prints large values whole and small ones, such as error
+// rates in percent, with three significant digits.
+func formatMetric(v float64) string {
+	if v >= 100 || v <= -100 {
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(v, 'g', 3, 64)
+}
+
+// validateStore checks -store.
+func (c *config) validateStore() error {
+	if c.storePath != "" && (c.sweeping() || c.scenario != "") {
+		return errors.New("-store records single runs and -addr2 comparisons, not sweeps or -scenario")
+	}
+	return nil
+}
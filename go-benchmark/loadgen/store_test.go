@@ -0,0 +1,112 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStoreHistoryDiff(t *testing.T) {
+	addr := newTestServerAddr(t)
+	path := filepath.Join(t.TempDir(), "results.db")
+	cfg := testConfig(t, "-addr", addr, "-clients", "2", "-duration", "300ms", "-store", path)
+	var ids []int64
+	for i := 0; i < 2; i++ {
+		res, err := runTarget(context.Background(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := storeRun(path, buildReport(cfg, res))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if ids[0] != 1 || ids[1] != 2 {
+		t.Fatalf("run ids %v, want 1 and 2", ids)
+	}
+
+	db, err := openStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var points int
+	if err := db.QueryRow("SELECT COUNT(*) FROM timeseries WHERE run_id = 1").Scan(&points); err != nil || points == 0 {
+		t.Errorf("%d time-series points stored, %v", points, err)
+	}
+	// A run stored before a metric existed lacks it.
+	if _, err := db.Exec("DELETE FROM metrics WHERE run_id = 1 AND name = 'write throughput'"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	var buf bytes.Buffer
+	if code := History([]string{"-store", path}, &buf, io.Discard); code != 0 {
+		t.Fatalf("history exited with %d", code)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "1 ") || !strings.Contains(lines[2], addr) || !strings.Contains(lines[2], "set") {
+		t.Errorf("history:\n%s", buf.String())
+	}
+	buf.Reset()
+	if code := History([]string{"-store", path, "-limit", "1"}, &buf, io.Discard); code != 0 || strings.Count(buf.String(), "\n") != 2 {
+		t.Errorf("history -limit 1 exited with %d:\n%s", code, buf.String())
+	}
+
+	buf.Reset()
+	if code := Diff([]string{"-store", path, "1", "2"}, &buf, io.Discard); code != 0 {
+		t.Fatalf("diff exited with %d", code)
+	}
+	for _, want := range []string{"Run 1:", "Run 2:", "throughput", "p99 latency", "write throughput (run 2 only)"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("diff lacks %q:\n%s", want, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "Workload settings differ") {
+		t.Errorf("identical runs reported as different:\n%s", buf.String())
+	}
+	for _, args := range [][]string{{"-store", path, "1"}, {"-store", path, "1", "x"}, {"-store", path, "1", "9"}} {
+		if code := Diff(args, &buf, io.Discard); code == 0 {
+			t.Errorf("diff %v succeeded", args)
+		}
+	}
+}
+
+func TestStoreMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.db")
+	for i := 0; i < 2; i++ {
+		db, err := openStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var version int
+		if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil || version != len(storeMigrations) {
+			t.Errorf("schema version %d, %v", version, err)
+		}
+		db.Close()
+	}
+	// A database of a newer tool is refused.
+	db, err := openStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("PRAGMA user_version = 99"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if db, err := openStore(path); err == nil {
+		db.Close()
+		t.Error("a database of schema version 99 was opened")
+	}
+
+	var buf bytes.Buffer
+	if code := History([]string{"-store", filepath.Join(t.TempDir(), "empty.db")}, &buf, io.Discard); code != 0 || !strings.Contains(buf.String(), "No runs stored") {
+		t.Errorf("history of an empty store exited with %d:\n%s", code, buf.String())
+	}
+	if _, err := parseFlags([]string{"-store", path, "-sweep-clients", "1,2"}); err == nil {
+		t.Error("-store was accepted with a sweep")
+	}
+}
@@ -0,0 +1,262 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// parseLevels parses the comma-separated, positive levels of a sweep flag.
+func parseLevels(s string) ([]int, error) {
+	var levels []int
+	for _, part := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid level %q: want a positive integer", part)
+		}
+		levels = append(levels, n)
+	}
+	return levels, nil
+}
+
+// sweepStep is the outcome of one level of a sweep.
+type sweepStep struct {
+	level int
+	cfg   *config
+	res   *runResult
+	// stopReason is set on the step that ended the sweep early.
+	stopReason string
+}
+
+// sweepParam returns the name of the swept parameter and its levels.
+func (c *config) sweepParam() (string, []int) {
+	switch {
+	case len(c.sweepSizes) > 0:
+		return "value size", c.sweepSizes
+	case len(c.sweepBatches) > 0:
+		return "batch keys", c.sweepBatches
+	}
+	return "clients", c.sweep
+}
+
+// forLevel returns the configuration of the sweep step at level.
+func (c *config) forLevel(level int) *config {
+	switch {
+	case len(c.sweepSizes) > 0:
+		return c.forValueSize(level)
+	case len(c.sweepBatches) > 0:
+		return c.forBatchKeys(level)
+	}
+	return c.forClients(level)
+}
+
+// forClients returns a copy of c running n clients over a connection pool
+// sized to match, unless -pool-size fixes the pool.
+func (c *config) forClients(n int) *config {
+	t := *c
+	t.clients = n
+	if c.poolSize == 0 {
+		t.poolSize = n
+	}
+	t.sweepClients, t.sweep = "", nil
+	return &t
+}
+
+// forValueSize returns a copy of c writing values of exactly n bytes into a
+// keyspace of its own, so no step reads values written by another.
+func (c *config) forValueSize(n int) *config {
+	t := *c
+	t.values = c.values.Sized(n, n)
+	t.valueSize, t.valueSizeRange = n, ""
+	t.keyPrefix = fmt.Sprintf("%sv%d:", c.keyPrefix, n)
+	t.sweepValueSizes, t.sweepSizes = "", nil
+	return &t
+}
+
+// forBatchKeys returns a copy of c sending n keys per MGET or MSET.
+func (c *config) forBatchKeys(n int) *config {
+	t := *c
+	t.batchKeys = n
+	t.sweepBatchKeys, t.sweepBatches = "", nil
+	return &t
+}
+
+// sweeping reports whether the run is a sweep of several steps.
+func (c *config) sweeping() bool {
+	return len(c.sweep) > 0 || len(c.sweepSizes) > 0 || len(c.sweepBatches) > 0
+}
+
+// runSweep runs the workload once per -sweep-clients, -sweep-value-size or
+// -sweep-batch-keys level, in order, with -sweep-cooldown between steps. It
+// stops after a step whose error rate exceeds -max-error-rate or that was
+// interrupted.
+func runSweep(rootCtx context.Context, cfg *config) ([]*sweepStep, error) {
+	param, levels := cfg.sweepParam()
+	var steps []*sweepStep
+	for i, n := range levels {
+		if i > 0 {
+			if cfg.sweepFlush {
+				if err := flushBetweenSteps(cfg); err != nil {
+					return steps, err
+				}
+			}
+			if !(realClock{}).Sleep(rootCtx, cfg.sweepCooldown) {
+				break
+			}
+		}
+		stepCfg := cfg.forLevel(n)
+		cfg.logger.Info("sweep step", "n", i+1, "of", len(levels), param, n)
+		res, err := runTarget(rootCtx, stepCfg)
+		if err != nil {
+			return steps, err
+		}
+		step := &sweepStep{level: n, cfg: stepCfg, res: res}
+		steps = append(steps, step)
+
+		switch {
+		case res.partial:
+			step.stopReason = "step did not complete"
+			if res.abortReason != "" {
+				step.stopReason = res.abortReason
+			}
+		case cfg.maxErrorRate > 0 && errorRate(res.total) > cfg.maxErrorRate:
+			step.stopReason = fmt.Sprintf("error rate %.2f%% exceeds -max-error-rate", 100*errorRate(res.total))
+		}
+		if step.stopReason != "" {
+			if i < len(levels)-1 {
+				cfg.logger.Warn("stopping sweep", "reason", step.stopReason)
+			}
+			break
+		}
+	}
+	return steps, nil
+}
+
+func errorRate(r *workerResult) float64 {
+	if n := r.attempts(); n > 0 {
+		return float64(r.errors()) / float64(n)
+	}
+	return 0
+}
+
+// flushBetweenSteps empties the database so each step starts from the same
+// state.
+func flushBetweenSteps(cfg *config) error {
+	rdb, _ := newClient(cfg)
+	defer rdb.Close()
+	err := forEachNode(context.Background(), rdb, func(ctx context.Context, c *redis.Client) error {
+		return c.FlushDB(ctx).Err()
+	})
+	if err != nil {
+		return fmt.Errorf("flush between sweep steps: %w", err)
+	}
+	return nil
+}
+
+// jsonSweep is the -output json document of a sweep. A -sweep-clients run
+// fills Steps, a -sweep-value-size run ByValueSize and a -sweep-batch-keys
+// run ByBatchKeys.
+type jsonSweep struct {
+	Steps       []*Report       `json:"steps,omitempty"`
+	ByValueSize []jsonValueStep `json:"by_value_size,omitempty"`
+	ByBatchKeys []jsonBatchStep `json:"by_batch_keys,omitempty"`
+	// StopReason explains why the sweep ended before its last step.
+	StopReason string `json:"stop_reason,omitempty"`
+}
+
+// jsonValueStep is one step of a value size sweep.
+type jsonValueStep struct {
+	ValueSize int     `json:"value_size"`
+	Result    *Report `json:"result"`
+}
+
+// jsonBatchStep is one step of a batch size sweep.
+type jsonBatchStep struct {
+	BatchKeys int     `json:"batch_keys"`
+	Result    *Report `json:"result"`
+}
+
+// writeSweep renders the sweep to -out, or to stdout when no file was given.
+func writeSweep(cfg *config, steps []*sweepStep) error {
+	var w io.Writer = cfg.stdout
+	if cfg.out != "" {
+		f, err := os.Create(cfg.out)
+		if err != nil {
+			return fmt.Errorf("create report file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	_, levels := cfg.sweepParam()
+	if cfg.output == outputJSON {
+		doc := &jsonSweep{}
+		for _, s := range steps {
+			rep := buildReport(s.cfg, s.res)
+			switch {
+			case len(cfg.sweepSizes) > 0:
+				doc.ByValueSize = append(doc.ByValueSize, jsonValueStep{ValueSize: s.level, Result: rep})
+			case len(cfg.sweepBatches) > 0:
+				doc.ByBatchKeys = append(doc.ByBatchKeys, jsonBatchStep{BatchKeys: s.level, Result: rep})
+			default:
+				doc.Steps = append(doc.Steps, rep)
+			}
+			if s.stopReason != "" && len(steps) < len(levels) {
+				doc.StopReason = s.stopReason
+			}
+		}
+		return writeJSON(w, doc)
+	}
+	printSweep(w, cfg, steps)
+	return nil
+}
+
+// printSweep writes one table row per step of a sweep.
+func printSweep(w io.Writer, cfg *config, steps []*sweepStep) {
+	param, levels := cfg.sweepParam()
+	fmt.Fprintf(w, "Sweep of %s against %s, workload: %s\n", param, cfg.addr, cfg.workload)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	batches := len(cfg.sweepBatches) > 0
+	txns := cfg.mix != nil && cfg.mix.share(opTxn) > 0
+	fmt.Fprintf(tw, "%s\tops/s\tread MB/s\twrite MB/s\tp50\tp99\terrors\t", param)
+	if batches {
+		fmt.Fprint(tw, "keys/s\tper-key p99\t")
+	}
+	if txns {
+		fmt.Fprint(tw, "aborts\tretries\t")
+	}
+	fmt.Fprintln(tw)
+	for _, s := range steps {
+		h := s.res.total.latency
+		fmt.Fprintf(tw, "%d\t%.0f\t%.2f\t%.2f\t%v\t%v\t%d\t", s.level, throughput(s.res),
+			megabytesPerSecond(s.res.total.bytesRead, s.res.elapsed()), megabytesPerSecond(s.res.total.bytesWritten, s.res.elapsed()),
+			h.Percentile(50).Round(time.Microsecond), h.Percentile(99).Round(time.Microsecond), s.res.total.errors())
+		if batches {
+			var keysPerSec float64
+			if b := buildBatch(s.cfg, s.res); b != nil {
+				keysPerSec = b.KeysPerSec
+			}
+			fmt.Fprintf(tw, "%.0f\t%v\t", keysPerSec, (h.Percentile(99) / time.Duration(s.level)).Round(time.Microsecond))
+		}
+		if txns {
+			// The abort rate shows how contention grows over the steps.
+			var t jsonTxn
+			if b := buildTxn(s.res.total); b != nil {
+				t = *b
+			}
+			fmt.Fprintf(tw, "%.2f%%\t%.2f\t", 100*t.AbortRate, t.MeanRetries)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	if last := steps[len(steps)-1]; last.stopReason != "" && len(steps) < len(levels) {
+		fmt.Fprintf(w, "Sweep stopped after %d of %d steps: %s\n", len(steps), len(levels), last.stopReason)
+	}
+}
@@ -0,0 +1,123 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestSweepRunsEachLevel(t *testing.T) {
+	mr := newTestServerAddr(t)
+	cfg := testConfig(t, "-addr", mr, "-sweep-clients", "1,3", "-sweep-cooldown", "0", "-sweep-flush", "-ops", "20")
+
+	steps, err := runSweep(context.Background(), cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 {
+		t.Fatalf("ran %d steps, want 2", len(steps))
+	}
+	for i, want := range []int{1, 3} {
+		s := steps[i]
+		if s.cfg.clients != want || s.cfg.poolSize != want {
+			t.Errorf("step %d ran %d clients on a pool of %d, want %d", i, s.cfg.clients, s.cfg.poolSize, want)
+		}
+		if got := s.res.total.attempts(); got != int64(20*want) {
+			t.Errorf("step %d issued %d operations, want %d", i, got, 20*want)
+		}
+	}
+}
+
+func TestSweepStopsOnErrorRate(t *testing.T) {
+	// Every operation but the PING checking the server fails.
+	addr := newFailingServer(t, "-ERR injected")
+	cfg := testConfig(t, "-addr", addr, "-sweep-clients", "1,2,4", "-sweep-cooldown", "0", "-ops", "5", "-max-error-rate", "0.5")
+
+	steps, err := runSweep(context.Background(), cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 1 || steps[0].stopReason == "" {
+		t.Errorf("sweep ran %d steps, want it to stop after the first", len(steps))
+	}
+}
+
+func TestParseClientList(t *testing.T) {
+	got, err := parseLevels("10, 50,100")
+	if err != nil || len(got) != 3 || got[2] != 100 {
+		t.Errorf("parseLevels = %v, %v", got, err)
+	}
+	for _, in := range []string{"", "10,,20", "0", "-5", "x"} {
+		if _, err := parseLevels(in); err == nil {
+			t.Errorf("parseLevels(%q) accepted", in)
+		}
+	}
+}
+
+func TestValueSizeSweep(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-sweep-value-size", "16,1024", "-sweep-cooldown", "0",
+		"-clients", "2", "-ops", "10", "-key-prefix", "vs:")
+
+	steps, err := runSweep(context.Background(), cfg)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 {
+		t.Fatalf("ran %d steps, want 2", len(steps))
+	}
+	for _, s := range steps {
+		if &s.cfg.values.Bytes()[0] != &cfg.values.Bytes()[0] {
+			t.Errorf("step %d allocated its own value pool", s.level)
+		}
+		if got, want := s.res.total.bytesWritten, int64(20*s.level); got != want {
+			t.Errorf("step %d wrote %d bytes, want %d", s.level, got, want)
+		}
+		// Every step writes into its own keyspace.
+		prefix := fmt.Sprintf("vs:v%d:", s.level)
+		var n int
+		for _, k := range mr.Keys() {
+			if strings.HasPrefix(k, prefix) {
+				n++
+				if v, _ := mr.Get(k); len(v) != s.level {
+					t.Errorf("key %s holds %d bytes, want %d", k, len(v), s.level)
+				}
+			}
+		}
+		if n != 20 {
+			t.Errorf("found %d keys under %s, want 20", n, prefix)
+		}
+	}
+}
+
+func TestValueSizeSweepReads(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-sweep-value-size", "16,1024", "-sweep-cooldown", "0",
+		"-clients", "2", "-ops", "10", "-workload", "get", "-keyspace", "10", "-preload", "10", "-key-prefix", "vr:")
+
+	steps, err := runSweep(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range steps {
+		// Every GET hits a preloaded key of the step's size.
+		if got, want := s.res.total.bytesRead, int64(20*s.level); got != want || s.res.total.bytesWritten != 0 {
+			t.Errorf("step %d read %d bytes, want %d, and wrote %d", s.level, got, want, s.res.total.bytesWritten)
+		}
+		if rep := buildReport(s.cfg, s.res); rep.ReadMBPerSec <= 0 {
+			t.Errorf("step %d reports %.2f read MB/s", s.level, rep.ReadMBPerSec)
+		}
+	}
+	var buf bytes.Buffer
+	printSweep(&buf, cfg, steps)
+	if !strings.Contains(buf.String(), "read MB/s") {
+		t.Errorf("sweep table:\n%s", buf.String())
+	}
+}
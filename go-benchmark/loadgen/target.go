@@ -0,0 +1,393 @@
+package loadgen
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"runtime"
+)
+
+// runTarget preloads, benchmarks, verifies and cleans up the server in
+// cfg.addr. Only the benchmark itself is part of the measured window.
+func runTarget(rootCtx context.Context, cfg *config) (*runResult, error) {
+	if err := cfg.checkTransport(); err != nil {
+		return nil, err
+	}
+	if cfg.protocol != protocolRedis {
+		return runProtocol(rootCtx, cfg)
+	}
+	if cfg.clientNamePrefix != "" {
+		cfg.namer = newClientNamer(cfg)
+	}
+	if cfg.client == clientRaw {
+		var err error
+		if cfg.negotiated, cfg.helloErr, err = negotiateResp(rootCtx, cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.addr, err)
+		}
+		if cfg.helloErr != "" {
+			cfg.logger.Warn("HELLO 3 rejected: running on RESP2", "target", cfg.addr, "reply", cfg.helloErr)
+		}
+	}
+	rdb, nodes := newClient(cfg)
+	defer rdb.Close()
+	if err := checkReachable(rootCtx, rdb); err != nil {
+		return nil, fmt.Errorf("%s: %w", cfg.addr, err)
+	}
+	version := serverVersion(rootCtx, rdb)
+	if cfg.shadowAddr != "" {
+		cfg.shadow = startShadow(cfg, rdb)
+		defer func() {
+			if cfg.shadow != nil {
+				cfg.shadow.finish(nil)
+				cfg.shadow = nil
+			}
+		}()
+		if err := checkReachable(rootCtx, cfg.shadow.rdb); err != nil {
+			return nil, fmt.Errorf("shadow %s: %w", cfg.shadowAddr, err)
+		}
+	}
+	if cfg.dbs != nil {
+		cfg.dbClients = openDBClients(cfg, rdb)
+		defer func() {
+			closeDBClients(cfg.dbClients)
+			cfg.dbClients = nil
+		}()
+	}
+	if cfg.poolStarved() {
+		cfg.logger.Warn("clients share a smaller pool: raise -pool-size to measure the server rather than the pool",
+			"clients", cfg.clients, "pool_size", cfg.effectivePoolSize())
+	}
+	if cfg.clientsCrowded() {
+		cfg.logger.Warn("more clients than the load generator can schedule promptly: latency includes waiting for a CPU",
+			"clients", cfg.clients, "gomaxprocs", runtime.GOMAXPROCS(0))
+	}
+
+	if cfg.usesCounters() {
+		if err := resetCounters(rootCtx, rdb, cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.addr, err)
+		}
+		if cfg.shadow != nil {
+			if err := resetCounters(rootCtx, cfg.shadow.rdb, cfg); err != nil {
+				return nil, fmt.Errorf("shadow %s: %w", cfg.shadowAddr, err)
+			}
+		}
+	}
+	if cfg.script != nil {
+		if err := prepareScript(rootCtx, rdb, cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.addr, err)
+		}
+	}
+	if cfg.workload == workloadQueue {
+		if err := resetQueues(rootCtx, rdb, cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.addr, err)
+		}
+	}
+
+	var preload *preloadResult
+	if (cfg.usesKeyspace() || cfg.trace != nil) && cfg.preload > 0 {
+		if cfg.dbClients == nil {
+			preload = preloadKeys(rootCtx, rdb, cfg)
+			if cfg.shadow != nil {
+				// The shadow starts from the same keyspace.
+				printPreload(cfg.logWriter(slog.LevelInfo), preloadKeys(rootCtx, cfg.shadow.rdb, cfg))
+			}
+		} else {
+			// Every database gets the whole keyspace.
+			preload = &preloadResult{evicted: -1}
+			for i, c := range cfg.dbClients {
+				preload.add(preloadKeys(rootCtx, c, cfg.forDB(cfg.dbs[i])))
+			}
+		}
+		printPreload(cfg.logWriter(slog.LevelInfo), preload)
+		if preload.oom != nil {
+			// Reads against a partially filled keyspace would be misleading.
+			return nil, fmt.Errorf("%s: preload aborted: %w", cfg.addr, preload.oom)
+		}
+	}
+
+	poolBefore := rdb.PoolStats()
+	var nodesBefore map[string]nodeSnapshot
+	if nodes != nil {
+		nodesBefore = nodes.snapshot()
+	}
+	var fill *pressureFill
+	var evictMon *evictMonitor
+	if cfg.evictPressure {
+		var err error
+		if fill, err = fillToPressure(rootCtx, rdb, cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.addr, err)
+		}
+		printPressureFill(cfg.logWriter(slog.LevelInfo), fill)
+		evictMon = startEvictMonitor(rdb)
+	}
+
+	var snapshots []infoSnapshot
+	var infoMon *infoMonitor
+	if cfg.captureInfo {
+		if s, err := captureInfo(rootCtx, rdb, infoBefore); err == nil {
+			snapshots = append(snapshots, s)
+		} else {
+			cfg.logger.Warn("INFO before the run failed", "err", err)
+		}
+		infoMon = startInfoMonitor(rdb, cfg)
+		if cfg.seriesOut != nil {
+			cfg.seriesOut.watchInfo(infoMon)
+		}
+	}
+	// The probe starts before the load and stops after it.
+	var probe *probeMonitor
+	if cfg.probe {
+		probe = startProbe(cfg)
+	}
+	if cfg.collectSlowlog {
+		cfg.slowlog = newSlowlogCollector(rdb)
+		defer func() { cfg.slowlog = nil }()
+	}
+	if cfg.watchNotifications {
+		cfg.notifyWatch = startNotifyWatcher(rootCtx, rdb, cfg)
+		if u := cfg.notifyWatch.unavailable; u != "" {
+			cfg.logger.Warn("keyspace notifications unavailable", "reason", u)
+		}
+		defer func() { cfg.notifyWatch = nil }()
+	}
+	if cfg.ttlSample > 0 {
+		cfg.ttlSampler = startTTLSampler(cfg)
+		defer func() { cfg.ttlSampler = nil }()
+	}
+	if cfg.verifyExpired > 0 {
+		cfg.expiredCheck = startExpiredChecker(cfg)
+		defer func() { cfg.expiredCheck = nil }()
+	}
+	if cfg.soak {
+		cfg.soakMonitor = startSoakMonitor(rdb, cfg.logger, cfg.soakLogInterval)
+		defer func() { cfg.soakMonitor = nil }()
+	}
+	if cfg.faultPlan != nil {
+		// Faults strike the run only, not the preparation before it.
+		cfg.faults = newFaultInjector(cfg)
+		rdb.AddHook(cfg.faults)
+		defer func() { cfg.faults = nil }()
+	}
+	if cfg.latencySplit {
+		// Added after the fault injector, so that injected delays count
+		// as round trip rather than as waiting for a connection.
+		rdb.AddHook(splitHook{})
+	}
+	var churnMon *expireChurnMonitor
+	if cfg.expireChurn {
+		churnMon = startExpireChurnMonitor(rdb, cfg.churnInterval)
+	}
+	if cfg.beforeRun != nil {
+		cfg.beforeRun()
+	}
+	res := runBenchmark(rootCtx, rdb, cfg)
+	// The checks and cleanup after the run outlive an interrupt.
+	ctx := context.WithoutCancel(rootCtx)
+	res.env.ServerVersion = version
+	if cfg.faults != nil {
+		cfg.faults.disarmed.Store(true)
+		res.faults = cfg.faults
+	}
+	if cfg.soakMonitor != nil {
+		cfg.soakMonitor.finish()
+		res.health = buildClientHealth(cfg, res.series, res.elapsed())
+		for _, warning := range res.health.Warnings {
+			cfg.logger.Warn("client health: " + warning)
+		}
+	}
+	if cfg.shadow != nil {
+		res.shadow = cfg.shadow.finish(res.total.ops[:])
+		cfg.shadow = nil
+	}
+	if cfg.ttlSampler != nil {
+		res.ttl = cfg.ttlSampler.finish()
+	}
+	if cfg.expiredCheck != nil {
+		cfg.logger.Info("reading back the keys of -verify-expired once their TTL passed", "ttl", cfg.expiredTTL, "grace", cfg.expiredGrace)
+		res.expired = cfg.expiredCheck.finish(rootCtx)
+	}
+	if cfg.slowlog != nil {
+		entries, unavailable := cfg.slowlog.collect(ctx)
+		if unavailable != "" {
+			cfg.logger.Warn("slowlog unavailable", "reason", unavailable)
+		}
+		res.slowlog = buildSlowlog(entries, unavailable, res.total.outliers)
+	}
+	if cfg.notifyWatch != nil {
+		var samples []expirySample
+		if cfg.notifyExpiries() && !res.partial {
+			samples = res.total.expirySamples
+		}
+		res.notify = cfg.notifyWatch.stop(rootCtx, rdb, samples, cfg.expiryGrace)
+	}
+	if probe != nil {
+		res.probe = buildProbe(cfg, probe.stop(), res)
+	}
+	if evictMon != nil {
+		series, evicted := evictMon.stop(rdb, res.start)
+		res.evict = buildEvict(fill, series, evicted, res)
+	}
+	if churnMon != nil {
+		res.expireChurn = buildExpireChurn(cfg, churnMon, churnMon.stop(res.start), res)
+	}
+	if infoMon != nil {
+		snapshots = append(snapshots, infoMon.stop()...)
+		if s, err := captureInfo(ctx, rdb, infoAfter); err == nil {
+			snapshots = append(snapshots, s)
+		}
+		res.info = buildInfo(cfg, snapshots, res.start)
+	}
+	res.preload = preload
+	if nodes != nil {
+		res.cluster = buildCluster(cfg, nodesBefore, nodes.snapshot())
+	}
+	if cfg.usesPool() {
+		res.pool = buildPool(cfg, poolBefore, rdb.PoolStats())
+	}
+	if cfg.namer != nil {
+		var list *clientListReport
+		if cfg.clientList {
+			list = captureClientList(ctx, rdb, cfg.namer)
+		}
+		res.clientNames = buildClientNames(cfg, list, res.pool)
+	}
+
+	// Verification runs after the measured window and is not part of it.
+	if cfg.verifyExpiry && !res.partial {
+		samples := res.total.expirySamples
+		cfg.logger.Info("verifying expiry", "keys", len(samples))
+		res.expiry = verifyExpiry(rootCtx, rdb, samples, cfg.expiryGrace, cfg.layout)
+	}
+
+	// Commands in flight at an interrupt still complete, so counters and
+	// queues are checked after partial runs too.
+	if t := res.total.counters; t != nil {
+		res.counters = verifyCounters(ctx, rdb, cfg, t)
+	}
+	if t := res.total.queue; t != nil {
+		res.queue = verifyQueues(ctx, rdb, cfg, t)
+	}
+	if cfg.verifyFinal && !res.partial {
+		cfg.logger.Info("checking final values", "keys", len(res.total.writes.keys))
+		res.final = verifyFinal(ctx, rdb, cfg, res.total.writes, preload != nil)
+		if len(res.final.discrepancies) > 0 {
+			if err := writeDiscrepancies(cfg.verifyFinalOut, res.final); err != nil {
+				cfg.logger.Error("cannot write -verify-final discrepancies", "err", err)
+			} else {
+				res.final.File = cfg.verifyFinalOut
+			}
+		}
+	}
+
+	// Cleanup also runs after an interrupted run, so it uses the
+	// uncancelled context.
+	if cfg.cleanup != "" {
+		res.cleanup = cleanupKeys(ctx, rdb, cfg)
+		printCleanup(cfg.logWriter(slog.LevelInfo), res.cleanup)
+	}
+	return res, nil
+}
+
+// exportResults appends the runs to -store and pushes them to -push, both
+// once their reports are written, and returns the exit status of the first
+// that failed.
+func exportResults(cfgs []*config, results []*runResult) int {
+	code := 0
+	if cfgs[0].storePath != "" {
+		code = storeResults(cfgs, results)
+	}
+	if cfgs[0].pushSink != nil {
+		if c := pushResults(cfgs, results); code == 0 {
+			code = c
+		}
+	}
+	return code
+}
+
+// checkBaseline handles -save-baseline and -compare-baseline and returns the
+// exit status they call for, 0 when the run may exit normally.
+func checkBaseline(cfg *config, res *runResult) int {
+	if cfg.saveBaseline == "" && cfg.baselinePath == "" {
+		return 0
+	}
+	if res.partial {
+		cfg.logger.Error("partial run: not saved or compared as a baseline")
+		return 1
+	}
+	rep := buildReport(cfg, res)
+	if cfg.saveBaseline != "" {
+		if err := saveBaseline(cfg.saveBaseline, rep); err != nil {
+			cfg.logger.Error(err.Error())
+			return 1
+		}
+	}
+	if cfg.baselinePath == "" {
+		return 0
+	}
+	base, err := loadBaseline(cfg.baselinePath)
+	if err != nil {
+		cfg.logger.Error(err.Error())
+		return 1
+	}
+	cmp, err := compareBaseline(base, rep, cfg.threshold)
+	if err != nil {
+		cfg.logger.Error("cannot compare with the baseline", "baseline", cfg.baselinePath, "err", err)
+		return 1
+	}
+	printBaselineComparison(cfg.logWriter(slog.LevelInfo), cmp)
+	if cmp.regressed() {
+		cfg.logger.Error("performance regressed against the baseline", "baseline", cfg.baselinePath)
+		return exitRegression
+	}
+	return 0
+}
+
+// checkVerification prints the failed end-of-run checks of runs whose
+// reports do not include them and returns the exit status they call for.
+func checkVerification(cfg *config, results []*runResult) int {
+	code := 0
+	for _, res := range results {
+		if res.counters != nil && res.counters.failed() {
+			printCounterReport(cfg.logWriter(slog.LevelError), res.counters)
+			code = exitVerifyFailed
+		}
+		if res.queue != nil && res.queue.failed() {
+			printQueueCheck(cfg.logWriter(slog.LevelError), res.queue)
+			code = exitVerifyFailed
+		}
+		if n := res.total.setWrong; n > 0 {
+			cfg.logger.Error("CORRECTNESS FAILURE: wrong SISMEMBER answers", "count", n)
+			code = exitVerifyFailed
+		}
+		if s := res.total.verify; s != nil && s.failed() > 0 {
+			cfg.logger.Error("CORRECTNESS FAILURE: read-backs did not return the value just written",
+				"count", s.failed(), "corrupt", s.corrupt, "stale", s.stale, "missing", s.missing)
+			code = exitVerifyFailed
+		}
+		if f := res.final; f != nil && f.failed() {
+			printFinalReport(cfg.logWriter(slog.LevelError), f)
+			code = exitVerifyFailed
+		}
+		if s := res.total.scan; s != nil && s.unexplained > 0 {
+			cfg.logger.Error("CORRECTNESS FAILURE: SCAN passes did not enumerate the keyspace", "count", s.unexplained)
+			code = exitVerifyFailed
+		}
+		if res.shadow.failed() {
+			printShadow(cfg.logWriter(slog.LevelError), res.shadow)
+			code = exitVerifyFailed
+		}
+	}
+	return code
+}
+
+// stopMetrics shuts the -metrics-addr and -pprof-addr servers down once all
+// runs are over, and closes the profiles.
+func stopMetrics(cfg *config) {
+	if cfg.metrics != nil {
+		cfg.metrics.shutdown()
+	}
+	if cfg.profiler != nil {
+		cfg.profiler.close()
+	}
+}
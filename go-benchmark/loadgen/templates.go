@@ -0,0 +1,148 @@
+package loadgen
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"go-benchmark/workload"
+)
+
+// -key-template and -value-template shape the keys and values of a run
+// like an application's, user:{seq}:profile rather than key42. A key is
+// -key-prefix followed by its template: {seq} is the index of a key of the
+// keyspace, or the number of a fresh key of the set workload, and {worker}
+// the client writing a fresh key. A value's {seq} is the number of the
+// operation, or the index of the key for the preload. The random
+// placeholders of a key draw from the seed and its numbers alone, so every
+// client, the preload and the checks name the same keys; those of a value
+// from the generator of its client.
+
+// templateSalt separates the generator of the key templates from the
+// others derived from -seed.
+const templateSalt = 0x74706c
+
+// maxCollisionCheck bounds the keyspaces whose templated keys are checked
+// for duplicates one by one; larger ones are judged by the template alone.
+const maxCollisionCheck = 1 << 20
+
+// validateTemplates compiles -key-template and -value-template and counts
+// the keys of the keyspace the key template gives to more than one index.
+func (c *config) validateTemplates() error {
+	if c.keyTemplateSpec == "" && c.valueTemplateSpec == "" {
+		return nil
+	}
+	if c.replayPath != "" || c.recordPath != "" {
+		return errors.New("-key-template and -value-template do not apply to -record or -replay, whose traces hold keys and value offsets of their own")
+	}
+	if c.keyTemplateSpec != "" {
+		t, err := workload.ParseTemplate(c.keyTemplateSpec)
+		if err != nil {
+			return fmt.Errorf("-key-template: %w", err)
+		}
+		switch {
+		case c.keySize > 0:
+			return errors.New("-key-size does not apply to -key-template, whose {pad:N} sizes the keys")
+		case c.usesKeyspace() && t.UsesWorker():
+			return errors.New("-key-template: {worker} only applies to the fresh keys of the set workload, as every client shares the keyspace")
+		case !c.usesKeyspace() && c.workload != workloadSet:
+			return fmt.Errorf("-key-template does not apply to the %s workload, whose keys are its own", c.workload)
+		}
+		if c.protocol == protocolMemcache || c.protocol2 == protocolMemcache {
+			if strings.IndexFunc(c.keyTemplateSpec, func(r rune) bool { return r <= ' ' || r == 0x7f }) >= 0 {
+				return fmt.Errorf("-key-template %q: memcached keys cannot hold spaces or control characters", c.keyTemplateSpec)
+			}
+			// Fresh keys are numbered by a random non-negative int.
+			maxSeq := int64(math.MaxInt)
+			if c.usesKeyspace() {
+				maxSeq = int64(c.keyspace - 1 + c.keyOffset)
+			}
+			if n := len(c.keyPrefix) + t.MaxLen(maxSeq, c.clients-1+c.clientOffset); n > memcacheMaxKey {
+				return fmt.Errorf("-key-template gives keys of up to %d bytes, beyond the %d of a memcached key", n, memcacheMaxKey)
+			}
+		}
+		c.keyTemplate = t
+		c.templateSeed = mix64(uint64(c.seed) ^ templateSalt)
+		if c.usesKeyspace() {
+			c.keyCollisions, c.keyCollisionsExact = c.countKeyCollisions()
+			if c.keyCollisions > 0 && c.verifyFinal {
+				return fmt.Errorf("-verify-final tracks the keyspace by index, and -key-template gives %d of its indexes the key of another", c.keyCollisions)
+			}
+		}
+	}
+	if c.valueTemplateSpec != "" {
+		t, err := workload.ParseTemplate(c.valueTemplateSpec)
+		if err != nil {
+			return fmt.Errorf("-value-template: %w", err)
+		}
+		switch {
+		case c.valueSize > 0 || c.valueSizeRange != "" || len(c.sweepSizes) > 0 || c.large != nil:
+			return errors.New("-value-template cannot be combined with -value-size, -value-size-range, -sweep-value-size or -large-values: the template sizes the values")
+		case t.UsesWorker():
+			return errors.New("-value-template: {worker} only applies to -key-template")
+		}
+		c.valueTemplate = t
+	}
+	return nil
+}
+
+// templateKey returns the templated key numbered seq, of worker.
+func (c *config) templateKey(seq, worker int) string {
+	b := make([]byte, 0, 64)
+	b = append(b, c.keyPrefix...)
+	return string(c.keyTemplate.Append(b, int64(seq), worker, c.templateSeed))
+}
+
+// countKeyCollisions returns how many indexes of the keyspace the key
+// template gives a key an earlier one already has, and whether it counted
+// them: a keyspace larger than maxCollisionCheck is judged by the template
+// alone, which gives every index its own key with {seq} and otherwise the
+// expected count of a random draw.
+func (c *config) countKeyCollisions() (int, bool) {
+	if c.keyspace > maxCollisionCheck {
+		if c.keyTemplate.UsesSeq() {
+			return 0, false
+		}
+		choices, ok := c.keyTemplate.Choices()
+		if !ok {
+			return 0, false
+		}
+		n, m := float64(c.keyspace), float64(choices)
+		// The keys of m drawn at least once in n draws.
+		distinct := m * -math.Expm1(n*math.Log1p(-1/m))
+		return int(n - distinct), false
+	}
+	hashes := make([]uint64, c.keyspace)
+	for i := range hashes {
+		hashes[i] = keyHash(c.keyName(i))
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	dups := 0
+	for i := 1; i < len(hashes); i++ {
+		if hashes[i] == hashes[i-1] {
+			dups++
+		}
+	}
+	return dups, true
+}
+
+// printTemplates writes the template lines of the summary.
+func printTemplates(w io.Writer, cfg *config) {
+	if cfg.keyTemplate != nil {
+		fmt.Fprintf(w, "Key template: %q\n", cfg.keyTemplateSpec)
+		if n := cfg.keyCollisions; n > 0 {
+			about := ""
+			if !cfg.keyCollisionsExact {
+				about = "about "
+			}
+			fmt.Fprintf(w, "WARNING: -key-template gives %s%d of the %d keys of the keyspace the key of another; they share it, and the keyspace holds that many fewer keys\n",
+				about, n, cfg.keyspace)
+		}
+	}
+	if cfg.valueTemplate != nil {
+		fmt.Fprintf(w, "Value template: %q\n", cfg.valueTemplateSpec)
+	}
+}
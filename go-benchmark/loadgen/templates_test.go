@@ -0,0 +1,133 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTemplatedRun(t *testing.T) {
+	mr, _ := newTestServer(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-ops", "50", "-workload", "mixed", "-ratio", "get=0.5,set=0.5", "-keyspace", "20",
+		"-preload", "20", "-key-prefix", "t:", "-key-template", "user:{seq}:profile",
+		"-value-template", `{"id":{seq},"token":"{rand:uuid}","bio":"{pad:20}"}`)
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.total.errors() != 0 {
+		t.Fatalf("%d errors", res.total.errors())
+	}
+	keys := mr.Keys()
+	if len(keys) != 20 {
+		t.Fatalf("keys %v", keys)
+	}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "t:user:") || !strings.HasSuffix(key, ":profile") {
+			t.Errorf("key %q", key)
+		}
+		v, _ := mr.Get(key)
+		var doc struct {
+			ID    int    `json:"id"`
+			Token string `json:"token"`
+			Bio   string `json:"bio"`
+		}
+		if err := json.Unmarshal([]byte(v), &doc); err != nil || len(doc.Token) != 36 || doc.Bio != strings.Repeat("x", 20) {
+			t.Errorf("value %q of %s: %v", v, key, err)
+		}
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), `Key template: "user:{seq}:profile"`) || strings.Contains(buf.String(), "WARNING: -key-template") {
+		t.Errorf("summary:\n%s", buf.String())
+	}
+	if rep := buildReport(cfg, res); rep.Config.KeyTemplate != "user:{seq}:profile" || rep.Config.ValueTemplate == "" {
+		t.Errorf("report config %+v", rep.Config)
+	}
+
+	// -verify reads the templated keys back, sealing the values.
+	cfg = testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-ops", "50", "-key-template", "sess:{rand:uuid}",
+		"-value-template", "v{seq}{pad:40}", "-verify")
+	if res, err = runTarget(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if res.verifyFailed() {
+		t.Error("verification failed")
+	}
+}
+
+func TestKeyTemplateFollowsTheSeed(t *testing.T) {
+	args := []string{"-workload", "get", "-keyspace", "100", "-key-prefix", "", "-key-template", "sess:{rand:uuid}"}
+	a := testConfig(t, append(args, "-seed", "1")...)
+	b := testConfig(t, append(args, "-seed", "1")...)
+	c := testConfig(t, append(args, "-seed", "2")...)
+	if a.keyName(7) != b.keyName(7) || a.keyName(7) == c.keyName(7) || a.keyName(7) == a.keyName(8) {
+		t.Errorf("keys %q, %q, %q, %q", a.keyName(7), b.keyName(7), c.keyName(7), a.keyName(8))
+	}
+	// Fresh keys name their client.
+	s := testConfig(t, "-key-prefix", "", "-key-template", "w{worker}-{seq}")
+	if got := s.uniqueKey(3, 9); got != "w3-9" {
+		t.Errorf("fresh key %q", got)
+	}
+}
+
+func TestKeyTemplateCollisions(t *testing.T) {
+	cfg := testConfig(t, "-workload", "get", "-keyspace", "100", "-key-template", "k{rand:int:1-10}")
+	if cfg.keyCollisions != 90 || !cfg.keyCollisionsExact {
+		t.Fatalf("%d collisions, exact %v", cfg.keyCollisions, cfg.keyCollisionsExact)
+	}
+	var buf bytes.Buffer
+	printTemplates(&buf, cfg)
+	if !strings.Contains(buf.String(), "WARNING: -key-template gives 90 of the 100 keys of the keyspace the key of another") {
+		t.Errorf("summary:\n%s", buf.String())
+	}
+
+	// Operations on colliding indexes share the key.
+	mr, _ := newTestServer(t)
+	cfg = testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-ops", "50", "-workload", "get", "-keyspace", "100",
+		"-preload", "100", "-key-template", "k{rand:int:1-10}")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(mr.Keys()); n != 10 || res.total.errors() != 0 {
+		t.Errorf("%d keys, %d errors", n, res.total.errors())
+	}
+
+	// A keyspace too large to check key by key is estimated.
+	big := testConfig(t, "-workload", "get", "-keyspace", "2000000", "-preload", "0", "-key-template", "k{rand:int:1-1000000}")
+	if big.keyCollisions < 1100000 || big.keyCollisions > 1200000 || big.keyCollisionsExact {
+		t.Errorf("%d collisions, exact %v", big.keyCollisions, big.keyCollisionsExact)
+	}
+	seq := testConfig(t, "-workload", "get", "-keyspace", "2000000", "-preload", "0", "-key-template", "k{seq}")
+	if seq.keyCollisions != 0 {
+		t.Errorf("%d collisions with {seq}", seq.keyCollisions)
+	}
+
+	_, err = parseFlags([]string{"-key-template", "k{rand:int:1-10}", "-keyspace", "100", "-verify-final", "-verify-final-out", "final.json"})
+	if err == nil || !strings.Contains(err.Error(), "-verify-final tracks the keyspace by index") {
+		t.Errorf("-verify-final with colliding keys: %v", err)
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	for _, args := range [][]string{
+		{"-key-template", "user:{seq"},
+		{"-key-template", "{rand:int:9-1}"},
+		{"-value-template", "{unknown}"},
+		{"-key-template", "k{seq}", "-key-size", "64"},
+		{"-key-template", "k{worker}", "-workload", "get"},
+		{"-key-template", "k{seq}", "-workload", "queue"},
+		{"-value-template", "v{seq}", "-value-size", "64"},
+		{"-value-template", "v{worker}"},
+		{"-key-template", "k{seq}", "-record", "x.trace"},
+		{"-key-template", "k {seq}", "-protocol", "memcache"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v accepted", args)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// A -scenario file listing tenants runs them at the same time, each with
+// workers and a key prefix of its own, to measure how they interfere:
+//
+//	calibrate: true
+//	tenants:
+//	  - name: sessions
+//	    ratio: get=0.9,set=0.1
+//	    rate: 5000
+//	    clients: 20
+//	  - name: feeds
+//	    value-size: 4096
+//	    rate: 500
+//
+// A tenant sets flags over the command line as a phase does. Its key prefix
+// defaults to the command line's followed by its name and a colon. With
+// calibrate, every tenant first runs alone, one after the other, so its p99
+// alone can be set against its p99 among the others.
+
+// validateTenants builds the configuration of every tenant of file from
+// base, the flags of the command line.
+func (c *config) validateTenants(file *scenarioFile, base map[string]string) error {
+	if c.metricsAddr != "" {
+		return errors.New("-metrics-addr serves one run, not -scenario tenants running together")
+	}
+	names := make(map[string]bool)
+	for i, t := range file.tenants {
+		switch {
+		case t.name == "":
+			return fmt.Errorf("-scenario %s: tenant %d: line %d: a tenant needs a name", c.scenario, i+1, t.line)
+		case names[t.name]:
+			return fmt.Errorf("-scenario %s: tenant %d: line %d: tenant %s given twice", c.scenario, i+1, t.line, t.name)
+		case !t.run:
+			return fmt.Errorf("-scenario %s: tenant %s: line %d: run applies to phases", c.scenario, t.name, t.line)
+		}
+		names[t.name] = true
+		flags, set := phaseFlags(base, t)
+		if !set["key-prefix"] {
+			flags["key-prefix"] = c.keyPrefix + t.name + ":"
+		}
+		var err error
+		if t.cfg, err = parseArgs(scenarioArgs(flags), io.Discard); err != nil {
+			return fmt.Errorf("-scenario %s: tenant %s: %w", c.scenario, t.name, scenarioError(err, t))
+		}
+		if file.calibrate {
+			// The calibration run leaves its keys to the run together,
+			// which then reads the keyspace it preloaded.
+			alone := maps.Clone(flags)
+			delete(alone, "cleanup")
+			if t.aloneCfg, err = parseArgs(scenarioArgs(alone), io.Discard); err != nil {
+				return fmt.Errorf("-scenario %s: tenant %s: %w", c.scenario, t.name, scenarioError(err, t))
+			}
+			if t.aloneCfg.preload > 0 {
+				flags["keyspace"], flags["preload"] = strconv.Itoa(t.aloneCfg.keyspace), "0"
+			}
+			if t.cfg, err = parseArgs(scenarioArgs(flags), io.Discard); err != nil {
+				return fmt.Errorf("-scenario %s: tenant %s: %w", c.scenario, t.name, scenarioError(err, t))
+			}
+		}
+	}
+	// Each tenant's keys must be its own: no prefix may start another.
+	for i, a := range file.tenants {
+		for _, b := range file.tenants[i+1:] {
+			pa, pb := a.cfg.keyPrefix, b.cfg.keyPrefix
+			if strings.HasPrefix(pa, pb) || strings.HasPrefix(pb, pa) {
+				return fmt.Errorf("-scenario %s: the key prefixes of tenants %s (%q) and %s (%q) collide", c.scenario, a.name, pa, b.name, pb)
+			}
+		}
+	}
+	c.tenants, c.calibrate = file.tenants, file.calibrate
+	return nil
+}
+
+// runTenants runs the calibration passes of the tenants, one after the
+// other, then all tenants together. Each tenant prepares its run, preload
+// included, before any starts to measure, so their measured windows
+// overlap from the start.
+func runTenants(rootCtx context.Context, cfg *config) error {
+	for _, t := range cfg.tenants {
+		t.cfg.logger, t.cfg.logOut = cfg.logger, cfg.logOut
+		if t.aloneCfg != nil {
+			t.aloneCfg.logger, t.aloneCfg.logOut = cfg.logger, cfg.logOut
+		}
+	}
+	if cfg.calibrate {
+		for _, t := range cfg.tenants {
+			if rootCtx.Err() != nil {
+				return nil
+			}
+			cfg.logger.Info("scenario tenant calibration", "tenant", t.name)
+			res, err := runTarget(rootCtx, t.aloneCfg)
+			if err != nil {
+				return fmt.Errorf("tenant %s alone: %w", t.name, err)
+			}
+			t.alone = res
+		}
+	}
+	if rootCtx.Err() != nil {
+		return nil
+	}
+	cfg.logger.Info("scenario tenants", "tenants", len(cfg.tenants))
+	var ready, done sync.WaitGroup
+	errs := make([]error, len(cfg.tenants))
+	ready.Add(len(cfg.tenants))
+	for i, t := range cfg.tenants {
+		i, t := i, t
+		var arrived sync.Once
+		arrive := func() { arrived.Do(ready.Done) }
+		t.cfg.beforeRun = func() {
+			arrive()
+			ready.Wait()
+		}
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			// A tenant that fails before its run must not hold the
+			// others back.
+			defer arrive()
+			res, err := runTarget(rootCtx, t.cfg)
+			if err != nil {
+				errs[i] = fmt.Errorf("tenant %s: %w", t.name, err)
+				return
+			}
+			t.res = res
+		}()
+	}
+	done.Wait()
+	return errors.Join(errs...)
+}
+
+// tenantsCombined sums the tenants run together, over the wall-clock span
+// of their measured windows.
+type tenantsCombined struct {
+	Tenants        int             `json:"tenants"`
+	Operations     int64           `json:"operations"`
+	Errors         int64           `json:"errors"`
+	ElapsedSeconds float64         `json:"elapsed_seconds"`
+	Throughput     float64         `json:"throughput_ops_per_sec"`
+	Latency        *latencySummary `json:"latency"`
+}
+
+func buildTenantsCombined(cfg *config) *tenantsCombined {
+	c := &tenantsCombined{}
+	latency := cfg.layout.New()
+	var start, end time.Time
+	for _, t := range cfg.tenants {
+		if t.res == nil {
+			continue
+		}
+		c.Tenants++
+		latency.Merge(t.res.total.latency)
+		c.Errors += t.res.total.errors()
+		if start.IsZero() || t.res.start.Before(start) {
+			start = t.res.start
+		}
+		if t.res.end.After(end) {
+			end = t.res.end
+		}
+	}
+	c.Operations = latency.Count()
+	if span := end.Sub(start); span > 0 {
+		c.ElapsedSeconds = span.Seconds()
+		c.Throughput = float64(c.Operations) / span.Seconds()
+	}
+	c.Latency = summarizeLatency(latency)
+	return c
+}
+
+// tenantInterference compares the p99 of a tenant alone with its p99
+// among the others.
+type tenantInterference struct {
+	P99AloneNs    int64   `json:"p99_alone_ns"`
+	P99TogetherNs int64   `json:"p99_together_ns"`
+	Slowdown      float64 `json:"slowdown"`
+}
+
+// interference returns the interference of t, nil without a calibration
+// run or without operations in either.
+func (t *scenarioPhase) interference() *tenantInterference {
+	if t.alone == nil || t.res == nil || t.alone.total.latency.Count() == 0 || t.res.total.latency.Count() == 0 {
+		return nil
+	}
+	i := &tenantInterference{
+		P99AloneNs:    int64(t.alone.total.latency.Percentile(99)),
+		P99TogetherNs: int64(t.res.total.latency.Percentile(99)),
+	}
+	if i.P99AloneNs > 0 {
+		i.Slowdown = float64(i.P99TogetherNs) / float64(i.P99AloneNs)
+	}
+	return i
+}
+
+// jsonTenants is the -output json document of a -scenario run of tenants.
+type jsonTenants struct {
+	Tenants  []jsonTenant     `json:"tenants"`
+	Combined *tenantsCombined `json:"combined"`
+}
+
+// jsonTenant is one tenant: its run among the others and, with calibrate,
+// its run alone.
+type jsonTenant struct {
+	Name         string              `json:"name"`
+	KeyPrefix    string              `json:"key_prefix"`
+	Result       *Report             `json:"result,omitempty"`
+	Alone        *Report             `json:"alone,omitempty"`
+	Interference *tenantInterference `json:"interference,omitempty"`
+}
+
+// writeTenants renders the tenants to -out, or to stdout when no file was
+// given.
+func writeTenants(cfg *config) error {
+	var w io.Writer = cfg.stdout
+	if cfg.out != "" {
+		f, err := os.Create(cfg.out)
+		if err != nil {
+			return fmt.Errorf("create report file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if cfg.output == outputJSON {
+		doc := &jsonTenants{Combined: buildTenantsCombined(cfg)}
+		for _, t := range cfg.tenants {
+			jt := jsonTenant{Name: t.name, KeyPrefix: t.cfg.keyPrefix, Interference: t.interference()}
+			if t.res != nil {
+				jt.Result = buildReport(t.cfg, t.res)
+			}
+			if t.alone != nil {
+				jt.Alone = buildReport(t.aloneCfg, t.alone)
+			}
+			doc.Tenants = append(doc.Tenants, jt)
+		}
+		return writeJSON(w, doc)
+	}
+	printTenants(w, cfg)
+	return nil
+}
+
+// printTenants writes the report of every tenant, then a table of them
+// and the combined figures.
+func printTenants(w io.Writer, cfg *config) {
+	for _, t := range cfg.tenants {
+		if t.res == nil {
+			continue
+		}
+		fmt.Fprintf(w, "=== Tenant %s ===\n", t.name)
+		printSummary(w, t.cfg, t.res)
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "Scenario %s against %s: %d tenants together\n", cfg.scenario, cfg.addr, len(cfg.tenants))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	header := "tenant\tkey prefix\tclients\telapsed\tops\tops/s\tp50\tp99\terrors\t"
+	if cfg.calibrate {
+		header += "p99 alone\tslowdown\t"
+	}
+	fmt.Fprintln(tw, header)
+	for _, t := range cfg.tenants {
+		if t.res == nil {
+			fmt.Fprintf(tw, "%s\t%s\t%d\t-\t-\t-\t-\t-\t-\t\n", t.name, t.cfg.keyPrefix, t.cfg.clients)
+			continue
+		}
+		h := t.res.total.latency
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%v\t%d\t%.0f\t%v\t%v\t%d\t", t.name, t.cfg.keyPrefix, t.cfg.clients,
+			t.res.elapsed().Round(time.Millisecond), h.Count(), throughput(t.res),
+			h.Percentile(50).Round(time.Microsecond), h.Percentile(99).Round(time.Microsecond), t.res.total.errors())
+		if cfg.calibrate {
+			if i := t.interference(); i != nil {
+				fmt.Fprintf(tw, "%v\t%.2fx\t", time.Duration(i.P99AloneNs).Round(time.Microsecond), i.Slowdown)
+			} else {
+				fmt.Fprint(tw, "-\t-\t")
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	c := buildTenantsCombined(cfg)
+	fmt.Fprintf(w, "Combined: %d operations in %v (%.0f ops/s), %d errors",
+		c.Operations, time.Duration(c.ElapsedSeconds*float64(time.Second)).Round(time.Millisecond), c.Throughput, c.Errors)
+	if c.Latency != nil {
+		fmt.Fprintf(w, ", p99 %v", time.Duration(c.Latency.P99Ns).Round(time.Microsecond))
+	}
+	fmt.Fprintln(w)
+	if c.Tenants < len(cfg.tenants) {
+		fmt.Fprintf(w, "Only %d of %d tenants ran\n", c.Tenants, len(cfg.tenants))
+	}
+}
@@ -0,0 +1,100 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestTenantValidation(t *testing.T) {
+	for doc, want := range map[string]string{
+		"tenants:\n  - ops: 10\n":               "tenant 1: line 2: a tenant needs a name",
+		"tenants:\n  - name: a\n  - name: a\n":  "tenant 2: line 3: tenant a given twice",
+		"tenants:\n  - name: a\n    rate: -5\n": "tenant a: line 3: -rate",
+		"tenants:\n  - name: a\n    key-prefix: app\n  - name: b\n    key-prefix: app:b\n": `tenants a ("app") and b ("app:b") collide`,
+		"tenants:\n  - name: a\n    run: false\n":                                          "run applies to phases",
+		"tenants:\n  - name: a\nphases:\n  - ops: 1\n":                                     "phases and tenants cannot be combined",
+		"calibrate: true\nphases:\n  - ops: 1\n":                                           "calibrate applies to tenants",
+		"calibrate: often\ntenants:\n  - name: a\n":                                        "line 1: calibrate must be true or false",
+	} {
+		path := writeScenarioFile(t, doc)
+		if _, err := parseFlags([]string{"-scenario", path}); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: error %v, want %q", doc, err, want)
+		}
+	}
+}
+
+func TestScenarioRunsTenants(t *testing.T) {
+	mr := miniredis.RunT(t)
+	path := writeScenarioFile(t, `calibrate: true
+tenants:
+  - name: sessions
+    workload: get
+    preload: 100
+    clients: 2
+  - name: feeds
+    value-size: 512
+    rate: 200
+    ops: 20
+`)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "1", "-ops", "50", "-key-prefix", "mt:", "-scenario", path)
+	sessions, feeds := cfg.tenants[0], cfg.tenants[1]
+	if sessions.cfg.keyPrefix != "mt:sessions:" || feeds.cfg.keyPrefix != "mt:feeds:" || sessions.cfg.preload != 0 ||
+		sessions.aloneCfg.preload != 100 || feeds.cfg.valueSize != 512 {
+		t.Fatalf("tenants: prefixes %q and %q, preload %d alone and %d together", sessions.cfg.keyPrefix, feeds.cfg.keyPrefix,
+			sessions.aloneCfg.preload, sessions.cfg.preload)
+	}
+
+	if err := runTenants(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	for _, tn := range cfg.tenants {
+		if tn.res == nil || tn.alone == nil || tn.interference() == nil {
+			t.Fatalf("tenant %s: run %v, alone %v", tn.name, tn.res, tn.alone)
+		}
+	}
+	// The sessions preloaded alone, then read their keys back together.
+	if r := sessions.res.total; r.ops[opGet].hits != 100 {
+		t.Errorf("sessions hit %d of 100 keys", r.ops[opGet].hits)
+	}
+	// Started together, the measured windows overlap.
+	if sessions.res.start.After(feeds.res.end) || feeds.res.start.After(sessions.res.end) {
+		t.Errorf("windows %v-%v and %v-%v do not overlap", sessions.res.start, sessions.res.end, feeds.res.start, feeds.res.end)
+	}
+	for _, k := range mr.Keys() {
+		if !strings.HasPrefix(k, "mt:sessions:") && !strings.HasPrefix(k, "mt:feeds:") {
+			t.Errorf("key %q outside the tenants' namespaces", k)
+		}
+	}
+
+	var buf bytes.Buffer
+	printTenants(&buf, cfg)
+	for _, want := range []string{"=== Tenant sessions ===", "=== Tenant feeds ===", "2 tenants together", "p99 alone", "Combined: 120 operations"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, buf.String())
+		}
+	}
+
+	cfg.output, cfg.out = outputJSON, filepath.Join(t.TempDir(), "report.json")
+	if err := writeTenants(cfg); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(cfg.out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc jsonTenants
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Tenants) != 2 || doc.Tenants[1].Name != "feeds" || doc.Tenants[1].Alone == nil || doc.Tenants[1].Interference == nil ||
+		doc.Combined.Operations != 120 || doc.Combined.Tenants != 2 {
+		t.Errorf("JSON document %+v", doc)
+	}
+}
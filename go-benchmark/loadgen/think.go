@@ -0,0 +1,174 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Distributions of -think-time: a bare duration pauses that long every time,
+// exp:MEAN draws exponential pauses of that mean and uniform:MIN-MAX pauses
+// evenly spread over the range.
+const (
+	thinkFixed   = "fixed"
+	thinkExp     = "exp"
+	thinkUniform = "uniform"
+)
+
+// thinkSalt separates the think-time generators from the workers' command
+// generators, so that adding -think-time does not change the commands sent.
+const thinkSalt = 0x7468696e6b
+
+// thinkTime is the pause of -think-time a worker takes between two
+// operations, or two pipelines with -pipeline.
+type thinkTime struct {
+	kind     string
+	min, max time.Duration
+	// mean is the average pause, the min of a fixed one.
+	mean time.Duration
+}
+
+// parseThinkTime parses 5ms, exp:5ms or uniform:1ms-10ms.
+func parseThinkTime(s string) (*thinkTime, error) {
+	kind, arg, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		kind, arg = thinkFixed, kind
+	}
+	switch kind {
+	case thinkFixed, thinkExp:
+		d, err := time.ParseDuration(strings.TrimSpace(arg))
+		if err != nil {
+			return nil, fmt.Errorf("invalid think time %q: %v", s, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid think time %q: must be positive", s)
+		}
+		t := &thinkTime{kind: kind, mean: d, min: d, max: d}
+		if kind == thinkExp {
+			t.min = 0
+		}
+		return t, nil
+	case thinkUniform:
+		lo, hi, ok := strings.Cut(arg, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid think time %q: want uniform:MIN-MAX", s)
+		}
+		min, err := time.ParseDuration(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("invalid think time %q: %v", s, err)
+		}
+		max, err := time.ParseDuration(strings.TrimSpace(hi))
+		if err != nil {
+			return nil, fmt.Errorf("invalid think time %q: %v", s, err)
+		}
+		if min < 0 || max <= min {
+			return nil, fmt.Errorf("invalid think time %q: want 0 <= MIN < MAX", s)
+		}
+		return &thinkTime{kind: kind, min: min, max: max, mean: (min + max) / 2}, nil
+	}
+	return nil, fmt.Errorf("invalid think time %q: want a duration, %s:MEAN or %s:MIN-MAX", s, thinkExp, thinkUniform)
+}
+
+func (t *thinkTime) String() string {
+	switch t.kind {
+	case thinkExp:
+		return fmt.Sprintf("exponential, mean %v", t.mean)
+	case thinkUniform:
+		return fmt.Sprintf("uniform %v-%v", t.min, t.max)
+	}
+	return t.mean.String()
+}
+
+// next draws the next pause from rng.
+func (t *thinkTime) next(rng *rand.Rand) time.Duration {
+	switch t.kind {
+	case thinkExp:
+		return time.Duration(rng.ExpFloat64() * float64(t.mean))
+	case thinkUniform:
+		return t.min + time.Duration(rng.Int63n(int64(t.max-t.min)+1))
+	}
+	return t.mean
+}
+
+// validateThinkTime checks -think-time.
+func (c *config) validateThinkTime() error {
+	if c.thinkTimeSpec == "" {
+		return nil
+	}
+	switch {
+	case c.loop == loopOpen:
+		return errors.New("-think-time does not apply to -loop open, whose arrivals do not wait for the clients")
+	case c.trace != nil:
+		return errors.New("-think-time does not apply to -replay, which keeps the timing of the trace")
+	}
+	var err error
+	if c.think, err = parseThinkTime(c.thinkTimeSpec); err != nil {
+		return fmt.Errorf("-think-time: %w", err)
+	}
+	return nil
+}
+
+// pause sleeps for the worker's next think time outside of any measured
+// operation. It reports false when runCtx ended the run first.
+func (w *worker) pause(runCtx context.Context) bool {
+	return realClock{}.Sleep(runCtx, w.run.cfg.think.next(w.thinkRng))
+}
+
+// jsonThinkTime describes the -think-time of a run and the rate it offers.
+type jsonThinkTime struct {
+	Spec   string `json:"spec"`
+	MeanNs int64  `json:"mean_ns"`
+	// MaxOfferedRate is the rate the clients would offer against a server
+	// answering instantly; OfferedRate adds the measured round trip to
+	// every pause.
+	MaxOfferedRate float64 `json:"max_offered_rate"`
+	OfferedRate    float64 `json:"offered_rate,omitempty"`
+	AchievedRate   float64 `json:"achieved_rate"`
+}
+
+// buildThinkTime derives the rate offered by the closed loop of -think-time:
+// every client sends one operation, or one pipeline, per pause and round
+// trip.
+func buildThinkTime(cfg *config, res *runResult) *jsonThinkTime {
+	if cfg.think == nil {
+		return nil
+	}
+	total := res.total
+	elapsed := res.elapsed()
+	batch := float64(max(cfg.pipeline, 1))
+	j := &jsonThinkTime{
+		Spec:           cfg.thinkTimeSpec,
+		MeanNs:         int64(cfg.think.mean),
+		MaxOfferedRate: float64(cfg.clients) * batch / cfg.think.mean.Seconds(),
+	}
+	roundTrip := total.latency
+	if total.batch != nil {
+		roundTrip = total.batch
+	}
+	if roundTrip != nil && roundTrip.Count() > 0 {
+		j.OfferedRate = float64(cfg.clients) * batch / (cfg.think.mean + roundTrip.Mean()).Seconds()
+	}
+	if elapsed > 0 {
+		j.AchievedRate = float64(total.attempts()) / elapsed.Seconds()
+	}
+	return j
+}
+
+// printThinkTime writes the offered rate of -think-time next to the rate
+// achieved, which falls short of it when clients queue for connections or
+// the tool cannot keep up.
+func printThinkTime(w io.Writer, cfg *config, res *runResult) {
+	j := buildThinkTime(cfg, res)
+	if j == nil {
+		return
+	}
+	fmt.Fprintf(w, "Think time: %s, offered rate: at most %.0f ops/s", cfg.think, j.MaxOfferedRate)
+	if j.OfferedRate > 0 {
+		fmt.Fprintf(w, ", %.0f ops/s at the measured round trip", j.OfferedRate)
+	}
+	fmt.Fprintf(w, ", achieved: %.0f ops/s\n", j.AchievedRate)
+}
@@ -0,0 +1,93 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseThinkTime(t *testing.T) {
+	for _, tc := range []struct {
+		spec          string
+		min, max, avg time.Duration
+	}{
+		{"5ms", 5 * time.Millisecond, 5 * time.Millisecond, 5 * time.Millisecond},
+		{"exp:5ms", 0, 5 * time.Millisecond, 5 * time.Millisecond},
+		{"uniform:1ms-9ms", time.Millisecond, 9 * time.Millisecond, 5 * time.Millisecond},
+	} {
+		th, err := parseThinkTime(tc.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.spec, err)
+		}
+		if th.min != tc.min || th.max != tc.max || th.mean != tc.avg {
+			t.Errorf("%s parsed as %+v", tc.spec, th)
+		}
+		// The pauses average the mean and stay in range.
+		rng := rand.New(rand.NewSource(1))
+		var sum time.Duration
+		for i := 0; i < 10000; i++ {
+			d := th.next(rng)
+			if d < th.min || (th.kind != thinkExp && d > th.max) {
+				t.Fatalf("%s drew %v", tc.spec, d)
+			}
+			sum += d
+		}
+		if avg := sum / 10000; avg < tc.avg*9/10 || avg > tc.avg*11/10 {
+			t.Errorf("%s averaged %v", tc.spec, avg)
+		}
+	}
+	for _, spec := range []string{"", "0s", "-1ms", "exp:", "exp:0s", "uniform:5ms", "uniform:5ms-1ms", "normal:5ms"} {
+		if _, err := parseThinkTime(spec); err == nil {
+			t.Errorf("%q was accepted", spec)
+		}
+	}
+	for _, args := range [][]string{
+		{"-think-time", "soon"},
+		{"-think-time", "1ms", "-loop", "open", "-rate", "100"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
+
+func TestThinkTimeRun(t *testing.T) {
+	addr := newTestServerAddr(t)
+	cfg := testConfig(t, "-addr", addr, "-clients", "2", "-ops", "6", "-think-time", "20ms")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Five pauses between six operations, none of them in the latency.
+	if d := res.elapsed(); d < 100*time.Millisecond {
+		t.Errorf("6 operations 20ms apart took %v", d)
+	}
+	if d := res.total.latency.Maximum(); d >= 20*time.Millisecond {
+		t.Errorf("latency includes the think time: max %v", d)
+	}
+	rep := buildReport(cfg, res)
+	if th := rep.ThinkTime; th == nil || th.MaxOfferedRate != 100 || th.OfferedRate <= 0 || th.OfferedRate >= 100 || th.AchievedRate <= 0 {
+		t.Errorf("think time %+v", rep.ThinkTime)
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "Think time: 20ms, offered rate: at most 100 ops/s") {
+		t.Errorf("summary lacks the think time:\n%s", buf.String())
+	}
+
+	// A pause does not outlast the run.
+	cfg = testConfig(t, "-addr", addr, "-clients", "2", "-duration", "100ms", "-think-time", "exp:1h")
+	begin := time.Now()
+	if res, err = runTarget(context.Background(), cfg); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(begin); d > 2*time.Second {
+		t.Errorf("the run took %v", d)
+	}
+	if n := res.total.attempts(); n != 2 {
+		t.Errorf("%d operations, want one per client", n)
+	}
+}
@@ -0,0 +1,252 @@
+package loadgen
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sampleInterval is the resolution of the throughput time series.
+const sampleInterval = time.Second
+
+// timePoint is one interval of the time series. T is the end of the interval
+// in seconds since the measured window started, negative during -ramp-up;
+// Ops and Errors count what completed during the interval and Clients is
+// the number of clients running at its end.
+type timePoint struct {
+	T       float64 `json:"t"`
+	Ops     int64   `json:"ops"`
+	Errors  int64   `json:"errors"`
+	Clients int64   `json:"clients"`
+	// Offered counts the arrivals -loop open generated during the
+	// interval, which show the shape of -pattern.
+	Offered int64 `json:"offered,omitempty"`
+	// P99Ns is the p99 latency of the interval, from the coarse live
+	// histogram; only sampled when the run keeps one.
+	P99Ns int64 `json:"p99_ns,omitempty"`
+	// WindowP50Ns and WindowP99Ns are the percentiles over the
+	// -percentile-window ending with the interval, from the same live
+	// histogram.
+	WindowP50Ns int64 `json:"window_p50_ns,omitempty"`
+	WindowP99Ns int64 `json:"window_p99_ns,omitempty"`
+	// Outliers counts the -capture-outliers kept that started in the
+	// interval.
+	Outliers int64 `json:"outliers,omitempty"`
+	// The resources of the tool at the end of the interval under -soak:
+	// GCPauseNs is the total of its GC pauses since it started and
+	// OpenFDs 0 where the platform does not list open files.
+	Goroutines int    `json:"goroutines,omitempty"`
+	HeapInuse  uint64 `json:"heap_inuse_bytes,omitempty"`
+	GCPauseNs  uint64 `json:"gc_pause_ns,omitempty"`
+	OpenFDs    int    `json:"open_fds,omitempty"`
+	PoolConns  uint32 `json:"pool_connections,omitempty"`
+}
+
+// sampler periodically reads the live counters published by workers and
+// turns them into a time series. It only ever loads atomics, so it adds no
+// locking to the request path.
+type sampler struct {
+	live     *liveCounters
+	interval time.Duration
+	start    time.Time
+
+	lastOps      int64
+	lastErrors   int64
+	lastArrivals int64
+	lastT        time.Time
+	// lastClasses are the errors by class at the last sample, only kept
+	// for -timeseries-out.
+	lastClasses [numErrClasses]int64
+	// lastLatency is the live histogram at the last sample, nil when the
+	// run keeps none.
+	lastLatency *liveSnapshot
+	// window sums the intervals of -percentile-window; nil when disabled
+	// or the run keeps no live histogram.
+	window *slidingWindow
+
+	stopCh chan struct{}
+	done   sync.WaitGroup
+	points []timePoint
+}
+
+// startSampler begins sampling live every interval from now on. Point times
+// are relative to origin; window is the length of the windowed percentiles,
+// zero for none.
+func startSampler(live *liveCounters, interval time.Duration, origin time.Time, window time.Duration) *sampler {
+	s := &sampler{
+		live:     live,
+		interval: interval,
+		start:    origin,
+		stopCh:   make(chan struct{}),
+	}
+	s.lastT = time.Now()
+	s.lastOps, s.lastErrors, s.lastArrivals = live.ops.Load(), live.errors.Load(), live.arrivals.Load()
+	if live.seriesOut != nil {
+		s.lastClasses = live.errClassCounts()
+	}
+	if live.latency != nil {
+		s.lastLatency = live.latency.snapshot()
+		s.window = newSlidingWindow(window, interval)
+	}
+	s.done.Add(1)
+	go s.loop()
+	return s
+}
+
+func (s *sampler) loop() {
+	defer s.done.Done()
+	t := time.NewTicker(s.interval)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			s.sample(now)
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+func (s *sampler) sample(now time.Time) {
+	ops, errs, arrivals := s.live.ops.Load(), s.live.errors.Load(), s.live.arrivals.Load()
+	// The errors counter is a subset of ops; successful ops are the rest.
+	p := timePoint{
+		T:       now.Sub(s.start).Seconds(),
+		Ops:     (ops - s.lastOps) - (errs - s.lastErrors),
+		Errors:  errs - s.lastErrors,
+		Clients: s.live.active.Load(),
+		Offered: arrivals - s.lastArrivals,
+	}
+	if s.lastLatency != nil {
+		snap := s.live.latency.snapshot()
+		delta := snap.since(s.lastLatency)
+		p.P99Ns = int64(delta.percentile(99))
+		if s.window != nil {
+			s.window.add(delta)
+			p.WindowP50Ns, p.WindowP99Ns = int64(s.window.percentile(50)), int64(s.window.percentile(99))
+		}
+		s.lastLatency = snap
+	}
+	if m := s.live.soak; m != nil {
+		m.sample(&p)
+	}
+	if sw := s.live.seriesOut; sw != nil {
+		classes := s.live.errClassCounts()
+		var delta [numErrClasses]int64
+		for c := range classes {
+			delta[c] = classes[c] - s.lastClasses[c]
+		}
+		sw.add(p, s.lastT, now, delta)
+		s.lastClasses = classes
+	}
+	s.points = append(s.points, p)
+	s.lastOps, s.lastErrors, s.lastArrivals, s.lastT = ops, errs, arrivals, now
+}
+
+// errClassCounts loads the errors by class.
+func (l *liveCounters) errClassCounts() [numErrClasses]int64 {
+	var n [numErrClasses]int64
+	for c := range n {
+		n[c] = l.errClasses[c].Load()
+	}
+	return n
+}
+
+// stop ends sampling, records the final partial interval and returns the
+// series. The sampler must not be used afterwards.
+func (s *sampler) stop() []timePoint {
+	close(s.stopCh)
+	s.done.Wait()
+	if now := time.Now(); now.Sub(s.lastT) > s.interval/10 {
+		s.sample(now)
+	}
+	return s.points
+}
+
+// maxTableRows caps the per-interval table in text output; longer series
+// are summarised by the sparkline alone and remain complete in JSON.
+const maxTableRows = 60
+
+// sparkBlocks are the glyphs of the text sparkline, lowest to highest.
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline renders values on a single line scaled between their min and max.
+func sparkline(values []float64) string {
+	if len(values) == 0 {
+		return ""
+	}
+	lo, hi := values[0], values[0]
+	for _, v := range values {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+	var b strings.Builder
+	for _, v := range values {
+		idx := len(sparkBlocks) - 1
+		if hi > lo {
+			idx = int((v - lo) / (hi - lo) * float64(len(sparkBlocks)-1))
+		}
+		b.WriteRune(sparkBlocks[idx])
+	}
+	return b.String()
+}
+
+// printTimeSeries writes a sparkline of per-interval throughput followed by
+// a per-interval table for series short enough to read.
+// from is the start of the first interval and window the length of the
+// windowed percentiles.
+func printTimeSeries(w io.Writer, points []timePoint, from float64, window time.Duration) {
+	if len(points) == 0 {
+		return
+	}
+	rates := make([]float64, len(points))
+	prev := from
+	for i, p := range points {
+		rates[i] = float64(p.Ops) / (p.T - prev)
+		prev = p.T
+	}
+	lo, hi := rates[0], rates[0]
+	for _, r := range rates {
+		lo, hi = min(lo, r), max(hi, r)
+	}
+	fmt.Fprintf(w, "Throughput over time (min %.0f, max %.0f ops/s):\n  %s\n", lo, hi, sparkline(rates))
+	if len(points) > maxTableRows {
+		return
+	}
+	// The windowed percentile columns show when the run kept a live
+	// histogram, the outliers column when -capture-outliers kept some and
+	// the offered column under -loop open.
+	windowed, outliers, offered := false, false, false
+	for _, p := range points {
+		windowed = windowed || p.WindowP99Ns > 0
+		outliers = outliers || p.Outliers > 0
+		offered = offered || p.Offered > 0
+	}
+	header := "      t      ops/s   errors  clients"
+	if offered {
+		header += "  offered/s"
+	}
+	if windowed {
+		header += fmt.Sprintf(" %12s %12s", "p50/"+window.String(), "p99/"+window.String())
+	}
+	if outliers {
+		header += " outliers"
+	}
+	fmt.Fprintln(w, header)
+	prevT := from
+	for i, p := range points {
+		fmt.Fprintf(w, "  %6.1fs %9.0f %8d %8d", p.T, rates[i], p.Errors, p.Clients)
+		if offered {
+			fmt.Fprintf(w, " %10.0f", float64(p.Offered)/(p.T-prevT))
+		}
+		prevT = p.T
+		if windowed {
+			fmt.Fprintf(w, " %12v %12v", time.Duration(p.WindowP50Ns), time.Duration(p.WindowP99Ns))
+		}
+		if outliers {
+			fmt.Fprintf(w, " %8d", p.Outliers)
+		}
+		fmt.Fprintln(w)
+	}
+}
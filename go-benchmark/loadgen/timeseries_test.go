@@ -0,0 +1,40 @@
+package loadgen
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSamplerDeltas(t *testing.T) {
+	live := &liveCounters{}
+	live.ops.Add(5) // done before sampling starts, must not be counted
+	s := startSampler(live, 20*time.Millisecond, time.Now(), 0)
+	live.ops.Add(10)
+	live.errors.Add(2)
+	live.ops.Add(2)
+	time.Sleep(50 * time.Millisecond)
+	points := s.stop()
+
+	var ops, errs int64
+	for _, p := range points {
+		ops += p.Ops
+		errs += p.Errors
+	}
+	if ops != 10 || errs != 2 {
+		t.Errorf("series totals ops=%d errors=%d, want 10 and 2", ops, errs)
+	}
+	for i := 1; i < len(points); i++ {
+		if points[i].T <= points[i-1].T {
+			t.Errorf("timestamps not increasing: %v", points)
+		}
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	if got := sparkline([]float64{0, 7, 14}); got != "▁▄█" {
+		t.Errorf("sparkline = %q", got)
+	}
+	if got := sparkline([]float64{3, 3}); got != "██" {
+		t.Errorf("flat sparkline = %q", got)
+	}
+}
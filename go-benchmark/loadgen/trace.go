@@ -0,0 +1,531 @@
+package loadgen
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/workload"
+)
+
+// A -record trace is a header followed by chunks of records, each chunk
+// holding consecutive commands of one connection:
+//
+//	header: magic | prefix | seed | flags | preload | value min | value max | connections
+//	chunk:  connection | length | records
+//	record: command | offset delta (µs) | key | SET: value size, fill, TTL (ms) | EXPIRE: TTL (s)
+//
+// Integers are varints and strings are length-prefixed. Keys are stored
+// without the key prefix, which the replay puts back, and offsets count
+// from the previous command of the same connection, the first one from the
+// start of the run. A SET's fill is where its value starts in the value
+// pool, which the seed and value sizes rebuild, or the number of its legacy
+// "value<i>" string; with the connection and its count of SETs it
+// regenerates the value byte for byte. The only flag, traceRawValues, is
+// set for a run with -raw-values.
+const (
+	traceMagic = "htrace\x00\x01"
+	// traceChunkSize is how many bytes of records a worker buffers before
+	// handing them to the file.
+	traceChunkSize = 64 << 10
+	traceRawValues = 1
+)
+
+// Replay timings: original sleeps until each command's recorded offset,
+// fast sends the next command as soon as the last one completed.
+const (
+	replayOriginal = "original"
+	replayFast     = "fast"
+)
+
+// traceOps are the commands a trace can hold.
+var traceOps = map[opType]bool{opSet: true, opGet: true, opDel: true, opExpire: true}
+
+// replayGenerated are the flags shaping generated traffic, which a replay
+// takes from the trace instead.
+var replayGenerated = []string{
+	"workload", "ratio", "duration", "ops", "ttl", "expire-ttl", "value-size", "value-size-range",
+	"raw-values", "key-dist", "hot-keys", "churn", "verify", "ramp-up",
+}
+
+// replayOnly are the flags that only shape a -replay.
+var replayOnly = []string{"replay-timing", "replay-speed", "monitor-commands", "monitor-rewrite", "monitor-value-scale"}
+
+// validateRecord checks the flags of -record.
+func (c *config) validateRecord() error {
+	if c.workload != workloadSet {
+		if c.mix == nil {
+			return fmt.Errorf("-record does not apply to the %s workload", c.workload)
+		}
+		for _, op := range c.mix.ops {
+			if !traceOps[op] {
+				return fmt.Errorf("-record cannot trace %s: traces hold SET, GET, DEL and EXPIRE", opNames[op])
+			}
+		}
+	}
+	switch {
+	case c.replayPath != "":
+		return errors.New("-record cannot be combined with -replay")
+	case c.verify || c.evictPressure:
+		return errors.New("-record does not apply to -verify or -evict-pressure, whose values and fill it cannot regenerate")
+	case c.addr2 != "" || c.sweeping() || c.scenario != "":
+		return errors.New("-record cannot be combined with -addr2, sweeps or -scenario, which run more than once")
+	}
+	return nil
+}
+
+// validateReplay checks the flags of -replay, whose trace parseArgs read.
+func (c *config) validateReplay() error {
+	for _, name := range replayGenerated {
+		if _, ok := c.explicit[name]; ok {
+			return fmt.Errorf("-%s does not apply to -replay, which sends the commands of the trace", name)
+		}
+	}
+	switch c.replayTiming {
+	case replayOriginal:
+		if c.rate > 0 {
+			return errors.New("-rate does not apply to -replay-timing original, which keeps the recorded pace")
+		}
+	case replayFast:
+	default:
+		return fmt.Errorf("-replay-timing must be %q or %q, got %q", replayOriginal, replayFast, c.replayTiming)
+	}
+	if c.replaySpeed <= 0 || math.IsInf(c.replaySpeed, 0) || math.IsNaN(c.replaySpeed) {
+		return fmt.Errorf("-replay-speed must be positive, got %v", c.replaySpeed)
+	}
+	// The pool of the recording, seeded alike, regenerates its values.
+	t := c.trace
+	c.values = nil
+	if t.valueMax > 0 {
+		c.values = workload.NewValuePool(t.valueMin, t.valueMax, rand.New(rand.NewSource(c.seed)))
+	}
+	for _, recs := range t.conns {
+		for _, r := range recs {
+			if c.values != nil && r.op == opSet && r.fill+r.size > len(c.values.Bytes()) {
+				return fmt.Errorf("-replay: a SET of %d bytes at %d lies outside the value pool of the recording", r.size, r.fill)
+			}
+		}
+	}
+	return nil
+}
+
+// applyTrace sets the key prefix, seed, clients and preload of the
+// recording unless they were given on the command line, so a replay sends
+// the same keys and values.
+func (c *config) applyTrace() {
+	t := c.trace
+	if _, ok := c.explicit["key-prefix"]; !ok {
+		c.keyPrefix = t.prefix
+	}
+	if _, ok := c.explicit["seed"]; !ok {
+		c.seed = t.seed
+	}
+	if _, ok := c.explicit["clients"]; !ok {
+		c.clients = max(len(t.conns), 1)
+	}
+	if _, ok := c.explicit["preload"]; !ok {
+		c.preload = t.preload
+	}
+	c.rawValues = t.rawValues
+	if c.preload > 0 && c.keyspace == 0 {
+		c.keyspace = c.preload
+	}
+}
+
+// traceRecord is one command of a trace.
+type traceRecord struct {
+	op opType
+	// at is the offset of the command from the start of the run.
+	at time.Duration
+	// key is the key without the key prefix.
+	key  string
+	size int
+	fill int
+	ttl  time.Duration
+	// conn is the recorded connection and seq the number of its SETs up
+	// to this one, which the structured value names.
+	conn int
+	seq  uint64
+}
+
+// Formats of a -replay file.
+const (
+	traceRecorded = "record"
+	traceMonitor  = "monitor"
+)
+
+// trace is a recorded command stream, by connection.
+type trace struct {
+	format             string
+	prefix             string
+	seed               int64
+	preload            int
+	valueMin, valueMax int
+	rawValues          bool
+	conns              [][]traceRecord
+	// skipped counts the commands of a MONITOR log a trace cannot hold,
+	// by name, and filtered those -monitor-commands left out.
+	skipped  map[string]int64
+	filtered int64
+}
+
+// len returns the number of commands of t.
+func (t *trace) len() int {
+	n := 0
+	for _, c := range t.conns {
+		n += len(c)
+	}
+	return n
+}
+
+// forWorker returns the commands worker replays out of workers: those of
+// every connection congruent to it, in the order of their offsets. The sort
+// is stable, so the commands of each connection keep their order.
+func (t *trace) forWorker(worker, workers int) []traceRecord {
+	var recs []traceRecord
+	for c := worker; c < len(t.conns); c += workers {
+		recs = append(recs, t.conns[c]...)
+	}
+	sort.SliceStable(recs, func(i, j int) bool { return recs[i].at < recs[j].at })
+	return recs
+}
+
+// traceWriter writes the commands of a run to a -record file. Workers
+// buffer their records and append whole chunks under mu.
+type traceWriter struct {
+	path   string
+	prefix string
+	// start is the time offsets count from, set by runBenchmark before any
+	// worker starts.
+	start   time.Time
+	mu      sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+	err     error
+	written int64
+}
+
+// newTraceWriter creates path and writes the header of cfg's trace.
+func newTraceWriter(path string, cfg *config) (*traceWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create trace: %w", err)
+	}
+	tw := &traceWriter{path: path, prefix: cfg.keyPrefix, file: f, w: bufio.NewWriterSize(f, 1<<16)}
+	h := []byte(traceMagic)
+	h = appendTraceString(h, cfg.keyPrefix)
+	h = binary.AppendVarint(h, cfg.seed)
+	var flags uint64
+	if cfg.rawValues {
+		flags |= traceRawValues
+	}
+	h = binary.AppendUvarint(h, flags)
+	preload := 0
+	if cfg.usesKeyspace() {
+		preload = cfg.preload
+	}
+	h = binary.AppendUvarint(h, uint64(preload))
+	var lo, hi int
+	if v := cfg.values; v != nil {
+		lo, hi = v.Min(), v.Max()
+	}
+	h = binary.AppendUvarint(h, uint64(lo))
+	h = binary.AppendUvarint(h, uint64(hi))
+	h = binary.AppendUvarint(h, uint64(cfg.clients))
+	tw.w.Write(h)
+	return tw, nil
+}
+
+func appendTraceString(b []byte, s string) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(s))), s...)
+}
+
+// writeChunk appends the records of conn in recs to the file.
+func (tw *traceWriter) writeChunk(conn int, recs []byte, n int) {
+	if len(recs) == 0 {
+		return
+	}
+	var h [2 * binary.MaxVarintLen64]byte
+	hn := binary.PutUvarint(h[:], uint64(conn))
+	hn += binary.PutUvarint(h[hn:], uint64(len(recs)))
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if _, err := tw.w.Write(h[:hn]); err != nil && tw.err == nil {
+		tw.err = err
+	}
+	if _, err := tw.w.Write(recs); err != nil && tw.err == nil {
+		tw.err = err
+	}
+	tw.written += int64(n)
+}
+
+// close flushes and closes the file once every worker flushed its buffer.
+func (tw *traceWriter) close() error {
+	err := tw.err
+	if ferr := tw.w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := tw.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write trace: %w", err)
+	}
+	return nil
+}
+
+// traceBuffer is a worker's handle on the trace writer.
+type traceBuffer struct {
+	tw   *traceWriter
+	conn int
+	buf  []byte
+	n    int
+	last time.Duration
+}
+
+func (tw *traceWriter) buffer(worker int) *traceBuffer {
+	return &traceBuffer{tw: tw, conn: worker, buf: make([]byte, 0, traceChunkSize)}
+}
+
+// add records p, issued at start.
+func (b *traceBuffer) add(p pendingOp, start time.Time) {
+	at := max(start.Sub(b.tw.start), b.last)
+	b.buf = append(b.buf, byte(p.op))
+	b.buf = binary.AppendUvarint(b.buf, uint64((at-b.last)/time.Microsecond))
+	// The delta is truncated to whole microseconds; carry the remainder.
+	b.last += (at - b.last) / time.Microsecond * time.Microsecond
+	b.buf = appendTraceString(b.buf, strings.TrimPrefix(p.key, b.tw.prefix))
+	switch p.op {
+	case opSet:
+		b.buf = binary.AppendUvarint(b.buf, uint64(p.bytes))
+		b.buf = binary.AppendUvarint(b.buf, uint64(p.fill))
+		b.buf = binary.AppendUvarint(b.buf, uint64(p.ttl/time.Millisecond))
+	case opExpire:
+		// EXPIRE sends whole seconds; the argument is the TTL sent.
+		secs, _ := p.cmd.Args()[2].(int64)
+		b.buf = binary.AppendUvarint(b.buf, uint64(secs))
+	}
+	b.n++
+	if len(b.buf) >= traceChunkSize {
+		b.flush()
+	}
+}
+
+// flush hands the buffered records to the writer.
+func (b *traceBuffer) flush() {
+	b.tw.writeChunk(b.conn, b.buf, b.n)
+	b.buf, b.n = b.buf[:0], 0
+}
+
+// readTraceFile reads the trace written to path by -record, or the MONITOR
+// log there shaped by opts.
+func readTraceFile(path string, opts monitorOptions) (*trace, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var t *trace
+	if magic, _ := r.Peek(len(traceMagic)); string(magic) == traceMagic {
+		if opts.given() {
+			return nil, fmt.Errorf("%s: the -monitor flags only apply to MONITOR logs", path)
+		}
+		t, err = readTrace(r)
+	} else {
+		t, err = parseMonitor(r, opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return t, nil
+}
+
+// errTraceFormat is returned for a file that is not a well-formed trace.
+var errTraceFormat = errors.New("not a trace written by -record")
+
+// readTrace decodes a trace from r.
+func readTrace(r *bufio.Reader) (*trace, error) {
+	magic := make([]byte, len(traceMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != traceMagic {
+		return nil, errTraceFormat
+	}
+	t := &trace{format: traceRecorded}
+	var err error
+	if t.prefix, err = readTraceString(r); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	if t.seed, err = binary.ReadVarint(r); err != nil {
+		return nil, fmt.Errorf("header: %w", err)
+	}
+	var fields [5]uint64
+	for i := range fields {
+		if fields[i], err = binary.ReadUvarint(r); err != nil {
+			return nil, fmt.Errorf("header: %w", err)
+		}
+	}
+	t.rawValues = fields[0]&traceRawValues != 0
+	t.preload, t.valueMin, t.valueMax = int(fields[1]), int(fields[2]), int(fields[3])
+	t.conns = make([][]traceRecord, fields[4])
+	last := make([]time.Duration, len(t.conns))
+	sets := make([]uint64, len(t.conns))
+	for chunk := 1; ; chunk++ {
+		conn, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return t, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", chunk, err)
+		}
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", chunk, err)
+		}
+		if conn >= uint64(len(t.conns)) {
+			return nil, fmt.Errorf("chunk %d: connection %d of %d", chunk, conn, len(t.conns))
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("chunk %d: %w", chunk, io.ErrUnexpectedEOF)
+		}
+		cr := bytes.NewReader(data)
+		for cr.Len() > 0 {
+			rec, err := readTraceRecord(cr, &last[conn])
+			if err != nil {
+				return nil, fmt.Errorf("chunk %d: %w", chunk, err)
+			}
+			rec.conn = int(conn)
+			if rec.op == opSet {
+				sets[conn]++
+				rec.seq = sets[conn]
+			}
+			t.conns[conn] = append(t.conns[conn], rec)
+		}
+	}
+}
+
+func readTraceString(r io.ByteReader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	for i := range b {
+		if b[i], err = r.ReadByte(); err != nil {
+			return "", io.ErrUnexpectedEOF
+		}
+	}
+	return string(b), nil
+}
+
+// readTraceRecord decodes the record after the one at *last.
+func readTraceRecord(r *bytes.Reader, last *time.Duration) (traceRecord, error) {
+	var rec traceRecord
+	op, _ := r.ReadByte()
+	rec.op = opType(op)
+	if !traceOps[rec.op] {
+		return rec, fmt.Errorf("unknown command %d", op)
+	}
+	delta, err := binary.ReadUvarint(r)
+	if err != nil {
+		return rec, io.ErrUnexpectedEOF
+	}
+	*last += time.Duration(delta) * time.Microsecond
+	rec.at = *last
+	if rec.key, err = readTraceString(r); err != nil {
+		return rec, io.ErrUnexpectedEOF
+	}
+	switch rec.op {
+	case opSet:
+		var fields [3]uint64
+		for i := range fields {
+			if fields[i], err = binary.ReadUvarint(r); err != nil {
+				return rec, io.ErrUnexpectedEOF
+			}
+		}
+		rec.size, rec.fill, rec.ttl = int(fields[0]), int(fields[1]), time.Duration(fields[2])*time.Millisecond
+	case opExpire:
+		ttl, err := binary.ReadUvarint(r)
+		if err != nil {
+			return rec, io.ErrUnexpectedEOF
+		}
+		rec.ttl = time.Duration(ttl) * time.Second
+	}
+	return rec, nil
+}
+
+// waitReplay sleeps until the next command of the trace is due under
+// -replay-timing original and reports whether the run is still on.
+func (w *worker) waitReplay(runCtx context.Context) bool {
+	if w.run.cfg.replayTiming != replayOriginal {
+		return true
+	}
+	at := float64(w.replay[w.replayed].at) / w.run.cfg.replaySpeed
+	due := w.run.replayStart.Add(time.Duration(at))
+	return realClock{}.Sleep(runCtx, time.Until(due))
+}
+
+// issueReplay sends the next command of the trace through c. SET values
+// are regenerated as the recording wrote them.
+func (w *worker) issueReplay(ctx context.Context, c redis.Cmdable) pendingOp {
+	cfg := w.run.cfg
+	r := w.replay[w.replayed]
+	w.replayed++
+	key := cfg.keyPrefix + r.key
+	switch r.op {
+	case opGet:
+		return pendingOp{op: r.op, cmd: c.Get(ctx, key), key: key, at: r.at}
+	case opDel:
+		return pendingOp{op: r.op, cmd: c.Del(ctx, key), key: key, at: r.at}
+	case opExpire:
+		return pendingOp{op: r.op, cmd: c.Expire(ctx, key, r.ttl), key: key, at: r.at}
+	}
+	var filler []byte
+	if cfg.values != nil {
+		filler = cfg.values.Bytes()[r.fill : r.fill+r.size]
+	} else {
+		filler = strconv.AppendInt([]byte("value"), int64(r.fill), 10)
+	}
+	value := cfg.structure(filler, key, r.conn, r.seq)
+	p := pendingOp{op: r.op, cmd: c.Set(ctx, key, value, r.ttl), bytes: len(value), key: key, ttl: r.ttl, at: r.at}
+	if cfg.verifyFinal {
+		p.written = value
+	}
+	return p
+}
+
+// printTrace writes the -record or -replay line of the summary.
+func printTrace(w io.Writer, u latencyUnit, cfg *config, res *runResult) {
+	if tw := res.record; tw != nil {
+		fmt.Fprintf(w, "Trace: %d commands recorded to %s\n", tw.written, tw.path)
+	}
+	if t := cfg.trace; t != nil {
+		timing := cfg.replayTiming
+		if timing == replayOriginal && cfg.replaySpeed != 1 {
+			timing = fmt.Sprintf("%s at %gx", timing, cfg.replaySpeed)
+		}
+		source := "trace"
+		if t.format == traceMonitor {
+			source = "MONITOR log"
+		}
+		fmt.Fprintf(w, "Replay: %d commands of %d connections from %s %s, timing %s\n", t.len(), len(t.conns), source, cfg.replayPath, timing)
+		printSkipped(w, t)
+		if rt := buildReplayTiming(cfg, res.total); rt != nil {
+			printReplayTiming(w, u, rt)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package loadgen
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// recordTrace runs the benchmark described by args with -record and
+// returns the trace file and the result.
+func recordTrace(t *testing.T, args ...string) (string, *runResult) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "trace.bin")
+	cfg := testConfig(t, append(args, "-record", path)...)
+	tw, err := newTraceWriter(path, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.recorder = tw
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.close(); err != nil {
+		t.Fatal(err)
+	}
+	res.record = tw
+	return path, res
+}
+
+func TestRecordReplayIdentical(t *testing.T) {
+	recorded := miniredis.RunT(t)
+	path, rec := recordTrace(t, "-addr", recorded.Addr(), "-clients", "1", "-ops", "400",
+		"-ratio", "get=3,set=3,del=1,expire=1", "-expire-ttl", "100s", "-ttl", "1s:60s",
+		"-preload", "100", "-value-size-range", "10:80", "-key-prefix", "tr:")
+	if rec.record.written != 400 {
+		t.Fatalf("recorded %d of 400 commands", rec.record.written)
+	}
+
+	replayed := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", replayed.Addr(), "-replay", path, "-replay-timing", "fast")
+	if cfg.keyPrefix != "tr:" || cfg.clients != 1 || cfg.preload != 100 || cfg.trace.len() != 400 {
+		t.Fatalf("replay of prefix %q, %d clients, preload %d, %d commands", cfg.keyPrefix, cfg.clients, cfg.preload, cfg.trace.len())
+	}
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range []opType{opGet, opSet, opDel, opExpire} {
+		if got, want := res.total.ops[op].attempts(), rec.total.ops[op].attempts(); got != want {
+			t.Errorf("%s: replayed %d, recorded %d", op, got, want)
+		}
+	}
+	if got, want := res.total.ops[opGet].hits, rec.total.ops[opGet].hits; got != want {
+		t.Errorf("replay hit %d GETs, the recording %d", got, want)
+	}
+
+	keys := recorded.Keys()
+	if got := replayed.Keys(); strings.Join(got, ",") != strings.Join(keys, ",") {
+		t.Fatalf("replay left %d keys, the recording %d", len(got), len(keys))
+	}
+	for _, key := range keys {
+		want, _ := recorded.Get(key)
+		if got, _ := replayed.Get(key); got != want {
+			t.Errorf("%s: replayed value %q, recorded %q", key, valuePrefix([]byte(got)), valuePrefix([]byte(want)))
+		}
+		if got, want := replayed.TTL(key), recorded.TTL(key); got != want {
+			t.Errorf("%s: replayed TTL %v, recorded %v", key, got, want)
+		}
+	}
+
+	var buf bytes.Buffer
+	printTrace(&buf, cfg.displayUnit(res.total.latency), cfg, res)
+	if want := "Replay: 400 commands of 1 connections"; !strings.Contains(buf.String(), want) {
+		t.Errorf("summary lacks %q:\n%s", want, buf.String())
+	}
+}
+
+func TestReplayKeepsConnectionOrder(t *testing.T) {
+	tr := &trace{conns: make([][]traceRecord, 5)}
+	for c := range tr.conns {
+		for i := 0; i < 4; i++ {
+			// Commands of a connection may share an offset.
+			tr.conns[c] = append(tr.conns[c], traceRecord{op: opSet, at: time.Duration(i/2) * time.Millisecond, conn: c, seq: uint64(i)})
+		}
+	}
+	recs := tr.forWorker(1, 2)
+	if len(recs) != 8 {
+		t.Fatalf("worker 1 of 2 replays %d commands, want those of connections 1 and 3", len(recs))
+	}
+	next := map[int]uint64{1: 0, 3: 0}
+	for i, r := range recs {
+		if r.seq != next[r.conn] {
+			t.Fatalf("command %d is #%d of connection %d, want #%d", i, r.seq, r.conn, next[r.conn])
+		}
+		next[r.conn]++
+		if i > 0 && r.at < recs[i-1].at {
+			t.Errorf("command %d at %v after one at %v", i, r.at, recs[i-1].at)
+		}
+	}
+}
+
+func TestReplayTiming(t *testing.T) {
+	mr := miniredis.RunT(t)
+	path, _ := recordTrace(t, "-addr", mr.Addr(), "-clients", "2", "-ops", "10", "-rate", "100")
+
+	for timing, fast := range map[string]bool{replayOriginal: false, replayFast: true} {
+		cfg := testConfig(t, "-addr", mr.Addr(), "-replay", path, "-replay-timing", timing)
+		rdb, _ := newClient(cfg)
+		res := runBenchmark(context.Background(), rdb, cfg)
+		rdb.Close()
+		if n := res.total.ops[opSet].attempts(); n != 20 {
+			t.Errorf("%s: replayed %d of 20 commands", timing, n)
+		}
+		// The recording spread its 20 commands over 200ms.
+		if d := res.end.Sub(res.start); fast != (d < 100*time.Millisecond) {
+			t.Errorf("%s replay took %v", timing, d)
+		}
+	}
+}
+
+func TestTraceFormat(t *testing.T) {
+	mr := miniredis.RunT(t)
+	path, _ := recordTrace(t, "-addr", mr.Addr(), "-clients", "3", "-ops", "50", "-raw-values",
+		"-ratio", "set=1,expire=1", "-expire-ttl", "30s", "-preload", "10", "-value-size", "16", "-seed", "7")
+	tr, err := readTraceFile(path, monitorOptions{scale: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr.seed != 7 || tr.preload != 10 || tr.valueMin != 16 || tr.valueMax != 16 || !tr.rawValues || len(tr.conns) != 3 {
+		t.Fatalf("header %+v", tr)
+	}
+	for c, recs := range tr.conns {
+		var sets uint64
+		for i, r := range recs {
+			switch {
+			case r.conn != c, i > 0 && r.at < recs[i-1].at, !strings.HasPrefix(r.key, "key"):
+				t.Fatalf("connection %d: record %d %+v", c, i, r)
+			case r.op == opSet:
+				sets++
+				if r.size != 16 || r.seq != sets {
+					t.Errorf("SET %+v, want 16 bytes as SET #%d", r, sets)
+				}
+			case r.ttl != 30*time.Second:
+				t.Errorf("EXPIRE %+v, want a 30s TTL", r)
+			}
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readTrace(bufio.NewReader(bytes.NewReader(data[:len(data)-3]))); err == nil || !strings.Contains(err.Error(), "chunk") {
+		t.Errorf("truncated trace: error %v", err)
+	}
+	if _, err := readTrace(bufio.NewReader(strings.NewReader("timestamp_us,command\n"))); err != errTraceFormat {
+		t.Errorf("CSV file: error %v", err)
+	}
+}
+
+func TestTraceFlags(t *testing.T) {
+	mr := miniredis.RunT(t)
+	path, _ := recordTrace(t, "-addr", mr.Addr(), "-clients", "1", "-ops", "5")
+	for _, args := range [][]string{
+		{"-record", "t.bin", "-ratio", "get=1,incr=1", "-preload", "10"},
+		{"-record", "t.bin", "-workload", "hash", "-preload", "10"},
+		{"-record", "t.bin", "-verify"},
+		{"-record", "t.bin", "-addr2", "localhost:1"},
+		{"-record", "t.bin", "-replay", path},
+		{"-replay", path, "-ratio", "get=1"},
+		{"-replay", path, "-duration", "1s"},
+		{"-replay", path, "-rate", "100"},
+		{"-replay", path, "-replay-timing", "slow"},
+		{"-replay", filepath.Join(t.TempDir(), "missing.bin")},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	testConfig(t, "-replay", path, "-replay-timing", "fast", "-rate", "100", "-clients", "4", "-key-prefix", "other:")
+}
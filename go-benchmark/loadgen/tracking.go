@@ -0,0 +1,463 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+// workloadTracking runs a GET-heavy -ratio mix with server-assisted client
+// side caching. Each client reads with CLIENT TRACKING on, keeps the values
+// in a local cache and serves repeated GETs from it; the server redirects
+// its invalidations to a second connection of the client, whose goroutine
+// evicts the keys. SETs go out on a third connection, as another client's
+// would, and each is followed by a check that the cache dropped what it
+// held of the key within -tracking-staleness.
+const workloadTracking = "tracking"
+
+// defaultTrackingRatio is the mix of -workload tracking without -ratio, read
+// over a zipfian keyspace unless -key-dist says otherwise.
+const defaultTrackingRatio = "get=0.95,set=0.05"
+
+// trackingChannel is the channel of redirected invalidations.
+const trackingChannel = "__redis__:invalidate"
+
+// validateTracking checks the flags of the tracking workload, which runs on
+// raw RESP2 connections of its own, three per client.
+func (c *config) validateTracking() error {
+	for _, op := range c.mix.ops {
+		if op != opGet && op != opSet {
+			return fmt.Errorf("-workload tracking runs GET and SET, not %s", op)
+		}
+	}
+	switch {
+	case c.trackingStaleness <= 0:
+		return fmt.Errorf("-tracking-staleness must be positive, got %v", c.trackingStaleness)
+	case c.pipeline > 1:
+		return errors.New("-pipeline does not apply to -workload tracking")
+	case c.cluster || c.sentinelMaster != "":
+		return errors.New("-workload tracking connects to a single node, not a -cluster or -sentinel-master")
+	case c.churn || c.resilience || c.retries > 0:
+		return errors.New("-workload tracking keeps its connections and cannot be combined with -churn, -resilience or -retries")
+	case c.verify || c.evictPressure || c.recordPath != "":
+		return errors.New("-workload tracking does not apply to -verify, -evict-pressure or -record")
+	}
+	return nil
+}
+
+// trackingClient holds the connections of one client of the tracking
+// workload and its local cache. cache is shared with the listener goroutine
+// under mu; checks belongs to the worker.
+type trackingClient struct {
+	cmd, listener, writer *respConn
+
+	mu    sync.Mutex
+	cache map[string]*cachedValue
+	// lost is set once the listener stopped, after which cache may hold
+	// values the server invalidated.
+	lost atomic.Bool
+	done chan struct{}
+	// messages counts the invalidation messages received, keys the keys
+	// they named and flushes those that invalidated every key.
+	messages, keys, flushes atomic.Int64
+
+	checks []stalenessCheck
+}
+
+// cachedValue is a value of the local cache.
+type cachedValue struct {
+	value []byte
+	// sent is when the GET that read it was sent.
+	sent time.Time
+	// pending is set while that GET awaits its reply.
+	pending bool
+}
+
+// stalenessCheck is the check owed by a SET: by due, the cache must not
+// hold a value read before the SET was sent.
+type stalenessCheck struct {
+	key  string
+	sent time.Time
+	due  time.Time
+}
+
+// dialTracking opens the connections of a tracking client: the listener
+// first, subscribed to the invalidations, then the reading connection
+// redirecting its invalidations to it, then the writing one.
+func dialTracking(ctx context.Context, cfg *config) (*trackingClient, error) {
+	t := &trackingClient{cache: make(map[string]*cachedValue), done: make(chan struct{})}
+	var err error
+	if t.listener, err = dialResp(ctx, cfg, resp2); err != nil {
+		return nil, err
+	}
+	id, err := t.listener.integer(ctx, "CLIENT", "ID")
+	if err == nil {
+		err = t.subscribe(ctx)
+	}
+	if err == nil {
+		t.cmd, err = dialResp(ctx, cfg, resp2)
+	}
+	if err == nil {
+		if err = t.cmd.status(ctx, "CLIENT", "TRACKING", "ON", "REDIRECT", strconv.FormatInt(id, 10)); err != nil {
+			err = fmt.Errorf("CLIENT TRACKING: %w", err)
+		}
+	}
+	if err == nil {
+		t.writer, err = dialResp(ctx, cfg, resp2)
+	}
+	if err != nil {
+		close(t.done)
+		t.close()
+		return nil, err
+	}
+	go t.listen()
+	return t, nil
+}
+
+// subscribe subscribes the listener to the invalidations and clears the
+// deadline the setup left on it.
+func (t *trackingClient) subscribe(ctx context.Context) error {
+	rep, err := t.listener.command(ctx, "SUBSCRIBE", trackingChannel)
+	if err != nil {
+		return err
+	}
+	if rep.kind != '*' || rep.n != 3 {
+		return &respProtocolError{fmt.Sprintf("unexpected reply type %q to SUBSCRIBE", rep.kind)}
+	}
+	if err := t.listener.skip(rep); err != nil {
+		return err
+	}
+	return t.listener.conn.SetDeadline(time.Time{})
+}
+
+// listen evicts the keys of every invalidation message until the listener
+// connection closes. A message with a null key list invalidates them all.
+func (t *trackingClient) listen() {
+	defer close(t.done)
+	defer t.lost.Store(true)
+	c := t.listener
+	for {
+		rep, err := c.readReply()
+		if err != nil {
+			return
+		}
+		if rep.kind != '*' || rep.n != 3 {
+			if err := c.skip(rep); err != nil {
+				return
+			}
+			continue
+		}
+		kind, err := c.readReply()
+		if err != nil {
+			return
+		}
+		message := bytes.Equal(kind.str, []byte("message"))
+		channel, err := c.readReply()
+		if err != nil {
+			return
+		}
+		message = message && string(channel.str) == trackingChannel
+		keys, err := c.readReply()
+		if err != nil {
+			return
+		}
+		if !message {
+			if err := c.skip(keys); err != nil {
+				return
+			}
+			continue
+		}
+		t.messages.Add(1)
+		if keys.null {
+			t.mu.Lock()
+			clear(t.cache)
+			t.mu.Unlock()
+			t.flushes.Add(1)
+			continue
+		}
+		for i := int64(0); i < keys.elements(); i++ {
+			key, err := c.readReply()
+			if err != nil {
+				return
+			}
+			t.mu.Lock()
+			delete(t.cache, string(key.str))
+			t.mu.Unlock()
+			t.keys.Add(1)
+		}
+	}
+}
+
+// get serves GET key from the cache, or reads it from the server and caches
+// it. It returns the size of the value, whether the key exists and whether
+// the cache served it.
+func (t *trackingClient) get(ctx context.Context, key string) (int, bool, bool, error) {
+	t.mu.Lock()
+	e := t.cache[key]
+	t.mu.Unlock()
+	if e != nil && !e.pending {
+		return len(e.value), true, true, nil
+	}
+	// An invalidation overtaking the reply evicts the placeholder, and
+	// the value it invalidated is then not cached.
+	e = &cachedValue{sent: time.Now(), pending: true}
+	t.mu.Lock()
+	t.cache[key] = e
+	t.mu.Unlock()
+	v, found, err := t.cmd.get(ctx, key)
+	t.mu.Lock()
+	if t.cache[key] == e {
+		if err == nil && found {
+			e.value, e.pending = bytes.Clone(v), false
+		} else {
+			delete(t.cache, key)
+		}
+	}
+	t.mu.Unlock()
+	return len(v), found, false, err
+}
+
+// set sends the SET of s on the writing connection and schedules its
+// staleness check.
+func (t *trackingClient) set(ctx context.Context, s setArgs, bound time.Duration) error {
+	sent := time.Now()
+	if err := t.writer.set(ctx, s.key, s.value, s.ttl); err != nil {
+		return err
+	}
+	t.checks = append(t.checks, stalenessCheck{key: s.key, sent: sent, due: time.Now().Add(bound)})
+	return nil
+}
+
+// checkStale runs the staleness checks due by now.
+func (t *trackingClient) checkStale(now time.Time, s *trackingStats) {
+	n := 0
+	for ; n < len(t.checks) && !t.checks[n].due.After(now); n++ {
+		c := t.checks[n]
+		t.mu.Lock()
+		e := t.cache[c.key]
+		stale := e != nil && !e.pending && e.sent.Before(c.sent)
+		t.mu.Unlock()
+		s.checks++
+		if stale {
+			s.violations++
+		}
+	}
+	t.checks = append(t.checks[:0], t.checks[n:]...)
+}
+
+// close closes the connections and waits for the listener to stop.
+func (t *trackingClient) close() {
+	for _, c := range []*respConn{t.cmd, t.listener, t.writer} {
+		if c != nil {
+			c.close()
+		}
+	}
+	<-t.done
+}
+
+// trackingStats describes the GETs and invalidations of the tracking
+// workload.
+type trackingStats struct {
+	// local and server are the latencies of the GETs the cache served
+	// and of those sent to the server.
+	local, server *stats.Histogram
+	// checks counts the staleness checks of SETs, of which violations
+	// found the cache still holding a value read before the SET.
+	checks, violations int64
+	messages, keys     int64
+	flushes            int64
+	// lost counts the listener connections that stopped during the run,
+	// each dropping its client's cache and connections.
+	lost int64
+}
+
+func (s *trackingStats) merge(o *trackingStats) {
+	s.local.Merge(o.local)
+	s.server.Merge(o.server)
+	s.checks += o.checks
+	s.violations += o.violations
+	s.messages += o.messages
+	s.keys += o.keys
+	s.flushes += o.flushes
+	s.lost += o.lost
+}
+
+// trackingStats returns the tracking statistics of r, allocating them on
+// first use.
+func (r *workerResult) trackingStats() *trackingStats {
+	if r.tracking == nil {
+		r.tracking = &trackingStats{local: r.layout.New(), server: r.layout.New()}
+	}
+	return r.tracking
+}
+
+// recordTracking records the latency of a successful GET by whether the
+// cache served it.
+func (r *workerResult) recordTracking(local bool, d time.Duration) {
+	s := r.trackingStats()
+	if local {
+		s.local.Record(d)
+	} else {
+		s.server.Record(d)
+	}
+}
+
+// issueTracking sends one operation of the tracking workload, dialing the
+// client's connections first if needed. A client whose listener stopped is
+// replaced, since its cache can no longer be trusted.
+func (w *worker) issueTracking(ctx context.Context, op opType) pendingOp {
+	cfg := w.run.cfg
+	if w.tracking != nil && w.tracking.lost.Load() {
+		w.dropTracking()
+		w.result.trackingStats().lost++
+	}
+	key, hot := w.hotKey()
+	var p pendingOp
+	var s setArgs
+	if op == opGet {
+		if !hot {
+			key = cfg.keyName(w.keys.Next())
+		}
+		p = pendingOp{op: op, key: key, hot: hot}
+	} else {
+		s = w.nextSet(key, hot)
+		p = pendingOp{op: op, bytes: len(s.value), key: s.key, ttl: s.ttl, hot: hot}
+		w.keepSet(&p, s)
+	}
+	if w.tracking == nil {
+		if w.tracking, p.rawErr = dialTracking(ctx, cfg); p.rawErr != nil {
+			return p
+		}
+	}
+	t := w.tracking
+	t.checkStale(time.Now(), w.result.trackingStats())
+	if op == opGet {
+		p.replySize, p.found, p.local, p.rawErr = t.get(ctx, key)
+	} else {
+		p.rawErr = t.set(ctx, s, cfg.trackingStaleness)
+	}
+	var reply respError
+	if p.rawErr != nil && !errors.As(p.rawErr, &reply) {
+		w.dropTracking()
+	}
+	return p
+}
+
+// closeTracking runs the staleness checks of the last SETs, waiting until
+// they are due, and closes the client.
+func (w *worker) closeTracking() {
+	t := w.tracking
+	if t == nil {
+		return
+	}
+	if n := len(t.checks); n > 0 && !t.lost.Load() {
+		last := t.checks[n-1].due
+		time.Sleep(time.Until(last))
+		t.checkStale(last, w.result.trackingStats())
+	}
+	w.dropTracking()
+}
+
+// dropTracking closes the worker's tracking client and adds up its
+// invalidations.
+func (w *worker) dropTracking() {
+	t := w.tracking
+	if t == nil {
+		return
+	}
+	t.close()
+	s := w.result.trackingStats()
+	s.messages += t.messages.Load()
+	s.keys += t.keys.Load()
+	s.flushes += t.flushes.Load()
+	w.tracking = nil
+}
+
+// resetCounts discards the invalidations counted during the warmup.
+func (t *trackingClient) resetCounts() {
+	t.messages.Store(0)
+	t.keys.Store(0)
+	t.flushes.Store(0)
+}
+
+// jsonTracking is the tracking section of the JSON report.
+type jsonTracking struct {
+	LocalHits  int64 `json:"local_hits"`
+	ServerGets int64 `json:"server_gets"`
+	// LocalHitRatio is the share of the successful GETs the local cache
+	// served.
+	LocalHitRatio float64         `json:"local_hit_ratio"`
+	LocalLatency  *latencySummary `json:"local_hit_latency"`
+	ServerLatency *latencySummary `json:"server_get_latency"`
+	Invalidations int64           `json:"invalidation_messages"`
+	// InvalidatedKeys counts the keys the messages named; Flushes the
+	// messages that invalidated every key.
+	InvalidatedKeys int64  `json:"invalidated_keys"`
+	Flushes         int64  `json:"invalidation_flushes,omitempty"`
+	ListenersLost   int64  `json:"listeners_lost,omitempty"`
+	StalenessBound  string `json:"staleness_bound"`
+	StalenessChecks int64  `json:"staleness_checks"`
+	// StalenessViolations counts the SETs after which the cache still
+	// held an older value once the bound had passed.
+	StalenessViolations int64 `json:"staleness_violations"`
+}
+
+// buildTracking summarises the tracking workload, nil outside it.
+func buildTracking(cfg *config, total *workerResult) *jsonTracking {
+	s := total.tracking
+	if s == nil {
+		return nil
+	}
+	rep := &jsonTracking{
+		LocalHits:           s.local.Count(),
+		ServerGets:          s.server.Count(),
+		LocalLatency:        summarizeLatency(s.local),
+		ServerLatency:       summarizeLatency(s.server),
+		Invalidations:       s.messages,
+		InvalidatedKeys:     s.keys,
+		Flushes:             s.flushes,
+		ListenersLost:       s.lost,
+		StalenessBound:      cfg.trackingStaleness.String(),
+		StalenessChecks:     s.checks,
+		StalenessViolations: s.violations,
+	}
+	if n := rep.LocalHits + rep.ServerGets; n > 0 {
+		rep.LocalHitRatio = float64(rep.LocalHits) / float64(n)
+	}
+	return rep
+}
+
+// printTracking writes the tracking section of the summary.
+func printTracking(w io.Writer, u latencyUnit, cfg *config, total *workerResult) {
+	rep := buildTracking(cfg, total)
+	if rep == nil {
+		return
+	}
+	fmt.Fprintf(w, "Client-side caching: %d GETs served locally, %d by the server (%.2f%% local hit ratio)\n",
+		rep.LocalHits, rep.ServerGets, 100*rep.LocalHitRatio)
+	printLatency(w, u, "Local hit", total.tracking.local)
+	printLatency(w, u, "Server GET", total.tracking.server)
+	fmt.Fprintf(w, "Invalidations: %d messages for %d keys", rep.Invalidations, rep.InvalidatedKeys)
+	if rep.Flushes > 0 {
+		fmt.Fprintf(w, ", %d flushes", rep.Flushes)
+	}
+	fmt.Fprintln(w)
+	if rep.ListenersLost > 0 {
+		fmt.Fprintf(w, "WARNING: %d invalidation listeners lost their connection; their clients started over with empty caches\n", rep.ListenersLost)
+	}
+	if rep.StalenessViolations > 0 {
+		fmt.Fprintf(w, "WARNING: %d of %d SETs left an older value in the local cache past %s\n",
+			rep.StalenessViolations, rep.StalenessChecks, rep.StalenessBound)
+	} else {
+		fmt.Fprintf(w, "Staleness: %d SETs checked, none left an older value in the local cache past %s\n",
+			rep.StalenessChecks, rep.StalenessBound)
+	}
+}
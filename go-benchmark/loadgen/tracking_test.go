@@ -0,0 +1,221 @@
+package loadgen
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+)
+
+// trackingServer adds to miniredis the parts of CLIENT TRACKING the
+// tracking workload uses: CLIENT ID, CLIENT TRACKING ON REDIRECT, and the
+// invalidation of every key a tracking client read, sent to its redirect
+// client once per read after a SET of the key. Invalidations of a key drop
+// matches are not sent.
+type trackingServer struct {
+	*miniredis.Miniredis
+	drop func(key string) bool
+
+	mu       sync.Mutex
+	ids      map[*server.Peer]int64
+	peers    map[int64]*server.Peer
+	redirect map[*server.Peer]int64
+	readers  map[string]map[int64]bool
+}
+
+func newTrackingServer(t *testing.T) *trackingServer {
+	s := &trackingServer{
+		Miniredis: miniredis.RunT(t),
+		ids:       make(map[*server.Peer]int64),
+		peers:     make(map[int64]*server.Peer),
+		redirect:  make(map[*server.Peer]int64),
+		readers:   make(map[string]map[int64]bool),
+	}
+	s.Server().SetPreHook(s.hook)
+	return s
+}
+
+func (s *trackingServer) hook(c *server.Peer, cmd string, args ...string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case cmd == "CLIENT" && len(args) == 1 && strings.EqualFold(args[0], "ID"):
+		id, ok := s.ids[c]
+		if !ok {
+			id = int64(len(s.ids) + 1)
+			s.ids[c], s.peers[id] = id, c
+		}
+		c.WriteInt(int(id))
+		return true
+	case cmd == "CLIENT" && len(args) == 4 && strings.EqualFold(args[0], "TRACKING"):
+		id, _ := strconv.ParseInt(args[3], 10, 64)
+		s.redirect[c] = id
+		c.WriteOK()
+		return true
+	case cmd == "GET" && len(args) == 1:
+		if id, ok := s.redirect[c]; ok {
+			if s.readers[args[0]] == nil {
+				s.readers[args[0]] = make(map[int64]bool)
+			}
+			s.readers[args[0]][id] = true
+		}
+	case cmd == "SET" && (len(args) == 2 || len(args) == 4 && strings.EqualFold(args[2], "PX")):
+		key := args[0]
+		s.Set(key, args[1])
+		if len(args) == 4 {
+			ms, _ := strconv.Atoi(args[3])
+			s.SetTTL(key, time.Duration(ms)*time.Millisecond)
+		}
+		c.WriteOK()
+		for id := range s.readers[key] {
+			if peer := s.peers[id]; peer != nil && (s.drop == nil || !s.drop(key)) {
+				peer.Block(func(w *server.Writer) {
+					w.WriteLen(3)
+					w.WriteBulk("message")
+					w.WriteBulk(trackingChannel)
+					w.WriteLen(1)
+					w.WriteBulk(key)
+					w.Flush()
+				})
+			}
+		}
+		delete(s.readers, key)
+		return true
+	}
+	return false
+}
+
+func TestTrackingWorkload(t *testing.T) {
+	s := newTrackingServer(t)
+	cfg := testConfig(t, "-addr", s.Addr(), "-workload", "tracking", "-clients", "4", "-ops", "500",
+		"-keyspace", "50", "-preload", "50", "-tracking-staleness", "20ms")
+	if cfg.keyDist != keyDistZipfian || cfg.mix.share(opGet) != 0.95 {
+		t.Fatalf("key distribution %s, %v GETs", cfg.keyDist, cfg.mix.share(opGet))
+	}
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := res.total.errors(); n != 0 {
+		t.Fatalf("%d operations failed", n)
+	}
+	tr := res.total.tracking
+	get, set := res.total.ops[opGet], res.total.ops[opSet]
+	switch {
+	case tr == nil:
+		t.Fatal("no tracking statistics")
+	case tr.local.Count() == 0 || tr.server.Count() == 0 || tr.local.Count()+tr.server.Count() != get.attempts():
+		t.Errorf("%d local hits and %d server GETs of %d", tr.local.Count(), tr.server.Count(), get.attempts())
+	case tr.local.Count() < tr.server.Count():
+		t.Errorf("only %d of %d GETs of 50 keys served locally", tr.local.Count(), get.attempts())
+	case tr.messages == 0 || tr.keys != tr.messages:
+		t.Errorf("%d invalidation messages for %d keys", tr.messages, tr.keys)
+	case tr.checks != set.attempts() || tr.violations != 0:
+		t.Errorf("%d staleness checks of %d SETs, %d violations", tr.checks, set.attempts(), tr.violations)
+	case res.pool != nil:
+		t.Error("a tracking run reported pool stats")
+	}
+
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	for _, want := range []string{"local hit ratio", "Local hit latency", "Server GET latency", "none left an older value in the local cache past 20ms"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("summary lacks %q:\n%s", want, buf.String())
+		}
+	}
+	var rep struct {
+		Tracking *jsonTracking `json:"tracking"`
+	}
+	buf.Reset()
+	if err := writeJSON(&buf, buildReport(cfg, res)); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &rep); err != nil || rep.Tracking == nil || rep.Tracking.LocalHitRatio <= 0.5 || rep.Tracking.StalenessBound != "20ms" {
+		t.Errorf("JSON tracking section %+v, %v", rep.Tracking, err)
+	}
+}
+
+func TestTrackingStaleness(t *testing.T) {
+	s := newTrackingServer(t)
+	// A server that never invalidates key0 leaves it stale in the cache.
+	s.drop = func(key string) bool { return strings.HasSuffix(key, "key0") }
+	cfg := testConfig(t, "-addr", s.Addr(), "-workload", "tracking", "-clients", "1", "-ops", "300",
+		"-ratio", "get=1,set=1", "-key-dist", "uniform", "-keyspace", "2", "-preload", "2", "-tracking-staleness", "1ms")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := res.total.tracking
+	if tr.violations == 0 || tr.violations >= tr.checks {
+		t.Errorf("%d of %d staleness checks failed, want those of key0 only", tr.violations, tr.checks)
+	}
+	var buf bytes.Buffer
+	printTracking(&buf, cfg.displayUnit(res.total.latency), cfg, res.total)
+	if want := "SETs left an older value in the local cache past 1ms"; !strings.Contains(buf.String(), want) {
+		t.Errorf("summary lacks %q:\n%s", want, buf.String())
+	}
+}
+
+func TestTrackingListener(t *testing.T) {
+	client, srv := net.Pipe()
+	defer srv.Close()
+	tc := &trackingClient{
+		listener: &respConn{conn: client, r: bufio.NewReader(client)},
+		cache:    make(map[string]*cachedValue),
+		done:     make(chan struct{}),
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		tc.cache[key] = &cachedValue{value: []byte(key)}
+	}
+	go tc.listen()
+	for _, msg := range []string{
+		// Another channel's confirmation and message are ignored.
+		"*3\r\n$9\r\nsubscribe\r\n$5\r\nother\r\n:2\r\n",
+		"*3\r\n$7\r\nmessage\r\n$5\r\nother\r\n*1\r\n$1\r\na\r\n",
+		"*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n",
+	} {
+		if _, err := srv.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// net.Pipe writes return once read, so the last message is being
+	// handled; a flush written after it is handled after it.
+	if _, err := srv.Write([]byte("*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n*-1\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+	<-tc.done
+	if !tc.lost.Load() || len(tc.cache) != 0 {
+		t.Errorf("listener stopped with lost %v and %d keys cached", tc.lost.Load(), len(tc.cache))
+	}
+	if m, k, f := tc.messages.Load(), tc.keys.Load(), tc.flushes.Load(); m != 2 || k != 2 || f != 1 {
+		t.Errorf("%d messages for %d keys and %d flushes, want 2, 2 and 1", m, k, f)
+	}
+}
+
+func TestTrackingFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-workload", "tracking", "-ratio", "get=1,del=1"},
+		{"-workload", "tracking", "-tracking-staleness", "0s"},
+		{"-workload", "tracking", "-pipeline", "4"},
+		{"-workload", "tracking", "-record", "t.bin"},
+		{"-workload", "tracking", "-cluster"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	cfg := testConfig(t, "-workload", "tracking", "-ratio", "get=0.8,set=0.2", "-key-dist", "uniform", "-clients", "50", "-pool-size", "5")
+	if cfg.workload != workloadTracking || cfg.keyDist != keyDistUniform || cfg.poolStarved() {
+		t.Errorf("workload %s over %s keys, pool starved %v", cfg.workload, cfg.keyDist, cfg.poolStarved())
+	}
+}
@@ -0,0 +1,127 @@
+package loadgen
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// unixScheme prefixes an -addr or -addr2 that names a unix domain socket,
+// as in unix:///var/run/htcache.sock.
+const unixScheme = "unix://"
+
+// splitAddr returns the network and the address to dial for a target.
+func splitAddr(addr string) (network, address string) {
+	if path, ok := strings.CutPrefix(addr, unixScheme); ok {
+		return "unix", path
+	}
+	return "tcp", addr
+}
+
+// network returns the network of the target, tcp or unix.
+func (c *config) network() string {
+	network, _ := splitAddr(c.addr)
+	return network
+}
+
+// dialAddr returns the host:port or socket path of the target.
+func (c *config) dialAddr() string {
+	_, address := splitAddr(c.addr)
+	return address
+}
+
+// transport describes how the target is reached, for reports.
+func (c *config) transport() string {
+	t := "tcp"
+	if c.network() == "unix" {
+		t = "unix socket"
+	}
+	if c.tlsConfig != nil {
+		t += " with TLS"
+	}
+	if c.cluster {
+		t = "cluster over " + t
+	}
+	return t
+}
+
+// loadTLS builds cfg.tlsConfig from the -tls flags, loading the CA and
+// client certificate files so a bad file fails the run before it starts.
+func (c *config) loadTLS() error {
+	if !c.useTLS {
+		if c.tlsCA != "" || c.tlsCert != "" || c.tlsKey != "" || c.tlsSkipVerify {
+			return errors.New("-tls-ca, -tls-cert, -tls-key and -tls-skip-verify require -tls")
+		}
+		return nil
+	}
+	tc := &tls.Config{InsecureSkipVerify: c.tlsSkipVerify}
+	if c.tlsCA != "" {
+		pem, err := os.ReadFile(c.tlsCA)
+		if err != nil {
+			return fmt.Errorf("-tls-ca: %w", err)
+		}
+		tc.RootCAs = x509.NewCertPool()
+		if !tc.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("-tls-ca: no PEM certificate in %s", c.tlsCA)
+		}
+	}
+	if (c.tlsCert == "") != (c.tlsKey == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	if c.tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.tlsCert, c.tlsKey)
+		if err != nil {
+			return fmt.Errorf("-tls-cert and -tls-key: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	c.tlsConfig = tc
+	return nil
+}
+
+// tlsHandshake runs the client side of a TLS handshake on conn, verifying
+// the server as the host of addr unless the configuration names another.
+func tlsHandshake(ctx context.Context, conn net.Conn, tc *tls.Config, addr string) (net.Conn, error) {
+	if tc.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		tc = tc.Clone()
+		tc.ServerName = host
+	}
+	tconn := tls.Client(conn, tc)
+	if err := tconn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tconn, nil
+}
+
+// checkTransport fails early when a unix socket target cannot be reached,
+// so a missing or stale socket file is reported once instead of as a
+// failure of every operation. TCP targets are left to the run itself.
+func (c *config) checkTransport() error {
+	if c.network() != "unix" {
+		return nil
+	}
+	path := c.dialAddr()
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("unix socket %s: %w", path, err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("unix socket %s: not a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("unix socket %s: %w", path, err)
+	}
+	return conn.Close()
+}
@@ -0,0 +1,235 @@
+package loadgen
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// unixProxy serves a unix socket in a temporary directory that forwards
+// every connection to the TCP server at addr, and returns its path.
+func unixProxy(t *testing.T, addr string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "redis.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				s, err := net.Dial("tcp", addr)
+				if err != nil {
+					return
+				}
+				defer s.Close()
+				go io.Copy(s, c)
+				io.Copy(c, s)
+			}()
+		}
+	}()
+	return path
+}
+
+func TestUnixSocketTarget(t *testing.T) {
+	mr := miniredis.RunT(t)
+	path := unixProxy(t, mr.Addr())
+	cfg := testConfig(t, "-unix-socket", path, "-preload", "10", "-keyspace", "10", "-workload", "get",
+		"-clients", "2", "-ops", "20", "-key-prefix", "ux:")
+	if cfg.addr != "unix://"+path || cfg.network() != "unix" || cfg.dialAddr() != path {
+		t.Fatalf("-unix-socket gave addr %q, network %q, dial address %q", cfg.addr, cfg.network(), cfg.dialAddr())
+	}
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := &res.total.ops[opGet]; s.hits != 40 || s.errors != 0 {
+		t.Errorf("%d hits, %d errors over the socket, want 40 hits", s.hits, s.errors)
+	}
+	var out strings.Builder
+	printSummary(&out, cfg, res)
+	if !strings.Contains(out.String(), "unix socket") {
+		t.Error("summary does not name the transport")
+	}
+}
+
+func TestUnixSocketCompareWithTCP(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-addr2", "unix://"+unixProxy(t, mr.Addr()), "-clients", "1", "-ops", "10", "-key-prefix", "uc:")
+
+	for _, c := range []*config{cfg, cfg.forTarget(cfg.addr2)} {
+		res, err := runTarget(context.Background(), c)
+		if err != nil {
+			t.Fatalf("%s: %v", c.addr, err)
+		}
+		if rep := buildReport(c, res); rep.Config.Transport != c.network() || res.total.errors() != 0 {
+			t.Errorf("%s: transport %q, %d errors", c.addr, rep.Config.Transport, res.total.errors())
+		}
+	}
+}
+
+func TestMissingUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.sock")
+	cfg := testConfig(t, "-addr", "unix://"+path, "-ops", "10")
+
+	_, err := runTarget(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("missing socket gave %v, want an error naming it", err)
+	}
+	for _, args := range [][]string{
+		{"-addr", "unix://"},
+		{"-addr", "localhost:6379", "-unix-socket", path},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
+
+// writeTestCert writes a self-signed certificate for 127.0.0.1, valid for
+// both server and client authentication, and its key as PEM files.
+func writeTestCert(t *testing.T) (certFile, keyFile string, cert tls.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "htcache test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err = tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile, cert
+}
+
+// runTLSServer starts a miniredis that requires TLS with a client
+// certificate signed by the test certificate.
+func runTLSServer(t *testing.T, cert tls.Certificate) *miniredis.Miniredis {
+	t.Helper()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	mr, err := miniredis.RunTLS(&tls.Config{Certificates: []tls.Certificate{cert}, ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+	return mr
+}
+
+func TestTLSTarget(t *testing.T) {
+	certFile, keyFile, cert := writeTestCert(t)
+	mr := runTLSServer(t, cert)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-tls", "-tls-ca", certFile, "-tls-cert", certFile, "-tls-key", keyFile,
+		"-preload", "10", "-keyspace", "10", "-workload", "get", "-clients", "2", "-ops", "20", "-key-prefix", "tl:")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := &res.total.ops[opGet]; s.hits != 40 || s.errors != 0 {
+		t.Errorf("%d hits, %d errors over TLS, want 40 hits", s.hits, s.errors)
+	}
+	var out strings.Builder
+	printSummary(&out, cfg, res)
+	if !strings.Contains(out.String(), "tcp with TLS") {
+		t.Error("summary does not say TLS was in use")
+	}
+	if !buildReport(cfg, res).Config.TLS {
+		t.Error("JSON config does not say TLS was in use")
+	}
+}
+
+func TestTLSChurnHandshake(t *testing.T) {
+	certFile, keyFile, cert := writeTestCert(t)
+	mr := runTLSServer(t, cert)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-tls", "-tls-ca", certFile, "-tls-cert", certFile, "-tls-key", keyFile,
+		"-churn", "-clients", "2", "-ops", "10", "-key-prefix", "tc:")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := buildChurn(res.total)
+	if c == nil || c.TLSHandshake == nil || c.TLSHandshake.Count != 20 || res.total.errors() != 0 {
+		t.Fatalf("churn report %+v, %d errors, want 20 handshakes", c, res.total.errors())
+	}
+	if c.TLSHandshakeShare <= 0 || c.TLSHandshakeShare > 1 {
+		t.Errorf("handshake share %v of connect time", c.TLSHandshakeShare)
+	}
+}
+
+func TestTLSFlags(t *testing.T) {
+	certFile, keyFile, _ := writeTestCert(t)
+	missing := filepath.Join(t.TempDir(), "missing.pem")
+	for _, args := range [][]string{
+		{"-tls-ca", certFile},
+		{"-tls", "-tls-ca", missing},
+		{"-tls", "-tls-ca", keyFile},
+		{"-tls", "-tls-cert", certFile},
+		{"-tls", "-tls-cert", keyFile, "-tls-key", keyFile},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	if cfg := testConfig(t, "-tls", "-tls-skip-verify"); !cfg.tlsConfig.InsecureSkipVerify {
+		t.Error("-tls-skip-verify did not disable verification")
+	}
+}
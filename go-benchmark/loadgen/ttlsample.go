@@ -0,0 +1,254 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+// ttlJumpFraction is how much of its TTL a key may outlive its expected
+// expiry before the sample counts its TTL as jumped or reset.
+const ttlJumpFraction = 0.1
+
+// validateTTLSample checks -ttl-sample. Like -verify-expiry it needs every
+// key written once, so a key's expected expiry is that of its only SET.
+func (c *config) validateTTLSample() error {
+	if c.ttlSample == 0 {
+		if _, ok := c.explicit["ttl-sample-interval"]; ok {
+			return errors.New("-ttl-sample-interval requires -ttl-sample")
+		}
+		return nil
+	}
+	switch {
+	case c.ttlSample < 0:
+		return fmt.Errorf("-ttl-sample must not be negative, got %d", c.ttlSample)
+	case c.ttlSampleInterval <= 0:
+		return fmt.Errorf("-ttl-sample-interval must be positive, got %v", c.ttlSampleInterval)
+	case c.ttlMax == 0:
+		return errors.New("-ttl-sample requires -ttl")
+	case c.workload != workloadSet || c.boundedSets:
+		return errors.New("-ttl-sample requires -workload set without -keyspace, which writes every key once")
+	case c.dbList != "":
+		return errors.New("-ttl-sample follows one database, not -dbs")
+	case c.pipeline > 1:
+		return errors.New("-pipeline does not apply to -ttl-sample, which needs when each SET was sent")
+	}
+	return nil
+}
+
+// ttlKey is a key of the TTL sample. Its SET was sent at written and
+// answered at acked, so the server expires it between written and acked
+// plus ttl.
+type ttlKey struct {
+	key            string
+	written, acked time.Time
+	ttl            time.Duration
+	// jumped is set once the key outlived its expected expiry by more than
+	// ttlJumpFraction of its TTL, and done once it expired.
+	jumped, done bool
+}
+
+// ttlSampler keeps a reservoir of the keys written with a TTL, shared by
+// the workers, and samples their PTTL on a connection of its own while the
+// run goes on.
+type ttlSampler struct {
+	size int
+	seen atomic.Int64
+
+	mu   sync.Mutex
+	keys []*ttlKey
+
+	rdb  redis.UniversalClient
+	stop chan struct{}
+	done chan struct{}
+	// The fields below are only used by the sampling goroutine.
+	drift               *stats.Histogram
+	samples, errors     int64
+	jumped, early, none int64
+	sampled             map[string]bool
+}
+
+// startTTLSampler starts sampling the PTTL of the keys offered every
+// -ttl-sample-interval.
+func startTTLSampler(cfg *config) *ttlSampler {
+	own := *cfg
+	own.poolSize = 1
+	rdb, _ := newClient(&own)
+	s := &ttlSampler{size: cfg.ttlSample, rdb: rdb, stop: make(chan struct{}), done: make(chan struct{}),
+		drift: cfg.layout.New(), sampled: make(map[string]bool)}
+	go s.run(cfg.ttlSampleInterval)
+	return s
+}
+
+// offer considers a key written between written and acked with ttl for the
+// reservoir (Vitter's algorithm R). Keys the reservoir does not take cost
+// an atomic increment.
+func (s *ttlSampler) offer(rng *rand.Rand, k ttlKey) {
+	n := s.seen.Add(1)
+	if n > int64(s.size) {
+		j := rng.Int63n(n)
+		if j >= int64(s.size) {
+			return
+		}
+		s.mu.Lock()
+		s.keys[j] = &k
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Lock()
+	s.keys = append(s.keys, &k)
+	s.mu.Unlock()
+}
+
+func (s *ttlSampler) run(interval time.Duration) {
+	defer close(s.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+		}
+		s.sample()
+	}
+}
+
+// sample issues a PTTL for every live key of the reservoir in one pipeline.
+func (s *ttlSampler) sample() {
+	s.mu.Lock()
+	keys := make([]*ttlKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		if !k.done {
+			keys = append(keys, k)
+		}
+	}
+	s.mu.Unlock()
+	if len(keys) == 0 {
+		return
+	}
+	pipe := s.rdb.Pipeline()
+	cmds := make([]*redis.DurationCmd, len(keys))
+	for i, k := range keys {
+		cmds[i] = pipe.PTTL(context.Background(), k.key)
+	}
+	sent := time.Now()
+	_, _ = pipe.Exec(context.Background())
+	received := time.Now()
+	for i, k := range keys {
+		s.check(k, cmds[i], sent, received)
+	}
+}
+
+// check compares the PTTL of k, sent at sent and answered by received, with
+// its expected expiry. The reply pins the expiry between sent and received
+// plus the time left, to the millisecond; drift is how far that misses the
+// expiry the SET called for.
+func (s *ttlSampler) check(k *ttlKey, cmd *redis.DurationCmd, sent, received time.Time) {
+	remaining, err := cmd.Result()
+	if err != nil {
+		s.errors++
+		return
+	}
+	earliest, latest := k.written.Add(k.ttl), k.acked.Add(k.ttl)
+	switch remaining {
+	case -2:
+		// Gone: fine once its TTL may have run out.
+		if sent.Before(earliest) {
+			s.early++
+		}
+		s.mu.Lock()
+		k.done = true
+		s.mu.Unlock()
+		return
+	case -1:
+		s.none++
+		s.mu.Lock()
+		k.done = true
+		s.mu.Unlock()
+		return
+	}
+	s.samples++
+	s.sampled[k.key] = true
+	lo, hi := sent.Add(remaining-time.Millisecond), received.Add(remaining+time.Millisecond)
+	var drift time.Duration
+	switch {
+	case lo.After(latest):
+		drift = lo.Sub(latest)
+	case hi.Before(earliest):
+		drift = earliest.Sub(hi)
+	}
+	s.drift.Record(drift)
+	if !k.jumped && lo.Sub(latest) > time.Duration(ttlJumpFraction*float64(k.ttl)) {
+		k.jumped = true
+		s.jumped++
+	}
+}
+
+// finish stops sampling and reports what the samples showed.
+func (s *ttlSampler) finish() *ttlReport {
+	close(s.stop)
+	<-s.done
+	s.rdb.Close()
+	rep := &ttlReport{
+		Keys:         len(s.sampled),
+		Samples:      s.samples,
+		Errors:       s.errors,
+		Jumped:       s.jumped,
+		MissingEarly: s.early,
+		NoTTL:        s.none,
+	}
+	if s.drift.Count() > 0 {
+		rep.MeanDriftMs = milliseconds(s.drift.Mean())
+		rep.P99DriftMs = milliseconds(s.drift.Percentile(99))
+		rep.MaxDriftMs = milliseconds(s.drift.Maximum())
+	}
+	return rep
+}
+
+// milliseconds converts d into fractional milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// ttlReport is the outcome of -ttl-sample. A key missing or without a TTL
+// before its TTL ran out is a correctness failure.
+type ttlReport struct {
+	Keys        int     `json:"keys"`
+	Samples     int64   `json:"samples"`
+	Errors      int64   `json:"errors,omitempty"`
+	MeanDriftMs float64 `json:"mean_drift_ms"`
+	P99DriftMs  float64 `json:"p99_drift_ms"`
+	MaxDriftMs  float64 `json:"max_drift_ms"`
+	// Jumped counts the keys that outlived their expected expiry by more
+	// than a tenth of their TTL, as a TTL reset would.
+	Jumped       int64 `json:"jumped"`
+	MissingEarly int64 `json:"missing_early"`
+	NoTTL        int64 `json:"no_ttl"`
+}
+
+func (r *ttlReport) failed() bool {
+	return r != nil && r.MissingEarly+r.NoTTL > 0
+}
+
+// printTTLReport writes the -ttl-sample section of the summary.
+func printTTLReport(w io.Writer, rep *ttlReport) {
+	fmt.Fprintf(w, "TTL accuracy: %d PTTL samples of %d keys, drift mean %.1fms, p99 %.1fms, max %.1fms, %d TTLs jumped",
+		rep.Samples, rep.Keys, rep.MeanDriftMs, rep.P99DriftMs, rep.MaxDriftMs, rep.Jumped)
+	if rep.Errors > 0 {
+		fmt.Fprintf(w, ", %d errors", rep.Errors)
+	}
+	fmt.Fprintln(w)
+	if rep.failed() {
+		fmt.Fprintf(w, "CORRECTNESS FAILURE: before their TTL ran out, %d keys were gone and %d had no TTL\n", rep.MissingEarly, rep.NoTTL)
+	}
+}
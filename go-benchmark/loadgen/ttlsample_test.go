@@ -0,0 +1,67 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+func TestTTLSampleRun(t *testing.T) {
+	mr, _ := newTestServer(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-duration", "300ms", "-ttl", "10s",
+		"-ttl-sample", "5", "-ttl-sample-interval", "20ms")
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rep := res.ttl
+	if rep == nil || rep.Samples == 0 || rep.Keys < 5 || rep.failed() || rep.Jumped != 0 || rep.MaxDriftMs > 1000 {
+		t.Fatalf("TTL report %+v", rep)
+	}
+	if buildReport(cfg, res).TTLAccuracy != rep {
+		t.Error("the JSON report lacks the TTL accuracy")
+	}
+	var buf bytes.Buffer
+	printSummary(&buf, cfg, res)
+	if !strings.Contains(buf.String(), "TTL accuracy: ") {
+		t.Errorf("summary lacks the TTL accuracy:\n%s", buf.String())
+	}
+}
+
+func TestTTLSampleCheck(t *testing.T) {
+	s := &ttlSampler{drift: stats.NewHistogram(), sampled: make(map[string]bool)}
+	now := time.Now()
+	key := func() *ttlKey { return &ttlKey{key: "k", written: now, acked: now.Add(time.Millisecond), ttl: 10 * time.Second} }
+	// On time, then reset to the full TTL 5s later.
+	k := key()
+	s.check(k, redis.NewDurationResult(10*time.Second, nil), now.Add(time.Millisecond), now.Add(time.Millisecond))
+	s.check(k, redis.NewDurationResult(10*time.Second, nil), now.Add(5*time.Second), now.Add(5*time.Second))
+	if s.samples != 2 || s.jumped != 1 || s.drift.Maximum() < 4*time.Second {
+		t.Errorf("%d samples, %d jumped, max drift %v", s.samples, s.jumped, s.drift.Maximum())
+	}
+	// Gone or without a TTL before it ran out; gone after it is fine.
+	s.check(key(), redis.NewDurationResult(-2, nil), now.Add(time.Second), now.Add(time.Second))
+	s.check(key(), redis.NewDurationResult(-1, nil), now.Add(time.Second), now.Add(time.Second))
+	s.check(key(), redis.NewDurationResult(-2, nil), now.Add(11*time.Second), now.Add(11*time.Second))
+	rep := &ttlReport{MissingEarly: s.early, NoTTL: s.none}
+	if s.early != 1 || s.none != 1 || !rep.failed() {
+		t.Errorf("%d gone early, %d without a TTL", s.early, s.none)
+	}
+	for _, args := range [][]string{
+		{"-ttl-sample", "5"},
+		{"-ttl-sample", "5", "-ttl", "1s", "-workload", "get"},
+		{"-ttl-sample", "5", "-ttl", "1s", "-pipeline", "4"},
+		{"-ttl-sample-interval", "1s"},
+		{"-ttl-sample", "5", "-ttl", "1s", "-ttl-sample-interval", "0s"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+}
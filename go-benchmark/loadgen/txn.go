@@ -0,0 +1,151 @@
+package loadgen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// errTxnGaveUp fails a transaction whose EXEC was aborted by conflicting
+// writes -txn-retries times in a row. Nothing was applied.
+var errTxnGaveUp = errors.New("transaction aborted on every retry")
+
+// usesCounters reports whether the workload increments the -counter-keys
+// counters, with INCR or with transactions, so they are checked after the
+// run.
+func (c *config) usesCounters() bool {
+	return c.mix != nil && (c.mix.share(opIncr) > 0 || c.mix.share(opTxn) > 0)
+}
+
+// issueTxn increments a counter with an optimistic transaction: WATCH the
+// counter, GET it, then MULTI, SET of the value plus one, EXEC. An EXEC
+// aborted because another client changed the counter is retried from the
+// WATCH, up to -txn-retries times. The returned command carries the number
+// of retries as its value, and the whole sequence is timed as one
+// operation.
+func (w *worker) issueTxn(ctx context.Context) pendingOp {
+	cfg := w.run.cfg
+	i := w.rng.Intn(cfg.counterKeys)
+	key := cfg.counterName(i)
+	incr := func(tx *redis.Tx) error {
+		n, err := tx.Get(ctx, key).Int64()
+		if err != nil && !isMiss(err) {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, n+1, 0)
+			return nil
+		})
+		return err
+	}
+	cmd := redis.NewIntCmd(ctx, "txn", key)
+	retries := 0
+	for {
+		err := w.rdb.Watch(ctx, incr, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			w.result.txnAborts++
+			if retries < cfg.txnRetries {
+				retries++
+				continue
+			}
+			err = errTxnGaveUp
+		}
+		cmd.SetErr(err)
+		break
+	}
+	cmd.SetVal(int64(retries))
+	return pendingOp{op: opTxn, cmd: cmd, key: key, counter: i}
+}
+
+// recordTxnRetries counts a committed transaction by its number of retries.
+func (r *workerResult) recordTxnRetries(n int) {
+	for len(r.txnRetries) <= n {
+		r.txnRetries = append(r.txnRetries, 0)
+	}
+	r.txnRetries[n]++
+}
+
+// mergeTxnRetries adds the retry distribution o to r.
+func (r *workerResult) mergeTxnRetries(o []int64) {
+	for n, count := range o {
+		if count > 0 {
+			for len(r.txnRetries) <= n {
+				r.txnRetries = append(r.txnRetries, 0)
+			}
+			r.txnRetries[n] += count
+		}
+	}
+}
+
+// validateTxn checks the flags of the txn workload.
+func (c *config) validateTxn() error {
+	if c.txnRetries < 0 {
+		return fmt.Errorf("-txn-retries must not be negative, got %d", c.txnRetries)
+	}
+	if c.pipeline > 1 {
+		// WATCH needs the reply of the GET before MULTI.
+		return errors.New("-pipeline does not apply to transactions")
+	}
+	return nil
+}
+
+// jsonTxn describes the transactions of a run.
+type jsonTxn struct {
+	Committed int64 `json:"committed"`
+	// Aborts counts the EXECs aborted by a conflicting write; AbortRate
+	// is their share of all EXECs.
+	Aborts    int64   `json:"aborts"`
+	AbortRate float64 `json:"abort_rate"`
+	// GaveUp counts the transactions that failed after -txn-retries.
+	GaveUp int64 `json:"gave_up"`
+	// RetriesPerCommit[n] counts the transactions committed after n
+	// retries.
+	RetriesPerCommit []int64 `json:"retries_per_commit"`
+	MeanRetries      float64 `json:"mean_retries"`
+	MaxRetries       int     `json:"max_retries"`
+}
+
+// buildTxn summarises the transactions of r, nil when there were none.
+func buildTxn(r *workerResult) *jsonTxn {
+	s := &r.ops[opTxn]
+	if s.attempts() == 0 {
+		return nil
+	}
+	t := &jsonTxn{Aborts: r.txnAborts, GaveUp: r.txnGaveUp, RetriesPerCommit: r.txnRetries}
+	var retries int64
+	for n, count := range r.txnRetries {
+		t.Committed += count
+		retries += int64(n) * count
+		if count > 0 {
+			t.MaxRetries = n
+		}
+	}
+	if t.Committed > 0 {
+		t.MeanRetries = float64(retries) / float64(t.Committed)
+	}
+	// EXECs that failed for other reasons are errors of the run.
+	if execs := t.Committed + t.Aborts; execs > 0 {
+		t.AbortRate = float64(t.Aborts) / float64(execs)
+	}
+	return t
+}
+
+// printTxn writes the transaction section of the summary.
+func printTxn(w io.Writer, cfg *config, r *workerResult) {
+	t := buildTxn(r)
+	if t == nil {
+		return
+	}
+	fmt.Fprintf(w, "Transactions: %d committed, %d EXECs aborted by conflicts (%.2f%% abort rate), %d gave up after %d retries\n",
+		t.Committed, t.Aborts, 100*t.AbortRate, t.GaveUp, cfg.txnRetries)
+	fmt.Fprintf(w, "Retries per committed transaction: mean %.2f, max %d;", t.MeanRetries, t.MaxRetries)
+	for n, count := range t.RetriesPerCommit {
+		if count > 0 {
+			fmt.Fprintf(w, " %d: %d", n, count)
+		}
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,59 @@
+package loadgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"go-benchmark/stats"
+)
+
+func TestTxnWorkload(t *testing.T) {
+	mr := miniredis.RunT(t)
+	// Few counters and many clients make transactions conflict.
+	cfg := testConfig(t, "-addr", mr.Addr(), "-workload", "txn", "-counter-keys", "2",
+		"-clients", "8", "-ops", "25", "-key-prefix", "tx:")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.counters == nil || res.counters.failed() {
+		t.Fatalf("counter check %+v, want the counters to match the committed transactions", res.counters)
+	}
+	txn := buildTxn(res.total)
+	if txn == nil || txn.Committed != 8*25 || txn.GaveUp != 0 {
+		t.Fatalf("transactions %+v, want %d committed", txn, 8*25)
+	}
+	if res.counters.Increments != txn.Committed {
+		t.Errorf("%d increments verified, want %d", res.counters.Increments, txn.Committed)
+	}
+	var retries int64
+	for n, count := range txn.RetriesPerCommit {
+		retries += int64(n) * count
+	}
+	if retries != txn.Aborts {
+		t.Errorf("%d retries of committed transactions, want one per abort (%d)", retries, txn.Aborts)
+	}
+}
+
+func TestBuildTxn(t *testing.T) {
+	r := newWorkerResult(stats.DefaultLayout)
+	r.ops[opTxn].record(stats.DefaultLayout, 1)
+	r.txnRetries = []int64{6, 2, 0, 2}
+	r.txnAborts = 8
+
+	txn := buildTxn(r)
+
+	if txn.Committed != 10 || txn.MaxRetries != 3 || txn.MeanRetries != 0.8 {
+		t.Errorf("got %+v, want 10 committed, max 3 and mean 0.8 retries", txn)
+	}
+	if want := 8.0 / 18; txn.AbortRate != want {
+		t.Errorf("abort rate %v, want %v", txn.AbortRate, want)
+	}
+	if _, err := parseFlags([]string{"-workload", "txn", "-pipeline", "10"}); err == nil {
+		t.Error("pipelined transactions were accepted")
+	}
+}
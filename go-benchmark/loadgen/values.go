@@ -0,0 +1,19 @@
+package loadgen
+
+import (
+	"math/rand"
+	"strconv"
+)
+
+// nextValue returns the payload for the i-th operation of a worker. Without
+// -value-size or -value-size-range the legacy "value<i>" strings are used,
+// unless -value-template shapes them.
+func (c *config) nextValue(rng *rand.Rand, i int) []byte {
+	if c.valueTemplate != nil {
+		return c.valueTemplate.Append(nil, int64(i), 0, rng.Uint64())
+	}
+	if c.values == nil {
+		return strconv.AppendInt([]byte("value"), int64(i), 10)
+	}
+	return c.values.Next(rng)
+}
@@ -0,0 +1,328 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+const (
+	// sealSize is the trailer -verify appends to the values it writes:
+	// the writing client and its sequence number in 24 hex digits, then
+	// the CRC-32 of everything before it in 8.
+	sealSize = 32
+	// maxVerifyFailures is how many read-back failures are logged.
+	maxVerifyFailures = 20
+	// verifyPrefix is how much of a value a logged failure shows.
+	verifyPrefix = 48
+)
+
+// Kinds of read-back failures. A value whose checksum does not match is
+// corrupt; an intact one this client wrote earlier is stale.
+const (
+	verifyCorrupt = "corrupt"
+	verifyStale   = "stale"
+	verifyMissing = "missing"
+)
+
+// validateVerify checks the flags of -verify.
+func (c *config) validateVerify() error {
+	if c.workload != workloadSet && (c.mix == nil || c.mix.share(opSet) == 0) {
+		return fmt.Errorf("-verify needs a workload that SETs, not -workload %s", c.workload)
+	}
+	switch {
+	case c.verifyFraction <= 0 || c.verifyFraction > 1:
+		return fmt.Errorf("-verify-fraction must be in (0, 1], got %v", c.verifyFraction)
+	case c.pipeline > 1:
+		return errors.New("-pipeline does not apply to -verify, which reads every checked SET back before the next command")
+	case c.cluster:
+		return errors.New("-verify does not apply to -cluster")
+	case c.churn:
+		return errors.New("-verify does not apply to -churn")
+	}
+	return nil
+}
+
+// verifyMayMiss reports whether a key may legitimately be gone when it is
+// read back: it has a TTL, the mix deletes or expires keys, or the server is
+// pushed into eviction.
+func (c *config) verifyMayMiss() bool {
+	return c.ttlMax > 0 || c.evictPressure || (c.mix != nil && (c.mix.share(opDel) > 0 || c.mix.share(opExpire) > 0))
+}
+
+// sealValue returns a copy of value whose last sealSize bytes name the
+// client and sequence number and checksum the rest. Values shorter than the
+// seal grow to its size.
+func sealValue(value []byte, client int, seq int64) []byte {
+	body := max(len(value)-sealSize, 0)
+	sealed := make([]byte, 0, body+sealSize)
+	sealed = append(sealed, value[:body]...)
+	sealed = fmt.Appendf(sealed, "%08x%016x", uint32(client), uint64(seq))
+	return fmt.Appendf(sealed, "%08x", crc32.ChecksumIEEE(sealed))
+}
+
+// sealFiller returns filler, the payload of a SET of -verify, sealed with
+// sealValue. The seal of a structured value follows the header structure
+// writes over the start of the filler: the header's CRC covers the seal,
+// and the seal's the filler after the header. A filler too short for both
+// grows to their size.
+func (c *config) sealFiller(filler []byte, client int, seq int64) []byte {
+	if !c.structured() {
+		return sealValue(filler, client, seq)
+	}
+	rest := filler[min(len(filler), valueHeaderSize):]
+	sealed := make([]byte, valueHeaderSize, valueHeaderSize+max(len(rest), sealSize))
+	copy(sealed, filler)
+	return append(sealed, sealValue(rest, client, seq)...)
+}
+
+// openValueSeal opens the seal sealFiller put in value, read back for key.
+// A structured value must decode intact as well.
+func (c *config) openValueSeal(value []byte, key string) (client int, seq int64, ok bool) {
+	if !c.structured() {
+		return openSeal(value)
+	}
+	if _, kind := decodeValue(value, key); kind != valueIntact || len(value) < valueHeaderSize {
+		return 0, 0, false
+	}
+	return openSeal(value[valueHeaderSize:])
+}
+
+// openSeal checks the seal of value and returns the client and sequence
+// number in it; ok is false when the value is corrupt.
+func openSeal(value []byte) (client int, seq int64, ok bool) {
+	if len(value) < sealSize {
+		return 0, 0, false
+	}
+	n := len(value)
+	var sum [4]byte
+	if _, err := hex.Decode(sum[:], value[n-8:]); err != nil {
+		return 0, 0, false
+	}
+	if crc32.ChecksumIEEE(value[:n-8]) != uint32(sum[0])<<24|uint32(sum[1])<<16|uint32(sum[2])<<8|uint32(sum[3]) {
+		return 0, 0, false
+	}
+	c, err := strconv.ParseUint(string(value[n-32:n-24]), 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	s, err := strconv.ParseUint(string(value[n-24:n-8]), 16, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return int(c), int64(s), true
+}
+
+// verifyFailure is a read-back that did not return the value just written.
+type verifyFailure struct {
+	At       time.Time `json:"at"`
+	Key      string    `json:"key"`
+	Kind     string    `json:"kind"`
+	Client   int       `json:"client"`
+	Expected string    `json:"expected_prefix"`
+	Actual   string    `json:"actual_prefix,omitempty"`
+}
+
+// verifyStats counts the read-backs of -verify.
+type verifyStats struct {
+	checked int64
+	// corrupt, stale and missing are correctness failures; a key may only
+	// be missing legitimately when verifyMayMiss. overwritten counts
+	// intact values of another client, which shares the key.
+	corrupt, stale, missing int64
+	expectedMiss            int64
+	overwritten             int64
+	errors                  int64
+	// get is the latency of the read-backs, kept apart from the run's.
+	get      *stats.Histogram
+	failures []verifyFailure
+}
+
+func (s *verifyStats) merge(o *verifyStats) {
+	s.checked += o.checked
+	s.corrupt += o.corrupt
+	s.stale += o.stale
+	s.missing += o.missing
+	s.expectedMiss += o.expectedMiss
+	s.overwritten += o.overwritten
+	s.errors += o.errors
+	s.get.Merge(o.get)
+	for _, f := range o.failures {
+		if len(s.failures) < maxVerifyFailures {
+			s.failures = append(s.failures, f)
+		}
+	}
+}
+
+// failed returns the number of correctness failures.
+func (s *verifyStats) failed() int64 {
+	return s.corrupt + s.stale + s.missing
+}
+
+// verifyStats returns the -verify counts of r, allocating them on first
+// use.
+func (r *workerResult) verifyStats() *verifyStats {
+	if r.verify == nil {
+		r.verify = &verifyStats{get: r.layout.New()}
+	}
+	return r.verify
+}
+
+// verifyConn returns the connection the worker writes the SETs it reads
+// back through, so the read is on the connection the write was.
+func (w *worker) verifyConn() *redis.Conn {
+	if w.conn == nil {
+		w.conn = w.rdb.(*redis.Client).Conn(context.Background())
+		if f := w.run.cfg.faults; f != nil {
+			// A Conn does not inherit the hooks of its client.
+			w.conn.AddHook(f)
+		}
+	}
+	return w.conn
+}
+
+// dropVerifyConn closes the -verify connection after a connection error;
+// the next check takes a new one.
+func (w *worker) dropVerifyConn() {
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+// afterVerify reads a sealed SET back on its connection and compares the
+// value byte for byte.
+func (w *worker) afterVerify(p pendingOp) {
+	if err := p.cmd.Err(); err != nil {
+		if isConnectionLoss(err) {
+			w.dropVerifyConn()
+		}
+		return
+	}
+	opCtx, cancel := w.opContext()
+	start := time.Now()
+	got, err := w.conn.Get(opCtx, p.key).Bytes()
+	end := time.Now()
+	cancel()
+	if !w.measuring {
+		return
+	}
+	s := w.result.verifyStats()
+	if err != nil && !isMiss(err) {
+		s.errors++
+		if isConnectionLoss(err) {
+			w.dropVerifyConn()
+		}
+		return
+	}
+	s.checked++
+	s.get.Record(end.Sub(start))
+	kind := ""
+	switch {
+	case isMiss(err):
+		if w.run.cfg.verifyMayMiss() {
+			s.expectedMiss++
+			return
+		}
+		s.missing++
+		kind = verifyMissing
+	case bytes.Equal(got, p.value):
+		return
+	default:
+		cfg := w.run.cfg
+		client, seq, ok := cfg.openValueSeal(got, p.key)
+		_, want, _ := cfg.openValueSeal(p.value, p.key)
+		switch {
+		case !ok || (client == w.id && seq == want):
+			// hex.Decode takes both cases, so a seal can survive a
+			// flipped bit in its checksum: the write itself came back
+			// different, which is corruption.
+			s.corrupt++
+			kind = verifyCorrupt
+		case client != w.id:
+			s.overwritten++
+			return
+		default:
+			s.stale++
+			kind = verifyStale
+		}
+	}
+	if len(s.failures) < maxVerifyFailures {
+		s.failures = append(s.failures, verifyFailure{At: end, Key: p.key, Kind: kind, Client: w.id,
+			Expected: valuePrefix(p.value), Actual: valuePrefix(got)})
+	}
+}
+
+// valuePrefix returns the start of value for the failure log.
+func valuePrefix(value []byte) string {
+	if len(value) > verifyPrefix {
+		value = value[:verifyPrefix]
+	}
+	return string(value)
+}
+
+// jsonVerify describes the read-backs of a -verify run.
+type jsonVerify struct {
+	Fraction     float64         `json:"fraction"`
+	Checked      int64           `json:"checked"`
+	Corrupt      int64           `json:"corrupt"`
+	Stale        int64           `json:"stale"`
+	Missing      int64           `json:"missing"`
+	ExpectedMiss int64           `json:"expected_misses,omitempty"`
+	Overwritten  int64           `json:"overwritten,omitempty"`
+	Errors       int64           `json:"errors,omitempty"`
+	GetLatency   *latencySummary `json:"get_latency"`
+	Failures     []verifyFailure `json:"failures,omitempty"`
+}
+
+// buildVerify summarises the read-backs of a run, nil without -verify.
+func buildVerify(cfg *config, total *workerResult) *jsonVerify {
+	if !cfg.verify {
+		return nil
+	}
+	s := total.verifyStats()
+	return &jsonVerify{
+		Fraction:     cfg.verifyFraction,
+		Checked:      s.checked,
+		Corrupt:      s.corrupt,
+		Stale:        s.stale,
+		Missing:      s.missing,
+		ExpectedMiss: s.expectedMiss,
+		Overwritten:  s.overwritten,
+		Errors:       s.errors,
+		GetLatency:   summarizeLatency(s.get),
+		Failures:     s.failures,
+	}
+}
+
+// printVerify writes the -verify section of the summary.
+func printVerify(w io.Writer, u latencyUnit, cfg *config, total *workerResult) {
+	if !cfg.verify {
+		return
+	}
+	s := total.verifyStats()
+	fmt.Fprintf(w, "Read-your-writes: %d SETs read back (%.1f%%), %d corrupt, %d stale, %d missing\n",
+		s.checked, 100*cfg.verifyFraction, s.corrupt, s.stale, s.missing)
+	if s.expectedMiss > 0 || s.overwritten > 0 || s.errors > 0 {
+		fmt.Fprintf(w, "  expired or deleted: %d, overwritten by another client: %d, read errors: %d\n",
+			s.expectedMiss, s.overwritten, s.errors)
+	}
+	printLatency(w, u, "Read-back GET", s.get)
+	if s.failed() > 0 {
+		fmt.Fprintf(w, "WARNING: %d read-backs did not return the value just written\n", s.failed())
+		for _, f := range s.failures {
+			fmt.Fprintf(w, "  %s %s %s by client %d: wrote %q, read %q\n",
+				f.At.Format(time.RFC3339Nano), f.Kind, f.Key, f.Client, f.Expected, f.Actual)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestSealValue(t *testing.T) {
+	for _, value := range []string{"", "value7", strings.Repeat("x", 100)} {
+		sealed := sealValue([]byte(value), 3, 42)
+		if len(sealed) != max(len(value), sealSize) {
+			t.Errorf("%d-byte value sealed to %d bytes", len(value), len(sealed))
+		}
+		client, seq, ok := openSeal(sealed)
+		if !ok || client != 3 || seq != 42 {
+			t.Errorf("seal of %q opened as client %d, seq %d, %v", value, client, seq, ok)
+		}
+		corrupt := bytes.Clone(sealed)
+		corrupt[0] ^= 1
+		if _, _, ok := openSeal(corrupt); ok {
+			t.Errorf("corrupted %q passed its checksum", corrupt)
+		}
+	}
+	v := []byte(strings.Repeat("v", 64))
+	if sealValue(v, 0, 1); string(v) != strings.Repeat("v", 64) {
+		t.Error("sealing modified the value")
+	}
+}
+
+func TestSealStructuredValue(t *testing.T) {
+	for _, size := range []string{"16", "40", "64", "200"} {
+		cfg := testConfig(t, "-value-size", size, "-verify")
+		filler := cfg.nextValue(rand.New(rand.NewSource(1)), 0)
+		v := cfg.structure(cfg.sealFiller(filler, 3, 42), "k", 3, 7)
+		h, kind := decodeValue(v, "k")
+		if kind != valueIntact || h.writer != 3 || h.seq != 7 {
+			t.Errorf("%s-byte sealed value decoded as %+v, %q", size, h, kind)
+		}
+		if client, seq, ok := cfg.openValueSeal(v, "k"); !ok || client != 3 || seq != 42 {
+			t.Errorf("%s-byte seal opened as client %d, seq %d, %v", size, client, seq, ok)
+		}
+		corrupt := bytes.Clone(v)
+		corrupt[len(corrupt)-sealSize] ^= 1
+		if _, _, ok := cfg.openValueSeal(corrupt, "k"); ok {
+			t.Errorf("%s-byte value with a corrupt seal passed", size)
+		}
+	}
+}
+
+func TestVerifyReadYourWrites(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "2", "-ops", "200", "-value-size", "64", "-key-prefix", "vf:",
+		"-verify", "-verify-fraction", "0.5")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := res.total.verify
+	if s == nil || s.checked < 100 || s.checked > 300 || s.failed() != 0 || res.verifyFailed() {
+		t.Fatalf("read-backs %+v, want about half of 400 SETs, all intact", s)
+	}
+	if s.get.Count() != s.checked || res.total.ops[opGet].attempts() != 0 {
+		t.Errorf("%d read-back latencies, %d GETs in the workload stats", s.get.Count(), res.total.ops[opGet].attempts())
+	}
+}
+
+func TestVerifySharedKeysDefaultFraction(t *testing.T) {
+	mr := miniredis.RunT(t)
+	// Clients overwrite each other's keys, most SETs without a read-back.
+	cfg := testConfig(t, "-addr", mr.Addr(), "-clients", "4", "-ops", "500", "-ratio", "get=1,set=1", "-keyspace", "50",
+		"-preload", "50", "-key-prefix", "vs:", "-verify")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := res.total.verify
+	if s == nil || s.checked == 0 || s.corrupt != 0 || s.failed() != 0 || res.verifyFailed() {
+		t.Fatalf("read-backs %+v of a correct server", s)
+	}
+}
+
+func TestVerifyFailures(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		tamper func(value, prev string, found bool) (string, bool)
+		check  func(s *verifyStats) int64
+	}{
+		{verifyCorrupt, func(v, _ string, ok bool) (string, bool) { return "X" + v[1:], ok }, func(s *verifyStats) int64 { return s.corrupt }},
+		{verifyStale, func(v, prev string, ok bool) (string, bool) {
+			if prev == "" {
+				return v, ok
+			}
+			return prev, ok
+		}, func(s *verifyStats) int64 { return s.stale }},
+		{verifyMissing, func(string, string, bool) (string, bool) { return "", false }, func(s *verifyStats) int64 { return s.missing }},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			addr := startTamperingCache(t, 1000, false, c.tamper)
+			// One client rewriting one key, every SET read back.
+			cfg := testConfig(t, "-addr", addr, "-clients", "1", "-ops", "20", "-ratio", "set=1", "-keyspace", "1",
+				"-key-prefix", "vf:", "-verify", "-verify-fraction", "1")
+
+			res, err := runTarget(context.Background(), cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			s := res.total.verify
+			if n := c.check(s); n < 19 || n != s.failed() || !res.verifyFailed() {
+				t.Fatalf("read-backs %+v, want the %s ones failing the run", s, c.name)
+			}
+			if len(s.failures) == 0 || s.failures[0].Kind != c.name || s.failures[0].Key != cfg.keyName(0) || s.failures[0].At.IsZero() {
+				t.Errorf("failure log %+v", s.failures)
+			}
+			if code := checkVerification(cfg, []*runResult{res}); code != exitVerifyFailed {
+				t.Errorf("exit code %d", code)
+			}
+		})
+	}
+}
+
+func TestVerifyFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-verify", "-workload", "incr"},
+		{"-verify", "-verify-fraction", "0"},
+		{"-verify", "-pipeline", "4"},
+		{"-verify", "-churn"},
+	} {
+		if _, err := parseFlags(args); err == nil {
+			t.Errorf("%v was accepted", args)
+		}
+	}
+	if cfg := testConfig(t, "-verify", "-ratio", "set=1,del=1"); !cfg.verifyMayMiss() {
+		t.Error("a mix that deletes keys expects none to be missing")
+	}
+}
@@ -0,0 +1,61 @@
+package loadgen
+
+import (
+	"errors"
+	"time"
+)
+
+// validateWindow checks -percentile-window.
+func (c *config) validateWindow() error {
+	if c.percentileWindow < 0 {
+		return errors.New("-percentile-window must not be negative")
+	}
+	return nil
+}
+
+// slidingWindow holds the live histogram deltas of the last few intervals
+// and their sum, so percentiles over the window cost one subtraction and one
+// addition per interval whatever the length of the run. Memory is fixed by
+// the number of intervals, a liveSnapshot for each.
+type slidingWindow struct {
+	slots []liveSnapshot
+	next  int
+	sum   liveSnapshot
+}
+
+// newSlidingWindow returns a window of length covered by intervals of the
+// given duration, rounded up to at least one interval; nil when length is
+// zero, which disables windowed percentiles.
+func newSlidingWindow(length, interval time.Duration) *slidingWindow {
+	if length <= 0 {
+		return nil
+	}
+	n := max(1, int((length+interval-1)/interval))
+	return &slidingWindow{slots: make([]liveSnapshot, n)}
+}
+
+// add rotates the deltas of an interval in, dropping those of the oldest.
+func (w *slidingWindow) add(delta *liveSnapshot) {
+	old := &w.slots[w.next]
+	for i := range w.sum {
+		w.sum[i] += delta[i] - old[i]
+	}
+	*old = *delta
+	w.next = (w.next + 1) % len(w.slots)
+}
+
+// windowLabel names the -percentile-window of the JSON time series, empty
+// when no interval has windowed percentiles.
+func windowLabel(cfg *config, points []timePoint) string {
+	for _, p := range points {
+		if p.WindowP99Ns > 0 {
+			return cfg.percentileWindow.String()
+		}
+	}
+	return ""
+}
+
+// percentile returns the p-th percentile over the window.
+func (w *slidingWindow) percentile(p float64) time.Duration {
+	return w.sum.percentile(p)
+}
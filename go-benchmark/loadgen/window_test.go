@@ -0,0 +1,90 @@
+package loadgen
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"go-benchmark/stats"
+)
+
+func TestSlidingWindowSpike(t *testing.T) {
+	live := &liveCounters{latency: &liveHistogram{}}
+	// Sampled by hand, one interval per second of a 60-second run.
+	s := startSampler(live, time.Hour, time.Now(), 10*time.Hour)
+	overall := stats.NewHistogram()
+	record := func(d time.Duration, n int) {
+		for i := 0; i < n; i++ {
+			live.latency.record(d)
+			overall.Record(d)
+		}
+	}
+	origin := time.Now()
+	for sec := 1; sec <= 60; sec++ {
+		record(time.Millisecond, 1000)
+		if sec == 30 {
+			// A second of background work slows 200 operations to 50ms.
+			record(50*time.Millisecond, 200)
+		}
+		s.sample(origin.Add(time.Duration(sec) * time.Second))
+	}
+	points := s.stop()
+	if len(points) != 60 {
+		t.Fatalf("%d points, want 60", len(points))
+	}
+	ms := int64(time.Millisecond)
+	for i, p := range points {
+		sec := i + 1
+		p99 := time.Duration(p.WindowP99Ns)
+		switch {
+		case sec >= 30 && sec < 40:
+			// 200 of at most 10200 samples are 2% of the window.
+			if p99 < 40*time.Millisecond {
+				t.Errorf("window p99 at %ds is %v, want the spike", sec, p99)
+			}
+		case p.WindowP99Ns > 2*ms:
+			t.Errorf("window p99 at %ds is %v, want about 1ms", sec, p99)
+		}
+		if p.WindowP50Ns > 2*ms {
+			t.Errorf("window p50 at %ds is %v", sec, time.Duration(p.WindowP50Ns))
+		}
+	}
+	// Over the whole run the spike is 0.3% of the samples.
+	if p99 := overall.Percentile(99); p99 > 2*time.Millisecond {
+		t.Errorf("overall p99 %v, want about 1ms", p99)
+	}
+
+	var buf bytes.Buffer
+	printTimeSeries(&buf, points, 0, 10*time.Second)
+	if !strings.Contains(buf.String(), "p99/10s") {
+		t.Errorf("time series lacks the windowed columns:\n%s", buf.String())
+	}
+}
+
+func TestSlidingWindowRotation(t *testing.T) {
+	if newSlidingWindow(0, time.Second) != nil {
+		t.Error("a zero window was allocated")
+	}
+	if w := newSlidingWindow(2500*time.Millisecond, time.Second); len(w.slots) != 3 {
+		t.Errorf("a 2.5s window of 1s intervals has %d slots, want 3", len(w.slots))
+	}
+	w := newSlidingWindow(2*time.Second, time.Second)
+	one := func(d time.Duration) *liveSnapshot {
+		var s liveSnapshot
+		s[liveBucket(int64(d))] = 1
+		return &s
+	}
+	w.add(one(time.Second))
+	w.add(one(time.Millisecond))
+	if got := w.percentile(100); got < time.Second {
+		t.Errorf("max over both intervals %v", got)
+	}
+	w.add(one(time.Millisecond))
+	if got := w.percentile(100); got > 2*time.Millisecond {
+		t.Errorf("max after the 1s interval rotated out %v", got)
+	}
+	if _, err := parseFlags([]string{"-percentile-window", "-1s"}); err == nil {
+		t.Error("a negative -percentile-window was accepted")
+	}
+}
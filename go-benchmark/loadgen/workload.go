@@ -0,0 +1,777 @@
+package loadgen
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-benchmark/stats"
+)
+
+// Supported values for -workload besides the single-command workloads named
+// after their command (get, del, ...).
+const (
+	workloadSet   = "set"
+	workloadMixed = "mixed"
+	// workloadHash runs -ratio, or defaultHashRatio, over a keyspace of
+	// hashes.
+	workloadHash = "hash"
+)
+
+// opType identifies the command issued by a single benchmark operation.
+type opType int
+
+const (
+	opSet opType = iota
+	opGet
+	opDel
+	opExpire
+	opIncr
+	opSetNX
+	opHSet
+	opHGet
+	opHGetAll
+	opLPush
+	opRPop
+	opBRPop
+	opZAdd
+	opZRange
+	opZRevRange
+	opSAdd
+	opSIsMember
+	opSMembers
+	opMGet
+	opMSet
+	opScan
+	opTxn
+	opScript
+	opPublish
+	opAppend
+	opGetRange
+	numOpTypes
+)
+
+var opNames = [numOpTypes]string{
+	opSet:       "SET",
+	opGet:       "GET",
+	opDel:       "DEL",
+	opExpire:    "EXPIRE",
+	opIncr:      "INCR",
+	opSetNX:     "SETNX",
+	opHSet:      "HSET",
+	opHGet:      "HGET",
+	opHGetAll:   "HGETALL",
+	opLPush:     "LPUSH",
+	opRPop:      "RPOP",
+	opBRPop:     "BRPOP",
+	opZAdd:      "ZADD",
+	opZRange:    "ZRANGE",
+	opZRevRange: "ZREVRANGE",
+	opSAdd:      "SADD",
+	opSIsMember: "SISMEMBER",
+	opSMembers:  "SMEMBERS",
+	opMGet:      "MGET",
+	opMSet:      "MSET",
+	opScan:      "SCAN",
+	opTxn:       "TXN",
+	opScript:    "EVALSHA",
+	opPublish:   "PUBLISH",
+	opAppend:    "APPEND",
+	opGetRange:  "GETRANGE",
+}
+
+// singleOpWorkloads are the commands that can be run on their own with
+// -workload <name>. The set workload is handled separately because it writes
+// fresh keys; incr and setnx work on pools of their own, -counter-keys
+// counters and -lock-keys locks; txn increments the counters in WATCH/MULTI/
+// EXEC transactions. mget and mset address -batch-keys keys per command.
+var singleOpWorkloads = map[opType]bool{
+	opGet:    true,
+	opDel:    true,
+	opExpire: true,
+	opIncr:   true,
+	opSetNX:  true,
+	opMGet:   true,
+	opMSet:   true,
+	opTxn:    true,
+}
+
+// workloadNames lists the accepted -workload values for help and errors.
+func workloadNames() string {
+	names := []string{workloadSet, workloadChurn}
+	for op := opType(0); op < numOpTypes; op++ {
+		if singleOpWorkloads[op] {
+			names = append(names, strings.ToLower(op.String()))
+		}
+	}
+	return strings.Join(append(names, workloadHash, workloadZSet, workloadSets, workloadQueue, workloadPubSub, workloadScan, workloadScript, workloadTracking, workloadAppend, workloadMixed), ", ")
+}
+
+func (o opType) String() string {
+	return opNames[o]
+}
+
+// parseOpType maps a lower-case command name as used in -ratio to its opType.
+func parseOpType(name string) (opType, bool) {
+	for i, n := range opNames {
+		if strings.EqualFold(n, name) {
+			return opType(i), true
+		}
+	}
+	return 0, false
+}
+
+// notFoundOps are the commands whose successful replies can report a missing
+// key or field, listed with their found/not-found counts.
+var notFoundOps = []opType{opDel, opExpire, opHGet, opHGetAll}
+
+// opStats accumulates the outcome of every operation of one command type.
+// hits and misses count successful operations that found or did not find
+// their key (a GET miss, a DEL or EXPIRE of a missing key, an HGET of a
+// missing field, an MGET key with a nil reply).
+type opStats struct {
+	latency *stats.Histogram
+	hits    int64
+	misses  int64
+	errors  int64
+	// timeouts are the errors caused by -op-timeout expiring.
+	timeouts int64
+	// acquire and roundTrip split latency under -latency-split.
+	acquire   *stats.Histogram
+	roundTrip *stats.Histogram
+	// retries counts the commands -retries sent again.
+	retries int64
+}
+
+// attempts returns how many operations of this type were issued.
+func (s *opStats) attempts() int64 {
+	n := s.errors
+	if s.latency != nil {
+		n += s.latency.Count()
+	}
+	return n
+}
+
+func (s *opStats) record(l stats.Layout, d time.Duration) {
+	// Histograms are allocated lazily so unused commands cost no memory.
+	if s.latency == nil {
+		s.latency = l.New()
+	}
+	s.latency.Record(d)
+}
+
+func (s *opStats) merge(o *opStats) {
+	if o.latency != nil {
+		if s.latency == nil {
+			s.latency = o.latency.Empty()
+		}
+		s.latency.Merge(o.latency)
+	}
+	s.acquire = mergeHistogram(s.acquire, o.acquire)
+	s.roundTrip = mergeHistogram(s.roundTrip, o.roundTrip)
+	s.hits += o.hits
+	s.misses += o.misses
+	s.errors += o.errors
+	s.timeouts += o.timeouts
+	s.retries += o.retries
+}
+
+// workerResult holds everything a single client measured during the run:
+// operation count, cumulative duration, min/max and percentiles through its
+// histograms, and error counts per command. It is owned by exactly one
+// goroutine until the run is over, then merged, so recording needs no locks.
+type workerResult struct {
+	// layout is the layout of the histograms the run records.
+	layout stats.Layout
+	// latency is the service time: from the moment a command was actually
+	// sent until its reply arrived.
+	latency *stats.Histogram
+	// response is the latency measured from the intended send time on the
+	// -rate schedule, which includes time spent waiting behind a slow
+	// server. It corrects for coordinated omission and is nil when the run
+	// was not paced.
+	response     *stats.Histogram
+	ops          [numOpTypes]opStats
+	errClasses   [numErrClasses]int64
+	bytesWritten int64
+	bytesRead    int64
+	warmupOps    int64
+	// hashSizeMismatches counts HGETALL replies with a field count other
+	// than -hash-fields.
+	hashSizeMismatches int64
+	// zsetChecked counts the range replies sampled by -zset-verify, of
+	// which zsetDisorders were out of order and zsetShort held fewer
+	// entries than the sorted set must have.
+	zsetChecked   int64
+	zsetDisorders int64
+	zsetShort     int64
+	// setWrong counts SISMEMBER answers that contradict the known
+	// membership; setShort counts SMEMBERS replies missing members.
+	setWrong int64
+	setShort int64
+	// mgetWrongLength counts MGET replies without one element per key.
+	mgetWrongLength int64
+	// scan describes the SCAN passes; nil unless the worker scanned.
+	// fgScanning and fgIdle split the foreground latency of the scan
+	// workload by whether a pass was in progress.
+	scan       *scanStats
+	fgScanning *stats.Histogram
+	fgIdle     *stats.Histogram
+	// txnAborts counts the EXECs aborted by a conflicting write and
+	// txnGaveUp the transactions that failed after -txn-retries of them;
+	// txnRetries[n] counts the transactions committed after n retries.
+	txnAborts  int64
+	txnGaveUp  int64
+	txnRetries []int64
+	// scriptFallbacks counts the EVALSHAs repeated with EVAL after a
+	// NOSCRIPT reply.
+	scriptFallbacks int64
+	// pub tallies the messages published, warmup included; nil outside
+	// the pubsub workload.
+	pub *pubTally
+	// churn describes the connections dialed by -churn; nil without it.
+	churn *churnStats
+	// tracking describes the local cache of the tracking workload; nil
+	// outside it.
+	tracking *trackingStats
+	// resilience splits latency around the outages of -resilience; nil
+	// without it.
+	resilience *resilienceStats
+	// retry counts the retries of -retries; nil without it.
+	retry *retryStats
+	// evict counts the read-backs and OOM replies of -evict-pressure; nil
+	// without it.
+	evict *evictStats
+	// verify counts the read-backs of -verify; nil without it.
+	verify *verifyStats
+	// keys sketches the keyspace keys used; nil without a keyspace.
+	keys *keySketch
+	// reuse is the re-reference distance of the keys picked under a
+	// locality -key-dist, whose first picks firstRefs counts.
+	reuse     *stats.Histogram
+	firstRefs int64
+	// appends are the statistics of the append workload; nil without it.
+	appends *appendStats
+	// large counts the GETs of -large-values; nil without it.
+	large *largeStats
+	// sizes buckets latency by payload size; nil unless -size-buckets
+	// applies.
+	sizes *sizeStats
+	// slowest is the successful operation with the longest service time.
+	slowest slowOp
+	// outliers are the operations slower than -capture-outliers; nil
+	// unless there was one.
+	outliers *outlierSet
+	// hot and cold split latency by whether the key came from the
+	// -hot-keys pool; both are nil without -hot-keys.
+	hot  *stats.Histogram
+	cold *stats.Histogram
+
+	// expirySamples are the keys tracked by -verify-expiry.
+	expirySamples []expirySample
+	// counters tallies INCRs per counter, warmup included; nil when the
+	// workload issues no INCR.
+	counters *counterTally
+	// writes records the keyspace changes, warmup included; nil without
+	// -verify-final.
+	writes *writeLog
+	// locks describes the SETNX acquisitions; nil without any.
+	locks *lockStats
+	// lockConflicts counts acquisitions that read back another holder's
+	// value, warmup included; lockConflictLog keeps the first few.
+	lockConflicts   int64
+	lockConflictLog []lockConflict
+
+	// endToEnd is the time from a producer sending a message to a consumer
+	// receiving it; nil unless messages were consumed.
+	endToEnd *stats.Histogram
+	// queue tallies the messages moved, warmup included; nil outside the
+	// queue workload.
+	queue *queueTally
+
+	// batch is the round-trip latency of whole pipelines; nil unless
+	// -pipeline is set.
+	batch *stats.Histogram
+	// queueDelay is the time arrivals of -loop open waited for a client;
+	// nil for a closed loop.
+	queueDelay *stats.Histogram
+	// inBursts and betweenBursts split latency by whether the operation
+	// was sent within a burst of -pattern burst; nil otherwise.
+	inBursts      *stats.Histogram
+	betweenBursts *stats.Histogram
+	// queueWait is the time operations waited from enqueue to send under
+	// -max-inflight, and shed counts those -queue-timeout dropped.
+	queueWait *stats.Histogram
+	shed      int64
+	// replayGaps are the gaps between the commands a replay sent, beside
+	// replayRecorded, those of the trace, and replayLag, their lateness on
+	// the recorded offsets; nil outside a replay.
+	replayGaps     *stats.Histogram
+	replayRecorded *stats.Histogram
+	replayLag      *stats.Histogram
+}
+
+func newWorkerResult(l stats.Layout) *workerResult {
+	return &workerResult{layout: l, latency: l.New()}
+}
+
+// merge folds o into r.
+func (r *workerResult) merge(o *workerResult) {
+	r.latency.Merge(o.latency)
+	if o.response != nil {
+		if r.response == nil {
+			r.response = o.response.Empty()
+		}
+		r.response.Merge(o.response)
+	}
+	r.bytesWritten += o.bytesWritten
+	r.bytesRead += o.bytesRead
+	r.warmupOps += o.warmupOps
+	r.hashSizeMismatches += o.hashSizeMismatches
+	r.zsetChecked += o.zsetChecked
+	r.zsetDisorders += o.zsetDisorders
+	r.zsetShort += o.zsetShort
+	r.setWrong += o.setWrong
+	r.setShort += o.setShort
+	r.mgetWrongLength += o.mgetWrongLength
+	if o.scan != nil {
+		r.scanStats().merge(o.scan)
+	}
+	r.fgScanning = mergeHistogram(r.fgScanning, o.fgScanning)
+	r.fgIdle = mergeHistogram(r.fgIdle, o.fgIdle)
+	r.txnAborts += o.txnAborts
+	r.txnGaveUp += o.txnGaveUp
+	r.mergeTxnRetries(o.txnRetries)
+	r.scriptFallbacks += o.scriptFallbacks
+	if o.pub != nil {
+		if r.pub == nil {
+			r.pub = &pubTally{}
+		}
+		r.pub.merge(o.pub)
+	}
+	if o.verify != nil {
+		r.verifyStats().merge(o.verify)
+	}
+	if o.evict != nil {
+		r.evictStats().merge(o.evict)
+	}
+	if o.retry != nil {
+		r.retryStats().merge(o.retry)
+	}
+	if o.resilience != nil {
+		r.resilienceStats().merge(o.resilience)
+	}
+	if o.tracking != nil {
+		r.trackingStats().merge(o.tracking)
+	}
+	if o.churn != nil {
+		r.churnStats().merge(o.churn)
+	}
+	if o.keys != nil {
+		r.keySketch().merge(o.keys)
+	}
+	if o.appends != nil {
+		if r.appends == nil {
+			r.appends = &appendStats{byLength: make([]*stats.Histogram, len(o.appends.byLength))}
+		}
+		r.appends.merge(o.appends)
+	}
+	if o.large != nil {
+		r.largeStats().merge(o.large)
+	}
+	if o.sizes != nil {
+		r.sizeStats(o.sizes.bounds).merge(o.sizes)
+	}
+	if o.slowest.latency > r.slowest.latency {
+		r.slowest = o.slowest
+	}
+	if o.outliers != nil {
+		if r.outliers == nil {
+			r.outliers = newOutlierSet(o.outliers.limit)
+		}
+		r.outliers.merge(o.outliers)
+	}
+	r.hot = mergeHistogram(r.hot, o.hot)
+	r.cold = mergeHistogram(r.cold, o.cold)
+	r.expirySamples = append(r.expirySamples, o.expirySamples...)
+	if o.counters != nil {
+		if r.counters == nil {
+			r.counters = newCounterTally(len(o.counters.ok))
+		}
+		r.counters.merge(o.counters)
+	}
+	if o.writes != nil {
+		if r.writes == nil {
+			r.writes = newWriteLog()
+		}
+		r.writes.merge(o.writes)
+	}
+	if o.locks != nil {
+		r.lockStats().merge(o.locks)
+	}
+	r.endToEnd = mergeHistogram(r.endToEnd, o.endToEnd)
+	if o.queue != nil {
+		if r.queue == nil {
+			r.queue = &queueTally{}
+		}
+		r.queue.merge(o.queue)
+	}
+	r.lockConflicts += o.lockConflicts
+	for _, c := range o.lockConflictLog {
+		if len(r.lockConflictLog) < maxLockConflicts {
+			r.lockConflictLog = append(r.lockConflictLog, c)
+		}
+	}
+	if o.batch != nil {
+		if r.batch == nil {
+			r.batch = o.batch.Empty()
+		}
+		r.batch.Merge(o.batch)
+	}
+	r.queueDelay = mergeHistogram(r.queueDelay, o.queueDelay)
+	r.inBursts = mergeHistogram(r.inBursts, o.inBursts)
+	r.betweenBursts = mergeHistogram(r.betweenBursts, o.betweenBursts)
+	r.queueWait = mergeHistogram(r.queueWait, o.queueWait)
+	r.reuse = mergeHistogram(r.reuse, o.reuse)
+	r.firstRefs += o.firstRefs
+	r.shed += o.shed
+	r.replayGaps = mergeHistogram(r.replayGaps, o.replayGaps)
+	r.replayRecorded = mergeHistogram(r.replayRecorded, o.replayRecorded)
+	r.replayLag = mergeHistogram(r.replayLag, o.replayLag)
+	for i := range r.errClasses {
+		r.errClasses[i] += o.errClasses[i]
+	}
+	for i := range r.ops {
+		r.ops[i].merge(&o.ops[i])
+	}
+}
+
+// recordSuccess records a completed operation. intended is the slot assigned
+// by the pacer and is only used when the run is paced; start and end bracket
+// the command itself.
+func (r *workerResult) recordSuccess(cfg *config, s *opStats, intended, start, end time.Time) {
+	service := end.Sub(start)
+	r.latency.Record(service)
+	s.record(r.layout, service)
+	if cfg.rate > 0 {
+		if r.response == nil {
+			r.response = r.layout.New()
+		}
+		r.response.Record(end.Sub(intended))
+	}
+}
+
+// recordBatch records the round trip of one pipeline.
+func (r *workerResult) recordBatch(d time.Duration) {
+	if r.batch == nil {
+		r.batch = r.layout.New()
+	}
+	r.batch.Record(d)
+}
+
+// attempts returns the number of operations issued, failed or not.
+func (r *workerResult) attempts() int64 {
+	return r.latency.Count() + r.errors()
+}
+
+// errors returns the number of failed operations across all commands.
+func (r *workerResult) errors() int64 {
+	var n int64
+	for i := range r.ops {
+		n += r.ops[i].errors
+	}
+	return n
+}
+
+// commandMix is a weighted set of commands an operation is drawn from.
+type commandMix struct {
+	ops        []opType
+	cumulative []float64
+}
+
+// parseRatio parses a mix such as "get=0.9,set=0.1". Weights that do not sum
+// to one are normalized, so "get=9,set=1" is equivalent to the example.
+func parseRatio(s string) (*commandMix, error) {
+	weights := make(map[opType]float64)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid ratio component %q: want command=weight", part)
+		}
+		op, ok := parseOpType(strings.TrimSpace(name))
+		if !ok {
+			return nil, fmt.Errorf("invalid ratio component %q: unknown command %q", part, name)
+		}
+		w, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid ratio component %q: weight must be a non-negative number", part)
+		}
+		if _, dup := weights[op]; dup {
+			return nil, fmt.Errorf("invalid ratio: %s listed twice", op)
+		}
+		weights[op] = w
+	}
+
+	var sum float64
+	for _, w := range weights {
+		sum += w
+	}
+	if sum == 0 {
+		return nil, errors.New("invalid ratio: weights must sum to a positive number")
+	}
+
+	m := &commandMix{}
+	for op := range weights {
+		m.ops = append(m.ops, op)
+	}
+	sort.Slice(m.ops, func(i, j int) bool { return m.ops[i] < m.ops[j] })
+	var acc float64
+	for _, op := range m.ops {
+		acc += weights[op] / sum
+		m.cumulative = append(m.cumulative, acc)
+	}
+	m.cumulative[len(m.cumulative)-1] = 1
+	return m, nil
+}
+
+// singleOpMix returns a mix that always picks op.
+func singleOpMix(op opType) *commandMix {
+	return &commandMix{ops: []opType{op}, cumulative: []float64{1}}
+}
+
+// pick draws a command according to the mix weights.
+func (m *commandMix) pick(rng *rand.Rand) opType {
+	if len(m.ops) == 1 {
+		return m.ops[0]
+	}
+	x := rng.Float64()
+	for i, c := range m.cumulative {
+		if x < c {
+			return m.ops[i]
+		}
+	}
+	return m.ops[len(m.ops)-1]
+}
+
+// share returns the normalized weight of op in the mix.
+func (m *commandMix) share(op opType) float64 {
+	prev := 0.0
+	for i, o := range m.ops {
+		if o == op {
+			return m.cumulative[i] - prev
+		}
+		prev = m.cumulative[i]
+	}
+	return 0
+}
+
+func (m *commandMix) String() string {
+	parts := make([]string, len(m.ops))
+	for i, op := range m.ops {
+		parts[i] = fmt.Sprintf("%s=%.3g", strings.ToLower(op.String()), m.share(op))
+	}
+	return strings.Join(parts, ",")
+}
+
+// recordHotCold records d into the hot- or cold-key latency histogram.
+func (r *workerResult) recordHotCold(hot bool, d time.Duration) {
+	h := &r.cold
+	if hot {
+		h = &r.hot
+	}
+	if *h == nil {
+		*h = r.layout.New()
+	}
+	(*h).Record(d)
+}
+
+// mergeHistogram merges o into h, allocating h in the layout of o when
+// needed, and returns h.
+func mergeHistogram(h, o *stats.Histogram) *stats.Histogram {
+	if o == nil {
+		return h
+	}
+	if h == nil {
+		h = o.Empty()
+	}
+	h.Merge(o)
+	return h
+}
+
+// slowOp identifies a single operation for correlation with server logs.
+type slowOp struct {
+	latency time.Duration
+	at      time.Time
+	op      opType
+	key     string
+}
+
+// timeouts returns the number of operations that hit -op-timeout.
+func (r *workerResult) timeouts() int64 {
+	var n int64
+	for i := range r.ops {
+		n += r.ops[i].timeouts
+	}
+	return n
+}
+
+// workloadOps are the commands that only run in the workload they belong
+// to and cannot be part of a -ratio mix.
+var workloadOps = map[opType]string{
+	opLPush:    workloadQueue,
+	opRPop:     workloadQueue,
+	opBRPop:    workloadQueue,
+	opScan:     workloadScan,
+	opPublish:  workloadPubSub,
+	opAppend:   workloadAppend,
+	opGetRange: workloadAppend,
+}
+
+// ownPoolOps are the commands that work on a key pool of their own instead
+// of the shared keyspace.
+var ownPoolOps = map[opType]bool{
+	opIncr:  true,
+	opSetNX: true,
+	opTxn:   true,
+}
+
+// mixedCommands reports whether the workload draws from a mix of commands
+// rather than issuing a single one.
+func (c *config) mixedCommands() bool {
+	switch c.workload {
+	case workloadMixed, workloadHash, workloadZSet, workloadSets, workloadScan, workloadTracking, workloadAppend:
+		return true
+	}
+	return false
+}
+
+// usesKeyspace reports whether the workload reads or writes the shared
+// keyspace, which then needs -keyspace and is filled by -preload. Workloads
+// of SETs of fresh keys, without -keyspace, or of commands with their own
+// pool do not.
+func (c *config) usesKeyspace() bool {
+	switch c.workload {
+	case workloadSet:
+		// Unless -keyspace bounds the keys it writes.
+		return c.boundedSets
+	case workloadQueue, workloadPubSub:
+		return false
+	}
+	if c.mix == nil {
+		return true
+	}
+	for _, op := range c.mix.ops {
+		if !ownPoolOps[op] {
+			return true
+		}
+	}
+	return false
+}
+
+// keyspaceComplete reports whether every key of the keyspace exists
+// throughout the run: the preload filled the whole keyspace and no command
+// removes keys.
+func (c *config) keyspaceComplete() bool {
+	return c.preload >= c.keyspace && c.mix.share(opDel) == 0 && c.mix.share(opExpire) == 0
+}
+
+// keyKinds names the data type each command expects the keys of the
+// keyspace to hold. DEL and EXPIRE work on any type and commands with a
+// pool of their own are not listed.
+var keyKinds = map[opType]string{
+	opGet:       "string",
+	opSet:       "string",
+	opHSet:      "hash",
+	opHGet:      "hash",
+	opHGetAll:   "hash",
+	opZAdd:      "sorted set",
+	opZRange:    "sorted set",
+	opZRevRange: "sorted set",
+	opSAdd:      "set",
+	opSIsMember: "set",
+	opSMembers:  "set",
+	opMGet:      "string",
+	opMSet:      "string",
+	opAppend:    "string",
+	opGetRange:  "string",
+}
+
+// validateKeyKinds rejects a mix whose commands need different data types
+// in one keyspace, which would only measure WRONGTYPE errors.
+func (c *config) validateKeyKinds() error {
+	var first opType
+	kind := ""
+	for _, op := range c.mix.ops {
+		k, ok := keyKinds[op]
+		switch {
+		case !ok:
+		case kind == "":
+			first, kind = op, k
+		case k != kind:
+			return fmt.Errorf("%s (%s keys) and %s (%s keys) cannot share one keyspace", first, kind, op, k)
+		}
+	}
+	return nil
+}
+
+// keyName returns the key for logical index i of the shared keyspace. Preload
+// and the read workloads both go through it so reads land on preloaded data.
+func (c *config) keyName(i int) string {
+	if c.keyTemplate != nil {
+		return c.templateKey(i+c.keyOffset, 0)
+	}
+	return c.padKey(c.keyPrefix + "key" + strconv.Itoa(i+c.keyOffset))
+}
+
+// uniqueKey returns a fresh key for the set workload, which writes each key
+// once.
+func (c *config) uniqueKey(client, n int) string {
+	if c.keyTemplate != nil {
+		return c.templateKey(n, client+c.clientOffset)
+	}
+	return c.padKey(fmt.Sprintf("%sclient%d-key%d", c.keyPrefix, client+c.clientOffset, n))
+}
+
+// expiredKey returns the n-th key client writes for -verify-expired.
+func (c *config) expiredKey(client, n int) string {
+	return c.padKey(fmt.Sprintf("%sexpired:client%d-key%d", c.keyPrefix, client+c.clientOffset, n))
+}
+
+// keyPattern returns a SCAN pattern matching every key the run writes.
+func (c *config) keyPattern() string {
+	return globEscape(c.keyPrefix) + "*"
+}
+
+// globEscape quotes the characters SCAN MATCH treats as wildcards.
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// defaultKeyPrefix returns a namespace unique to this run so concurrent
+// benchmarks against one server do not collide.
+func defaultKeyPrefix() string {
+	return fmt.Sprintf("bench-%s-%06x:", time.Now().UTC().Format("20060102T150405"), rand.Intn(1<<24))
+}
+
+// isMiss reports whether err only signals that the key does not exist.
+func isMiss(err error) bool {
+	return errors.Is(err, redis.Nil)
+}
@@ -0,0 +1,44 @@
+package loadgen
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestParseRatio(t *testing.T) {
+	m, err := parseRatio("get=9, set=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.share(opGet); math.Abs(got-0.9) > 1e-9 {
+		t.Errorf("get share = %v, want 0.9", got)
+	}
+	if got := m.share(opSet); math.Abs(got-0.1) > 1e-9 {
+		t.Errorf("set share = %v, want 0.1", got)
+	}
+
+	for _, bad := range []string{"", "get", "get=x", "get=-1", "foo=1", "get=0", "get=1,get=2", "del=1,del=1"} {
+		if _, err := parseRatio(bad); err == nil {
+			t.Errorf("parseRatio(%q) succeeded, want error", bad)
+		}
+	}
+}
+
+func TestCommandMixPick(t *testing.T) {
+	m, err := parseRatio("get=0.9,set=0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	var gets int
+	const n = 100000
+	for i := 0; i < n; i++ {
+		if m.pick(rng) == opGet {
+			gets++
+		}
+	}
+	if frac := float64(gets) / n; math.Abs(frac-0.9) > 0.01 {
+		t.Errorf("get fraction = %v, want ~0.9", frac)
+	}
+}
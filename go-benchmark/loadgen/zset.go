@@ -0,0 +1,108 @@
+package loadgen
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// workloadZSet runs -ratio, or defaultZSetRatio, over a keyspace of sorted
+// sets used as leaderboards.
+const workloadZSet = "zset"
+
+// defaultZSetRatio is the command mix of -workload zset without -ratio.
+const defaultZSetRatio = "zadd=0.2,zrange=0.4,zrevrange=0.4"
+
+// zsetOps are the commands that treat keys of the keyspace as sorted sets of
+// -zset-members members.
+var zsetOps = map[opType]bool{
+	opZAdd:      true,
+	opZRange:    true,
+	opZRevRange: true,
+}
+
+// zsetMember returns the name of member i of every sorted set.
+func zsetMember(i int) string {
+	return "m" + strconv.Itoa(i)
+}
+
+// usesZSets reports whether the keyspace holds sorted sets.
+func (c *config) usesZSets() bool {
+	if c.mix == nil {
+		return false
+	}
+	for _, op := range c.mix.ops {
+		if zsetOps[op] {
+			return true
+		}
+	}
+	return false
+}
+
+// zsetScore draws the score of a ZADD.
+func zsetScore(rng *rand.Rand) float64 {
+	return float64(rng.Intn(1_000_000))
+}
+
+// zsetMembers returns every member of a sorted set with a random score, to
+// seed it to its full cardinality.
+func (c *config) zsetMembers(rng *rand.Rand) []*redis.Z {
+	members := make([]*redis.Z, c.zsetSize)
+	for i := range members {
+		members[i] = &redis.Z{Score: zsetScore(rng), Member: zsetMember(i)}
+	}
+	return members
+}
+
+// issueZSet sends one ZADD of an existing member with a new score, or reads
+// the top -zset-range entries of key.
+func (w *worker) issueZSet(ctx context.Context, c redis.Cmdable, op opType, key string, hot bool) pendingOp {
+	cfg := w.run.cfg
+	stop := int64(cfg.zsetRange - 1)
+	switch op {
+	case opZAdd:
+		z := &redis.Z{Score: zsetScore(w.rng), Member: zsetMember(w.rng.Intn(cfg.zsetSize))}
+		return pendingOp{op: op, cmd: c.ZAdd(ctx, key, z), key: key, hot: hot}
+	case opZRange:
+		return pendingOp{op: op, cmd: c.ZRangeWithScores(ctx, key, 0, stop), key: key, hot: hot, check: w.sampleCheck()}
+	default:
+		return pendingOp{op: op, cmd: c.ZRevRangeWithScores(ctx, key, 0, stop), key: key, hot: hot, check: w.sampleCheck()}
+	}
+}
+
+// sampleCheck decides whether the reply of the next range read is verified.
+func (w *worker) sampleCheck() bool {
+	return w.rng.Float64() < w.run.cfg.zsetVerify
+}
+
+// checkZRange verifies a sampled range reply: entries ordered by score, ties
+// by member, ascending for ZRANGE and descending for ZREVRANGE, and as many
+// of them as the sorted set must hold.
+func (w *worker) checkZRange(op opType, entries []redis.Z) {
+	cfg := w.run.cfg
+	r := w.result
+	r.zsetChecked++
+	if !zsetOrdered(entries, op == opZRevRange) {
+		r.zsetDisorders++
+	}
+	if cfg.checkFullKeys && len(entries) != min(cfg.zsetRange, cfg.zsetSize) {
+		r.zsetShort++
+	}
+}
+
+// zsetOrdered reports whether entries are sorted the way ZRANGE, or
+// ZREVRANGE when reverse is set, returns them.
+func zsetOrdered(entries []redis.Z, reverse bool) bool {
+	for i := 1; i < len(entries); i++ {
+		a, b := entries[i-1], entries[i]
+		if reverse {
+			a, b = b, a
+		}
+		if a.Score > b.Score || a.Score == b.Score && a.Member.(string) > b.Member.(string) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package loadgen
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestZSetWorkload(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cfg := testConfig(t, "-addr", mr.Addr(), "-workload", "zset", "-preload", "10", "-zset-members", "20",
+		"-zset-range", "5", "-zset-verify", "1", "-clients", "4", "-ops", "100", "-key-prefix", "z:")
+
+	res, err := runTarget(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if members, _ := mr.ZMembers("z:key9"); len(members) != 20 {
+		t.Errorf("preloaded sorted set has %d members, want 20", len(members))
+	}
+	for _, op := range []opType{opZAdd, opZRange, opZRevRange} {
+		if s := &res.total.ops[op]; s.latency == nil || s.errors > 0 {
+			t.Errorf("%s: %+v, want successful operations", op, s)
+		}
+	}
+	total := res.total
+	if reads := total.ops[opZRange].hits + total.ops[opZRevRange].hits; total.zsetChecked != reads {
+		t.Errorf("checked %d of %d range replies, want all", total.zsetChecked, reads)
+	}
+	if total.zsetDisorders != 0 || total.zsetShort != 0 {
+		t.Errorf("%d out of order, %d short replies from a correct server", total.zsetDisorders, total.zsetShort)
+	}
+}
+
+func TestZSetOrdered(t *testing.T) {
+	asc := []redis.Z{{Score: 1, Member: "b"}, {Score: 2, Member: "a"}, {Score: 2, Member: "c"}}
+	desc := []redis.Z{{Score: 2, Member: "c"}, {Score: 2, Member: "a"}, {Score: 1, Member: "b"}}
+	if !zsetOrdered(asc, false) || !zsetOrdered(desc, true) {
+		t.Error("correctly ordered ranges rejected")
+	}
+	if zsetOrdered(desc, false) || zsetOrdered(asc, true) {
+		t.Error("reversed ranges accepted")
+	}
+	// Ties are ordered by member.
+	if zsetOrdered([]redis.Z{{Score: 2, Member: "c"}, {Score: 2, Member: "a"}}, false) {
+		t.Error("tied scores out of member order accepted")
+	}
+}
+
+func TestZSetRejectsOtherKeyTypes(t *testing.T) {
+	if _, err := parseFlags([]string{"-ratio", "zadd=1,hget=1"}); err == nil {
+		t.Error("mixing sorted set and hash commands over one keyspace was accepted")
+	}
+}
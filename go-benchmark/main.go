@@ -1,73 +1,49 @@
+// Command go-benchmark is the command line front end of package loadgen:
+// it load tests a Redis-compatible server and reports its throughput and
+// latency.
 package main
 
 import (
 	"context"
 	"fmt"
-	"math/rand"
-	"sync"
-	"time"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/go-redis/redis/v8"
+	"go-benchmark/loadgen"
 )
 
-var ctx = context.Background()
-
-// Define a variable for the cumulative time taken for SET operations
-var cumulativeTime time.Duration
-var mutex sync.Mutex
+// subcommands are the commands that work on stored results instead of
+// running a benchmark.
+var subcommands = map[string]func(args []string, stdout, stderr io.Writer) int{
+	"history": loadgen.History,
+	"diff":    loadgen.Diff,
+}
 
 func main() {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     "localhost:6379",
-		Password: "",
-		DB:       0,
-	})
-
-	clientsCount := 1000
-	opsPerClient := 10000
-
-	startTime := time.Now()
-
-	var wg sync.WaitGroup
-	wg.Add(clientsCount)
-
-	for i := 0; i < clientsCount; i++ {
-		go func(clientID int) {
-			defer wg.Done()
-			performLoadTest(rdb, opsPerClient, clientID)
-		}(i)
+	args := os.Args[1:]
+	if len(args) > 0 {
+		if run, ok := subcommands[args[0]]; ok {
+			os.Exit(run(args[1:], os.Stdout, os.Stderr))
+		}
 	}
-
-	wg.Wait()
-
-	endTime := time.Now()
-	totalTime := endTime.Sub(startTime)
-
-	fmt.Println("Load test completed")
-	fmt.Printf("Total time for operations: %v\n", totalTime)
-	fmt.Printf("Cumulative time for SET operations: %v\n", cumulativeTime)
+	os.Exit(loadgen.Execute(interruptible(), args, os.Stdout, os.Stderr))
 }
 
-func performLoadTest(rdb *redis.Client, numOperations int, clientID int) {
-	rand.Seed(time.Now().UnixNano())
-	for i := 0; i < numOperations; i++ {
-		start := time.Now()
-
-		key := fmt.Sprintf("client%d-key%d", clientID, rand.Int())
-		value := fmt.Sprintf("value%d", i)
-
-		err := rdb.Set(ctx, key, value, 0).Err()
-		if err != nil {
-			//fmt.Printf("Client %d encountered an error: %v\n", clientID, err)
-			continue
-		}
-
-		timeTaken := time.Since(start)
-
-		mutex.Lock()
-		cumulativeTime += timeTaken
-		mutex.Unlock()
-
-		//fmt.Printf("Client %d: Time taken for SET operation: %v\n", clientID, timeTaken)
-	}
+// interruptible returns a context canceled on the first SIGINT/SIGTERM, so
+// workers can finish their in-flight command and the summary can still be
+// printed. A second signal exits immediately.
+func interruptible() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+		<-sigs
+		fmt.Fprintln(os.Stderr, "interrupted again: exiting without a report")
+		os.Exit(loadgen.ExitInterrupted)
+	}()
+	return ctx
 }
@@ -0,0 +1,66 @@
+package stats
+
+import (
+	"fmt"
+	"time"
+)
+
+// Encoded is a histogram on the wire: its layout and its non-zero counts by
+// index.
+type Encoded struct {
+	Counts   [][2]int64 `json:"counts"`
+	Overflow int64      `json:"overflow,omitempty"`
+	Sum      int64      `json:"sum"`
+	Min      int64      `json:"min"`
+	Max      int64      `json:"max"`
+	// Highest and SubBuckets, the sub-bucket magnitude, are the layout;
+	// zero for the layout of DefaultLayout.
+	Highest    int64 `json:"highest,omitempty"`
+	SubBuckets uint  `json:"sub_buckets,omitempty"`
+}
+
+// defaultSubBuckets is the sub-bucket magnitude of DefaultLayout.
+const defaultSubBuckets = 7
+
+// Encode returns h on the wire, nil for a nil histogram.
+func Encode(h *Histogram) *Encoded {
+	if h == nil {
+		return nil
+	}
+	e := &Encoded{Overflow: h.overflow, Sum: h.sum, Min: h.min, Max: h.max}
+	if h.highest != int64(DefaultLayout.Highest) || h.subBucketHalfCountMagnitude != defaultSubBuckets {
+		e.Highest, e.SubBuckets = h.highest, h.subBucketHalfCountMagnitude
+	}
+	for i, c := range h.counts {
+		if c != 0 {
+			e.Counts = append(e.Counts, [2]int64{int64(i), c})
+		}
+	}
+	return e
+}
+
+// Decode rebuilds the histogram of e in its layout, nil for a nil e.
+func Decode(e *Encoded) (*Histogram, error) {
+	if e == nil {
+		return nil, nil
+	}
+	highest, sub := int64(DefaultLayout.Highest), uint(defaultSubBuckets)
+	if e.Highest != 0 {
+		highest, sub = e.Highest, e.SubBuckets
+	}
+	if highest < int64(MinHighest) || sub < 1 || sub > 16 {
+		return nil, fmt.Errorf("invalid histogram layout: highest %v, sub-bucket magnitude %d", time.Duration(highest), sub)
+	}
+	h := newHistogram(histogramLowest, highest, sub)
+	for _, c := range e.Counts {
+		if c[0] < 0 || c[0] >= int64(len(h.counts)) {
+			return nil, fmt.Errorf("histogram index %d out of range", c[0])
+		}
+		h.counts[c[0]] += c[1]
+		h.total += c[1]
+	}
+	h.overflow = e.Overflow
+	h.total += e.Overflow
+	h.sum, h.min, h.max = e.Sum, e.Min, e.Max
+	return h, nil
+}
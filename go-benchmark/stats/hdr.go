@@ -0,0 +1,92 @@
+package stats
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"math"
+)
+
+// Cookies of the V2 encoding of HdrHistogram, whose counts are ZigZag
+// LEB128 varints with runs of zeros folded into one negative count.
+const (
+	hdrEncodingCookie   = 0x1c849313
+	hdrCompressedCookie = 0x1c849314
+	hdrHeaderSize       = 40
+)
+
+// hdrSigFigs returns the fewest significant digits whose HdrHistogram
+// layout has at least subBucketHalfCountMagnitude, capped at 5, and the
+// magnitude of that layout.
+func hdrSigFigs(subBucketHalfCountMagnitude uint) (int, uint) {
+	for s := 1; ; s++ {
+		mag := uint(math.Ceil(math.Log2(2*math.Pow10(s)))) - 1
+		if mag >= subBucketHalfCountMagnitude || s == 5 {
+			return s, mag
+		}
+	}
+}
+
+// EncodeHdr returns h in the compressed V2 encoding of HdrHistogram, the
+// histogram of a line of an HdrHistogram log once in base64.
+//
+// Readers rebuild the layout from a number of significant digits, so a
+// histogram whose layout has none is re-recorded into the next finer one
+// that does. HdrHistogram has no overflow: the export tracks up to the
+// maximum, where the overflow of h is counted.
+func EncodeHdr(h *Histogram) []byte {
+	sigFigs, mag := hdrSigFigs(h.subBucketHalfCountMagnitude)
+	if mag != h.subBucketHalfCountMagnitude || h.overflow > 0 {
+		e := newHistogram(h.lowest, max(h.highest, h.max), mag)
+		e.Merge(h)
+		h = e
+	}
+
+	var payload bytes.Buffer
+	var word [binary.MaxVarintLen64]byte
+	put := func(v int64) {
+		payload.Write(word[:binary.PutVarint(word[:], v)])
+	}
+	limit := 0
+	if h.total > 0 {
+		limit = h.countsIndex(h.max) + 1
+	}
+	for i := 0; i < limit; {
+		c := h.counts[i]
+		i++
+		if c != 0 {
+			put(c)
+			continue
+		}
+		zeros := int64(1)
+		for i < limit && h.counts[i] == 0 {
+			zeros++
+			i++
+		}
+		if zeros > 1 {
+			put(-zeros)
+		} else {
+			put(0)
+		}
+	}
+
+	raw := make([]byte, hdrHeaderSize, hdrHeaderSize+payload.Len())
+	binary.BigEndian.PutUint32(raw[0:], hdrEncodingCookie)
+	binary.BigEndian.PutUint32(raw[4:], uint32(payload.Len()))
+	// raw[8:12] is the normalizing index offset, 0.
+	binary.BigEndian.PutUint32(raw[12:], uint32(sigFigs))
+	binary.BigEndian.PutUint64(raw[16:], uint64(h.lowest))
+	binary.BigEndian.PutUint64(raw[24:], uint64(h.highest))
+	binary.BigEndian.PutUint64(raw[32:], math.Float64bits(1))
+	raw = append(raw, payload.Bytes()...)
+
+	var out bytes.Buffer
+	out.Write(make([]byte, 8))
+	zw := zlib.NewWriter(&out)
+	zw.Write(raw)
+	zw.Close()
+	b := out.Bytes()
+	binary.BigEndian.PutUint32(b[0:], hdrCompressedCookie)
+	binary.BigEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b
+}
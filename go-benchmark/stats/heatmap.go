@@ -0,0 +1,96 @@
+package stats
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// The latency buckets of a Heatmap are log-spaced: HeatmapPerOctave buckets
+// to every doubling from HeatmapLowest, over HeatmapOctaves doublings. The
+// first bucket also holds everything faster than HeatmapLowest and the last
+// everything slower than the highest bound.
+const (
+	HeatmapLowest    = time.Microsecond
+	HeatmapPerOctave = 4
+	HeatmapOctaves   = 26
+	HeatmapBuckets   = HeatmapPerOctave*HeatmapOctaves + 1
+)
+
+// HeatmapRow counts the latencies of one time bucket by latency bucket.
+type HeatmapRow [HeatmapBuckets]int64
+
+// HeatmapBucket returns the latency bucket of d.
+func HeatmapBucket(d time.Duration) int {
+	if d < HeatmapLowest {
+		return 0
+	}
+	i := int(math.Log2(float64(d)/float64(HeatmapLowest)) * HeatmapPerOctave)
+	return min(i, HeatmapBuckets-1)
+}
+
+// HeatmapBound returns the lower bound of latency bucket i, zero for the
+// first.
+func HeatmapBound(i int) time.Duration {
+	if i == 0 {
+		return 0
+	}
+	return time.Duration(float64(HeatmapLowest) * math.Exp2(float64(i)/HeatmapPerOctave))
+}
+
+// Record counts d in its latency bucket.
+func (r *HeatmapRow) Record(d time.Duration) {
+	r[HeatmapBucket(d)]++
+}
+
+// Add adds the counts of o to r.
+func (r *HeatmapRow) Add(o *HeatmapRow) {
+	for i, c := range o {
+		r[i] += c
+	}
+}
+
+// Heatmap is the grid of a run's latencies, a row of latency buckets per
+// Interval. Its memory grows by one fixed-size row per interval, so a grid
+// of a run of duration d holds d/Interval rows.
+type Heatmap struct {
+	Interval time.Duration
+	// Rows are the time buckets in order, the first starting with the
+	// measured window; the last ends with Length, the length of the
+	// window, and may be shorter than Interval.
+	Rows   []HeatmapRow
+	Length time.Duration
+}
+
+// End returns the end of time bucket n, from the start of the window.
+func (h *Heatmap) End(n int) time.Duration {
+	return min(time.Duration(n+1)*h.Interval, max(h.Length, time.Duration(n)*h.Interval))
+}
+
+// WriteCSV writes h as CSV: a header of the lower bounds of the latency
+// buckets in nanoseconds, then per row the end of its time bucket in
+// seconds and its counts.
+func (h *Heatmap) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	record := make([]string, HeatmapBuckets+1)
+	record[0] = "t"
+	for i := 0; i < HeatmapBuckets; i++ {
+		record[i+1] = strconv.FormatInt(int64(HeatmapBound(i)), 10)
+	}
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+	for n, row := range h.Rows {
+		record[0] = strconv.FormatFloat(h.End(n).Seconds(), 'f', 3, 64)
+		for i, c := range row {
+			record[i+1] = strconv.FormatInt(c, 10)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,66 @@
+package stats
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+)
+
+func TestHeatmapBuckets(t *testing.T) {
+	for _, c := range []struct {
+		d    time.Duration
+		want int
+	}{
+		{0, 0},
+		{500 * time.Nanosecond, 0},
+		{time.Microsecond, 0},
+		{2 * time.Microsecond, HeatmapPerOctave},
+		{3 * time.Microsecond, 6},
+		{time.Hour, HeatmapBuckets - 1},
+	} {
+		if got := HeatmapBucket(c.d); got != c.want {
+			t.Errorf("bucket of %v = %d, want %d", c.d, got, c.want)
+		}
+	}
+	// Every bucket holds the latencies from its bound up to the next.
+	for i := 1; i < HeatmapBuckets; i++ {
+		lo := HeatmapBound(i)
+		if lo <= HeatmapBound(i-1) {
+			t.Fatalf("bound %d (%v) not above bound %d", i, lo, i-1)
+		}
+		if got := HeatmapBucket(lo + 1); got != i {
+			t.Errorf("%v just above bound %d lands in bucket %d", lo+1, i, got)
+		}
+	}
+}
+
+func TestHeatmapCSV(t *testing.T) {
+	h := &Heatmap{Interval: time.Second, Length: 2500 * time.Millisecond, Rows: make([]HeatmapRow, 3)}
+	h.Rows[0].Record(time.Millisecond)
+	var o HeatmapRow
+	o.Record(time.Millisecond)
+	o.Record(5 * time.Microsecond)
+	h.Rows[2].Add(&o)
+	h.Rows[2].Add(&o)
+
+	var buf bytes.Buffer
+	if err := h.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 || len(records[0]) != HeatmapBuckets+1 || records[0][0] != "t" || records[0][1] != "0" || records[0][2] != "1189" {
+		t.Fatalf("%d records, header %v", len(records), records[0][:3])
+	}
+	ms := HeatmapBucket(time.Millisecond) + 1
+	for i, want := range []struct {
+		t, ms string
+	}{{"1.000", "1"}, {"2.000", "0"}, {"2.500", "2"}} {
+		if r := records[i+1]; r[0] != want.t || r[ms] != want.ms {
+			t.Errorf("row %d ends at %s with %s at 1ms, want %s and %s", i, r[0], r[ms], want.t, want.ms)
+		}
+	}
+}
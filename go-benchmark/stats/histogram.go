@@ -0,0 +1,323 @@
+// Package stats records latency distributions for the load generator.
+package stats
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Histogram is a fixed-memory latency histogram using the HdrHistogram bucket
+// layout: values are grouped into power-of-two buckets, each split into linear
+// sub-buckets, which keeps the relative error bounded by the configured number
+// of significant digits. Values are recorded in nanoseconds.
+//
+// Values above the highest trackable one are counted as overflow rather
+// than in a bucket: percentiles that fall among them report the exact
+// maximum.
+//
+// A histogram is not safe for concurrent use; each worker owns one and the
+// results are merged once the run is over.
+type Histogram struct {
+	lowest  int64
+	highest int64
+
+	unitMagnitude               uint
+	subBucketHalfCountMagnitude uint
+	subBucketCount              int64
+	subBucketHalfCount          int64
+	subBucketMask               int64
+	bucketCount                 int
+
+	counts   []int64
+	total    int64
+	overflow int64
+	sum      int64
+	min      int64
+	max      int64
+}
+
+const histogramLowest = int64(time.Nanosecond)
+
+// Layout is the range and resolution of a histogram.
+type Layout struct {
+	// Highest is the largest value tracked in a bucket.
+	Highest time.Duration
+	// Growth bounds the ratio between the upper and lower bound of a
+	// bucket, the relative error of a percentile.
+	Growth float64
+}
+
+// Bounds of a Layout: a finer growth multiplies the memory of every
+// histogram, a coarser one makes percentiles meaningless.
+const (
+	MinGrowth  = 1.001
+	MaxGrowth  = 1.5
+	MinHighest = time.Microsecond
+)
+
+// DefaultLayout tracks 1ns to one minute within 1%.
+var DefaultLayout = Layout{Highest: time.Minute, Growth: 1.01}
+
+// Validate reports whether l is within the bounds of a Layout.
+func (l Layout) Validate() error {
+	if l.Highest < MinHighest {
+		return fmt.Errorf("histogram max %v is below %v", l.Highest, MinHighest)
+	}
+	if l.Growth < MinGrowth || l.Growth > MaxGrowth {
+		return fmt.Errorf("histogram growth %v is outside [%v, %v]", l.Growth, MinGrowth, MaxGrowth)
+	}
+	return nil
+}
+
+// New returns a histogram tracking 1ns to the highest value of l, within
+// its growth; the zero Layout is DefaultLayout. Histograms of different
+// layouts still merge, at the cost of re-recording every bucket.
+func (l Layout) New() *Histogram {
+	if l == (Layout{}) {
+		l = DefaultLayout
+	}
+	// A sub-bucket spans 1/halfCount of its power of two at most.
+	half := uint(math.Ceil(math.Log2(1 / (l.Growth - 1))))
+	return newHistogram(histogramLowest, int64(l.Highest), half)
+}
+
+// NewHistogram returns a histogram of DefaultLayout.
+func NewHistogram() *Histogram {
+	return DefaultLayout.New()
+}
+
+// NewHistogramRange returns a histogram able to track values in
+// [lowest, highest] with the given number of significant digits.
+func NewHistogramRange(lowest, highest int64, sigFigs int) *Histogram {
+	largestSingleUnit := 2 * int64(math.Pow10(sigFigs))
+	subBucketCountMagnitude := uint(math.Ceil(math.Log2(float64(largestSingleUnit))))
+	return newHistogram(lowest, highest, subBucketCountMagnitude-1)
+}
+
+// newHistogram returns a histogram of values in [lowest, highest] whose
+// powers of two are split into 2^subBucketHalfCountMagnitude sub-buckets.
+func newHistogram(lowest, highest int64, subBucketHalfCountMagnitude uint) *Histogram {
+	if lowest < 1 {
+		lowest = 1
+	}
+	subBucketCountMagnitude := subBucketHalfCountMagnitude + 1
+	unitMagnitude := uint(math.Floor(math.Log2(float64(lowest))))
+
+	h := &Histogram{
+		lowest:                      lowest,
+		highest:                     highest,
+		unitMagnitude:               unitMagnitude,
+		subBucketHalfCountMagnitude: subBucketHalfCountMagnitude,
+		subBucketCount:              int64(1) << subBucketCountMagnitude,
+		subBucketHalfCount:          int64(1) << subBucketHalfCountMagnitude,
+		min:                         math.MaxInt64,
+	}
+	h.subBucketMask = (h.subBucketCount - 1) << unitMagnitude
+
+	smallestUntrackable := h.subBucketCount << unitMagnitude
+	buckets := 1
+	for smallestUntrackable <= highest {
+		if smallestUntrackable > math.MaxInt64/2 {
+			buckets++
+			break
+		}
+		smallestUntrackable <<= 1
+		buckets++
+	}
+	h.bucketCount = buckets
+	h.counts = make([]int64, int64(buckets+1)*h.subBucketHalfCount)
+	return h
+}
+
+// Record adds a single latency sample. Samples above the trackable range are
+// counted as overflow; the exact maximum is still kept.
+func (h *Histogram) Record(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	h.total++
+	h.sum += v
+	if v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+	if v > h.highest {
+		h.overflow++
+		return
+	}
+	h.counts[h.countsIndex(v)]++
+}
+
+// Merge adds all samples of o into h. The buckets of an o of another layout
+// are re-recorded at their highest equivalent value.
+func (h *Histogram) Merge(o *Histogram) {
+	if o.total == 0 {
+		return
+	}
+	if h.sameLayout(o) {
+		for i, c := range o.counts {
+			h.counts[i] += c
+		}
+		h.overflow += o.overflow
+	} else {
+		for i, c := range o.counts {
+			if c == 0 {
+				continue
+			}
+			v := o.highestEquivalentValue(o.valueFromIndex(i))
+			if v > h.highest {
+				h.overflow += c
+				continue
+			}
+			h.counts[h.countsIndex(v)] += c
+		}
+		// The overflow of o is above its highest value, which may be
+		// within the range of h: its count lands with the maximum.
+		if o.overflow > 0 && o.max <= h.highest {
+			h.counts[h.countsIndex(o.max)] += o.overflow
+		} else {
+			h.overflow += o.overflow
+		}
+	}
+	h.total += o.total
+	h.sum += o.sum
+	if o.min < h.min {
+		h.min = o.min
+	}
+	if o.max > h.max {
+		h.max = o.max
+	}
+}
+
+// Empty returns a histogram of the layout of h holding no value.
+func (h *Histogram) Empty() *Histogram {
+	return newHistogram(h.lowest, h.highest, h.subBucketHalfCountMagnitude)
+}
+
+func (h *Histogram) sameLayout(o *Histogram) bool {
+	return h.lowest == o.lowest && h.highest == o.highest && h.subBucketHalfCountMagnitude == o.subBucketHalfCountMagnitude
+}
+
+// Count returns the number of recorded samples.
+func (h *Histogram) Count() int64 {
+	return h.total
+}
+
+// Overflow returns the number of samples above Highest.
+func (h *Histogram) Overflow() int64 {
+	return h.overflow
+}
+
+// Highest returns the largest value tracked in a bucket.
+func (h *Histogram) Highest() time.Duration {
+	return time.Duration(h.highest)
+}
+
+// Minimum returns the smallest recorded sample, or 0 when empty.
+func (h *Histogram) Minimum() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.min)
+}
+
+// Maximum returns the largest recorded sample, or 0 when empty.
+func (h *Histogram) Maximum() time.Duration {
+	return time.Duration(h.max)
+}
+
+// Cumulative returns the sum of all recorded samples.
+func (h *Histogram) Cumulative() time.Duration {
+	return time.Duration(h.sum)
+}
+
+// Mean returns the exact arithmetic mean of the recorded samples.
+func (h *Histogram) Mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return time.Duration(h.sum / h.total)
+}
+
+// Percentile returns the value at or below which the given percentage of
+// samples fall, reported as the highest value equivalent to its bucket and
+// capped to the observed maximum. A percentile among the overflow is the
+// maximum.
+func (h *Histogram) Percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	if p > 100 {
+		p = 100
+	}
+	target := int64(math.Ceil(p / 100 * float64(h.total)))
+	if target < 1 {
+		target = 1
+	}
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= target {
+			v := h.highestEquivalentValue(h.valueFromIndex(i))
+			if v > h.max {
+				v = h.max
+			}
+			if v < h.min {
+				v = h.min
+			}
+			return time.Duration(v)
+		}
+	}
+	return time.Duration(h.max)
+}
+
+func (h *Histogram) bucketIndex(v int64) int {
+	pow2Ceiling := 64 - bits.LeadingZeros64(uint64(v|h.subBucketMask))
+	return pow2Ceiling - int(h.unitMagnitude) - int(h.subBucketHalfCountMagnitude+1)
+}
+
+func (h *Histogram) subBucketIndex(v int64, bucketIdx int) int64 {
+	return v >> uint(bucketIdx+int(h.unitMagnitude))
+}
+
+func (h *Histogram) countsIndex(v int64) int {
+	bucketIdx := h.bucketIndex(v)
+	subBucketIdx := h.subBucketIndex(v, bucketIdx)
+	bucketBaseIdx := int64(bucketIdx+1) << h.subBucketHalfCountMagnitude
+	return int(bucketBaseIdx + subBucketIdx - h.subBucketHalfCount)
+}
+
+func (h *Histogram) valueFromIndex(idx int) int64 {
+	bucketIdx := (idx >> h.subBucketHalfCountMagnitude) - 1
+	subBucketIdx := int64(idx)&(h.subBucketHalfCount-1) + h.subBucketHalfCount
+	if bucketIdx < 0 {
+		subBucketIdx -= h.subBucketHalfCount
+		bucketIdx = 0
+	}
+	return subBucketIdx << uint(bucketIdx+int(h.unitMagnitude))
+}
+
+func (h *Histogram) sizeOfEquivalentValueRange(v int64) int64 {
+	bucketIdx := h.bucketIndex(v)
+	subBucketIdx := h.subBucketIndex(v, bucketIdx)
+	adjusted := bucketIdx
+	if subBucketIdx >= h.subBucketCount {
+		adjusted++
+	}
+	return int64(1) << uint(int(h.unitMagnitude)+adjusted)
+}
+
+func (h *Histogram) lowestEquivalentValue(v int64) int64 {
+	bucketIdx := h.bucketIndex(v)
+	subBucketIdx := h.subBucketIndex(v, bucketIdx)
+	return subBucketIdx << uint(bucketIdx+int(h.unitMagnitude))
+}
+
+func (h *Histogram) highestEquivalentValue(v int64) int64 {
+	return h.lowestEquivalentValue(v) + h.sizeOfEquivalentValueRange(v) - 1
+}
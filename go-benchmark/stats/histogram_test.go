@@ -0,0 +1,233 @@
+package stats
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestHistogramPercentiles(t *testing.T) {
+	h := NewHistogram()
+	for i := 1; i <= 10000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 5000 * time.Microsecond},
+		{90, 9000 * time.Microsecond},
+		{99, 9900 * time.Microsecond},
+		{99.9, 9990 * time.Microsecond},
+		{100, 10000 * time.Microsecond},
+	}
+	for _, tt := range tests {
+		got := h.Percentile(tt.p)
+		// Two significant digits bound the relative error to 1%.
+		if diff := float64(got-tt.want) / float64(tt.want); diff < -0.01 || diff > 0.01 {
+			t.Errorf("p%v = %v, want %v (±1%%)", tt.p, got, tt.want)
+		}
+	}
+	if h.Count() != 10000 {
+		t.Errorf("count = %d, want 10000", h.Count())
+	}
+	if h.Minimum() != time.Microsecond || h.Maximum() != 10*time.Millisecond {
+		t.Errorf("min/max = %v/%v", h.Minimum(), h.Maximum())
+	}
+}
+
+func TestHistogramSubMicrosecond(t *testing.T) {
+	h := NewHistogram()
+	h.Record(250 * time.Nanosecond)
+	h.Record(750 * time.Nanosecond)
+	if got := h.Percentile(50); got != 250*time.Nanosecond {
+		t.Errorf("p50 = %v, want 250ns", got)
+	}
+	if got := h.Mean(); got != 500*time.Nanosecond {
+		t.Errorf("mean = %v, want 500ns", got)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b := NewHistogram(), NewHistogram()
+	a.Record(time.Millisecond)
+	b.Record(3 * time.Millisecond)
+	a.Merge(b)
+	if a.Count() != 2 || a.Mean() != 2*time.Millisecond {
+		t.Errorf("merged count/mean = %d/%v", a.Count(), a.Mean())
+	}
+	if a.Maximum() != 3*time.Millisecond {
+		t.Errorf("merged max = %v", a.Maximum())
+	}
+}
+
+func TestHistogramEmpty(t *testing.T) {
+	h := NewHistogram()
+	if h.Percentile(99) != 0 || h.Mean() != 0 || h.Minimum() != 0 {
+		t.Error("empty histogram should report zero values")
+	}
+}
+
+func TestHistogramEncode(t *testing.T) {
+	h := NewHistogram()
+	for _, d := range []time.Duration{3 * time.Microsecond, 250 * time.Microsecond, 2 * time.Millisecond, 2 * time.Minute} {
+		h.Record(d)
+	}
+	b, err := json.Marshal(Encode(h))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e Encoded
+	if err := json.Unmarshal(b, &e); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Decode(&e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Count() != h.Count() || got.Mean() != h.Mean() || got.Minimum() != h.Minimum() || got.Maximum() != h.Maximum() ||
+		got.Percentile(50) != h.Percentile(50) || got.Percentile(99) != h.Percentile(99) {
+		t.Errorf("decoded count/mean/p99 %d/%v/%v, want %d/%v/%v", got.Count(), got.Mean(), got.Percentile(99), h.Count(), h.Mean(), h.Percentile(99))
+	}
+	if _, err := Decode(&Encoded{Counts: [][2]int64{{1 << 40, 1}}}); err == nil {
+		t.Error("out of range index decoded")
+	}
+}
+
+func TestHistogramOverflow(t *testing.T) {
+	h := NewHistogramRange(1, int64(time.Second), 2)
+	h.Record(time.Millisecond)
+	h.Record(2 * time.Second)
+	h.Record(3 * time.Second)
+	if h.Count() != 3 || h.Overflow() != 2 {
+		t.Fatalf("count %d, overflow %d", h.Count(), h.Overflow())
+	}
+	if got := h.Percentile(50); got != 3*time.Second {
+		t.Errorf("p50 among the overflow = %v, want the maximum", got)
+	}
+	if got := h.Percentile(10); got > 2*time.Millisecond {
+		t.Errorf("p10 = %v", got)
+	}
+}
+
+func TestHistogramLayout(t *testing.T) {
+	for _, l := range []Layout{{Highest: time.Nanosecond, Growth: 1.01}, {Highest: time.Second, Growth: 1}, {Highest: time.Second, Growth: 2}} {
+		if err := l.Validate(); err == nil {
+			t.Errorf("%+v accepted", l)
+		}
+	}
+	l := Layout{Highest: 10 * time.Second, Growth: 1.001}
+	if err := l.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	fine := l.New()
+	for i := 1; i <= 1000; i++ {
+		fine.Record(time.Duration(1000+i) * time.Microsecond)
+	}
+	fine.Record(time.Minute)
+	if fine.Highest() != 10*time.Second || fine.Overflow() != 1 {
+		t.Errorf("highest %v, overflow %d", fine.Highest(), fine.Overflow())
+	}
+	if got, want := fine.Percentile(50), 1500*time.Microsecond; got < want || float64(got-want)/float64(want) > 0.001 {
+		t.Errorf("p50 = %v, want %v within 0.1%%", got, want)
+	}
+
+	// A histogram of the default layout takes in the fine one, its
+	// overflow included.
+	coarse := NewHistogramRange(1, int64(time.Minute)*2, 2)
+	coarse.Merge(fine)
+	if coarse.Count() != 1001 || coarse.Overflow() != 0 || coarse.Percentile(100) != time.Minute {
+		t.Errorf("merged count %d, overflow %d, max %v", coarse.Count(), coarse.Overflow(), coarse.Percentile(100))
+	}
+	if got := coarse.Percentile(50); got < 1490*time.Microsecond || got > 1520*time.Microsecond {
+		t.Errorf("merged p50 = %v", got)
+	}
+
+	got, err := Decode(Encode(fine))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Highest() != fine.Highest() || got.Overflow() != 1 || got.Percentile(50) != fine.Percentile(50) {
+		t.Errorf("decoded highest %v, overflow %d, p50 %v", got.Highest(), got.Overflow(), got.Percentile(50))
+	}
+}
+
+// decodeHdr reads the compressed V2 encoding back the way HdrHistogram
+// does, returning its significant digits, highest value and counts.
+func decodeHdr(t *testing.T, b []byte) (int, int64, []int64) {
+	t.Helper()
+	if len(b) < 8 || binary.BigEndian.Uint32(b) != hdrCompressedCookie || int(binary.BigEndian.Uint32(b[4:])) != len(b)-8 {
+		t.Fatalf("bad compressed envelope % x", b[:min(len(b), 8)])
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(b[8:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binary.BigEndian.Uint32(raw) != hdrEncodingCookie || int(binary.BigEndian.Uint32(raw[4:])) != len(raw)-hdrHeaderSize {
+		t.Fatalf("bad header % x", raw[:hdrHeaderSize])
+	}
+	if lowest := binary.BigEndian.Uint64(raw[16:]); lowest != 1 {
+		t.Errorf("lowest %d", lowest)
+	}
+	var counts []int64
+	for r := bytes.NewReader(raw[hdrHeaderSize:]); r.Len() > 0; {
+		c, err := binary.ReadVarint(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c < 0 {
+			counts = append(counts, make([]int64, -c)...)
+		} else {
+			counts = append(counts, c)
+		}
+	}
+	return int(binary.BigEndian.Uint32(raw[12:])), int64(binary.BigEndian.Uint64(raw[24:])), counts
+}
+
+func TestEncodeHdr(t *testing.T) {
+	h := NewHistogram()
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	sigFigs, highest, counts := decodeHdr(t, EncodeHdr(h))
+	if sigFigs != 2 || highest != int64(time.Minute) {
+		t.Errorf("sig figs %d, highest %d", sigFigs, highest)
+	}
+	var total int64
+	for i, c := range counts {
+		if c != h.counts[i] {
+			t.Fatalf("count %d = %d, want %d", i, c, h.counts[i])
+		}
+		total += c
+	}
+	if total != 1000 || len(counts) != h.countsIndex(h.max)+1 {
+		t.Errorf("%d samples in %d counts", total, len(counts))
+	}
+
+	// A layout without significant digits, and its overflow, go to the
+	// next finer one tracking up to the maximum.
+	over := newHistogram(1, int64(time.Second), 5)
+	over.Record(time.Millisecond)
+	over.Record(3 * time.Second)
+	sigFigs, highest, counts = decodeHdr(t, EncodeHdr(over))
+	if sigFigs != 2 || highest != int64(3*time.Second) {
+		t.Errorf("sig figs %d, highest %d", sigFigs, highest)
+	}
+	e := newHistogram(1, highest, 7)
+	if len(counts) != e.countsIndex(int64(3*time.Second))+1 || counts[len(counts)-1] != 1 {
+		t.Errorf("overflow not counted at the maximum: %d counts", len(counts))
+	}
+
+	if _, _, counts := decodeHdr(t, EncodeHdr(NewHistogram())); len(counts) != 0 {
+		t.Errorf("empty histogram encoded %d counts", len(counts))
+	}
+}
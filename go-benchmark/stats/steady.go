@@ -0,0 +1,73 @@
+package stats
+
+import "math"
+
+// RelativeStddev returns the population standard deviation of xs over their
+// mean, +Inf when the mean is zero or xs is empty.
+func RelativeStddev(xs []float64) float64 {
+	if len(xs) == 0 {
+		return math.Inf(1)
+	}
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	mean := sum / float64(len(xs))
+	if mean == 0 {
+		return math.Inf(1)
+	}
+	var sq float64
+	for _, x := range xs {
+		sq += (x - mean) * (x - mean)
+	}
+	return math.Sqrt(sq/float64(len(xs))) / math.Abs(mean)
+}
+
+// SteadyDetector decides when a run has settled: it takes one throughput
+// and one p99 sample per interval, and holds both steady once the relative
+// standard deviation of each over the last Window samples is within
+// Tolerance.
+type SteadyDetector struct {
+	Window    int
+	Tolerance float64
+
+	throughput, p99 []float64
+}
+
+// NewSteadyDetector returns a detector over window intervals.
+func NewSteadyDetector(window int, tolerance float64) *SteadyDetector {
+	return &SteadyDetector{Window: window, Tolerance: tolerance}
+}
+
+// Add records the samples of the next interval and reports whether the run
+// is now steady.
+func (d *SteadyDetector) Add(throughput, p99 float64) bool {
+	d.throughput = appendWindow(d.throughput, throughput, d.Window)
+	d.p99 = appendWindow(d.p99, p99, d.Window)
+	return d.Steady()
+}
+
+// Steady reports whether the last Window samples of both series are within
+// Tolerance.
+func (d *SteadyDetector) Steady() bool {
+	if len(d.throughput) < d.Window {
+		return false
+	}
+	tput, p99 := d.Spread()
+	return tput <= d.Tolerance && p99 <= d.Tolerance
+}
+
+// Spread returns the relative standard deviations of the throughput and
+// p99 samples in the window.
+func (d *SteadyDetector) Spread() (throughput, p99 float64) {
+	return RelativeStddev(d.throughput), RelativeStddev(d.p99)
+}
+
+// appendWindow appends x to xs, keeping the last n values.
+func appendWindow(xs []float64, x float64, n int) []float64 {
+	xs = append(xs, x)
+	if len(xs) > n {
+		xs = xs[len(xs)-n:]
+	}
+	return xs
+}
@@ -0,0 +1,77 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRelativeStddev(t *testing.T) {
+	for _, c := range []struct {
+		xs   []float64
+		want float64
+	}{
+		{[]float64{5, 5, 5}, 0},
+		{[]float64{9, 11}, 0.1},
+		{[]float64{2, 4, 4, 4, 5, 5, 7, 9}, 0.4},
+	} {
+		if got := RelativeStddev(c.xs); math.Abs(got-c.want) > 1e-9 {
+			t.Errorf("RelativeStddev(%v) = %v, want %v", c.xs, got, c.want)
+		}
+	}
+	for _, xs := range [][]float64{nil, {0, 0, 0}} {
+		if got := RelativeStddev(xs); !math.IsInf(got, 1) {
+			t.Errorf("RelativeStddev(%v) = %v, want +Inf", xs, got)
+		}
+	}
+}
+
+// steadyAt feeds the series to a detector and returns the index of the
+// first sample at which it holds the run steady, -1 if never.
+func steadyAt(d *SteadyDetector, tput, p99 []float64) int {
+	for i := range tput {
+		if d.Add(tput[i], p99[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestSteadyDetector(t *testing.T) {
+	flat := func(n int, v float64) []float64 {
+		xs := make([]float64, n)
+		for i := range xs {
+			xs[i] = v
+		}
+		return xs
+	}
+	// A warming pool: throughput climbs, then holds.
+	tput := append([]float64{1000, 4000, 7000, 9000, 9800}, flat(10, 10000)...)
+	p99 := append([]float64{9, 6, 4, 3, 2.1}, flat(10, 2)...)
+	if got := steadyAt(NewSteadyDetector(3, 0.05), tput, p99); got != 6 {
+		t.Errorf("climbing series steady at %d, want 6", got)
+	}
+
+	// Noise within the tolerance is steady as soon as the window fills.
+	noisy := []float64{100, 102, 98, 101, 99, 100}
+	if got := steadyAt(NewSteadyDetector(4, 0.05), noisy, noisy); got != 3 {
+		t.Errorf("noisy series steady at %d, want 3", got)
+	}
+
+	// Steady throughput is not enough while p99 keeps swinging.
+	swing := []float64{2, 8, 2, 8, 2, 8, 2, 8}
+	if got := steadyAt(NewSteadyDetector(3, 0.05), flat(8, 500), swing); got != -1 {
+		t.Errorf("swinging p99 steady at %d", got)
+	}
+
+	// A stalled run does no work: never steady.
+	if got := steadyAt(NewSteadyDetector(3, 0.05), flat(6, 0), flat(6, 0)); got != -1 {
+		t.Errorf("stalled series steady at %d", got)
+	}
+
+	d := NewSteadyDetector(2, 0.05)
+	d.Add(100, 1)
+	d.Add(50, 1)
+	if tp, p := d.Spread(); math.Abs(tp-1.0/3) > 1e-9 || p != 0 || d.Steady() {
+		t.Errorf("spread %v, %v", tp, p)
+	}
+}
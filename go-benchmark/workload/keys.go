@@ -0,0 +1,112 @@
+package workload
+
+import "math/rand"
+
+// KeyChooser yields logical key indexes in [0, keyspace). Each worker owns its
+// own chooser, so implementations need no synchronisation.
+type KeyChooser interface {
+	Next() int
+}
+
+// UniformKeys draws every index with the same probability.
+type UniformKeys struct {
+	N   int
+	Rng *rand.Rand
+}
+
+func (u *UniformKeys) Next() int {
+	return u.Rng.Intn(u.N)
+}
+
+// SequentialKeys walks the indexes in order from Cur, wrapping at N.
+type SequentialKeys struct {
+	N   int
+	Cur int
+}
+
+func (s *SequentialKeys) Next() int {
+	v := s.Cur
+	s.Cur++
+	if s.Cur >= s.N {
+		s.Cur = 0
+	}
+	return v
+}
+
+// ZipfianKeys draws the indexes from a shared Zipfian, index 0 the hottest.
+type ZipfianKeys struct {
+	Z   *Zipfian
+	Rng *rand.Rand
+}
+
+func (z *ZipfianKeys) Next() int {
+	return z.Z.Next(z.Rng)
+}
+
+// hotPoolSalt separates the hot pool's generator from the workers', which
+// are seeded with seed + clientID.
+const hotPoolSalt = 0x686f74
+
+// NewHotPool picks n distinct key indexes of [0, keyspace) from the run's
+// seed. Every worker shares the result, so all of them contend on the same
+// keys.
+func NewHotPool(n, keyspace int, seed int64) []int {
+	rng := rand.New(rand.NewSource(seed ^ hotPoolSalt))
+	seen := make(map[int]bool, n)
+	pool := make([]int, 0, n)
+	for len(pool) < n {
+		if i := rng.Intn(keyspace); !seen[i] {
+			seen[i] = true
+			pool = append(pool, i)
+		}
+	}
+	return pool
+}
+
+// StridedKeys walks the indexes from Start in steps of Stride, back to Start
+// once past N. Workers striding by their number from their own offsets
+// between them visit every index of [0, N) in order, once per pass.
+type StridedKeys struct {
+	N      int
+	Start  int
+	Stride int
+	Cur    int
+}
+
+func (s *StridedKeys) Next() int {
+	v := s.Cur
+	s.Cur += s.Stride
+	if s.Cur >= s.N {
+		s.Cur = s.Start
+	}
+	return v
+}
+
+// ReuseWindowKeys re-references a key of the last Window fresh keys with
+// probability Reref and otherwise draws a fresh key uniformly from [0, N):
+// recently used keys stay hot while the window slides on.
+type ReuseWindowKeys struct {
+	N      int
+	Window int
+	Reref  float64
+	Rng    *rand.Rand
+
+	// recent is a ring of the fresh keys, next the slot of the following
+	// one.
+	recent []int
+	next   int
+}
+
+func (r *ReuseWindowKeys) Next() int {
+	if len(r.recent) > 0 && r.Rng.Float64() < r.Reref {
+		return r.recent[r.Rng.Intn(len(r.recent))]
+	}
+	v := r.Rng.Intn(r.N)
+	if len(r.recent) < r.Window {
+		r.recent = append(r.recent, v)
+	} else {
+		r.recent[r.next] = v
+		r.next = (r.next + 1) % r.Window
+	}
+	return v
+}
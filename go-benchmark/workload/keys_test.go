@@ -0,0 +1,55 @@
+package workload
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestStridedKeys(t *testing.T) {
+	// Three workers striding over 10 keys visit each once per pass.
+	walkers := make([]*StridedKeys, 3)
+	for i := range walkers {
+		walkers[i] = &StridedKeys{N: 10, Start: i, Stride: 3, Cur: i}
+	}
+	for pass := 0; pass < 2; pass++ {
+		seen := make(map[int]int)
+		for i, w := range walkers {
+			// Worker i owns the keys i, i+3 and so on below 10.
+			for n := 0; n < (10-i+2)/3; n++ {
+				seen[w.Next()]++
+			}
+		}
+		if len(seen) != 10 {
+			t.Fatalf("pass %d visited %d of 10 keys: %v", pass, len(seen), seen)
+		}
+		for k, c := range seen {
+			if c != 1 {
+				t.Errorf("pass %d visited key %d %d times", pass, k, c)
+			}
+		}
+	}
+}
+
+func TestReuseWindowKeys(t *testing.T) {
+	r := &ReuseWindowKeys{N: 1 << 30, Window: 100, Reref: 0.8, Rng: rand.New(rand.NewSource(1))}
+	const samples = 100000
+	seen := make(map[int]bool)
+	fresh := 0
+	for i := 0; i < samples; i++ {
+		v := r.Next()
+		if v < 0 || v >= r.N {
+			t.Fatalf("key %d out of range", v)
+		}
+		if !seen[v] {
+			fresh++
+			seen[v] = true
+		}
+	}
+	// A fifth of the keys are fresh, the rest re-referenced.
+	if fresh < samples*18/100 || fresh > samples*22/100 {
+		t.Errorf("%d of %d keys fresh, want about 20%%", fresh, samples)
+	}
+	if len(r.recent) != r.Window {
+		t.Errorf("window of %d keys, want %d", len(r.recent), r.Window)
+	}
+}
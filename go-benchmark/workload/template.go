@@ -0,0 +1,240 @@
+package workload
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// A Template generates keys or values shaped like an application's, such
+// as user:{seq}:profile. Its text is literal but for placeholders:
+//
+//	{seq}            the sequence number of the key or value
+//	{worker}         the client generating it
+//	{rand:uuid}      a random version 4 UUID
+//	{rand:int:A-B}   a random integer in [A, B]
+//	{pad:N}          N filler bytes
+//
+// A placeholder opens with a brace followed by a lowercase letter; any
+// other brace, as those of a JSON document, is literal, and {{ writes a
+// single one. The random placeholders draw from a generator seeded by the
+// caller, so the same seed and numbers give the same text.
+type Template struct {
+	segs []segment
+}
+
+type segKind int
+
+const (
+	segLiteral segKind = iota
+	segSeq
+	segWorker
+	segUUID
+	segInt
+	segPad
+)
+
+// segment is a literal or placeholder of a Template.
+type segment struct {
+	kind   segKind
+	lit    string
+	lo, hi int64
+	n      int
+}
+
+// TemplatePadByte fills {pad:N}.
+const TemplatePadByte = 'x'
+
+// ParseTemplate compiles s.
+func ParseTemplate(s string) (*Template, error) {
+	t := &Template{}
+	var lit strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '{' {
+			lit.WriteByte(c)
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '{' {
+			lit.WriteByte('{')
+			i++
+			continue
+		}
+		if i+1 == len(s) || s[i+1] < 'a' || s[i+1] > 'z' {
+			lit.WriteByte('{')
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("template %q: unterminated placeholder at byte %d", s, i)
+		}
+		seg, err := parsePlaceholder(s[i+1 : i+end])
+		if err != nil {
+			return nil, fmt.Errorf("template %q: %v", s, err)
+		}
+		if lit.Len() > 0 {
+			t.segs = append(t.segs, segment{kind: segLiteral, lit: lit.String()})
+			lit.Reset()
+		}
+		t.segs = append(t.segs, seg)
+		i += end
+	}
+	if lit.Len() > 0 {
+		t.segs = append(t.segs, segment{kind: segLiteral, lit: lit.String()})
+	}
+	if len(t.segs) == 0 {
+		return nil, fmt.Errorf("template %q is empty", s)
+	}
+	return t, nil
+}
+
+// parsePlaceholder parses the text between the braces of a placeholder.
+func parsePlaceholder(p string) (segment, error) {
+	name, arg, _ := strings.Cut(p, ":")
+	switch {
+	case p == "seq":
+		return segment{kind: segSeq}, nil
+	case p == "worker":
+		return segment{kind: segWorker}, nil
+	case p == "rand:uuid":
+		return segment{kind: segUUID}, nil
+	case strings.HasPrefix(p, "rand:int:"):
+		lo, hi, ok := strings.Cut(strings.TrimPrefix(p, "rand:int:"), "-")
+		a, errA := strconv.ParseInt(lo, 10, 64)
+		b, errB := strconv.ParseInt(hi, 10, 64)
+		if !ok || errA != nil || errB != nil || a < 0 || b < a {
+			return segment{}, fmt.Errorf("{%s}: want {rand:int:A-B} with 0 <= A <= B", p)
+		}
+		return segment{kind: segInt, lo: a, hi: b}, nil
+	case name == "pad":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return segment{}, fmt.Errorf("{%s}: want {pad:N} with N > 0", p)
+		}
+		return segment{kind: segPad, n: n}, nil
+	}
+	return segment{}, fmt.Errorf("unknown placeholder {%s}", p)
+}
+
+// Append appends the text of t for sequence number seq and worker to dst,
+// drawing the random placeholders from seed.
+func (t *Template) Append(dst []byte, seq int64, worker int, seed uint64) []byte {
+	// The numbers go through the mixer so neighbouring ones draw unrelated
+	// streams.
+	state := seed
+	state = splitmix(&state) ^ uint64(seq)
+	state = splitmix(&state) ^ uint64(worker)
+	for _, s := range t.segs {
+		switch s.kind {
+		case segLiteral:
+			dst = append(dst, s.lit...)
+		case segSeq:
+			dst = strconv.AppendInt(dst, seq, 10)
+		case segWorker:
+			dst = strconv.AppendInt(dst, int64(worker), 10)
+		case segUUID:
+			var u [16]byte
+			for i := 0; i < 16; i += 8 {
+				r := splitmix(&state)
+				for j := 0; j < 8; j++ {
+					u[i+j] = byte(r >> (8 * j))
+				}
+			}
+			u[6] = u[6]&0x0f | 0x40
+			u[8] = u[8]&0x3f | 0x80
+			var buf [36]byte
+			hex.Encode(buf[0:8], u[0:4])
+			buf[8] = '-'
+			hex.Encode(buf[9:13], u[4:6])
+			buf[13] = '-'
+			hex.Encode(buf[14:18], u[6:8])
+			buf[18] = '-'
+			hex.Encode(buf[19:23], u[8:10])
+			buf[23] = '-'
+			hex.Encode(buf[24:], u[10:])
+			dst = append(dst, buf[:]...)
+		case segInt:
+			n, _ := bits.Mul64(splitmix(&state), uint64(s.hi-s.lo)+1)
+			dst = strconv.AppendInt(dst, s.lo+int64(n), 10)
+		case segPad:
+			for i := 0; i < s.n; i++ {
+				dst = append(dst, TemplatePadByte)
+			}
+		}
+	}
+	return dst
+}
+
+// splitmix advances state and returns its next random number.
+func splitmix(state *uint64) uint64 {
+	*state += 0x9e3779b97f4a7c15
+	x := *state
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// UsesSeq and UsesWorker report whether t holds {seq} and {worker}.
+func (t *Template) UsesSeq() bool    { return t.uses(segSeq) }
+func (t *Template) UsesWorker() bool { return t.uses(segWorker) }
+
+func (t *Template) uses(kind segKind) bool {
+	for _, s := range t.segs {
+		if s.kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Choices returns how many texts the random placeholders of t can give one
+// sequence number and worker, false when more than fit a uint64.
+func (t *Template) Choices() (uint64, bool) {
+	n := uint64(1)
+	for _, s := range t.segs {
+		var c uint64
+		switch s.kind {
+		case segUUID:
+			return 0, false
+		case segInt:
+			if s.hi-s.lo == math.MaxInt64 {
+				return 0, false
+			}
+			c = uint64(s.hi-s.lo) + 1
+		default:
+			continue
+		}
+		hi, lo := bits.Mul64(n, c)
+		if hi != 0 {
+			return 0, false
+		}
+		n = lo
+	}
+	return n, true
+}
+
+// MaxLen returns the length of the longest text of t, taking seq and worker
+// of up to maxSeq and maxWorker.
+func (t *Template) MaxLen(maxSeq int64, maxWorker int) int {
+	n := 0
+	for _, s := range t.segs {
+		switch s.kind {
+		case segLiteral:
+			n += len(s.lit)
+		case segSeq:
+			n += len(strconv.FormatInt(maxSeq, 10))
+		case segWorker:
+			n += len(strconv.Itoa(maxWorker))
+		case segUUID:
+			n += 36
+		case segInt:
+			n += len(strconv.FormatInt(s.hi, 10))
+		case segPad:
+			n += s.n
+		}
+	}
+	return n
+}
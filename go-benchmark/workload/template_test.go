@@ -0,0 +1,85 @@
+package workload
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTemplateText(t *testing.T) {
+	tpl, err := ParseTemplate(`user:{seq}:{worker}:{{x}:{"a":1}:{pad:3}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(tpl.Append([]byte("p:"), 42, 7, 1)); got != `p:user:42:7:{x}:{"a":1}:xxx` {
+		t.Errorf("text %q", got)
+	}
+	if !tpl.UsesSeq() || !tpl.UsesWorker() {
+		t.Error("placeholders not reported")
+	}
+}
+
+func TestTemplateRandom(t *testing.T) {
+	tpl, err := ParseTemplate("sess:{rand:uuid}:{rand:int:5-9}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uuid := regexp.MustCompile(`^sess:[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}:([0-9]+)$`)
+	seen := make(map[string]bool)
+	for seq := int64(0); seq < 1000; seq++ {
+		text := string(tpl.Append(nil, seq, 0, 1))
+		m := uuid.FindStringSubmatch(text)
+		if m == nil {
+			t.Fatalf("text %q", text)
+		}
+		if n, _ := strconv.Atoi(m[1]); n < 5 || n > 9 {
+			t.Errorf("int %d outside [5, 9]", n)
+		}
+		seen[text] = true
+		// The same seed and numbers give the same text.
+		if again := string(tpl.Append(nil, seq, 0, 1)); again != text {
+			t.Fatalf("%q then %q", text, again)
+		}
+	}
+	if len(seen) != 1000 {
+		t.Errorf("%d distinct texts of 1000", len(seen))
+	}
+	if string(tpl.Append(nil, 0, 0, 1)) == string(tpl.Append(nil, 0, 0, 2)) {
+		t.Error("the seed does not change the text")
+	}
+	if _, ok := tpl.Choices(); ok {
+		t.Error("a UUID has countable choices")
+	}
+}
+
+func TestTemplateChoices(t *testing.T) {
+	tpl, _ := ParseTemplate("k{rand:int:1-10}-{rand:int:0-4}")
+	if n, ok := tpl.Choices(); !ok || n != 50 {
+		t.Errorf("Choices = %d, %v", n, ok)
+	}
+	if n := tpl.MaxLen(0, 0); n != len("k10-4") {
+		t.Errorf("MaxLen = %d", n)
+	}
+}
+
+func TestParseTemplateErrors(t *testing.T) {
+	for _, bad := range []string{
+		"",
+		"user:{seq",
+		"{nope}",
+		"{rand:int:5-1}",
+		"{rand:int:a-b}",
+		"{rand:int:-1-5}",
+		"{rand:int:7}",
+		"{rand:hex}",
+		"{pad:0}",
+		"{pad:x}",
+	} {
+		if _, err := ParseTemplate(bad); err == nil {
+			t.Errorf("ParseTemplate(%q) succeeded, want error", bad)
+		} else if !strings.Contains(err.Error(), "template") {
+			t.Errorf("ParseTemplate(%q): %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package workload
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// ValuePoolSlack is how many bytes the pool holds beyond the largest value, so
+// consecutive values start at different offsets and are not all identical.
+const ValuePoolSlack = 1 << 20
+
+// ValuePool hands out random payloads as sub-slices of one pre-allocated
+// buffer so generating a value never allocates on the hot path. The buffer is
+// read-only after construction and shared by all workers.
+type ValuePool struct {
+	data []byte
+	min  int
+	max  int
+}
+
+// NewValuePool fills a buffer with random bytes covering the whole 0x00-0xFF
+// range so payloads are binary and exercise the server's framing.
+func NewValuePool(min, max int, rng *rand.Rand) *ValuePool {
+	data := make([]byte, max+ValuePoolSlack)
+	rng.Read(data)
+	// Guarantee every byte value appears at least once regardless of the RNG.
+	for i := 0; i < 256 && i < len(data); i++ {
+		data[i] = byte(i)
+	}
+	return &ValuePool{data: data, min: min, max: max}
+}
+
+// Next returns a payload whose size is drawn uniformly from [min, max]. The
+// returned slice aliases the pool and must not be modified.
+func (p *ValuePool) Next(rng *rand.Rand) []byte {
+	size := p.min
+	if p.max > p.min {
+		size += rng.Intn(p.max - p.min + 1)
+	}
+	off := rng.Intn(len(p.data) - size + 1)
+	return p.data[off : off+size]
+}
+
+// Min and Max return the bounds of the sizes p hands out.
+func (p *ValuePool) Min() int { return p.min }
+func (p *ValuePool) Max() int { return p.max }
+
+// Bytes returns the whole buffer of the pool, payloads of any size being
+// sub-slices of it. It must not be modified.
+func (p *ValuePool) Bytes() []byte { return p.data }
+
+// Offset returns where v, a payload handed out by p, starts in the pool.
+func (p *ValuePool) Offset(v []byte) int {
+	return cap(p.data) - cap(v)
+}
+
+// Sized returns a pool handing out sizes in [min, max] from the same buffer,
+// so steps of a value size sweep share one allocation. max must not exceed
+// the largest size p was built for.
+func (p *ValuePool) Sized(min, max int) *ValuePool {
+	return &ValuePool{data: p.data, min: min, max: max}
+}
+
+// ParseSizeRange parses "min:max" into its bounds.
+func ParseSizeRange(s string) (int, int, error) {
+	lo, hi, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid size range %q: want min:max", s)
+	}
+	min, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid size range %q: %v", s, err)
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(hi))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid size range %q: %v", s, err)
+	}
+	if min < 0 || max < min {
+		return 0, 0, fmt.Errorf("invalid size range %q: want 0 <= min <= max", s)
+	}
+	return min, max, nil
+}
@@ -0,0 +1,42 @@
+package workload
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestValuePoolSizes(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	p := NewValuePool(10, 20, rng)
+	for i := 0; i < 1000; i++ {
+		v := p.Next(rng)
+		if len(v) < 10 || len(v) > 20 {
+			t.Fatalf("value size %d outside [10, 20]", len(v))
+		}
+	}
+}
+
+func TestValuePoolBinarySafe(t *testing.T) {
+	p := NewValuePool(64, 64, rand.New(rand.NewSource(1)))
+	var seen [256]bool
+	for _, b := range p.data {
+		seen[b] = true
+	}
+	for i, ok := range seen {
+		if !ok {
+			t.Fatalf("byte 0x%02x never appears in the pool", i)
+		}
+	}
+}
+
+func TestParseSizeRange(t *testing.T) {
+	min, max, err := ParseSizeRange("64:1024")
+	if err != nil || min != 64 || max != 1024 {
+		t.Errorf("ParseSizeRange = %d, %d, %v", min, max, err)
+	}
+	for _, bad := range []string{"64", "a:1", "1:b", "10:5", "-1:5"} {
+		if _, _, err := ParseSizeRange(bad); err == nil {
+			t.Errorf("ParseSizeRange(%q) succeeded, want error", bad)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Package workload generates the keys and values a load run sends.
+package workload
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// Zipfian draws integers in [0, n) with probability proportional to
+// 1/(rank+1)^theta, using the rejection-free method of Gray et al. ("Quickly
+// generating billion-record synthetic databases") popularised by YCSB. Rank 0
+// is the most popular item. Unlike math/rand.Zipf it accepts 0 < theta < 1,
+// which covers the skews usually quoted for cache traffic (e.g. 0.99).
+//
+// The precomputed constants are read-only, so one Zipfian can be shared by all
+// workers as long as each passes its own *rand.Rand to Next.
+type Zipfian struct {
+	n     int
+	theta float64
+	alpha float64
+	zetan float64
+	eta   float64
+	half  float64
+}
+
+// NewZipfian precomputes the generator constants for n items. Building the
+// normalisation constant is O(n), so it is done once per run.
+func NewZipfian(n int, theta float64) (*Zipfian, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("zipfian needs at least one item, got %d", n)
+	}
+	if theta <= 0 || theta >= 1 {
+		return nil, fmt.Errorf("zipfian theta must be in (0, 1), got %v", theta)
+	}
+	zetan := zeta(n, theta)
+	z := &Zipfian{
+		n:     n,
+		theta: theta,
+		alpha: 1 / (1 - theta),
+		zetan: zetan,
+		half:  math.Pow(0.5, theta),
+	}
+	z.eta = (1 - math.Pow(2/float64(n), 1-theta)) / (1 - zeta(2, theta)/zetan)
+	return z, nil
+}
+
+func zeta(n int, theta float64) float64 {
+	var sum float64
+	for i := 1; i <= n; i++ {
+		sum += 1 / math.Pow(float64(i), theta)
+	}
+	return sum
+}
+
+// Next returns the next rank.
+func (z *Zipfian) Next(rng *rand.Rand) int {
+	u := rng.Float64()
+	uz := u * z.zetan
+	if uz < 1 {
+		return 0
+	}
+	if uz < 1+z.half {
+		return 1
+	}
+	v := int(float64(z.n) * math.Pow(z.eta*u-z.eta+1, z.alpha))
+	if v >= z.n {
+		v = z.n - 1
+	}
+	return v
+}
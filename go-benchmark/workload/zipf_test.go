@@ -0,0 +1,66 @@
+package workload
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestZipfianSkew(t *testing.T) {
+	const (
+		n       = 1000
+		theta   = 0.99
+		samples = 1000000
+	)
+	z, err := NewZipfian(n, theta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(42))
+	counts := make([]int, n)
+	for i := 0; i < samples; i++ {
+		v := z.Next(rng)
+		if v < 0 || v >= n {
+			t.Fatalf("sample %d out of range", v)
+		}
+		counts[v]++
+	}
+
+	// The two most popular ranks are generated exactly with probability
+	// p(k) = (1/(k+1)^theta) / zeta(n, theta); the tail is approximated.
+	for k := 0; k < 2; k++ {
+		want := samples / math.Pow(float64(k+1), theta) / z.zetan
+		got := float64(counts[k])
+		if math.Abs(got-want)/want > 0.05 {
+			t.Errorf("rank %d: got %v samples, want ~%.0f", k, got, want)
+		}
+	}
+
+	// Popularity must decrease with rank.
+	for k := 1; k < 10; k++ {
+		if counts[k] > counts[k-1] {
+			t.Errorf("rank %d drew more samples (%d) than rank %d (%d)", k, counts[k], k-1, counts[k-1])
+		}
+	}
+
+	// Heavy skew: the top 10% of keys must take well over half the traffic,
+	// where a uniform distribution would give them 10%.
+	var top int
+	for k := 0; k < n/10; k++ {
+		top += counts[k]
+	}
+	if share := float64(top) / samples; share < 0.6 {
+		t.Errorf("top 10%% of keys got %.2f of samples, want > 0.6", share)
+	}
+}
+
+func TestZipfianRejectsBadParameters(t *testing.T) {
+	for _, theta := range []float64{0, 1, 1.5, -0.1} {
+		if _, err := NewZipfian(10, theta); err == nil {
+			t.Errorf("NewZipfian(10, %v) succeeded, want error", theta)
+		}
+	}
+	if _, err := NewZipfian(0, 0.5); err == nil {
+		t.Error("NewZipfian(0, 0.5) succeeded, want error")
+	}
+}